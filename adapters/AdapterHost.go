@@ -0,0 +1,201 @@
+// Package adapters with hosting of device adapters that are loaded at runtime
+package adapters
+
+import (
+	"path/filepath"
+	"plugin"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// NewAdapterSymbol is the name of the function a Go plugin exports to create its adapter.
+// Its signature must be: func NewAdapter() adapters.IAdapter
+const NewAdapterSymbol = "NewAdapter"
+
+// IAdapter interface of device adapters hosted by the adapter host
+type IAdapter interface {
+	// Discover creates the nodes, inputs and outputs of the devices of this adapter
+	// Inputs are created with HostedAdapter.CreateInput so set commands are passed to SetInput.
+	Discover(hosted *HostedAdapter) error
+	// Poll updates the output values of the devices. Invoked each poll interval.
+	Poll(hosted *HostedAdapter) error
+	// SetInput sets the input of a device to the value received from a set command
+	SetInput(input *types.InputDiscoveryMessage, sender string, value string) error
+}
+
+// HostedAdapter is an adapter hosted in the publisher of the adapter host
+type HostedAdapter struct {
+	adapter IAdapter             // the hosted adapter
+	name    string               // name the adapter is hosted under
+	pub     *publisher.Publisher // publisher of the adapter's nodes, inputs and outputs
+}
+
+// CreateInput creates an input whose set commands are passed to the adapter's SetInput
+// If an input of the given nodeHWID, type and instance already exist it will be replaced.
+func (hosted *HostedAdapter) CreateInput(
+	nodeHWID string, inputType types.InputType, instance string) *types.InputDiscoveryMessage {
+
+	return hosted.pub.CreateInput(nodeHWID, inputType, instance, hosted.setInput)
+}
+
+// CreateInputWithTarget creates an input like CreateInput and describes where the adapter applies its value
+//  target is the device specific location the value is written to, eg a register or resource path
+func (hosted *HostedAdapter) CreateInputWithTarget(
+	nodeHWID string, inputType types.InputType, instance string, target string) *types.InputDiscoveryMessage {
+
+	input := hosted.CreateInput(nodeHWID, inputType, instance)
+	return hosted.pub.ModifyInput(input.InputID, func(input *types.InputDiscoveryMessage) {
+		if input.SourceInfo == nil {
+			input.SourceInfo = &types.InputSourceInfo{Kind: types.InputSourceSet}
+		}
+		input.SourceInfo.Description = hosted.name
+		input.SourceInfo.Target = target
+	})
+}
+
+// Name returns the name the adapter is hosted under
+func (hosted *HostedAdapter) Name() string {
+	return hosted.name
+}
+
+// Publisher returns the publisher for creating the adapter's nodes and outputs and updating output values
+func (hosted *HostedAdapter) Publisher() *publisher.Publisher {
+	return hosted.pub
+}
+
+// setInput passes a received set command to the adapter
+func (hosted *HostedAdapter) setInput(input *types.InputDiscoveryMessage, sender string, value string) {
+	err := hosted.adapter.SetInput(input, sender, value)
+	if err != nil {
+		logrus.Errorf("HostedAdapter.setInput: Adapter %s failed setting input %s: %s", hosted.name, input.Address, err)
+	}
+}
+
+// AdapterHost hosts multiple device adapters in a single publisher.
+// Intended for a generic publisher binary that hosts third-party adapters.
+type AdapterHost struct {
+	adapters    map[string]*HostedAdapter // hosted adapters by name
+	pub         *publisher.Publisher      // publisher of the hosted adapters
+	updateMutex *sync.Mutex               // mutex for async adding of adapters
+}
+
+// AddAdapter adds an adapter to the host and discovers its devices
+//  name is the unique name of the adapter
+// This returns an error if the name is already in use or discovery fails
+func (host *AdapterHost) AddAdapter(name string, adapter IAdapter) error {
+	host.updateMutex.Lock()
+	if _, exists := host.adapters[name]; exists {
+		host.updateMutex.Unlock()
+		return lib.MakeErrorf("AddAdapter: Adapter %s already exists", name)
+	}
+	hosted := &HostedAdapter{adapter: adapter, name: name, pub: host.pub}
+	host.adapters[name] = hosted
+	host.updateMutex.Unlock()
+
+	logrus.Infof("AdapterHost.AddAdapter: Added adapter %s", name)
+	err := adapter.Discover(hosted)
+	if err != nil {
+		return lib.MakeErrorf("AddAdapter: Discovery of adapter %s failed: %s", name, err)
+	}
+	return nil
+}
+
+// GetAdapterNames returns the sorted names of the hosted adapters
+func (host *AdapterHost) GetAdapterNames() []string {
+	host.updateMutex.Lock()
+	defer host.updateMutex.Unlock()
+	names := make([]string, 0, len(host.adapters))
+	for name := range host.adapters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadAdapter loads an adapter from a Go plugin and adds it to the host.
+// The plugin must export the NewAdapter function. The adapter is named after the file without extension.
+// WASM modules are not supported as no WASM runtime is included.
+//  path is the path to the plugin .so file
+func (host *AdapterHost) LoadAdapter(path string) error {
+	ext := filepath.Ext(path)
+	name := strings.TrimSuffix(filepath.Base(path), ext)
+	if ext == ".wasm" {
+		return lib.MakeErrorf("LoadAdapter: Adapter %s is a WASM module. WASM adapters are not supported", path)
+	}
+	plug, err := plugin.Open(path)
+	if err != nil {
+		return lib.MakeErrorf("LoadAdapter: Unable to load plugin %s: %s", path, err)
+	}
+	symbol, err := plug.Lookup(NewAdapterSymbol)
+	if err != nil {
+		return lib.MakeErrorf("LoadAdapter: Plugin %s doesn't export %s: %s", path, NewAdapterSymbol, err)
+	}
+	newAdapter, ok := symbol.(func() IAdapter)
+	if !ok {
+		return lib.MakeErrorf("LoadAdapter: %s of plugin %s has the wrong signature", NewAdapterSymbol, path)
+	}
+	return host.AddAdapter(name, newAdapter())
+}
+
+// LoadAdapters loads the adapters from the Go plugins in a folder
+// This returns the first error. Plugins that fail to load are skipped.
+//  folder containing the plugin .so files
+func (host *AdapterHost) LoadAdapters(folder string) error {
+	var firstErr error
+	paths, _ := filepath.Glob(filepath.Join(folder, "*.so"))
+	for _, path := range paths {
+		err := host.LoadAdapter(path)
+		if err != nil {
+			logrus.Errorf("AdapterHost.LoadAdapters: %s", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// PollAdapters polls the hosted adapters for updates of their output values
+// Errors are logged and don't affect polling of other adapters.
+func (host *AdapterHost) PollAdapters() {
+	host.updateMutex.Lock()
+	hostedList := make([]*HostedAdapter, 0, len(host.adapters))
+	for _, hosted := range host.adapters {
+		hostedList = append(hostedList, hosted)
+	}
+	host.updateMutex.Unlock()
+
+	for _, hosted := range hostedList {
+		err := hosted.adapter.Poll(hosted)
+		if err != nil {
+			logrus.Errorf("AdapterHost.PollAdapters: Adapter %s failed polling: %s", hosted.name, err)
+		}
+	}
+}
+
+// SetPollInterval sets the interval in which the hosted adapters are polled
+// This replaces the poll handler of the publisher.
+//  seconds interval to poll. Default (0) is publisher.DefaultPollInterval
+func (host *AdapterHost) SetPollInterval(seconds int) {
+	host.pub.SetPollInterval(seconds, func(pub *publisher.Publisher) {
+		host.PollAdapters()
+	})
+}
+
+// NewAdapterHost creates a host for adapters that publish with the given publisher
+// The adapters are polled each poll interval of the publisher.
+func NewAdapterHost(pub *publisher.Publisher) *AdapterHost {
+	host := &AdapterHost{
+		adapters:    make(map[string]*HostedAdapter),
+		pub:         pub,
+		updateMutex: &sync.Mutex{},
+	}
+	host.SetPollInterval(0)
+	return host
+}
@@ -0,0 +1,76 @@
+package adapters_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/adapters"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const adapterNodeHWID = "adapternode1"
+
+var testConfig = &publisher.PublisherConfig{
+	ConfigFolder: "../test",
+	CacheFolder:  "../test",
+	Domain:       "test",
+	PublisherID:  "adapterhost",
+}
+
+// testAdapter with a node that has a switch input and output
+type testAdapter struct {
+	pollCount int
+	pollErr   error
+}
+
+func (adapter *testAdapter) Discover(hosted *adapters.HostedAdapter) error {
+	pub := hosted.Publisher()
+	pub.CreateNode(adapterNodeHWID, types.NodeTypeUnknown)
+	pub.CreateOutput(adapterNodeHWID, types.OutputTypeSwitch, types.DefaultOutputInstance)
+	hosted.CreateInput(adapterNodeHWID, types.InputTypeSwitch, types.DefaultInputInstance)
+	return nil
+}
+
+func (adapter *testAdapter) Poll(hosted *adapters.HostedAdapter) error {
+	adapter.pollCount++
+	return adapter.pollErr
+}
+
+func (adapter *testAdapter) SetInput(input *types.InputDiscoveryMessage, sender string, value string) error {
+	return nil
+}
+
+func TestAdapterHost(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	pub := publisher.NewPublisher(testConfig, messenger)
+	host := adapters.NewAdapterHost(pub)
+	adapter1 := &testAdapter{}
+	adapter2 := &testAdapter{pollErr: errors.New("poll failed")}
+
+	err := host.AddAdapter("adapter1", adapter1)
+	require.NoError(t, err)
+	err = host.AddAdapter("adapter2", adapter2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"adapter1", "adapter2"}, host.GetAdapterNames())
+	assert.NotNil(t, pub.GetNodeByHWID(adapterNodeHWID))
+	assert.NotNil(t, pub.GetInputByNodeHWID(adapterNodeHWID, types.InputTypeSwitch, types.DefaultInputInstance))
+
+	// all adapters are polled, even if one fails
+	pub.TriggerDiscovery()
+	assert.Equal(t, 1, adapter1.pollCount)
+	assert.Equal(t, 1, adapter2.pollCount)
+
+	// error cases
+	err = host.AddAdapter("adapter1", adapter1)
+	assert.Error(t, err, "duplicate name")
+	err = host.LoadAdapter("../test/adapter.wasm")
+	assert.Error(t, err)
+	err = host.LoadAdapter("../test/notaplugin.so")
+	assert.Error(t, err)
+	err = host.LoadAdapters("../test")
+	assert.NoError(t, err, "folder without plugins")
+}
@@ -0,0 +1,208 @@
+// Package adapters with import of values from legacy MQTT topics
+package adapters
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// MqttImportMapping maps the messages of a legacy MQTT topic to the value of an output
+type MqttImportMapping struct {
+	DataType   types.DataType   `yaml:"dataType,omitempty"` // data type of the output value. Default is string
+	Instance   string           `yaml:"instance,omitempty"` // instance of the output. Default is types.DefaultOutputInstance
+	JSONPath   string           `yaml:"jsonPath,omitempty"` // dot separated path to the value in a JSON message, eg sensor.temp or list.0. Default is the whole message
+	NodeHWID   string           `yaml:"node"`               // hardware ID of the node of the output
+	NodeType   types.NodeType   `yaml:"nodeType,omitempty"` // type of the node. Default is unknown
+	OutputType types.OutputType `yaml:"outputType"`         // type of the output
+	Topic      string           `yaml:"topic"`              // legacy topic, with support for '+' and '#' wildcards
+	Unit       types.Unit       `yaml:"unit,omitempty"`     // unit of the output value
+}
+
+// MqttImportConfig with the mapping of legacy MQTT topics to node outputs
+type MqttImportConfig struct {
+	Mappings []MqttImportMapping `yaml:"mappings"`
+}
+
+// MqttImport is an adapter that republishes the messages of legacy MQTT topics as signed iotdomain
+// discovery and output values. Intended for migrating existing MQTT device fleets.
+// The import is read-only. Set commands are not passed to the legacy topics.
+type MqttImport struct {
+	config          MqttImportConfig     // mappings of the legacy topics
+	legacyMessenger messaging.IMessenger // connection to the bus with the legacy topics
+	pub             *publisher.Publisher // publisher of the imported values, set on discovery
+	topics          []string             // subscribed legacy topics
+	updateMutex     *sync.Mutex          // mutex for async receiving of legacy messages
+}
+
+// Discover creates the nodes and outputs of the mappings and subscribes to the legacy topics
+// This returns an error if a mapping lacks its topic, node or output type.
+func (mqttImport *MqttImport) Discover(hosted *HostedAdapter) error {
+	pub := hosted.Publisher()
+	for _, mapping := range mqttImport.config.Mappings {
+		if mapping.Topic == "" || mapping.NodeHWID == "" || mapping.OutputType == "" {
+			return lib.MakeErrorf("Discover: Mapping of topic '%s' requires a topic, node and outputType", mapping.Topic)
+		}
+		if pub.GetNodeByHWID(mapping.NodeHWID) == nil {
+			nodeType := mapping.NodeType
+			if nodeType == "" {
+				nodeType = types.NodeTypeUnknown
+			}
+			pub.CreateNode(mapping.NodeHWID, nodeType)
+		}
+		output := pub.CreateOutput(mapping.NodeHWID, mapping.OutputType, mappingInstance(mapping))
+		output.DataType = mapping.DataType
+		output.Unit = mapping.Unit
+		pub.UpdateOutput(output)
+	}
+
+	mqttImport.updateMutex.Lock()
+	defer mqttImport.updateMutex.Unlock()
+	mqttImport.pub = pub
+	subscribed := make(map[string]bool)
+	for _, topic := range mqttImport.topics {
+		subscribed[topic] = true
+	}
+	for _, mapping := range mqttImport.config.Mappings {
+		if !subscribed[mapping.Topic] {
+			subscribed[mapping.Topic] = true
+			mqttImport.topics = append(mqttImport.topics, mapping.Topic)
+			mqttImport.legacyMessenger.Subscribe(mapping.Topic, mqttImport.handleLegacyMessage)
+		}
+	}
+	return nil
+}
+
+// Poll does nothing as values are updated when legacy messages are received
+func (mqttImport *MqttImport) Poll(hosted *HostedAdapter) error {
+	return nil
+}
+
+// SetInput is not supported as the import is read-only
+func (mqttImport *MqttImport) SetInput(input *types.InputDiscoveryMessage, sender string, value string) error {
+	return lib.MakeErrorf("SetInput: The MQTT import is read-only. Input %s is not supported", input.Address)
+}
+
+// Stop unsubscribes from the legacy topics
+func (mqttImport *MqttImport) Stop() {
+	mqttImport.updateMutex.Lock()
+	defer mqttImport.updateMutex.Unlock()
+	for _, topic := range mqttImport.topics {
+		mqttImport.legacyMessenger.Unsubscribe(topic, nil)
+	}
+	mqttImport.topics = nil
+}
+
+// handleLegacyMessage updates the output values of the mappings whose topic matches
+// This returns an error if the value of a mapping can't be extracted from the message.
+func (mqttImport *MqttImport) handleLegacyMessage(topic string, message string) error {
+	mqttImport.updateMutex.Lock()
+	pub := mqttImport.pub
+	mqttImport.updateMutex.Unlock()
+	if pub == nil {
+		return nil
+	}
+	var firstErr error
+	for _, mapping := range mqttImport.config.Mappings {
+		if !messaging.MatchAddress(topic, mapping.Topic) {
+			continue
+		}
+		value, err := ExtractJSONPath(message, mapping.JSONPath)
+		if err != nil {
+			logrus.Warningf("MqttImport.handleLegacyMessage: Message on %s: %s", topic, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		pub.UpdateOutputValue(mapping.NodeHWID, mapping.OutputType, mappingInstance(mapping), value)
+	}
+	return firstErr
+}
+
+// mappingInstance returns the output instance of a mapping
+func mappingInstance(mapping MqttImportMapping) string {
+	if mapping.Instance == "" {
+		return types.DefaultOutputInstance
+	}
+	return mapping.Instance
+}
+
+// ExtractJSONPath returns the value at the path in a JSON message.
+// Strings are returned as is, numbers and booleans in their text form and objects and lists as JSON.
+//  message is the JSON message
+//  path is a dot separated list of object keys and list indices, eg sensor.temp or list.0.
+//  Use "" to return the whole message without parsing it.
+func ExtractJSONPath(message string, path string) (string, error) {
+	if path == "" {
+		return message, nil
+	}
+	var node interface{}
+	err := json.Unmarshal([]byte(message), &node)
+	if err != nil {
+		return "", lib.MakeErrorf("ExtractJSONPath: Message is not JSON: %s", err)
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch container := node.(type) {
+		case map[string]interface{}:
+			value, found := container[key]
+			if !found {
+				return "", lib.MakeErrorf("ExtractJSONPath: Key '%s' of path '%s' not found", key, path)
+			}
+			node = value
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(container) {
+				return "", lib.MakeErrorf("ExtractJSONPath: Index '%s' of path '%s' is not in the list", key, path)
+			}
+			node = container[index]
+		default:
+			return "", lib.MakeErrorf("ExtractJSONPath: Path '%s' continues past a value at '%s'", path, key)
+		}
+	}
+	switch value := node.(type) {
+	case string:
+		return value, nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case nil:
+		return "", lib.MakeErrorf("ExtractJSONPath: Value of path '%s' is null", path)
+	}
+	valueJSON, _ := json.Marshal(node)
+	return string(valueJSON), nil
+}
+
+// LoadMqttImportConfig loads the mapping of legacy topics from a YAML file
+//  configFolder containing the file. Use "" for the default config folder.
+//  filename of the YAML file with the mappings
+func LoadMqttImportConfig(configFolder string, filename string) (*MqttImportConfig, error) {
+	config := &MqttImportConfig{}
+	err := lib.LoadYamlConfig(configFolder, filename, "", config)
+	if err != nil {
+		return nil, lib.MakeErrorf("LoadMqttImportConfig: Unable to load mappings from %s: %s", filename, err)
+	}
+	return config, nil
+}
+
+// NewMqttImport creates an adapter that imports the messages of legacy MQTT topics.
+// Add it to an adapter host to create the nodes and outputs and start importing.
+//  config with the mapping of legacy topics to node outputs
+//  legacyMessenger is the connected messenger of the bus with the legacy topics. Messages are not verified.
+func NewMqttImport(config *MqttImportConfig, legacyMessenger messaging.IMessenger) *MqttImport {
+	mqttImport := &MqttImport{
+		config:          *config,
+		legacyMessenger: legacyMessenger,
+		topics:          make([]string, 0),
+		updateMutex:     &sync.Mutex{},
+	}
+	return mqttImport
+}
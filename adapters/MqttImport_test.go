@@ -0,0 +1,110 @@
+package adapters_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/adapters"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const mqttImportYaml = `
+mappings:
+  - topic: legacy/kitchen/climate
+    node: kitchen
+    outputType: temperature
+    jsonPath: temp
+    unit: C
+    dataType: number
+  - topic: legacy/kitchen/climate
+    node: kitchen
+    outputType: humidity
+    jsonPath: hum.1
+  - topic: legacy/+/switch
+    node: hallway
+    outputType: switch
+`
+
+func TestMqttImport(t *testing.T) {
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	ioutil.WriteFile(filepath.Join(tempFolder, "mqttimport.yaml"), []byte(mqttImportYaml), 0644)
+	config, err := adapters.LoadMqttImportConfig(tempFolder, "mqttimport.yaml")
+	require.NoError(t, err)
+	require.Len(t, config.Mappings, 3)
+
+	legacy := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	pub := publisher.NewPublisher(testConfig, messaging.NewDummyMessenger(&messaging.MessengerConfig{}))
+	host := adapters.NewAdapterHost(pub)
+	mqttImport := adapters.NewMqttImport(config, legacy)
+	err = host.AddAdapter("mqttimport", mqttImport)
+	require.NoError(t, err)
+	output := pub.GetOutputByNodeHWID("kitchen", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	require.NotNil(t, output)
+	assert.Equal(t, types.UnitCelcius, output.Unit)
+	assert.NotNil(t, pub.GetNodeByHWID("hallway"))
+
+	// legacy messages update the output values
+	legacy.Publish("legacy/kitchen/climate", false, `{"temp": 21.5, "hum": [40, 41]}`)
+	legacy.Publish("legacy/hall/switch", false, "on")
+	value := pub.GetOutputValueByNodeHWID("kitchen", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	require.NotNil(t, value)
+	assert.Equal(t, "21.5", value.Value)
+	value = pub.GetOutputValueByNodeHWID("kitchen", types.OutputTypeHumidity, types.DefaultOutputInstance)
+	require.NotNil(t, value)
+	assert.Equal(t, "41", value.Value)
+	value = pub.GetOutputValueByNodeHWID("hallway", types.OutputTypeSwitch, types.DefaultOutputInstance)
+	require.NotNil(t, value)
+	assert.Equal(t, "on", value.Value)
+
+	// the import is read-only
+	input := &types.InputDiscoveryMessage{Address: "test/adapterhost/hallway/$input/switch/0"}
+	assert.Error(t, mqttImport.SetInput(input, "", "off"))
+	assert.NoError(t, mqttImport.Poll(nil))
+
+	// no updates after stopping
+	mqttImport.Stop()
+	legacy.Publish("legacy/hall/switch", false, "off")
+	value = pub.GetOutputValueByNodeHWID("hallway", types.OutputTypeSwitch, types.DefaultOutputInstance)
+	assert.Equal(t, "on", value.Value)
+
+	// error case - invalid mapping and missing file
+	invalid := adapters.NewMqttImport(&adapters.MqttImportConfig{
+		Mappings: []adapters.MqttImportMapping{{Topic: "legacy/invalid"}}}, legacy)
+	assert.Error(t, host.AddAdapter("invalid", invalid))
+	_, err = adapters.LoadMqttImportConfig(tempFolder, "notafile.yaml")
+	assert.Error(t, err)
+}
+
+func TestExtractJSONPath(t *testing.T) {
+	const message = `{"a": {"b": [1, true, "three", {"c": null}]}}`
+	value, err := adapters.ExtractJSONPath(message, "a.b.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", value)
+	value, _ = adapters.ExtractJSONPath(message, "a.b.1")
+	assert.Equal(t, "true", value)
+	value, _ = adapters.ExtractJSONPath(message, "a.b.2")
+	assert.Equal(t, "three", value)
+	value, _ = adapters.ExtractJSONPath(message, "a.b.3")
+	assert.Equal(t, `{"c":null}`, value)
+	value, _ = adapters.ExtractJSONPath("not json", "")
+	assert.Equal(t, "not json", value)
+
+	// error cases
+	_, err = adapters.ExtractJSONPath("not json", "a")
+	assert.Error(t, err)
+	_, err = adapters.ExtractJSONPath(message, "a.x")
+	assert.Error(t, err)
+	_, err = adapters.ExtractJSONPath(message, "a.b.9")
+	assert.Error(t, err)
+	_, err = adapters.ExtractJSONPath(message, "a.b.0.d")
+	assert.Error(t, err)
+	_, err = adapters.ExtractJSONPath(message, "a.b.3.c")
+	assert.Error(t, err)
+}
@@ -0,0 +1,215 @@
+// Package lwm2m with encoding and decoding of CoAP messages (RFC 7252)
+package lwm2m
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/lib/coap"
+)
+
+// CoAP message types
+const (
+	CoAPConfirmable    = 0
+	CoAPNonConfirmable = 1
+	CoAPAcknowledgment = 2
+	CoAPReset          = 3
+)
+
+// CoAP request and response codes as class << 5 | detail
+const (
+	CoAPEmpty      = 0
+	CoAPGet        = 1
+	CoAPPost       = 2
+	CoAPPut        = 3
+	CoAPDelete     = 4
+	CoAPCreated    = 2<<5 | 1
+	CoAPDeleted    = 2<<5 | 2
+	CoAPChanged    = 2<<5 | 4
+	CoAPContent    = 2<<5 | 5
+	CoAPBadRequest = 4<<5 | 0
+	CoAPForbidden  = 4<<5 | 3
+	CoAPNotFound   = 4<<5 | 4
+	CoAPNotAllowed = 4<<5 | 5
+)
+
+// CoAP options used by LwM2M
+const (
+	CoAPOptionObserve       = 6
+	CoAPOptionLocationPath  = 8
+	CoAPOptionURIPath       = 11
+	CoAPOptionContentFormat = 12
+	CoAPOptionURIQuery      = 15
+	CoAPOptionAccept        = 17
+)
+
+// CoAPContentFormatText is the content format of plain text payloads
+const CoAPContentFormatText = 0
+
+// CoAPOption is an option of a CoAP message
+type CoAPOption = coap.Option
+
+// CoAPMessage is a CoAP request or response
+type CoAPMessage struct {
+	Code      byte
+	MessageID uint16
+	Options   []CoAPOption
+	Payload   []byte
+	Token     []byte
+	Type      byte
+}
+
+// AddOption adds an option to the message
+func (message *CoAPMessage) AddOption(number int, value []byte) {
+	message.Options = append(message.Options, CoAPOption{Number: number, Value: value})
+}
+
+// AddPath adds the Uri-Path options of a path with segments separated by '/'
+func (message *CoAPMessage) AddPath(path string) {
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		if segment != "" {
+			message.AddOption(CoAPOptionURIPath, []byte(segment))
+		}
+	}
+}
+
+// AddUintOption adds an option with an unsigned integer value in the fewest bytes
+func (message *CoAPMessage) AddUintOption(number int, value uint32) {
+	message.AddOption(number, EncodeCoAPUint(value))
+}
+
+// CodeText returns the code of the message as class.detail, eg 2.05
+func (message *CoAPMessage) CodeText() string {
+	return fmt.Sprintf("%d.%02d", message.Code>>5, message.Code&0x1f)
+}
+
+// GetOption returns the value of the first option with a number, or nil if the option is missing
+func (message *CoAPMessage) GetOption(number int) []byte {
+	for _, option := range message.Options {
+		if option.Number == number {
+			return option.Value
+		}
+	}
+	return nil
+}
+
+// GetPath returns the Uri-Path options joined with '/'
+func (message *CoAPMessage) GetPath() string {
+	segments := make([]string, 0)
+	for _, option := range message.Options {
+		if option.Number == CoAPOptionURIPath {
+			segments = append(segments, string(option.Value))
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// GetQuery returns the Uri-Query options as a map of names and values
+func (message *CoAPMessage) GetQuery() map[string]string {
+	query := make(map[string]string)
+	for _, option := range message.Options {
+		if option.Number == CoAPOptionURIQuery {
+			parts := strings.SplitN(string(option.Value), "=", 2)
+			name, _ := url.QueryUnescape(parts[0])
+			value := ""
+			if len(parts) > 1 {
+				value, _ = url.QueryUnescape(parts[1])
+			}
+			query[name] = value
+		}
+	}
+	return query
+}
+
+// IsRequest returns true if the message is a request, false if it is a response or empty message
+func (message *CoAPMessage) IsRequest() bool {
+	return message.Code != CoAPEmpty && message.Code>>5 == 0
+}
+
+// Marshal encodes the message. Options are encoded in the order of their number.
+func (message *CoAPMessage) Marshal() []byte {
+	data := []byte{1<<6 | message.Type<<4 | byte(len(message.Token)&0x0f), message.Code, 0, 0}
+	binary.BigEndian.PutUint16(data[2:], message.MessageID)
+	data = append(data, message.Token...)
+
+	options := append([]CoAPOption{}, message.Options...)
+	sort.SliceStable(options, func(i, j int) bool {
+		return options[i].Number < options[j].Number
+	})
+	previous := 0
+	for _, option := range options {
+		delta, deltaExtended := encodeCoAPNibble(option.Number - previous)
+		length, lengthExtended := encodeCoAPNibble(len(option.Value))
+		data = append(data, byte(delta<<4|length))
+		data = append(data, deltaExtended...)
+		data = append(data, lengthExtended...)
+		data = append(data, option.Value...)
+		previous = option.Number
+	}
+	if len(message.Payload) > 0 {
+		data = append(data, 0xff)
+		data = append(data, message.Payload...)
+	}
+	return data
+}
+
+// DecodeCoAPUint returns the unsigned integer value of an option
+func DecodeCoAPUint(value []byte) uint32 {
+	result := uint32(0)
+	for _, b := range value {
+		result = result<<8 | uint32(b)
+	}
+	return result
+}
+
+// EncodeCoAPUint returns the option value of an unsigned integer in the fewest bytes
+func EncodeCoAPUint(value uint32) []byte {
+	encoded := make([]byte, 0, 4)
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(value >> uint(shift)); b != 0 || len(encoded) > 0 {
+			encoded = append(encoded, b)
+		}
+	}
+	return encoded
+}
+
+// ParseCoAPMessage decodes a CoAP message
+// This returns an error if the message is not a valid CoAP version 1 message.
+func ParseCoAPMessage(data []byte) (*CoAPMessage, error) {
+	if len(data) < 4 || data[0]>>6 != 1 {
+		return nil, lib.MakeErrorf("ParseCoAPMessage: Not a CoAP message")
+	}
+	tokenLength := int(data[0] & 0x0f)
+	if tokenLength > 8 || len(data) < 4+tokenLength {
+		return nil, lib.MakeErrorf("ParseCoAPMessage: Invalid token length %d", tokenLength)
+	}
+	options, payload, err := coap.ParseOptions(data, 4+tokenLength)
+	if err != nil {
+		return nil, lib.MakeErrorf("ParseCoAPMessage: %s", err)
+	}
+	message := &CoAPMessage{
+		Code:      data[1],
+		MessageID: binary.BigEndian.Uint16(data[2:4]),
+		Options:   options,
+		Payload:   payload,
+		Token:     append([]byte{}, data[4:4+tokenLength]...),
+		Type:      (data[0] >> 4) & 0x03,
+	}
+	return message, nil
+}
+
+// encodeCoAPNibble returns the nibble and extended bytes of an option delta or length
+func encodeCoAPNibble(value int) (int, []byte) {
+	if value < 13 {
+		return value, nil
+	} else if value < 269 {
+		return 13, []byte{byte(value - 13)}
+	}
+	extended := make([]byte, 2)
+	binary.BigEndian.PutUint16(extended, uint16(value-269))
+	return 14, extended
+}
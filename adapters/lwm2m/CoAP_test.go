@@ -0,0 +1,57 @@
+package lwm2m_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/adapters/lwm2m"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoAPMessage(t *testing.T) {
+	message := &lwm2m.CoAPMessage{
+		Code:      lwm2m.CoAPPost,
+		MessageID: 0x1234,
+		Payload:   []byte("</3303/0>"),
+		Token:     []byte{1, 2, 3},
+		Type:      lwm2m.CoAPConfirmable,
+	}
+	message.AddOption(lwm2m.CoAPOptionURIQuery, []byte("ep=sensor%201"))
+	message.AddPath("/rd/")
+	message.AddOption(lwm2m.CoAPOptionURIQuery, []byte("lt=60"))
+	message.AddOption(3332, []byte("SHSW-1#A4CF12F45D37#2"))
+	message.AddUintOption(lwm2m.CoAPOptionContentFormat, 11543)
+	longValue := make([]byte, 300)
+	message.AddOption(2048, longValue)
+
+	// options are encoded in order, with extended deltas and lengths
+	parsed, err := lwm2m.ParseCoAPMessage(message.Marshal())
+	require.NoError(t, err)
+	assert.Equal(t, message.Code, parsed.Code)
+	assert.Equal(t, message.MessageID, parsed.MessageID)
+	assert.Equal(t, message.Token, parsed.Token)
+	assert.Equal(t, message.Payload, parsed.Payload)
+	assert.Equal(t, "rd", parsed.GetPath())
+	assert.Equal(t, map[string]string{"ep": "sensor 1", "lt": "60"}, parsed.GetQuery())
+	assert.Equal(t, uint32(11543), lwm2m.DecodeCoAPUint(parsed.GetOption(lwm2m.CoAPOptionContentFormat)))
+	assert.Equal(t, "SHSW-1#A4CF12F45D37#2", string(parsed.GetOption(3332)))
+	assert.Len(t, parsed.GetOption(2048), 300)
+	assert.True(t, parsed.IsRequest())
+	assert.Equal(t, "0.02", parsed.CodeText())
+	assert.Empty(t, lwm2m.EncodeCoAPUint(0))
+
+	// a response without options or payload
+	response := &lwm2m.CoAPMessage{Code: lwm2m.CoAPContent, Type: lwm2m.CoAPAcknowledgment}
+	parsed, err = lwm2m.ParseCoAPMessage(response.Marshal())
+	require.NoError(t, err)
+	assert.False(t, parsed.IsRequest())
+	assert.Equal(t, "2.05", parsed.CodeText())
+	assert.Empty(t, parsed.Payload)
+
+	// error cases - not CoAP and truncated options
+	_, err = lwm2m.ParseCoAPMessage([]byte("GET / HTTP/1.1"))
+	assert.Error(t, err)
+	truncated := message.Marshal()
+	_, err = lwm2m.ParseCoAPMessage(truncated[:12])
+	assert.Error(t, err)
+}
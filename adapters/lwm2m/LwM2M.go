@@ -0,0 +1,537 @@
+// Package lwm2m with an adapter for LwM2M devices that register with its CoAP server
+package lwm2m
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/adapters"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Defaults of the LwM2M server
+const (
+	DefaultAddress  = ":5683" // UDP address the CoAP server listens on
+	DefaultLifetime = 86400   // nr of seconds a registration lasts if the device doesn't provide it
+	DefaultTimeout  = 5       // nr of seconds to wait for the response of a device
+)
+
+// ResourceMapping maps a resource of an LwM2M object to an output, an input or both
+// Each instance of the object is published with its object instance ID as the output and input instance.
+type ResourceMapping struct {
+	DataType   types.DataType   `yaml:"dataType,omitempty"`   // data type of the value. Booleans are published as true/false
+	InputType  types.InputType  `yaml:"inputType,omitempty"`  // input that writes the resource. Default is none
+	ObjectID   int              `yaml:"object"`               // LwM2M object ID, eg 3303 for temperature
+	OutputType types.OutputType `yaml:"outputType,omitempty"` // output with the observed resource value. Default is none
+	ResourceID int              `yaml:"resource"`             // resource ID, eg 5700 for a sensor value
+	Unit       types.Unit       `yaml:"unit,omitempty"`       // unit of the value
+}
+
+// DefaultResourceMappings maps the resources of common IPSO objects
+var DefaultResourceMappings = []ResourceMapping{
+	{ObjectID: 3, ResourceID: 9, OutputType: types.OutputTypeBattery, DataType: types.DataTypeNumber, Unit: types.UnitPercent},
+	{ObjectID: 3301, ResourceID: 5700, OutputType: types.OutputTypeLuminance, DataType: types.DataTypeNumber, Unit: types.UnitLux},
+	{ObjectID: 3303, ResourceID: 5700, OutputType: types.OutputTypeTemperature, DataType: types.DataTypeNumber, Unit: types.UnitCelcius},
+	{ObjectID: 3304, ResourceID: 5700, OutputType: types.OutputTypeHumidity, DataType: types.DataTypeNumber, Unit: types.UnitPercent},
+	{ObjectID: 3305, ResourceID: 5800, OutputType: types.OutputTypeElectricPower, DataType: types.DataTypeNumber, Unit: types.UnitWatt},
+	{ObjectID: 3306, ResourceID: 5850, OutputType: types.OutputTypeSwitch, InputType: types.InputTypeSwitch, DataType: types.DataTypeBool},
+	{ObjectID: 3311, ResourceID: 5850, OutputType: types.OutputTypeSwitch, InputType: types.InputTypeSwitch, DataType: types.DataTypeBool},
+	{ObjectID: 3311, ResourceID: 5851, OutputType: types.OutputTypeDimmer, InputType: types.InputTypeDimmer, DataType: types.DataTypeNumber, Unit: types.UnitPercent},
+	{ObjectID: 3312, ResourceID: 5850, OutputType: types.OutputTypeSwitch, InputType: types.InputTypeSwitch, DataType: types.DataTypeBool},
+	{ObjectID: 3315, ResourceID: 5700, OutputType: types.OutputTypeAtmosphericPressure, DataType: types.DataTypeNumber, Unit: types.UnitMillibar},
+	{ObjectID: 3316, ResourceID: 5700, OutputType: types.OutputTypeVoltage, DataType: types.DataTypeNumber, Unit: types.UnitVolt},
+	{ObjectID: 3317, ResourceID: 5700, OutputType: types.OutputTypeElectricCurrent, DataType: types.DataTypeNumber, Unit: types.UnitAmp},
+}
+
+// LwM2MConfig with the CoAP server and the mapping of LwM2M resources
+type LwM2MConfig struct {
+	Address  string            `yaml:"address,omitempty"`  // UDP address to listen on. Default is DefaultAddress
+	Mappings []ResourceMapping `yaml:"mappings,omitempty"` // resource mappings that add to or replace the default mappings
+	Timeout  int               `yaml:"timeout,omitempty"`  // nr of seconds to wait for device responses. Default is DefaultTimeout
+}
+
+// lwm2mDevice is a registered LwM2M device
+type lwm2mDevice struct {
+	address        *net.UDPAddr // address the device registered from
+	endpoint       string       // endpoint client name
+	expires        time.Time    // time the registration expires unless updated
+	hwID           string       // node hardware ID
+	registrationID string       // location of the registration, rd/{registrationID}
+}
+
+// pendingRequest is a request waiting for the response of a device
+type pendingRequest struct {
+	address         *net.UDPAddr      // address of the device the request was sent to
+	responseChannel chan *CoAPMessage // channel that receives the response
+}
+
+// resourceInput is the resource written by an input
+type resourceInput struct {
+	mapping ResourceMapping
+	path    string // object/instance/resource
+}
+
+// resourceOutput is the output of an observed resource
+type resourceOutput struct {
+	instance string
+	mapping  ResourceMapping
+	nodeHWID string
+}
+
+// LwM2MServer is an adapter with a CoAP server that LwM2M devices register with. Registered devices are
+// published as nodes. The resources of their objects are mapped to outputs that are updated from resource
+// observations, and to inputs whose set commands write the resource. Payloads use the plain text format.
+// Object instances are published as output and input instance {objectID}-{instanceID}, eg 3303-0.
+type LwM2MServer struct {
+	config       LwM2MConfig                // server configuration
+	conn         *net.UDPConn               // CoAP server socket, nil when stopped
+	devices      map[string]*lwm2mDevice    // registered devices by registration ID
+	hosted       *adapters.HostedAdapter    // host of the adapter, set on discovery
+	inputs       map[string]resourceInput   // resource of inputs by input ID
+	lastID       uint16                     // last message ID
+	mappings     []ResourceMapping          // default and configured resource mappings
+	observations map[string]resourceOutput  // output of observed resources by token
+	pending      map[string]*pendingRequest // requests waiting for their response by token
+	updateMutex  *sync.Mutex                // mutex for async receiving of device messages
+}
+
+// Discover starts the CoAP server. Devices are added when they register.
+// This returns an error if the server can't listen on its address.
+func (server *LwM2MServer) Discover(hosted *adapters.HostedAdapter) error {
+	address, err := net.ResolveUDPAddr("udp", server.config.Address)
+	if err != nil {
+		return lib.MakeErrorf("Discover: Invalid address %s: %s", server.config.Address, err)
+	}
+	conn, err := net.ListenUDP("udp", address)
+	if err != nil {
+		return lib.MakeErrorf("Discover: Unable to listen on %s: %s", server.config.Address, err)
+	}
+	server.updateMutex.Lock()
+	server.hosted = hosted
+	server.conn = conn
+	server.updateMutex.Unlock()
+	logrus.Infof("LwM2MServer.Discover: Listening for LwM2M registrations on %s", conn.LocalAddr())
+	go server.receiveLoop(conn)
+	return nil
+}
+
+// Poll removes the registrations that have expired and marks their nodes as lost
+func (server *LwM2MServer) Poll(hosted *adapters.HostedAdapter) error {
+	now := time.Now()
+	expired := make([]*lwm2mDevice, 0)
+	server.updateMutex.Lock()
+	for registrationID, device := range server.devices {
+		if now.After(device.expires) {
+			expired = append(expired, device)
+			delete(server.devices, registrationID)
+		}
+	}
+	server.updateMutex.Unlock()
+
+	for _, device := range expired {
+		hosted.Publisher().UpdateNodeErrorStatus(device.hwID, types.NodeRunStateLost, "LwM2M registration expired")
+	}
+	return nil
+}
+
+// SetInput writes the resource of an input to the device and waits for the device to accept it
+// This returns an error if the device isn't registered, the value is invalid or the device rejects the write.
+func (server *LwM2MServer) SetInput(input *types.InputDiscoveryMessage, sender string, value string) error {
+	server.updateMutex.Lock()
+	resource, found := server.inputs[input.InputID]
+	var address *net.UDPAddr
+	device := server.findDevice(input.NodeHWID)
+	if device != nil {
+		address = device.address
+	}
+	server.updateMutex.Unlock()
+	if !found || device == nil {
+		return lib.MakeErrorf("SetInput: Input %s is not a resource of a registered device", input.Address)
+	}
+	payload := value
+	if resource.mapping.DataType == types.DataTypeBool {
+		switch strings.ToLower(value) {
+		case "on", "true", "1":
+			payload = "1"
+		case "off", "false", "0":
+			payload = "0"
+		default:
+			return lib.MakeErrorf("SetInput: Value '%s' of input %s is not a boolean", value, input.Address)
+		}
+	}
+	request := &CoAPMessage{Code: CoAPPut, Payload: []byte(payload), Type: CoAPConfirmable}
+	request.AddPath(resource.path)
+	request.AddUintOption(CoAPOptionContentFormat, CoAPContentFormatText)
+	response, err := server.sendRequest(request, address)
+	if err != nil {
+		return err
+	} else if response.Code != CoAPChanged {
+		return lib.MakeErrorf("SetInput: Device %s rejected the write of %s: %s",
+			device.endpoint, resource.path, response.CodeText())
+	}
+	return nil
+}
+
+// Stop stops the CoAP server
+func (server *LwM2MServer) Stop() {
+	server.updateMutex.Lock()
+	conn := server.conn
+	server.conn = nil
+	server.updateMutex.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// findDevice returns the registered device of a node, or nil if the node isn't registered
+// The caller must hold the update mutex.
+func (server *LwM2MServer) findDevice(hwID string) *lwm2mDevice {
+	for _, registered := range server.devices {
+		if registered.hwID == hwID {
+			return registered
+		}
+	}
+	return nil
+}
+
+// handleRegistration handles the register, update and deregister requests of the registration interface
+// The objects of a registration are added after the response is sent. A registration of an endpoint that
+// is registered from another address is refused until the existing registration expires.
+func (server *LwM2MServer) handleRegistration(request *CoAPMessage, sender *net.UDPAddr) {
+	path := strings.Split(request.GetPath(), "/")
+	query := request.GetQuery()
+	pub := server.hosted.Publisher()
+
+	if len(path) == 1 && path[0] == "rd" && request.Code == CoAPPost {
+		endpoint := query["ep"]
+		if endpoint == "" {
+			server.respond(request, sender, CoAPBadRequest)
+			return
+		}
+		device := &lwm2mDevice{address: sender, endpoint: endpoint, hwID: makeNodeHWID(endpoint)}
+		now := time.Now()
+		server.updateMutex.Lock()
+		for _, registered := range server.devices {
+			if registered.endpoint == endpoint && !isSameAddress(registered.address, sender) &&
+				now.Before(registered.expires) {
+				server.updateMutex.Unlock()
+				logrus.Warningf("LwM2MServer.handleRegistration: Refused registration of device '%s' from %s. "+
+					"It is registered from %s", endpoint, sender, registered.address)
+				server.respond(request, sender, CoAPForbidden)
+				return
+			}
+		}
+		for registrationID, registered := range server.devices {
+			if registered.endpoint == endpoint {
+				delete(server.devices, registrationID)
+			}
+		}
+		for token, output := range server.observations {
+			if output.nodeHWID == device.hwID {
+				delete(server.observations, token)
+			}
+		}
+		device.registrationID = hex.EncodeToString(makeToken())
+		device.expires = now.Add(getLifetime(query))
+		server.devices[device.registrationID] = device
+		server.updateMutex.Unlock()
+
+		if pub.GetNodeByHWID(device.hwID) == nil {
+			pub.CreateNode(device.hwID, types.NodeTypeSensor)
+		}
+		pub.UpdateNodeAttr(device.hwID, types.NodeAttrMap{types.NodeAttrAddress: sender.String()})
+		pub.UpdateNodeErrorStatus(device.hwID, types.NodeRunStateReady, "")
+		logrus.Infof("LwM2MServer.handleRegistration: Device '%s' registered from %s", endpoint, sender)
+		server.respond(request, sender, CoAPCreated,
+			CoAPOption{Number: CoAPOptionLocationPath, Value: []byte("rd")},
+			CoAPOption{Number: CoAPOptionLocationPath, Value: []byte(device.registrationID)})
+		server.registerObjects(device, string(request.Payload))
+		return
+	} else if len(path) != 2 || path[0] != "rd" {
+		server.respond(request, sender, CoAPNotFound)
+		return
+	}
+
+	server.updateMutex.Lock()
+	device := server.devices[path[1]]
+	if device != nil && request.Code == CoAPDelete {
+		delete(server.devices, path[1])
+	} else if device != nil && request.Code == CoAPPost {
+		device.address = sender
+		device.expires = time.Now().Add(getLifetime(query))
+	}
+	server.updateMutex.Unlock()
+	if device == nil {
+		server.respond(request, sender, CoAPNotFound)
+	} else if request.Code == CoAPDelete {
+		pub.UpdateNodeErrorStatus(device.hwID, types.NodeRunStateLost, "LwM2M device deregistered")
+		server.respond(request, sender, CoAPDeleted)
+	} else if request.Code == CoAPPost {
+		pub.UpdateNodeErrorStatus(device.hwID, types.NodeRunStateReady, "")
+		server.respond(request, sender, CoAPChanged)
+		if len(request.Payload) > 0 {
+			server.registerObjects(device, string(request.Payload))
+		}
+	} else {
+		server.respond(request, sender, CoAPNotAllowed)
+	}
+}
+
+// handleResponse passes a response to the request waiting for it or updates the output of an observed resource
+// Responses are only accepted from the address of the device the request was sent to.
+func (server *LwM2MServer) handleResponse(response *CoAPMessage, sender *net.UDPAddr) {
+	if response.Type == CoAPConfirmable {
+		server.send(&CoAPMessage{MessageID: response.MessageID, Type: CoAPAcknowledgment}, sender)
+	}
+	if response.Code == CoAPEmpty {
+		return
+	}
+	token := string(response.Token)
+	server.updateMutex.Lock()
+	request := server.pending[token]
+	if request != nil && isSameAddress(request.address, sender) {
+		delete(server.pending, token)
+	} else {
+		request = nil
+	}
+	output, isObserved := server.observations[token]
+	if isObserved {
+		device := server.findDevice(output.nodeHWID)
+		isObserved = device != nil && isSameAddress(device.address, sender)
+	}
+	server.updateMutex.Unlock()
+
+	if request != nil {
+		request.responseChannel <- response
+	} else if isObserved && response.Code == CoAPContent {
+		contentFormat := response.GetOption(CoAPOptionContentFormat)
+		if contentFormat != nil && DecodeCoAPUint(contentFormat) != CoAPContentFormatText {
+			logrus.Warningf("LwM2MServer.handleResponse: Ignored observation of %s/%s/%d with content format %d",
+				output.nodeHWID, output.instance, output.mapping.ResourceID, DecodeCoAPUint(contentFormat))
+			return
+		}
+		value := string(response.Payload)
+		if output.mapping.DataType == types.DataTypeBool {
+			value = strconv.FormatBool(value == "1" || value == "true")
+		}
+		server.hosted.Publisher().UpdateOutputValue(output.nodeHWID, output.mapping.OutputType, output.instance, value)
+	} else {
+		logrus.Infof("LwM2MServer.handleResponse: Ignored response from %s with unknown token", sender)
+	}
+}
+
+// nextID returns a new message ID and a random token
+func (server *LwM2MServer) nextID() (uint16, []byte) {
+	server.updateMutex.Lock()
+	defer server.updateMutex.Unlock()
+	server.lastID++
+	return server.lastID, makeToken()
+}
+
+// receiveLoop receives CoAP messages until the server is stopped
+func (server *LwM2MServer) receiveLoop(conn *net.UDPConn) {
+	buffer := make([]byte, 2048)
+	for {
+		size, sender, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			logrus.Infof("LwM2MServer.receiveLoop: Stopped listening: %s", err)
+			return
+		}
+		message, err := ParseCoAPMessage(buffer[:size])
+		if err != nil {
+			logrus.Warningf("LwM2MServer.receiveLoop: Message from %s: %s", sender, err)
+			continue
+		}
+		if message.IsRequest() {
+			server.handleRegistration(message, sender)
+		} else {
+			server.handleResponse(message, sender)
+		}
+	}
+}
+
+// registerObjects creates the outputs and inputs of the mapped resources of the object instances in a
+// registration link list, eg </3/0>,</3303/0>, and observes the resources of the outputs
+func (server *LwM2MServer) registerObjects(device *lwm2mDevice, links string) {
+	pub := server.hosted.Publisher()
+	for _, link := range strings.Split(links, ",") {
+		start := strings.Index(link, "<")
+		end := strings.Index(link, ">")
+		if start < 0 || end < start {
+			continue
+		}
+		segments := strings.Split(strings.Trim(link[start+1:end], "/"), "/")
+		if len(segments) != 2 {
+			continue
+		}
+		objectID, err := strconv.Atoi(segments[0])
+		if err != nil {
+			continue
+		}
+		// objects that map to the same output type don't share an instance
+		instance := segments[0] + "-" + segments[1]
+		for _, mapping := range server.mappings {
+			if mapping.ObjectID != objectID {
+				continue
+			}
+			path := strings.Join([]string{segments[0], segments[1], strconv.Itoa(mapping.ResourceID)}, "/")
+			if mapping.InputType != "" {
+				input := server.hosted.CreateInputWithTarget(device.hwID, mapping.InputType, instance, path)
+				server.updateMutex.Lock()
+				server.inputs[input.InputID] = resourceInput{mapping: mapping, path: path}
+				server.updateMutex.Unlock()
+			}
+			if mapping.OutputType != "" {
+				output := pub.CreateOutput(device.hwID, mapping.OutputType, instance)
+				output.DataType = mapping.DataType
+				output.Unit = mapping.Unit
+				pub.UpdateOutput(output)
+				server.observe(device, path, resourceOutput{instance: instance, mapping: mapping, nodeHWID: device.hwID})
+			}
+		}
+	}
+}
+
+// observe requests the device to report the value of a resource when it changes
+func (server *LwM2MServer) observe(device *lwm2mDevice, path string, output resourceOutput) {
+	messageID, token := server.nextID()
+	request := &CoAPMessage{Code: CoAPGet, MessageID: messageID, Token: token, Type: CoAPConfirmable}
+	request.AddOption(CoAPOptionObserve, nil)
+	request.AddPath(path)
+	request.AddUintOption(CoAPOptionAccept, CoAPContentFormatText)
+	server.updateMutex.Lock()
+	server.observations[string(token)] = output
+	server.updateMutex.Unlock()
+	server.send(request, device.address)
+}
+
+// respond sends the response to a request, piggybacked on the acknowledgment of confirmable requests
+func (server *LwM2MServer) respond(request *CoAPMessage, address *net.UDPAddr, code byte, options ...CoAPOption) {
+	response := &CoAPMessage{
+		Code:      code,
+		MessageID: request.MessageID,
+		Options:   options,
+		Token:     request.Token,
+		Type:      CoAPAcknowledgment,
+	}
+	if request.Type != CoAPConfirmable {
+		response.MessageID, _ = server.nextID()
+		response.Type = CoAPNonConfirmable
+	}
+	server.send(response, address)
+}
+
+// send sends a message to a device
+func (server *LwM2MServer) send(message *CoAPMessage, address *net.UDPAddr) {
+	server.updateMutex.Lock()
+	conn := server.conn
+	server.updateMutex.Unlock()
+	if conn == nil {
+		return
+	}
+	_, err := conn.WriteToUDP(message.Marshal(), address)
+	if err != nil {
+		logrus.Warningf("LwM2MServer.send: Unable to send to %s: %s", address, err)
+	}
+}
+
+// sendRequest sends a request to a device and waits for its response
+// This returns an error if the device doesn't respond before the timeout.
+func (server *LwM2MServer) sendRequest(request *CoAPMessage, address *net.UDPAddr) (*CoAPMessage, error) {
+	request.MessageID, request.Token = server.nextID()
+	responseChannel := make(chan *CoAPMessage, 1)
+	server.updateMutex.Lock()
+	server.pending[string(request.Token)] = &pendingRequest{address: address, responseChannel: responseChannel}
+	server.updateMutex.Unlock()
+
+	server.send(request, address)
+	select {
+	case response := <-responseChannel:
+		return response, nil
+	case <-time.After(time.Duration(server.config.Timeout) * time.Second):
+		server.updateMutex.Lock()
+		delete(server.pending, string(request.Token))
+		server.updateMutex.Unlock()
+		return nil, lib.MakeErrorf("sendRequest: No response from %s to request %s", address, request.GetPath())
+	}
+}
+
+// getLifetime returns the lifetime of a registration from its 'lt' query parameter
+func getLifetime(query map[string]string) time.Duration {
+	lifetime, err := strconv.Atoi(query["lt"])
+	if err != nil || lifetime <= 0 {
+		lifetime = DefaultLifetime
+	}
+	return time.Duration(lifetime) * time.Second
+}
+
+// isSameAddress returns true if two UDP addresses have the same IP and port
+func isSameAddress(address1 *net.UDPAddr, address2 *net.UDPAddr) bool {
+	return address1 != nil && address2 != nil && address1.IP.Equal(address2.IP) && address1.Port == address2.Port
+}
+
+// makeToken returns a random token that can't be guessed by other hosts
+func makeToken() []byte {
+	token := make([]byte, 8)
+	rand.Read(token)
+	return token
+}
+
+// makeNodeHWID returns the node hardware ID of an endpoint client name, with characters that aren't
+// valid in an address replaced by '_'
+func makeNodeHWID(endpoint string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_", "$", "_").Replace(endpoint)
+}
+
+// LoadLwM2MConfig loads the LwM2M server configuration from a YAML file
+//  configFolder containing the file. Use "" for the default config folder.
+//  filename of the YAML file with the configuration
+func LoadLwM2MConfig(configFolder string, filename string) (*LwM2MConfig, error) {
+	config := &LwM2MConfig{}
+	err := lib.LoadYamlConfig(configFolder, filename, "", config)
+	if err != nil {
+		return nil, lib.MakeErrorf("LoadLwM2MConfig: Unable to load configuration from %s: %s", filename, err)
+	}
+	return config, nil
+}
+
+// NewLwM2MServer creates an adapter with a CoAP server for LwM2M devices.
+// Add it to an adapter host to start the server.
+//  config of the server. Empty fields use their default.
+func NewLwM2MServer(config *LwM2MConfig) *LwM2MServer {
+	server := &LwM2MServer{
+		config:       *config,
+		devices:      make(map[string]*lwm2mDevice),
+		inputs:       make(map[string]resourceInput),
+		mappings:     make([]ResourceMapping, 0),
+		observations: make(map[string]resourceOutput),
+		pending:      make(map[string]*pendingRequest),
+		updateMutex:  &sync.Mutex{},
+	}
+	if server.config.Address == "" {
+		server.config.Address = DefaultAddress
+	}
+	if server.config.Timeout <= 0 {
+		server.config.Timeout = DefaultTimeout
+	}
+	// configured mappings replace the default mapping of the same resource
+	for _, mapping := range DefaultResourceMappings {
+		isReplaced := false
+		for _, configured := range server.config.Mappings {
+			isReplaced = isReplaced ||
+				(configured.ObjectID == mapping.ObjectID && configured.ResourceID == mapping.ResourceID)
+		}
+		if !isReplaced {
+			server.mappings = append(server.mappings, mapping)
+		}
+	}
+	server.mappings = append(server.mappings, server.config.Mappings...)
+	return server
+}
@@ -0,0 +1,199 @@
+package lwm2m_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/adapters"
+	"github.com/iotdomain/iotdomain-go/adapters/lwm2m"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testConfig = &publisher.PublisherConfig{
+	ConfigFolder: "../../test",
+	CacheFolder:  "../../test",
+	Domain:       "test",
+	PublisherID:  "lwm2m1",
+}
+
+// testDevice simulates an LwM2M client with a temperature sensor and a light control
+type testDevice struct {
+	conn        *net.UDPConn
+	responses   chan *lwm2m.CoAPMessage
+	tokens      map[string][]byte // observation tokens by resource path
+	updateMutex sync.Mutex
+	values      map[string]string // resource values by path
+	writes      chan string
+}
+
+// receive responds to the requests of the server and passes its responses to the responses channel
+func (device *testDevice) receive() {
+	buffer := make([]byte, 2048)
+	for {
+		size, server, err := device.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		message, err := lwm2m.ParseCoAPMessage(buffer[:size])
+		if err != nil {
+			continue
+		} else if !message.IsRequest() {
+			device.responses <- message
+			continue
+		}
+		path := message.GetPath()
+		response := &lwm2m.CoAPMessage{MessageID: message.MessageID, Token: message.Token, Type: lwm2m.CoAPAcknowledgment}
+		device.updateMutex.Lock()
+		switch {
+		case message.Code == lwm2m.CoAPGet:
+			device.tokens[path] = message.Token
+			response.Code = lwm2m.CoAPContent
+			response.Payload = []byte(device.values[path])
+		case message.Code == lwm2m.CoAPPut && path == "3311/0/5851":
+			response.Code = lwm2m.CoAPNotAllowed
+		case message.Code == lwm2m.CoAPPut:
+			device.values[path] = string(message.Payload)
+			response.Code = lwm2m.CoAPChanged
+			device.writes <- path + "=" + string(message.Payload)
+		}
+		device.updateMutex.Unlock()
+		device.conn.WriteToUDP(response.Marshal(), server)
+	}
+}
+
+// request sends a confirmable request to the server and returns its response
+func (device *testDevice) request(t *testing.T, server *net.UDPAddr, request *lwm2m.CoAPMessage) *lwm2m.CoAPMessage {
+	request.Type = lwm2m.CoAPConfirmable
+	device.conn.WriteToUDP(request.Marshal(), server)
+	select {
+	case response := <-device.responses:
+		return response
+	case <-time.After(time.Second):
+		require.Fail(t, "No response from the server")
+	}
+	return nil
+}
+
+func TestLwM2MServer(t *testing.T) {
+	freeConn, _ := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	serverAddress := freeConn.LocalAddr().(*net.UDPAddr)
+	freeConn.Close()
+
+	pub := publisher.NewPublisher(testConfig, messaging.NewDummyMessenger(&messaging.MessengerConfig{}))
+	host := adapters.NewAdapterHost(pub)
+	server := lwm2m.NewLwM2MServer(&lwm2m.LwM2MConfig{Address: serverAddress.String(), Timeout: 1})
+	err := host.AddAdapter("lwm2m", server)
+	require.NoError(t, err)
+	defer server.Stop()
+
+	clientConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer clientConn.Close()
+	device := &testDevice{
+		conn:      clientConn,
+		responses: make(chan *lwm2m.CoAPMessage, 10),
+		tokens:    make(map[string][]byte),
+		values: map[string]string{"3303/0/5700": "21.5", "3306/0/5850": "1", "3311/0/5850": "0",
+			"3311/0/5851": "40"},
+		writes: make(chan string, 10),
+	}
+	go device.receive()
+
+	// registration creates the node with the outputs and inputs of its objects
+	register := &lwm2m.CoAPMessage{Code: lwm2m.CoAPPost, MessageID: 1, Token: []byte{1}}
+	register.AddPath("rd")
+	register.AddOption(lwm2m.CoAPOptionURIQuery, []byte("ep=sensor1"))
+	register.AddOption(lwm2m.CoAPOptionURIQuery, []byte("lt=60"))
+	register.Payload = []byte(`</>;rt="oma.lwm2m",</1/0>,</3303/0>,</3306/0>,</3311/0>`)
+	response := device.request(t, serverAddress, register)
+	require.Equal(t, byte(lwm2m.CoAPCreated), response.Code)
+	location := response.Options
+	require.Len(t, location, 2)
+	registrationPath := string(location[0].Value) + "/" + string(location[1].Value)
+
+	require.NotNil(t, pub.GetNodeByHWID("sensor1"))
+	assert.Eventually(t, func() bool {
+		value := pub.GetOutputValueByNodeHWID("sensor1", types.OutputTypeTemperature, "3303-0")
+		return value != nil && value.Value == "21.5"
+	}, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		value := pub.GetOutputValueByNodeHWID("sensor1", types.OutputTypeSwitch, "3311-0")
+		return value != nil && value.Value == "false"
+	}, time.Second, 10*time.Millisecond)
+	// objects with the same output type have their own instance
+	assert.Eventually(t, func() bool {
+		value := pub.GetOutputValueByNodeHWID("sensor1", types.OutputTypeSwitch, "3306-0")
+		return value != nil && value.Value == "true"
+	}, time.Second, 10*time.Millisecond)
+	output := pub.GetOutputByNodeHWID("sensor1", types.OutputTypeTemperature, "3303-0")
+	assert.Equal(t, types.UnitCelcius, output.Unit)
+
+	// notifications update the outputs
+	device.updateMutex.Lock()
+	token := device.tokens["3303/0/5700"]
+	device.updateMutex.Unlock()
+	notification := &lwm2m.CoAPMessage{Code: lwm2m.CoAPContent, MessageID: 2, Token: token,
+		Type: lwm2m.CoAPNonConfirmable, Payload: []byte("22.5")}
+	notification.AddUintOption(lwm2m.CoAPOptionObserve, 2)
+	clientConn.WriteToUDP(notification.Marshal(), serverAddress)
+	assert.Eventually(t, func() bool {
+		value := pub.GetOutputValueByNodeHWID("sensor1", types.OutputTypeTemperature, "3303-0")
+		return value.Value == "22.5"
+	}, time.Second, 10*time.Millisecond)
+
+	// notifications from another host are ignored
+	otherConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer otherConn.Close()
+	notification.Payload = []byte("99")
+	otherConn.WriteToUDP(notification.Marshal(), serverAddress)
+	time.Sleep(50 * time.Millisecond)
+	value := pub.GetOutputValueByNodeHWID("sensor1", types.OutputTypeTemperature, "3303-0")
+	assert.Equal(t, "22.5", value.Value)
+
+	// another host can't take over the registration of the endpoint
+	otherDevice := &testDevice{conn: otherConn, responses: make(chan *lwm2m.CoAPMessage, 10),
+		tokens: make(map[string][]byte), values: make(map[string]string), writes: make(chan string, 10)}
+	go otherDevice.receive()
+	response = otherDevice.request(t, serverAddress, register)
+	assert.Equal(t, byte(lwm2m.CoAPForbidden), response.Code)
+
+	// set commands write the resource
+	input := pub.GetInputByNodeHWID("sensor1", types.InputTypeSwitch, "3311-0")
+	require.NotNil(t, input)
+	err = server.SetInput(input, "", "on")
+	require.NoError(t, err)
+	assert.Equal(t, "3311/0/5850=1", <-device.writes)
+
+	// error cases - rejected writes and invalid values
+	dimmer := pub.GetInputByNodeHWID("sensor1", types.InputTypeDimmer, "3311-0")
+	require.NotNil(t, dimmer)
+	assert.Error(t, server.SetInput(dimmer, "", "50"))
+	assert.Error(t, server.SetInput(input, "", "maybe"))
+
+	// registration updates
+	update := &lwm2m.CoAPMessage{Code: lwm2m.CoAPPost, MessageID: 3, Token: []byte{3}}
+	update.AddPath(registrationPath)
+	response = device.request(t, serverAddress, update)
+	assert.Equal(t, byte(lwm2m.CoAPChanged), response.Code)
+	unknown := &lwm2m.CoAPMessage{Code: lwm2m.CoAPPost, MessageID: 4, Token: []byte{4}}
+	unknown.AddPath("rd/unknown")
+	response = device.request(t, serverAddress, unknown)
+	assert.Equal(t, byte(lwm2m.CoAPNotFound), response.Code)
+
+	// deregistration marks the node as lost
+	deregister := &lwm2m.CoAPMessage{Code: lwm2m.CoAPDelete, MessageID: 5, Token: []byte{5}}
+	deregister.AddPath(registrationPath)
+	response = device.request(t, serverAddress, deregister)
+	assert.Equal(t, byte(lwm2m.CoAPDeleted), response.Code)
+	node := pub.GetNodeByHWID("sensor1")
+	assert.Equal(t, types.NodeRunStateLost, node.Status[types.NodeStatusRunState])
+	assert.Error(t, server.SetInput(input, "", "off"))
+	assert.NoError(t, server.Poll(nil))
+}
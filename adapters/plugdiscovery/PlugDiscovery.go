@@ -0,0 +1,196 @@
+// Package plugdiscovery with an adapter that discovers Shelly and Tasmota relays and power meters on the LAN
+package plugdiscovery
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/adapters"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// Defaults of the device discovery
+const (
+	DefaultCoIoTAddress           = "224.0.1.187:5683"  // multicast group of Shelly CoIoT announcements
+	DefaultTasmotaDiscoveryPrefix = "tasmota/discovery" // topic prefix of Tasmota MQTT discovery
+	DefaultTimeout                = 5                   // nr of seconds to wait for a device HTTP response
+)
+
+// Node hardware ID prefixes of the discovered devices, followed by the device MAC address
+const (
+	ShellyHWIDPrefix  = "shelly-"
+	TasmotaHWIDPrefix = "tasmota-"
+)
+
+// PlugDiscoveryConfig with the discovery of Shelly and Tasmota devices
+type PlugDiscoveryConfig struct {
+	CoIoTAddress           string   `yaml:"coiotAddress,omitempty"`           // address to listen for Shelly CoIoT announcements. Default is DefaultCoIoTAddress
+	DisableCoIoT           bool     `yaml:"disableCoIoT,omitempty"`           // only use the configured Shelly hosts
+	ShellyHosts            []string `yaml:"shellyHosts,omitempty"`            // host[:port] of Shelly devices that aren't announced
+	ShellyLogin            string   `yaml:"shellyLogin,omitempty"`            // login name of Shelly devices that require authentication
+	ShellyPassword         string   `yaml:"shellyPassword,omitempty"`         // password of Shelly devices that require authentication
+	TasmotaDiscoveryPrefix string   `yaml:"tasmotaDiscoveryPrefix,omitempty"` // Default is DefaultTasmotaDiscoveryPrefix
+	Timeout                int      `yaml:"timeout,omitempty"`                // nr of seconds to wait for HTTP responses. Default is DefaultTimeout
+}
+
+// PlugDiscovery is an adapter that discovers Shelly and Tasmota devices and creates their nodes with
+// relay inputs and switch, power and energy outputs. Intended to get started without configuring devices.
+// Shelly devices (Gen1 HTTP API) are discovered from their CoIoT multicast announcements and polled over
+// HTTP. Tasmota devices are discovered from their MQTT discovery messages and report their state over MQTT.
+type PlugDiscovery struct {
+	coiotConn       *net.UDPConn              // listener of CoIoT announcements, nil if not listening
+	config          PlugDiscoveryConfig       // discovery configuration
+	hosted          *adapters.HostedAdapter   // host of the adapter, set on discovery
+	httpClient      *http.Client              // client for the Shelly HTTP API
+	legacyMessenger messaging.IMessenger      // connection to the bus of the Tasmota devices, nil to not discover them
+	shellies        map[string]*shellyDevice  // discovered Shelly devices by node hardware ID
+	tasmotas        map[string]*tasmotaDevice // discovered Tasmota devices by node hardware ID
+	topics          []string                  // subscribed Tasmota topics
+	updateMutex     *sync.Mutex               // mutex for async discovery of devices
+}
+
+// Discover adds the configured Shelly hosts, starts listening for CoIoT announcements and subscribes
+// to Tasmota discovery. Configured hosts that can't be reached are retried on each poll.
+// This returns an error if the CoIoT listener can't be started.
+func (discovery *PlugDiscovery) Discover(hosted *adapters.HostedAdapter) error {
+	discovery.updateMutex.Lock()
+	discovery.hosted = hosted
+	discovery.updateMutex.Unlock()
+
+	discovery.addShellyHosts()
+	if !discovery.config.DisableCoIoT {
+		err := discovery.listenCoIoT()
+		if err != nil {
+			return err
+		}
+	}
+	if discovery.legacyMessenger != nil {
+		prefix := discovery.config.TasmotaDiscoveryPrefix
+		discovery.subscribeTasmota(prefix+"/+/config", prefix+"/+/sensors")
+	}
+	return nil
+}
+
+// Poll updates the values of the Shelly devices and retries configured hosts that weren't reached
+// Tasmota devices report their values when they change.
+func (discovery *PlugDiscovery) Poll(hosted *adapters.HostedAdapter) error {
+	discovery.addShellyHosts()
+	discovery.updateMutex.Lock()
+	devices := make([]*shellyDevice, 0, len(discovery.shellies))
+	for _, device := range discovery.shellies {
+		devices = append(devices, device)
+	}
+	discovery.updateMutex.Unlock()
+
+	for _, device := range devices {
+		discovery.pollShelly(device)
+	}
+	return nil
+}
+
+// SetInput switches the relay of a Shelly or Tasmota device
+// This returns an error if the input isn't a relay of a discovered device or the value isn't on or off.
+func (discovery *PlugDiscovery) SetInput(input *types.InputDiscoveryMessage, sender string, value string) error {
+	isOn, err := parseRelayValue(value)
+	if err != nil {
+		return lib.MakeErrorf("SetInput: Input %s: %s", input.Address, err)
+	}
+	discovery.updateMutex.Lock()
+	shelly := discovery.shellies[input.NodeHWID]
+	tasmota := discovery.tasmotas[input.NodeHWID]
+	discovery.updateMutex.Unlock()
+
+	if shelly != nil {
+		return discovery.setShellyRelay(shelly, input.Instance, isOn)
+	} else if tasmota != nil {
+		return discovery.setTasmotaRelay(tasmota, input.Instance, isOn)
+	}
+	return lib.MakeErrorf("SetInput: Input %s is not a discovered relay", input.Address)
+}
+
+// Stop stops listening for CoIoT announcements and unsubscribes from the Tasmota topics
+func (discovery *PlugDiscovery) Stop() {
+	discovery.updateMutex.Lock()
+	coiotConn := discovery.coiotConn
+	discovery.coiotConn = nil
+	topics := discovery.topics
+	discovery.topics = nil
+	discovery.updateMutex.Unlock()
+
+	if coiotConn != nil {
+		coiotConn.Close()
+	}
+	for _, topic := range topics {
+		discovery.legacyMessenger.Unsubscribe(topic, nil)
+	}
+}
+
+// getHosted returns the host of the adapter, nil before discovery
+func (discovery *PlugDiscovery) getHosted() *adapters.HostedAdapter {
+	discovery.updateMutex.Lock()
+	defer discovery.updateMutex.Unlock()
+	return discovery.hosted
+}
+
+// parseRelayValue returns true if a set command value switches a relay on
+func parseRelayValue(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "true", "1":
+		return true, nil
+	case "off", "false", "0":
+		return false, nil
+	}
+	return false, lib.MakeErrorf("parseRelayValue: Value '%s' is not on or off", value)
+}
+
+// relayValue returns the output value of a relay state
+func relayValue(isOn bool) string {
+	if isOn {
+		return "on"
+	}
+	return "off"
+}
+
+// LoadPlugDiscoveryConfig loads the device discovery configuration from a YAML file
+//  configFolder containing the file. Use "" for the default config folder.
+//  filename of the YAML file with the configuration
+func LoadPlugDiscoveryConfig(configFolder string, filename string) (*PlugDiscoveryConfig, error) {
+	config := &PlugDiscoveryConfig{}
+	err := lib.LoadYamlConfig(configFolder, filename, "", config)
+	if err != nil {
+		return nil, lib.MakeErrorf("LoadPlugDiscoveryConfig: Unable to load configuration from %s: %s", filename, err)
+	}
+	return config, nil
+}
+
+// NewPlugDiscovery creates an adapter that discovers Shelly and Tasmota devices.
+// Add it to an adapter host to start discovery.
+//  config of the discovery. Empty fields use their default.
+//  legacyMessenger is the connected messenger of the bus the Tasmota devices publish on. Use nil to
+//  only discover Shelly devices.
+func NewPlugDiscovery(config *PlugDiscoveryConfig, legacyMessenger messaging.IMessenger) *PlugDiscovery {
+	discovery := &PlugDiscovery{
+		config:          *config,
+		legacyMessenger: legacyMessenger,
+		shellies:        make(map[string]*shellyDevice),
+		tasmotas:        make(map[string]*tasmotaDevice),
+		topics:          make([]string, 0),
+		updateMutex:     &sync.Mutex{},
+	}
+	if discovery.config.CoIoTAddress == "" {
+		discovery.config.CoIoTAddress = DefaultCoIoTAddress
+	}
+	if discovery.config.TasmotaDiscoveryPrefix == "" {
+		discovery.config.TasmotaDiscoveryPrefix = DefaultTasmotaDiscoveryPrefix
+	}
+	if discovery.config.Timeout <= 0 {
+		discovery.config.Timeout = DefaultTimeout
+	}
+	discovery.httpClient = &http.Client{Timeout: time.Duration(discovery.config.Timeout) * time.Second}
+	return discovery
+}
@@ -0,0 +1,158 @@
+package plugdiscovery_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/adapters"
+	"github.com/iotdomain/iotdomain-go/adapters/plugdiscovery"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testConfig = &publisher.PublisherConfig{
+	ConfigFolder: "../../test",
+	CacheFolder:  "../../test",
+	Domain:       "test",
+	PublisherID:  "plugdiscovery1",
+}
+
+const tasmotaDiscovery = `{"ip":"192.168.1.20","dn":"Kitchen plug","fn":["Kitchen plug",null],` +
+	`"hn":"tasmota-F45D37","mac":"A4CF12F45D37","md":"Sonoff S31","sw":"9.1.0","t":"tasmota_F45D37",` +
+	`"ft":"%prefix%/%topic%/","tp":["cmnd","stat","tele"],"rl":[1,0,0,0,0,0,0,0],"state":["OFF","ON","TOGGLE","HOLD"]}`
+
+// testShelly simulates the HTTP API of a Shelly plug with a relay and a power meter
+type testShelly struct {
+	isOn        bool
+	updateMutex sync.Mutex
+}
+
+func (shelly *testShelly) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	shelly.updateMutex.Lock()
+	defer shelly.updateMutex.Unlock()
+	switch request.URL.Path {
+	case "/shelly":
+		fmt.Fprint(response, `{"type":"SHPLG-S","mac":"A4CF12000001","auth":false,"fw":"20201124-091711/v1.9.0"}`)
+	case "/status":
+		fmt.Fprintf(response, `{"relays":[{"ison":%t}],"meters":[{"power":60.5,"total":120000,"is_valid":true}]}`, shelly.isOn)
+	case "/relay/0":
+		shelly.isOn = request.URL.Query().Get("turn") == "on"
+		fmt.Fprintf(response, `{"ison":%t}`, shelly.isOn)
+	default:
+		http.NotFound(response, request)
+	}
+}
+
+func TestShellyDiscovery(t *testing.T) {
+	shelly := &testShelly{}
+	httpServer := httptest.NewServer(shelly)
+	defer httpServer.Close()
+
+	pub := publisher.NewPublisher(testConfig, messaging.NewDummyMessenger(&messaging.MessengerConfig{}))
+	host := adapters.NewAdapterHost(pub)
+	discovery := plugdiscovery.NewPlugDiscovery(&plugdiscovery.PlugDiscoveryConfig{
+		CoIoTAddress: "127.0.0.1:0",
+		ShellyHosts:  []string{strings.TrimPrefix(httpServer.URL, "http://"), "localhost:1"},
+	}, nil)
+	err := host.AddAdapter("plugdiscovery", discovery)
+	require.NoError(t, err)
+	defer discovery.Stop()
+
+	// configured hosts are discovered with their relay and meter
+	hwID := plugdiscovery.ShellyHWIDPrefix + "a4cf12000001"
+	node := pub.GetNodeByHWID(hwID)
+	require.NotNil(t, node)
+	assert.Equal(t, "SHPLG-S", node.Attr[types.NodeAttrModel])
+	value := pub.GetOutputValueByNodeHWID(hwID, types.OutputTypeSwitch, "0")
+	require.NotNil(t, value)
+	assert.Equal(t, "off", value.Value)
+	value = pub.GetOutputValueByNodeHWID(hwID, types.OutputTypeElectricEnergy, "0")
+	require.NotNil(t, value)
+	assert.Equal(t, "2", value.Value)
+	input := pub.GetInputByNodeHWID(hwID, types.InputTypeSwitch, "0")
+	require.NotNil(t, input)
+
+	// set commands switch the relay
+	err = discovery.SetInput(input, "", "on")
+	require.NoError(t, err)
+	value = pub.GetOutputValueByNodeHWID(hwID, types.OutputTypeSwitch, "0")
+	assert.Equal(t, "on", value.Value)
+
+	// poll updates the values and marks unreachable devices as lost
+	shelly.updateMutex.Lock()
+	shelly.isOn = false
+	shelly.updateMutex.Unlock()
+	assert.NoError(t, discovery.Poll(nil))
+	value = pub.GetOutputValueByNodeHWID(hwID, types.OutputTypeSwitch, "0")
+	assert.Equal(t, "off", value.Value)
+	httpServer.Close()
+	assert.NoError(t, discovery.Poll(nil))
+	node = pub.GetNodeByHWID(hwID)
+	assert.Equal(t, types.NodeRunStateLost, node.Status[types.NodeStatusRunState])
+
+	// error cases - invalid values and unknown inputs
+	assert.Error(t, discovery.SetInput(input, "", "maybe"))
+	unknownInput := &types.InputDiscoveryMessage{NodeHWID: "unknown", Instance: "0"}
+	assert.Error(t, discovery.SetInput(unknownInput, "", "on"))
+}
+
+func TestTasmotaDiscovery(t *testing.T) {
+	legacy := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	pub := publisher.NewPublisher(testConfig, messaging.NewDummyMessenger(&messaging.MessengerConfig{}))
+	host := adapters.NewAdapterHost(pub)
+	discovery := plugdiscovery.NewPlugDiscovery(&plugdiscovery.PlugDiscoveryConfig{DisableCoIoT: true}, legacy)
+	err := host.AddAdapter("plugdiscovery", discovery)
+	require.NoError(t, err)
+
+	// discovery messages create the node with its relay
+	legacy.Publish("tasmota/discovery/A4CF12F45D37/config", true, tasmotaDiscovery)
+	hwID := plugdiscovery.TasmotaHWIDPrefix + "a4cf12f45d37"
+	node := pub.GetNodeByHWID(hwID)
+	require.NotNil(t, node)
+	assert.Equal(t, "Kitchen plug", node.Attr[types.NodeAttrName])
+	input := pub.GetInputByNodeHWID(hwID, types.InputTypeSwitch, "1")
+	require.NotNil(t, input)
+
+	// state and telemetry messages update the outputs
+	legacy.Publish("stat/tasmota_F45D37/POWER", false, "ON")
+	value := pub.GetOutputValueByNodeHWID(hwID, types.OutputTypeSwitch, "1")
+	require.NotNil(t, value)
+	assert.Equal(t, "on", value.Value)
+	legacy.Publish("tele/tasmota_F45D37/SENSOR", false, `{"ENERGY":{"Total":1.25,"Power":40,"Voltage":230}}`)
+	value = pub.GetOutputValueByNodeHWID(hwID, types.OutputTypeElectricEnergy, types.DefaultOutputInstance)
+	require.NotNil(t, value)
+	assert.Equal(t, "1.25", value.Value)
+	output := pub.GetOutputByNodeHWID(hwID, types.OutputTypeElectricPower, types.DefaultOutputInstance)
+	require.NotNil(t, output)
+	assert.Equal(t, types.UnitWatt, output.Unit)
+	legacy.Publish("tele/tasmota_F45D37/STATE", false, `{"POWER":"OFF"}`)
+	value = pub.GetOutputValueByNodeHWID(hwID, types.OutputTypeSwitch, "1")
+	assert.Equal(t, "off", value.Value)
+	legacy.Publish("tele/tasmota_F45D37/LWT", false, "Offline")
+	node = pub.GetNodeByHWID(hwID)
+	assert.Equal(t, types.NodeRunStateLost, node.Status[types.NodeStatusRunState])
+
+	// set commands are published to the command topic
+	err = discovery.SetInput(input, "", "on")
+	require.NoError(t, err)
+	assert.Equal(t, "ON", legacy.FindLastPublication("cmnd/tasmota_F45D37/POWER1"))
+	unknownRelay := &types.InputDiscoveryMessage{NodeHWID: hwID, Instance: "2"}
+	assert.Error(t, discovery.SetInput(unknownRelay, "", "on"))
+
+	// error case - invalid discovery message
+	legacy.Publish("tasmota/discovery/A4CF12F45D38/config", true, `{"mac":"A4CF12F45D38"}`)
+	assert.Nil(t, pub.GetNodeByHWID(plugdiscovery.TasmotaHWIDPrefix+"a4cf12f45d38"))
+
+	// no updates after stopping
+	discovery.Stop()
+	legacy.Publish("stat/tasmota_F45D37/POWER", false, "ON")
+	value = pub.GetOutputValueByNodeHWID(hwID, types.OutputTypeSwitch, "1")
+	assert.Equal(t, "off", value.Value)
+}
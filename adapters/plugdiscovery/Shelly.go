@@ -0,0 +1,320 @@
+// Package plugdiscovery with discovery and control of Shelly devices using CoIoT and the Gen1 HTTP API
+package plugdiscovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/lib/coap"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// coiotOptionDeviceID is the CoAP option of CoIoT messages with the device ID as type#mac#version
+const coiotOptionDeviceID = 3332
+
+// shellyDevice is a discovered Shelly device
+type shellyDevice struct {
+	host string     // host[:port] of the device HTTP API
+	hwID string     // node hardware ID
+	info shellyInfo // device info
+}
+
+// shellyInfo is the device info from the /shelly endpoint
+type shellyInfo struct {
+	Auth bool   `json:"auth"` // device requires authentication
+	FW   string `json:"fw"`   // firmware version
+	MAC  string `json:"mac"`  // MAC address
+	Type string `json:"type"` // model, eg SHSW-PM
+}
+
+// shellyMeter is a power meter from the /status endpoint. Total is in watt-minute for meters and
+// watt-hour for emeters.
+type shellyMeter struct {
+	Current float64 `json:"current"`
+	Power   float64 `json:"power"`
+	Total   float64 `json:"total"`
+	Voltage float64 `json:"voltage"`
+}
+
+// shellyRelay is the state of a relay from the /status or /relay endpoint
+type shellyRelay struct {
+	IsOn bool `json:"ison"`
+}
+
+// shellyStatus is the device status from the /status endpoint
+type shellyStatus struct {
+	EMeters []shellyMeter `json:"emeters"`
+	Meters  []shellyMeter `json:"meters"`
+	Relays  []shellyRelay `json:"relays"`
+}
+
+// addShelly adds the Shelly device at a host and creates its node, inputs and outputs
+// A known device that is found at a new host is updated with that host.
+func (discovery *PlugDiscovery) addShelly(host string) error {
+	hosted := discovery.getHosted()
+	if hosted == nil {
+		return nil
+	}
+	info := shellyInfo{}
+	err := discovery.getShelly(host, "/shelly", &info)
+	if err != nil {
+		return err
+	} else if info.MAC == "" {
+		return lib.MakeErrorf("addShelly: Device at %s is not a Shelly", host)
+	}
+	hwID := ShellyHWIDPrefix + strings.ToLower(info.MAC)
+	discovery.updateMutex.Lock()
+	device := discovery.shellies[hwID]
+	if device != nil {
+		device.host = host
+	}
+	discovery.updateMutex.Unlock()
+	if device != nil {
+		return nil
+	}
+
+	device = &shellyDevice{host: host, hwID: hwID, info: info}
+	status := shellyStatus{}
+	err = discovery.getShelly(host, "/status", &status)
+	if err != nil {
+		return err
+	}
+	pub := hosted.Publisher()
+	nodeType := types.NodeTypePowerMeter
+	if len(status.Relays) > 0 {
+		nodeType = types.NodeTypeOnOffSwitch
+	}
+	if pub.GetNodeByHWID(hwID) == nil {
+		pub.CreateNode(hwID, nodeType)
+	}
+	pub.UpdateNodeAttr(hwID, types.NodeAttrMap{
+		types.NodeAttrMAC:             info.MAC,
+		types.NodeAttrModel:           info.Type,
+		types.NodeAttrSoftwareVersion: info.FW,
+		types.NodeAttrURL:             "http://" + host,
+	})
+	for i := range status.Relays {
+		instance := strconv.Itoa(i)
+		discovery.createOutput(hwID, types.OutputTypeSwitch, instance, types.DataTypeBool, types.UnitNone)
+		hosted.CreateInput(hwID, types.InputTypeSwitch, instance)
+	}
+	for i := range append(status.Meters, status.EMeters...) {
+		instance := strconv.Itoa(i)
+		discovery.createOutput(hwID, types.OutputTypeElectricPower, instance, types.DataTypeNumber, types.UnitWatt)
+		discovery.createOutput(hwID, types.OutputTypeElectricEnergy, instance, types.DataTypeNumber, types.UnitKWH)
+	}
+	for i := range status.EMeters {
+		instance := strconv.Itoa(len(status.Meters) + i)
+		discovery.createOutput(hwID, types.OutputTypeVoltage, instance, types.DataTypeNumber, types.UnitVolt)
+		discovery.createOutput(hwID, types.OutputTypeElectricCurrent, instance, types.DataTypeNumber, types.UnitAmp)
+	}
+	discovery.updateMutex.Lock()
+	discovery.shellies[hwID] = device
+	discovery.updateMutex.Unlock()
+	logrus.Infof("PlugDiscovery.addShelly: Discovered %s '%s' at %s", info.Type, hwID, host)
+	discovery.updateShellyValues(device, &status)
+	return nil
+}
+
+// addShellyHosts adds the configured Shelly hosts that aren't discovered yet
+func (discovery *PlugDiscovery) addShellyHosts() {
+	discovery.updateMutex.Lock()
+	knownHosts := make(map[string]bool)
+	for _, device := range discovery.shellies {
+		knownHosts[device.host] = true
+	}
+	discovery.updateMutex.Unlock()
+
+	for _, host := range discovery.config.ShellyHosts {
+		if !knownHosts[host] {
+			err := discovery.addShelly(host)
+			if err != nil {
+				logrus.Warningf("PlugDiscovery.addShellyHosts: %s", err)
+			}
+		}
+	}
+}
+
+// createOutput creates an output with its data type and unit
+func (discovery *PlugDiscovery) createOutput(
+	nodeHWID string, outputType types.OutputType, instance string, dataType types.DataType, unit types.Unit) {
+
+	pub := discovery.getHosted().Publisher()
+	output := pub.CreateOutput(nodeHWID, outputType, instance)
+	output.DataType = dataType
+	output.Unit = unit
+	pub.UpdateOutput(output)
+}
+
+// getShelly gets a JSON response from the HTTP API of a Shelly device
+func (discovery *PlugDiscovery) getShelly(host string, path string, response interface{}) error {
+	url := "http://" + host + path
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return lib.MakeErrorf("getShelly: Invalid request %s: %s", url, err)
+	}
+	if discovery.config.ShellyLogin != "" {
+		request.SetBasicAuth(discovery.config.ShellyLogin, discovery.config.ShellyPassword)
+	}
+	httpResponse, err := discovery.httpClient.Do(request)
+	if err != nil {
+		return lib.MakeErrorf("getShelly: Request %s failed: %s", url, err)
+	}
+	defer httpResponse.Body.Close()
+	body, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return lib.MakeErrorf("getShelly: Unable to read response of %s: %s", url, err)
+	} else if httpResponse.StatusCode != http.StatusOK {
+		return lib.MakeErrorf("getShelly: Request %s failed: %s", url, httpResponse.Status)
+	}
+	err = json.Unmarshal(body, response)
+	if err != nil {
+		return lib.MakeErrorf("getShelly: Invalid response of %s: %s", url, err)
+	}
+	return nil
+}
+
+// listenCoIoT starts listening for the CoIoT announcements of Shelly devices
+func (discovery *PlugDiscovery) listenCoIoT() error {
+	address, err := net.ResolveUDPAddr("udp4", discovery.config.CoIoTAddress)
+	if err != nil {
+		return lib.MakeErrorf("listenCoIoT: Invalid CoIoT address %s: %s", discovery.config.CoIoTAddress, err)
+	}
+	var coiotConn *net.UDPConn
+	if address.IP.IsMulticast() {
+		coiotConn, err = net.ListenMulticastUDP("udp4", nil, address)
+	} else {
+		coiotConn, err = net.ListenUDP("udp4", address)
+	}
+	if err != nil {
+		return lib.MakeErrorf("listenCoIoT: Unable to listen on %s: %s", discovery.config.CoIoTAddress, err)
+	}
+	discovery.updateMutex.Lock()
+	discovery.coiotConn = coiotConn
+	discovery.updateMutex.Unlock()
+	go discovery.receiveCoIoT(coiotConn)
+	return nil
+}
+
+// pollShelly updates the values of a Shelly device and its run state
+func (discovery *PlugDiscovery) pollShelly(device *shellyDevice) {
+	pub := discovery.getHosted().Publisher()
+	status := shellyStatus{}
+	discovery.updateMutex.Lock()
+	host := device.host
+	discovery.updateMutex.Unlock()
+	err := discovery.getShelly(host, "/status", &status)
+	if err != nil {
+		pub.UpdateNodeErrorStatus(device.hwID, types.NodeRunStateLost, err.Error())
+		return
+	}
+	pub.UpdateNodeErrorStatus(device.hwID, types.NodeRunStateReady, "")
+	discovery.updateShellyValues(device, &status)
+}
+
+// receiveCoIoT adds the devices that announce themselves until the listener is closed
+// Announcements of known devices update their values.
+func (discovery *PlugDiscovery) receiveCoIoT(coiotConn *net.UDPConn) {
+	buffer := make([]byte, 4096)
+	for {
+		size, sender, err := coiotConn.ReadFromUDP(buffer)
+		if err != nil {
+			logrus.Infof("PlugDiscovery.receiveCoIoT: Stopped listening: %s", err)
+			return
+		}
+		deviceID := parseCoIoTDeviceID(buffer[:size])
+		parts := strings.Split(deviceID, "#")
+		if len(parts) < 2 {
+			continue
+		}
+		hwID := ShellyHWIDPrefix + strings.ToLower(parts[1])
+		senderIP := sender.IP.String()
+		discovery.updateMutex.Lock()
+		device := discovery.shellies[hwID]
+		knownHost := ""
+		if device != nil {
+			knownHost = device.host
+			if host, _, err := net.SplitHostPort(knownHost); err == nil {
+				knownHost = host
+			}
+		}
+		discovery.updateMutex.Unlock()
+		if device != nil && knownHost == senderIP {
+			discovery.pollShelly(device)
+		} else {
+			err = discovery.addShelly(senderIP)
+			if err != nil {
+				logrus.Warningf("PlugDiscovery.receiveCoIoT: Announcement of %s: %s", deviceID, err)
+			}
+		}
+	}
+}
+
+// setShellyRelay switches a relay of a Shelly device and updates its output
+func (discovery *PlugDiscovery) setShellyRelay(device *shellyDevice, instance string, isOn bool) error {
+	if _, err := strconv.Atoi(instance); err != nil {
+		return lib.MakeErrorf("setShellyRelay: Relay '%s' of %s is not a relay number", instance, device.hwID)
+	}
+	discovery.updateMutex.Lock()
+	host := device.host
+	discovery.updateMutex.Unlock()
+	relay := shellyRelay{}
+	err := discovery.getShelly(host, fmt.Sprintf("/relay/%s?turn=%s", instance, relayValue(isOn)), &relay)
+	if err != nil {
+		return err
+	}
+	pub := discovery.getHosted().Publisher()
+	pub.UpdateOutputValue(device.hwID, types.OutputTypeSwitch, instance, relayValue(relay.IsOn))
+	return nil
+}
+
+// updateShellyValues updates the outputs of a Shelly device from its status
+func (discovery *PlugDiscovery) updateShellyValues(device *shellyDevice, status *shellyStatus) {
+	pub := discovery.getHosted().Publisher()
+	for i, relay := range status.Relays {
+		pub.UpdateOutputValue(device.hwID, types.OutputTypeSwitch, strconv.Itoa(i), relayValue(relay.IsOn))
+	}
+	// meters report energy in watt-minute, emeters in watt-hour
+	for i, meter := range status.Meters {
+		instance := strconv.Itoa(i)
+		pub.UpdateOutputValue(device.hwID, types.OutputTypeElectricPower, instance, formatNumber(meter.Power))
+		pub.UpdateOutputValue(device.hwID, types.OutputTypeElectricEnergy, instance, formatNumber(meter.Total/60000))
+	}
+	for i, meter := range status.EMeters {
+		instance := strconv.Itoa(len(status.Meters) + i)
+		pub.UpdateOutputValue(device.hwID, types.OutputTypeElectricPower, instance, formatNumber(meter.Power))
+		pub.UpdateOutputValue(device.hwID, types.OutputTypeElectricEnergy, instance, formatNumber(meter.Total/1000))
+		pub.UpdateOutputValue(device.hwID, types.OutputTypeVoltage, instance, formatNumber(meter.Voltage))
+		pub.UpdateOutputValue(device.hwID, types.OutputTypeElectricCurrent, instance, formatNumber(meter.Current))
+	}
+}
+
+// formatNumber returns the text of a numeric value
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// parseCoIoTDeviceID returns the device ID option of a CoIoT message, or "" if the message is not
+// a CoAP message with a device ID
+func parseCoIoTDeviceID(message []byte) string {
+	if len(message) < 4 || message[0]>>6 != 1 {
+		return ""
+	}
+	options, _, err := coap.ParseOptions(message, 4+int(message[0]&0x0f))
+	if err != nil {
+		return ""
+	}
+	for _, option := range options {
+		if option.Number == coiotOptionDeviceID {
+			return string(option.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,295 @@
+// Package plugdiscovery with discovery and control of Tasmota devices using MQTT discovery
+package plugdiscovery
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// tasmotaRelayType is the relay type of a Tasmota discovery message for a relay
+const tasmotaRelayType = 1
+
+// Index of the topic prefixes in a Tasmota discovery message
+const (
+	tasmotaCommandPrefix   = 0
+	tasmotaStatPrefix      = 1
+	tasmotaTelemetryPrefix = 2
+)
+
+// tasmotaConfig is the discovery message of a Tasmota device
+type tasmotaConfig struct {
+	DeviceName      string   `json:"dn"`
+	FullTopic       string   `json:"ft"` // topic template, eg %prefix%/%topic%/
+	Hostname        string   `json:"hn"`
+	IP              string   `json:"ip"`
+	MAC             string   `json:"mac"`
+	Model           string   `json:"md"`
+	Prefixes        []string `json:"tp"` // command, stat and telemetry topic prefixes
+	Relays          []int    `json:"rl"` // type of each relay, 1 for a relay
+	SoftwareVersion string   `json:"sw"`
+	StateTexts      []string `json:"state"` // texts of off, on, toggle and hold
+	Topic           string   `json:"t"`
+}
+
+// tasmotaDevice is a discovered Tasmota device
+type tasmotaDevice struct {
+	commandTopic   string        // topic prefix of commands
+	config         tasmotaConfig // discovery message
+	hasEnergy      bool          // energy outputs are created
+	hwID           string        // node hardware ID
+	relays         []string      // relay numbers, starting at 1
+	statTopic      string        // topic prefix of state messages
+	telemetryTopic string        // topic prefix of telemetry messages
+}
+
+// addTasmota adds the Tasmota device of a discovery message and creates its node, relay inputs and outputs
+// A known device is updated with the new discovery message.
+func (discovery *PlugDiscovery) addTasmota(message string) error {
+	hosted := discovery.getHosted()
+	if hosted == nil {
+		return nil
+	}
+	config := tasmotaConfig{}
+	err := json.Unmarshal([]byte(message), &config)
+	if err != nil {
+		return lib.MakeErrorf("addTasmota: Invalid discovery message: %s", err)
+	} else if config.MAC == "" || config.Topic == "" || len(config.Prefixes) <= tasmotaTelemetryPrefix {
+		return lib.MakeErrorf("addTasmota: Discovery message lacks the MAC, topic or prefixes")
+	}
+	if config.FullTopic == "" {
+		config.FullTopic = "%prefix%/%topic%/"
+	}
+	device := &tasmotaDevice{
+		commandTopic:   makeTasmotaTopic(&config, tasmotaCommandPrefix),
+		config:         config,
+		hwID:           TasmotaHWIDPrefix + strings.ToLower(config.MAC),
+		relays:         make([]string, 0),
+		statTopic:      makeTasmotaTopic(&config, tasmotaStatPrefix),
+		telemetryTopic: makeTasmotaTopic(&config, tasmotaTelemetryPrefix),
+	}
+	for i, relayType := range config.Relays {
+		if relayType == tasmotaRelayType {
+			device.relays = append(device.relays, strconv.Itoa(i+1))
+		}
+	}
+
+	pub := hosted.Publisher()
+	if pub.GetNodeByHWID(device.hwID) == nil {
+		nodeType := types.NodeTypePowerMeter
+		if len(device.relays) > 0 {
+			nodeType = types.NodeTypeOnOffSwitch
+		}
+		pub.CreateNode(device.hwID, nodeType)
+	}
+	pub.UpdateNodeAttr(device.hwID, types.NodeAttrMap{
+		types.NodeAttrHostname:        config.Hostname,
+		types.NodeAttrLocalIP:         config.IP,
+		types.NodeAttrMAC:             config.MAC,
+		types.NodeAttrModel:           config.Model,
+		types.NodeAttrName:            config.DeviceName,
+		types.NodeAttrSoftwareVersion: config.SoftwareVersion,
+	})
+	for _, relay := range device.relays {
+		discovery.createOutput(device.hwID, types.OutputTypeSwitch, relay, types.DataTypeBool, types.UnitNone)
+		hosted.CreateInput(device.hwID, types.InputTypeSwitch, relay)
+	}
+	discovery.updateMutex.Lock()
+	if existing := discovery.tasmotas[device.hwID]; existing != nil {
+		device.hasEnergy = existing.hasEnergy
+	}
+	discovery.tasmotas[device.hwID] = device
+	discovery.updateMutex.Unlock()
+	logrus.Infof("PlugDiscovery.addTasmota: Discovered %s '%s' with topic %s", config.Model, device.hwID, config.Topic)
+	discovery.subscribeTasmota(device.statTopic+"+", device.telemetryTopic+"+")
+	return nil
+}
+
+// getTasmotaByTopic returns the Tasmota device that publishes on a topic and the topic suffix
+// This returns nil if the topic isn't a stat or telemetry topic of a discovered device.
+func (discovery *PlugDiscovery) getTasmotaByTopic(topic string) (*tasmotaDevice, string, int) {
+	discovery.updateMutex.Lock()
+	defer discovery.updateMutex.Unlock()
+	for _, device := range discovery.tasmotas {
+		if strings.HasPrefix(topic, device.statTopic) {
+			return device, strings.TrimPrefix(topic, device.statTopic), tasmotaStatPrefix
+		} else if strings.HasPrefix(topic, device.telemetryTopic) {
+			return device, strings.TrimPrefix(topic, device.telemetryTopic), tasmotaTelemetryPrefix
+		}
+	}
+	return nil, "", 0
+}
+
+// handleTasmotaMessage handles the discovery, state and telemetry messages of Tasmota devices
+// This returns an error if a discovery message is invalid.
+func (discovery *PlugDiscovery) handleTasmotaMessage(topic string, message string) error {
+	prefix := discovery.config.TasmotaDiscoveryPrefix
+	if messaging.MatchAddress(topic, prefix+"/+/config") {
+		err := discovery.addTasmota(message)
+		if err != nil {
+			logrus.Warningf("PlugDiscovery.handleTasmotaMessage: Message on %s: %s", topic, err)
+		}
+		return err
+	} else if messaging.MatchAddress(topic, prefix+"/+/sensors") {
+		mac := strings.Split(strings.TrimPrefix(topic, prefix+"/"), "/")[0]
+		discovery.updateMutex.Lock()
+		device := discovery.tasmotas[TasmotaHWIDPrefix+strings.ToLower(mac)]
+		discovery.updateMutex.Unlock()
+		sensors := struct {
+			Sensors map[string]interface{} `json:"sn"`
+		}{}
+		if device != nil && json.Unmarshal([]byte(message), &sensors) == nil {
+			discovery.updateTasmotaEnergy(device, sensors.Sensors["ENERGY"])
+		}
+		return nil
+	}
+	device, suffix, prefixIndex := discovery.getTasmotaByTopic(topic)
+	if device == nil {
+		return nil
+	}
+	pub := discovery.getHosted().Publisher()
+	if strings.HasPrefix(suffix, "POWER") {
+		discovery.updateTasmotaRelay(device, strings.TrimPrefix(suffix, "POWER"), message)
+		return nil
+	} else if prefixIndex == tasmotaTelemetryPrefix && suffix == "LWT" {
+		if strings.EqualFold(message, "Offline") {
+			pub.UpdateNodeErrorStatus(device.hwID, types.NodeRunStateLost, "Tasmota device is offline")
+		} else {
+			pub.UpdateNodeErrorStatus(device.hwID, types.NodeRunStateReady, "")
+		}
+		return nil
+	}
+	// RESULT, STATE and SENSOR messages are JSON objects with POWER and ENERGY values
+	values := make(map[string]interface{})
+	if json.Unmarshal([]byte(message), &values) != nil {
+		return nil
+	}
+	for key, value := range values {
+		if relayState, isText := value.(string); isText && strings.HasPrefix(key, "POWER") {
+			discovery.updateTasmotaRelay(device, strings.TrimPrefix(key, "POWER"), relayState)
+		}
+	}
+	if energy, found := values["ENERGY"]; found {
+		discovery.updateTasmotaEnergy(device, energy)
+	}
+	return nil
+}
+
+// setTasmotaRelay publishes the command that switches a relay of a Tasmota device
+// The output is updated when the device publishes its new state.
+func (discovery *PlugDiscovery) setTasmotaRelay(device *tasmotaDevice, instance string, isOn bool) error {
+	for _, relay := range device.relays {
+		if relay == instance {
+			command := "OFF"
+			if isOn {
+				command = "ON"
+			}
+			return discovery.legacyMessenger.Publish(device.commandTopic+"POWER"+relay, false, command)
+		}
+	}
+	return lib.MakeErrorf("setTasmotaRelay: Device %s has no relay '%s'", device.hwID, instance)
+}
+
+// subscribeTasmota subscribes to the Tasmota topics that aren't subscribed yet
+func (discovery *PlugDiscovery) subscribeTasmota(topics ...string) {
+	newTopics := make([]string, 0)
+	discovery.updateMutex.Lock()
+	for _, topic := range topics {
+		if !containsString(discovery.topics, topic) {
+			discovery.topics = append(discovery.topics, topic)
+			newTopics = append(newTopics, topic)
+		}
+	}
+	discovery.updateMutex.Unlock()
+	for _, topic := range newTopics {
+		discovery.legacyMessenger.Subscribe(topic, discovery.handleTasmotaMessage)
+	}
+}
+
+// updateTasmotaEnergy updates the energy outputs of a Tasmota device from the ENERGY values of its
+// sensor message. The outputs are created when the device first reports energy values.
+func (discovery *PlugDiscovery) updateTasmotaEnergy(device *tasmotaDevice, energy interface{}) {
+	energyValues, isObject := energy.(map[string]interface{})
+	if !isObject {
+		return
+	}
+	energyOutputs := []struct {
+		key        string
+		outputType types.OutputType
+		unit       types.Unit
+	}{
+		{"Current", types.OutputTypeElectricCurrent, types.UnitAmp},
+		{"Power", types.OutputTypeElectricPower, types.UnitWatt},
+		{"Total", types.OutputTypeElectricEnergy, types.UnitKWH},
+		{"Voltage", types.OutputTypeVoltage, types.UnitVolt},
+	}
+	discovery.updateMutex.Lock()
+	createOutputs := !device.hasEnergy
+	device.hasEnergy = true
+	discovery.updateMutex.Unlock()
+
+	pub := discovery.getHosted().Publisher()
+	for _, energyOutput := range energyOutputs {
+		value, isNumber := energyValues[energyOutput.key].(float64)
+		if !isNumber {
+			continue
+		}
+		if createOutputs {
+			discovery.createOutput(device.hwID, energyOutput.outputType, types.DefaultOutputInstance,
+				types.DataTypeNumber, energyOutput.unit)
+		}
+		pub.UpdateOutputValue(device.hwID, energyOutput.outputType, types.DefaultOutputInstance, formatNumber(value))
+	}
+}
+
+// updateTasmotaRelay updates the output of a relay of a Tasmota device with its state text
+//  relay is the relay number, "" for the first relay
+func (discovery *PlugDiscovery) updateTasmotaRelay(device *tasmotaDevice, relay string, state string) {
+	if relay == "" {
+		relay = "1"
+	}
+	if !containsString(device.relays, relay) {
+		return
+	}
+	onText := "ON"
+	if len(device.config.StateTexts) > 1 && device.config.StateTexts[1] != "" {
+		onText = device.config.StateTexts[1]
+	}
+	isOn := strings.EqualFold(state, onText) || strings.EqualFold(state, "ON")
+	pub := discovery.getHosted().Publisher()
+	pub.UpdateOutputValue(device.hwID, types.OutputTypeSwitch, relay, relayValue(isOn))
+}
+
+// containsString returns true if the list contains the text
+func containsString(list []string, text string) bool {
+	for _, item := range list {
+		if item == text {
+			return true
+		}
+	}
+	return false
+}
+
+// makeTasmotaTopic returns the topic prefix of a Tasmota device from its full topic template
+//  prefixIndex is the index of the command, stat or telemetry prefix
+func makeTasmotaTopic(config *tasmotaConfig, prefixIndex int) string {
+	deviceID := strings.ToUpper(config.MAC)
+	if len(deviceID) > 6 {
+		deviceID = deviceID[len(deviceID)-6:]
+	}
+	topic := strings.NewReplacer(
+		"%prefix%", config.Prefixes[prefixIndex],
+		"%topic%", config.Topic,
+		"%hostname%", config.Hostname,
+		"%id%", deviceID,
+	).Replace(config.FullTopic)
+	if !strings.HasSuffix(topic, "/") {
+		topic += "/"
+	}
+	return topic
+}
@@ -0,0 +1,641 @@
+// Package zwavejs with an adapter of the Z-Wave devices managed by a zwave-js-server
+package zwavejs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/adapters"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+// Defaults of the zwave-js-server connection
+const (
+	DefaultCommandTimeout = 10      // nr of seconds to wait for the result of a command
+	DefaultNodePrefix     = "zwave" // prefix of the node hardware ID, followed by the Z-Wave node ID
+	DefaultSchemaVersion  = 1       // API schema version of the zwave-js-server messages
+	DefaultURL            = "ws://localhost:3000"
+)
+
+// Z-Wave command classes that are mapped to inputs and outputs
+const (
+	CommandClassBinarySwitch     = 37
+	CommandClassMultilevelSwitch = 38
+	CommandClassBinarySensor     = 48
+	CommandClassMultilevelSensor = 49
+	CommandClassMeter            = 50
+	CommandClassDoorLock         = 98
+	CommandClassBattery          = 128
+)
+
+// NodeAttrCommandClasses is the node attribute with the comma separated command classes of a Z-Wave node
+const NodeAttrCommandClasses types.NodeAttr = "commandClasses"
+
+// multilevelSensorTypes maps multilevel sensor properties to output types
+var multilevelSensorTypes = map[string]types.OutputType{
+	"Air temperature":            types.OutputTypeTemperature,
+	"Atmospheric pressure":       types.OutputTypeAtmosphericPressure,
+	"Carbon dioxide (CO2) level": types.OutputTypeCarbonDioxideLevel,
+	"Dew point":                  types.OutputTypeDewpoint,
+	"Humidity":                   types.OutputTypeHumidity,
+	"Illuminance":                types.OutputTypeLuminance,
+	"Power":                      types.OutputTypeElectricPower,
+	"Ultraviolet":                types.OutputTypeUltraviolet,
+	"Voltage":                    types.OutputTypeVoltage,
+}
+
+// meterUnitTypes maps the units of meter values to output types
+var meterUnitTypes = map[string]types.OutputType{
+	"A":   types.OutputTypeElectricCurrent,
+	"kWh": types.OutputTypeElectricEnergy,
+	"V":   types.OutputTypeVoltage,
+	"W":   types.OutputTypeElectricPower,
+}
+
+// valueUnits maps the units of Z-Wave values to output units
+var valueUnits = map[string]types.Unit{
+	"%":   types.UnitPercent,
+	"°C":  types.UnitCelcius,
+	"°F":  types.UnitFahrenheit,
+	"A":   types.UnitAmp,
+	"kWh": types.UnitKWH,
+	"Lux": types.UnitLux,
+	"V":   types.UnitVolt,
+	"W":   types.UnitWatt,
+}
+
+// ZWaveJSConfig with the connection to the zwave-js-server
+type ZWaveJSConfig struct {
+	CommandTimeout int    `yaml:"commandTimeout,omitempty"` // nr of seconds to wait for a command result. Default is DefaultCommandTimeout
+	NodePrefix     string `yaml:"nodePrefix,omitempty"`     // prefix of the node hardware IDs. Default is DefaultNodePrefix
+	SchemaVersion  int    `yaml:"schemaVersion,omitempty"`  // API schema version. Default is DefaultSchemaVersion
+	URL            string `yaml:"url,omitempty"`            // websocket URL of the server. Default is DefaultURL
+}
+
+// ZWaveValueID identifies a value of a Z-Wave node
+type ZWaveValueID struct {
+	CommandClass int         `json:"commandClass"`
+	Endpoint     int         `json:"endpoint"`
+	Property     interface{} `json:"property"`
+	PropertyKey  interface{} `json:"propertyKey,omitempty"`
+}
+
+// ZWaveValueMetadata describes a Z-Wave value
+type ZWaveValueMetadata struct {
+	Label     string   `json:"label,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Readable  bool     `json:"readable"`
+	Type      string   `json:"type"` // boolean, number, string, ...
+	Unit      string   `json:"unit,omitempty"`
+	Writeable bool     `json:"writeable"`
+}
+
+// ZWaveValue is a value of a Z-Wave node as reported in the server state
+type ZWaveValue struct {
+	ZWaveValueID
+	CommandClassName string             `json:"commandClassName"`
+	Metadata         ZWaveValueMetadata `json:"metadata"`
+	PropertyName     string             `json:"propertyName,omitempty"`
+	Value            interface{}        `json:"value,omitempty"`
+}
+
+// ZWaveDeviceConfig with the device description of a Z-Wave node
+type ZWaveDeviceConfig struct {
+	Description  string `json:"description,omitempty"`
+	Label        string `json:"label,omitempty"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+}
+
+// ZWaveNode is a Z-Wave node as reported in the server state
+type ZWaveNode struct {
+	DeviceConfig *ZWaveDeviceConfig `json:"deviceConfig,omitempty"`
+	Location     string             `json:"location,omitempty"`
+	Name         string             `json:"name,omitempty"`
+	NodeID       int                `json:"nodeId"`
+	Values       []ZWaveValue       `json:"values"`
+}
+
+// serverEvent is an event from the server
+type serverEvent struct {
+	Args   *serverEventArgs `json:"args,omitempty"`
+	Event  string           `json:"event"`
+	Node   *ZWaveNode       `json:"node,omitempty"`
+	NodeID int              `json:"nodeId,omitempty"`
+	Source string           `json:"source"`
+}
+
+// serverEventArgs with the value of a value event
+type serverEventArgs struct {
+	ZWaveValueID
+	NewValue interface{} `json:"newValue,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+}
+
+// serverMessage is a message from the server, either the version, a command result or an event
+type serverMessage struct {
+	ErrorCode     string          `json:"errorCode,omitempty"`
+	Event         *serverEvent    `json:"event,omitempty"`
+	MessageID     string          `json:"messageId,omitempty"`
+	Result        json.RawMessage `json:"result,omitempty"`
+	ServerVersion string          `json:"serverVersion,omitempty"`
+	Success       bool            `json:"success,omitempty"`
+	Type          string          `json:"type"`
+}
+
+// serverState is the result of the start_listening command
+type serverState struct {
+	State struct {
+		Nodes []ZWaveNode `json:"nodes"`
+	} `json:"state"`
+}
+
+// inputMapping maps an input to the Z-Wave value it sets
+type inputMapping struct {
+	dataType types.DataType
+	valueID  ZWaveValueID
+}
+
+// outputMapping maps a Z-Wave value to the output that has its value
+type outputMapping struct {
+	instance   string
+	nodeHWID   string
+	outputType types.OutputType
+}
+
+// ZWaveJS is an adapter that connects to the websocket API of a zwave-js-server. Z-Wave nodes are
+// published as nodes with their supported command class values as inputs and outputs. Set commands
+// of inputs are passed to the server. Outputs are updated when the server reports value changes.
+type ZWaveJS struct {
+	config      ZWaveJSConfig                  // server connection configuration
+	conn        *websocket.Conn                // connection to the server, nil when disconnected
+	hosted      *adapters.HostedAdapter        // host of the adapter, set on discovery
+	inputs      map[string]inputMapping        // Z-Wave value of inputs by input ID
+	messageID   int                            // ID of the last command sent
+	outputs     map[string]outputMapping       // output of Z-Wave values by value key
+	pending     map[string]chan *serverMessage // command results waiting to be received, by message ID
+	updateMutex *sync.Mutex                    // mutex for async receiving of server messages
+}
+
+// Discover connects to the server and creates the nodes, inputs and outputs of the Z-Wave nodes
+// This returns an error if the server can't be reached or doesn't provide its state.
+func (zwave *ZWaveJS) Discover(hosted *adapters.HostedAdapter) error {
+	zwave.updateMutex.Lock()
+	zwave.hosted = hosted
+	zwave.updateMutex.Unlock()
+	return zwave.connect()
+}
+
+// Poll reconnects to the server if the connection was lost. Values are updated by server events.
+func (zwave *ZWaveJS) Poll(hosted *adapters.HostedAdapter) error {
+	zwave.updateMutex.Lock()
+	isConnected := zwave.conn != nil
+	zwave.updateMutex.Unlock()
+	if isConnected {
+		return nil
+	}
+	return zwave.connect()
+}
+
+// SetInput sets the Z-Wave value of an input and waits for the server to accept it
+// This returns an error if the input isn't a Z-Wave value, the value is invalid or the server rejects it.
+func (zwave *ZWaveJS) SetInput(input *types.InputDiscoveryMessage, sender string, value string) error {
+	zwave.updateMutex.Lock()
+	mapping, found := zwave.inputs[input.InputID]
+	zwave.updateMutex.Unlock()
+	if !found {
+		return lib.MakeErrorf("SetInput: Input %s is not a Z-Wave value", input.Address)
+	}
+	nodeID, err := strconv.Atoi(strings.TrimPrefix(input.NodeHWID, zwave.config.NodePrefix))
+	if err != nil {
+		return lib.MakeErrorf("SetInput: Node %s of input %s is not a Z-Wave node", input.NodeHWID, input.Address)
+	}
+	setValue, err := parseInputValue(value, mapping.dataType)
+	if err != nil {
+		return lib.MakeErrorf("SetInput: Input %s: %s", input.Address, err)
+	}
+	_, err = zwave.sendCommand(map[string]interface{}{
+		"command": "node.set_value",
+		"nodeId":  nodeID,
+		"valueId": mapping.valueID,
+		"value":   setValue,
+	})
+	return err
+}
+
+// Stop closes the connection to the server
+func (zwave *ZWaveJS) Stop() {
+	zwave.updateMutex.Lock()
+	conn := zwave.conn
+	zwave.conn = nil
+	zwave.updateMutex.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// connect connects to the server, starts listening for events and registers the nodes of the server state
+func (zwave *ZWaveJS) connect() error {
+	conn, err := websocket.Dial(zwave.config.URL, "", "http://localhost/")
+	if err != nil {
+		return lib.MakeErrorf("connect: Unable to connect to zwave-js-server at %s: %s", zwave.config.URL, err)
+	}
+	zwave.updateMutex.Lock()
+	zwave.conn = conn
+	zwave.updateMutex.Unlock()
+	go zwave.receiveLoop(conn)
+
+	_, err = zwave.sendCommand(map[string]interface{}{
+		"command":       "set_api_schema",
+		"schemaVersion": zwave.config.SchemaVersion,
+	})
+	if err == nil {
+		var result *serverMessage
+		result, err = zwave.sendCommand(map[string]interface{}{"command": "start_listening"})
+		if err == nil {
+			state := serverState{}
+			err = json.Unmarshal(result.Result, &state)
+			if err == nil {
+				for i := range state.State.Nodes {
+					zwave.registerNode(&state.State.Nodes[i])
+				}
+				logrus.Infof("ZWaveJS.connect: Connected to %s with %d nodes", zwave.config.URL, len(state.State.Nodes))
+				return nil
+			}
+			err = lib.MakeErrorf("connect: Invalid server state: %s", err)
+		}
+	}
+	zwave.Stop()
+	return err
+}
+
+// handleEvent updates the outputs and nodes from a server event
+func (zwave *ZWaveJS) handleEvent(event *serverEvent) {
+	zwave.updateMutex.Lock()
+	hosted := zwave.hosted
+	zwave.updateMutex.Unlock()
+	if hosted == nil {
+		return
+	}
+	pub := hosted.Publisher()
+	nodeHWID := zwave.makeNodeHWID(event.NodeID)
+
+	switch event.Event {
+	case "value updated", "value notification":
+		if event.Args == nil {
+			return
+		}
+		newValue := event.Args.NewValue
+		if event.Event == "value notification" {
+			newValue = event.Args.Value
+		}
+		zwave.updateMutex.Lock()
+		mapping, found := zwave.outputs[makeValueKey(event.NodeID, &event.Args.ZWaveValueID)]
+		zwave.updateMutex.Unlock()
+		if found && newValue != nil {
+			pub.UpdateOutputValue(mapping.nodeHWID, mapping.outputType, mapping.instance, formatValue(newValue))
+		}
+	case "node added":
+		if event.Node != nil {
+			zwave.registerNode(event.Node)
+		}
+	case "node removed":
+		if event.Node != nil {
+			pub.DeleteNode(zwave.makeNodeHWID(event.Node.NodeID))
+		}
+	case "dead":
+		pub.UpdateNodeErrorStatus(nodeHWID, types.NodeRunStateLost, "Z-Wave node is dead")
+	case "sleep":
+		pub.UpdateNodeErrorStatus(nodeHWID, types.NodeRunStateSleeping, "")
+	case "alive", "wake up":
+		pub.UpdateNodeErrorStatus(nodeHWID, types.NodeRunStateReady, "")
+	}
+}
+
+// makeNodeHWID returns the hardware ID of a Z-Wave node
+func (zwave *ZWaveJS) makeNodeHWID(nodeID int) string {
+	return zwave.config.NodePrefix + strconv.Itoa(nodeID)
+}
+
+// receiveLoop receives the server messages until the connection closes
+// Command results are passed to the waiting command and events are handled.
+func (zwave *ZWaveJS) receiveLoop(conn *websocket.Conn) {
+	for {
+		message := &serverMessage{}
+		err := websocket.JSON.Receive(conn, message)
+		if err != nil {
+			logrus.Warningf("ZWaveJS.receiveLoop: Connection to %s closed: %s", zwave.config.URL, err)
+			break
+		}
+		switch message.Type {
+		case "version":
+			logrus.Infof("ZWaveJS.receiveLoop: Connected to zwave-js-server %s", message.ServerVersion)
+		case "result":
+			zwave.updateMutex.Lock()
+			resultChannel := zwave.pending[message.MessageID]
+			delete(zwave.pending, message.MessageID)
+			zwave.updateMutex.Unlock()
+			if resultChannel != nil {
+				resultChannel <- message
+			}
+		case "event":
+			if message.Event != nil {
+				zwave.handleEvent(message.Event)
+			}
+		}
+	}
+	zwave.updateMutex.Lock()
+	if zwave.conn == conn {
+		zwave.conn = nil
+	}
+	for messageID, resultChannel := range zwave.pending {
+		close(resultChannel)
+		delete(zwave.pending, messageID)
+	}
+	zwave.updateMutex.Unlock()
+}
+
+// registerNode creates the node and the inputs and outputs of its supported values
+func (zwave *ZWaveJS) registerNode(zwaveNode *ZWaveNode) {
+	zwave.updateMutex.Lock()
+	hosted := zwave.hosted
+	zwave.updateMutex.Unlock()
+	pub := hosted.Publisher()
+	nodeHWID := zwave.makeNodeHWID(zwaveNode.NodeID)
+
+	commandClasses := make([]string, 0)
+	nodeType := types.NodeTypeUnknown
+	for i := range zwaveNode.Values {
+		zwaveValue := &zwaveNode.Values[i]
+		if !containsString(commandClasses, zwaveValue.CommandClassName) {
+			commandClasses = append(commandClasses, zwaveValue.CommandClassName)
+		}
+		if nodeType == types.NodeTypeUnknown {
+			nodeType = getNodeType(zwaveValue.CommandClass)
+		}
+	}
+	if pub.GetNodeByHWID(nodeHWID) == nil {
+		pub.CreateNode(nodeHWID, nodeType)
+	}
+	attr := types.NodeAttrMap{
+		NodeAttrCommandClasses: strings.Join(commandClasses, ","),
+	}
+	if zwaveNode.Name != "" {
+		attr[types.NodeAttrName] = zwaveNode.Name
+	}
+	if zwaveNode.Location != "" {
+		attr[types.NodeAttrLocationName] = zwaveNode.Location
+	}
+	if zwaveNode.DeviceConfig != nil {
+		attr[types.NodeAttrManufacturer] = zwaveNode.DeviceConfig.Manufacturer
+		attr[types.NodeAttrModel] = zwaveNode.DeviceConfig.Label
+		attr[types.NodeAttrDescription] = zwaveNode.DeviceConfig.Description
+	}
+	pub.UpdateNodeAttr(nodeHWID, attr)
+
+	for i := range zwaveNode.Values {
+		zwave.registerValue(hosted, zwaveNode.NodeID, &zwaveNode.Values[i])
+	}
+}
+
+// registerValue creates the output of a readable value and the input of a writeable value
+// Values of unsupported command classes are ignored.
+func (zwave *ZWaveJS) registerValue(hosted *adapters.HostedAdapter, nodeID int, zwaveValue *ZWaveValue) {
+	pub := hosted.Publisher()
+	outputType, inputType := getValueTypes(zwaveValue)
+	nodeHWID := zwave.makeNodeHWID(nodeID)
+	instance := strconv.Itoa(zwaveValue.Endpoint)
+	if zwaveValue.PropertyKey != nil && zwaveValue.CommandClass != CommandClassMeter {
+		instance += "-" + fmt.Sprint(zwaveValue.PropertyKey)
+	}
+	dataType := types.DataTypeString
+	switch zwaveValue.Metadata.Type {
+	case "boolean":
+		dataType = types.DataTypeBool
+	case "number":
+		dataType = types.DataTypeNumber
+	}
+
+	if outputType != "" && zwaveValue.Metadata.Readable {
+		output := pub.CreateOutput(nodeHWID, outputType, instance)
+		output.DataType = dataType
+		output.Unit = valueUnits[zwaveValue.Metadata.Unit]
+		pub.UpdateOutput(output)
+		zwave.updateMutex.Lock()
+		zwave.outputs[makeValueKey(nodeID, &zwaveValue.ZWaveValueID)] = outputMapping{
+			instance:   instance,
+			nodeHWID:   nodeHWID,
+			outputType: outputType,
+		}
+		zwave.updateMutex.Unlock()
+		if zwaveValue.Value != nil {
+			pub.UpdateOutputValue(nodeHWID, outputType, instance, formatValue(zwaveValue.Value))
+		}
+	}
+	if inputType != "" && zwaveValue.Metadata.Writeable {
+		target := makeValueKey(nodeID, &zwaveValue.ZWaveValueID)
+		input := hosted.CreateInputWithTarget(nodeHWID, inputType, instance, target)
+		zwave.updateMutex.Lock()
+		zwave.inputs[input.InputID] = inputMapping{dataType: dataType, valueID: zwaveValue.ZWaveValueID}
+		zwave.updateMutex.Unlock()
+	}
+}
+
+// sendCommand sends a command to the server and waits for its result
+// This returns an error if the command can't be sent, times out or doesn't succeed.
+func (zwave *ZWaveJS) sendCommand(command map[string]interface{}) (*serverMessage, error) {
+	resultChannel := make(chan *serverMessage, 1)
+	zwave.updateMutex.Lock()
+	conn := zwave.conn
+	if conn == nil {
+		zwave.updateMutex.Unlock()
+		return nil, lib.MakeErrorf("sendCommand: Not connected to zwave-js-server at %s", zwave.config.URL)
+	}
+	zwave.messageID++
+	messageID := strconv.Itoa(zwave.messageID)
+	command["messageId"] = messageID
+	zwave.pending[messageID] = resultChannel
+	zwave.updateMutex.Unlock()
+
+	err := websocket.JSON.Send(conn, command)
+	if err != nil {
+		zwave.updateMutex.Lock()
+		delete(zwave.pending, messageID)
+		zwave.updateMutex.Unlock()
+		return nil, lib.MakeErrorf("sendCommand: Unable to send command %s: %s", command["command"], err)
+	}
+	select {
+	case result, isOpen := <-resultChannel:
+		if !isOpen {
+			return nil, lib.MakeErrorf("sendCommand: Connection closed before the result of command %s", command["command"])
+		} else if !result.Success {
+			return nil, lib.MakeErrorf("sendCommand: Command %s failed: %s", command["command"], result.ErrorCode)
+		}
+		return result, nil
+	case <-time.After(time.Duration(zwave.config.CommandTimeout) * time.Second):
+		zwave.updateMutex.Lock()
+		delete(zwave.pending, messageID)
+		zwave.updateMutex.Unlock()
+		return nil, lib.MakeErrorf("sendCommand: Timeout waiting for the result of command %s", command["command"])
+	}
+}
+
+// containsString returns true if the list contains the text
+func containsString(list []string, text string) bool {
+	for _, item := range list {
+		if item == text {
+			return true
+		}
+	}
+	return false
+}
+
+// formatValue returns the text of a Z-Wave value
+func formatValue(value interface{}) string {
+	switch typedValue := value.(type) {
+	case string:
+		return typedValue
+	case float64:
+		return strconv.FormatFloat(typedValue, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(typedValue)
+	}
+	valueJSON, _ := json.Marshal(value)
+	return string(valueJSON)
+}
+
+// getNodeType returns the node type of a node with a command class, or unknown
+func getNodeType(commandClass int) types.NodeType {
+	switch commandClass {
+	case CommandClassBinarySwitch:
+		return types.NodeTypeOnOffSwitch
+	case CommandClassMultilevelSwitch:
+		return types.NodeTypeDimmer
+	case CommandClassDoorLock:
+		return types.NodeTypeLock
+	case CommandClassMeter:
+		return types.NodeTypePowerMeter
+	case CommandClassBinarySensor, CommandClassMultilevelSensor:
+		return types.NodeTypeSensor
+	}
+	return types.NodeTypeUnknown
+}
+
+// getValueTypes returns the output and input types of a Z-Wave value
+// This returns "" for the types that the value isn't mapped to.
+func getValueTypes(zwaveValue *ZWaveValue) (types.OutputType, types.InputType) {
+	property := fmt.Sprint(zwaveValue.Property)
+	switch zwaveValue.CommandClass {
+	case CommandClassBinarySwitch:
+		if property == "currentValue" {
+			return types.OutputTypeSwitch, ""
+		} else if property == "targetValue" {
+			return "", types.InputTypeSwitch
+		}
+	case CommandClassMultilevelSwitch:
+		if property == "currentValue" {
+			return types.OutputTypeDimmer, ""
+		} else if property == "targetValue" {
+			return "", types.InputTypeDimmer
+		}
+	case CommandClassDoorLock:
+		if property == "currentMode" {
+			return types.OutputTypeLock, ""
+		} else if property == "targetMode" {
+			return "", types.InputTypeLock
+		}
+	case CommandClassBinarySensor:
+		if property == "Door/Window" {
+			return types.OutputTypeDoorWindowSensor, ""
+		} else if property == "Smoke" {
+			return types.OutputTypeSmokeDetector, ""
+		}
+		return types.OutputTypeMotion, ""
+	case CommandClassMultilevelSensor:
+		return multilevelSensorTypes[property], ""
+	case CommandClassMeter:
+		if property == "value" {
+			return meterUnitTypes[zwaveValue.Metadata.Unit], ""
+		}
+	case CommandClassBattery:
+		if property == "level" {
+			return types.OutputTypeBattery, ""
+		}
+	}
+	return "", ""
+}
+
+// makeValueKey returns the key of a value of a Z-Wave node
+func makeValueKey(nodeID int, valueID *ZWaveValueID) string {
+	propertyKey := ""
+	if valueID.PropertyKey != nil {
+		propertyKey = fmt.Sprint(valueID.PropertyKey)
+	}
+	return fmt.Sprintf("%d/%d/%d/%v/%s", nodeID, valueID.CommandClass, valueID.Endpoint, valueID.Property, propertyKey)
+}
+
+// parseInputValue converts the value of a set command to the data type of the input
+func parseInputValue(value string, dataType types.DataType) (interface{}, error) {
+	switch dataType {
+	case types.DataTypeBool:
+		switch strings.ToLower(value) {
+		case "true", "on", "1":
+			return true, nil
+		case "false", "off", "0":
+			return false, nil
+		}
+		return nil, lib.MakeErrorf("parseInputValue: Value '%s' is not a boolean", value)
+	case types.DataTypeNumber:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, lib.MakeErrorf("parseInputValue: Value '%s' is not a number", value)
+		}
+		return number, nil
+	}
+	return value, nil
+}
+
+// LoadZWaveJSConfig loads the zwave-js-server connection configuration from a YAML file
+//  configFolder containing the file. Use "" for the default config folder.
+//  filename of the YAML file with the configuration
+func LoadZWaveJSConfig(configFolder string, filename string) (*ZWaveJSConfig, error) {
+	config := &ZWaveJSConfig{}
+	err := lib.LoadYamlConfig(configFolder, filename, "", config)
+	if err != nil {
+		return nil, lib.MakeErrorf("LoadZWaveJSConfig: Unable to load configuration from %s: %s", filename, err)
+	}
+	return config, nil
+}
+
+// NewZWaveJS creates an adapter of the Z-Wave nodes of a zwave-js-server.
+// Add it to an adapter host to connect and create the nodes, inputs and outputs.
+//  config with the server connection. Empty fields use their default.
+func NewZWaveJS(config *ZWaveJSConfig) *ZWaveJS {
+	zwave := &ZWaveJS{
+		config:      *config,
+		inputs:      make(map[string]inputMapping),
+		outputs:     make(map[string]outputMapping),
+		pending:     make(map[string]chan *serverMessage),
+		updateMutex: &sync.Mutex{},
+	}
+	if zwave.config.CommandTimeout <= 0 {
+		zwave.config.CommandTimeout = DefaultCommandTimeout
+	}
+	if zwave.config.NodePrefix == "" {
+		zwave.config.NodePrefix = DefaultNodePrefix
+	}
+	if zwave.config.SchemaVersion <= 0 {
+		zwave.config.SchemaVersion = DefaultSchemaVersion
+	}
+	if zwave.config.URL == "" {
+		zwave.config.URL = DefaultURL
+	}
+	return zwave
+}
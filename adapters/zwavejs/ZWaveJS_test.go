@@ -0,0 +1,177 @@
+package zwavejs_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/adapters"
+	"github.com/iotdomain/iotdomain-go/adapters/zwavejs"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+var testConfig = &publisher.PublisherConfig{
+	ConfigFolder: "../../test",
+	CacheFolder:  "../../test",
+	Domain:       "test",
+	PublisherID:  "zwavejs1",
+}
+
+// testNodes is the server state with a switch with a power meter and a temperature sensor
+var testNodes = []zwavejs.ZWaveNode{
+	{
+		NodeID:       2,
+		Name:         "Hallway switch",
+		DeviceConfig: &zwavejs.ZWaveDeviceConfig{Manufacturer: "Aeotec", Label: "ZW096"},
+		Values: []zwavejs.ZWaveValue{
+			{
+				ZWaveValueID:     zwavejs.ZWaveValueID{CommandClass: 37, Property: "currentValue"},
+				CommandClassName: "Binary Switch",
+				Metadata:         zwavejs.ZWaveValueMetadata{Type: "boolean", Readable: true},
+				Value:            false,
+			},
+			{
+				ZWaveValueID:     zwavejs.ZWaveValueID{CommandClass: 37, Property: "targetValue"},
+				CommandClassName: "Binary Switch",
+				Metadata:         zwavejs.ZWaveValueMetadata{Type: "boolean", Readable: true, Writeable: true},
+			},
+			{
+				ZWaveValueID:     zwavejs.ZWaveValueID{CommandClass: 50, Property: "value", PropertyKey: 66049},
+				CommandClassName: "Meter",
+				Metadata:         zwavejs.ZWaveValueMetadata{Type: "number", Readable: true, Unit: "W"},
+				Value:            12.5,
+			},
+		},
+	},
+	{
+		NodeID: 3,
+		Values: []zwavejs.ZWaveValue{
+			{
+				ZWaveValueID:     zwavejs.ZWaveValueID{CommandClass: 49, Property: "Air temperature"},
+				CommandClassName: "Multilevel Sensor",
+				Metadata:         zwavejs.ZWaveValueMetadata{Type: "number", Readable: true, Unit: "°C"},
+				Value:            21.5,
+			},
+			{
+				ZWaveValueID:     zwavejs.ZWaveValueID{CommandClass: 112, Property: 1},
+				CommandClassName: "Configuration",
+				Metadata:         zwavejs.ZWaveValueMetadata{Type: "number", Readable: true, Writeable: true},
+				Value:            1,
+			},
+		},
+	},
+}
+
+// testServer simulates the websocket API of zwave-js-server
+type testServer struct {
+	conn      chan *websocket.Conn
+	setValues chan map[string]interface{}
+}
+
+func (server *testServer) handle(conn *websocket.Conn) {
+	websocket.JSON.Send(conn, map[string]interface{}{"type": "version", "serverVersion": "1.0.0"})
+	server.conn <- conn
+	for {
+		command := make(map[string]interface{})
+		if err := websocket.JSON.Receive(conn, &command); err != nil {
+			return
+		}
+		result := map[string]interface{}{"type": "result", "messageId": command["messageId"], "success": true}
+		switch command["command"] {
+		case "start_listening":
+			result["result"] = map[string]interface{}{
+				"state": map[string]interface{}{"nodes": testNodes},
+			}
+		case "node.set_value":
+			server.setValues <- command
+		}
+		websocket.JSON.Send(conn, result)
+	}
+}
+
+func TestZWaveJS(t *testing.T) {
+	server := &testServer{conn: make(chan *websocket.Conn, 2), setValues: make(chan map[string]interface{}, 2)}
+	httpServer := httptest.NewServer(websocket.Handler(server.handle))
+	defer httpServer.Close()
+
+	pub := publisher.NewPublisher(testConfig, messaging.NewDummyMessenger(&messaging.MessengerConfig{}))
+	host := adapters.NewAdapterHost(pub)
+	zwave := zwavejs.NewZWaveJS(&zwavejs.ZWaveJSConfig{URL: "ws" + strings.TrimPrefix(httpServer.URL, "http")})
+	err := host.AddAdapter("zwavejs", zwave)
+	require.NoError(t, err)
+	serverConn := <-server.conn
+
+	// nodes with their command classes, outputs and inputs
+	node := pub.GetNodeByHWID("zwave2")
+	require.NotNil(t, node)
+	assert.Equal(t, string(types.NodeTypeOnOffSwitch), node.Attr[types.NodeAttrType])
+	assert.Equal(t, "Binary Switch,Meter", node.Attr[zwavejs.NodeAttrCommandClasses])
+	assert.Equal(t, "Aeotec", node.Attr[types.NodeAttrManufacturer])
+	value := pub.GetOutputValueByNodeHWID("zwave2", types.OutputTypeSwitch, "0")
+	require.NotNil(t, value)
+	assert.Equal(t, "false", value.Value)
+	value = pub.GetOutputValueByNodeHWID("zwave2", types.OutputTypeElectricPower, "0")
+	require.NotNil(t, value)
+	assert.Equal(t, "12.5", value.Value)
+	output := pub.GetOutputByNodeHWID("zwave3", types.OutputTypeTemperature, "0")
+	require.NotNil(t, output)
+	assert.Equal(t, types.UnitCelcius, output.Unit)
+	assert.Len(t, pub.GetOutputs(), 3)
+	input := pub.GetInputByNodeHWID("zwave2", types.InputTypeSwitch, "0")
+	require.NotNil(t, input)
+
+	// set commands are passed to the server with the value of the value's type
+	err = zwave.SetInput(input, "", "on")
+	require.NoError(t, err)
+	setValue := <-server.setValues
+	assert.Equal(t, true, setValue["value"])
+	assert.Equal(t, "targetValue", setValue["valueId"].(map[string]interface{})["property"])
+
+	// value updates from the server update the outputs
+	websocket.JSON.Send(serverConn, map[string]interface{}{
+		"type": "event",
+		"event": map[string]interface{}{
+			"source": "node", "event": "value updated", "nodeId": 2,
+			"args": map[string]interface{}{"commandClass": 37, "endpoint": 0, "property": "currentValue", "newValue": true},
+		},
+	})
+	assert.Eventually(t, func() bool {
+		value := pub.GetOutputValueByNodeHWID("zwave2", types.OutputTypeSwitch, "0")
+		return value != nil && value.Value == "true"
+	}, time.Second, 10*time.Millisecond)
+
+	// dead nodes are lost
+	websocket.JSON.Send(serverConn, map[string]interface{}{
+		"type":  "event",
+		"event": map[string]interface{}{"source": "node", "event": "dead", "nodeId": 3},
+	})
+	assert.Eventually(t, func() bool {
+		return pub.GetNodeByHWID("zwave3").Status[types.NodeStatusRunState] == types.NodeRunStateLost
+	}, time.Second, 10*time.Millisecond)
+
+	// error cases - invalid values and unknown inputs
+	assert.Error(t, zwave.SetInput(input, "", "maybe"))
+	unknownInput := &types.InputDiscoveryMessage{InputID: "zwave3/switch/0", NodeHWID: "zwave3"}
+	assert.Error(t, zwave.SetInput(unknownInput, "", "on"))
+
+	// poll reconnects after the connection is lost
+	serverConn.Close()
+	assert.Eventually(t, func() bool {
+		return zwave.Poll(nil) == nil && len(server.conn) > 0
+	}, time.Second, 10*time.Millisecond)
+	<-server.conn
+	assert.NoError(t, zwave.SetInput(input, "", "off"))
+	setValue = <-server.setValues
+	assert.Equal(t, false, setValue["value"])
+	zwave.Stop()
+
+	// error case - no server
+	noServer := zwavejs.NewZWaveJS(&zwavejs.ZWaveJSConfig{URL: "ws://localhost:1", CommandTimeout: 1})
+	assert.Error(t, host.AddAdapter("noserver", noServer))
+}
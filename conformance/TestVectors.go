@@ -0,0 +1,390 @@
+// Package conformance with test vectors of signed messages for validating other implementations
+package conformance
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// VectorsFileSuffix to append to the name of the sample in the test vectors file, eg identity-vectors.json
+const VectorsFileSuffix = "-vectors.json"
+
+// Fixed values of the sample messages so the payloads are canonical
+const (
+	vectorDomain      = "test"
+	vectorNodeID      = "node1"
+	vectorPublisherID = "publisher1"
+	vectorSender      = "test/publisher2/node2"
+	vectorTimestamp   = "2020-01-01T00:00:00.000+0000"
+	vectorValidUntil  = "2120-01-01T00:00:00.000+0000"
+)
+
+// TestVector is a sample message with the expected verification result.
+// Signatures aren't deterministic so each generation produces different messages with the same payload.
+// Commands are JWE encrypted to the receiving publisher, whose private key is included to decrypt them.
+type TestVector struct {
+	Address         string `json:"address"`                   // publication address of the message
+	DecryptionKey   string `json:"decryptionKey,omitempty"`   // private key in PEM format of the receiver, messages that must be encrypted only
+	Description     string `json:"description"`               // what is tested with this vector
+	Encrypted       bool   `json:"encrypted"`                 // the message is JWE encrypted to the receiver
+	IdentityPayload string `json:"identityPayload,omitempty"` // canonical JSON the identity signature is computed over, identities only
+	Message         string `json:"message"`                   // the message as published, JWS compact serialization if signed
+	MessageType     string `json:"messageType"`               // message type of the address, eg $node
+	Payload         string `json:"payload"`                   // JSON payload of the message
+	PublicKey       string `json:"publicKey"`                 // public key in PEM format to verify the message with
+	Signed          bool   `json:"signed"`                    // the message is JWS signed
+	Valid           bool   `json:"valid"`                     // the message passes verification with the public key
+}
+
+// vectorSample is a message to create test vectors for
+type vectorSample struct {
+	address     string
+	messageType string
+	// create the message object signed by the given key. Only identities contain the key.
+	newMessage func(key *ecdsa.PrivateKey) interface{}
+}
+
+// GenerateTestVectors creates the test vectors of the identity, node, output, set and configure samples.
+// For each sample the vectors contain messages signed with ES256 and ES384, a message whose payload is
+// modified after signing, a message signed with another key and an unsigned message. The set and
+// configure commands are encrypted to the receiving publisher, and also have a vector of a signed
+// command that isn't encrypted.
+// This returns the test vectors by sample name.
+func GenerateTestVectors() (map[string][]TestVector, error) {
+	samples := map[string][]vectorSample{
+		"identity":  {identitySample()},
+		"node":      {nodeSample()},
+		"output":    {outputSample(), latestSample()},
+		"set":       {setSample()},
+		"configure": {configureSample()},
+	}
+	es256Key := messaging.CreateAsymKeys()
+	es384Key := messaging.CreateAsymKeysWithCurve(elliptic.P384())
+	otherKey := messaging.CreateAsymKeys()
+	// commands are only accepted when encrypted to the receiving publisher
+	receiverKey := messaging.CreateAsymKeys()
+	encryptedSamples := map[string]bool{"set": true, "configure": true}
+
+	vectorsByName := make(map[string][]TestVector)
+	for name, sampleList := range samples {
+		vectors := make([]TestVector, 0)
+		for _, sample := range sampleList {
+			var sampleReceiverKey *ecdsa.PrivateKey
+			if encryptedSamples[name] {
+				sampleReceiverKey = receiverKey
+			}
+			sampleVectors, err := makeTestVectors(sample, es256Key, es384Key, otherKey, sampleReceiverKey)
+			if err != nil {
+				return nil, err
+			}
+			vectors = append(vectors, sampleVectors...)
+		}
+		vectorsByName[name] = vectors
+	}
+	return vectorsByName, nil
+}
+
+// LoadTestVectors loads the test vectors from a test vectors file
+func LoadTestVectors(filename string) ([]TestVector, error) {
+	vectorsJSON, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, lib.MakeErrorf("LoadTestVectors: Unable to open file %s: %s", filename, err)
+	}
+	vectors := make([]TestVector, 0)
+	err = json.Unmarshal(vectorsJSON, &vectors)
+	if err != nil {
+		return nil, lib.MakeErrorf("LoadTestVectors: Error parsing JSON file %s: %v", filename, err)
+	}
+	return vectors, nil
+}
+
+// VerifyTestVector verifies the message of a test vector with this library.
+// A signed message is valid if its JWS signature verifies with the public key and contains the payload.
+// If the vector has a decryption key then the message is only valid if it is encrypted, as receivers
+// reject commands that aren't encrypted. It is decrypted before its signature is verified.
+// An identity is only valid if its identity signature also verifies over the identity payload.
+// Unsigned messages are not valid as they are rejected in secured domains.
+// This returns an error if the verification result differs from the expected result.
+func VerifyTestVector(vector *TestVector) error {
+	var err error
+	message := vector.Message
+	if vector.DecryptionKey != "" {
+		var isEncrypted bool
+		message, isEncrypted, err = messaging.DecryptMessage(vector.Message, messaging.PrivateKeyFromPem(vector.DecryptionKey))
+		if !isEncrypted {
+			err = lib.MakeErrorf("Message is not encrypted")
+		}
+	}
+	publicKey := messaging.PublicKeyFromPem(vector.PublicKey)
+	payload := ""
+	if err == nil {
+		payload, err = messaging.VerifyJWSMessage(message, publicKey)
+	}
+	if err == nil && payload != vector.Payload {
+		err = lib.MakeErrorf("Payload differs from the message payload")
+	}
+	if err == nil && vector.MessageType == types.MessageTypeIdentity {
+		var ident types.PublisherIdentityMessage
+		err = json.Unmarshal([]byte(payload), &ident)
+		if err == nil && canonicalIdentity(&ident) != vector.IdentityPayload {
+			err = lib.MakeErrorf("Identity payload differs from the canonical identity")
+		} else if err == nil {
+			err = messaging.VerifyIdentitySignature(&ident, publicKey)
+		}
+	}
+	isValid := err == nil
+	if isValid != vector.Valid {
+		return lib.MakeErrorf("VerifyTestVector: Vector '%s' of %s has verification result %t instead of %t: %v",
+			vector.Description, vector.Address, isValid, vector.Valid, err)
+	}
+	return nil
+}
+
+// WriteTestVectors generates the test vectors and writes them as golden files to the given folder,
+// one file per sample named <sample>-vectors.json. Existing files are overwritten.
+func WriteTestVectors(folder string) error {
+	vectorsByName, err := GenerateTestVectors()
+	if err != nil {
+		return err
+	}
+	for name, vectors := range vectorsByName {
+		vectorsJSON, err := json.MarshalIndent(vectors, "", "  ")
+		if err != nil {
+			return lib.MakeErrorf("WriteTestVectors: Error marshalling vectors of %s: %s", name, err)
+		}
+		filename := filepath.Join(folder, name+VectorsFileSuffix)
+		err = ioutil.WriteFile(filename, vectorsJSON, 0644)
+		if err != nil {
+			return lib.MakeErrorf("WriteTestVectors: Error writing file %s: %s", filename, err)
+		}
+	}
+	return nil
+}
+
+// canonicalIdentity returns the JSON of the identity that the identity signature is computed over
+func canonicalIdentity(ident *types.PublisherIdentityMessage) string {
+	identCopy := *ident
+	identCopy.IdentitySignature = ""
+	payload, _ := json.Marshal(identCopy)
+	return string(payload)
+}
+
+// makeTestVectors creates the test vectors of a sample
+//  receiverKey is the key of the receiver the messages are encrypted to. nil to not encrypt the messages.
+func makeTestVectors(sample vectorSample, es256Key *ecdsa.PrivateKey, es384Key *ecdsa.PrivateKey,
+	otherKey *ecdsa.PrivateKey, receiverKey *ecdsa.PrivateKey) ([]TestVector, error) {
+
+	vectors := make([]TestVector, 0)
+	for _, signed := range []struct {
+		description string
+		signingKey  *ecdsa.PrivateKey
+		senderKey   *ecdsa.PrivateKey
+		modify      func(message string) string
+		valid       bool
+	}{
+		{"signed with ES256", es256Key, es256Key, nil, true},
+		{"signed with ES384", es384Key, es384Key, nil, true},
+		{"payload modified after signing", es256Key, es256Key, modifyJWSPayload, false},
+		{"signed with another key than the sender's", otherKey, es256Key, nil, false},
+	} {
+		payload, _ := json.Marshal(sample.newMessage(signed.senderKey))
+		message, err := messaging.CreateJWSSignature(string(payload), signed.signingKey)
+		if err != nil {
+			return nil, lib.MakeErrorf("makeTestVectors: Error signing %s: %s", sample.address, err)
+		}
+		if signed.modify != nil {
+			message = signed.modify(message)
+		}
+		vector, err := sample.newVector(signed.description, message, string(payload),
+			signed.senderKey, receiverKey, true, signed.valid)
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, vector)
+	}
+	payload, _ := json.Marshal(sample.newMessage(es256Key))
+	vector, err := sample.newVector("unsigned", string(payload), string(payload),
+		es256Key, receiverKey, false, false)
+	if err != nil {
+		return nil, err
+	}
+	vectors = append(vectors, vector)
+
+	// receivers reject messages that must be encrypted and aren't
+	if receiverKey != nil {
+		message, err := messaging.CreateJWSSignature(string(payload), es256Key)
+		if err != nil {
+			return nil, lib.MakeErrorf("makeTestVectors: Error signing %s: %s", sample.address, err)
+		}
+		vector := sample.newVectorFromMessage("signed with ES256 and not encrypted", message, string(payload),
+			es256Key, true, false)
+		vector.DecryptionKey = messaging.PrivateKeyToPem(receiverKey)
+		vectors = append(vectors, vector)
+	}
+
+	// the identity signature covers the identity itself
+	if sample.messageType == types.MessageTypeIdentity {
+		ident := sample.newMessage(es256Key).(*types.PublisherIdentityMessage)
+		ident.Location = "modified"
+		payload, _ = json.Marshal(ident)
+		message, err := messaging.CreateJWSSignature(string(payload), es256Key)
+		if err != nil {
+			return nil, lib.MakeErrorf("makeTestVectors: Error signing %s: %s", sample.address, err)
+		}
+		vectors = append(vectors, sample.newVectorFromMessage("identity modified after the identity signature",
+			message, string(payload), es256Key, true, false))
+	}
+	return vectors, nil
+}
+
+// modifyJWSPayload replaces the payload of a JWS compact serialized message without signing it again
+func modifyJWSPayload(message string) string {
+	parts := strings.Split(message, ".")
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	modified := strings.Replace(string(payload), vectorTimestamp, "2020-01-02T00:00:00.000+0000", 1)
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(modified))
+	return strings.Join(parts, ".")
+}
+
+// newVector creates a test vector of the sample for the given message, encrypted to the receiver
+//  receiverKey is the key of the receiver to encrypt the message to. nil to not encrypt the message.
+func (sample vectorSample) newVector(description string, message string, payload string,
+	senderKey *ecdsa.PrivateKey, receiverKey *ecdsa.PrivateKey, signed bool, valid bool) (TestVector, error) {
+
+	if receiverKey == nil {
+		return sample.newVectorFromMessage(description, message, payload, senderKey, signed, valid), nil
+	}
+	encrypted, err := messaging.EncryptMessage(message, &receiverKey.PublicKey)
+	if err != nil {
+		return TestVector{}, lib.MakeErrorf("newVector: Error encrypting %s: %s", sample.address, err)
+	}
+	vector := sample.newVectorFromMessage(description, encrypted, payload, senderKey, signed, valid)
+	vector.DecryptionKey = messaging.PrivateKeyToPem(receiverKey)
+	vector.Encrypted = true
+	return vector, nil
+}
+
+// newVectorFromMessage creates a test vector of the sample for the message as published
+func (sample vectorSample) newVectorFromMessage(description string, message string, payload string,
+	senderKey *ecdsa.PrivateKey, signed bool, valid bool) TestVector {
+
+	vector := TestVector{
+		Address:     sample.address,
+		Description: description,
+		Message:     message,
+		MessageType: sample.messageType,
+		Payload:     payload,
+		PublicKey:   messaging.PublicKeyToPem(&senderKey.PublicKey),
+		Signed:      signed,
+		Valid:       valid,
+	}
+	if sample.messageType == types.MessageTypeIdentity {
+		var ident types.PublisherIdentityMessage
+		json.Unmarshal([]byte(payload), &ident)
+		vector.IdentityPayload = canonicalIdentity(&ident)
+	}
+	return vector
+}
+
+// configureSample is a node configure command
+func configureSample() vectorSample {
+	address := nodes.MakeNodeConfigureAddress(vectorDomain, vectorPublisherID, vectorNodeID)
+	return vectorSample{address, types.MessageTypeConfigure, func(key *ecdsa.PrivateKey) interface{} {
+		return &types.NodeConfigureMessage{
+			Address:   address,
+			Attr:      types.NodeAttrMap{types.NodeAttrName: "kitchen"},
+			Sender:    vectorSender,
+			Timestamp: vectorTimestamp,
+		}
+	}}
+}
+
+// identitySample is a self-signed publisher identity
+func identitySample() vectorSample {
+	address := identities.MakePublisherIdentityAddress(vectorDomain, vectorPublisherID)
+	return vectorSample{address, types.MessageTypeIdentity, func(key *ecdsa.PrivateKey) interface{} {
+		ident := &types.PublisherIdentityMessage{
+			Address:      address,
+			Domain:       vectorDomain,
+			IssuerID:     vectorPublisherID,
+			Location:     "local",
+			Organization: "iotdomain",
+			PublicKey:    messaging.PublicKeyToPem(&key.PublicKey),
+			PublisherID:  vectorPublisherID,
+			Timestamp:    vectorTimestamp,
+			ValidUntil:   vectorValidUntil,
+		}
+		messaging.SignIdentity(ident, key)
+		return ident
+	}}
+}
+
+// latestSample is the latest value of an output
+func latestSample() vectorSample {
+	address := outputs.MakeOutputDiscoveryAddress(vectorDomain, vectorPublisherID, vectorNodeID,
+		types.OutputTypeTemperature, types.DefaultOutputInstance)
+	address = outputs.ReplaceMessageType(address, types.MessageTypeLatest)
+	return vectorSample{address, types.MessageTypeLatest, func(key *ecdsa.PrivateKey) interface{} {
+		return &types.OutputLatestMessage{
+			Address:   address,
+			Timestamp: vectorTimestamp,
+			Unit:      types.UnitCelcius,
+			Value:     "21.5",
+		}
+	}}
+}
+
+// nodeSample is a node discovery
+func nodeSample() vectorSample {
+	address := nodes.MakeNodeDiscoveryAddress(vectorDomain, vectorPublisherID, vectorNodeID)
+	return vectorSample{address, types.MessageTypeNodeDiscovery, func(key *ecdsa.PrivateKey) interface{} {
+		return &types.NodeDiscoveryMessage{
+			Address:   address,
+			Attr:      types.NodeAttrMap{types.NodeAttrName: "kitchen", types.NodeAttrType: string(types.NodeTypeMultisensor)},
+			HWID:      vectorNodeID,
+			NodeID:    vectorNodeID,
+			Timestamp: vectorTimestamp,
+		}
+	}}
+}
+
+// outputSample is an output discovery
+func outputSample() vectorSample {
+	address := outputs.MakeOutputDiscoveryAddress(vectorDomain, vectorPublisherID, vectorNodeID,
+		types.OutputTypeTemperature, types.DefaultOutputInstance)
+	return vectorSample{address, types.MessageTypeOutputDiscovery, func(key *ecdsa.PrivateKey) interface{} {
+		return &types.OutputDiscoveryMessage{
+			Address:   address,
+			DataType:  types.DataTypeNumber,
+			Timestamp: vectorTimestamp,
+			Unit:      types.UnitCelcius,
+		}
+	}}
+}
+
+// setSample is a set input command
+func setSample() vectorSample {
+	address := inputs.MakeSetInputAddress(vectorDomain, vectorPublisherID, vectorNodeID,
+		types.InputTypeSwitch, types.DefaultInputInstance)
+	return vectorSample{address, types.MessageTypeSetInput, func(key *ecdsa.PrivateKey) interface{} {
+		return &types.SetInputMessage{
+			Address:   address,
+			Sender:    vectorSender,
+			Timestamp: vectorTimestamp,
+			Value:     "on",
+		}
+	}}
+}
@@ -0,0 +1,62 @@
+package conformance_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/conformance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const goldenFolder = "vectors"
+
+var sampleNames = []string{"configure", "identity", "node", "output", "set"}
+
+func TestGenerateTestVectors(t *testing.T) {
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+
+	err := conformance.WriteTestVectors(tempFolder)
+	require.NoError(t, err)
+	for _, name := range sampleNames {
+		vectors, err := conformance.LoadTestVectors(filepath.Join(tempFolder, name+conformance.VectorsFileSuffix))
+		require.NoError(t, err)
+		assert.NotEmpty(t, vectors)
+		for _, vector := range vectors {
+			assert.NoError(t, conformance.VerifyTestVector(&vector))
+		}
+	}
+
+	// valid commands are encrypted to the receiving publisher
+	vectors, _ := conformance.LoadTestVectors(filepath.Join(tempFolder, "set"+conformance.VectorsFileSuffix))
+	for _, vector := range vectors {
+		assert.NotEmpty(t, vector.DecryptionKey)
+		assert.True(t, vector.Encrypted || !vector.Valid)
+	}
+
+	// error case - a vector with the wrong expected result
+	vectors, _ = conformance.LoadTestVectors(filepath.Join(tempFolder, "node"+conformance.VectorsFileSuffix))
+	vectors[0].Valid = !vectors[0].Valid
+	assert.Error(t, conformance.VerifyTestVector(&vectors[0]))
+
+	// error case - missing or invalid file
+	_, err = conformance.LoadTestVectors(filepath.Join(tempFolder, "notafile.json"))
+	assert.Error(t, err)
+	ioutil.WriteFile(filepath.Join(tempFolder, "invalid.json"), []byte("not json"), 0644)
+	_, err = conformance.LoadTestVectors(filepath.Join(tempFolder, "invalid.json"))
+	assert.Error(t, err)
+}
+
+// The golden files must keep verifying as other implementations validate against them
+func TestGoldenVectors(t *testing.T) {
+	for _, name := range sampleNames {
+		vectors, err := conformance.LoadTestVectors(filepath.Join(goldenFolder, name+conformance.VectorsFileSuffix))
+		require.NoError(t, err)
+		for _, vector := range vectors {
+			assert.NoError(t, conformance.VerifyTestVector(&vector))
+		}
+	}
+}
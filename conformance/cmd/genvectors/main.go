@@ -0,0 +1,23 @@
+// Package main with the generator of the conformance test vector golden files
+//  usage: genvectors [folder]
+// The default folder is the current folder.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/iotdomain/iotdomain-go/conformance"
+)
+
+func main() {
+	folder := "."
+	if len(os.Args) > 1 {
+		folder = os.Args[1]
+	}
+	err := conformance.WriteTestVectors(folder)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
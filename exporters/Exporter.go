@@ -0,0 +1,251 @@
+// Package exporters with forwarding of domain publications to external analytics pipelines
+package exporters
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/lib/backoff"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Default exporter settings
+const (
+	DefaultBatchSize        = 100 // max nr of records in a batch
+	DefaultBatchIntervalSec = 10  // max seconds a record waits before its batch is sent
+	MaxBufferedBatches      = 10  // nr of batches that are kept when the sink fails
+)
+
+// sendBackoff is the retry policy of sending a batch to the sink before it is requeued
+var sendBackoff = backoff.NewBackoff(100*time.Millisecond, time.Second, 3)
+
+// DefaultExportAddresses are the publications that are exported by default: output values and node events
+// Alarms are published as values of outputs of type alarm.
+var DefaultExportAddresses = []string{
+	"+/+/+/+/+/" + types.MessageTypeLatest,
+	"+/+/+/" + types.MessageTypeEvent,
+}
+
+// KeyMapping determines the record key of exported publications
+type KeyMapping string
+
+// Available key mappings
+const (
+	KeyMappingAddress   KeyMapping = "address"   // full publication address (default)
+	KeyMappingNode      KeyMapping = "node"      // domain/publisherID/nodeID
+	KeyMappingOutput    KeyMapping = "output"    // address without the message type
+	KeyMappingPublisher KeyMapping = "publisher" // domain/publisherID
+)
+
+// ExportRecord with a publication to export
+type ExportRecord struct {
+	Address     string            // address the message was published on
+	Key         string            // record key from the key mapping
+	MessageType types.MessageType // message type from the address
+	Payload     string            // JSON message, without signature
+}
+
+// ISink interface of destinations that exporters send records to
+type ISink interface {
+	// Send a batch of records
+	Send(records []ExportRecord) error
+}
+
+// ExporterConfig with the selection and batching of exported publications
+type ExporterConfig struct {
+	Addresses        []string   `yaml:"addresses,omitempty"`        // subscription addresses to export. Default is DefaultExportAddresses
+	BatchIntervalSec int        `yaml:"batchIntervalSec,omitempty"` // max seconds before a batch is sent. Default is DefaultBatchIntervalSec
+	BatchSize        int        `yaml:"batchSize,omitempty"`        // max nr of records in a batch. Default is DefaultBatchSize
+	KeyMapping       KeyMapping `yaml:"keyMapping,omitempty"`       // record key mapping. Default is address
+}
+
+// Exporter subscribes to domain publications and sends them in batches to a sink.
+// Signed publications are only exported if their signature verifies.
+type Exporter struct {
+	batch          []ExportRecord           // records waiting to be sent
+	config         ExporterConfig           // selection and batching
+	flushCountdown int                      // seconds until the batch is sent
+	flushMutex     *sync.Mutex              // mutex to send batches in order
+	flushPending   bool                     // a background flush of a full batch is pending
+	isRunning      bool                     // the exporter is started
+	messageSigner  *messaging.MessageSigner // subscription to publications
+	sink           ISink                    // destination of exported records
+	updateMutex    *sync.Mutex              // mutex for async updating of the batch
+}
+
+// Flush sends the waiting records to the sink
+// Failed sends are retried a few times. If the sink keeps failing the records are kept until the
+// buffer is full. Concurrent flushes send their batches one at a time.
+func (exporter *Exporter) Flush() error {
+	exporter.flushMutex.Lock()
+	defer exporter.flushMutex.Unlock()
+	exporter.updateMutex.Lock()
+	batch := exporter.batch
+	exporter.batch = make([]ExportRecord, 0)
+	exporter.flushCountdown = exporter.config.BatchIntervalSec
+	exporter.flushPending = false
+	exporter.updateMutex.Unlock()
+
+	for len(batch) > 0 {
+		count := len(batch)
+		if count > exporter.config.BatchSize {
+			count = exporter.config.BatchSize
+		}
+		err := sendBackoff.Retry(context.Background(), func(attempt int) error {
+			return exporter.sink.Send(batch[:count])
+		})
+		if err != nil {
+			exporter.requeue(batch)
+			return lib.MakeErrorf("Exporter.Flush: Failed sending %d records: %s", len(batch), err)
+		}
+		batch = batch[count:]
+	}
+	return nil
+}
+
+// Start subscribing to the publications to export
+func (exporter *Exporter) Start() {
+	exporter.updateMutex.Lock()
+	defer exporter.updateMutex.Unlock()
+	if exporter.isRunning {
+		return
+	}
+	exporter.isRunning = true
+	for _, address := range exporter.config.Addresses {
+		exporter.messageSigner.Subscribe(address, exporter.receivePublication)
+	}
+	go exporter.flushLoop()
+}
+
+// Stop subscribing and send the remaining records
+func (exporter *Exporter) Stop() {
+	exporter.updateMutex.Lock()
+	if !exporter.isRunning {
+		exporter.updateMutex.Unlock()
+		return
+	}
+	exporter.isRunning = false
+	for _, address := range exporter.config.Addresses {
+		exporter.messageSigner.Unsubscribe(address, exporter.receivePublication)
+	}
+	exporter.updateMutex.Unlock()
+	exporter.Flush()
+}
+
+// flushLoop sends the batch when the batch interval has passed
+func (exporter *Exporter) flushLoop() {
+	for {
+		time.Sleep(time.Second)
+		exporter.updateMutex.Lock()
+		isRunning := exporter.isRunning
+		exporter.flushCountdown--
+		flushNow := exporter.flushCountdown <= 0 && len(exporter.batch) > 0
+		exporter.updateMutex.Unlock()
+		if !isRunning {
+			break
+		}
+		if flushNow {
+			exporter.Flush()
+		}
+	}
+}
+
+// receivePublication verifies a publication and adds it to the batch
+func (exporter *Exporter) receivePublication(address string, message string) error {
+	// the publication address identifies the publisher whose signature to verify
+	var header struct {
+		Address string `json:"address"`
+	}
+	isSigned, err := exporter.messageSigner.VerifySignedMessage(message, &header)
+	if err != nil {
+		return lib.MakeErrorf("receivePublication: Publication on %s fails verification: %s. Not exported", address, err)
+	} else if !isSigned && !exporter.messageSigner.IsUnsignedAllowed(address) {
+		return lib.MakeErrorf("receivePublication: Publication on %s isn't signed. Not exported", address)
+	}
+	payload, _ := messaging.JWSPayload(message)
+	record := ExportRecord{
+		Address:     address,
+		Key:         MakeRecordKey(address, exporter.config.KeyMapping),
+		MessageType: types.MessageType(address[strings.LastIndex(address, "/")+1:]),
+		Payload:     payload,
+	}
+
+	exporter.updateMutex.Lock()
+	exporter.batch = append(exporter.batch, record)
+	flushNow := len(exporter.batch) >= exporter.config.BatchSize && !exporter.flushPending
+	if flushNow {
+		exporter.flushPending = true
+	}
+	exporter.updateMutex.Unlock()
+	// the retry backoff of the flush must not hold up the handling of publications
+	if flushNow {
+		go exporter.Flush()
+	}
+	return nil
+}
+
+// requeue puts records that failed to send back in front of the batch, dropping the oldest
+// records when the buffer is full
+func (exporter *Exporter) requeue(records []ExportRecord) {
+	exporter.updateMutex.Lock()
+	defer exporter.updateMutex.Unlock()
+	exporter.batch = append(records, exporter.batch...)
+	maxRecords := exporter.config.BatchSize * MaxBufferedBatches
+	if len(exporter.batch) > maxRecords {
+		dropCount := len(exporter.batch) - maxRecords
+		logrus.Warningf("Exporter.requeue: Buffer is full. Dropping %d records", dropCount)
+		exporter.batch = exporter.batch[dropCount:]
+	}
+}
+
+// MakeRecordKey returns the record key of a publication address using the key mapping
+//  address is of the form domain/publisherID/nodeID[/outputType/instance]/messageType
+func MakeRecordKey(address string, keyMapping KeyMapping) string {
+	segments := strings.Split(address, "/")
+	switch keyMapping {
+	case KeyMappingNode:
+		if len(segments) > 3 {
+			return strings.Join(segments[:3], "/")
+		}
+	case KeyMappingOutput:
+		if len(segments) > 1 {
+			return strings.Join(segments[:len(segments)-1], "/")
+		}
+	case KeyMappingPublisher:
+		if len(segments) > 2 {
+			return strings.Join(segments[:2], "/")
+		}
+	}
+	return address
+}
+
+// NewExporter creates an exporter of domain publications to a sink. Use Start() to start exporting.
+//  config selects the publications to export and their batching. Defaults are used for missing values.
+//  sink is the destination, eg a Pub/Sub or Kafka sink
+//  messageSigner to subscribe and verify the publications
+func NewExporter(config *ExporterConfig, sink ISink, messageSigner *messaging.MessageSigner) *Exporter {
+	exporter := &Exporter{
+		batch:         make([]ExportRecord, 0),
+		config:        *config,
+		flushMutex:    &sync.Mutex{},
+		messageSigner: messageSigner,
+		sink:          sink,
+		updateMutex:   &sync.Mutex{},
+	}
+	if len(exporter.config.Addresses) == 0 {
+		exporter.config.Addresses = DefaultExportAddresses
+	}
+	if exporter.config.BatchIntervalSec <= 0 {
+		exporter.config.BatchIntervalSec = DefaultBatchIntervalSec
+	}
+	if exporter.config.BatchSize <= 0 {
+		exporter.config.BatchSize = DefaultBatchSize
+	}
+	exporter.flushCountdown = exporter.config.BatchIntervalSec
+	return exporter
+}
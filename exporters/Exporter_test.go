@@ -0,0 +1,240 @@
+package exporters_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/exporters"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const latestAddr = "test/publisher1/node1/temperature/0/$latest"
+const eventAddr = "test/publisher1/node1/$event"
+
+// testSink collects the sent records
+type testSink struct {
+	batches [][]exporters.ExportRecord
+	err     error
+	mutex   sync.Mutex
+}
+
+func (sink *testSink) Send(records []exporters.ExportRecord) error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.err != nil {
+		return sink.err
+	}
+	sink.batches = append(sink.batches, records)
+	return nil
+}
+
+// setError sets the error that is returned when sending
+func (sink *testSink) setError(err error) {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	sink.err = err
+}
+
+// batchCount returns the nr of batches that were sent
+func (sink *testSink) batchCount() int {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	return len(sink.batches)
+}
+
+func TestExporter(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	signer := messaging.NewMessageSigner(messenger, messaging.CreateAsymKeys(), nil)
+	sink := &testSink{}
+	exporter := exporters.NewExporter(&exporters.ExporterConfig{
+		BatchSize:  2,
+		KeyMapping: exporters.KeyMappingNode,
+	}, sink, signer)
+	exporter.Start()
+
+	latest := types.OutputLatestMessage{Address: latestAddr, Value: "20"}
+	signer.PublishObject(latestAddr, false, latest, nil)
+	assert.Len(t, sink.batches, 0, "batch is not full")
+	event := types.OutputEventMessage{Address: eventAddr, Event: map[string]string{"temperature/0": "20"}}
+	signer.PublishObject(eventAddr, false, event, nil)
+	// full batches are sent in the background
+	require.Eventually(t, func() bool { return sink.batchCount() == 1 }, time.Second, 10*time.Millisecond)
+	require.Len(t, sink.batches[0], 2)
+	record := sink.batches[0][0]
+	assert.Equal(t, latestAddr, record.Address)
+	assert.Equal(t, "test/publisher1/node1", record.Key)
+	assert.Equal(t, types.MessageType(types.MessageTypeLatest), record.MessageType)
+	var rxLatest types.OutputLatestMessage
+	err := json.Unmarshal([]byte(record.Payload), &rxLatest)
+	assert.NoError(t, err, "payload should be the unsigned message")
+	assert.Equal(t, "20", rxLatest.Value)
+
+	// records are kept if the sink fails
+	sink.setError(errors.New("sink failure"))
+	signer.PublishObject(latestAddr, false, latest, nil)
+	err = exporter.Flush()
+	assert.Error(t, err)
+
+	// retrying a full batch doesn't hold up the handling of publications
+	start := time.Now()
+	signer.PublishObject(latestAddr, false, latest, nil)
+	assert.True(t, time.Since(start) < 100*time.Millisecond)
+	sink.setError(nil)
+	exporter.Stop()
+	assert.Equal(t, 2, sink.batchCount())
+
+	// unsigned messages are not exported when signing is required
+	messenger.Publish(latestAddr, false, "{}")
+	assert.Len(t, sink.batches, 2)
+}
+
+func TestMakeRecordKey(t *testing.T) {
+	assert.Equal(t, latestAddr, exporters.MakeRecordKey(latestAddr, ""))
+	assert.Equal(t, "test/publisher1", exporters.MakeRecordKey(latestAddr, exporters.KeyMappingPublisher))
+	assert.Equal(t, "test/publisher1/node1/temperature/0", exporters.MakeRecordKey(latestAddr, exporters.KeyMappingOutput))
+}
+
+func TestPubSubSink(t *testing.T) {
+	var rxRequest struct {
+		Messages []struct {
+			Attributes map[string]string `json:"attributes"`
+			Data       []byte            `json:"data"`
+		} `json:"messages"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/projects/project1/topics/topic1:publish", r.URL.Path)
+		assert.Equal(t, "Bearer token1", r.Header.Get("Authorization"))
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &rxRequest)
+	}))
+	defer server.Close()
+
+	sink := exporters.NewPubSubSink(&exporters.PubSubConfig{
+		AccessToken: "token1", Endpoint: server.URL, Project: "project1", Topic: "topic1"})
+	err := sink.Send([]exporters.ExportRecord{{Address: latestAddr, Key: "key1", Payload: `{"value":"20"}`}})
+	assert.NoError(t, err)
+	require.Len(t, rxRequest.Messages, 1)
+	assert.Equal(t, "key1", rxRequest.Messages[0].Attributes["key"])
+	assert.Equal(t, `{"value":"20"}`, string(rxRequest.Messages[0].Data))
+
+	sink.SetTokenProvider(func() (string, error) { return "", errors.New("no token") })
+	err = sink.Send([]exporters.ExportRecord{{Address: latestAddr}})
+	assert.Error(t, err)
+}
+
+func TestKafkaSink(t *testing.T) {
+	var rxBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/topics/topic1", r.URL.Path)
+		assert.Equal(t, exporters.KafkaContentType, r.Header.Get("Content-Type"))
+		body, _ := ioutil.ReadAll(r.Body)
+		rxBody = string(body)
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	records := []exporters.ExportRecord{{Key: "key1", Payload: `{"value":"20"}`}, {Key: "key2", Payload: "raw"}}
+	sink := exporters.NewKafkaSink(&exporters.KafkaConfig{ProxyURL: server.URL, Topic: "topic1"})
+	err := sink.Send(records)
+	assert.Error(t, err, "expected unauthorized")
+
+	sink = exporters.NewKafkaSink(&exporters.KafkaConfig{ProxyURL: server.URL, Topic: "topic1", Login: "user1"})
+	err = sink.Send(records)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"records":[{"key":"key1","value":{"value":"20"}},{"key":"key2","value":"raw"}]}`, rxBody)
+}
+
+func TestSyslogSink(t *testing.T) {
+	const domainLogAddr = "test/publisher1/$domainlog"
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	signer := messaging.NewMessageSigner(messenger, messaging.CreateAsymKeys(), nil)
+	sink := exporters.NewSyslogSink(&exporters.SyslogConfig{Address: conn.LocalAddr().String(), Hostname: "host1"})
+	defer sink.Close()
+	exporter := exporters.NewDomainLogExporter(sink, signer)
+	exporter.Start()
+	event := types.DomainLogMessage{
+		Address:     domainLogAddr,
+		Description: "Node ID changed to a=b|c",
+		Event:       types.DomainLogEventAliasChanged,
+		Sender:      "test/publisher1/$identity",
+		Severity:    types.DomainLogSeverityCritical,
+		Subject:     "test/publisher1/node1/$node",
+		Timestamp:   "2020-01-02T03:04:05.000-0700",
+	}
+	signer.PublishObject(domainLogAddr, false, event, nil)
+	signer.PublishObject(latestAddr, false, types.OutputLatestMessage{Address: latestAddr, Value: "20"}, nil)
+	exporter.Stop()
+
+	buffer := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buffer)
+	require.NoError(t, err)
+	message := string(buffer[:n])
+	assert.True(t, strings.HasPrefix(message, "<82>1 2020-01-02T03:04:05.000-0700 host1 iotdomain - - - CEF:0|iotdomain|"), message)
+	assert.Contains(t, message, "|aliasChanged|Node ID changed to a=b\\|c|9|")
+	assert.Contains(t, message, "msg=Node ID changed to a\\=b|c")
+	assert.Contains(t, message, "duser=test/publisher1/node1/$node")
+	// only the domain log is exported
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = conn.ReadFrom(buffer)
+	assert.Error(t, err)
+
+	// records that aren't domain log events are sent as generic events
+	message = exporters.FormatSyslogMessage(exporters.ExportRecord{Address: latestAddr, MessageType: types.MessageTypeLatest, Payload: "{}"}, "host1")
+	assert.Contains(t, message, "|$latest|{}|3|")
+}
+
+func TestWebhookSink(t *testing.T) {
+	var rxBody string
+	var rxContentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token1", r.Header.Get("Authorization"))
+		body, _ := ioutil.ReadAll(r.Body)
+		rxBody = string(body)
+		rxContentType = r.Header.Get("Content-Type")
+	}))
+	defer server.Close()
+
+	eventJSON, _ := json.Marshal(types.DomainLogMessage{
+		Event: types.DomainLogEventRevoked, Severity: types.DomainLogSeverityWarning, Subject: "test/publisher2"})
+	records := []exporters.ExportRecord{{Address: "test/publisher1/$domainlog", Payload: string(eventJSON)}}
+
+	sink := exporters.NewWebhookSink(&exporters.WebhookConfig{Token: "token1", URL: server.URL})
+	err := sink.Send(records)
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", rxContentType)
+	var rxEvents []types.DomainLogMessage
+	err = json.Unmarshal([]byte(rxBody), &rxEvents)
+	require.NoError(t, err)
+	require.Len(t, rxEvents, 1)
+	assert.Equal(t, types.DomainLogEventRevoked, rxEvents[0].Event)
+	assert.Equal(t, "test/publisher2", rxEvents[0].Subject)
+
+	sink = exporters.NewWebhookSink(&exporters.WebhookConfig{
+		Format: exporters.SecurityEventFormatCEF, Token: "token1", URL: server.URL})
+	err = sink.Send(records)
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain", rxContentType)
+	assert.True(t, strings.HasPrefix(rxBody, "CEF:0|iotdomain|iotdomain-go|1|revoked||6|"), rxBody)
+
+	// events in JSON format
+	message := exporters.FormatSecurityEvent(exporters.SecurityEventFromRecord(records[0]), exporters.SecurityEventFormatJSON)
+	assert.True(t, strings.HasPrefix(message, `{"address":`), message)
+}
@@ -0,0 +1,71 @@
+// Package exporters with a sink that sends exported records to Kafka through the Kafka REST proxy
+package exporters
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+)
+
+// KafkaContentType is the REST proxy content type for JSON records
+const KafkaContentType = "application/vnd.kafka.json.v2+json"
+
+// KafkaConfig with the Kafka topic to produce exported records to
+type KafkaConfig struct {
+	Login    string `yaml:"login,omitempty"`       // optional basic authentication login name
+	Password string `yaml:"credentials,omitempty"` // optional basic authentication password
+	ProxyURL string `yaml:"proxyUrl"`              // REST proxy URL, eg http://localhost:8082
+	Topic    string `yaml:"topic"`                 // Kafka topic to produce records to
+}
+
+// kafkaRecord is a record in the REST proxy produce request
+type kafkaRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// KafkaSink sends exported records to a Kafka topic using the Kafka REST proxy API v2.
+// The record key is used as Kafka key so records with the same key go to the same partition.
+type KafkaSink struct {
+	client *http.Client
+	config KafkaConfig
+}
+
+// Send produces a batch of records to the topic
+func (sink *KafkaSink) Send(records []ExportRecord) error {
+	var request struct {
+		Records []kafkaRecord `json:"records"`
+	}
+	for _, record := range records {
+		value := json.RawMessage(record.Payload)
+		if !json.Valid(value) {
+			// pass non JSON payloads as a string
+			value, _ = json.Marshal(record.Payload)
+		}
+		request.Records = append(request.Records, kafkaRecord{Key: record.Key, Value: value})
+	}
+	body, _ := json.Marshal(request)
+	url := fmt.Sprintf("%s/topics/%s", sink.config.ProxyURL, sink.config.Topic)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return lib.MakeErrorf("KafkaSink.Send: Invalid request to %s: %s", url, err)
+	}
+	if sink.config.Login != "" {
+		req.SetBasicAuth(sink.config.Login, sink.config.Password)
+	}
+	req.Header.Set("Content-Type", KafkaContentType)
+	return sendRequest(sink.client, req)
+}
+
+// NewKafkaSink creates a sink that produces exported records to a Kafka topic
+func NewKafkaSink(config *KafkaConfig) *KafkaSink {
+	sink := &KafkaSink{
+		client: &http.Client{Timeout: 30 * time.Second},
+		config: *config,
+	}
+	return sink
+}
@@ -0,0 +1,104 @@
+// Package exporters with a sink that sends exported records to Google Cloud Pub/Sub
+package exporters
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+)
+
+// DefaultPubSubEndpoint is the Google Cloud Pub/Sub REST API endpoint
+const DefaultPubSubEndpoint = "https://pubsub.googleapis.com"
+
+// PubSubConfig with the Pub/Sub topic to publish exported records to
+type PubSubConfig struct {
+	AccessToken string `yaml:"accessToken,omitempty"` // OAuth2 access token, unless a token provider is set
+	Endpoint    string `yaml:"endpoint,omitempty"`    // REST API endpoint. Default is DefaultPubSubEndpoint
+	Project     string `yaml:"project"`               // Google Cloud project ID
+	Topic       string `yaml:"topic"`                 // Pub/Sub topic ID
+}
+
+// pubSubMessage is a message in the Pub/Sub publish request
+type pubSubMessage struct {
+	Attributes map[string]string `json:"attributes"`
+	Data       string            `json:"data"` // base64 encoded payload
+}
+
+// PubSubSink sends exported records to a Google Cloud Pub/Sub topic using the REST API.
+// The record address, key and message type are passed as message attributes.
+type PubSubSink struct {
+	client        *http.Client
+	config        PubSubConfig
+	tokenProvider func() (string, error) // provides the access token of each request
+}
+
+// Send publishes a batch of records to the topic
+func (sink *PubSubSink) Send(records []ExportRecord) error {
+	var request struct {
+		Messages []pubSubMessage `json:"messages"`
+	}
+	for _, record := range records {
+		request.Messages = append(request.Messages, pubSubMessage{
+			Attributes: map[string]string{
+				"address":     record.Address,
+				"key":         record.Key,
+				"messageType": string(record.MessageType),
+			},
+			Data: base64.StdEncoding.EncodeToString([]byte(record.Payload)),
+		})
+	}
+	body, _ := json.Marshal(request)
+	url := fmt.Sprintf("%s/v1/projects/%s/topics/%s:publish", sink.config.Endpoint, sink.config.Project, sink.config.Topic)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return lib.MakeErrorf("PubSubSink.Send: Invalid request to %s: %s", url, err)
+	}
+	token, err := sink.tokenProvider()
+	if err != nil {
+		return lib.MakeErrorf("PubSubSink.Send: Unable to obtain access token: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	return sendRequest(sink.client, req)
+}
+
+// SetTokenProvider sets the function that provides the OAuth2 access token for each request
+// Intended for tokens that expire and need to be refreshed.
+func (sink *PubSubSink) SetTokenProvider(tokenProvider func() (string, error)) {
+	sink.tokenProvider = tokenProvider
+}
+
+// sendRequest sends a http request and checks the response status
+func sendRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return lib.MakeErrorf("sendRequest: Request to %s failed: %s", req.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return lib.MakeErrorf("sendRequest: Request to %s failed with status %s: %s", req.URL, resp.Status, respBody)
+	}
+	return nil
+}
+
+// NewPubSubSink creates a sink that publishes exported records to a Pub/Sub topic
+func NewPubSubSink(config *PubSubConfig) *PubSubSink {
+	sink := &PubSubSink{
+		client: &http.Client{Timeout: 30 * time.Second},
+		config: *config,
+	}
+	if sink.config.Endpoint == "" {
+		sink.config.Endpoint = DefaultPubSubEndpoint
+	}
+	sink.tokenProvider = func() (string, error) {
+		return sink.config.AccessToken, nil
+	}
+	return sink
+}
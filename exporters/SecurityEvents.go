@@ -0,0 +1,42 @@
+// Package exporters with formatting of security events for SIEM and SOC tooling
+package exporters
+
+import (
+	"encoding/json"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// SecurityEventFormat is the format security events are sent to a SIEM in
+type SecurityEventFormat string
+
+// Available security event formats
+const (
+	SecurityEventFormatCEF  SecurityEventFormat = "cef"  // ArcSight Common Event Format (default)
+	SecurityEventFormatJSON SecurityEventFormat = "json" // the domain log message in JSON
+)
+
+// FormatSecurityEvent returns a security event in the given format. The default format is CEF.
+func FormatSecurityEvent(event *types.DomainLogMessage, format SecurityEventFormat) string {
+	if format == SecurityEventFormatJSON {
+		eventJSON, _ := json.Marshal(event)
+		return string(eventJSON)
+	}
+	return FormatCEF(event)
+}
+
+// SecurityEventFromRecord returns the domain log event of an exported record
+// Records that aren't domain log events are turned into an info event with the payload as description.
+func SecurityEventFromRecord(record ExportRecord) *types.DomainLogMessage {
+	event := &types.DomainLogMessage{}
+	err := json.Unmarshal([]byte(record.Payload), event)
+	if err != nil || event.Event == "" {
+		event = &types.DomainLogMessage{
+			Address:     record.Address,
+			Description: record.Payload,
+			Event:       types.DomainLogEvent(record.MessageType),
+			Severity:    types.DomainLogSeverityInfo,
+		}
+	}
+	return event
+}
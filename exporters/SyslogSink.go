@@ -0,0 +1,177 @@
+// Package exporters with a sink that sends domain log events to a SIEM as syslog messages
+package exporters
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// DomainLogExportAddresses are the publications that are exported to a SIEM: the domain log of all publishers
+var DomainLogExportAddresses = []string{"+/+/" + types.MessageTypeDomainLog}
+
+// syslogFacility is the syslog facility of domain log events: security/authorization messages
+const syslogFacility = 10
+
+// syslogSeverities maps the severity of domain log events to syslog severities
+var syslogSeverities = map[types.DomainLogSeverity]int{
+	types.DomainLogSeverityCritical: 2,
+	types.DomainLogSeverityInfo:     6,
+	types.DomainLogSeverityWarning:  4,
+}
+
+// cefSeverities maps the severity of domain log events to CEF severities 0-10
+var cefSeverities = map[types.DomainLogSeverity]int{
+	types.DomainLogSeverityCritical: 9,
+	types.DomainLogSeverityInfo:     3,
+	types.DomainLogSeverityWarning:  6,
+}
+
+// SyslogConfig with the syslog server of the SIEM
+type SyslogConfig struct {
+	Address  string              `yaml:"address"`            // host:port of the syslog server, eg siem.local:514
+	Format   SecurityEventFormat `yaml:"format,omitempty"`   // format of the events, cef or json. Default is cef
+	Hostname string              `yaml:"hostname,omitempty"` // hostname in the syslog messages. Default is the os hostname
+	Network  string              `yaml:"network,omitempty"`  // udp or tcp. Default is udp
+}
+
+// SyslogSink sends domain log events to a syslog server in the ArcSight Common Event Format (CEF)
+// that most SIEMs ingest, or in JSON. Other records are sent as generic events.
+// Intended for use with an exporter of DomainLogExportAddresses. See NewDomainLogExporter.
+type SyslogSink struct {
+	config      SyslogConfig
+	conn        net.Conn    // connection to the syslog server, nil until the first send or after an error
+	updateMutex *sync.Mutex // mutex for concurrent sending
+}
+
+// Send writes a batch of records to the syslog server, one message per record
+// The connection is reopened on the next send if writing fails.
+func (sink *SyslogSink) Send(records []ExportRecord) error {
+	sink.updateMutex.Lock()
+	defer sink.updateMutex.Unlock()
+	if sink.conn == nil {
+		conn, err := net.DialTimeout(sink.config.Network, sink.config.Address, 10*time.Second)
+		if err != nil {
+			return lib.MakeErrorf("SyslogSink.Send: Unable to connect to %s: %s", sink.config.Address, err)
+		}
+		sink.conn = conn
+	}
+	for _, record := range records {
+		message := formatSyslogMessage(record, sink.config.Hostname, sink.config.Format)
+		if sink.config.Network == "tcp" {
+			message += "\n"
+		}
+		_, err := sink.conn.Write([]byte(message))
+		if err != nil {
+			sink.conn.Close()
+			sink.conn = nil
+			return lib.MakeErrorf("SyslogSink.Send: Failed writing to %s: %s", sink.config.Address, err)
+		}
+	}
+	return nil
+}
+
+// Close the connection to the syslog server
+func (sink *SyslogSink) Close() {
+	sink.updateMutex.Lock()
+	defer sink.updateMutex.Unlock()
+	if sink.conn != nil {
+		sink.conn.Close()
+		sink.conn = nil
+	}
+}
+
+// FormatSyslogMessage returns the RFC 5424 syslog message with the CEF event of an exported record
+func FormatSyslogMessage(record ExportRecord, hostname string) string {
+	return formatSyslogMessage(record, hostname, SecurityEventFormatCEF)
+}
+
+// FormatCEF returns a domain log event in the ArcSight Common Event Format
+//  CEF:0|iotdomain|iotdomain-go|1|event|description|severity|extensions
+func FormatCEF(event *types.DomainLogMessage) string {
+	severity, found := cefSeverities[event.Severity]
+	if !found {
+		severity = cefSeverities[types.DomainLogSeverityWarning]
+	}
+	extensions := []string{
+		"rt=" + escapeCEFExtension(event.Timestamp),
+		"suser=" + escapeCEFExtension(event.Sender),
+		"duser=" + escapeCEFExtension(event.Subject),
+		"cs1Label=address",
+		"cs1=" + escapeCEFExtension(event.Address),
+		"msg=" + escapeCEFExtension(event.Description),
+	}
+	return fmt.Sprintf("CEF:0|iotdomain|iotdomain-go|1|%s|%s|%d|%s",
+		escapeCEFHeader(string(event.Event)), escapeCEFHeader(event.Description), severity,
+		strings.Join(extensions, " "))
+}
+
+// escapeCEFHeader escapes the backslash and pipe characters in CEF header fields
+func escapeCEFHeader(value string) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "|", "\\|")
+	return strings.ReplaceAll(value, "\n", " ")
+}
+
+// escapeCEFExtension escapes the backslash, equal sign and newline characters in CEF extension values
+func escapeCEFExtension(value string) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "=", "\\=")
+	value = strings.ReplaceAll(value, "\r", "\\r")
+	return strings.ReplaceAll(value, "\n", "\\n")
+}
+
+// NewDomainLogExporter creates an exporter of the domain log events of all publishers to a sink, eg a
+// syslog sink of a SIEM. Events are sent each second. Use Start() to start exporting.
+//  sink is the destination of the events
+//  messageSigner to subscribe and verify the events
+func NewDomainLogExporter(sink ISink, messageSigner *messaging.MessageSigner) *Exporter {
+	config := &ExporterConfig{
+		Addresses:        DomainLogExportAddresses,
+		BatchIntervalSec: 1,
+		KeyMapping:       KeyMappingPublisher,
+	}
+	return NewExporter(config, sink, messageSigner)
+}
+
+// formatSyslogMessage returns the RFC 5424 syslog message with the security event of an exported record
+// in the given format, see FormatSecurityEvent
+func formatSyslogMessage(record ExportRecord, hostname string, format SecurityEventFormat) string {
+	event := SecurityEventFromRecord(record)
+	priority := syslogFacility*8 + syslogSeverities[event.Severity]
+	if _, found := syslogSeverities[event.Severity]; !found {
+		priority = syslogFacility*8 + syslogSeverities[types.DomainLogSeverityWarning]
+	}
+	timestamp := event.Timestamp
+	if timestamp == "" {
+		timestamp = time.Now().Format(types.TimeFormat)
+	}
+	return fmt.Sprintf("<%d>1 %s %s iotdomain - - - %s", priority, timestamp, hostname,
+		FormatSecurityEvent(event, format))
+}
+
+// NewSyslogSink creates a sink that sends records to a syslog server
+//  config with the syslog server address. Defaults are used for missing values.
+func NewSyslogSink(config *SyslogConfig) *SyslogSink {
+	sink := &SyslogSink{
+		config:      *config,
+		updateMutex: &sync.Mutex{},
+	}
+	if sink.config.Network == "" {
+		sink.config.Network = "udp"
+	}
+	if sink.config.Hostname == "" {
+		sink.config.Hostname, _ = os.Hostname()
+	}
+	if sink.config.Hostname == "" {
+		sink.config.Hostname = "-"
+	}
+	return sink
+}
@@ -0,0 +1,74 @@
+// Package exporters with a sink that posts security events to a SIEM webhook
+package exporters
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// WebhookConfig with the HTTP endpoint of the SIEM that receives security events
+type WebhookConfig struct {
+	Format   SecurityEventFormat `yaml:"format,omitempty"`   // format of the events, cef or json. Default is json
+	Login    string              `yaml:"login,omitempty"`    // optional basic authentication login name
+	Password string              `yaml:"password,omitempty"` // optional basic authentication password
+	Token    string              `yaml:"token,omitempty"`    // optional bearer token, eg a HTTP event collector token
+	URL      string              `yaml:"url"`                // URL to post the events to
+}
+
+// WebhookSink posts domain log events to a webhook of a SIEM or SOC tool.
+// In JSON format a batch is posted as an array of domain log messages. In CEF format a batch is
+// posted as plain text with one event per line. Other records are sent as generic events.
+// Intended for use with an exporter of DomainLogExportAddresses. See NewDomainLogExporter.
+type WebhookSink struct {
+	client *http.Client
+	config WebhookConfig
+}
+
+// Send posts a batch of records to the webhook
+func (sink *WebhookSink) Send(records []ExportRecord) error {
+	var body []byte
+	contentType := "application/json"
+	if sink.config.Format == SecurityEventFormatCEF {
+		lines := make([]string, 0, len(records))
+		for _, record := range records {
+			lines = append(lines, FormatCEF(SecurityEventFromRecord(record)))
+		}
+		body = []byte(strings.Join(lines, "\n") + "\n")
+		contentType = "text/plain"
+	} else {
+		events := make([]*types.DomainLogMessage, 0, len(records))
+		for _, record := range records {
+			events = append(events, SecurityEventFromRecord(record))
+		}
+		body, _ = json.Marshal(events)
+	}
+	req, err := http.NewRequest("POST", sink.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return lib.MakeErrorf("WebhookSink.Send: Invalid request to %s: %s", sink.config.URL, err)
+	}
+	if sink.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+sink.config.Token)
+	} else if sink.config.Login != "" {
+		req.SetBasicAuth(sink.config.Login, sink.config.Password)
+	}
+	req.Header.Set("Content-Type", contentType)
+	return sendRequest(sink.client, req)
+}
+
+// NewWebhookSink creates a sink that posts security events to a webhook
+func NewWebhookSink(config *WebhookConfig) *WebhookSink {
+	sink := &WebhookSink{
+		client: &http.Client{Timeout: 30 * time.Second},
+		config: *config,
+	}
+	if sink.config.Format == "" {
+		sink.config.Format = SecurityEventFormatJSON
+	}
+	return sink
+}
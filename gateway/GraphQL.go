@@ -0,0 +1,525 @@
+// Package gateway with a read-only GraphQL API over the discovered domain data
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// GraphQL paths of the gateway
+const (
+	PathGraphQL       = "/graphql"        // GET or POST a GraphQL request
+	PathGraphQLSchema = "/graphql/schema" // GET the GraphQL schema
+)
+
+// MaxGraphQLRequestSize is the maximum size in bytes of the body of a GraphQL POST request
+const MaxGraphQLRequestSize = 64 * 1024
+
+// GraphQLSchema describes the domain model served by the GraphQL API
+// Introspection queries are not supported. Subscriptions are streamed as Server-Sent Events with
+// the 'next' event, whose data is the GraphQL response of each update.
+const GraphQLSchema = `scalar JSON
+
+type Query {
+  publishers(publisher: String): [Publisher]
+  nodes(publisher: String, node: String, group: String): [Node]
+  inputs(publisher: String, node: String, type: String): [Input]
+  outputs(publisher: String, node: String, type: String): [Output]
+  latest(address: String!): Latest
+  history(address: String!): History
+  status(address: String!): Status
+}
+
+type Subscription {
+  values(publisher: String, node: String, type: String): Latest
+  status(publisher: String): Status
+}
+
+type Publisher {
+  address: String
+  certificate: String
+  domain: String
+  issuerId: String
+  location: String
+  organization: String
+  priority: Int
+  publicKey: String
+  publisherId: String
+  unsignedTypes: [String]
+  validUntil: String
+  signature: String
+  timestamp: String
+}
+
+type Node {
+  address: String
+  attr: JSON
+  config: JSON
+  hwID: String
+  nodeId: String
+  status: JSON
+  timestamp: String
+}
+
+type TypeInfo {
+  namespace: String
+  name: String
+  description: String
+  dataType: String
+  unit: String
+  url: String
+}
+
+type Input {
+  address: String
+  attr: JSON
+  config: JSON
+  dataType: String
+  enumValues: [String]
+  max: Float
+  min: Float
+  source: String
+  timestamp: String
+  typeInfo: TypeInfo
+  unit: String
+}
+
+type Output {
+  address: String
+  attr: JSON
+  config: JSON
+  dataType: String
+  enumValues: [String]
+  max: Float
+  min: Float
+  timestamp: String
+  typeInfo: TypeInfo
+  unit: String
+  latest: Latest
+  history: History
+}
+
+type Latest {
+  address: String
+  quality: String
+  timestamp: String
+  unit: String
+  value: String
+}
+
+type Value {
+  timestamp: String
+  value: String
+  epoch: Int
+  quality: String
+  raw: String
+}
+
+type History {
+  address: String
+  duration: Int
+  history: [Value]
+  timestamp: String
+  unit: String
+}
+
+type Status {
+  address: String
+  clockOutOfSync: Boolean
+  clockSkewMsec: Int
+  identityConflict: Boolean
+  identityExpiry: String
+  status: String
+}
+`
+
+// gqlTypeNames maps the domain types to their name in the GraphQL schema
+var gqlTypeNames = map[reflect.Type]string{
+	reflect.TypeOf(types.CustomTypeInfo{}):           "TypeInfo",
+	reflect.TypeOf(types.InputDiscoveryMessage{}):    "Input",
+	reflect.TypeOf(types.NodeDiscoveryMessage{}):     "Node",
+	reflect.TypeOf(types.OutputDiscoveryMessage{}):   "Output",
+	reflect.TypeOf(types.OutputHistoryMessage{}):     "History",
+	reflect.TypeOf(types.OutputLatestMessage{}):      "Latest",
+	reflect.TypeOf(types.OutputValue{}):              "Value",
+	reflect.TypeOf(types.PublisherIdentityMessage{}): "Publisher",
+	reflect.TypeOf(types.PublisherStatusMessage{}):   "Status",
+}
+
+// GraphQLRequest is the body of a GraphQL POST request
+type GraphQLRequest struct {
+	OperationName string                 `json:"operationName,omitempty"` // ignored as only a single operation is supported
+	Query         string                 `json:"query"`                   // the GraphQL document
+	Variables     map[string]interface{} `json:"variables,omitempty"`     // values of the variables in the document
+}
+
+// GraphQLError is an error in a GraphQL response
+type GraphQLError struct {
+	Message string `json:"message"`
+}
+
+// GraphQLResponse is the result of a GraphQL request
+type GraphQLResponse struct {
+	Data   interface{}    `json:"data"`
+	Errors []GraphQLError `json:"errors,omitempty"`
+}
+
+// gqlObject is a selection of fields that is marshalled to JSON in the order of the selection
+type gqlObject []gqlEntry
+
+// gqlEntry is a field in a gqlObject
+type gqlEntry struct {
+	key   string
+	value interface{}
+}
+
+// MarshalJSON writes the fields in order
+func (object gqlObject) MarshalJSON() ([]byte, error) {
+	buffer := bytes.NewBufferString("{")
+	for i, entry := range object {
+		if i > 0 {
+			buffer.WriteString(",")
+		}
+		key, _ := json.Marshal(entry.key)
+		value, err := json.Marshal(entry.value)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(key)
+		buffer.WriteString(":")
+		buffer.Write(value)
+	}
+	buffer.WriteString("}")
+	return buffer.Bytes(), nil
+}
+
+// executeQuery resolves the root fields of a query
+// Fields that fail to resolve are null in the result and their error is added to the errors.
+func (gateway *RestGateway) executeQuery(selections []*gqlField) *GraphQLResponse {
+	response := &GraphQLResponse{}
+	data := gqlObject{}
+	for _, field := range selections {
+		value, err := gateway.resolveQueryField(field)
+		if err != nil {
+			response.Errors = append(response.Errors, GraphQLError{Message: err.Error()})
+			value = nil
+		}
+		data = append(data, gqlEntry{key: field.Alias, value: value})
+	}
+	response.Data = data
+	return response
+}
+
+// resolveQueryField resolves a root field of a query using the cache of discovered domain data
+func (gateway *RestGateway) resolveQueryField(field *gqlField) (interface{}, error) {
+	var result interface{}
+	filter, err := makeFilter(field)
+	if err != nil {
+		return nil, err
+	}
+	switch field.Name {
+	case "__typename":
+		return "Query", nil
+	case "publishers":
+		err = checkArguments(field, "publisher")
+		matches := make([]*types.PublisherIdentityMessage, 0)
+		for _, identity := range gateway.pub.GetDomainPublishers() {
+			if filter.Matches(identity.Address) {
+				matches = append(matches, identity)
+			}
+		}
+		result = matches
+	case "nodes":
+		err = checkArguments(field, "publisher", "node", "group")
+		nodeList := gateway.pub.GetDomainNodes()
+		if group, isSet := field.Arguments["group"]; isSet {
+			nodeList = gateway.pub.GetDomainNodesByGroup(fmt.Sprint(group))
+		}
+		matches := make([]*types.NodeDiscoveryMessage, 0)
+		for _, node := range nodeList {
+			if filter.Matches(node.Address) {
+				matches = append(matches, node)
+			}
+		}
+		result = matches
+	case "inputs":
+		err = checkArguments(field, "publisher", "node", "type")
+		matches := make([]*types.InputDiscoveryMessage, 0)
+		for _, input := range gateway.pub.GetDomainInputs() {
+			if filter.Matches(input.Address) {
+				matches = append(matches, input)
+			}
+		}
+		result = matches
+	case "outputs":
+		err = checkArguments(field, "publisher", "node", "type")
+		matches := make([]*types.OutputDiscoveryMessage, 0)
+		for _, output := range gateway.pub.GetDomainOutputs() {
+			if filter.Matches(output.Address) {
+				matches = append(matches, output)
+			}
+		}
+		result = matches
+	case "latest":
+		address, err := requiredArgument(field, "address")
+		if err != nil {
+			return nil, err
+		}
+		result = gateway.pub.GetDomainOutputLatest(address)
+	case "history":
+		address, err := requiredArgument(field, "address")
+		if err != nil {
+			return nil, err
+		}
+		result = gateway.pub.GetDomainOutputHistory(address)
+	case "status":
+		address, err := requiredArgument(field, "address")
+		if err != nil {
+			return nil, err
+		}
+		result = gateway.pub.GetDomainPublisherStatus(address)
+	default:
+		return nil, lib.MakeErrorf("Cannot query field '%s' on type 'Query'", field.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gateway.selectValue(reflect.ValueOf(result), field)
+}
+
+// resolveVirtualField resolves the fields of domain objects that refer to other domain data
+// This returns false if the field isn't a virtual field of the object.
+func (gateway *RestGateway) resolveVirtualField(object reflect.Value, field *gqlField) (interface{}, bool, error) {
+	if object.Type() != reflect.TypeOf(types.OutputDiscoveryMessage{}) {
+		return nil, false, nil
+	}
+	outputAddress := object.FieldByName("Address").String()
+	var value interface{}
+	switch field.Name {
+	case "latest":
+		value = gateway.pub.GetDomainOutputLatest(outputAddress)
+	case "history":
+		value = gateway.pub.GetDomainOutputHistory(outputAddress)
+	default:
+		return nil, false, nil
+	}
+	result, err := gateway.selectValue(reflect.ValueOf(value), field)
+	return result, true, err
+}
+
+// selectValue returns the selection of fields of a value
+// Objects and lists of objects require a selection of fields. Other values are scalars.
+func (gateway *RestGateway) selectValue(value reflect.Value, field *gqlField) (interface{}, error) {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil, nil
+		}
+		value = value.Elem()
+	}
+	if !value.IsValid() {
+		return nil, nil
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		if field.Selections == nil {
+			return nil, lib.MakeErrorf("Field '%s' of type '%s' must have a selection of subfields",
+				field.Name, gqlTypeNames[value.Type()])
+		}
+		object := gqlObject{}
+		for _, selection := range field.Selections {
+			result, err := gateway.selectField(value, selection)
+			if err != nil {
+				return nil, err
+			}
+			object = append(object, gqlEntry{key: selection.Alias, value: result})
+		}
+		return object, nil
+	case reflect.Slice, reflect.Array:
+		elemType := value.Type().Elem()
+		if elemType.Kind() == reflect.Ptr {
+			elemType = elemType.Elem()
+		}
+		if elemType.Kind() == reflect.Struct {
+			list := make([]interface{}, 0, value.Len())
+			for i := 0; i < value.Len(); i++ {
+				result, err := gateway.selectValue(value.Index(i), field)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, result)
+			}
+			return list, nil
+		}
+	}
+	if field.Selections != nil {
+		return nil, lib.MakeErrorf("Field '%s' is a scalar and can't have a selection of subfields", field.Name)
+	}
+	return value.Interface(), nil
+}
+
+// selectField returns the selected field of an object using the JSON name of the struct field
+func (gateway *RestGateway) selectField(object reflect.Value, field *gqlField) (interface{}, error) {
+	if field.Name == "__typename" {
+		return gqlTypeNames[object.Type()], nil
+	}
+	result, isVirtual, err := gateway.resolveVirtualField(object, field)
+	if isVirtual {
+		return result, err
+	}
+	for i := 0; i < object.NumField(); i++ {
+		jsonName := strings.Split(object.Type().Field(i).Tag.Get("json"), ",")[0]
+		if jsonName == field.Name && jsonName != "-" {
+			return gateway.selectValue(object.Field(i), field)
+		}
+	}
+	return nil, lib.MakeErrorf("Cannot query field '%s' on type '%s'", field.Name, gqlTypeNames[object.Type()])
+}
+
+// serveGraphQL executes a GraphQL request. Subscriptions are streamed as Server-Sent Events.
+// Queries are passed in the 'query' and 'variables' query parameters of a GET request or the
+// GraphQLRequest body of a POST request. The body is limited to MaxGraphQLRequestSize.
+func (gateway *RestGateway) serveGraphQL(response http.ResponseWriter, request *http.Request) {
+	var gqlRequest GraphQLRequest
+	switch request.Method {
+	case http.MethodGet:
+		gqlRequest.Query = request.URL.Query().Get("query")
+		variables := request.URL.Query().Get("variables")
+		if variables != "" {
+			err := json.Unmarshal([]byte(variables), &gqlRequest.Variables)
+			if err != nil {
+				writeGraphQLError(response, http.StatusBadRequest, "Invalid variables: "+err.Error())
+				return
+			}
+		}
+	case http.MethodPost:
+		body, err := ioutil.ReadAll(http.MaxBytesReader(response, request.Body, MaxGraphQLRequestSize))
+		if err != nil {
+			writeGraphQLError(response, http.StatusRequestEntityTooLarge, "Unable to read the GraphQL request: "+err.Error())
+			return
+		}
+		err = json.Unmarshal(body, &gqlRequest)
+		if err != nil {
+			writeGraphQLError(response, http.StatusBadRequest, "Invalid GraphQL request: "+err.Error())
+			return
+		}
+	default:
+		http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	operation, err := parseGraphQL(gqlRequest.Query, gqlRequest.Variables)
+	if err != nil {
+		writeGraphQLError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch operation.Type {
+	case OperationMutation:
+		writeGraphQLError(response, http.StatusBadRequest, "Mutations are not supported. The GraphQL API is read-only")
+	case OperationSubscription:
+		gateway.serveSubscription(response, request, operation)
+	default:
+		writeJSON(response, gateway.executeQuery(operation.Selections))
+	}
+}
+
+// serveSubscription streams the updates of a subscription on output values or publisher status
+func (gateway *RestGateway) serveSubscription(response http.ResponseWriter, request *http.Request, operation *gqlOperation) {
+	if len(operation.Selections) != 1 {
+		writeGraphQLError(response, http.StatusBadRequest, "A subscription must have a single root field")
+		return
+	}
+	field := operation.Selections[0]
+	filter, err := makeFilter(field)
+	eventName := ""
+	switch field.Name {
+	case "values":
+		eventName = StreamEventValue
+		if err == nil {
+			err = checkArguments(field, "publisher", "node", "type")
+		}
+	case "status":
+		eventName = StreamEventStatus
+		if err == nil {
+			err = checkArguments(field, "publisher")
+		}
+	default:
+		err = lib.MakeErrorf("Cannot query field '%s' on type 'Subscription'", field.Name)
+	}
+	if err != nil {
+		writeGraphQLError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	gateway.streamEvents(response, request, eventName, filter, func(event streamEvent) (string, string) {
+		eventResponse := &GraphQLResponse{}
+		result, err := gateway.selectValue(reflect.ValueOf(event.object), field)
+		if err != nil {
+			eventResponse.Errors = append(eventResponse.Errors, GraphQLError{Message: err.Error()})
+		}
+		eventResponse.Data = gqlObject{{key: field.Alias, value: result}}
+		data, _ := json.Marshal(eventResponse)
+		return "next", string(data)
+	})
+}
+
+// checkArguments returns an error if the field has an argument that isn't allowed
+func checkArguments(field *gqlField, allowed ...string) error {
+	for name := range field.Arguments {
+		isAllowed := false
+		for _, allowedName := range allowed {
+			isAllowed = isAllowed || name == allowedName
+		}
+		if !isAllowed {
+			return lib.MakeErrorf("Unknown argument '%s' on field '%s'", name, field.Name)
+		}
+	}
+	return nil
+}
+
+// requiredArgument returns the value of a required string argument of a field
+// This returns an error if the field has other arguments or the argument is missing.
+func requiredArgument(field *gqlField, name string) (string, error) {
+	err := checkArguments(field, name)
+	if err != nil {
+		return "", err
+	}
+	value, isString := field.Arguments[name].(string)
+	if !isString || value == "" {
+		return "", lib.MakeErrorf("Field '%s' requires the string argument '%s'", field.Name, name)
+	}
+	return value, nil
+}
+
+// makeFilter returns the filter of the publisher, node and type arguments of a field
+func makeFilter(field *gqlField) (StreamFilter, error) {
+	filter := StreamFilter{}
+	for name, value := range field.Arguments {
+		text, isString := value.(string)
+		if !isString && (name == "publisher" || name == "node" || name == "type") {
+			return filter, lib.MakeErrorf("Argument '%s' on field '%s' must be a string", name, field.Name)
+		}
+		switch name {
+		case "publisher":
+			filter.PublisherID = text
+		case "node":
+			filter.NodeID = text
+		case "type":
+			filter.OutputType = types.OutputType(text)
+		}
+	}
+	return filter, nil
+}
+
+// writeGraphQLError writes a GraphQL response with an error
+func writeGraphQLError(response http.ResponseWriter, status int, message string) {
+	responseJSON, _ := json.Marshal(&GraphQLResponse{Errors: []GraphQLError{{Message: message}}})
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	response.Write(responseJSON)
+}
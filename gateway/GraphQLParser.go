@@ -0,0 +1,260 @@
+// Package gateway with a parser of GraphQL operations
+package gateway
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Operation types of GraphQL requests
+const (
+	OperationMutation     = "mutation"
+	OperationQuery        = "query"
+	OperationSubscription = "subscription"
+)
+
+// MaxGraphQLDepth is the maximum nesting depth of selection sets in an operation
+const MaxGraphQLDepth = 10
+
+// gqlField is a field in the selection set of an operation
+type gqlField struct {
+	Alias      string                 // name of the field in the result
+	Arguments  map[string]interface{} // argument values with variables resolved
+	Name       string                 // name of the field in the schema
+	Selections []*gqlField            // selection of fields of an object, nil for scalars
+}
+
+// gqlOperation is a parsed GraphQL operation
+type gqlOperation struct {
+	Selections []*gqlField // selection of root fields
+	Type       string      // OperationQuery, OperationMutation or OperationSubscription
+}
+
+// gqlParser parses a GraphQL document with a single operation.
+// Fragments and directives are not supported.
+type gqlParser struct {
+	err       error                  // first error in reading tokens
+	pos       int                    // position of the next token
+	source    string                 // the document
+	token     string                 // the current token
+	variables map[string]interface{} // variable values of the request
+}
+
+// next reads the next token: a punctuator, name, number or quoted string
+// Whitespace, commas and comments are skipped. The token is empty at the end of the
+// document or after an error.
+func (parser *gqlParser) next() error {
+	if parser.err != nil {
+		return parser.err
+	}
+	for parser.pos < len(parser.source) {
+		c := rune(parser.source[parser.pos])
+		if c == '#' {
+			for parser.pos < len(parser.source) && parser.source[parser.pos] != '\n' {
+				parser.pos++
+			}
+		} else if unicode.IsSpace(c) || c == ',' {
+			parser.pos++
+		} else {
+			break
+		}
+	}
+	if parser.pos >= len(parser.source) {
+		parser.token = ""
+		return nil
+	}
+	start := parser.pos
+	c := rune(parser.source[parser.pos])
+	switch {
+	case strings.ContainsRune("{}():$!=[]@", c):
+		parser.pos++
+	case c == '"':
+		parser.pos++
+		for parser.pos < len(parser.source) && parser.source[parser.pos] != '"' {
+			if parser.source[parser.pos] == '\\' {
+				parser.pos++
+			}
+			parser.pos++
+		}
+		if parser.pos >= len(parser.source) {
+			return parser.fail(fmt.Errorf("Unterminated string at position %d", start))
+		}
+		parser.pos++
+	case c == '-' || c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c):
+		parser.pos++
+		for parser.pos < len(parser.source) {
+			c = rune(parser.source[parser.pos])
+			if c != '_' && c != '.' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+				break
+			}
+			parser.pos++
+		}
+	default:
+		return parser.fail(fmt.Errorf("Unexpected character '%c' at position %d", c, start))
+	}
+	parser.token = parser.source[start:parser.pos]
+	return nil
+}
+
+// fail stops reading tokens after an error
+func (parser *gqlParser) fail(err error) error {
+	parser.err = err
+	parser.token = ""
+	return err
+}
+
+// expect reads the given token or returns an error
+func (parser *gqlParser) expect(token string) error {
+	if parser.token != token {
+		return fmt.Errorf("Expected '%s' but found '%s'", token, parser.token)
+	}
+	return parser.next()
+}
+
+// parseArguments parses the arguments of a field: (name: value ...)
+func (parser *gqlParser) parseArguments() (map[string]interface{}, error) {
+	arguments := make(map[string]interface{})
+	err := parser.expect("(")
+	for err == nil && parser.token != ")" {
+		name := parser.token
+		if !isGqlName(name) {
+			return nil, fmt.Errorf("Expected an argument name but found '%s'", name)
+		}
+		parser.next()
+		err = parser.expect(":")
+		if err != nil {
+			break
+		}
+		arguments[name], err = parser.parseValue()
+	}
+	if err == nil {
+		err = parser.expect(")")
+	}
+	return arguments, err
+}
+
+// parseSelections parses a selection set: { field ... }
+//  depth is the nesting depth of the selection set, limited to MaxGraphQLDepth
+func (parser *gqlParser) parseSelections(depth int) ([]*gqlField, error) {
+	if depth > MaxGraphQLDepth {
+		return nil, fmt.Errorf("Selections are nested deeper than %d levels", MaxGraphQLDepth)
+	}
+	selections := make([]*gqlField, 0)
+	err := parser.expect("{")
+	for err == nil && parser.token != "}" {
+		if !isGqlName(parser.token) {
+			return nil, fmt.Errorf("Expected a field name but found '%s'", parser.token)
+		}
+		field := &gqlField{Alias: parser.token, Name: parser.token, Arguments: map[string]interface{}{}}
+		parser.next()
+		if parser.token == ":" {
+			parser.next()
+			if !isGqlName(parser.token) {
+				return nil, fmt.Errorf("Expected a field name after alias '%s'", field.Alias)
+			}
+			field.Name = parser.token
+			parser.next()
+		}
+		if parser.token == "(" {
+			field.Arguments, err = parser.parseArguments()
+		}
+		if err == nil && parser.token == "{" {
+			field.Selections, err = parser.parseSelections(depth + 1)
+		}
+		selections = append(selections, field)
+	}
+	if err == nil {
+		err = parser.expect("}")
+	}
+	return selections, err
+}
+
+// parseValue parses a string, number, boolean, null, enum or variable value
+// Lists and input objects are not supported.
+func (parser *gqlParser) parseValue() (value interface{}, err error) {
+	token := parser.token
+	switch {
+	case token == "$":
+		parser.next()
+		value = parser.variables[parser.token]
+	case strings.HasPrefix(token, "\""):
+		value, err = strconv.Unquote(token)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid string %s", token)
+		}
+	case token == "true" || token == "false":
+		value = token == "true"
+	case token == "null":
+		value = nil
+	case isGqlName(token):
+		// enum values are passed as strings
+		value = token
+	default:
+		value, err = strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Unsupported argument value '%s'", token)
+		}
+	}
+	return value, parser.next()
+}
+
+// skipVariableDefinitions skips the variable definitions of an operation, as the variable values are
+// taken from the request as is
+func (parser *gqlParser) skipVariableDefinitions() error {
+	for parser.token != ")" {
+		if parser.token == "" {
+			return fmt.Errorf("Unterminated variable definitions")
+		}
+		parser.next()
+	}
+	return parser.next()
+}
+
+// isGqlName returns true if the token is a GraphQL name
+func isGqlName(token string) bool {
+	if token == "" {
+		return false
+	}
+	c := rune(token[0])
+	return (c == '_' || unicode.IsLetter(c)) && !strings.Contains(token, ".")
+}
+
+// parseGraphQL parses a GraphQL document with a single operation
+// Shorthand queries without the operation type, aliases, arguments and variables are supported.
+// Fragments, directives, and list or object argument values are not.
+//  document is the GraphQL request text
+//  variables holds the values of variables used in arguments. Use nil if there are none.
+func parseGraphQL(document string, variables map[string]interface{}) (*gqlOperation, error) {
+	parser := &gqlParser{source: document, variables: variables}
+	err := parser.next()
+	if err != nil {
+		return nil, err
+	}
+	operation := &gqlOperation{Type: OperationQuery}
+	if parser.token == OperationQuery || parser.token == OperationMutation || parser.token == OperationSubscription {
+		operation.Type = parser.token
+		parser.next()
+		if isGqlName(parser.token) {
+			// operation name
+			parser.next()
+		}
+		if parser.token == "(" {
+			err = parser.skipVariableDefinitions()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	operation.Selections, err = parser.parseSelections(1)
+	if parser.err != nil {
+		return nil, parser.err
+	} else if err != nil {
+		return nil, err
+	}
+	if parser.token != "" {
+		return nil, fmt.Errorf("Unexpected '%s' after the operation. Only a single operation is supported", parser.token)
+	}
+	return operation, nil
+}
@@ -0,0 +1,163 @@
+package gateway_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/gateway"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newGraphQLPublisher returns a publisher with a cached temperature output value
+func newGraphQLPublisher(tempFolder string) *publisher.Publisher {
+	config := &publisher.PublisherConfig{
+		ConfigFolder: tempFolder,
+		CacheFolder:  tempFolder,
+		Domain:       "test",
+		PublisherID:  "graphql1",
+	}
+	pub := publisher.NewPublisher(config, messaging.NewDummyMessenger(&messaging.MessengerConfig{}))
+	pub.Subscribe("test", "")
+	pub.Start()
+	pub.CreateNode("node1", types.NodeTypeMultisensor)
+	pub.CreateOutput("node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub.CreateOutput("node1", types.OutputTypeHumidity, types.DefaultOutputInstance)
+	pub.UpdateOutputValue("node1", types.OutputTypeTemperature, types.DefaultOutputInstance, "21.5")
+	pub.PublishUpdates()
+	return pub
+}
+
+// postGraphQL posts a GraphQL request to the gateway and returns the status code and response
+func postGraphQL(gw *gateway.RestGateway, query string, variables map[string]interface{}) (int, string) {
+	body, _ := json.Marshal(&gateway.GraphQLRequest{Query: query, Variables: variables})
+	recorder := doRequest(gw, "POST", gateway.PathGraphQL, testToken, string(body))
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestGraphQLQuery(t *testing.T) {
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	pub := newGraphQLPublisher(tempFolder)
+	defer pub.Stop()
+	gw := gateway.NewRestGateway(pub, &gateway.RestGatewayConfig{Tokens: []string{testToken}})
+
+	// fields are returned in the order of the selection
+	status, response := postGraphQL(gw, `{
+		nodes(publisher: "graphql1") { nodeId }
+		temp: outputs(type: temperature) {
+			address
+			latest { value }
+			history { history { value } }
+		}
+	}`, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"data":{"nodes":[{"nodeId":"node1"}],"temp":[{"address":"test/graphql1/node1/temperature/0/$output",`+
+		`"latest":{"value":"21.5"},"history":{"history":[{"value":"21.5"}]}}]}}`, response)
+
+	// variables and GET requests
+	status, response = postGraphQL(gw, `query Latest($addr: String!) { latest(address: $addr) { value __typename } }`,
+		map[string]interface{}{"addr": "test/graphql1/node1/temperature/0"})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"data":{"latest":{"value":"21.5","__typename":"Latest"}}}`, response)
+	recorder := doRequest(gw, "GET", gateway.PathGraphQL+"?query="+url.QueryEscape(`{ publishers { publisherId } }`), testToken, "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `{"publisherId":"graphql1"}`)
+	status, response = postGraphQL(gw, `{ latest(address: "test/graphql1/node1/humidity/0") { value } }`, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"data":{"latest":null}}`, response)
+
+	// field errors are reported with the other fields resolved
+	_, response = postGraphQL(gw, `{ nodes { unknown } inputs { address } }`, nil)
+	var result gateway.GraphQLResponse
+	err := json.Unmarshal([]byte(response), &result)
+	require.NoError(t, err)
+	require.Len(t, result.Errors, 1)
+	assert.Contains(t, result.Errors[0].Message, "unknown")
+	assert.Equal(t, map[string]interface{}{"nodes": nil, "inputs": []interface{}{}}, result.Data)
+	_, response = postGraphQL(gw, `{ nodes { attr { name } } latest { value } outputs(color: "red") { address } }`, nil)
+	result = gateway.GraphQLResponse{}
+	json.Unmarshal([]byte(response), &result)
+	assert.Len(t, result.Errors, 3)
+
+	// invalid requests
+	status, _ = postGraphQL(gw, `{ nodes { ...nodeFields } }`, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = postGraphQL(gw, `{ nodes { address }`, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, response = postGraphQL(gw, `mutation { setInput(address: "x", value: "on") }`, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, response, "read-only")
+	recorder = doRequest(gw, "POST", gateway.PathGraphQL, testToken, "not json")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	status, response = postGraphQL(gw, strings.Repeat("{ nodes ", gateway.MaxGraphQLDepth+1)+
+		strings.Repeat("}", gateway.MaxGraphQLDepth+1), nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, response, "nested")
+	recorder = doRequest(gw, "POST", gateway.PathGraphQL, testToken, strings.Repeat(" ", gateway.MaxGraphQLRequestSize+1))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+
+	recorder = doRequest(gw, "GET", gateway.PathGraphQLSchema, testToken, "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, gateway.GraphQLSchema, recorder.Body.String())
+}
+
+func TestGraphQLSubscription(t *testing.T) {
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	pub := newGraphQLPublisher(tempFolder)
+	defer pub.Stop()
+	gw := gateway.NewRestGateway(pub, &gateway.RestGatewayConfig{Tokens: []string{testToken}})
+	status, _ := postGraphQL(gw, `subscription { nodes { address } }`, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	server := httptest.NewServer(gw)
+	defer server.Close()
+	body, _ := json.Marshal(&gateway.GraphQLRequest{Query: `subscription { temp: values(type: "temperature") { value } }`})
+	request, _ := http.NewRequest("POST", server.URL+gateway.PathGraphQL, bytes.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+testToken)
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	// status and humidity updates are not part of the subscription
+	pub.SetPublisherStatus(types.PublisherRunStateConnected)
+	pub.UpdateOutputValue("node1", types.OutputTypeHumidity, types.DefaultOutputInstance, "50")
+	pub.UpdateOutputValue("node1", types.OutputTypeTemperature, types.DefaultOutputInstance, "22")
+	pub.PublishUpdates()
+
+	lines := make(chan string, 10)
+	go func() {
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			if scanner.Text() != "" {
+				lines <- scanner.Text()
+			}
+		}
+	}()
+	received := make([]string, 0)
+	timeout := time.After(time.Second)
+	for len(received) < 2 {
+		select {
+		case line := <-lines:
+			received = append(received, line)
+		case <-timeout:
+			require.Fail(t, "Missing subscription events", "received: %v", received)
+		}
+	}
+	assert.Equal(t, "event: next", received[0])
+	assert.Equal(t, `data: {"data":{"temp":{"value":"22"}}}`, received[1])
+}
@@ -0,0 +1,275 @@
+// Package gateway with a REST server that exposes discovered domain data to web frontends
+package gateway
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultRestListen is the address the REST gateway listens on by default
+const DefaultRestListen = ":8880"
+
+// Paths of the REST gateway
+const (
+	PathInputs     = "/inputs/"    // POST /inputs/{domain}/{publisher}/{node}/{type}/{instance} sets an input
+	PathNodes      = "/nodes"      // GET the discovered nodes
+	PathOutputs    = "/outputs"    // GET the discovered outputs
+	PathPublishers = "/publishers" // GET the discovered publishers
+	PathStream     = "/stream"     // GET a stream of output values and publisher status, see StreamFilter
+	SuffixHistory  = "/history"    // GET /outputs/{domain}/{publisher}/{node}/{type}/{instance}/history
+	SuffixLatest   = "/latest"     // GET /outputs/{domain}/{publisher}/{node}/{type}/{instance}/latest
+)
+
+// RestGatewayConfig with the settings of the REST gateway
+// Clients are authorized by the role of their bearer token or client certificate. Reading domain data
+// requires the viewer role and setting inputs the operator role.
+type RestGatewayConfig struct {
+	AllowSetInput bool                  `yaml:"allowSetInput,omitempty"` // allow setting domain inputs. Default is read-only
+	CertFile      string                `yaml:"certFile,omitempty"`      // server certificate to serve HTTPS. Default is HTTP
+	CertRoles     map[string]types.Role `yaml:"certRoles,omitempty"`     // role by common name of verified client certificates
+	ClientCAFile  string                `yaml:"clientCaFile,omitempty"`  // CA that verifies client certificates. Requires certFile
+	KeyFile       string                `yaml:"keyFile,omitempty"`       // private key of the server certificate
+	Listen        string                `yaml:"listen,omitempty"`        // address to listen on. Default is DefaultRestListen
+	Roles         map[string]types.Role `yaml:"roles,omitempty"`         // role by bearer token
+	Tokens        []string              `yaml:"tokens,omitempty"`        // bearer tokens with the operator role
+}
+
+// RestGateway serves the domain publishers, nodes and output values from the publisher's cache of
+// discovered domain data. Use Publisher.Subscribe to select the domains and publishers to cache.
+// Clients authenticate with a bearer token from the configuration.
+type RestGateway struct {
+	config        RestGatewayConfig      // gateway settings
+	listener      net.Listener           // listener of the running server
+	pub           *publisher.Publisher   // with the cache of domain data
+	server        *http.Server           // the running server
+	streamClients map[*streamClient]bool // connected clients of the event stream
+	updateMutex   *sync.Mutex            // mutex for async starting, stopping and streaming
+}
+
+// Address returns the address the gateway listens on, or "" when it isn't running
+func (gateway *RestGateway) Address() string {
+	gateway.updateMutex.Lock()
+	defer gateway.updateMutex.Unlock()
+	if gateway.listener == nil {
+		return ""
+	}
+	return gateway.listener.Addr().String()
+}
+
+// ServeHTTP authenticates the request and serves the domain data
+func (gateway *RestGateway) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	role := gateway.clientRole(request)
+	if !lib.HasRole(role, types.RoleViewer) {
+		response.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(response, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	path := request.URL.Path
+	if strings.HasPrefix(path, PathInputs) {
+		if !lib.HasRole(role, types.RoleOperator) {
+			http.Error(response, "The operator role is required to set inputs", http.StatusForbidden)
+			return
+		}
+		gateway.serveSetInput(response, request, strings.TrimPrefix(path, PathInputs))
+		return
+	} else if path == PathGraphQL {
+		gateway.serveGraphQL(response, request)
+		return
+	}
+	if request.Method != http.MethodGet {
+		http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case path == PathPublishers:
+		writeJSON(response, gateway.pub.GetDomainPublishers())
+	case path == PathNodes:
+		writeJSON(response, gateway.pub.GetDomainNodes())
+	case path == PathOutputs:
+		writeJSON(response, gateway.pub.GetDomainOutputs())
+	case path == PathStream:
+		gateway.serveStream(response, request)
+	case path == PathGraphQLSchema:
+		response.Header().Set("Content-Type", "text/plain")
+		response.Write([]byte(GraphQLSchema))
+	case strings.HasPrefix(path, PathOutputs+"/") && strings.HasSuffix(path, SuffixLatest):
+		outputID := strings.TrimSuffix(strings.TrimPrefix(path, PathOutputs+"/"), SuffixLatest)
+		latest := gateway.pub.GetDomainOutputLatest(outputID)
+		if latest == nil {
+			http.Error(response, "No latest value for output "+outputID, http.StatusNotFound)
+			return
+		}
+		writeJSON(response, latest)
+	case strings.HasPrefix(path, PathOutputs+"/") && strings.HasSuffix(path, SuffixHistory):
+		outputID := strings.TrimSuffix(strings.TrimPrefix(path, PathOutputs+"/"), SuffixHistory)
+		history := gateway.pub.GetDomainOutputHistory(outputID)
+		if history == nil {
+			http.Error(response, "No history for output "+outputID, http.StatusNotFound)
+			return
+		}
+		writeJSON(response, history)
+	default:
+		http.NotFound(response, request)
+	}
+}
+
+// Start listening for requests
+// This returns an error if no clients are configured, the certificates can't be loaded or the
+// listen address can't be used.
+func (gateway *RestGateway) Start() error {
+	gateway.updateMutex.Lock()
+	defer gateway.updateMutex.Unlock()
+	if gateway.server != nil {
+		return nil
+	}
+	config := gateway.config
+	if len(config.Tokens) == 0 && len(config.Roles) == 0 && len(config.CertRoles) == 0 {
+		return lib.MakeErrorf("Start: The REST gateway requires at least one token or client certificate role")
+	}
+	server := &http.Server{Handler: gateway}
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return lib.MakeErrorf("Start: Unable to load the server certificate %s: %s", config.CertFile, err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		if config.ClientCAFile != "" {
+			caPEM, err := ioutil.ReadFile(config.ClientCAFile)
+			caPool := x509.NewCertPool()
+			if err != nil || !caPool.AppendCertsFromPEM(caPEM) {
+				return lib.MakeErrorf("Start: Unable to load the client CA %s", config.ClientCAFile)
+			}
+			server.TLSConfig.ClientCAs = caPool
+			server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	listener, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		return lib.MakeErrorf("Start: Unable to listen on %s: %s", config.Listen, err)
+	}
+	gateway.listener = listener
+	gateway.server = server
+	go func(server *http.Server) {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("RestGateway.Start: Server stopped: %s", err)
+		}
+	}(gateway.server)
+	logrus.Infof("RestGateway.Start: Listening on %s", listener.Addr())
+	return nil
+}
+
+// Stop the server and close its connections
+func (gateway *RestGateway) Stop() {
+	gateway.updateMutex.Lock()
+	defer gateway.updateMutex.Unlock()
+	if gateway.server == nil {
+		return
+	}
+	gateway.server.Close()
+	gateway.server = nil
+	gateway.listener = nil
+}
+
+// clientRole returns the most privileged role of the bearer token and the verified client
+// certificate of a request. This returns types.RoleNone if the client is unknown.
+func (gateway *RestGateway) clientRole(request *http.Request) types.Role {
+	role := types.RoleNone
+	auth := request.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		token := []byte(strings.TrimPrefix(auth, "Bearer "))
+		for _, allowed := range gateway.config.Tokens {
+			if allowed != "" && subtle.ConstantTimeCompare(token, []byte(allowed)) == 1 {
+				role = maxRole(role, types.RoleOperator)
+			}
+		}
+		for allowed, tokenRole := range gateway.config.Roles {
+			if allowed != "" && subtle.ConstantTimeCompare(token, []byte(allowed)) == 1 {
+				role = maxRole(role, tokenRole)
+			}
+		}
+	}
+	if request.TLS != nil && len(request.TLS.VerifiedChains) > 0 {
+		commonName := request.TLS.VerifiedChains[0][0].Subject.CommonName
+		role = maxRole(role, gateway.config.CertRoles[commonName])
+	}
+	return role
+}
+
+// serveSetInput publishes a set command with the request body as value to a domain input
+// This is only allowed when the gateway is configured with allowSetInput.
+func (gateway *RestGateway) serveSetInput(response http.ResponseWriter, request *http.Request, inputID string) {
+	if request.Method != http.MethodPost {
+		http.Error(response, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	} else if !gateway.config.AllowSetInput {
+		http.Error(response, "The gateway is read-only", http.StatusForbidden)
+		return
+	}
+	value, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		http.Error(response, "Unable to read the input value", http.StatusBadRequest)
+		return
+	}
+	err = gateway.pub.PublishSetInput(inputID+"/"+types.MessageTypeSetInput, string(value))
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response.WriteHeader(http.StatusAccepted)
+}
+
+// maxRole returns the most privileged of two roles
+func maxRole(role1 types.Role, role2 types.Role) types.Role {
+	if lib.HasRole(role2, role1) {
+		return role2
+	}
+	return role1
+}
+
+// writeJSON writes the object as a JSON response
+func writeJSON(response http.ResponseWriter, object interface{}) {
+	responseJSON, err := json.Marshal(object)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(responseJSON)
+}
+
+// NewRestGateway creates a REST gateway for the domain data of a publisher. Use Start() to start serving.
+// The gateway sets the publisher's handlers of domain output values and publisher status for streaming.
+//  pub is the publisher whose cache of discovered domain data is served
+//  config with the listen address and access tokens. Defaults are used for missing values.
+func NewRestGateway(pub *publisher.Publisher, config *RestGatewayConfig) *RestGateway {
+	gateway := &RestGateway{
+		config:        *config,
+		pub:           pub,
+		streamClients: make(map[*streamClient]bool),
+		updateMutex:   &sync.Mutex{},
+	}
+	if gateway.config.Listen == "" {
+		gateway.config.Listen = DefaultRestListen
+	}
+	pub.SetOnDomainOutputValue(gateway.handleValue)
+	pub.SetOnDomainPublisherStatus(gateway.handleStatus)
+	return gateway
+}
@@ -0,0 +1,160 @@
+package gateway_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/gateway"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "secret"
+const outputID = "test/gateway1/node1/temperature/0"
+
+// doRequest performs a request on the gateway and returns the response recorder
+func doRequest(gw *gateway.RestGateway, method string, path string, token string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	gw.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestRestGateway(t *testing.T) {
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{
+		ConfigFolder: tempFolder,
+		CacheFolder:  tempFolder,
+		Domain:       "test",
+		PublisherID:  "gateway1",
+	}
+	pub := publisher.NewPublisher(config, messaging.NewDummyMessenger(&messaging.MessengerConfig{}))
+	pub.Subscribe("test", "")
+	pub.Start()
+	defer pub.Stop()
+	pub.CreateNode("node1", types.NodeTypeMultisensor)
+	pub.CreateOutput("node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub.UpdateOutputValue("node1", types.OutputTypeTemperature, types.DefaultOutputInstance, "21.5")
+	pub.PublishUpdates()
+
+	gw := gateway.NewRestGateway(pub, &gateway.RestGatewayConfig{Tokens: []string{testToken}})
+
+	// requests without a valid token are rejected
+	assert.Equal(t, http.StatusUnauthorized, doRequest(gw, "GET", "/nodes", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, doRequest(gw, "GET", "/nodes", "wrong", "").Code)
+
+	response := doRequest(gw, "GET", gateway.PathNodes, testToken, "")
+	require.Equal(t, http.StatusOK, response.Code)
+	var nodeList []types.NodeDiscoveryMessage
+	err := json.Unmarshal(response.Body.Bytes(), &nodeList)
+	require.NoError(t, err)
+	assert.NotEmpty(t, nodeList)
+
+	response = doRequest(gw, "GET", gateway.PathPublishers, testToken, "")
+	assert.Equal(t, http.StatusOK, response.Code)
+	response = doRequest(gw, "GET", gateway.PathOutputs, testToken, "")
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	response = doRequest(gw, "GET", "/outputs/"+outputID+"/latest", testToken, "")
+	require.Equal(t, http.StatusOK, response.Code)
+	var latest types.OutputLatestMessage
+	err = json.Unmarshal(response.Body.Bytes(), &latest)
+	require.NoError(t, err)
+	assert.Equal(t, "21.5", latest.Value)
+
+	response = doRequest(gw, "GET", "/outputs/"+outputID+"/history", testToken, "")
+	require.Equal(t, http.StatusOK, response.Code)
+	var history types.OutputHistoryMessage
+	err = json.Unmarshal(response.Body.Bytes(), &history)
+	require.NoError(t, err)
+	assert.NotEmpty(t, history.History)
+
+	assert.Equal(t, http.StatusNotFound, doRequest(gw, "GET", "/outputs/test/gateway1/node1/humidity/0/latest", testToken, "").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(gw, "GET", "/unknown", testToken, "").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, doRequest(gw, "DELETE", gateway.PathNodes, testToken, "").Code)
+
+	// the gateway is read-only by default
+	assert.Equal(t, http.StatusForbidden, doRequest(gw, "POST", "/inputs/test/gateway1/node1/switch/0", testToken, "on").Code)
+}
+
+func TestRestGatewayStartStop(t *testing.T) {
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{
+		ConfigFolder: tempFolder,
+		CacheFolder:  tempFolder,
+		Domain:       "test",
+		PublisherID:  "gateway2",
+	}
+	pub := publisher.NewPublisher(config, messaging.NewDummyMessenger(&messaging.MessengerConfig{}))
+
+	// a token is required
+	gw := gateway.NewRestGateway(pub, &gateway.RestGatewayConfig{Listen: "127.0.0.1:0"})
+	assert.Error(t, gw.Start())
+
+	gw = gateway.NewRestGateway(pub, &gateway.RestGatewayConfig{Listen: "127.0.0.1:0", Tokens: []string{testToken}})
+	err := gw.Start()
+	require.NoError(t, err)
+	request, _ := http.NewRequest("GET", "http://"+gw.Address()+gateway.PathPublishers, nil)
+	request.Header.Set("Authorization", "Bearer "+testToken)
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	gw.Stop()
+	assert.Equal(t, "", gw.Address())
+	gw.Stop()
+}
+
+func TestRestGatewayRoles(t *testing.T) {
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{
+		ConfigFolder: tempFolder,
+		CacheFolder:  tempFolder,
+		Domain:       "test",
+		PublisherID:  "gateway3",
+	}
+	pub := publisher.NewPublisher(config, messaging.NewDummyMessenger(&messaging.MessengerConfig{}))
+	gw := gateway.NewRestGateway(pub, &gateway.RestGatewayConfig{
+		AllowSetInput: true,
+		CertRoles:     map[string]types.Role{"dashboard": types.RoleViewer},
+		Roles:         map[string]types.Role{"viewer": types.RoleViewer, "operator": types.RoleOperator, "typo": "superuser"},
+	})
+	const inputPath = "/inputs/test/unknown/node1/switch/0"
+
+	// viewers read but can't set inputs
+	assert.Equal(t, http.StatusOK, doRequest(gw, "GET", gateway.PathNodes, "viewer", "").Code)
+	assert.Equal(t, http.StatusForbidden, doRequest(gw, "POST", inputPath, "viewer", "on").Code)
+	// operators pass authorization. The set command fails as the publisher of the input is unknown
+	assert.Equal(t, http.StatusBadRequest, doRequest(gw, "POST", inputPath, "operator", "on").Code)
+	assert.Equal(t, http.StatusUnauthorized, doRequest(gw, "GET", gateway.PathNodes, "typo", "").Code)
+
+	// client certificates are mapped to roles by their common name
+	request := httptest.NewRequest("GET", gateway.PathNodes, nil)
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "dashboard"}}
+	request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	recorder := httptest.NewRecorder()
+	gw.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	request = httptest.NewRequest("POST", inputPath, strings.NewReader("on"))
+	request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	recorder = httptest.NewRecorder()
+	gw.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+}
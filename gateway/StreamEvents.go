@@ -0,0 +1,147 @@
+// Package gateway with a Server-Sent Events stream of live output values and publisher availability
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// StreamBufferSize is the nr of events that are queued for a slow stream client before events are dropped
+const StreamBufferSize = 100
+
+// Events in the stream
+const (
+	StreamEventStatus = "status" // data is the PublisherStatusMessage of a publisher
+	StreamEventValue  = "value"  // data is the OutputLatestMessage of an output
+)
+
+// StreamFilter selects the events that are sent to a stream client. Empty fields match all.
+// Status events are sent to clients of the publisher regardless of the node and output type.
+type StreamFilter struct {
+	NodeID      string           // node ID, from the 'node' query parameter
+	OutputType  types.OutputType // output type, from the 'type' query parameter
+	PublisherID string           // publisher ID, from the 'publisher' query parameter
+}
+
+// streamEvent is a server-sent event
+type streamEvent struct {
+	address string      // address of the publication this event is for
+	name    string      // StreamEventStatus or StreamEventValue
+	object  interface{} // the event message
+}
+
+// streamClient with the events queued for a connected stream client
+type streamClient struct {
+	eventName string           // only stream events with this name. Default is all events
+	events    chan streamEvent // queued events
+	filter    StreamFilter     // selection of events
+}
+
+// Matches returns true if the event of a publication address passes the filter
+//  address is domain/publisherID/$status or domain/publisherID/nodeID/outputType/instance/$latest
+func (filter *StreamFilter) Matches(address string) bool {
+	segments := strings.Split(address, "/")
+	if len(segments) < 3 {
+		return false
+	}
+	if filter.PublisherID != "" && filter.PublisherID != segments[1] {
+		return false
+	}
+	if len(segments) == 3 {
+		// publisher status
+		return true
+	}
+	if filter.NodeID != "" && filter.NodeID != segments[2] {
+		return false
+	}
+	if filter.OutputType != "" && (len(segments) < 5 || string(filter.OutputType) != segments[3]) {
+		return false
+	}
+	return true
+}
+
+// broadcast queues an event for the clients whose filter it passes
+func (gateway *RestGateway) broadcast(event streamEvent) {
+	gateway.updateMutex.Lock()
+	defer gateway.updateMutex.Unlock()
+	for client := range gateway.streamClients {
+		if (client.eventName != "" && client.eventName != event.name) || !client.filter.Matches(event.address) {
+			continue
+		}
+		select {
+		case client.events <- event:
+		default:
+			logrus.Warningf("RestGateway.broadcast: Stream client is too slow. Dropped event of %s", event.address)
+		}
+	}
+}
+
+// handleStatus streams a received publisher status
+func (gateway *RestGateway) handleStatus(status *types.PublisherStatusMessage) {
+	gateway.broadcast(streamEvent{address: status.Address, name: StreamEventStatus, object: status})
+}
+
+// handleValue streams a received output value
+func (gateway *RestGateway) handleValue(latest *types.OutputLatestMessage) {
+	gateway.broadcast(streamEvent{address: latest.Address, name: StreamEventValue, object: latest})
+}
+
+// serveStream sends the events that pass the filter of the query parameters as Server-Sent Events
+// until the client disconnects
+func (gateway *RestGateway) serveStream(response http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	filter := StreamFilter{
+		NodeID:      query.Get("node"),
+		OutputType:  types.OutputType(query.Get("type")),
+		PublisherID: query.Get("publisher"),
+	}
+	gateway.streamEvents(response, request, "", filter, func(event streamEvent) (string, string) {
+		data, _ := json.Marshal(event.object)
+		return event.name, string(data)
+	})
+}
+
+// streamEvents sends the events that pass the filter as Server-Sent Events until the client disconnects
+//  eventName selects the events to send. Use "" for all events.
+//  format returns the name and data of the sent event
+func (gateway *RestGateway) streamEvents(response http.ResponseWriter, request *http.Request,
+	eventName string, filter StreamFilter, format func(event streamEvent) (name string, data string)) {
+	flusher, ok := response.(http.Flusher)
+	if !ok {
+		http.Error(response, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	client := &streamClient{
+		eventName: eventName,
+		events:    make(chan streamEvent, StreamBufferSize),
+		filter:    filter,
+	}
+	gateway.updateMutex.Lock()
+	gateway.streamClients[client] = true
+	gateway.updateMutex.Unlock()
+	defer func() {
+		gateway.updateMutex.Lock()
+		delete(gateway.streamClients, client)
+		gateway.updateMutex.Unlock()
+	}()
+
+	response.Header().Set("Content-Type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case event := <-client.events:
+			name, data := format(event)
+			fmt.Fprintf(response, "event: %s\ndata: %s\n\n", name, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package gateway_test
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/gateway"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamFilter(t *testing.T) {
+	filter := gateway.StreamFilter{PublisherID: "pub1", OutputType: types.OutputTypeTemperature}
+	assert.True(t, filter.Matches("test/pub1/node1/temperature/0/$latest"))
+	assert.True(t, filter.Matches("test/pub1/$status"))
+	assert.False(t, filter.Matches("test/pub2/node1/temperature/0/$latest"))
+	assert.False(t, filter.Matches("test/pub2/$status"))
+	assert.False(t, filter.Matches("test/pub1/node1/humidity/0/$latest"))
+
+	filter = gateway.StreamFilter{NodeID: "node1"}
+	assert.True(t, filter.Matches("test/pub2/node1/humidity/0/$latest"))
+	assert.False(t, filter.Matches("test/pub2/node2/humidity/0/$latest"))
+	assert.False(t, filter.Matches("invalid"))
+}
+
+func TestStreamEvents(t *testing.T) {
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{
+		ConfigFolder: tempFolder,
+		CacheFolder:  tempFolder,
+		Domain:       "test",
+		PublisherID:  "stream1",
+	}
+	pub := publisher.NewPublisher(config, messaging.NewDummyMessenger(&messaging.MessengerConfig{}))
+	pub.Subscribe("test", "")
+	pub.Start()
+	defer pub.Stop()
+	pub.CreateNode("node1", types.NodeTypeMultisensor)
+	pub.CreateOutput("node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub.CreateOutput("node1", types.OutputTypeHumidity, types.DefaultOutputInstance)
+	pub.PublishUpdates()
+
+	gw := gateway.NewRestGateway(pub, &gateway.RestGatewayConfig{Tokens: []string{testToken}})
+	server := httptest.NewServer(gw)
+	defer server.Close()
+	request, _ := http.NewRequest("GET", server.URL+gateway.PathStream+"?publisher=stream1&type=temperature", nil)
+	request.Header.Set("Authorization", "Bearer "+testToken)
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	// the humidity value is filtered out
+	pub.UpdateOutputValue("node1", types.OutputTypeHumidity, types.DefaultOutputInstance, "50")
+	pub.UpdateOutputValue("node1", types.OutputTypeTemperature, types.DefaultOutputInstance, "21.5")
+	pub.PublishUpdates()
+	pub.SetPublisherStatus(types.PublisherRunStateConnected)
+
+	lines := make(chan string, 10)
+	go func() {
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			if scanner.Text() != "" {
+				lines <- scanner.Text()
+			}
+		}
+		close(lines)
+	}()
+	received := make([]string, 0)
+	timeout := time.After(time.Second)
+	for len(received) < 4 {
+		select {
+		case line := <-lines:
+			received = append(received, line)
+		case <-timeout:
+			require.Fail(t, "Missing stream events", "received: %v", received)
+		}
+	}
+	assert.Equal(t, "event: "+gateway.StreamEventValue, received[0])
+	assert.True(t, strings.HasPrefix(received[1], "data: "))
+	assert.Contains(t, received[1], "21.5")
+	assert.NotContains(t, received[1], "humidity")
+	assert.Equal(t, "event: "+gateway.StreamEventStatus, received[2])
+	assert.Contains(t, received[3], string(types.PublisherRunStateConnected))
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
@@ -82,6 +83,30 @@ func (pubIdentities *DomainPublisherIdentities) GetPublisherKey(publisherAddress
 	return pubKey
 }
 
+// IsUnsignedMessageType returns true if the publisher of the address has declared the message type of
+// the address as unsigned in its identity. Commands and identities are never unsigned.
+//  address is a publication address of the form domain/publisherId/.../messageType
+func (pubIdentities *DomainPublisherIdentities) IsUnsignedMessageType(address string) bool {
+	segments := strings.Split(address, "/")
+	if len(segments) < 3 {
+		return false
+	}
+	messageType := messaging.MessageTypeFromAddress(address)
+	if messaging.IsSigningRequired(messageType) {
+		return false
+	}
+	identity := pubIdentities.GetPublisherByAddress(MakePublisherIdentityAddress(segments[0], segments[1]))
+	if identity == nil {
+		return false
+	}
+	for _, unsignedType := range identity.UnsignedTypes {
+		if unsignedType == messageType {
+			return true
+		}
+	}
+	return false
+}
+
 // LoadIdentities loads previously save identities from file
 // Existing identities are retained but replaced if contained in the file
 func (pubIdentities *DomainPublisherIdentities) LoadIdentities(filename string) error {
@@ -140,6 +165,14 @@ func (pubIdentities *DomainPublisherIdentities) UpdateCount() int {
 func VerifyPublisherIdentity(rxAddress string, ident *types.PublisherIdentityMessage,
 	dssSigningKey *ecdsa.PublicKey) error {
 
+	return verifyPublisherIdentity(rxAddress, ident, dssSigningKey, 0)
+}
+
+// verifyPublisherIdentity verifies the integrity of the given identity record
+// expiryMargin is the time an identity is still accepted after it expired
+func verifyPublisherIdentity(rxAddress string, ident *types.PublisherIdentityMessage,
+	dssSigningKey *ecdsa.PublicKey, expiryMargin time.Duration) error {
+
 	var signingKey *ecdsa.PublicKey
 
 	// identity must contain public key, issuer and signature
@@ -171,7 +204,7 @@ func VerifyPublisherIdentity(rxAddress string, ident *types.PublisherIdentityMes
 	}
 
 	// identity must not be expired
-	expired := IsIdentityExpired(ident)
+	expired := IsIdentityExpiredWithin(ident, expiryMargin)
 	if expired {
 		err := lib.MakeErrorf("VerifyIdentity: Identity '%s' is expired", rxAddress)
 		return err
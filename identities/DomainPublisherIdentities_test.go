@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/identities"
 	"github.com/iotdomain/iotdomain-go/messaging"
@@ -210,3 +211,213 @@ func TestDSSiscovery(t *testing.T) {
 
 	receiver.Stop()
 }
+
+func TestDomainTimeSync(t *testing.T) {
+	const publisher1ID = "publisher1"
+	timeSync := identities.NewDomainTimeSync(time.Second)
+	assert.True(t, timeSync.IsInSync())
+
+	// the first message of a sender is ignored as it can be retained
+	ahead := time.Now().Add(time.Minute)
+	_, err := timeSync.CheckTimestamp(publisher1ID, ahead.Format(types.TimeFormat))
+	assert.NoError(t, err)
+	assert.True(t, timeSync.IsInSync())
+	skew, err := timeSync.CheckTimestamp(publisher1ID, ahead.Add(time.Second).Format(types.TimeFormat))
+	assert.NoError(t, err)
+	assert.InDelta(t, float64(time.Minute), float64(skew), float64(2*time.Second))
+	assert.False(t, timeSync.IsInSync())
+
+	// the DSS time is leading
+	timeSync.CheckTimestamp(types.DSSPublisherID, time.Now().Add(-time.Minute).Format(types.TimeFormat))
+	timeSync.CheckTimestamp(types.DSSPublisherID, time.Now().Format(types.TimeFormat))
+	assert.True(t, timeSync.IsInSync())
+
+	_, err = timeSync.CheckTimestamp(publisher1ID, "not a time")
+	assert.Error(t, err)
+
+	// verification windows are only widened when enabled
+	timeSync.CheckTimestamp(types.DSSPublisherID, time.Now().Add(time.Hour).Format(types.TimeFormat))
+	assert.Equal(t, time.Second, timeSync.VerificationWindow(time.Second))
+	timeSync.SetAutoWiden(true)
+	assert.True(t, timeSync.VerificationWindow(time.Second) > time.Hour)
+
+	// recently expired identity is accepted within the window
+	ident := &types.PublisherIdentityMessage{ValidUntil: time.Now().Add(-time.Minute).Format(types.TimeFormat)}
+	assert.True(t, identities.IsIdentityExpired(ident))
+	assert.False(t, identities.IsIdentityExpiredWithin(ident, timeSync.VerificationWindow(0)))
+}
+
+func TestStatusTimeSync(t *testing.T) {
+	const domain = "test"
+	collection := identities.NewDomainPublisherIdentities()
+	messenger := messaging.NewDummyMessenger(dummyConfig)
+	signer1 := messaging.NewMessageSigner(messenger, messaging.CreateAsymKeys(), collection.GetPublisherKey)
+	timeSync := identities.NewDomainTimeSync(time.Second)
+	domainStatus := identities.NewDomainPublisherStatus(signer1)
+	domainStatus.SetTimeSync(timeSync)
+	domainStatus.Subscribe(domain, types.DSSPublisherID)
+
+	dssIdent, dssKeys := identities.CreateIdentity(domain, types.DSSPublisherID)
+	collection.AddIdentity(&dssIdent.PublisherIdentityMessage)
+	dssSigner := messaging.NewMessageSigner(messenger, dssKeys, collection.GetPublisherKey)
+	status := types.PublisherStatusMessage{
+		Address: identities.MakePublisherStatusAddress(domain, types.DSSPublisherID),
+		Status:  types.PublisherRunStateConnected,
+	}
+
+	// the status timestamp of the DSS is a sample of the domain time. The first one can be retained.
+	ahead := time.Now().Add(time.Minute)
+	status.Timestamp = ahead.Format(types.TimeFormat)
+	identities.PublishStatus(&status, dssSigner)
+	assert.True(t, timeSync.IsInSync())
+	status.Timestamp = ahead.Add(time.Second).Format(types.TimeFormat)
+	identities.PublishStatus(&status, dssSigner)
+	assert.NotNil(t, domainStatus.GetStatus(status.Address))
+	assert.False(t, timeSync.IsInSync())
+	assert.InDelta(t, float64(time.Minute), float64(timeSync.ClockSkew()), float64(2*time.Second))
+
+	// a status without timestamp is not a sample
+	status.Timestamp = ""
+	identities.PublishStatus(&status, dssSigner)
+	assert.False(t, timeSync.IsInSync())
+	domainStatus.Unsubscribe(domain, types.DSSPublisherID)
+}
+
+func TestTrustStorePinning(t *testing.T) {
+	const domain = "test"
+	const publisher2ID = "pub2"
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	trustStoreFile := tempFolder + "/pub1" + identities.TrustStoreFileSuffix
+
+	// setup test
+	keyChangeCount := 0
+	collection := identities.NewDomainPublisherIdentities()
+	privKey := messaging.CreateAsymKeys()
+	messenger := messaging.NewDummyMessenger(dummyConfig)
+	signer1 := messaging.NewMessageSigner(messenger, privKey, collection.GetPublisherKey)
+	receiver := identities.NewReceivePublisherIdentities(domain, collection, signer1)
+	trustStore := identities.NewTrustStore(trustStoreFile, func(addr string, pinnedKey string, newKey string) {
+		keyChangeCount++
+		assert.NotEqual(t, pinnedKey, newKey)
+	})
+	receiver.SetTrustStore(trustStore)
+	receiver.Start()
+	defer receiver.Stop()
+
+	// the first identity of publisher 2 is pinned
+	pub2Ident, pub2Keys := identities.CreateIdentity(domain, publisher2ID)
+	signer2 := messaging.NewMessageSigner(messenger, pub2Keys, collection.GetPublisherKey)
+	addr2 := identities.MakePublisherIdentityAddress(domain, publisher2ID)
+	signer2.PublishObject(addr2, false, pub2Ident.PublisherIdentityMessage, nil)
+	require.NotNil(t, collection.GetPublisherByAddress(addr2), "Expected the discovered publisher 2")
+	assert.Equal(t, pub2Ident.PublicKey, trustStore.GetPinnedKey(domain+"/"+publisher2ID))
+
+	// the same key is accepted again
+	err := receiver.ReceiveDomainIdentity(addr2, messenger.FindLastPublication(addr2))
+	assert.NoError(t, err)
+
+	// a self-signed identity with a different key is rejected
+	spoofIdent, spoofKeys := identities.CreateIdentity(domain, publisher2ID)
+	spoofIdent.Location = "spoofed"
+	messaging.SignIdentity(&spoofIdent.PublisherIdentityMessage, spoofKeys)
+	spoofSigner := messaging.NewMessageSigner(messenger, spoofKeys, collection.GetPublisherKey)
+	spoofSigner.PublishObject(addr2, false, spoofIdent.PublisherIdentityMessage, nil)
+	assert.Equal(t, 1, keyChangeCount, "Expected a key change notification")
+	pub2b := collection.GetPublisherByAddress(addr2)
+	require.NotNil(t, pub2b)
+	assert.NotEqual(t, "spoofed", pub2b.Location, "Identity with changed key should be rejected")
+
+	// pinned keys are persisted
+	trustStore2 := identities.NewTrustStore(trustStoreFile, nil)
+	err = trustStore2.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, pub2Ident.PublicKey, trustStore2.GetPinnedKey(domain+"/"+publisher2ID))
+
+	// after unpinning the new key is pinned on first use
+	trustStore.Unpin(domain + "/" + publisher2ID)
+	spoofSigner.PublishObject(addr2, false, spoofIdent.PublisherIdentityMessage, nil)
+	assert.Equal(t, 1, keyChangeCount)
+	assert.Equal(t, spoofIdent.PublicKey, trustStore.GetPinnedKey(domain+"/"+publisher2ID))
+
+	// error case - missing trust store file
+	trustStore3 := identities.NewTrustStore(tempFolder+"/notafile.json", nil)
+	err = trustStore3.Load()
+	assert.Error(t, err)
+}
+
+func TestIdentityConflict(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "pub1"
+	var conflicting *types.PublisherIdentityMessage
+
+	// setup test
+	collection := identities.NewDomainPublisherIdentities()
+	regIdent := identities.NewRegisteredIdentity(domain, publisher1ID, "")
+	myIdent, privKey := regIdent.GetFullIdentity()
+	collection.AddIdentity(&myIdent.PublisherIdentityMessage)
+	messenger := messaging.NewDummyMessenger(dummyConfig)
+	signer1 := messaging.NewMessageSigner(messenger, privKey, collection.GetPublisherKey)
+	receiver := identities.NewReceivePublisherIdentities(domain, collection, signer1)
+	receiver.SetIdentityConflictHandler(regIdent, func(identity *types.PublisherIdentityMessage) {
+		conflicting = identity
+	})
+	receiver.Start()
+	defer receiver.Stop()
+
+	// our own identity is accepted
+	addr1 := identities.MakePublisherIdentityAddress(domain, publisher1ID)
+	signer1.PublishObject(addr1, false, myIdent.PublisherIdentityMessage, nil)
+	assert.Nil(t, conflicting)
+
+	// an identity with our publisher ID and a different key is rejected
+	otherIdent, otherKeys := identities.CreateIdentity(domain, publisher1ID)
+	otherSigner := messaging.NewMessageSigner(messenger, otherKeys, collection.GetPublisherKey)
+	otherSigner.PublishObject(addr1, false, otherIdent.PublisherIdentityMessage, nil)
+	require.NotNil(t, conflicting, "Expected an identity conflict")
+	assert.Equal(t, otherIdent.PublicKey, conflicting.PublicKey)
+	err := receiver.ReceiveDomainIdentity(addr1, messenger.FindLastPublication(addr1))
+	assert.Error(t, err)
+	assert.Equal(t, myIdent.PublicKey, collection.GetPublisherByAddress(addr1).PublicKey)
+}
+
+func TestArbitrateIdentity(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "pub1"
+	ident1, _ := identities.CreateIdentity(domain, publisher1ID)
+	ident2, _ := identities.CreateIdentity(domain, publisher1ID)
+	first := ident1.PublisherIdentityMessage
+	second := ident2.PublisherIdentityMessage
+	first.Created = time.Now().Add(-time.Hour).Format(types.TimeFormat)
+	first.Timestamp = first.Created
+
+	// the first self-signed identity holds the claim
+	assert.True(t, identities.ArbitrateIdentity(&first, &second))
+	assert.False(t, identities.ArbitrateIdentity(&second, &first))
+
+	// signing the identity again doesn't change the claim
+	first.Timestamp = time.Now().Add(time.Minute).Format(types.TimeFormat)
+	assert.True(t, identities.ArbitrateIdentity(&first, &second))
+	first.Timestamp = first.Created
+
+	// priority wins over time
+	second.Priority = 1
+	assert.False(t, identities.ArbitrateIdentity(&first, &second))
+	assert.True(t, identities.ArbitrateIdentity(&second, &first))
+
+	// DSS-issued wins over priority
+	first.IssuerID = types.DSSPublisherID
+	assert.True(t, identities.ArbitrateIdentity(&first, &second))
+
+	// the most recently DSS-issued identity holds the claim
+	second.IssuerID = types.DSSPublisherID
+	second.Priority = 0
+	assert.False(t, identities.ArbitrateIdentity(&first, &second))
+	assert.True(t, identities.ArbitrateIdentity(&second, &first))
+
+	// invalid timestamps lose and identical timestamps are decided by key
+	second.Timestamp = "invalid"
+	assert.True(t, identities.ArbitrateIdentity(&first, &second))
+	second.Timestamp = first.Timestamp
+	assert.NotEqual(t, identities.ArbitrateIdentity(&first, &second), identities.ArbitrateIdentity(&second, &first))
+}
@@ -0,0 +1,105 @@
+// Package identities with tracking of the runtime status of domain publishers
+package identities
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// PublisherStatusHandler is invoked when the status of a domain publisher is received
+//  status is the received status. Its Address is the status address of the publisher.
+type PublisherStatusHandler func(status *types.PublisherStatusMessage)
+
+// DomainPublisherStatus tracks the availability of domain publishers from their $status publications.
+// This includes the 'lost' status that the message bus publishes when a publisher unexpectedly disconnects.
+type DomainPublisherStatus struct {
+	messageSigner *messaging.MessageSigner                 // subscription to status messages
+	onStatus      PublisherStatusHandler                   // optional notification of received status
+	statusMap     map[string]*types.PublisherStatusMessage // status by publisher address domain/publisherID
+	timeSync      *DomainTimeSync                          // optional clock skew tracking of received status
+	updateMutex   *sync.Mutex                              // mutex for async updating of the status
+}
+
+// GetStatus returns the last received status of a publisher, or nil if no status was received
+//  address is the publisher address domain/publisherID, with or without message type
+func (domainStatus *DomainPublisherStatus) GetStatus(address string) *types.PublisherStatusMessage {
+	domainStatus.updateMutex.Lock()
+	defer domainStatus.updateMutex.Unlock()
+	return domainStatus.statusMap[lib.MakeBaseAddress(address)]
+}
+
+// SetOnStatus sets the handler that is invoked when a publisher status is received. Use nil to remove the handler.
+func (domainStatus *DomainPublisherStatus) SetOnStatus(handler PublisherStatusHandler) {
+	domainStatus.updateMutex.Lock()
+	defer domainStatus.updateMutex.Unlock()
+	domainStatus.onStatus = handler
+}
+
+// SetTimeSync sets the clock skew tracker that is updated with the publication timestamp of received status
+func (domainStatus *DomainPublisherStatus) SetTimeSync(timeSync *DomainTimeSync) {
+	domainStatus.updateMutex.Lock()
+	defer domainStatus.updateMutex.Unlock()
+	domainStatus.timeSync = timeSync
+}
+
+// Subscribe to the status of domain publishers
+func (domainStatus *DomainPublisherStatus) Subscribe(domain string, publisherID string) {
+	addr := MakePublisherStatusAddress(domain, publisherID)
+	domainStatus.messageSigner.Subscribe(addr, domainStatus.handleStatus)
+}
+
+// Unsubscribe from the status of domain publishers
+func (domainStatus *DomainPublisherStatus) Unsubscribe(domain string, publisherID string) {
+	addr := MakePublisherStatusAddress(domain, publisherID)
+	domainStatus.messageSigner.Unsubscribe(addr, domainStatus.handleStatus)
+}
+
+// handleStatus updates the status of a publisher
+// Status messages must be signed by their publisher, except for the last will 'lost' status as
+// the message bus publishes it on behalf of the publisher.
+func (domainStatus *DomainPublisherStatus) handleStatus(address string, message string) error {
+	var statusMsg types.PublisherStatusMessage
+	if message == "" {
+		// retained status is cleared
+		return nil
+	} else if message == string(types.PublisherRunStateLost) {
+		statusMsg.Status = types.PublisherRunStateLost
+	} else {
+		isSigned, err := domainStatus.messageSigner.VerifySignedMessage(message, &statusMsg)
+		if err != nil {
+			return lib.MakeErrorf("handleStatus: Failed verifying signature on address %s: %s", address, err)
+		} else if !isSigned && !domainStatus.messageSigner.IsUnsignedAllowed(address) {
+			return lib.MakeErrorf("handleStatus: Status on address %s isn't signed. Ignored", address)
+		}
+	}
+	statusMsg.Address = address
+
+	domainStatus.updateMutex.Lock()
+	domainStatus.statusMap[lib.MakeBaseAddress(address)] = &statusMsg
+	handler := domainStatus.onStatus
+	timeSync := domainStatus.timeSync
+	domainStatus.updateMutex.Unlock()
+	// the timestamp is set when the status is published so it is a fresh sample of the sender's clock.
+	// CheckTimestamp logs invalid timestamps.
+	segments := strings.Split(address, "/")
+	if timeSync != nil && statusMsg.Timestamp != "" && len(segments) >= 2 {
+		timeSync.CheckTimestamp(segments[1], statusMsg.Timestamp)
+	}
+	if handler != nil {
+		handler(&statusMsg)
+	}
+	return nil
+}
+
+// NewDomainPublisherStatus creates a new instance for tracking the status of domain publishers
+func NewDomainPublisherStatus(messageSigner *messaging.MessageSigner) *DomainPublisherStatus {
+	return &DomainPublisherStatus{
+		messageSigner: messageSigner,
+		statusMap:     make(map[string]*types.PublisherStatusMessage),
+		updateMutex:   &sync.Mutex{},
+	}
+}
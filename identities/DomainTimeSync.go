@@ -0,0 +1,127 @@
+// Package identities with tracking of the clock skew between this publisher and the domain
+package identities
+
+import (
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// DefaultMaxClockSkew is the clock difference above which the clocks are considered out of sync
+const DefaultMaxClockSkew = 5 * time.Second
+
+// clockSkewSamples is the nr of samples the clock skew estimate is averaged over
+const clockSkewSamples = 10
+
+// DomainTimeSync estimates the clock skew between this publisher and the domain from the
+// publication timestamps of received DSS and publisher status messages. If the DSS is known its time is leading.
+// The estimate includes the message transit time so small differences are normal.
+type DomainTimeSync struct {
+	autoWiden       bool                 // widen verification windows with the clock skew
+	clockSkew       time.Duration        // averaged skew of publishers, remote minus local time
+	dssSkew         time.Duration        // most recent skew of the DSS, remote minus local time
+	hasDSSSkew      bool                 // the DSS skew is known
+	localID         string               // publisher ID of this publisher whose own messages are ignored
+	maxSkew         time.Duration        // skew above which clocks are out of sync
+	sampleCount     int                  // nr of samples in the clock skew estimate
+	senderTimestamp map[string]time.Time // previous timestamp by sender
+	updateMutex     *sync.Mutex          // mutex for async updating of the estimate
+}
+
+// CheckTimestamp compares the timestamp of a message from the given publisher with the local time
+// and updates the clock skew estimate. This returns the skew of this timestamp, remote minus local time.
+// The first message of each sender is not used in the estimate as it can be a retained message from the past.
+// Publishers republish their status periodically so the following messages are fresh samples.
+func (timeSync *DomainTimeSync) CheckTimestamp(publisherID string, timestamp string) (time.Duration, error) {
+	remoteTime, err := time.Parse(types.TimeFormat, timestamp)
+	if err != nil {
+		return 0, lib.MakeErrorf("CheckTimestamp: Invalid timestamp '%s' from publisher %s", timestamp, publisherID)
+	}
+	skew := time.Until(remoteTime)
+
+	timeSync.updateMutex.Lock()
+	defer timeSync.updateMutex.Unlock()
+	prevTime, seen := timeSync.senderTimestamp[publisherID]
+	timeSync.senderTimestamp[publisherID] = remoteTime
+	if !seen || !remoteTime.After(prevTime) || publisherID == timeSync.localID {
+		return skew, nil
+	}
+	if publisherID == types.DSSPublisherID {
+		timeSync.dssSkew = skew
+		timeSync.hasDSSSkew = true
+	}
+	if timeSync.sampleCount < clockSkewSamples {
+		timeSync.sampleCount++
+	}
+	timeSync.clockSkew += (skew - timeSync.clockSkew) / time.Duration(timeSync.sampleCount)
+	return skew, nil
+}
+
+// ClockSkew returns the estimated clock skew of the domain, remote minus local time.
+// This is the skew of the DSS if known, otherwise the average skew of publishers.
+func (timeSync *DomainTimeSync) ClockSkew() time.Duration {
+	timeSync.updateMutex.Lock()
+	defer timeSync.updateMutex.Unlock()
+	if timeSync.hasDSSSkew {
+		return timeSync.dssSkew
+	}
+	return timeSync.clockSkew
+}
+
+// IsInSync returns true if the estimated clock skew doesn't exceed the maximum skew
+func (timeSync *DomainTimeSync) IsInSync() bool {
+	skew := timeSync.ClockSkew()
+	return skew <= timeSync.maxSkew && skew >= -timeSync.maxSkew
+}
+
+// SampleCount returns the nr of samples in the clock skew estimate, up to the nr of samples that are averaged
+func (timeSync *DomainTimeSync) SampleCount() int {
+	timeSync.updateMutex.Lock()
+	defer timeSync.updateMutex.Unlock()
+	return timeSync.sampleCount
+}
+
+// SetAutoWiden enables widening of the verification windows with the estimated clock skew
+func (timeSync *DomainTimeSync) SetAutoWiden(autoWiden bool) {
+	timeSync.updateMutex.Lock()
+	defer timeSync.updateMutex.Unlock()
+	timeSync.autoWiden = autoWiden
+}
+
+// SetLocalPublisherID sets the ID of this publisher. Its own messages are not used in the estimate.
+func (timeSync *DomainTimeSync) SetLocalPublisherID(publisherID string) {
+	timeSync.updateMutex.Lock()
+	defer timeSync.updateMutex.Unlock()
+	timeSync.localID = publisherID
+}
+
+// VerificationWindow returns the given window widened with the size of the clock skew if auto widen is enabled
+func (timeSync *DomainTimeSync) VerificationWindow(window time.Duration) time.Duration {
+	timeSync.updateMutex.Lock()
+	autoWiden := timeSync.autoWiden
+	timeSync.updateMutex.Unlock()
+	if !autoWiden {
+		return window
+	}
+	skew := timeSync.ClockSkew()
+	if skew < 0 {
+		skew = -skew
+	}
+	return window + skew
+}
+
+// NewDomainTimeSync creates a clock skew tracker
+//  maxSkew is the skew above which clocks are out of sync. Use 0 for DefaultMaxClockSkew
+func NewDomainTimeSync(maxSkew time.Duration) *DomainTimeSync {
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxClockSkew
+	}
+	timeSync := &DomainTimeSync{
+		maxSkew:         maxSkew,
+		senderTimestamp: make(map[string]time.Time),
+		updateMutex:     &sync.Mutex{},
+	}
+	return timeSync
+}
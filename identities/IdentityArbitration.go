@@ -0,0 +1,57 @@
+// Package identities with arbitration between publishers that claim the same publisher ID
+package identities
+
+import (
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// ArbitrateIdentity determines which of two identities with the same address holds the claim to
+// the publisher ID. Both publishers reach the same decision so the other backs off. In order:
+// 1. An identity issued by the DSS wins from a self-signed identity
+// 2. The identity with the highest priority wins
+// 3. When both are issued by the DSS, the most recently issued identity wins as it replaces the older one
+// 4. When both are self-signed, the identity that was created first wins. This uses the creation time
+//    as the timestamp changes each time the identity is signed again. Older identities without creation
+//    time use the timestamp.
+// 5. The identity with the lowest public key wins, so the outcome is always decided
+// Identities are expected to be verified. A timestamp that can't be parsed loses.
+//  myIdentity is the identity of this publisher
+//  otherIdentity is the conflicting identity
+// This returns true if myIdentity holds the claim.
+func ArbitrateIdentity(myIdentity *types.PublisherIdentityMessage, otherIdentity *types.PublisherIdentityMessage) bool {
+	myDSS := myIdentity.IssuerID == types.DSSPublisherID
+	otherDSS := otherIdentity.IssuerID == types.DSSPublisherID
+	if myDSS != otherDSS {
+		return myDSS
+	} else if myIdentity.Priority != otherIdentity.Priority {
+		return myIdentity.Priority > otherIdentity.Priority
+	}
+	myTimestamp := myIdentity.Timestamp
+	otherTimestamp := otherIdentity.Timestamp
+	if !myDSS {
+		myTimestamp = getCreationTime(myIdentity)
+		otherTimestamp = getCreationTime(otherIdentity)
+	}
+	myTime, myErr := time.Parse(types.TimeFormat, myTimestamp)
+	otherTime, otherErr := time.Parse(types.TimeFormat, otherTimestamp)
+	if (myErr == nil) != (otherErr == nil) {
+		return myErr == nil
+	} else if myErr == nil && !myTime.Equal(otherTime) {
+		if myDSS {
+			return myTime.After(otherTime)
+		}
+		return myTime.Before(otherTime)
+	}
+	return myIdentity.PublicKey < otherIdentity.PublicKey
+}
+
+// getCreationTime returns the time the identity was created, or its timestamp if the identity
+// doesn't have a creation time.
+func getCreationTime(identity *types.PublisherIdentityMessage) string {
+	if identity.Created != "" {
+		return identity.Created
+	}
+	return identity.Timestamp
+}
@@ -0,0 +1,74 @@
+// Package identities with storage of the issuer signature of the registered identity
+package identities
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/lib/atomicfile"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// IdentityMetaFileSuffix replaces the .json extension of the identity file to name the file with
+// the issuer signature and validity, eg publisher1-identity-meta.json
+const IdentityMetaFileSuffix = "-meta.json"
+
+// IdentityMeta with the signature and validity of the identity as issued by the DSS or self-signed.
+// This is stored separately from the key material so a renewal by the DSS doesn't rewrite the keys.
+type IdentityMeta struct {
+	Checksum          string `json:"checksum"`   // sha256 of the public key and the fields below
+	IdentitySignature string `json:"signature"`  // base64 encoded signature of the identity
+	IssuerID          string `json:"issuerId"`   // Issuer of the identity, the DSS or publisherId
+	Timestamp         string `json:"timestamp"`  // time the identity was issued
+	ValidUntil        string `json:"validUntil"` // time the identity expires
+}
+
+// checksum returns the integrity checksum of the meta data for the given public key
+func (meta *IdentityMeta) checksum(publicKeyPem string) string {
+	fields := []string{publicKeyPem, meta.IssuerID, meta.Timestamp, meta.ValidUntil, meta.IdentitySignature}
+	hash := sha256.Sum256([]byte(strings.Join(fields, "\n")))
+	return hex.EncodeToString(hash[:])
+}
+
+// loadIdentityMeta loads the identity meta data and verifies that it belongs to the public key
+// This returns nil without error if no meta data file exists.
+func loadIdentityMeta(filename string, publicKeyPem string) (*IdentityMeta, error) {
+	metaJSON, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, lib.MakeErrorf("loadIdentityMeta: Unable to read %s: %s", filename, err)
+	}
+	meta := &IdentityMeta{}
+	err = json.Unmarshal(metaJSON, meta)
+	if err != nil {
+		return nil, lib.MakeErrorf("loadIdentityMeta: Error parsing %s: %s", filename, err)
+	}
+	if meta.Checksum != meta.checksum(publicKeyPem) {
+		return nil, lib.MakeErrorf("loadIdentityMeta: Integrity check of %s failed. It doesn't belong to the identity keys", filename)
+	}
+	return meta, nil
+}
+
+// saveIdentityMeta saves the signature and validity of the identity with its integrity checksum
+func saveIdentityMeta(filename string, identity *types.PublisherIdentityMessage) error {
+	meta := &IdentityMeta{
+		IdentitySignature: identity.IdentitySignature,
+		IssuerID:          identity.IssuerID,
+		Timestamp:         identity.Timestamp,
+		ValidUntil:        identity.ValidUntil,
+	}
+	meta.Checksum = meta.checksum(identity.PublicKey)
+	metaJSON, _ := json.MarshalIndent(meta, " ", " ")
+	return atomicfile.WriteFile(filename, metaJSON, 0400)
+}
+
+// makeIdentityMetaFilename returns the name of the identity meta data file for the identity file
+func makeIdentityMetaFilename(identityFile string) string {
+	return strings.TrimSuffix(identityFile, ".json") + IdentityMetaFileSuffix
+}
@@ -0,0 +1,38 @@
+// Package identities with command to control a remote publisher
+package identities
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// MakePublisherControlAddress returns the address to send publisher control commands to
+func MakePublisherControlAddress(domain string, publisherID string) string {
+	address := fmt.Sprintf("%s/%s/%s", domain, publisherID, types.MessageTypeControl)
+	return address
+}
+
+// PublishControl publishes a command to control a remote publisher. This signs and encrypts
+// the message for the destination. The sender must be authorized by the destination publisher.
+func PublishControl(
+	domain string, publisherID string, command types.PublisherControlCommand, value string, sender string,
+	messageSigner *messaging.MessageSigner, encryptionKey *ecdsa.PublicKey) error {
+
+	controlAddr := MakePublisherControlAddress(domain, publisherID)
+	logrus.Infof("PublishControl: publishing encrypted command '%s' to %s", command, controlAddr)
+	timeStampStr := time.Now().Format("2006-01-02T15:04:05.000-0700")
+	var message = types.PublisherControlMessage{
+		Address:   controlAddr,
+		Command:   command,
+		Sender:    sender,
+		Timestamp: timeStampStr,
+		Value:     value,
+	}
+	err := messageSigner.PublishObject(controlAddr, false, &message, encryptionKey)
+	return err
+}
@@ -2,19 +2,65 @@
 package identities
 
 import (
+	"time"
+
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/sirupsen/logrus"
 )
 
+// IdentityConflictHandler is invoked when an identity is received for the address of this publisher that
+// has a different key. This happens when two publishers run with the same publisher ID.
+//  identity is the conflicting identity that was received
+type IdentityConflictHandler func(identity *types.PublisherIdentityMessage)
+
+// PublisherJoinHandler is invoked when a verified identity of a previously unknown publisher is received,
+// or when a known publisher has a new public key. Republished identities of known publishers are not
+// passed to the handler.
+//  identity is the received identity
+type PublisherJoinHandler func(identity *types.PublisherIdentityMessage)
+
 // ReceiveDomainPublisherIdentities listens for publisher identities on the domain.
 // The domain identities are used to verify the signature of messages from a publisher
 // In secured domains the domain identity must be signed by the DSS.
 type ReceiveDomainPublisherIdentities struct {
-	domainIdentities *DomainPublisherIdentities
-	messageSigner    *messaging.MessageSigner // subscription to command
-	dssAddress       string                   // the DSS address for this domain
+	domainIdentities   *DomainPublisherIdentities
+	messageSigner      *messaging.MessageSigner // subscription to command
+	dssAddress         string                   // the DSS address for this domain
+	onConflict         IdentityConflictHandler  // optional notification of identity conflicts
+	onJoin             PublisherJoinHandler     // optional notification of received identities
+	registeredIdentity *RegisteredIdentity      // optional identity of this publisher to detect conflicts
+	timeSync           *DomainTimeSync          // optional clock skew margin for verifying identity expiry
+	trustStore         *TrustStore              // optional pinning of self-signed identity keys
+}
+
+// SetIdentityConflictHandler enables detection of identities that are received for the address of this
+// publisher but have a different key. Conflicting identities are rejected and passed to the handler.
+//  registeredIdentity is the identity of this publisher
+//  handler is invoked with the conflicting identity
+func (rxIdentity *ReceiveDomainPublisherIdentities) SetIdentityConflictHandler(
+	registeredIdentity *RegisteredIdentity, handler IdentityConflictHandler) {
+	rxIdentity.registeredIdentity = registeredIdentity
+	rxIdentity.onConflict = handler
+}
+
+// SetOnPublisherJoin sets the handler that is invoked with verified identities of publishers after they are
+// added to the domain identities. Use nil to remove the handler.
+func (rxIdentity *ReceiveDomainPublisherIdentities) SetOnPublisherJoin(handler PublisherJoinHandler) {
+	rxIdentity.onJoin = handler
+}
+
+// SetTimeSync sets the clock skew tracker whose verification window is used to accept recently expired
+// identities from skewed clocks. The identity timestamp is the time it was issued so it isn't used as a sample.
+func (rxIdentity *ReceiveDomainPublisherIdentities) SetTimeSync(timeSync *DomainTimeSync) {
+	rxIdentity.timeSync = timeSync
+}
+
+// SetTrustStore sets the store that pins the keys of self-signed identities on first use.
+// Self-signed identities whose key differs from the pinned key are rejected. Use nil to accept all keys.
+func (rxIdentity *ReceiveDomainPublisherIdentities) SetTrustStore(trustStore *TrustStore) {
+	rxIdentity.trustStore = trustStore
 }
 
 // Start listening for updates to the registered identity
@@ -36,7 +82,11 @@ func (rxIdentity *ReceiveDomainPublisherIdentities) Stop() {
 // This:
 // - verifies if the sender signature is valid
 // - verifies that the identity is signed by the DSS when in a secure domain
+// - rejects identities for the address of this publisher with a different key if conflict detection is set
+// - verifies the key of self-signed identities with the pinned key if a trust store is set
+// - updates the clock skew estimate if a time sync tracker is set
 // - passes the update to the domain identity collection
+// - notifies the publisher join handler if set and the publisher is new or has a new key
 func (rxIdentity *ReceiveDomainPublisherIdentities) ReceiveDomainIdentity(address string, rawMessage string) error {
 	var newIdentity types.PublisherIdentityMessage
 
@@ -47,35 +97,71 @@ func (rxIdentity *ReceiveDomainPublisherIdentities) ReceiveDomainIdentity(addres
 	logrus.Infof("ReceiveDomainIdentity: %s", address)
 
 	// decode the message and determine the sender.
+	receiveStats := rxIdentity.messageSigner.ReceiveStats()
 	isSigned, err := messaging.VerifySenderJWSSignature(rawMessage, &newIdentity, nil)
 	if err != nil {
+		receiveStats.Update(address, address, messaging.ReceiveResultRejectedSchema)
 		return lib.MakeErrorf("ReceiveDomainIdentity: Invalid identity message on '%s': %s", address, err)
 	} else if !isSigned && rxIdentity.messageSigner.SignMessages() {
+		receiveStats.Update(address, address, messaging.ReceiveResultRejectedSignature)
 		return lib.MakeErrorf("ReceiveDomainIdentity: Identity message on '%s' isn't signed but must be. Message discarded.", address)
 	}
 
+	// allow for clock skew when verifying expiry
+	expiryMargin := time.Duration(0)
+	if rxIdentity.timeSync != nil {
+		expiryMargin = rxIdentity.timeSync.VerificationWindow(0)
+	}
+
 	// Determine the key to verify the identity with
-	if newIdentity.IssuerID == newIdentity.PublisherID {
-		// self signed identity
+	isSelfSigned := newIdentity.IssuerID == newIdentity.PublisherID
+	if isSelfSigned {
 		issuerKey := messaging.PublicKeyFromPem(newIdentity.PublicKey)
-		err = VerifyPublisherIdentity(address, &newIdentity, issuerKey)
+		err = verifyPublisherIdentity(address, &newIdentity, issuerKey, expiryMargin)
 	} else if newIdentity.IssuerID == types.DSSPublisherID {
 		// DSS signed identity. DSS Must be known.
 		issuerAddress := newIdentity.Domain + "/" + newIdentity.IssuerID
 		issuerKey := rxIdentity.domainIdentities.GetPublisherKey(issuerAddress)
-		err = VerifyPublisherIdentity(address, &newIdentity, issuerKey)
+		err = verifyPublisherIdentity(address, &newIdentity, issuerKey, expiryMargin)
 	} else {
 		// TODO: assume a CA signed identity. Not yet supported
 		err = lib.MakeErrorf("Unknown Issuer %s for domain %s", newIdentity.IssuerID, newIdentity.Domain)
 	}
+	// another publisher with our publisher ID must not replace our own key
+	if err == nil && rxIdentity.isConflictingIdentity(&newIdentity) {
+		receiveStats.Update(address, address, messaging.ReceiveResultRejectedSignature)
+		if rxIdentity.onConflict != nil {
+			rxIdentity.onConflict(&newIdentity)
+		}
+		return lib.MakeErrorf("ReceiveDomainIdentity: Identity on '%s' conflicts with the identity of this publisher", address)
+	}
+	if err == nil && isSelfSigned && rxIdentity.trustStore != nil {
+		err = rxIdentity.trustStore.VerifyIdentity(&newIdentity)
+	}
 	if err != nil {
+		receiveStats.Update(address, address, messaging.ReceiveResultRejectedSignature)
 		return lib.MakeErrorf("ReceiveDomainIdentity: Publisher identity signature verification failed for %s", address)
 	}
 
+	receiveStats.Update(address, address, messaging.ReceiveResultVerified)
+	existing := rxIdentity.domainIdentities.GetPublisherByAddress(newIdentity.Address)
+	isJoin := existing == nil || existing.PublicKey != newIdentity.PublicKey
 	rxIdentity.domainIdentities.AddIdentity(&newIdentity)
+	if isJoin && rxIdentity.onJoin != nil {
+		rxIdentity.onJoin(&newIdentity)
+	}
 	return nil
 }
 
+// isConflictingIdentity returns true if the identity has the address of this publisher but a different key
+func (rxIdentity *ReceiveDomainPublisherIdentities) isConflictingIdentity(identity *types.PublisherIdentityMessage) bool {
+	if rxIdentity.registeredIdentity == nil {
+		return false
+	}
+	myIdent, _ := rxIdentity.registeredIdentity.GetFullIdentity()
+	return identity.Address == myIdent.Address && identity.PublicKey != myIdent.PublicKey
+}
+
 // NewReceivePublisherIdentities listens for publisher identity updates of the domain
 // Run Start() to start listening.
 func NewReceivePublisherIdentities(domain string, domainIdentities *DomainPublisherIdentities,
@@ -0,0 +1,128 @@
+// Package identities with handling of publisher control commands
+package identities
+
+import (
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// PublisherControlHandler application handler to execute a publisher control command
+type PublisherControlHandler func(command types.PublisherControlCommand, value string, sender string) error
+
+// ReceivePublisherControl listens for control commands aimed at this publisher.
+// This decrypts incoming messages, verifies the signature with the sender public key and
+// only accepts commands from senders with the role the command requires, see types.PublisherControlRoles.
+type ReceivePublisherControl struct {
+	domain          string                   // the domain of this publisher
+	publisherID     string                   // the publisher to control
+	handler         PublisherControlHandler  // handler to pass the command to
+	messageSigner   *messaging.MessageSigner // subscription to command
+	senderRoles     lib.RoleMap              // role of senders by identity address
+	senderTimestamp map[string]string        // most recent timestamp of received commands by sender
+	updateMutex     *sync.Mutex              // mutex for async handling of commands
+}
+
+// SetControlHandler set the handler that executes control commands
+func (rxControl *ReceivePublisherControl) SetControlHandler(
+	handler func(command types.PublisherControlCommand, value string, sender string) error) {
+
+	rxControl.handler = handler
+}
+
+// SetSenderRoles sets the roles of senders by their identity address. This replaces the role of
+// senders that are already known, including the admin role of the authorized senders.
+func (rxControl *ReceivePublisherControl) SetSenderRoles(senderRoles map[string]types.Role) {
+	rxControl.updateMutex.Lock()
+	defer rxControl.updateMutex.Unlock()
+	for sender, role := range senderRoles {
+		rxControl.senderRoles[sender] = role
+	}
+}
+
+// Start listening for control commands
+func (rxControl *ReceivePublisherControl) Start() {
+	rxControl.updateMutex.Lock()
+	defer rxControl.updateMutex.Unlock()
+	addr := MakePublisherControlAddress(rxControl.domain, rxControl.publisherID)
+	rxControl.messageSigner.Subscribe(addr, rxControl.receiveControlCommand)
+}
+
+// Stop listening for control commands
+func (rxControl *ReceivePublisherControl) Stop() {
+	rxControl.updateMutex.Lock()
+	defer rxControl.updateMutex.Unlock()
+	addr := MakePublisherControlAddress(rxControl.domain, rxControl.publisherID)
+	rxControl.messageSigner.Unsubscribe(addr, rxControl.receiveControlCommand)
+}
+
+// receiveControlCommand handles an incoming control command. This:
+// - checks if the message is encrypted
+// - verifies if the sender signature is valid
+// - checks the sender has the role that the command requires
+// - checks the message is more recent than the previous message of the sender
+// - passes the command to the handler
+func (rxControl *ReceivePublisherControl) receiveControlCommand(address string, message string) error {
+	var controlMessage types.PublisherControlMessage
+
+	isEncrypted, isSigned, err := rxControl.messageSigner.DecodeMessage(message, &controlMessage)
+
+	if !isEncrypted {
+		return lib.MakeErrorf("receiveControlCommand: Control command '%s' is not encrypted. Message discarded.", address)
+	} else if !isSigned {
+		return lib.MakeErrorf("receiveControlCommand: Control command '%s' is not signed. Message discarded.", address)
+	} else if err != nil {
+		return lib.MakeErrorf("receiveControlCommand: Message to %s. Error %s'. Message discarded.", address, err)
+	}
+
+	requiredRole, isListed := types.PublisherControlRoles[controlMessage.Command]
+	if !isListed {
+		requiredRole = types.RoleAdmin
+	}
+	rxControl.updateMutex.Lock()
+	authErr := rxControl.senderRoles.Authorize(controlMessage.Sender, requiredRole)
+	prevTimestamp := rxControl.senderTimestamp[controlMessage.Sender]
+	if authErr == nil && prevTimestamp <= controlMessage.Timestamp {
+		rxControl.senderTimestamp[controlMessage.Sender] = controlMessage.Timestamp
+	}
+	handler := rxControl.handler
+	rxControl.updateMutex.Unlock()
+
+	if authErr != nil {
+		return lib.MakeErrorf("receiveControlCommand: Sender '%s' is not authorized to send command '%s': %s. Message discarded.",
+			controlMessage.Sender, controlMessage.Command, authErr)
+	} else if prevTimestamp > controlMessage.Timestamp {
+		rxControl.messageSigner.ReceiveStats().Update(address, controlMessage.Sender, messaging.ReceiveResultDroppedDuplicate)
+		return lib.MakeErrorf("receiveControlCommand: earlier timestamp of control command from sender %s. Message discarded.",
+			controlMessage.Sender)
+	}
+	logrus.Infof("receiveControlCommand: command '%s' from %s", controlMessage.Command, controlMessage.Sender)
+	if handler != nil {
+		err = handler(controlMessage.Command, controlMessage.Value, controlMessage.Sender)
+	}
+	return err
+}
+
+// NewReceivePublisherControl listens for control commands for this publisher. Run Start() to start listening.
+// authorizedSenders contains the identity addresses of the publishers that have the admin role and are
+// allowed to send all control commands. Use SetSenderRoles to authorize senders with other roles.
+func NewReceivePublisherControl(domain string, publisherID string, authorizedSenders []string,
+	handler PublisherControlHandler, messageSigner *messaging.MessageSigner) *ReceivePublisherControl {
+
+	rxControl := &ReceivePublisherControl{
+		domain:          domain,
+		publisherID:     publisherID,
+		handler:         handler,
+		messageSigner:   messageSigner,
+		senderRoles:     make(lib.RoleMap),
+		senderTimestamp: make(map[string]string),
+		updateMutex:     &sync.Mutex{},
+	}
+	for _, sender := range authorizedSenders {
+		rxControl.senderRoles[sender] = types.RoleAdmin
+	}
+	return rxControl
+}
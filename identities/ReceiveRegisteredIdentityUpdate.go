@@ -12,10 +12,17 @@ import (
 // ReceiveRegisteredIdentityUpdate listens for the identity update command from the DSS
 // This decrypts and verifies the signature of the command using the DSS public key when available
 type ReceiveRegisteredIdentityUpdate struct {
-	domain             string                   // the domain of this publisher
-	publisherID        string                   // the registered publisher for the inputs
-	messageSigner      *messaging.MessageSigner // subscription to command
-	registeredIdentity *RegisteredIdentity      // the identity to update
+	domain             string                                      // the domain of this publisher
+	publisherID        string                                      // the registered publisher for the inputs
+	messageSigner      *messaging.MessageSigner                    // subscription to command
+	onUpdate           func(identity *types.PublisherFullIdentity) // optional notification of updates
+	registeredIdentity *RegisteredIdentity                         // the identity to update
+}
+
+// SetOnUpdate sets the handler that is invoked after the registered identity is updated by the DSS
+// Use nil to remove the handler.
+func (rxIdentity *ReceiveRegisteredIdentityUpdate) SetOnUpdate(handler func(identity *types.PublisherFullIdentity)) {
+	rxIdentity.onUpdate = handler
 }
 
 // Start listening for updates to the registered identity
@@ -59,6 +66,9 @@ func (rxIdentity *ReceiveRegisteredIdentityUpdate) ReceiveIdentityUpdate(address
 		rxIdentity.registeredIdentity.UpdateIdentity(&newIdentity)
 		rxIdentity.registeredIdentity.SaveIdentity()
 	}
+	if rxIdentity.onUpdate != nil {
+		rxIdentity.onUpdate(&newIdentity)
+	}
 	return err
 }
 
@@ -4,6 +4,7 @@ import (
 	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/json"
+	"io/ioutil"
 	"os"
 	"testing"
 	"time"
@@ -61,6 +62,151 @@ func TestPersistIdentity(t *testing.T) {
 	os.Remove(identityFile)
 }
 
+func TestIdentityMeta(t *testing.T) {
+	const domain = "test"
+	const publisherID = "publisher1"
+	identityFile := configFolder + "/testidentitymeta.json"
+	metaFile := configFolder + "/testidentitymeta" + identities.IdentityMetaFileSuffix
+	defer os.Remove(identityFile)
+	defer os.Remove(metaFile)
+
+	regIdent := identities.NewRegisteredIdentity(domain, publisherID, identityFile)
+	assert.False(t, regIdent.IsExpired())
+	assert.Equal(t, 364, regIdent.DaysUntilExpiry())
+	err := regIdent.SaveIdentity()
+	require.NoError(t, err)
+	// saving again replaces the read-only files
+	err = regIdent.SaveIdentity()
+	require.NoError(t, err)
+	_, err = os.Stat(metaFile)
+	require.NoError(t, err, "Missing identity meta file")
+
+	ident, _ := regIdent.GetFullIdentity()
+	regIdent2 := identities.NewRegisteredIdentity(domain, publisherID, identityFile)
+	ident2, _, err := regIdent2.LoadIdentity()
+	require.NoError(t, err)
+	assert.Equal(t, ident.IdentitySignature, ident2.IdentitySignature)
+	assert.Equal(t, ident.ValidUntil, ident2.ValidUntil)
+
+	// a meta file that fails the integrity check is ignored
+	os.Chmod(metaFile, 0600)
+	var meta identities.IdentityMeta
+	metaJSON, _ := ioutil.ReadFile(metaFile)
+	json.Unmarshal(metaJSON, &meta)
+	meta.ValidUntil = time.Now().Add(time.Hour).Format(types.TimeFormat)
+	metaJSON, _ = json.Marshal(meta)
+	ioutil.WriteFile(metaFile, metaJSON, 0600)
+	regIdent3 := identities.NewRegisteredIdentity(domain, publisherID, identityFile)
+	ident3, _, err := regIdent3.LoadIdentity()
+	require.NoError(t, err)
+	assert.Equal(t, ident.ValidUntil, ident3.ValidUntil)
+}
+
+func TestReloadIdentity(t *testing.T) {
+	const domain = "test"
+	const publisherID = "publisher1"
+	identityFile := configFolder + "/testreloadidentity.json"
+	metaFile := configFolder + "/testreloadidentity" + identities.IdentityMetaFileSuffix
+	defer os.Remove(identityFile)
+	defer os.Remove(metaFile)
+
+	regIdent := identities.NewRegisteredIdentity(domain, publisherID, identityFile)
+	err := regIdent.SaveIdentity()
+	require.NoError(t, err)
+	changed, err := regIdent.ReloadIdentity(nil)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// an operator installs a new identity. It gets the configured priority.
+	err = regIdent.SetPriority(2)
+	require.NoError(t, err)
+	regIdent2 := identities.NewRegisteredIdentity(domain, publisherID, identityFile)
+	err = regIdent2.SaveIdentity()
+	require.NoError(t, err)
+	var reloadedKey *ecdsa.PrivateKey
+	changed, err = regIdent.ReloadIdentity(func(fullIdentity *types.PublisherFullIdentity, privKey *ecdsa.PrivateKey) {
+		reloadedKey = privKey
+	})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	ident, privKey := regIdent.GetFullIdentity()
+	ident2, privKey2 := regIdent2.GetFullIdentity()
+	assert.Equal(t, ident2.PublicKey, ident.PublicKey)
+	assert.Equal(t, privKey2, privKey)
+	assert.Equal(t, privKey2, reloadedKey)
+	assert.Equal(t, 2, ident.Priority)
+	assert.NoError(t, messaging.VerifyIdentitySignature(&ident.PublisherIdentityMessage, &privKey2.PublicKey))
+
+	// an identity of another publisher is refused and the current identity remains
+	regIdent3 := identities.NewRegisteredIdentity(domain, "publisher3", identityFile)
+	err = regIdent3.SaveIdentity()
+	require.NoError(t, err)
+	_, err = regIdent.ReloadIdentity(nil)
+	assert.Error(t, err)
+	assert.Equal(t, privKey2, regIdent.GetPrivateKey())
+
+	// reloading requires a file
+	_, err = identities.NewRegisteredIdentity(domain, publisherID, "").ReloadIdentity(nil)
+	assert.Error(t, err)
+}
+
+func TestUnsignedTypes(t *testing.T) {
+	const domain = "test"
+	const publisherID = "publisher1"
+	rawAddress := domain + "/" + publisherID + "/node1/temperature/0/" + types.MessageTypeRaw
+	regIdent := identities.NewRegisteredIdentity(domain, publisherID, "")
+
+	// the self-signed identity declares the unsigned types and is signed again
+	err := regIdent.SetUnsignedTypes([]types.MessageType{types.MessageTypeRaw})
+	require.NoError(t, err)
+	ident, _ := regIdent.GetFullIdentity()
+	assert.Equal(t, []types.MessageType{types.MessageTypeRaw}, ident.UnsignedTypes)
+	err = identities.VerifyPublisherIdentity(ident.Address, &ident.PublisherIdentityMessage, nil)
+	assert.NoError(t, err)
+
+	// receivers accept unsigned messages of the declared types but not commands
+	domainIdentities := identities.NewDomainPublisherIdentities()
+	assert.False(t, domainIdentities.IsUnsignedMessageType(rawAddress))
+	domainIdentities.AddIdentity(&ident.PublisherIdentityMessage)
+	assert.True(t, domainIdentities.IsUnsignedMessageType(rawAddress))
+	assert.False(t, domainIdentities.IsUnsignedMessageType(domain+"/"+publisherID+"/node1/temperature/0/$latest"))
+	assert.False(t, domainIdentities.IsUnsignedMessageType("notanaddress"))
+
+	// error case - an identity issued by the DSS can't be changed by the publisher
+	dssKeys := messaging.CreateAsymKeys()
+	dssIdent := *ident
+	dssIdent.IssuerID = types.DSSPublisherID
+	messaging.SignIdentity(&dssIdent.PublisherIdentityMessage, dssKeys)
+	regIdent.SetDssKey(&dssKeys.PublicKey)
+	regIdent.UpdateIdentity(&dssIdent)
+	err = regIdent.SetUnsignedTypes([]types.MessageType{types.MessageTypeRaw, types.MessageTypeEvent})
+	assert.Error(t, err)
+}
+
+func TestSetPriority(t *testing.T) {
+	const domain = "test"
+	const publisherID = "publisher1"
+	regIdent := identities.NewRegisteredIdentity(domain, publisherID, "")
+
+	// the self-signed identity is signed again with the priority
+	err := regIdent.SetPriority(2)
+	require.NoError(t, err)
+	ident, _ := regIdent.GetFullIdentity()
+	assert.Equal(t, 2, ident.Priority)
+	err = identities.VerifyPublisherIdentity(ident.Address, &ident.PublisherIdentityMessage, nil)
+	assert.NoError(t, err)
+
+	// error case - an identity issued by the DSS can't be changed by the publisher
+	dssKeys := messaging.CreateAsymKeys()
+	dssIdent := *ident
+	dssIdent.IssuerID = types.DSSPublisherID
+	messaging.SignIdentity(&dssIdent.PublisherIdentityMessage, dssKeys)
+	regIdent.SetDssKey(&dssKeys.PublicKey)
+	regIdent.UpdateIdentity(&dssIdent)
+	err = regIdent.SetPriority(3)
+	assert.Error(t, err)
+}
+
 func TestUpdateIdentity(t *testing.T) {
 	const domain = "test"
 	const publisher1ID = "pub1"
@@ -214,3 +360,76 @@ func TestVerifyIdentity(t *testing.T) {
 	assert.Error(t, err, "Signature should fail against a mismatched public/private key pem in the identity ")
 
 }
+
+func TestPublisherControl(t *testing.T) {
+	const domain = "test"
+	const publisherID = "publisher1"
+	var rxCommand types.PublisherControlCommand
+	var rxValue string
+	var rxCount = 0
+	privKey := messaging.CreateAsymKeys()
+	authorizedSender := identities.MakePublisherIdentityAddress(domain, "admin")
+
+	getPublisherKey := func(addr string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	msgr := messaging.NewDummyMessenger(nil)
+	signer := messaging.NewMessageSigner(msgr, privKey, getPublisherKey)
+	rxControl := identities.NewReceivePublisherControl(domain, publisherID, []string{authorizedSender},
+		func(command types.PublisherControlCommand, value string, sender string) error {
+			rxCommand = command
+			rxValue = value
+			rxCount++
+			return nil
+		}, signer)
+	rxControl.Start()
+
+	err := identities.PublishControl(domain, publisherID, types.PublisherControlSetLogLevel, "debug",
+		authorizedSender, signer, &privKey.PublicKey)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, rxCount)
+	assert.Equal(t, types.PublisherControlSetLogLevel, rxCommand)
+	assert.Equal(t, "debug", rxValue)
+
+	// unauthorized sender
+	identities.PublishControl(domain, publisherID, types.PublisherControlRestart, "",
+		identities.MakePublisherIdentityAddress(domain, "intruder"), signer, &privKey.PublicKey)
+	// not encrypted
+	identities.PublishControl(domain, publisherID, types.PublisherControlRestart, "",
+		authorizedSender, signer, nil)
+	rxControl.Stop()
+	assert.Equal(t, 1, rxCount, "Commands that are not authorized or encrypted should be discarded")
+}
+
+func TestPublisherControlRoles(t *testing.T) {
+	const domain = "test"
+	const publisherID = "publisher1"
+	var rxCommands = make([]types.PublisherControlCommand, 0)
+	privKey := messaging.CreateAsymKeys()
+	operator := identities.MakePublisherIdentityAddress(domain, "operator")
+	viewer := identities.MakePublisherIdentityAddress(domain, "viewer")
+
+	getPublisherKey := func(addr string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	signer := messaging.NewMessageSigner(messaging.NewDummyMessenger(nil), privKey, getPublisherKey)
+	rxControl := identities.NewReceivePublisherControl(domain, publisherID, nil,
+		func(command types.PublisherControlCommand, value string, sender string) error {
+			rxCommands = append(rxCommands, command)
+			return nil
+		}, signer)
+	rxControl.SetSenderRoles(map[string]types.Role{operator: types.RoleOperator, viewer: types.RoleViewer})
+	rxControl.Start()
+	defer rxControl.Stop()
+
+	// operators can republish but not restart the publisher
+	err := identities.PublishControl(domain, publisherID, types.PublisherControlRepublish, "",
+		operator, signer, &privKey.PublicKey)
+	assert.NoError(t, err)
+	identities.PublishControl(domain, publisherID, types.PublisherControlRestart, "",
+		operator, signer, &privKey.PublicKey)
+	// viewers can't send commands
+	identities.PublishControl(domain, publisherID, types.PublisherControlDiscover, "",
+		viewer, signer, &privKey.PublicKey)
+	assert.Equal(t, []types.PublisherControlCommand{types.PublisherControlRepublish}, rxCommands)
+}
@@ -5,11 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"os"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/lib/atomicfile"
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/sirupsen/logrus"
@@ -18,6 +20,10 @@ import (
 // valid for 1 year
 const validDuration = time.Hour * 24 * 365
 
+// DefaultIdentityExpiryWarningDays is the nr of days before expiry that the publisher status warns
+// the identity is about to expire
+const DefaultIdentityExpiryWarningDays = 30
+
 // IdentityFileSuffix to append to name of the file containing saved identity
 const IdentityFileSuffix = "-identity.json"
 
@@ -30,11 +36,16 @@ type RegisteredIdentity struct {
 	dssPubKey    *ecdsa.PublicKey  // DSS pub key for verification (secure zones only)
 	privateKey   *ecdsa.PrivateKey // private key from the new identity
 	updated      bool              // flag, this identity has been updated and needs to be published/saved
+	updateMutex  *sync.Mutex       // mutex for replacing the identity and key while they are in use
+	// settings that are applied to the self-signed identity, also when it is reloaded
+	priority      *int                // configured priority of the claim to the publisher ID, nil if not set
+	unsignedTypes []types.MessageType // configured message types published without signature, nil if not set
 }
 
 // GetAddress returns the identity's publication address
 func (regIdentity *RegisteredIdentity) GetAddress() string {
-	return regIdentity.fullIdentity.Address
+	fullIdentity, _ := regIdentity.GetFullIdentity()
+	return fullIdentity.Address
 }
 
 // GetPublicKey returns the identity's public key
@@ -44,16 +55,42 @@ func (regIdentity *RegisteredIdentity) GetAddress() string {
 
 // GetPrivateKey returns the identity's private key
 func (regIdentity *RegisteredIdentity) GetPrivateKey() *ecdsa.PrivateKey {
-	return regIdentity.privateKey
+	_, privateKey := regIdentity.GetFullIdentity()
+	return privateKey
+}
+
+// DaysUntilExpiry returns the nr of whole days until the identity expires. Negative if it has expired.
+func (regIdentity *RegisteredIdentity) DaysUntilExpiry() int {
+	fullIdentity, _ := regIdentity.GetFullIdentity()
+	validUntil, err := time.Parse(types.TimeFormat, fullIdentity.ValidUntil)
+	if err != nil {
+		return 0
+	}
+	untilExpiry := time.Until(validUntil)
+	if untilExpiry < 0 {
+		return -int(-untilExpiry/(24*time.Hour)) - 1
+	}
+	return int(untilExpiry / (24 * time.Hour))
+}
+
+// IsExpired returns true if the identity has expired
+func (regIdentity *RegisteredIdentity) IsExpired() bool {
+	fullIdentity, _ := regIdentity.GetFullIdentity()
+	return IsIdentityExpired(&fullIdentity.PublisherIdentityMessage)
 }
 
 // GetFullIdentity returns the full identity with private key
 func (regIdentity *RegisteredIdentity) GetFullIdentity() (fullIdentity *types.PublisherFullIdentity, privKey *ecdsa.PrivateKey) {
+	regIdentity.updateMutex.Lock()
+	defer regIdentity.updateMutex.Unlock()
 	return regIdentity.fullIdentity, regIdentity.privateKey
 }
 
 // LoadIdentity loads the publisher identity and private key from json file and
 // verifies its content. See also VerifyIdentity for the criteria.
+// The issuer signature and validity are loaded from the identity meta file if it passes the
+// integrity check. Without a meta file, eg identities saved by older versions, those of the identity
+// file are used.
 //  Returns the identity with corresponding ECDSA private key.
 //  If the identity doesn't exist, has a different domain/publisherId, or is invalid
 // then an error will be returned and the existing identity remains unchanged.
@@ -62,8 +99,76 @@ func (regIdentity *RegisteredIdentity) LoadIdentity() (
 
 	if regIdentity.filename == "" {
 		err := lib.MakeErrorf("LoadIdentity: Missing filename")
-		return regIdentity.fullIdentity, regIdentity.privateKey, err
+		fullIdentity, privKey = regIdentity.GetFullIdentity()
+		return fullIdentity, privKey, err
+	}
+	fullIdentity, privKey, err = regIdentity.readIdentity(nil)
+	if err != nil && fullIdentity == nil {
+		return nil, nil, err
 	}
+	// finaly, replace the identity with the loaded identity
+	regIdentity.updateMutex.Lock()
+	defer regIdentity.updateMutex.Unlock()
+	if err == nil {
+		regIdentity.fullIdentity = fullIdentity
+		regIdentity.privateKey = privKey
+	}
+	return regIdentity.fullIdentity, regIdentity.privateKey, err
+}
+
+// ReloadIdentity reloads the identity from the identity file, for example after an operator replaced
+// the file with an identity that the DSS issued out of band. The identity is verified like LoadIdentity
+// and must be signed by the DSS if its key is set. The configured priority and unsigned types are
+// applied to a self-signed identity. The identity and private key are replaced together, so concurrent
+// users get either the previous or the reloaded identity.
+// This returns true if the reloaded identity differs from the current identity. If the file can't be
+// read or the identity is invalid then an error is returned and the current identity remains unchanged.
+//  onReload is invoked with the reloaded identity and key before they replace the current identity,
+//  eg to replace the signing key at the same time. It must not use this registered identity. Use nil to ignore.
+func (regIdentity *RegisteredIdentity) ReloadIdentity(
+	onReload func(fullIdentity *types.PublisherFullIdentity, privKey *ecdsa.PrivateKey)) (changed bool, err error) {
+	if regIdentity.filename == "" {
+		return false, lib.MakeErrorf("ReloadIdentity: Missing filename")
+	}
+	regIdentity.updateMutex.Lock()
+	dssPubKey := regIdentity.dssPubKey
+	regIdentity.updateMutex.Unlock()
+
+	fullIdentity, privKey, err := regIdentity.readIdentity(dssPubKey)
+	if err != nil {
+		return false, lib.MakeErrorf("ReloadIdentity: Invalid identity in %s: %s", regIdentity.filename, err)
+	} else if privKey == nil {
+		return false, lib.MakeErrorf("ReloadIdentity: Identity in %s has no private key", regIdentity.filename)
+	}
+	regIdentity.updateMutex.Lock()
+	defer regIdentity.updateMutex.Unlock()
+	fullIdentity, resigned := regIdentity.applySettings(fullIdentity, privKey)
+	if resigned {
+		err = regIdentity.saveIdentity(fullIdentity)
+		if err != nil {
+			return false, err
+		}
+	} else if !regIdentity.hasSettings(&fullIdentity.PublisherIdentityMessage) {
+		logrus.Warningf("ReloadIdentity: Identity '%s' is issued by %s. The configured priority and unsigned types are not applied.",
+			fullIdentity.Address, fullIdentity.IssuerID)
+	}
+	changed = !reflect.DeepEqual(fullIdentity, regIdentity.fullIdentity)
+	if changed {
+		if onReload != nil {
+			onReload(fullIdentity, privKey)
+		}
+		regIdentity.fullIdentity = fullIdentity
+		regIdentity.privateKey = privKey
+		regIdentity.updated = true
+	}
+	return changed, nil
+}
+
+// readIdentity reads and verifies the identity and private key from the identity file
+// This returns the identity if it can be read, and an error if it can't be read or is invalid.
+//  dssSigningKey is the key of the DSS that must have signed the identity. nil to accept self-signed identities
+func (regIdentity *RegisteredIdentity) readIdentity(dssSigningKey *ecdsa.PublicKey) (
+	fullIdentity *types.PublisherFullIdentity, privKey *ecdsa.PrivateKey, err error) {
 
 	identityJSON, err := ioutil.ReadFile(regIdentity.filename)
 	if err != nil {
@@ -73,21 +178,25 @@ func (regIdentity *RegisteredIdentity) LoadIdentity() (
 	err = json.Unmarshal(identityJSON, fullIdentity)
 	if err == nil {
 		privKey = messaging.PrivateKeyFromPem(fullIdentity.PrivateKey)
+		meta, metaErr := loadIdentityMeta(makeIdentityMetaFilename(regIdentity.filename), fullIdentity.PublicKey)
+		if metaErr != nil {
+			logrus.Errorf("LoadIdentity: %s. Using the signature of the identity file.", metaErr)
+		} else if meta != nil {
+			fullIdentity.IdentitySignature = meta.IdentitySignature
+			fullIdentity.IssuerID = meta.IssuerID
+			fullIdentity.Timestamp = meta.Timestamp
+			fullIdentity.ValidUntil = meta.ValidUntil
+		}
 	}
 	if err == nil {
 		// must match domain and publisher
-		// We don't know the DSS signing key at this point
-		err = VerifyFullIdentity(fullIdentity, regIdentity.domain, regIdentity.publisherID, nil)
-	}
-	// finaly, replace the identity with the loaded identity
-	if err == nil {
-		regIdentity.fullIdentity = fullIdentity
-		regIdentity.privateKey = privKey
+		err = VerifyFullIdentity(fullIdentity, regIdentity.domain, regIdentity.publisherID, dssSigningKey)
 	}
-	return regIdentity.fullIdentity, regIdentity.privateKey, err
+	return fullIdentity, privKey, err
 }
 
-// SaveIdentity saves the full identity of the publisher
+// SaveIdentity saves the full identity of the publisher and its issuer signature in the meta file
+// The files are replaced atomically so a crash while saving leaves the previous identity intact.
 // see also https://stackoverflow.com/questions/21322182/how-to-store-ecdsa-private-key-in-go
 func (regIdentity *RegisteredIdentity) SaveIdentity() error {
 
@@ -95,29 +204,86 @@ func (regIdentity *RegisteredIdentity) SaveIdentity() error {
 		return lib.MakeErrorf("SaveIdentity: Missing filename")
 	}
 
-	// save the identity as JSON. Remove the existing file first as they are read-only
-	identityJSON, _ := json.MarshalIndent(regIdentity.fullIdentity, " ", " ")
-	// move the identity before deleting
-	os.Rename(regIdentity.filename, regIdentity.filename+".old")
-	err := ioutil.WriteFile(regIdentity.filename, identityJSON, 0400)
+	fullIdentity, _ := regIdentity.GetFullIdentity()
+	return regIdentity.saveIdentity(fullIdentity)
+}
+
+// saveIdentity saves the given full identity and its meta file
+func (regIdentity *RegisteredIdentity) saveIdentity(fullIdentity *types.PublisherFullIdentity) error {
+	// save the identity as JSON. The files are read-only.
+	identityJSON, _ := json.MarshalIndent(fullIdentity, " ", " ")
+	err := atomicfile.WriteFile(regIdentity.filename, identityJSON, 0400)
 	if err != nil {
 		return lib.MakeErrorf("SaveIdentity: Unable to save the publisher's identity at %s: %s", regIdentity.filename, err)
 	}
-	os.Remove(regIdentity.filename + ".old")
-	return err
+	metaFilename := makeIdentityMetaFilename(regIdentity.filename)
+	err = saveIdentityMeta(metaFilename, &fullIdentity.PublisherIdentityMessage)
+	if err != nil {
+		return lib.MakeErrorf("SaveIdentity: Unable to save the identity signature at %s: %s", metaFilename, err)
+	}
+	return nil
 }
 
 // SetDssKey sets the DSS public key. This is needed to allow the DSS to update the
 // registered identity. Without it, any updates are refused. Intended to be set by
 // the publisher when a verified DSS identity is received.
 func (regIdentity *RegisteredIdentity) SetDssKey(dssSigningKey *ecdsa.PublicKey) {
+	regIdentity.updateMutex.Lock()
+	defer regIdentity.updateMutex.Unlock()
 	regIdentity.dssPubKey = dssSigningKey
 }
 
+// SetPriority sets the priority of this publisher's claim to its publisher ID. This is used in the
+// arbitration with another publisher that uses the same ID. See also ArbitrateIdentity.
+// A self-signed identity is signed again and saved. An identity issued by the DSS can't be changed by the
+// publisher, so the DSS must set the priority when it renews the identity.
+// This returns an error if the identity is issued by the DSS and has a different priority.
+func (regIdentity *RegisteredIdentity) SetPriority(priority int) error {
+	regIdentity.updateMutex.Lock()
+	ident := &regIdentity.fullIdentity.PublisherIdentityMessage
+	if ident.Priority != priority && ident.IssuerID != regIdentity.publisherID {
+		regIdentity.updateMutex.Unlock()
+		return lib.MakeErrorf("SetPriority: Identity '%s' is issued by %s. Priority not changed.",
+			ident.Address, ident.IssuerID)
+	}
+	regIdentity.priority = &priority
+	resigned := regIdentity.updateSettings()
+	regIdentity.updateMutex.Unlock()
+
+	if !resigned || regIdentity.filename == "" {
+		return nil
+	}
+	return regIdentity.SaveIdentity()
+}
+
+// SetUnsignedTypes declares the message types this publisher publishes without signature.
+// A self-signed identity is signed again and saved. An identity issued by the DSS can't be changed by the
+// publisher, so the DSS must declare the types when it renews the identity.
+// This returns an error if the identity is issued by the DSS and doesn't declare the same types.
+func (regIdentity *RegisteredIdentity) SetUnsignedTypes(unsignedTypes []types.MessageType) error {
+	regIdentity.updateMutex.Lock()
+	ident := &regIdentity.fullIdentity.PublisherIdentityMessage
+	if !isSameTypes(ident.UnsignedTypes, unsignedTypes) && ident.IssuerID != regIdentity.publisherID {
+		regIdentity.updateMutex.Unlock()
+		return lib.MakeErrorf("SetUnsignedTypes: Identity '%s' is issued by %s. Unsigned types not changed.",
+			ident.Address, ident.IssuerID)
+	}
+	regIdentity.unsignedTypes = append([]types.MessageType{}, unsignedTypes...)
+	resigned := regIdentity.updateSettings()
+	regIdentity.updateMutex.Unlock()
+
+	if !resigned || regIdentity.filename == "" {
+		return nil
+	}
+	return regIdentity.SaveIdentity()
+}
+
 // UpdateIdentity verifies and sets a new registered identity and saves it to the
 // identity file.
 func (regIdentity *RegisteredIdentity) UpdateIdentity(fullIdentity *types.PublisherFullIdentity) {
 
+	regIdentity.updateMutex.Lock()
+	defer regIdentity.updateMutex.Unlock()
 	err := VerifyFullIdentity(fullIdentity, regIdentity.domain, regIdentity.publisherID, regIdentity.dssPubKey)
 	if err != nil {
 		logrus.Errorf("UpdateIdentity: verification failed. Identity not updated.")
@@ -129,6 +295,54 @@ func (regIdentity *RegisteredIdentity) UpdateIdentity(fullIdentity *types.Publis
 	regIdentity.updated = true
 }
 
+// applySettings returns the identity with the configured priority and unsigned types. If these differ
+// from a self-signed identity then a copy is made and signed again, as the identity can be in use.
+// An identity that isn't self-signed is returned as is, as only its issuer can change it.
+// The caller must hold the lock.
+// This returns true if the identity is signed again.
+func (regIdentity *RegisteredIdentity) applySettings(fullIdentity *types.PublisherFullIdentity, privKey *ecdsa.PrivateKey) (
+	*types.PublisherFullIdentity, bool) {
+
+	if regIdentity.hasSettings(&fullIdentity.PublisherIdentityMessage) ||
+		fullIdentity.IssuerID != regIdentity.publisherID {
+		return fullIdentity, false
+	}
+	newIdentity := *fullIdentity
+	if regIdentity.priority != nil {
+		newIdentity.Priority = *regIdentity.priority
+	}
+	if regIdentity.unsignedTypes != nil {
+		newIdentity.UnsignedTypes = regIdentity.unsignedTypes
+	}
+	newIdentity.Timestamp = time.Now().Format(types.TimeFormat)
+	messaging.SignIdentity(&newIdentity.PublisherIdentityMessage, privKey)
+	return &newIdentity, true
+}
+
+// hasSettings returns true if the identity has the configured priority and unsigned types
+func (regIdentity *RegisteredIdentity) hasSettings(ident *types.PublisherIdentityMessage) bool {
+	if regIdentity.priority != nil && *regIdentity.priority != ident.Priority {
+		return false
+	}
+	return regIdentity.unsignedTypes == nil || isSameTypes(regIdentity.unsignedTypes, ident.UnsignedTypes)
+}
+
+// updateSettings applies the configured settings to the current identity. The caller must hold the lock.
+// This returns true if the identity is signed again and needs to be saved.
+func (regIdentity *RegisteredIdentity) updateSettings() bool {
+	fullIdentity, resigned := regIdentity.applySettings(regIdentity.fullIdentity, regIdentity.privateKey)
+	if resigned {
+		regIdentity.fullIdentity = fullIdentity
+		regIdentity.updated = true
+	}
+	return resigned
+}
+
+// isSameTypes returns true if both lists contain the same message types in the same order
+func isSameTypes(types1 []types.MessageType, types2 []types.MessageType) bool {
+	return reflect.DeepEqual(types1, types2) || (len(types1) == 0 && len(types2) == 0)
+}
+
 // CreateIdentity creates and self-sign a new identity for the publisher
 // This creates a base64encoded signature of the public identity using the given
 // private key.
@@ -150,6 +364,7 @@ func CreateIdentity(domain string, publisherID string) (
 	// self signed identity
 	publicIdentity := types.PublisherIdentityMessage{
 		Address:           addr,
+		Created:           timestampStr,
 		IdentitySignature: "",
 		Domain:            domain,
 		IssuerID:          publisherID, // self issued, will be replaced by DSS
@@ -173,7 +388,13 @@ func CreateIdentity(domain string, publisherID string) (
 
 // IsIdentityExpired tests if the given identity is expired
 func IsIdentityExpired(identity *types.PublisherIdentityMessage) bool {
-	timestampStr := time.Now().Format(types.TimeFormat)
+	return IsIdentityExpiredWithin(identity, 0)
+}
+
+// IsIdentityExpiredWithin returns true if the identity has expired longer than the given margin ago.
+// Intended to allow for clock skew between publishers.
+func IsIdentityExpiredWithin(identity *types.PublisherIdentityMessage, margin time.Duration) bool {
+	timestampStr := time.Now().Add(-margin).Format(types.TimeFormat)
 	nowIsGreater := strings.Compare(timestampStr, identity.ValidUntil)
 	return (nowIsGreater > 0)
 }
@@ -238,6 +459,7 @@ func NewRegisteredIdentity(domain string, publisherID string, identityFile strin
 		privateKey:   privKey,
 		publisherID:  publisherID,
 		updated:      true,
+		updateMutex:  &sync.Mutex{},
 	}
 	return regIdent
 }
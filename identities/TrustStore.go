@@ -0,0 +1,140 @@
+// Package identities with pinning of publisher keys for domains without a DSS
+package identities
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// TrustStoreFileSuffix to append to the name of the file containing the pinned publisher keys
+const TrustStoreFileSuffix = "-trustedkeys.json"
+
+// KeyChangeHandler is invoked when the key of a received identity differs from the pinned key.
+// Intended to raise a security event as the identity might be spoofed.
+//  publisherAddress is the domain/publisherID of the publisher whose key changed
+//  pinnedKey and newKey are the pinned and received public keys in PEM format
+type KeyChangeHandler func(publisherAddress string, pinnedKey string, newKey string)
+
+// TrustStore pins the public key of publishers on first use (TOFU).
+// Intended for domains without a DSS where identities are self-signed. Anyone can publish a
+// self-signed identity, so a key that differs from the pinned key is rejected.
+type TrustStore struct {
+	filename    string            // file the pinned keys are saved to. "" to not persist
+	onKeyChange KeyChangeHandler  // optional notification of key changes
+	pins        map[string]string // pinned public key in PEM format by domain/publisherID
+	updateMutex *sync.Mutex       // mutex for concurrent access to the pins
+}
+
+// GetPinnedKey returns the pinned public key of a publisher, or "" if the publisher isn't pinned
+//  publisherAddress is the domain/publisherID of the publisher
+func (store *TrustStore) GetPinnedKey(publisherAddress string) string {
+	store.updateMutex.Lock()
+	defer store.updateMutex.Unlock()
+	return store.pins[publisherAddress]
+}
+
+// Load the pinned keys from the trust store file. Existing pins are replaced.
+func (store *TrustStore) Load() error {
+	if store.filename == "" {
+		return nil
+	}
+	pinsJSON, err := ioutil.ReadFile(store.filename)
+	if err != nil {
+		return lib.MakeErrorf("TrustStore.Load: Unable to open file %s: %s", store.filename, err)
+	}
+	pins := make(map[string]string)
+	err = json.Unmarshal(pinsJSON, &pins)
+	if err != nil {
+		return lib.MakeErrorf("TrustStore.Load: Error parsing JSON file %s: %v", store.filename, err)
+	}
+	store.updateMutex.Lock()
+	defer store.updateMutex.Unlock()
+	for publisherAddress, key := range pins {
+		store.pins[publisherAddress] = key
+	}
+	return nil
+}
+
+// Pin the public key of a publisher, replacing a previously pinned key.
+// Intended to accept a key change after verifying it out of band, or to pin the publisher's own key.
+//  publisherAddress is the domain/publisherID of the publisher
+//  publicKey is the public key in PEM format
+func (store *TrustStore) Pin(publisherAddress string, publicKey string) error {
+	store.updateMutex.Lock()
+	store.pins[publisherAddress] = publicKey
+	store.updateMutex.Unlock()
+	return store.Save()
+}
+
+// Save the pinned keys to the trust store file
+func (store *TrustStore) Save() error {
+	if store.filename == "" {
+		return nil
+	}
+	store.updateMutex.Lock()
+	pinsJSON, _ := json.MarshalIndent(store.pins, "", "  ")
+	store.updateMutex.Unlock()
+	err := ioutil.WriteFile(store.filename, pinsJSON, 0600)
+	if err != nil {
+		return lib.MakeErrorf("TrustStore.Save: Error saving pinned keys to %s: %v", store.filename, err)
+	}
+	return nil
+}
+
+// SetOnKeyChange sets the handler that is invoked when a received key differs from the pinned key
+func (store *TrustStore) SetOnKeyChange(handler KeyChangeHandler) {
+	store.updateMutex.Lock()
+	defer store.updateMutex.Unlock()
+	store.onKeyChange = handler
+}
+
+// Unpin removes the pinned key of a publisher. The next identity received from the publisher is pinned.
+func (store *TrustStore) Unpin(publisherAddress string) error {
+	store.updateMutex.Lock()
+	delete(store.pins, publisherAddress)
+	store.updateMutex.Unlock()
+	return store.Save()
+}
+
+// VerifyIdentity verifies the key of an identity against the pinned key of the publisher.
+// The key of a publisher that isn't pinned yet is pinned.
+// This returns an error and invokes the key change handler if the key differs from the pinned key.
+func (store *TrustStore) VerifyIdentity(identity *types.PublisherIdentityMessage) error {
+	publisherAddress := identity.Domain + "/" + identity.PublisherID
+	store.updateMutex.Lock()
+	pinnedKey, isPinned := store.pins[publisherAddress]
+	onKeyChange := store.onKeyChange
+	store.updateMutex.Unlock()
+
+	if !isPinned {
+		logrus.Infof("TrustStore.VerifyIdentity: Pinning key of publisher %s on first use", publisherAddress)
+		return store.Pin(publisherAddress, identity.PublicKey)
+	} else if strings.TrimSpace(pinnedKey) == strings.TrimSpace(identity.PublicKey) {
+		return nil
+	}
+	logrus.Errorf("TrustStore.VerifyIdentity: Key of publisher %s differs from its pinned key", publisherAddress)
+	if onKeyChange != nil {
+		onKeyChange(publisherAddress, pinnedKey, identity.PublicKey)
+	}
+	return lib.MakeErrorf("VerifyIdentity: Key of publisher %s differs from its pinned key", publisherAddress)
+}
+
+// NewTrustStore creates a store for pinning publisher keys
+// Use Load to load the previously pinned keys.
+//  filename of the file to save the pinned keys. Use "" to not persist the keys.
+//  onKeyChange is the optional handler invoked when a received key differs from the pinned key
+func NewTrustStore(filename string, onKeyChange KeyChangeHandler) *TrustStore {
+	store := &TrustStore{
+		filename:    filename,
+		onKeyChange: onKeyChange,
+		pins:        make(map[string]string),
+		updateMutex: &sync.Mutex{},
+	}
+	return store
+}
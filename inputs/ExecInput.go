@@ -0,0 +1,66 @@
+// Package inputs for running a command when an input is set
+package inputs
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Environment variables passed to the command of an exec input
+const (
+	ExecInputEnvSender = "IOTDOMAIN_SENDER" // address of the sender of the set command
+	ExecInputEnvValue  = "IOTDOMAIN_VALUE"  // value to set
+)
+
+// ExecInput is an input that runs a command when it receives a set command.
+// The value is passed as the last argument and in the IOTDOMAIN_VALUE environment variable.
+// Intended to wrap command line tools as domain devices without writing Go.
+type ExecInput struct {
+	args    []string                     // arguments that precede the value
+	command string                       // command to run
+	input   *types.InputDiscoveryMessage // the registered input
+	Timeout time.Duration                // max time the command can run. Default is lib.DefaultCommandTimeout
+}
+
+// Input returns the registered input of the exec input
+func (execInput *ExecInput) Input() *types.InputDiscoveryMessage {
+	return execInput.input
+}
+
+// runCommand runs the command with the value of the set command
+func (execInput *ExecInput) runCommand(input *types.InputDiscoveryMessage, sender string, value string) {
+	args := append(append([]string{}, execInput.args...), value)
+	env := []string{ExecInputEnvSender + "=" + sender, ExecInputEnvValue + "=" + value}
+	output, err := lib.RunCommand(execInput.Timeout, env, execInput.command, args...)
+	if err != nil {
+		logrus.Errorf("ExecInput.runCommand: Setting input %s failed: %s", input.Address, err)
+		return
+	}
+	logrus.Infof("ExecInput.runCommand: Input %s set to '%s' by %s. Output: %s", input.Address, value, sender, output)
+}
+
+// NewExecInput creates an input that runs a command when it receives a set command
+// The command is run directly, not through a shell, so the value is not interpreted.
+//  setCommands receives the set commands of the inputs of this publisher
+//  command and args is the command to run. The value is appended to the arguments.
+// Only the name of the executable is published in the source info as the path and arguments can hold secrets.
+func NewExecInput(setCommands *ReceiveFromSetCommands,
+	nodeHWID string, inputType types.InputType, instance string,
+	command string, args ...string) *ExecInput {
+
+	execInput := &ExecInput{
+		args:    args,
+		command: command,
+	}
+	input := setCommands.CreateInput(nodeHWID, inputType, instance, execInput.runCommand)
+	execInput.input = setCommands.registeredInputs.SetSourceInfo(input.InputID, types.InputSourceInfo{
+		Kind:   types.InputSourceExec,
+		Source: input.SourceInfo.Source,
+		Target: filepath.Base(command),
+	})
+	return execInput
+}
@@ -0,0 +1,129 @@
+// Package inputs for using the contents of a watched file or directory as input
+package inputs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// MaxFileWatcherInputSize is the max size of a file whose contents is passed to the input handler
+const MaxFileWatcherInputSize = 1024 * 1024
+
+// FileWatcherInput is an input that invokes its handler with the contents of a file when the file changes.
+// Intended for integrating with local daemons that write status files.
+// When watching a directory, the handler is invoked with the contents of the file in the directory
+// that changed. The sender passed to the handler is the path of the changed file.
+type FileWatcherInput struct {
+	input            *types.InputDiscoveryMessage // the registered input
+	isRunning        bool                         // the watcher loop is running
+	registeredInputs *RegisteredInputs            // registered inputs of this publisher
+	updateMutex      *sync.Mutex                  // mutex for async start and stop
+	watcher          *fsnotify.Watcher            // the watcher of the file or directory
+}
+
+// Input returns the registered input of the file watcher
+func (fwInput *FileWatcherInput) Input() *types.InputDiscoveryMessage {
+	return fwInput.input
+}
+
+// Start watching for changes
+func (fwInput *FileWatcherInput) Start() {
+	fwInput.updateMutex.Lock()
+	defer fwInput.updateMutex.Unlock()
+	if !fwInput.isRunning {
+		fwInput.isRunning = true
+		go fwInput.watcherLoop()
+	}
+}
+
+// Stop watching for changes and release the watcher
+// The input remains registered. Use RegisteredInputs.DeleteInput to remove it.
+func (fwInput *FileWatcherInput) Stop() {
+	fwInput.updateMutex.Lock()
+	defer fwInput.updateMutex.Unlock()
+	if fwInput.isRunning {
+		fwInput.isRunning = false
+		fwInput.watcher.Close()
+	}
+}
+
+// onFileChanged reads the changed file and passes its contents to the input handler
+func (fwInput *FileWatcherInput) onFileChanged(fullPath string) {
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() {
+		// deleted files and subdirectories are ignored
+		return
+	} else if info.Size() > MaxFileWatcherInputSize {
+		logrus.Warningf("FileWatcherInput.onFileChanged: File %s is larger than %d bytes. Ignored.",
+			fullPath, MaxFileWatcherInputSize)
+		return
+	}
+	contents, err := ioutil.ReadFile(fullPath)
+	if err != nil {
+		logrus.Warningf("FileWatcherInput.onFileChanged: Unable to read file %s: %s", fullPath, err)
+		return
+	}
+	fwInput.registeredInputs.NotifyInputHandler(fwInput.input.InputID, fullPath, string(contents))
+}
+
+// watcherLoop passes writes to the file or files in the directory to the input handler, until stopped
+func (fwInput *FileWatcherInput) watcherLoop() {
+	for {
+		select {
+		case event, ok := <-fwInput.watcher.Events:
+			if !ok {
+				return
+			}
+			// files that are replaced by a rename are created
+			if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				logrus.Infof("FileWatcherInput.watcherLoop: modified file %s", event.Name)
+				fwInput.onFileChanged(event.Name)
+			}
+		case err, ok := <-fwInput.watcher.Errors:
+			if !ok {
+				return
+			}
+			logrus.Warnf("FileWatcherInput.watcherLoop: error: %s", err)
+		}
+	}
+}
+
+// NewFileWatcherInput creates an input that invokes the handler with the contents of a file when it changes.
+// The input is registered with the path as source. Use Start() to start watching.
+//  path is the file or directory to watch. It must exist.
+//  handler is invoked with the path of the changed file as sender and the file contents as value
+func NewFileWatcherInput(registeredInputs *RegisteredInputs,
+	nodeHWID string, inputType types.InputType, instance string, path string,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string)) (*FileWatcherInput, error) {
+
+	fullPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, lib.MakeErrorf("NewFileWatcherInput: Invalid path '%s': %s", path, err)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, lib.MakeErrorf("NewFileWatcherInput: Unable to create a file watcher: %s", err)
+	}
+	err = watcher.Add(fullPath)
+	if err != nil {
+		watcher.Close()
+		return nil, lib.MakeErrorf("NewFileWatcherInput: Unable to watch '%s': %s", fullPath, err)
+	}
+	input := registeredInputs.CreateInputWithSource(nodeHWID, inputType, instance, fullPath, handler)
+	input = registeredInputs.SetSourceInfo(input.InputID,
+		types.InputSourceInfo{Kind: types.InputSourceFile, Source: fullPath})
+	fwInput := &FileWatcherInput{
+		input:            input,
+		registeredInputs: registeredInputs,
+		updateMutex:      &sync.Mutex{},
+		watcher:          watcher,
+	}
+	return fwInput, nil
+}
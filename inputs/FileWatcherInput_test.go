@@ -0,0 +1,68 @@
+package inputs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileWatcherInput(t *testing.T) {
+	const node1HWID = "node1"
+	const status1 = "status: running"
+	const status2 = "status: stopped"
+	tempDir, err := ioutil.TempDir("", "filewatcherinput")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	statusFile := filepath.Join(tempDir, "daemon.status")
+	err = ioutil.WriteFile(statusFile, []byte(""), 0644)
+	require.NoError(t, err)
+
+	received := make(chan string, 10)
+	handler := func(input *types.InputDiscoveryMessage, sender string, value string) {
+		if value != "" {
+			received <- value
+		}
+	}
+	regInputs := inputs.NewRegisteredInputs(domain, publisher1ID)
+
+	// watch a file
+	fileInput, err := inputs.NewFileWatcherInput(regInputs, node1HWID, types.InputTypeValue,
+		types.DefaultInputInstance, statusFile, handler)
+	require.NoError(t, err)
+	assert.Equal(t, statusFile, fileInput.Input().Source)
+	fileInput.Start()
+	ioutil.WriteFile(statusFile, []byte(status1), 0644)
+	select {
+	case value := <-received:
+		assert.Equal(t, status1, value)
+	case <-time.After(time.Second):
+		assert.Fail(t, "Handler not called when writing the file")
+	}
+	fileInput.Stop()
+
+	// watch a directory
+	dirInput, err := inputs.NewFileWatcherInput(regInputs, node1HWID, types.InputTypeValue,
+		"dir", tempDir, handler)
+	require.NoError(t, err)
+	dirInput.Start()
+	ioutil.WriteFile(filepath.Join(tempDir, "other.status"), []byte(status2), 0644)
+	select {
+	case value := <-received:
+		assert.Equal(t, status2, value)
+	case <-time.After(time.Second):
+		assert.Fail(t, "Handler not called when writing a file in the directory")
+	}
+	dirInput.Stop()
+
+	// error case - path doesn't exist
+	_, err = inputs.NewFileWatcherInput(regInputs, node1HWID, types.InputTypeValue,
+		"missing", filepath.Join(tempDir, "missing"), handler)
+	assert.Error(t, err)
+}
@@ -0,0 +1,147 @@
+// Package inputs with queueing of set commands for slow inputs
+package inputs
+
+import (
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultInputQueueDepth is the max nr of pending set commands of a FIFO input queue
+const DefaultInputQueueDepth = 10
+
+// InputQueueMode determines how set commands are handled while the input handler is busy
+type InputQueueMode string
+
+// Available input queue modes
+const (
+	InputQueueModeDirect InputQueueMode = ""       // invoke the handler on receipt (default)
+	InputQueueModeFIFO   InputQueueMode = "fifo"   // queue commands up to the max depth and drop new commands when full
+	InputQueueModeLatest InputQueueMode = "latest" // only keep the latest command that arrives while busy
+	InputQueueModeReject InputQueueMode = "reject" // drop commands that arrive while busy
+)
+
+// InputQueueStats with the number of handled and dropped set commands of an input
+type InputQueueStats struct {
+	Dropped int // nr of commands dropped due to the queue mode
+	Handled int // nr of commands passed to the handler
+	Pending int // nr of commands waiting for the handler
+}
+
+// inputCommand is a set command waiting for the input handler
+type inputCommand struct {
+	sender string
+	value  string
+}
+
+// inputQueue with pending set commands of an input. Access under the RegisteredInputs lock.
+type inputQueue struct {
+	isBusy   bool           // the handler is running
+	maxDepth int            // max nr of pending commands in FIFO mode
+	mode     InputQueueMode // queue behavior
+	pending  []inputCommand // commands waiting for the handler
+	stats    InputQueueStats
+}
+
+// push adds a command to the queue according to the queue mode
+// Returns true if the command is accepted
+func (queue *inputQueue) push(command inputCommand) bool {
+	switch queue.mode {
+	case InputQueueModeReject:
+		if queue.isBusy || len(queue.pending) > 0 {
+			queue.stats.Dropped++
+			return false
+		}
+	case InputQueueModeLatest:
+		if len(queue.pending) > 0 {
+			// the pending command is replaced
+			queue.stats.Dropped++
+			queue.pending = queue.pending[:0]
+		}
+	default:
+		if len(queue.pending) >= queue.maxDepth {
+			queue.stats.Dropped++
+			return false
+		}
+	}
+	queue.pending = append(queue.pending, command)
+	return true
+}
+
+// GetInputQueueStats returns the statistics of the set command queue of an input
+// This returns empty statistics if the input doesn't use a queue.
+func (regInputs *RegisteredInputs) GetInputQueueStats(inputID string) InputQueueStats {
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	queue := regInputs.queues[inputID]
+	if queue == nil {
+		return InputQueueStats{}
+	}
+	stats := queue.stats
+	stats.Pending = len(queue.pending)
+	return stats
+}
+
+// SetInputQueue sets how set commands are queued while the handler of an input is busy.
+// Queued commands are passed to the handler one at a time in a separate goroutine.
+// Intended for slow actuators that would otherwise receive a backlog of commands.
+//  mode is the queue behavior. Use InputQueueModeDirect to invoke the handler on receipt.
+//  maxDepth is the max nr of pending commands in FIFO mode. Use 0 for DefaultInputQueueDepth
+func (regInputs *RegisteredInputs) SetInputQueue(inputID string, mode InputQueueMode, maxDepth int) {
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+
+	if mode == InputQueueModeDirect {
+		delete(regInputs.queues, inputID)
+		return
+	}
+	if maxDepth <= 0 {
+		maxDepth = DefaultInputQueueDepth
+	}
+	queue := regInputs.queues[inputID]
+	if queue == nil {
+		queue = &inputQueue{pending: make([]inputCommand, 0)}
+		regInputs.queues[inputID] = queue
+	}
+	queue.mode = mode
+	queue.maxDepth = maxDepth
+}
+
+// queueCommand adds a set command to the queue of the input and starts the queue if it is idle
+// Use within a locked section. Returns false if the command is dropped.
+func (regInputs *RegisteredInputs) queueCommand(queue *inputQueue, input *types.InputDiscoveryMessage,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string), sender string, value string) bool {
+
+	if !queue.push(inputCommand{sender: sender, value: value}) {
+		logrus.Warningf("RegisteredInputs.queueCommand: Input %s is busy. Dropped value '%s' from %s",
+			input.Address, value, sender)
+		return false
+	}
+	if !queue.isBusy {
+		queue.isBusy = true
+		go regInputs.runQueue(queue, input, handler)
+	}
+	return true
+}
+
+// runQueue passes the pending commands to the handler until the queue is empty
+func (regInputs *RegisteredInputs) runQueue(queue *inputQueue, input *types.InputDiscoveryMessage,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string)) {
+
+	for {
+		regInputs.updateMutex.Lock()
+		if len(queue.pending) == 0 {
+			queue.isBusy = false
+			regInputs.updateMutex.Unlock()
+			return
+		}
+		command := queue.pending[0]
+		queue.pending = queue.pending[1:]
+		regInputs.updateMutex.Unlock()
+
+		regInputs.invokeHandler(handler, input, command.sender, command.value)
+
+		regInputs.updateMutex.Lock()
+		queue.stats.Handled++
+		regInputs.updateMutex.Unlock()
+	}
+}
@@ -0,0 +1,63 @@
+package inputs_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// sendQueuedCommands sends three set commands while the handler is blocked on the first
+// and returns the values received by the handler
+func sendQueuedCommands(mode inputs.InputQueueMode, maxDepth int) ([]string, inputs.InputQueueStats) {
+	collection := inputs.NewRegisteredInputs(domain, publisher1ID)
+	received := make([]string, 0)
+	mutex := &sync.Mutex{}
+	release := make(chan bool)
+	input := collection.CreateInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			mutex.Lock()
+			received = append(received, value)
+			mutex.Unlock()
+			if value == "1" {
+				<-release
+			}
+		})
+	collection.SetInputQueue(input.InputID, mode, maxDepth)
+
+	collection.NotifyInputHandler(input.InputID, "sender1", "1")
+	time.Sleep(10 * time.Millisecond)
+	collection.NotifyInputHandler(input.InputID, "sender1", "2")
+	collection.NotifyInputHandler(input.InputID, "sender1", "3")
+	release <- true
+	time.Sleep(10 * time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return received, collection.GetInputQueueStats(input.InputID)
+}
+
+func TestInputQueue(t *testing.T) {
+	received, stats := sendQueuedCommands(inputs.InputQueueModeFIFO, 1)
+	assert.Equal(t, []string{"1", "2"}, received)
+	assert.Equal(t, inputs.InputQueueStats{Dropped: 1, Handled: 2}, stats)
+
+	received, stats = sendQueuedCommands(inputs.InputQueueModeFIFO, 0)
+	assert.Equal(t, []string{"1", "2", "3"}, received)
+	assert.Equal(t, 0, stats.Dropped)
+
+	received, stats = sendQueuedCommands(inputs.InputQueueModeLatest, 0)
+	assert.Equal(t, []string{"1", "3"}, received)
+	assert.Equal(t, inputs.InputQueueStats{Dropped: 1, Handled: 2}, stats)
+
+	received, stats = sendQueuedCommands(inputs.InputQueueModeReject, 0)
+	assert.Equal(t, []string{"1"}, received)
+	assert.Equal(t, inputs.InputQueueStats{Dropped: 2, Handled: 1}, stats)
+
+	// without a queue there are no statistics
+	collection := inputs.NewRegisteredInputs(domain, publisher1ID)
+	assert.Equal(t, inputs.InputQueueStats{}, collection.GetInputQueueStats("notaninput"))
+}
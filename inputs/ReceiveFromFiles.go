@@ -47,7 +47,8 @@ func (iffile *ReceiveFromFiles) CreateInput(
 	}
 	input := iffile.registeredInputs.CreateInputWithSource(
 		nodeHWID, inputType, instance, fullPath, handler)
-
+	input = iffile.registeredInputs.SetSourceInfo(input.InputID,
+		types.InputSourceInfo{Kind: types.InputSourceFile, Source: fullPath})
 	return input
 }
 
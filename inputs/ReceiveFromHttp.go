@@ -2,6 +2,7 @@
 package inputs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -10,10 +11,14 @@ import (
 	"sync"
 	"time"
 
+	"github.com/iotdomain/iotdomain-go/lib/backoff"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/sirupsen/logrus"
 )
 
+// httpPollBackoff is the retry policy of failed polls
+var httpPollBackoff = backoff.NewBackoff(time.Second, 10*time.Second, 3)
+
 // ReceiveFromHTTP with inputs to periodically poll HTTP
 // Only a single handler per URL can be used.
 type ReceiveFromHTTP struct {
@@ -41,6 +46,7 @@ func (rxFromHttp *ReceiveFromHTTP) CreateHTTPInput(
 	input.Attr[types.NodeAttrPollInterval] = strconv.Itoa(pollInterval)
 	input.Attr[types.NodeAttrLoginName] = login
 	input.Attr[types.NodeAttrPassword] = password
+	input.SourceInfo = &types.InputSourceInfo{Kind: types.InputSourceHTTP, Source: url}
 	rxFromHttp.registeredInputs.UpdateInput(input)
 
 	rxFromHttp.updateMutex.Lock()
@@ -123,8 +129,13 @@ func (rxFromHttp *ReceiveFromHTTP) readInput(input *types.InputDiscoveryMessage)
 }
 
 // Poll the source of the input and notify subscribers with the result
+// Failed polls are retried using the httpPollBackoff policy.
 func (rxFromHttp *ReceiveFromHTTP) pollInputAndNotify(input *types.InputDiscoveryMessage) {
-	payload, err := rxFromHttp.readInput(input)
+	var payload string
+	err := httpPollBackoff.Retry(context.Background(), func(attempt int) (err error) {
+		payload, err = rxFromHttp.readInput(input)
+		return err
+	})
 	if err == nil && payload != "" {
 		inputID := MakeInputHWID(input.NodeHWID, input.InputType, input.Instance)
 		rxFromHttp.registeredInputs.NotifyInputHandler(inputID, "", string(payload))
@@ -30,6 +30,8 @@ func (ifout *ReceiveFromOutputs) CreateInput(
 	handler func(input *types.InputDiscoveryMessage, sender string, payload string)) *types.InputDiscoveryMessage {
 
 	input := ifout.registeredInputs.CreateInputWithSource(nodeID, inputType, instance, outputAddress, handler)
+	input = ifout.registeredInputs.SetSourceInfo(input.InputID,
+		types.InputSourceInfo{Kind: types.InputSourceOutput, Source: outputAddress})
 
 	ifout.updateMutex.Lock()
 	defer ifout.updateMutex.Unlock()
@@ -64,6 +66,7 @@ func (ifout *ReceiveFromOutputs) onReceiveOutput(address string, message string)
 		// Verify this is the most recent message to protect against replay attacks
 		prevTimestamp := ifout.senderTimestamp[address]
 		if prevTimestamp > latestMessage.Timestamp {
+			ifout.messageSigner.ReceiveStats().Update(address, address, messaging.ReceiveResultDroppedDuplicate)
 			return lib.MakeErrorf("onReceiveOutput: earlier timestamp of output %s. Message discarded.", address)
 		}
 		ifout.senderTimestamp[address] = latestMessage.Timestamp
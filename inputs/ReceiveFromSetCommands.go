@@ -22,6 +22,7 @@ type ReceiveFromSetCommands struct {
 	publisherID      string // the registered publisher for the inputs
 	isRunning        bool
 	messageSigner    *messaging.MessageSigner // subscription and publication messenger
+	overridden       map[string]bool          // inputs that ignore set commands by input ID
 	senderTimestamp  map[string]string        // most recent timestamp of received commands by sender
 	registeredInputs *RegisteredInputs        // registered inputs of this publisher
 	// subscriptions of registered inputs
@@ -42,7 +43,20 @@ func (ifset *ReceiveFromSetCommands) CreateInput(
 	input := ifset.registeredInputs.CreateInput(nodeHWID, inputType, instance, handler)
 	// only subscribe if this is a new input
 	ifset.subscribeToSetCommand(input)
-	return input
+	return ifset.setSourceInfo(input)
+}
+
+// CreateEnumInput creates a new enum input that is triggered by set commands with one of the enum values
+func (ifset *ReceiveFromSetCommands) CreateEnumInput(
+	nodeHWID string, inputType types.InputType, instance string, enum types.EnumValues,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string)) *types.InputDiscoveryMessage {
+
+	ifset.updateMutex.Lock()
+	defer ifset.updateMutex.Unlock()
+
+	input := ifset.registeredInputs.CreateEnumInput(nodeHWID, inputType, instance, enum, handler)
+	ifset.subscribeToSetCommand(input)
+	return ifset.setSourceInfo(input)
 }
 
 // DeleteInput deletes the input and unsubscribes to the input's set command
@@ -83,6 +97,7 @@ func (ifset *ReceiveFromSetCommands) decodeSetCommand(address string, message st
 	// Verify this is the most recent message to protect against replay attacks
 	prevTimestamp := ifset.senderTimestamp[setMessage.Sender]
 	if prevTimestamp > setMessage.Timestamp {
+		ifset.messageSigner.ReceiveStats().Update(address, setMessage.Sender, messaging.ReceiveResultDroppedDuplicate)
 		errText := fmt.Sprintf("decodeSetCommand: earlier timestamp of message to input %s from sender %s."+
 			" Message discarded.", address, setMessage.Sender)
 		logrus.Warning(errText)
@@ -94,10 +109,42 @@ func (ifset *ReceiveFromSetCommands) decodeSetCommand(address string, message st
 
 	// the handler is responsible for authorization
 	inputID := ifset.registeredInputs.addressMap[inputAddr]
+	if ifset.IsOverridden(inputID) {
+		return lib.MakeErrorf("decodeSetCommand: Input %s is overridden. Set command from %s discarded.",
+			inputID, setMessage.Sender)
+	}
 	ifset.registeredInputs.NotifyInputHandler(inputID, setMessage.Sender, setMessage.Value)
 	return nil
 }
 
+// IsOverridden returns true if the input ignores set commands
+func (ifset *ReceiveFromSetCommands) IsOverridden(inputID string) bool {
+	ifset.updateMutex.Lock()
+	defer ifset.updateMutex.Unlock()
+	return ifset.overridden[inputID]
+}
+
+// SetOverride sets whether an input ignores set commands. Intended for commissioning and maintenance
+// where an operator prevents remote control of the input.
+func (ifset *ReceiveFromSetCommands) SetOverride(inputID string, overridden bool) {
+	ifset.updateMutex.Lock()
+	defer ifset.updateMutex.Unlock()
+	if overridden {
+		ifset.overridden[inputID] = true
+	} else {
+		delete(ifset.overridden, inputID)
+	}
+}
+
+// setSourceInfo describes the set command address as the source of the input
+func (ifset *ReceiveFromSetCommands) setSourceInfo(input *types.InputDiscoveryMessage) *types.InputDiscoveryMessage {
+	segments := strings.Split(input.Address, "/")
+	segments[5] = types.MessageTypeSetInput
+	setAddr := strings.Join(segments, "/")
+	return ifset.registeredInputs.SetSourceInfo(input.InputID,
+		types.InputSourceInfo{Kind: types.InputSourceSet, Source: setAddr})
+}
+
 // subscribeToSetCommand to receive set input commands for the given node, type and instance
 func (ifset *ReceiveFromSetCommands) subscribeToSetCommand(input *types.InputDiscoveryMessage) {
 	// change message type $input to $set to make the set address from the input address
@@ -150,6 +197,7 @@ func NewReceiveFromSetCommands(
 		domain:           domain,
 		messageSigner:    messageSigner,
 		publisherID:      publisherID,
+		overridden:       make(map[string]bool),
 		registeredInputs: registeredInputs,
 		senderTimestamp:  make(map[string]string),
 		subscriptions:    make(map[string]string),
@@ -31,9 +31,27 @@ func TestCreateSetInput(t *testing.T) {
 		signer, registeredInputs)
 
 	inputID := inputs.MakeInputHWID(node1ID, input1Type, types.DefaultInputInstance)
-	receiver.CreateInput(node1ID, input1Type, types.DefaultInputInstance, nil)
+	input := receiver.CreateInput(node1ID, input1Type, types.DefaultInputInstance, nil)
+	setAddr := inputs.MakeSetInputAddress(domain, publisher1ID, node1ID, input1Type, types.DefaultInputInstance)
+	if assert.NotNil(t, input.SourceInfo) {
+		assert.Equal(t, types.InputSourceSet, input.SourceInfo.Kind)
+		assert.Equal(t, setAddr, input.SourceInfo.Source)
+	}
+	assert.Equal(t, input, registeredInputs.GetInputByID(inputID))
 	receiver.DeleteInput(inputID)
 
+	execInput := inputs.NewExecInput(receiver, node1ID, types.InputTypeSwitch, types.DefaultInputInstance, "echo", "-n")
+	sourceInfo := execInput.Input().SourceInfo
+	if assert.NotNil(t, sourceInfo) {
+		assert.Equal(t, types.InputSourceExec, sourceInfo.Kind)
+		assert.Equal(t, "echo", sourceInfo.Target)
+		assert.NotEmpty(t, sourceInfo.Source)
+	}
+	// the path and arguments of the command are not published
+	receiver.DeleteInput(execInput.Input().InputID)
+	execInput = inputs.NewExecInput(receiver, node1ID, types.InputTypeSwitch, types.DefaultInputInstance,
+		"/usr/bin/curl", "-u", "user:secret")
+	assert.Equal(t, "curl", execInput.Input().SourceInfo.Target)
 }
 
 func TestPublishSetInput(t *testing.T) {
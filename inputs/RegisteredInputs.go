@@ -2,13 +2,22 @@
 package inputs
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
 	"sync"
 	"time"
 
 	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
 )
 
+// RestoredInputSender is the sender passed to input handlers when replaying restored input values
+// This lets the handler distinguish restored values from fresh set commands.
+const RestoredInputSender = "$restored"
+
 // // InputSubscription with handler of subscriber to input updates
 // type InputSubscription struct {
 // 	handler      func(inputAddress string, sender string, value string) // the notification handler of this input
@@ -19,17 +28,79 @@ import (
 // RegisteredInputs manages registration of publisher inputs
 // Generics would be nice as this overlaps with outputs, nodes, publishers
 // The inputID used in the inputMap consist of nodeHWID.inputType.instance
+// Registered inputs are replaced rather than modified so they can be read concurrently. GetAllInputs
+// returns copies. To change a registered input use ModifyInput, or update a Clone using UpdateInput.
 type RegisteredInputs struct {
-	domain            string                                  // the domain of this publisher
-	publisherID       string                                  // the registered publisher for the inputs
-	addressMap        map[string]string                       // lookup inputID by publication address
-	inputsByHWID      map[string]*types.InputDiscoveryMessage // lookup input by inputHWID
-	updatedInputHWIDs map[string]string                       // inputHWIDs of inputs that have been rediscovered/updated
-	updateMutex       *sync.Mutex                             // mutex for async handling of inputs
+	domain            string                                    // the domain of this publisher
+	publisherID       string                                    // the registered publisher for the inputs
+	addressMap        map[string]string                         // lookup inputID by publication address
+	customTypes       map[types.InputType]*types.CustomTypeInfo // registered vendor specific input types
+	handlerGuard      *messaging.HandlerGuard                   // optional recovery of panics in handlers
+	inputsByHWID      map[string]*types.InputDiscoveryMessage   // lookup input by inputHWID
+	isNodeRegistered  func(nodeHWID string) bool                // optional check that the node of new inputs is registered
+	inputValues       map[string]string                         // last received value by inputHWID
+	queues            map[string]*inputQueue                    // set command queues by inputHWID
+	valueUpdateCount  int                                       // nr of value updates since last save
+	updatedInputHWIDs map[string]string                         // inputHWIDs of inputs that have been rediscovered/updated
+	updateMutex       *sync.Mutex                               // mutex for async handling of inputs
+	updateNotifier    *lib.UpdateNotifier                       // notify subscribers of updated inputs
+	updateTracker     *lib.UpdateTracker                        // track updates for consumers with a cursor
 	// notification handlers by inputID
 	handlers map[string]func(input *types.InputDiscoveryMessage, sender string, value string)
 }
 
+// Clone returns a copy of the input with new Attr, Config, EnumValues and SourceInfo
+// Intended for updating the input in a concurrent safe manner in combination with UpdateInput()
+// The type info of custom types is shared as it doesn't change after registration.
+func (regInputs *RegisteredInputs) Clone(input *types.InputDiscoveryMessage) *types.InputDiscoveryMessage {
+	newInput := *input
+	if input.Attr != nil {
+		newInput.Attr = make(types.NodeAttrMap, len(input.Attr))
+		for key, value := range input.Attr {
+			newInput.Attr[key] = value
+		}
+	}
+	if input.Config != nil {
+		newInput.Config = make(types.ConfigAttrMap, len(input.Config))
+		for key, value := range input.Config {
+			newInput.Config[key] = value
+		}
+	}
+	if input.EnumValues != nil {
+		newInput.EnumValues = append(types.EnumValues{}, input.EnumValues...)
+	}
+	if input.SourceInfo != nil {
+		sourceInfo := *input.SourceInfo
+		newInput.SourceInfo = &sourceInfo
+	}
+	return &newInput
+}
+
+// Compact removes the address lookups of inputs that no longer use the address and reclaims the
+// memory of the lookup map. Intended as a periodic maintenance call for long running publishers.
+// Returns the nr of removed lookups.
+func (regInputs *RegisteredInputs) Compact() int {
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	removed := 0
+	for address, inputID := range regInputs.addressMap {
+		input := regInputs.inputsByHWID[inputID]
+		if input == nil || input.Address != address {
+			delete(regInputs.addressMap, address)
+			removed++
+		}
+	}
+	// maps don't shrink when entries are deleted
+	if removed > 0 {
+		addressMap := make(map[string]string, len(regInputs.addressMap))
+		for address, inputID := range regInputs.addressMap {
+			addressMap[address] = inputID
+		}
+		regInputs.addressMap = addressMap
+	}
+	return removed
+}
+
 // CreateInput creates and registers a new input with optional handler for input trigger
 func (regInputs *RegisteredInputs) CreateInput(
 	nodeHWID string, inputType types.InputType, instance string,
@@ -38,6 +109,24 @@ func (regInputs *RegisteredInputs) CreateInput(
 	return regInputs.CreateInputWithSource(nodeHWID, inputType, instance, "", handler)
 }
 
+// CreateEnumInput creates and registers a new enum input whose values are one of the given enum values
+// Set commands with other values are rejected.
+func (regInputs *RegisteredInputs) CreateEnumInput(
+	nodeHWID string, inputType types.InputType, instance string, enum types.EnumValues,
+	handler func(input *types.InputDiscoveryMessage, sender string, value string)) *types.InputDiscoveryMessage {
+
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+
+	input := NewInput(regInputs.domain, regInputs.publisherID, nodeHWID, inputType, instance)
+	input.DataType = types.DataTypeEnum
+	input.EnumValues = enum
+	input.TypeInfo = regInputs.customTypes[inputType]
+
+	regInputs.updateInput(input, handler)
+	return input
+}
+
 // CreateInputWithSource creates and registers a new input that takes its input value from a given source
 // Replaces the existing input if it already exist.
 func (regInputs *RegisteredInputs) CreateInputWithSource(
@@ -49,6 +138,7 @@ func (regInputs *RegisteredInputs) CreateInputWithSource(
 
 	input := NewInput(regInputs.domain, regInputs.publisherID, nodeHWID, inputType, instance)
 	input.Source = source
+	input.TypeInfo = regInputs.customTypes[inputType]
 
 	regInputs.updateInput(input, handler)
 	return input
@@ -62,23 +152,30 @@ func (regInputs *RegisteredInputs) DeleteInput(inputHWID string) {
 	defer regInputs.updateMutex.Unlock()
 
 	// inputAddr := MakeInputDiscoveryAddress(regInputs.domain, regInputs.publisherID, nodeID, inputType, instance)
+	if input := regInputs.inputsByHWID[inputHWID]; input != nil {
+		regInputs.removeAddress(input.Address, inputHWID)
+	}
 	delete(regInputs.inputsByHWID, inputHWID)
 	delete(regInputs.handlers, inputHWID)
+	delete(regInputs.queues, inputHWID)
 	if regInputs.updatedInputHWIDs == nil {
 		regInputs.updatedInputHWIDs = make(map[string]string)
 	}
 	// "" updates mean that the input is deleted
 	regInputs.updatedInputHWIDs[inputHWID] = ""
+	regInputs.updateNotifier.Notify(inputHWID)
+	regInputs.updateTracker.Update(inputHWID)
 }
 
-// GetAllInputs returns the list of inputs
+// GetAllInputs returns copies of the registered inputs
+// Changes to the copies don't affect the registered inputs. Use UpdateInput to apply them.
 func (regInputs *RegisteredInputs) GetAllInputs() []*types.InputDiscoveryMessage {
 	regInputs.updateMutex.Lock()
 	defer regInputs.updateMutex.Unlock()
 
 	var inputList = make([]*types.InputDiscoveryMessage, 0)
-	for _, output := range regInputs.inputsByHWID {
-		inputList = append(inputList, output)
+	for _, input := range regInputs.inputsByHWID {
+		inputList = append(inputList, regInputs.Clone(input))
 	}
 	return inputList
 }
@@ -158,32 +255,236 @@ func (regInputs *RegisteredInputs) GetUpdatedInputs(clearUpdates bool) []*types.
 	return updateList
 }
 
+// GetUpdatedInputsSince returns the inputs that are updated after the given cursor without clearing
+// the updates. Deleted inputs are not included.
+//  cursor is the cursor returned by the previous call. Use 0 to get all inputs updated since creation.
+// This returns the updated inputs in order of update and the cursor to use for the next call.
+func (regInputs *RegisteredInputs) GetUpdatedInputsSince(cursor uint64) (
+	updateList []*types.InputDiscoveryMessage, newCursor uint64) {
+
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+
+	inputIDs, newCursor := regInputs.updateTracker.GetUpdatedSince(cursor)
+	updateList = make([]*types.InputDiscoveryMessage, 0)
+	for _, inputID := range inputIDs {
+		input := regInputs.inputsByHWID[inputID]
+		if input != nil {
+			updateList = append(updateList, input)
+		}
+	}
+	return updateList, newCursor
+}
+
+// GetInputValue returns the last received value of an input, or "" if no value was received
+func (regInputs *RegisteredInputs) GetInputValue(inputID string) string {
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	return regInputs.inputValues[inputID]
+}
+
+// LoadInputValues loads previously saved input values.
+// Intended to restore the last commanded input values after a restart. Values received since
+// startup take precedence over loaded values.
+func (regInputs *RegisteredInputs) LoadInputValues(filename string) error {
+	inputValues := make(map[string]string)
+
+	jsonValues, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return lib.MakeErrorf("LoadInputValues: Unable to open file %s: %s", filename, err)
+	}
+	err = json.Unmarshal(jsonValues, &inputValues)
+	if err != nil {
+		return lib.MakeErrorf("LoadInputValues: Error parsing JSON input values file %s: %v", filename, err)
+	}
+	logrus.Infof("LoadInputValues: Input values loaded successfully from %s", filename)
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	for inputID, value := range inputValues {
+		if _, hasValue := regInputs.inputValues[inputID]; !hasValue {
+			regInputs.inputValues[inputID] = value
+		}
+	}
+	return nil
+}
+
+// ModifyInput applies a modification to a copy of the input and replaces the registered input with
+// the copy. The modification is made in a locked section and must not use the registered inputs.
+// Returns the modified input, or nil if the input doesn't exist.
+func (regInputs *RegisteredInputs) ModifyInput(
+	inputID string, modify func(input *types.InputDiscoveryMessage)) *types.InputDiscoveryMessage {
+
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	input := regInputs.inputsByHWID[inputID]
+	if input == nil {
+		return nil
+	}
+	newInput := regInputs.Clone(input)
+	modify(newInput)
+	regInputs.updateInput(newInput, nil)
+	return newInput
+}
+
 // NotifyInputHandler passes a set input command to the input's handler to execute the request.
 // The sender is the identity address of the publisher and can be used for authorization. It is
 // empty for local inputs such as file watcher and http polling.
+// Inputs with a queue pass the command to the handler according to the queue mode. See SetInputQueue.
 func (regInputs *RegisteredInputs) NotifyInputHandler(inputID string, sender string, value string) {
 
 	handler := regInputs.handlers[inputID]
 	input := regInputs.GetInputByID(inputID)
+	if input != nil && input.DataType == types.DataTypeEnum &&
+		len(input.EnumValues) > 0 && !input.EnumValues.Contains(value) {
+		logrus.Warningf("RegisteredInputs.NotifyInputHandler: Value '%s' of input %s is not one of %v. Ignored.",
+			value, input.Address, input.EnumValues)
+		return
+	}
+	regInputs.updateMutex.Lock()
+	queue := regInputs.queues[inputID]
+	if input != nil && handler != nil && queue != nil {
+		accepted := regInputs.queueCommand(queue, input, handler, sender, value)
+		if accepted && sender != RestoredInputSender {
+			regInputs.inputValues[inputID] = value
+			regInputs.valueUpdateCount++
+		}
+		regInputs.updateMutex.Unlock()
+		return
+	}
+	regInputs.updateMutex.Unlock()
+
+	if input != nil && sender != RestoredInputSender {
+		regInputs.updateMutex.Lock()
+		regInputs.inputValues[inputID] = value
+		regInputs.valueUpdateCount++
+		regInputs.updateMutex.Unlock()
+	}
 	if handler != nil {
-		handler(input, sender, value)
+		regInputs.invokeHandler(handler, input, sender, value)
+	}
+}
+
+// OnUpdated subscribes a handler to updates of inputs. The handler is invoked asynchronously with the
+// ID of each input that is created, updated or deleted, in order of update.
+func (regInputs *RegisteredInputs) OnUpdated(handler func(inputID string)) {
+	regInputs.updateNotifier.Subscribe(handler)
+}
+
+// RegisterCustomType registers a vendor specific input type under the given namespace.
+// Inputs of this type include the type info in their discovery.
+// info is optional. A copy is registered with the namespace and name of the type, so the caller's
+// info isn't modified and changing it afterwards doesn't affect the inputs.
+// Returns the input type to use when creating the input, eg: x-acme:valve-position
+func (regInputs *RegisteredInputs) RegisterCustomType(
+	namespace string, name string, info *types.CustomTypeInfo) (types.InputType, error) {
+
+	inputType := types.MakeCustomType(namespace, name)
+	if _, _, isCustom := types.SplitCustomType(inputType); !isCustom {
+		return "", lib.MakeErrorf("RegisterCustomType: Invalid namespace '%s' or type name '%s'", namespace, name)
+	}
+	typeInfo := types.CustomTypeInfo{}
+	if info != nil {
+		typeInfo = *info
 	}
+	typeInfo.Namespace = namespace
+	typeInfo.Name = name
+
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	regInputs.customTypes[types.InputType(inputType)] = &typeInfo
+	return types.InputType(inputType), nil
+}
+
+// RestoreInputValues replays the last received input values to the handlers of the inputs.
+// The handlers are invoked with RestoredInputSender as the sender. Inputs without value or handler are skipped.
+// Use LoadInputValues to load the values saved before a restart.
+// Returns the number of restored input values.
+func (regInputs *RegisteredInputs) RestoreInputValues() int {
+	type restoredValue struct {
+		input   *types.InputDiscoveryMessage
+		handler func(input *types.InputDiscoveryMessage, sender string, value string)
+		value   string
+	}
+	restoreList := make([]restoredValue, 0)
+
+	regInputs.updateMutex.Lock()
+	for inputID, value := range regInputs.inputValues {
+		input := regInputs.inputsByHWID[inputID]
+		handler := regInputs.handlers[inputID]
+		if input != nil && handler != nil {
+			restoreList = append(restoreList, restoredValue{input: input, handler: handler, value: value})
+		}
+	}
+	regInputs.updateMutex.Unlock()
+
+	// invoke the handlers outside the locked section so they can use the inputs
+	for _, restored := range restoreList {
+		logrus.Infof("RestoreInputValues: restore input %s with value '%s'", restored.input.Address, restored.value)
+		regInputs.invokeHandler(restored.handler, restored.input, RestoredInputSender, restored.value)
+	}
+	return len(restoreList)
+}
+
+// SaveInputValues saves the last received input values to a JSON file and resets the update count
+func (regInputs *RegisteredInputs) SaveInputValues(filename string) error {
+	regInputs.updateMutex.Lock()
+	jsonText, err := json.MarshalIndent(regInputs.inputValues, "", "  ")
+	regInputs.valueUpdateCount = 0
+	regInputs.updateMutex.Unlock()
+	if err != nil {
+		return lib.MakeErrorf("SaveInputValues: Error Marshalling JSON input values '%s': %v", filename, err)
+	}
+	err = ioutil.WriteFile(filename, jsonText, 0664)
+	if err != nil {
+		return lib.MakeErrorf("SaveInputValues: Error saving input values to JSON file %s: %v", filename, err)
+	}
+	logrus.Infof("SaveInputValues: Input values saved successfully to JSON file %s", filename)
+	return nil
+}
+
+// SetHandlerGuard sets the guard that recovers from panics in input handlers
+// Without a guard a panic in a handler is not recovered.
+func (regInputs *RegisteredInputs) SetHandlerGuard(guard *messaging.HandlerGuard) {
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	regInputs.handlerGuard = guard
+}
+
+// SetNodeFilter sets the check that the node of a new input is registered. New inputs of nodes that
+// aren't registered, eg because the max nr of nodes is reached, are not registered. Existing inputs are
+// not removed.
+//  isNodeRegistered returns true if the node is registered. Use nil to accept inputs of any node.
+func (regInputs *RegisteredInputs) SetNodeFilter(isNodeRegistered func(nodeHWID string) bool) {
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	regInputs.isNodeRegistered = isNodeRegistered
 }
 
 // SetNodeID changes the publication address of all inputs that belong to the device hardware address
+// The lookup by the previous address is removed.
 func (regInputs *RegisteredInputs) SetNodeID(nodeHWID string, newNodeID string) {
 	inputList := regInputs.GetInputsByNodeHWID(nodeHWID)
 	for _, input := range inputList {
-		newAddress := MakeInputDiscoveryAddress(
+		newInput := regInputs.Clone(input)
+		newInput.Address = MakeInputDiscoveryAddress(
 			regInputs.domain, regInputs.publisherID, newNodeID, input.InputType, input.Instance)
-		input.Address = newAddress
 		// input.NodeID = newNodeID
 		regInputs.updateMutex.Lock()
-		regInputs.updateInput(input, nil)
+		regInputs.updateInput(newInput, nil)
 		regInputs.updateMutex.Unlock()
 	}
 }
 
+// SetSourceInfo replaces the description of the source and target of an input
+// Returns the updated input or nil if the input doesn't exist.
+func (regInputs *RegisteredInputs) SetSourceInfo(
+	inputID string, sourceInfo types.InputSourceInfo) *types.InputDiscoveryMessage {
+
+	return regInputs.ModifyInput(inputID, func(input *types.InputDiscoveryMessage) {
+		input.SourceInfo = &sourceInfo
+	})
+}
+
 // UpdateInput replaces an existing input with the provided input.
 // The input must already exist and be created using 'CreateInput', otherwise it returns an error
 func (regInputs *RegisteredInputs) UpdateInput(input *types.InputDiscoveryMessage) error {
@@ -198,24 +499,88 @@ func (regInputs *RegisteredInputs) UpdateInput(input *types.InputDiscoveryMessag
 	return nil
 }
 
+// ValueUpdateCount returns the nr of input values received since the last SaveInputValues call
+func (regInputs *RegisteredInputs) ValueUpdateCount() int {
+	regInputs.updateMutex.Lock()
+	defer regInputs.updateMutex.Unlock()
+	return regInputs.valueUpdateCount
+}
+
+// invokeHandler invokes the input handler through the handler guard if set
+// Use outside a locked section.
+func (regInputs *RegisteredInputs) invokeHandler(
+	handler func(input *types.InputDiscoveryMessage, sender string, value string),
+	input *types.InputDiscoveryMessage, sender string, value string) {
+
+	regInputs.updateMutex.Lock()
+	guard := regInputs.handlerGuard
+	regInputs.updateMutex.Unlock()
+	if guard == nil {
+		handler(input, sender, value)
+		return
+	}
+	address := ""
+	if input != nil {
+		address = input.Address
+	}
+	guard.Invoke(address, func() error {
+		handler(input, sender, value)
+		return nil
+	})
+}
+
+// removeAddress removes the lookup of an address if it refers to the input
+// Use within a locked section.
+func (regInputs *RegisteredInputs) removeAddress(address string, inputID string) {
+	if regInputs.addressMap[address] == inputID {
+		delete(regInputs.addressMap, address)
+	}
+}
+
 // updateInput replaces an existing input or adds the provided input.
 // If the input doesn't exist it will be added. The input is also added to the updatedInputs map
 // The handler for this input will be stored if provided. Use nil to retain the existing handler.
+// The lookup by the address of a replaced input instance is removed.
+// A replacement instance whose content equals the existing input, apart from its timestamp, is not
+// marked as updated. The existing instance itself is always marked as it could have been modified.
+// New inputs of a node that isn't registered are rejected when a node filter is set.
 // Use within a locked section.
 func (regInputs *RegisteredInputs) updateInput(input *types.InputDiscoveryMessage,
 	handler func(input *types.InputDiscoveryMessage, sender string, value string)) {
 
+	existing := regInputs.inputsByHWID[input.InputID]
+	if existing == nil && regInputs.isNodeRegistered != nil && !regInputs.isNodeRegistered(input.NodeHWID) {
+		logrus.Warningf("RegisteredInputs.updateInput: Input '%s' is rejected. Node '%s' isn't registered",
+			input.InputID, input.NodeHWID)
+		return
+	}
+	if existing != nil && existing != input {
+		regInputs.removeAddress(existing.Address, input.InputID)
+	}
 	regInputs.inputsByHWID[input.InputID] = input
 	regInputs.addressMap[input.Address] = input.InputID
 	if handler != nil {
 		regInputs.handlers[input.InputID] = handler
 	}
+	if existing != nil && existing != input && !isInputChanged(existing, input) {
+		input.Timestamp = existing.Timestamp
+		return
+	}
 	// track which inputs are updated
 	if regInputs.updatedInputHWIDs == nil {
 		regInputs.updatedInputHWIDs = make(map[string]string)
 	}
 	input.Timestamp = time.Now().Format(types.TimeFormat)
 	regInputs.updatedInputHWIDs[input.InputID] = input.InputID
+	regInputs.updateNotifier.Notify(input.InputID)
+	regInputs.updateTracker.Update(input.InputID)
+}
+
+// isInputChanged returns true if the inputs differ in more than their timestamp
+func isInputChanged(oldInput *types.InputDiscoveryMessage, newInput *types.InputDiscoveryMessage) bool {
+	oldCopy := *oldInput
+	oldCopy.Timestamp = newInput.Timestamp
+	return !reflect.DeepEqual(&oldCopy, newInput)
 }
 
 // MakeInputHWID creates the internal ID to identify the input of the owning node using its HWID
@@ -251,12 +616,17 @@ func NewInput(
 func NewRegisteredInputs(domain string, publisherID string) *RegisteredInputs {
 
 	regInputs := &RegisteredInputs{
-		domain:       domain,
-		publisherID:  publisherID,
-		addressMap:   make(map[string]string),
-		inputsByHWID: make(map[string]*types.InputDiscoveryMessage),
-		handlers:     make(map[string]func(input *types.InputDiscoveryMessage, sender string, newValue string)),
-		updateMutex:  &sync.Mutex{},
+		domain:         domain,
+		publisherID:    publisherID,
+		addressMap:     make(map[string]string),
+		customTypes:    make(map[types.InputType]*types.CustomTypeInfo),
+		inputsByHWID:   make(map[string]*types.InputDiscoveryMessage),
+		inputValues:    make(map[string]string),
+		queues:         make(map[string]*inputQueue),
+		handlers:       make(map[string]func(input *types.InputDiscoveryMessage, sender string, newValue string)),
+		updateMutex:    &sync.Mutex{},
+		updateNotifier: lib.NewUpdateNotifier(),
+		updateTracker:  lib.NewUpdateTracker(),
 	}
 	return regInputs
 }
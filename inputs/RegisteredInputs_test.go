@@ -2,6 +2,7 @@ package inputs_test
 
 import (
 	"crypto/ecdsa"
+	"encoding/json"
 	"fmt"
 	"testing"
 
@@ -87,6 +88,18 @@ func TestUpdateInput(t *testing.T) {
 	updated = collection.GetUpdatedInputs(false)
 	require.Len(t, updated, 1, "Existing input should be updated")
 	assert.Equal(t, "hello", input1b.Source, "Updating input not successful")
+
+	// replacing the input with identical content doesn't mark it as updated
+	collection.GetUpdatedInputs(true)
+	identical := collection.Clone(input1b)
+	identical.Timestamp = "later"
+	collection.UpdateInput(identical)
+	assert.Empty(t, collection.GetUpdatedInputs(true))
+	assert.Equal(t, input1b.Timestamp, collection.GetInputByID(input1b.InputID).Timestamp)
+	identical = collection.Clone(input1b)
+	identical.Source = "world"
+	collection.UpdateInput(identical)
+	assert.Len(t, collection.GetUpdatedInputs(true), 1)
 }
 
 func TestChangeNodeID(t *testing.T) {
@@ -104,6 +117,141 @@ func TestChangeNodeID(t *testing.T) {
 	assert.Equal(t, newInputAddr, input1c.Address, "Input doesn't have the new NodeID")
 }
 
+// TestConcurrentGetAllInputs tests that the inputs from GetAllInputs can be read while inputs
+// are renamed and modified. Run with -race to detect concurrent access.
+func TestConcurrentGetAllInputs(t *testing.T) {
+	collection := inputs.NewRegisteredInputs(domain, publisher1ID)
+	input := collection.CreateInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance, nil)
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			collection.SetNodeID(node1ID, fmt.Sprintf("name%d", i))
+			collection.ModifyInput(input.InputID, func(input *types.InputDiscoveryMessage) {
+				input.Attr[types.NodeAttrName] = fmt.Sprintf("switch%d", i)
+			})
+		}
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		for _, input := range collection.GetAllInputs() {
+			_, err := json.Marshal(input)
+			assert.NoError(t, err)
+		}
+	}
+	// changing a copy doesn't change the registered input
+	copies := collection.GetAllInputs()
+	require.Len(t, copies, 1)
+	copies[0].Attr[types.NodeAttrName] = "changed"
+	assert.Equal(t, "switch99", collection.GetAllInputs()[0].Attr[types.NodeAttrName])
+
+	assert.Nil(t, collection.ModifyInput("notaninput", func(input *types.InputDiscoveryMessage) {}))
+}
+
+// TestRenameAddressCleanup tests that the address lookups don't grow when nodes are renamed repeatedly
+func TestRenameAddressCleanup(t *testing.T) {
+	collection := inputs.NewRegisteredInputs(domain, publisher1ID)
+	collection.CreateInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance, nil)
+	collection.CreateInput(node1ID, types.InputTypeSwitch, "1", nil)
+
+	for i := 0; i < 100; i++ {
+		collection.SetNodeID(node1ID, fmt.Sprintf("name%d", i))
+	}
+	assert.Nil(t, collection.GetInputByAddress(node1InputAddr), "Old address should not resolve")
+	lastAddress := inputs.MakeInputDiscoveryAddress(domain, publisher1ID, "name99", types.InputTypeSwitch, types.DefaultInputInstance)
+	assert.NotNil(t, collection.GetInputByAddress(lastAddress))
+	assert.Equal(t, 0, collection.Compact(), "Renaming should not leave stale addresses")
+
+	// re-registering an input with another address replaces its lookup
+	collection.CreateInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance, nil)
+	assert.Nil(t, collection.GetInputByAddress(lastAddress))
+	assert.NotNil(t, collection.GetInputByAddress(node1InputAddr))
+	collection.DeleteInput(inputs.MakeInputHWID(node1ID, types.InputTypeSwitch, types.DefaultInputInstance))
+	assert.Nil(t, collection.GetInputByAddress(node1InputAddr))
+	assert.Equal(t, 0, collection.Compact())
+}
+
+func TestCustomInputType(t *testing.T) {
+	regInputs := inputs.NewRegisteredInputs(domain, publisher1ID)
+	typeInfo := &types.CustomTypeInfo{Description: "Valve position", DataType: types.DataTypeNumber}
+	inputType, err := regInputs.RegisterCustomType("acme", "valve-position", typeInfo)
+	require.NoError(t, err)
+	assert.Equal(t, types.InputType("x-acme:valve-position"), inputType)
+
+	input := regInputs.CreateInput(node1ID, inputType, types.DefaultInputInstance, nil)
+	require.NotNil(t, input.TypeInfo, "Missing custom type info")
+	assert.Equal(t, "acme", input.TypeInfo.Namespace)
+	assert.Equal(t, "valve-position", input.TypeInfo.Name)
+	// the registered info is a copy
+	assert.Empty(t, typeInfo.Namespace)
+	typeInfo.Description = "changed"
+	assert.Equal(t, "Valve position", input.TypeInfo.Description)
+
+	// invalid namespace
+	_, err = regInputs.RegisterCustomType("acme/bad", "valve", nil)
+	assert.Error(t, err)
+	_, err = regInputs.RegisterCustomType("", "valve", nil)
+	assert.Error(t, err)
+}
+
+func TestRestoreInputValues(t *testing.T) {
+	const inputValuesFile = "../test/testinputvalues.json"
+	var restoredSender string
+	var restoredValue string
+
+	regInputs := inputs.NewRegisteredInputs(domain, publisher1ID)
+	input := regInputs.CreateInput(node1ID, types.InputTypeTemperature, types.DefaultInputInstance, nil)
+	regInputs.NotifyInputHandler(input.InputID, "sender1", "21.5")
+	assert.Equal(t, "21.5", regInputs.GetInputValue(input.InputID))
+	assert.Equal(t, 1, regInputs.ValueUpdateCount())
+
+	err := regInputs.SaveInputValues(inputValuesFile)
+	require.NoError(t, err)
+	assert.Equal(t, 0, regInputs.ValueUpdateCount())
+
+	// after a restart the value is loaded and replayed to the new handler
+	regInputs2 := inputs.NewRegisteredInputs(domain, publisher1ID)
+	regInputs2.CreateInput(node1ID, types.InputTypeTemperature, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			restoredSender = sender
+			restoredValue = value
+		})
+	err = regInputs2.LoadInputValues(inputValuesFile)
+	require.NoError(t, err)
+	count := regInputs2.RestoreInputValues()
+	assert.Equal(t, 1, count)
+	assert.Equal(t, inputs.RestoredInputSender, restoredSender)
+	assert.Equal(t, "21.5", restoredValue)
+	assert.Equal(t, 0, regInputs2.ValueUpdateCount(), "Restored values are not new updates")
+
+	err = regInputs2.LoadInputValues("../test/doesnotexist.json")
+	assert.Error(t, err)
+}
+
+func TestEnumInput(t *testing.T) {
+	var receivedValue string
+	regInputs := inputs.NewRegisteredInputs(domain, publisher1ID)
+	input := regInputs.CreateEnumInput(node1ID, types.InputTypeTemperature, types.DefaultInputInstance,
+		types.EnumThermostatMode,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			receivedValue = value
+		})
+	assert.Equal(t, types.DataTypeEnum, input.DataType)
+	assert.Equal(t, types.EnumThermostatMode, input.EnumValues)
+
+	regInputs.NotifyInputHandler(input.InputID, "sender1", "heat")
+	assert.Equal(t, "heat", receivedValue)
+	// values that are not in the enum are ignored
+	regInputs.NotifyInputHandler(input.InputID, "sender1", "boil")
+	assert.Equal(t, "heat", receivedValue)
+	assert.Equal(t, "heat", regInputs.GetInputValue(input.InputID))
+}
+
 func TestPublish(t *testing.T) {
 
 	var privKey = messaging.CreateAsymKeys()
@@ -0,0 +1,167 @@
+// Package lib with generation of template configuration files for bootstrapping new publishers
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// messengerConfigTemplate with the commented defaults of the messenger configuration.
+// The domain is substituted on generation.
+const messengerConfigTemplate = `# Message bus configuration shared by all publishers on this host
+# Domain used by all publishers. Default is local
+domain: %s
+# Message bus server/broker hostname or ip address. Leave empty to locate the broker or DSS with mDNS
+server: localhost
+# Optional port. Default is 8883 for TLS
+#port: 8883
+# Messenger client type: DummyMessenger (default) or MQTTMessenger
+messenger: MQTTMessenger
+# Messenger login name and credentials
+login: ""
+credentials: ""
+# Optional connect ID, must be unique. Default is generated
+#clientid: ""
+# Optional suffix of the connect ID: none, pid, random or time. Default is time without clientid, none with
+#clientIdSuffix: pid
+# Keep subscriptions and queued messages on the broker during short disconnects. Requires a stable clientid
+#persistentSession: false
+# Seconds to resume a lost persistent session. After that queued messages are discarded. Default (0) always resumes
+#sessionExpiry: 0
+# Optional topic prefix required by the broker, eg tenants/acme
+#prefix: ""
+# Publishing and subscription QOS 0-2. Default is 0
+#pubqos: 0
+#subqos: 0
+# The broker doesn't support retained messages. Publishers periodically republish their discovery instead
+#noRetain: false
+# Message signing used by all publishers
+signing: true
+`
+
+// appConfigTemplate with the commented defaults of the publisher configuration of an application.
+// The publisher ID is substituted on generation.
+const appConfigTemplate = `# Configuration of publisher %s
+publisherId: %s
+# Optional override of the domain from the messenger configuration
+#domain: local
+# Log level: error, warning, info, debug. Default is warning
+loglevel: warning
+# Optional log file. Default logs to stderr
+#logfile: ""
+# Load/save discovered publisher identities, nodes and last received input values to the cache folder
+cachePublishers: false
+cacheNodes: false
+cacheInputs: false
+# Replay cached input values to input handlers after the first discovery
+restoreInputs: false
+# Location of the cache files. Default is the platform cache folder
+#cacheFolder: ""
+# Disable configuration and inputs over the bus. Default is enabled
+disableConfig: false
+disableInput: false
+# Require a secured domain and signed messages
+securedDomain: false
+# Seconds to finish handlers and flush on shutdown. Default is 10
+#shutdownDeadline: 10
+# Max nr of updates published per heartbeat. Default (0) is unlimited
+#publishBudget: 0
+# Quotas that protect a shared gateway from adapters that discover an unbounded nr of devices. Default (0) is unlimited
+#maxNodes: 0
+#maxNodeOutputs: 0
+#maxHistoryKB: 0
+#maxPublishRate: 0
+# Dampen publication of nodes that appear or change runState flapTransitions times within flapMinutes. 0 is disabled
+#flapTransitions: 0
+#flapMinutes: 10
+# Enable configuration of this publisher through its own node
+selfConfigure: false
+# Fixed node ID of the publisher's own node. Default is 'publisher'
+#publisherNodeId: ""
+# Also publish output values on this address template for legacy consumers. Default is disabled
+#addressTemplate: "legacy/{domain}/{publisher}/{nodeId}/{type}/{instance}/{msgtype}"
+# Append received messages that are rejected to this file for debugging. Default is disabled
+#deadLetterFile: ""
+# Stop publishing when another publisher uses this publisher ID with a different key
+#stopOnConflict: false
+# Publish security relevant events, eg identity conflicts and spoofed messages, on $domainlog for a SIEM
+#domainLog: false
+# Check at startup that the broker ACL authorizes the publications and subscriptions of the publisher
+#preflightTopics: false
+# Priority of the claim to the publisher ID when another publisher uses it. The highest priority keeps the ID
+#identityPriority: 0
+# Republish discovery when a publisher joins, for brokers without retained messages. At most once per nr of seconds. Default (0) is disabled
+#republishOnJoin: 0
+# Seconds between republishing discovery when the message bus doesn't retain messages. Default is 30
+#republishInterval: 30
+# Seconds between republishing the publisher status. Its timestamp is used to estimate the clock skew of the domain. Default is 60
+#statusInterval: 60
+# YAML file with nodes to pre-register before they are discovered, relative to the config folder. Default is none
+#provisionFile: ""
+# Message types that are only published while consumers announce their interest with $interest. Default publishes all
+#interestTypes: ["$history", "$event", "$raw"]
+# Max nr of output values per minute that are replayed from the history when consumers request missed values with $replay. 0 is disabled
+#replayBudget: 0
+# Load/save the past forecasts of outputs to the cache folder to retain the forecast accuracy between restarts
+#cacheForecasts: false
+# Load/save the sequence nrs of publications to the cache folder so consumers can detect missed values after a restart
+#cacheSequences: false
+# Load/save the desired properties of node twins to the cache folder to restore the desired state after a restart
+#cacheTwins: false
+# Publish $raw values unsigned with their detached signature on $rawsig, for consumers that can't parse JWS
+#rawSignatures: false
+# Advertise this publisher on the LAN with mDNS as _iotdomain._tcp so it can be located without configuration
+#advertise: false
+# Role of publishers that are allowed to send $control commands, by identity address: viewer, operator or admin
+#controlRoles:
+#  local/dashboard/$identity: operator
+# Listen address of the net/http/pprof endpoint for diagnosing performance issues. It has no authentication. Default is disabled
+#pprofListen: localhost:6060
+# Allow the pprof endpoint to listen on a non-loopback address, making it accessible from the network
+#pprofAllowRemote: false
+# Folder of CPU and heap profiles captured with the profileCPU and profileHeap control commands. Default is the cache folder
+#profileFolder: ""
+`
+
+// WriteConfigTemplates writes template messenger and application configuration files with commented
+// defaults into the configuration folder, creating the folder if needed. Existing files are not
+// overwritten. Intended for bootstrapping a new publisher, for example in a container.
+//  configFolder to write the files to. Use "" for DefaultConfigFolder.
+//  appID is the application ID used as publisher ID in <appID>.yaml
+//  domain is the domain to use in the messenger configuration. Use "" for the local domain.
+// This returns the paths of the files that were written.
+func WriteConfigTemplates(configFolder string, appID string, domain string) (written []string, err error) {
+	if configFolder == "" {
+		configFolder = DefaultConfigFolder
+	}
+	if appID == "" {
+		return nil, MakeErrorf("WriteConfigTemplates: Missing application ID")
+	}
+	if domain == "" {
+		domain = "local"
+	}
+	err = os.MkdirAll(configFolder, 0700)
+	if err != nil {
+		return nil, MakeErrorf("WriteConfigTemplates: Unable to create folder %s: %s", configFolder, err)
+	}
+	templates := map[string]string{
+		MessengerConfigFile:     fmt.Sprintf(messengerConfigTemplate, domain),
+		appID + AppConfigSuffix: fmt.Sprintf(appConfigTemplate, appID, appID),
+	}
+	written = make([]string, 0)
+	for _, filename := range []string{MessengerConfigFile, appID + AppConfigSuffix} {
+		fullPath := filepath.Join(configFolder, filename)
+		if _, err = os.Stat(fullPath); err == nil {
+			continue
+		}
+		// the messenger configuration can contain credentials
+		err = ioutil.WriteFile(fullPath, []byte(templates[filename]), 0600)
+		if err != nil {
+			return written, MakeErrorf("WriteConfigTemplates: Unable to write %s: %s", fullPath, err)
+		}
+		written = append(written, fullPath)
+	}
+	return written, nil
+}
@@ -0,0 +1,189 @@
+// Package lib with validation of yaml configuration files
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigError with all problems found in a configuration, so they can be fixed in one go instead of
+// one restart at a time
+type ConfigError struct {
+	Filename string   // configuration file with the problems, if known
+	Problems []string // description of each problem
+}
+
+// Add a problem to the configuration error
+func (configErr *ConfigError) Add(format string, args ...interface{}) {
+	configErr.Problems = append(configErr.Problems, fmt.Sprintf(format, args...))
+}
+
+// Error returns the report with the problems of the configuration, one per line
+func (configErr *ConfigError) Error() string {
+	source := "configuration"
+	if configErr.Filename != "" {
+		source = "configuration " + configErr.Filename
+	}
+	return fmt.Sprintf("Invalid %s, %d problem(s):\n  - %s",
+		source, len(configErr.Problems), strings.Join(configErr.Problems, "\n  - "))
+}
+
+// ErrorOrNil returns the configuration error if it has problems, or nil if it has none
+func (configErr *ConfigError) ErrorOrNil() error {
+	if len(configErr.Problems) == 0 {
+		return nil
+	}
+	return configErr
+}
+
+// CheckFolderWritable returns an error if the folder doesn't exist or files can't be created in it
+func CheckFolderWritable(folder string) error {
+	info, err := os.Stat(folder)
+	if err != nil {
+		return MakeErrorf("Folder %s does not exist", folder)
+	} else if !info.IsDir() {
+		return MakeErrorf("%s is not a folder", folder)
+	}
+	tmpFile, err := ioutil.TempFile(folder, ".writecheck")
+	if err != nil {
+		return MakeErrorf("Folder %s is not writable: %s", folder, err)
+	}
+	tmpFile.Close()
+	os.Remove(tmpFile.Name())
+	return nil
+}
+
+// FindUnknownYamlKeys returns the keys in a yaml configuration file that are not a yaml field of any
+// of the targets the file is loaded into, with the closest known key as suggestion, eg:
+//  unknown key 'loglevl'. Did you mean 'loglevel'?
+// Intended to catch typos that would otherwise silently be ignored. Only top level keys are checked.
+//  configFolder contains the configuration file. Use "" for default, which is DefaultConfigFolder
+//  filename of the configuration file
+//  targets are the structs the file is loaded into. Targets that are not structs accept all keys.
+// This returns an error if the file can't be read or parsed.
+func FindUnknownYamlKeys(configFolder string, filename string, targets ...interface{}) (unknown []string, err error) {
+	if configFolder == "" {
+		configFolder = DefaultConfigFolder
+	}
+	rawConfig, err := ioutil.ReadFile(filepath.Join(configFolder, filename))
+	if err != nil {
+		return nil, err
+	}
+	rawKeys := make(map[string]interface{})
+	err = yaml.Unmarshal(rawConfig, &rawKeys)
+	if err != nil {
+		return nil, err
+	}
+	knownKeys := make([]string, 0)
+	for _, target := range targets {
+		targetKeys, isStruct := yamlFieldNames(target)
+		if !isStruct {
+			return []string{}, nil
+		}
+		knownKeys = append(knownKeys, targetKeys...)
+	}
+	unknown = make([]string, 0)
+	for key := range rawKeys {
+		if !containsString(knownKeys, key) {
+			problem := fmt.Sprintf("unknown key '%s'", key)
+			if suggestion := closestString(key, knownKeys); suggestion != "" {
+				problem += fmt.Sprintf(". Did you mean '%s'?", suggestion)
+			}
+			unknown = append(unknown, problem)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+// closestString returns the candidate within an edit distance of 2 of the value, or "" if none is close
+// Matching is case insensitive as yaml keys are case sensitive and capitalization is a common mistake.
+func closestString(value string, candidates []string) string {
+	closest := ""
+	closestDistance := 3
+	for _, candidate := range candidates {
+		distance := editDistance(strings.ToLower(value), strings.ToLower(candidate))
+		if distance < closestDistance {
+			closest = candidate
+			closestDistance = distance
+		}
+	}
+	return closest
+}
+
+// containsString returns true if the list contains the value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// yamlFieldNames returns the yaml keys of the fields of a struct, including those of inlined structs
+// This returns false if the target is not a struct or pointer to a struct.
+func yamlFieldNames(target interface{}) (names []string, isStruct bool) {
+	targetType := reflect.TypeOf(target)
+	for targetType != nil && targetType.Kind() == reflect.Ptr {
+		targetType = targetType.Elem()
+	}
+	if targetType == nil || targetType.Kind() != reflect.Struct {
+		return nil, false
+	}
+	names = make([]string, 0, targetType.NumField())
+	for i := 0; i < targetType.NumField(); i++ {
+		field := targetType.Field(i)
+		tagParts := strings.Split(field.Tag.Get("yaml"), ",")
+		if tagParts[0] == "-" {
+			continue
+		} else if len(tagParts) > 1 && tagParts[1] == "inline" {
+			inlineNames, inlineStruct := yamlFieldNames(reflect.New(field.Type).Interface())
+			if !inlineStruct {
+				// an inline map accepts all keys
+				return nil, false
+			}
+			names = append(names, inlineNames...)
+		} else if tagParts[0] != "" {
+			names = append(names, tagParts[0])
+		} else if field.PkgPath == "" {
+			// yaml.v2 defaults to the lowercase field name
+			names = append(names, strings.ToLower(field.Name))
+		}
+	}
+	return names, true
+}
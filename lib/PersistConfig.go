@@ -4,7 +4,7 @@ package lib
 import (
 	"io/ioutil"
 	"os"
-	"path"
+	"path/filepath"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -20,11 +20,12 @@ const MessengerConfigFile = "messenger.yaml"
 // UserHomeDir is the user's home folder for default config
 var UserHomeDir, _ = os.UserHomeDir()
 
-// DefaultConfigFolder for publisher configuration files: ~/.config/iotc
-var DefaultConfigFolder = path.Join(UserHomeDir, ".config", "iotdomain")
+// DefaultConfigFolder for publisher configuration files, ~/.config/iotdomain on Linux. See GetConfigFolder.
+var DefaultConfigFolder = GetConfigFolder()
 
-// DefaultCacheFolder for caching discovered nodes and other publishers
-var DefaultCacheFolder = path.Join(UserHomeDir, ".cache", "iotdomain")
+// DefaultCacheFolder for caching discovered nodes and other publishers, ~/.cache/iotdomain on Linux.
+// See GetCacheFolder.
+var DefaultCacheFolder = GetCacheFolder()
 
 // LoadAppConfig loads the application configuration from a configuration file
 //
@@ -51,7 +52,7 @@ func LoadMessengerConfig(configFolder string, messengerConfig interface{}) error
 // It performs template substitution of expressions {publisher} and {hostname}
 //
 // altConfigFolder contains the location for the configuration files.
-//   Use "" for default, which is <userhome>/.config/iotdomain
+//   Use "" for default, which is DefaultConfigFolder
 // filename has the name of the file to load
 // publisherID is used for possible substitution use in the config file
 // target is the destination object. This must have a yaml encoding set for the fields
@@ -60,7 +61,7 @@ func LoadYamlConfig(configFolder string, filename string, publisherID string, ta
 	if configFolder == "" {
 		configFolder = DefaultConfigFolder
 	}
-	fullPath := path.Join(configFolder, filename)
+	fullPath := filepath.Join(configFolder, filename)
 
 	rawConfig, err := ioutil.ReadFile(fullPath)
 	if err != nil {
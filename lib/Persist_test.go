@@ -2,6 +2,9 @@
 package lib_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/iotdomain/iotdomain-go/lib"
@@ -39,6 +42,36 @@ func TestAppConfig(t *testing.T) {
 
 }
 
+func TestFindUnknownYamlKeys(t *testing.T) {
+	unknown, err := lib.FindUnknownYamlKeys(configFolder, PublisherID+lib.AppConfigSuffix, &TestConfig{})
+	assert.NoError(t, err)
+	assert.Empty(t, unknown)
+
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	ioutil.WriteFile(filepath.Join(tempFolder, "typo.yaml"), []byte("cstrng: x\nserver: localhost\nbogus: 1\n"), 0600)
+	unknown, err = lib.FindUnknownYamlKeys(tempFolder, "typo.yaml", &TestConfig{}, &MessengerConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"unknown key 'bogus'", "unknown key 'cstrng'. Did you mean 'cstring'?"}, unknown)
+	// maps accept all keys
+	unknown, err = lib.FindUnknownYamlKeys(tempFolder, "typo.yaml", &TestConfig{}, map[string]string{})
+	assert.NoError(t, err)
+	assert.Empty(t, unknown)
+	_, err = lib.FindUnknownYamlKeys(tempFolder, "missing.yaml", &TestConfig{})
+	assert.Error(t, err)
+
+	assert.NoError(t, lib.CheckFolderWritable(tempFolder))
+	assert.Error(t, lib.CheckFolderWritable(filepath.Join(tempFolder, "missing")))
+
+	configErr := &lib.ConfigError{Filename: "typo.yaml"}
+	assert.NoError(t, configErr.ErrorOrNil())
+	configErr.Add("%s is invalid", "cstring")
+	configErr.Add("cnumber is missing")
+	assert.Error(t, configErr.ErrorOrNil())
+	assert.Contains(t, configErr.Error(), "typo.yaml, 2 problem(s)")
+	assert.Contains(t, configErr.Error(), "cstring is invalid")
+}
+
 // TestMessengerConfig load configuration
 func TestMessengerConfig(t *testing.T) {
 	messengerConfig := MessengerConfig{}
@@ -0,0 +1,30 @@
+// Package lib with authorization of clients using roles
+package lib
+
+import (
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// RoleMap maps clients, eg API tokens, client certificate names or sender identity addresses, to their role
+type RoleMap map[string]types.Role
+
+// Authorize returns an error if the client doesn't have the required role or a more privileged role
+//  client is the token, certificate name or identity address of the client
+//  required is the minimum role for the operation
+func (roles RoleMap) Authorize(client string, required types.Role) error {
+	role := roles[client]
+	if !HasRole(role, required) {
+		return MakeErrorf("Authorize: The '%s' role is required. Client has role '%s'", required, role)
+	}
+	return nil
+}
+
+// HasRole returns true if the role is the required role or a more privileged role
+// Unknown roles have no privileges.
+func HasRole(role types.Role, required types.Role) bool {
+	rank, isKnown := types.RoleRank[role]
+	if !isKnown || role == types.RoleNone {
+		return false
+	}
+	return rank >= types.RoleRank[required]
+}
@@ -0,0 +1,24 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoles(t *testing.T) {
+	assert.True(t, lib.HasRole(types.RoleAdmin, types.RoleOperator))
+	assert.True(t, lib.HasRole(types.RoleViewer, types.RoleViewer))
+	assert.False(t, lib.HasRole(types.RoleViewer, types.RoleOperator))
+	assert.False(t, lib.HasRole(types.RoleNone, types.RoleNone))
+	assert.False(t, lib.HasRole("superuser", types.RoleViewer), "Unknown roles have no privileges")
+
+	roles := lib.RoleMap{"dashboard": types.RoleViewer, "operator1": types.RoleOperator}
+	assert.NoError(t, roles.Authorize("dashboard", types.RoleViewer))
+	assert.Error(t, roles.Authorize("dashboard", types.RoleOperator))
+	assert.NoError(t, roles.Authorize("operator1", types.RoleOperator))
+	assert.Error(t, roles.Authorize("operator1", types.RoleAdmin))
+	assert.Error(t, roles.Authorize("unknown", types.RoleViewer))
+}
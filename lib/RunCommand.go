@@ -0,0 +1,43 @@
+// Package lib with running of external commands
+package lib
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultCommandTimeout is the max time a command is allowed to run
+const DefaultCommandTimeout = 10 * time.Second
+
+// RunCommand runs a command and returns its output without leading and trailing whitespace.
+// The command is run directly, not through a shell, so arguments are not interpreted.
+//  timeout is the max time the command can run. Use 0 for DefaultCommandTimeout
+//  env are additional environment variables in the form key=value
+// This returns an error if the command fails, exits with an error or times out.
+func RunCommand(timeout time.Duration, env []string, command string, args ...string) (output string, err error) {
+	if timeout <= 0 {
+		timeout = DefaultCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", MakeErrorf("RunCommand: Command '%s' timed out after %s", command, timeout)
+	} else if err != nil {
+		return "", MakeErrorf("RunCommand: Command '%s' failed: %s. %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,28 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunCommand(t *testing.T) {
+	output, err := lib.RunCommand(0, nil, "echo", " hello ")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", output)
+
+	// environment is passed to the command
+	output, err = lib.RunCommand(0, []string{"TEST_VALUE=42"}, "sh", "-c", "echo $TEST_VALUE")
+	assert.NoError(t, err)
+	assert.Equal(t, "42", output)
+
+	// error cases
+	_, err = lib.RunCommand(0, nil, "false")
+	assert.Error(t, err)
+	_, err = lib.RunCommand(0, nil, "no-such-command-xyz")
+	assert.Error(t, err)
+	_, err = lib.RunCommand(100*time.Millisecond, nil, "sleep", "2")
+	assert.Error(t, err)
+}
@@ -0,0 +1,69 @@
+// Package lib with notification of updates to registered nodes, inputs and outputs
+package lib
+
+import (
+	"sync"
+)
+
+// UpdateNotifier notifies subscribers of the IDs of updated items in a registry.
+// Notifications are queued while the registry is locked and delivered in order by a background
+// goroutine, so handlers can safely use the registry.
+type UpdateNotifier struct {
+	handlers    []func(id string) // subscribed handlers
+	isDraining  bool              // a goroutine is delivering the pending notifications
+	pending     []string          // IDs to notify in order of update
+	updateMutex *sync.Mutex       // mutex for async notification
+}
+
+// Notify queues the notification of an updated ID to the subscribed handlers
+// Intended for use within a locked section of the registry. The handlers are invoked asynchronously.
+func (notifier *UpdateNotifier) Notify(id string) {
+	notifier.updateMutex.Lock()
+	defer notifier.updateMutex.Unlock()
+	if len(notifier.handlers) == 0 {
+		return
+	}
+	notifier.pending = append(notifier.pending, id)
+	if !notifier.isDraining {
+		notifier.isDraining = true
+		go notifier.drain()
+	}
+}
+
+// Subscribe adds a handler that is invoked with the ID of each updated item
+func (notifier *UpdateNotifier) Subscribe(handler func(id string)) {
+	notifier.updateMutex.Lock()
+	defer notifier.updateMutex.Unlock()
+	notifier.handlers = append(notifier.handlers, handler)
+}
+
+// drain delivers the pending notifications until there are none left
+func (notifier *UpdateNotifier) drain() {
+	for {
+		notifier.updateMutex.Lock()
+		if len(notifier.pending) == 0 {
+			notifier.isDraining = false
+			notifier.updateMutex.Unlock()
+			return
+		}
+		ids := notifier.pending
+		handlers := notifier.handlers
+		notifier.pending = nil
+		notifier.updateMutex.Unlock()
+
+		for _, id := range ids {
+			for _, handler := range handlers {
+				handler(id)
+			}
+		}
+	}
+}
+
+// NewUpdateNotifier creates a notifier without subscribers
+func NewUpdateNotifier() *UpdateNotifier {
+	notifier := &UpdateNotifier{
+		handlers:    make([]func(id string), 0),
+		updateMutex: &sync.Mutex{},
+	}
+	return notifier
+}
@@ -0,0 +1,40 @@
+package lib_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateNotifier(t *testing.T) {
+	notifier := lib.NewUpdateNotifier()
+	// without subscribers notifications are dropped
+	notifier.Notify("ignored")
+
+	received := make([]string, 0)
+	mutex := &sync.Mutex{}
+	done := make(chan bool, 1)
+	notifier.Subscribe(func(id string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		received = append(received, id)
+		if len(received) == 3 {
+			done <- true
+		}
+	})
+	notifier.Notify("id1")
+	notifier.Notify("id2")
+	notifier.Notify("id3")
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Notifications not received")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{"id1", "id2", "id3"}, received)
+}
@@ -0,0 +1,51 @@
+// Package lib with tracking of updates to registered nodes, inputs and outputs for multiple consumers
+package lib
+
+import (
+	"sort"
+	"sync"
+)
+
+// UpdateTracker tracks the sequence number of the last update of each ID in a registry.
+// Consumers keep their own cursor so each sees all updates independently of the others.
+type UpdateTracker struct {
+	sequence    uint64            // sequence number of the last update
+	updatedAt   map[string]uint64 // sequence number of the last update by ID
+	updateMutex *sync.Mutex       // mutex for async updating
+}
+
+// GetUpdatedSince returns the IDs that are updated after the given cursor, in order of their last update
+//  cursor is the cursor returned by the previous call. Use 0 to get all updates.
+// This returns the updated IDs and the cursor to use for the next call.
+func (tracker *UpdateTracker) GetUpdatedSince(cursor uint64) (ids []string, newCursor uint64) {
+	tracker.updateMutex.Lock()
+	defer tracker.updateMutex.Unlock()
+
+	ids = make([]string, 0)
+	for id, sequence := range tracker.updatedAt {
+		if sequence > cursor {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return tracker.updatedAt[ids[i]] < tracker.updatedAt[ids[j]]
+	})
+	return ids, tracker.sequence
+}
+
+// Update records an update of the given ID
+func (tracker *UpdateTracker) Update(id string) {
+	tracker.updateMutex.Lock()
+	defer tracker.updateMutex.Unlock()
+	tracker.sequence++
+	tracker.updatedAt[id] = tracker.sequence
+}
+
+// NewUpdateTracker creates a tracker without updates
+func NewUpdateTracker() *UpdateTracker {
+	tracker := &UpdateTracker{
+		updatedAt:   make(map[string]uint64),
+		updateMutex: &sync.Mutex{},
+	}
+	return tracker
+}
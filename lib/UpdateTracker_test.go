@@ -0,0 +1,29 @@
+package lib_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateTracker(t *testing.T) {
+	tracker := lib.NewUpdateTracker()
+	ids, cursor1 := tracker.GetUpdatedSince(0)
+	assert.Empty(t, ids)
+
+	tracker.Update("id1")
+	tracker.Update("id2")
+	tracker.Update("id1")
+	// consumers with their own cursor each see all updates in order of last update
+	ids, cursor2 := tracker.GetUpdatedSince(cursor1)
+	assert.Equal(t, []string{"id2", "id1"}, ids)
+	ids, _ = tracker.GetUpdatedSince(cursor1)
+	assert.Equal(t, []string{"id2", "id1"}, ids)
+
+	tracker.Update("id3")
+	ids, cursor3 := tracker.GetUpdatedSince(cursor2)
+	assert.Equal(t, []string{"id3"}, ids)
+	ids, _ = tracker.GetUpdatedSince(cursor3)
+	assert.Empty(t, ids)
+}
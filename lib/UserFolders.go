@@ -0,0 +1,50 @@
+// Package lib with resolution of the default configuration and cache folders of the platform
+package lib
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// AppFolderName is the name of the application folder within the user's configuration and cache folders
+const AppFolderName = "iotdomain"
+
+// ConfigFolderEnv is the environment variable that overrides the default configuration folder
+const ConfigFolderEnv = "IOTDOMAIN_CONFIG_FOLDER"
+
+// CacheFolderEnv is the environment variable that overrides the default cache folder
+const CacheFolderEnv = "IOTDOMAIN_CACHE_FOLDER"
+
+// GetCacheFolder returns the folder for caching discovered nodes and other publishers.
+// This is $IOTDOMAIN_CACHE_FOLDER if set, otherwise the application folder in the user cache folder
+// of the platform:
+//  Linux and BSD: $XDG_CACHE_HOME/iotdomain, default ~/.cache/iotdomain
+//  macOS: ~/Library/Caches/iotdomain
+//  Windows: %LocalAppData%\iotdomain
+func GetCacheFolder() string {
+	if folder := os.Getenv(CacheFolderEnv); folder != "" {
+		return folder
+	}
+	userFolder, err := os.UserCacheDir()
+	if err != nil {
+		userFolder = filepath.Join(UserHomeDir, ".cache")
+	}
+	return filepath.Join(userFolder, AppFolderName)
+}
+
+// GetConfigFolder returns the folder for the publisher configuration files.
+// This is $IOTDOMAIN_CONFIG_FOLDER if set, otherwise the application folder in the user configuration
+// folder of the platform:
+//  Linux and BSD: $XDG_CONFIG_HOME/iotdomain, default ~/.config/iotdomain
+//  macOS: ~/Library/Application Support/iotdomain
+//  Windows: %AppData%\iotdomain
+func GetConfigFolder() string {
+	if folder := os.Getenv(ConfigFolderEnv); folder != "" {
+		return folder
+	}
+	userFolder, err := os.UserConfigDir()
+	if err != nil {
+		userFolder = filepath.Join(UserHomeDir, ".config")
+	}
+	return filepath.Join(userFolder, AppFolderName)
+}
@@ -0,0 +1,40 @@
+package lib_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserFolders(t *testing.T) {
+	defer os.Unsetenv(lib.ConfigFolderEnv)
+	defer os.Unsetenv(lib.CacheFolderEnv)
+
+	// the default folders are resolved at startup
+	assert.Equal(t, lib.AppFolderName, filepath.Base(lib.DefaultConfigFolder))
+	assert.Equal(t, lib.AppFolderName, filepath.Base(lib.DefaultCacheFolder))
+
+	// override through the environment
+	os.Setenv(lib.ConfigFolderEnv, "/opt/iotdomain/config")
+	os.Setenv(lib.CacheFolderEnv, "/var/cache/iotdomain")
+	assert.Equal(t, "/opt/iotdomain/config", lib.GetConfigFolder())
+	assert.Equal(t, "/var/cache/iotdomain", lib.GetCacheFolder())
+	os.Unsetenv(lib.ConfigFolderEnv)
+	os.Unsetenv(lib.CacheFolderEnv)
+
+	if runtime.GOOS == "linux" {
+		oldConfigHome := os.Getenv("XDG_CONFIG_HOME")
+		oldCacheHome := os.Getenv("XDG_CACHE_HOME")
+		defer os.Setenv("XDG_CONFIG_HOME", oldConfigHome)
+		defer os.Setenv("XDG_CACHE_HOME", oldCacheHome)
+
+		os.Setenv("XDG_CONFIG_HOME", "/tmp/xdgconfig")
+		os.Setenv("XDG_CACHE_HOME", "/tmp/xdgcache")
+		assert.Equal(t, "/tmp/xdgconfig/iotdomain", lib.GetConfigFolder())
+		assert.Equal(t, "/tmp/xdgcache/iotdomain", lib.GetCacheFolder())
+	}
+}
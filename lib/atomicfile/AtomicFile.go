@@ -0,0 +1,34 @@
+// Package atomicfile with writing of files that are replaced in a single step
+// This is a separate package so it can be used by the messaging package.
+package atomicfile
+
+import (
+	"os"
+)
+
+// WriteFile writes a file by writing a temporary file and renaming it.
+// A crash while writing leaves the existing file intact.
+func WriteFile(filename string, data []byte, perm os.FileMode) error {
+	tmpFilename := filename + ".tmp"
+	// a leftover temporary file can be read-only
+	os.Remove(tmpFilename)
+	tmpFile, err := os.OpenFile(tmpFilename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(data)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFilename, filename)
+	}
+	if err != nil {
+		os.Remove(tmpFilename)
+	}
+	return err
+}
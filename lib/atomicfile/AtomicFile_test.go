@@ -0,0 +1,32 @@
+package atomicfile_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/lib/atomicfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFile(t *testing.T) {
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	filename := filepath.Join(tempFolder, "sequences.json")
+
+	require.NoError(t, atomicfile.WriteFile(filename, []byte("first"), 0664))
+	// a leftover temporary file of a crashed write doesn't block the next write
+	require.NoError(t, ioutil.WriteFile(filename+".tmp", []byte("partial"), 0400))
+	require.NoError(t, atomicfile.WriteFile(filename, []byte("second"), 0664))
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(data))
+	_, err = os.Stat(filename + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	// error case - the folder must exist
+	err = atomicfile.WriteFile(filepath.Join(tempFolder, "missing", "file.json"), []byte("third"), 0664)
+	assert.Error(t, err)
+}
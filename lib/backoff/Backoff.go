@@ -0,0 +1,79 @@
+// Package backoff with exponential backoff and retry of failing operations
+// This is a separate package so it can be used by the messaging package.
+package backoff
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Default backoff settings
+const (
+	DefaultBackoffJitter     = 0.2 // randomize delays by +/- 20%
+	DefaultBackoffMultiplier = 2   // double the delay after each attempt
+)
+
+// Backoff policy for retrying failed operations with exponentially increasing delays.
+// The delays are randomized with jitter to avoid clients retrying in lockstep after an outage.
+type Backoff struct {
+	InitialDelay time.Duration // delay after the first failed attempt
+	Jitter       float64       // fraction of the delay to randomize, 0-1. Use 0 for no jitter
+	MaxAttempts  int           // max nr of attempts. Use 0 to retry until the context is done
+	MaxDelay     time.Duration // max delay between attempts
+	Multiplier   float64       // increase of the delay after each attempt
+}
+
+// Delay returns the delay after a failed attempt, including jitter
+//  attempt is the nr of the failed attempt, starting at 1
+func (backoff *Backoff) Delay(attempt int) time.Duration {
+	delay := float64(backoff.InitialDelay)
+	for i := 1; i < attempt && delay < float64(backoff.MaxDelay); i++ {
+		delay *= backoff.Multiplier
+	}
+	if delay > float64(backoff.MaxDelay) {
+		delay = float64(backoff.MaxDelay)
+	}
+	if backoff.Jitter > 0 {
+		delay += delay * backoff.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+// Retry runs the operation until it succeeds, the max nr of attempts is reached or the context is done
+//  ctx cancels the retries. Use context.Background() to retry until the max attempts
+//  operation is invoked with the attempt nr, starting at 1, and returns nil on success
+// This returns nil on success, the error of the last attempt, or the context error if the context
+// was done before the operation succeeded.
+func (backoff *Backoff) Retry(ctx context.Context, operation func(attempt int) error) error {
+	for attempt := 1; ; attempt++ {
+		err := operation(attempt)
+		if err == nil {
+			return nil
+		} else if backoff.MaxAttempts > 0 && attempt >= backoff.MaxAttempts {
+			return err
+		}
+		timer := time.NewTimer(backoff.Delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// NewBackoff creates a backoff policy with the default multiplier and jitter
+//  initialDelay is the delay after the first failed attempt
+//  maxDelay is the max delay between attempts
+//  maxAttempts is the max nr of attempts. Use 0 to retry until the context is done
+func NewBackoff(initialDelay time.Duration, maxDelay time.Duration, maxAttempts int) *Backoff {
+	backoff := &Backoff{
+		InitialDelay: initialDelay,
+		Jitter:       DefaultBackoffJitter,
+		MaxAttempts:  maxAttempts,
+		MaxDelay:     maxDelay,
+		Multiplier:   DefaultBackoffMultiplier,
+	}
+	return backoff
+}
@@ -0,0 +1,56 @@
+package backoff_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib/backoff"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	policy := backoff.NewBackoff(time.Second, 5*time.Second, 0)
+	policy.Jitter = 0
+	assert.Equal(t, time.Second, policy.Delay(1))
+	assert.Equal(t, 2*time.Second, policy.Delay(2))
+	assert.Equal(t, 4*time.Second, policy.Delay(3))
+	assert.Equal(t, 5*time.Second, policy.Delay(4))
+	assert.Equal(t, 5*time.Second, policy.Delay(100))
+
+	// jitter stays within its fraction of the delay
+	policy.Jitter = 0.5
+	delay := policy.Delay(2)
+	assert.True(t, delay >= time.Second && delay <= 3*time.Second)
+}
+
+func TestBackoffRetry(t *testing.T) {
+	policy := backoff.NewBackoff(time.Millisecond, 10*time.Millisecond, 3)
+	attempts := 0
+	err := policy.Retry(context.Background(), func(attempt int) error {
+		attempts = attempt
+		return errors.New("failed")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+
+	err = policy.Retry(context.Background(), func(attempt int) error {
+		attempts = attempt
+		if attempt < 2 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	// a cancelled context stops retrying
+	policy = backoff.NewBackoff(time.Hour, time.Hour, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = policy.Retry(ctx, func(attempt int) error {
+		return errors.New("failed")
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
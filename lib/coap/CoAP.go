@@ -0,0 +1,65 @@
+// Package coap with decoding of CoAP message options (RFC 7252)
+// This is a separate package so it can be used by the adapters that receive CoAP messages.
+package coap
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// payloadMarker separates the options from the payload
+const payloadMarker = 0xff
+
+// Option is an option of a CoAP message
+type Option struct {
+	Number int
+	Value  []byte
+}
+
+// ParseOptions returns the options and payload of a CoAP message. The values are copies of the data.
+//  position is the position of the first option, after the header and token
+func ParseOptions(data []byte, position int) (options []Option, payload []byte, err error) {
+	options = make([]Option, 0)
+	number := 0
+	for position < len(data) {
+		if data[position] == payloadMarker {
+			payload = append([]byte{}, data[position+1:]...)
+			break
+		}
+		delta := int(data[position] >> 4)
+		length := int(data[position] & 0x0f)
+		position++
+		delta, position = decodeNibble(data, delta, position)
+		length, position = decodeNibble(data, length, position)
+		if delta < 0 || length < 0 || position+length > len(data) {
+			return nil, nil, fmt.Errorf("Invalid option after option %d", number)
+		}
+		number += delta
+		options = append(options, Option{
+			Number: number,
+			Value:  append([]byte{}, data[position:position+length]...),
+		})
+		position += length
+	}
+	return options, payload, nil
+}
+
+// decodeNibble returns the value of an option delta or length nibble and the position after its
+// extended bytes. This returns -1 if the nibble is invalid.
+func decodeNibble(data []byte, nibble int, position int) (int, int) {
+	switch nibble {
+	case 13:
+		if position >= len(data) {
+			return -1, position
+		}
+		return 13 + int(data[position]), position + 1
+	case 14:
+		if position+1 >= len(data) {
+			return -1, position
+		}
+		return 269 + int(binary.BigEndian.Uint16(data[position:])), position + 2
+	case 15:
+		return -1, position
+	}
+	return nibble, position
+}
@@ -0,0 +1,36 @@
+package coap_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/lib/coap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOptions(t *testing.T) {
+	// header and 1 byte token, option 11 'rd', option 3332 with 13+2 bytes extended delta, payload
+	data := []byte{0x41, 0x02, 0x00, 0x01, 0xaa, 0xb2, 'r', 'd', 0xe3, 0x0b, 0xec, 'a', 'b', 'c', 0xff, 'h', 'i'}
+	options, payload, err := coap.ParseOptions(data, 5)
+	require.NoError(t, err)
+	require.Len(t, options, 2)
+	assert.Equal(t, 11, options[0].Number)
+	assert.Equal(t, "rd", string(options[0].Value))
+	assert.Equal(t, 3332, options[1].Number)
+	assert.Equal(t, "abc", string(options[1].Value))
+	assert.Equal(t, "hi", string(payload))
+
+	// no payload
+	options, payload, err = coap.ParseOptions(data[:8], 5)
+	require.NoError(t, err)
+	assert.Len(t, options, 1)
+	assert.Nil(t, payload)
+
+	// error cases - truncated extended delta and option value
+	_, _, err = coap.ParseOptions(data[:10], 5)
+	assert.Error(t, err)
+	_, _, err = coap.ParseOptions(data[:12], 5)
+	assert.Error(t, err)
+	_, _, err = coap.ParseOptions([]byte{0xf0}, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,264 @@
+// Package loadtest with load generation on a publisher and measurement of the publication latency
+package loadtest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Defaults of the load configuration
+const (
+	DefaultLoadDuration = 60  // seconds
+	DefaultLoadNodes    = 10  // nr of nodes
+	DefaultLoadOutputs  = 10  // nr of outputs per node
+	DefaultLoadRate     = 100 // updates per second
+)
+
+// DrainTimeout is the max time to wait for outstanding publications after the load is generated
+const DrainTimeout = 5 * time.Second
+
+// LoadNodePrefix is the prefix of the hardware IDs of the generated nodes
+const LoadNodePrefix = "load-"
+
+// LoadConfig with the load to generate
+type LoadConfig struct {
+	Duration int     `yaml:"duration,omitempty"` // seconds to generate load. Default is 60
+	Nodes    int     `yaml:"nodes,omitempty"`    // nr of generated nodes. Default is 10
+	Outputs  int     `yaml:"outputs,omitempty"`  // nr of outputs per node. Default is 10
+	Rate     float64 `yaml:"rate,omitempty"`     // total nr of output value updates per second. Default is 100
+}
+
+// LoadReport with the results of a load run
+// The latency is measured from UpdateOutputValue until the publication is delivered back by the message
+// bus, which includes the publish budget and heartbeat delay, signing, the broker and verification.
+type LoadReport struct {
+	Duration   time.Duration // duration of the load generation
+	LatencyAvg time.Duration // average latency of the received updates
+	LatencyMax time.Duration // max latency of the received updates
+	LatencyMin time.Duration // min latency of the received updates
+	LatencyP50 time.Duration // median latency
+	LatencyP95 time.Duration // 95th percentile latency
+	LatencyP99 time.Duration // 99th percentile latency
+	Missing    int           // updates that weren't received by the end of the run
+	Received   int           // updates received back from the message bus
+	Sent       int           // updates passed to UpdateOutputValue
+	Superseded int           // updates replaced by a newer value of the output before they were published
+	Throughput float64       // received updates per second of the run
+}
+
+// String returns a human readable summary of the report
+func (report *LoadReport) String() string {
+	return fmt.Sprintf("sent=%d received=%d superseded=%d missing=%d throughput=%.1f/s "+
+		"latency min=%s avg=%s p50=%s p95=%s p99=%s max=%s",
+		report.Sent, report.Received, report.Superseded, report.Missing, report.Throughput,
+		report.LatencyMin, report.LatencyAvg, report.LatencyP50, report.LatencyP95, report.LatencyP99,
+		report.LatencyMax)
+}
+
+// sentValue is an update that hasn't been received yet
+type sentValue struct {
+	sent     time.Time // time of UpdateOutputValue
+	sequence int       // the value of the update
+}
+
+// LoadGenerator updates the output values of generated nodes at a configured rate and measures the
+// latency until the publisher's own publications are received
+type LoadGenerator struct {
+	config      LoadConfig
+	latencies   []time.Duration                 // latency of the received updates
+	outputs     []*types.OutputDiscoveryMessage // the generated outputs
+	pending     map[string][]sentValue          // updates that haven't been received by $latest address
+	pub         *publisher.Publisher            // publisher to generate load on
+	sequence    int                             // sequence nr of the last update, used as the value
+	superseded  int                             // nr of updates replaced before they were published
+	updateMutex *sync.Mutex                     // mutex for async receiving of publications
+}
+
+// CreateNodes creates the generated nodes and their outputs if they don't exist
+func (gen *LoadGenerator) CreateNodes() {
+	gen.updateMutex.Lock()
+	defer gen.updateMutex.Unlock()
+	if len(gen.outputs) > 0 {
+		return
+	}
+	for nodeIndex := 1; nodeIndex <= gen.config.Nodes; nodeIndex++ {
+		nodeHWID := LoadNodePrefix + strconv.Itoa(nodeIndex)
+		gen.pub.CreateNode(nodeHWID, types.NodeTypeSensor)
+		for outputIndex := 1; outputIndex <= gen.config.Outputs; outputIndex++ {
+			output := gen.pub.CreateOutput(nodeHWID, types.OutputTypeValue, strconv.Itoa(outputIndex))
+			gen.outputs = append(gen.outputs, output)
+		}
+	}
+}
+
+// Run generates load for the configured duration and returns the report
+// See RunFor for details.
+func (gen *LoadGenerator) Run() *LoadReport {
+	return gen.RunFor(time.Duration(gen.config.Duration) * time.Second)
+}
+
+// RunFor generates load for the given duration and returns the report. The publisher must be started.
+// After the load is generated this waits up to DrainTimeout for the outstanding publications. The domain
+// output value handler of the publisher is replaced during the run.
+func (gen *LoadGenerator) RunFor(duration time.Duration) *LoadReport {
+	gen.CreateNodes()
+	gen.updateMutex.Lock()
+	gen.latencies = make([]time.Duration, 0)
+	gen.pending = make(map[string][]sentValue)
+	gen.superseded = 0
+	gen.updateMutex.Unlock()
+
+	gen.pub.SetOnDomainOutputValue(gen.handleLatest)
+	gen.pub.Subscribe(gen.pub.Domain(), gen.pub.PublisherID())
+	defer gen.pub.SetOnDomainOutputValue(nil)
+	defer gen.pub.Unsubscribe(gen.pub.Domain(), gen.pub.PublisherID())
+
+	// send the updates that are due every tick, as tickers can't keep up with high rates
+	sent := 0
+	start := time.Now()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	for now := range ticker.C {
+		elapsed := now.Sub(start)
+		if elapsed > duration {
+			elapsed = duration
+		}
+		due := int(elapsed.Seconds() * gen.config.Rate)
+		for ; sent < due; sent++ {
+			gen.updateOutput(gen.outputs[sent%len(gen.outputs)])
+		}
+		if elapsed >= duration {
+			break
+		}
+	}
+	ticker.Stop()
+	runDuration := time.Since(start)
+
+	// wait for the outstanding publications
+	for deadline := time.Now().Add(DrainTimeout); time.Now().Before(deadline); {
+		if gen.pendingCount() == 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	report := gen.makeReport(sent)
+	if report.Received > 0 {
+		report.Throughput = float64(report.Received) / runDuration.Seconds()
+	}
+	report.Duration = runDuration
+	logrus.Infof("LoadGenerator.RunFor: %s", report)
+	return report
+}
+
+// handleLatest measures the latency of a received output value
+// Outstanding updates of the output with an older value were superseded before they were published.
+func (gen *LoadGenerator) handleLatest(latest *types.OutputLatestMessage) {
+	sequence, err := strconv.Atoi(latest.Value)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	gen.updateMutex.Lock()
+	defer gen.updateMutex.Unlock()
+	values := gen.pending[latest.Address]
+	for len(values) > 0 && values[0].sequence <= sequence {
+		if values[0].sequence == sequence {
+			gen.latencies = append(gen.latencies, now.Sub(values[0].sent))
+		} else {
+			gen.superseded++
+		}
+		values = values[1:]
+	}
+	gen.pending[latest.Address] = values
+}
+
+// makeReport returns the report of the received updates
+func (gen *LoadGenerator) makeReport(sent int) *LoadReport {
+	gen.updateMutex.Lock()
+	defer gen.updateMutex.Unlock()
+	report := &LoadReport{
+		Received:   len(gen.latencies),
+		Sent:       sent,
+		Superseded: gen.superseded,
+	}
+	for _, values := range gen.pending {
+		report.Missing += len(values)
+	}
+	if len(gen.latencies) == 0 {
+		return report
+	}
+	latencies := append([]time.Duration{}, gen.latencies...)
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	total := time.Duration(0)
+	for _, latency := range latencies {
+		total += latency
+	}
+	percentile := func(fraction float64) time.Duration {
+		return latencies[int(fraction*float64(len(latencies)-1))]
+	}
+	report.LatencyAvg = total / time.Duration(len(latencies))
+	report.LatencyMax = latencies[len(latencies)-1]
+	report.LatencyMin = latencies[0]
+	report.LatencyP50 = percentile(0.50)
+	report.LatencyP95 = percentile(0.95)
+	report.LatencyP99 = percentile(0.99)
+	return report
+}
+
+// pendingCount returns the nr of updates that haven't been received
+func (gen *LoadGenerator) pendingCount() int {
+	gen.updateMutex.Lock()
+	defer gen.updateMutex.Unlock()
+	count := 0
+	for _, values := range gen.pending {
+		count += len(values)
+	}
+	return count
+}
+
+// updateOutput updates an output with the next sequence nr as the value
+func (gen *LoadGenerator) updateOutput(output *types.OutputDiscoveryMessage) {
+	gen.updateMutex.Lock()
+	gen.sequence++
+	sequence := gen.sequence
+	latestAddress := outputs.ReplaceMessageType(output.Address, types.MessageTypeLatest)
+	gen.pending[latestAddress] = append(gen.pending[latestAddress], sentValue{sent: time.Now(), sequence: sequence})
+	gen.updateMutex.Unlock()
+	gen.pub.UpdateOutputValue(output.NodeHWID, output.OutputType, output.Instance, strconv.Itoa(sequence))
+}
+
+// NewLoadGenerator creates a load generator for a publisher
+//  pub is the publisher to generate load on
+//  config with the load to generate. Use nil for defaults.
+func NewLoadGenerator(pub *publisher.Publisher, config *LoadConfig) *LoadGenerator {
+	gen := &LoadGenerator{
+		pending:     make(map[string][]sentValue),
+		pub:         pub,
+		updateMutex: &sync.Mutex{},
+	}
+	if config != nil {
+		gen.config = *config
+	}
+	if gen.config.Duration <= 0 {
+		gen.config.Duration = DefaultLoadDuration
+	}
+	if gen.config.Nodes <= 0 {
+		gen.config.Nodes = DefaultLoadNodes
+	}
+	if gen.config.Outputs <= 0 {
+		gen.config.Outputs = DefaultLoadOutputs
+	}
+	if gen.config.Rate <= 0 {
+		gen.config.Rate = DefaultLoadRate
+	}
+	return gen
+}
@@ -0,0 +1,98 @@
+package loadtest_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/loadtest"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/publisher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPublisher(t *testing.T) (*publisher.Publisher, string) {
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	config := &publisher.PublisherConfig{
+		ConfigFolder: tempFolder,
+		CacheFolder:  tempFolder,
+		Domain:       "test",
+		PublisherID:  "load1",
+	}
+	pub := publisher.NewPublisher(config, messaging.NewDummyMessenger(&messaging.MessengerConfig{}))
+	require.NotNil(t, pub)
+	pub.Start()
+	return pub, tempFolder
+}
+
+func TestLoadGenerator(t *testing.T) {
+	pub, tempFolder := newTestPublisher(t)
+	defer os.RemoveAll(tempFolder)
+	defer pub.Stop()
+
+	gen := loadtest.NewLoadGenerator(pub, &loadtest.LoadConfig{Nodes: 2, Outputs: 3, Rate: 200})
+	report := gen.RunFor(500 * time.Millisecond)
+	require.NotNil(t, report)
+	assert.InDelta(t, 100, report.Sent, 5)
+	assert.Equal(t, 0, report.Missing)
+	assert.Equal(t, report.Sent, report.Received+report.Superseded)
+	assert.True(t, report.Received >= 6, "expected at least the last value of each output")
+	assert.True(t, report.LatencyMin <= report.LatencyP50)
+	assert.True(t, report.LatencyP50 <= report.LatencyP99)
+	assert.True(t, report.LatencyP99 <= report.LatencyMax)
+	assert.True(t, report.Throughput > 0)
+	assert.NotEmpty(t, report.String())
+	assert.Len(t, pub.GetNodes(), 2)
+}
+
+func TestSoakHarness(t *testing.T) {
+	pub, tempFolder := newTestPublisher(t)
+	defer os.RemoveAll(tempFolder)
+	defer pub.Stop()
+
+	gen := loadtest.NewLoadGenerator(pub, &loadtest.LoadConfig{Nodes: 1, Outputs: 2, Rate: 50})
+	soak := loadtest.NewSoakHarness(gen, &loadtest.SoakConfig{Duration: 3, SampleInterval: 1})
+	report := soak.Run()
+	require.NotNil(t, report)
+	assert.True(t, len(report.Samples) >= 2)
+	assert.NotNil(t, report.Samples[0].Load)
+	assert.True(t, report.Samples[0].Goroutines > 0)
+}
+
+func TestEvaluateSoakSamples(t *testing.T) {
+	config := &loadtest.SoakConfig{MaxGoroutineGrowth: 10, MaxHeapGrowth: 50}
+	stable := []loadtest.SoakSample{
+		{Goroutines: 20, HeapAlloc: 1000},
+		{Goroutines: 22, HeapAlloc: 1400},
+		{Goroutines: 21, HeapAlloc: 1900}, // gc noise
+		{Goroutines: 20, HeapAlloc: 1100},
+		{Goroutines: 21, HeapAlloc: 1200},
+	}
+	report := loadtest.EvaluateSoakSamples(stable, config)
+	assert.False(t, report.Leaking)
+	assert.Equal(t, 0, report.GoroutineGrowth)
+
+	goroutineLeak := []loadtest.SoakSample{
+		{Goroutines: 20, HeapAlloc: 1000},
+		{Goroutines: 30, HeapAlloc: 1000},
+		{Goroutines: 40, HeapAlloc: 1000},
+	}
+	report = loadtest.EvaluateSoakSamples(goroutineLeak, config)
+	assert.True(t, report.Leaking)
+	assert.Equal(t, 20, report.GoroutineGrowth)
+
+	heapLeak := []loadtest.SoakSample{
+		{Goroutines: 20, HeapAlloc: 1000},
+		{Goroutines: 20, HeapAlloc: 2000},
+		{Goroutines: 20, HeapAlloc: 3000},
+	}
+	report = loadtest.EvaluateSoakSamples(heapLeak, config)
+	assert.True(t, report.Leaking)
+	assert.InDelta(t, 200, report.HeapGrowth, 0.1)
+
+	// a single sample has no growth
+	report = loadtest.EvaluateSoakSamples(heapLeak[:1], config)
+	assert.False(t, report.Leaking)
+}
@@ -0,0 +1,131 @@
+// Package loadtest with a soak test harness for detecting goroutine and memory leaks under load
+package loadtest
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Defaults of the soak test configuration
+const (
+	DefaultSoakDuration       = 3600 // seconds
+	DefaultMaxGoroutineGrowth = 10   // goroutines
+	DefaultMaxHeapGrowth      = 50   // percent
+	DefaultSampleInterval     = 60   // seconds
+)
+
+// SoakConfig with the soak test duration and leak thresholds
+type SoakConfig struct {
+	Duration           int     `yaml:"duration,omitempty"`           // seconds to run the soak test. Default is 3600
+	MaxGoroutineGrowth int     `yaml:"maxGoroutineGrowth,omitempty"` // max increase in goroutines. Default is 10
+	MaxHeapGrowth      float64 `yaml:"maxHeapGrowth,omitempty"`      // max increase of the heap in percent. Default is 50
+	SampleInterval     int     `yaml:"sampleInterval,omitempty"`     // seconds of load between samples. Default is 60
+}
+
+// SoakSample with the resource usage after an interval of load
+type SoakSample struct {
+	Goroutines int         // nr of goroutines
+	HeapAlloc  uint64      // bytes of allocated heap objects after garbage collection
+	Load       *LoadReport // report of the load run of the interval
+	Time       time.Time   // time the sample was taken
+}
+
+// SoakReport with the result of a soak test
+type SoakReport struct {
+	GoroutineGrowth int          // increase of goroutines since the first sample
+	HeapGrowth      float64      // increase of the heap since the first sample, in percent
+	Leaking         bool         // goroutine or heap growth exceeds the thresholds
+	Samples         []SoakSample // samples taken during the test
+}
+
+// SoakHarness runs a load generator for hours and samples the goroutines and heap to detect leaks
+type SoakHarness struct {
+	config    SoakConfig
+	generator *LoadGenerator
+}
+
+// Run generates load for the configured duration, sampling the resource usage after each interval
+func (soak *SoakHarness) Run() *SoakReport {
+	samples := make([]SoakSample, 0)
+	interval := time.Duration(soak.config.SampleInterval) * time.Second
+	end := time.Now().Add(time.Duration(soak.config.Duration) * time.Second)
+	for len(samples) == 0 || time.Now().Before(end) {
+		load := soak.generator.RunFor(interval)
+		sample := TakeSoakSample()
+		sample.Load = load
+		samples = append(samples, sample)
+		logrus.Infof("SoakHarness.Run: sample %d: goroutines=%d heap=%d bytes",
+			len(samples), sample.Goroutines, sample.HeapAlloc)
+	}
+	report := EvaluateSoakSamples(samples, &soak.config)
+	if report.Leaking {
+		logrus.Warningf("SoakHarness.Run: Leak detected. Goroutine growth %d, heap growth %.1f%%",
+			report.GoroutineGrowth, report.HeapGrowth)
+	}
+	return report
+}
+
+// EvaluateSoakSamples compares the samples against the leak thresholds
+// The first sample is the baseline, as it is taken after the publisher has warmed up. Growth is
+// measured against the minimum of the last quarter of samples to ignore garbage collection noise.
+func EvaluateSoakSamples(samples []SoakSample, config *SoakConfig) *SoakReport {
+	report := &SoakReport{Samples: samples}
+	if len(samples) < 2 {
+		return report
+	}
+	baseline := samples[0]
+	tail := samples[len(samples)-1-(len(samples)-1)/4:]
+	minGoroutines := tail[0].Goroutines
+	minHeap := tail[0].HeapAlloc
+	for _, sample := range tail {
+		if sample.Goroutines < minGoroutines {
+			minGoroutines = sample.Goroutines
+		}
+		if sample.HeapAlloc < minHeap {
+			minHeap = sample.HeapAlloc
+		}
+	}
+	report.GoroutineGrowth = minGoroutines - baseline.Goroutines
+	if baseline.HeapAlloc > 0 {
+		report.HeapGrowth = (float64(minHeap) - float64(baseline.HeapAlloc)) * 100 / float64(baseline.HeapAlloc)
+	}
+	report.Leaking = report.GoroutineGrowth > config.MaxGoroutineGrowth || report.HeapGrowth > config.MaxHeapGrowth
+	return report
+}
+
+// TakeSoakSample collects garbage and returns the current goroutine and heap usage
+func TakeSoakSample() SoakSample {
+	runtime.GC()
+	memStats := runtime.MemStats{}
+	runtime.ReadMemStats(&memStats)
+	return SoakSample{
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  memStats.HeapAlloc,
+		Time:       time.Now(),
+	}
+}
+
+// NewSoakHarness creates a soak test harness for a load generator
+//  generator generates the load between samples
+//  config with the duration and thresholds. Use nil for defaults.
+func NewSoakHarness(generator *LoadGenerator, config *SoakConfig) *SoakHarness {
+	soak := &SoakHarness{generator: generator}
+	if config != nil {
+		soak.config = *config
+	}
+	if soak.config.Duration <= 0 {
+		soak.config.Duration = DefaultSoakDuration
+	}
+	if soak.config.MaxGoroutineGrowth <= 0 {
+		soak.config.MaxGoroutineGrowth = DefaultMaxGoroutineGrowth
+	}
+	if soak.config.MaxHeapGrowth <= 0 {
+		soak.config.MaxHeapGrowth = DefaultMaxHeapGrowth
+	}
+	if soak.config.SampleInterval <= 0 {
+		soak.config.SampleInterval = DefaultSampleInterval
+	}
+	return soak
+}
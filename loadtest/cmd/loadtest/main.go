@@ -0,0 +1,78 @@
+// Package main with the load test and soak test of a publisher on the configured message bus
+//  usage: loadtest [-soak] [-profile] [configFolder]
+// The load and soak settings are read from the 'load' and 'soak' sections of loadtest.yaml in the
+// config folder. The default config folder is lib.DefaultConfigFolder.
+// With -profile a CPU profile of the run and a heap profile at the end are written to the profile
+// folder of the publisher, for profile-guided tuning of eg the publish budget.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/iotdomain/iotdomain-go/loadtest"
+	"github.com/iotdomain/iotdomain-go/publisher"
+)
+
+// AppID is the publisher ID and the name of the configuration file
+const AppID = "loadtest"
+
+// AppConfig with the load test configuration in loadtest.yaml
+type AppConfig struct {
+	Load loadtest.LoadConfig `yaml:"load"`
+	Soak loadtest.SoakConfig `yaml:"soak"`
+}
+
+func main() {
+	soak := flag.Bool("soak", false, "run the soak test to detect goroutine and memory leaks")
+	profile := flag.Bool("profile", false, "capture a CPU profile of the run and a heap profile at the end")
+	flag.Parse()
+	appConfig := &AppConfig{}
+	pub, err := publisher.NewAppPublisher(AppID, flag.Arg(0), appConfig, "", false)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	pub.Start()
+	defer pub.Stop()
+
+	if *profile {
+		_, err = pub.StartCPUProfile()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer printProfiles(pub)
+	}
+
+	generator := loadtest.NewLoadGenerator(pub, &appConfig.Load)
+	if !*soak {
+		fmt.Println(generator.Run())
+		return
+	}
+	report := loadtest.NewSoakHarness(generator, &appConfig.Soak).Run()
+	for index, sample := range report.Samples {
+		fmt.Printf("%d: goroutines=%d heap=%d %s\n", index, sample.Goroutines, sample.HeapAlloc, sample.Load)
+	}
+	fmt.Printf("goroutine growth=%d heap growth=%.1f%% leaking=%v\n",
+		report.GoroutineGrowth, report.HeapGrowth, report.Leaking)
+	if report.Leaking {
+		if *profile {
+			printProfiles(pub)
+		}
+		pub.Stop()
+		os.Exit(1)
+	}
+}
+
+// printProfiles stops the CPU profile, captures the heap profile and prints their filenames
+func printProfiles(pub *publisher.Publisher) {
+	fmt.Printf("cpu profile=%s\n", pub.StopCPUProfile())
+	heapFile, err := pub.CaptureHeapProfile()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("heap profile=%s\n", heapFile)
+}
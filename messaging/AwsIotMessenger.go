@@ -0,0 +1,187 @@
+// Package messaging - Publish and Subscribe to messages using the AWS IoT Core message broker
+package messaging
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AWS IoT Core topic constraints
+const (
+	AwsIotMaxTopicLength  = 256 // max nr of bytes in a topic
+	AwsIotMaxTopicSlashes = 7   // max nr of forward slashes in a topic
+)
+
+// AwsIotConfig with the AWS IoT Core specific connection settings
+// The messenger config Server holds the account endpoint, eg xyz-ats.iot.us-east-1.amazonaws.com,
+// and the ClientID holds the thing name.
+type AwsIotConfig struct {
+	CACertFile     string `yaml:"caCertFile,omitempty"`  // Amazon root CA in PEM format. Default is the system CA pool
+	ClientCertFile string `yaml:"clientCertFile"`        // thing certificate in PEM format
+	ClientKeyFile  string `yaml:"clientKeyFile"`         // thing private key in PEM format
+	TopicPrefix    string `yaml:"topicPrefix,omitempty"` // prefix that the thing policy allows, eg "iotdomain/"
+}
+
+// AwsIotMessenger that implements IMessenger for AWS IoT Core using MQTT with mutual TLS.
+// Addresses are prefixed with the configured topic prefix and must meet the AWS topic constraints.
+type AwsIotMessenger struct {
+	awsConfig     *AwsIotConfig
+	mqtt          *MqttMessenger                                          // MQTT connection to AWS IoT Core
+	subscriptions map[string][]func(address string, message string) error // handlers by subscription address
+	updateMutex   *sync.Mutex                                             // mutex for async (un)subscribing
+}
+
+// AddressFromTopic returns the address of a topic by removing the topic prefix
+func (messenger *AwsIotMessenger) AddressFromTopic(topic string) string {
+	return strings.TrimPrefix(topic, messenger.awsConfig.TopicPrefix)
+}
+
+// Capabilities of AWS IoT Core. Retained messages aren't supported.
+func (messenger *AwsIotMessenger) Capabilities() MessengerCapabilities {
+	return MessengerCapabilities{Retained: false}
+}
+
+// Connect to AWS IoT Core using the thing certificate
+func (messenger *AwsIotMessenger) Connect(lastWillAddress string, lastWillValue string) error {
+	cert, err := tls.LoadX509KeyPair(messenger.awsConfig.ClientCertFile, messenger.awsConfig.ClientKeyFile)
+	if err != nil {
+		return fmt.Errorf("AwsIotMessenger.Connect: Unable to load the thing certificate: %s", err)
+	}
+	messenger.mqtt.tlsClientCerts = []tls.Certificate{cert}
+	lastWillTopic := ""
+	if lastWillAddress != "" {
+		lastWillTopic, err = messenger.TopicFromAddress(lastWillAddress)
+		if err != nil {
+			return err
+		}
+	}
+	return messenger.mqtt.Connect(lastWillTopic, lastWillValue)
+}
+
+// Disconnect from AWS IoT Core
+func (messenger *AwsIotMessenger) Disconnect() {
+	messenger.mqtt.Disconnect()
+}
+
+// GetConnectionStatus returns the status of the connection to AWS IoT Core
+func (messenger *AwsIotMessenger) GetConnectionStatus() ConnectionStatus {
+	return messenger.mqtt.GetConnectionStatus()
+}
+
+// Publish a message on the prefixed address
+func (messenger *AwsIotMessenger) Publish(address string, retained bool, message string) error {
+	topic, err := messenger.TopicFromAddress(address)
+	if err != nil {
+		return err
+	}
+	return messenger.mqtt.Publish(topic, retained, message)
+}
+
+// Subscribe to the prefixed address. The handler receives the address without prefix.
+// The topic is subscribed once and received messages are passed to all handlers of the address.
+func (messenger *AwsIotMessenger) Subscribe(
+	address string, onMessage func(address string, message string) error) {
+
+	topic, err := messenger.TopicFromAddress(address)
+	if err != nil {
+		logrus.Errorf("AwsIotMessenger.Subscribe: %s", err)
+		return
+	}
+	messenger.updateMutex.Lock()
+	handlers := messenger.subscriptions[address]
+	isNew := len(handlers) == 0
+	// copy on write as dispatch uses the list outside the lock
+	newHandlers := make([]func(address string, message string) error, 0, len(handlers)+1)
+	newHandlers = append(newHandlers, handlers...)
+	messenger.subscriptions[address] = append(newHandlers, onMessage)
+	messenger.updateMutex.Unlock()
+
+	if isNew {
+		messenger.mqtt.Subscribe(topic, func(rxTopic string, message string) error {
+			return messenger.dispatch(address, messenger.AddressFromTopic(rxTopic), message)
+		})
+	}
+}
+
+// TopicFromAddress returns the AWS topic of an address by adding the topic prefix
+// This fails if the topic doesn't meet the AWS IoT Core topic constraints.
+func (messenger *AwsIotMessenger) TopicFromAddress(address string) (string, error) {
+	topic := messenger.awsConfig.TopicPrefix + address
+	if len(topic) > AwsIotMaxTopicLength {
+		return topic, fmt.Errorf("TopicFromAddress: Topic '%s' exceeds %d bytes", topic, AwsIotMaxTopicLength)
+	} else if strings.Count(topic, "/") > AwsIotMaxTopicSlashes {
+		return topic, fmt.Errorf("TopicFromAddress: Topic '%s' has more than %d levels", topic, AwsIotMaxTopicSlashes+1)
+	} else if strings.HasPrefix(topic, "$") {
+		return topic, fmt.Errorf("TopicFromAddress: Topic '%s' is reserved", topic)
+	}
+	return topic, nil
+}
+
+// Unsubscribe the handler from the prefixed address. The topic is unsubscribed when no handlers remain.
+// If onMessage is nil then all handlers of the address are removed.
+func (messenger *AwsIotMessenger) Unsubscribe(
+	address string, onMessage func(address string, message string) error) {
+
+	messenger.updateMutex.Lock()
+	handlers := messenger.subscriptions[address]
+	remaining := make([]func(address string, message string) error, 0, len(handlers))
+	isRemoved := false
+	for _, handler := range handlers {
+		if (onMessage == nil || !isRemoved) && isSameHandler(onMessage, handler) {
+			isRemoved = true
+			continue
+		}
+		remaining = append(remaining, handler)
+	}
+	isLast := len(handlers) > 0 && len(remaining) == 0
+	if len(remaining) == 0 {
+		delete(messenger.subscriptions, address)
+	} else {
+		messenger.subscriptions[address] = remaining
+	}
+	messenger.updateMutex.Unlock()
+
+	if isLast {
+		topic, _ := messenger.TopicFromAddress(address)
+		messenger.mqtt.Unsubscribe(topic, nil)
+	}
+}
+
+// dispatch passes a received message to the handlers of the subscription address
+func (messenger *AwsIotMessenger) dispatch(subscription string, address string, message string) error {
+	messenger.updateMutex.Lock()
+	handlers := messenger.subscriptions[subscription]
+	messenger.updateMutex.Unlock()
+
+	var firstErr error
+	for _, handler := range handlers {
+		err := handler(address, message)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// NewAwsIotMessenger creates a messenger for AWS IoT Core
+//  config holds the account endpoint as server and the thing name as client ID
+//  awsConfig holds the thing certificate and topic prefix
+func NewAwsIotMessenger(config *MessengerConfig, awsConfig *AwsIotConfig) *AwsIotMessenger {
+	mqtt := NewMqttMessenger(config)
+	mqtt.tlsCACertFile = awsConfig.CACertFile
+	mqtt.tlsVerifyServerCert = true
+	// the ping address isn't mapped to the topics the cloud broker allows
+	mqtt.pingInterval = 0
+
+	messenger := &AwsIotMessenger{
+		awsConfig:     awsConfig,
+		mqtt:          mqtt,
+		subscriptions: make(map[string][]func(address string, message string) error),
+		updateMutex:   &sync.Mutex{},
+	}
+	return messenger
+}
@@ -0,0 +1,287 @@
+// Package messaging - Publish and Subscribe to messages using the Azure IoT Hub MQTT interface
+package messaging
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// AzureIotAPIVersion is the IoT Hub API version used in the MQTT username
+const AzureIotAPIVersion = "2021-04-12"
+
+// AzureIotAddressProperty is the message property that holds the iotdomain address
+const AzureIotAddressProperty = "address"
+
+// AzureIotDefaultTokenValidity is the default validity of the SAS token used to connect
+const AzureIotDefaultTokenValidity = time.Hour
+
+// AzureIotConfig with the Azure IoT Hub specific connection settings
+// The messenger config Server holds the hub hostname, eg myhub.azure-devices.net, and the ClientID
+// holds the device ID.
+type AzureIotConfig struct {
+	CACertFile       string `yaml:"caCertFile,omitempty"`       // hub root CA in PEM format. Default is the system CA pool
+	SharedAccessKey  string `yaml:"sharedAccessKey"`            // base64 encoded device shared access key
+	TokenValiditySec int    `yaml:"tokenValiditySec,omitempty"` // validity of SAS tokens. Default is 1 hour
+}
+
+// AzureTwinHandler is invoked with the desired configuration of a node from the device twin.
+// The signature matches Publisher.UpdateNodeConfigValues.
+type AzureTwinHandler func(nodeHWID string, params types.NodeAttrMap) (changed bool)
+
+// AzureIotMessenger that implements IMessenger for Azure IoT Hub using MQTT with SAS token authentication.
+// IoT Hub only supports fixed device topics so the iotdomain address is passed as a message property:
+//  - publications are sent as device-to-cloud messages
+//  - subscriptions receive cloud-to-device messages whose address matches the subscription
+//  - desired properties of the device twin are mapped to the configuration of nodes by their hardware ID
+type AzureIotMessenger struct {
+	azureConfig   *AzureIotConfig
+	config        *MessengerConfig
+	mqtt          *MqttMessenger   // MQTT connection to the IoT Hub
+	requestID     int              // request ID of twin requests
+	subscriptions []Subscription   // subscriptions to cloud-to-device messages
+	twinHandler   AzureTwinHandler // handler of desired node configuration
+	updateMutex   *sync.Mutex      // mutex for async updating of subscriptions
+}
+
+// AddressFromTopic returns the address of a cloud-to-device or device-to-cloud message topic
+// This returns "" if the topic has no address property.
+func (messenger *AzureIotMessenger) AddressFromTopic(topic string) string {
+	propertyBag := topic[strings.LastIndex(topic, "/")+1:]
+	properties, err := url.ParseQuery(propertyBag)
+	if err != nil {
+		return ""
+	}
+	return properties.Get(AzureIotAddressProperty)
+}
+
+// Capabilities of the IoT Hub. Retained messages aren't supported.
+func (messenger *AzureIotMessenger) Capabilities() MessengerCapabilities {
+	return MessengerCapabilities{Retained: false}
+}
+
+// Connect to the IoT Hub. This subscribes to cloud-to-device messages and device twin updates,
+// and requests the device twin once connected.
+func (messenger *AzureIotMessenger) Connect(lastWillAddress string, lastWillValue string) error {
+	deviceID := messenger.config.ClientID
+	messenger.mqtt.Subscribe(fmt.Sprintf("devices/%s/messages/devicebound/#", deviceID), messenger.ReceiveCloudMessage)
+	messenger.mqtt.Subscribe("$iothub/twin/PATCH/properties/desired/#", messenger.ReceiveTwinDesired)
+	messenger.mqtt.Subscribe("$iothub/twin/res/#", messenger.ReceiveTwinDesired)
+
+	lastWillTopic := ""
+	if lastWillAddress != "" {
+		lastWillTopic = messenger.TopicFromAddress(lastWillAddress)
+	}
+	err := messenger.mqtt.Connect(lastWillTopic, lastWillValue)
+	if err != nil {
+		return err
+	}
+	return messenger.mqtt.Publish(fmt.Sprintf("$iothub/twin/GET/?$rid=%d", messenger.nextRequestID()), false, "")
+}
+
+// Disconnect from the IoT Hub
+func (messenger *AzureIotMessenger) Disconnect() {
+	messenger.mqtt.Disconnect()
+}
+
+// GetConnectionStatus returns the status of the connection to the IoT Hub
+func (messenger *AzureIotMessenger) GetConnectionStatus() ConnectionStatus {
+	return messenger.mqtt.GetConnectionStatus()
+}
+
+// Publish a message as a device-to-cloud message with the address as property
+// IoT Hub does not retain messages so retained is ignored.
+func (messenger *AzureIotMessenger) Publish(address string, retained bool, message string) error {
+	return messenger.mqtt.Publish(messenger.TopicFromAddress(address), false, message)
+}
+
+// ReceiveCloudMessage passes a received cloud-to-device message to the subscribers of its address
+func (messenger *AzureIotMessenger) ReceiveCloudMessage(topic string, message string) error {
+	address := messenger.AddressFromTopic(topic)
+	if address == "" {
+		return fmt.Errorf("ReceiveCloudMessage: Message on '%s' has no address property. Message discarded", topic)
+	}
+	messenger.updateMutex.Lock()
+	subs := messenger.subscriptions
+	messenger.updateMutex.Unlock()
+	for _, sub := range subs {
+		if MatchAddress(address, sub.address) && sub.handler != nil {
+			sub.handler(address, message)
+		}
+	}
+	return nil
+}
+
+// ReceiveTwinDesired passes the desired node configuration of a device twin update or twin request response
+// to the twin handler. The desired properties are objects with node configuration by node hardware ID.
+func (messenger *AzureIotMessenger) ReceiveTwinDesired(topic string, message string) error {
+	var desired map[string]interface{}
+
+	if strings.HasPrefix(topic, "$iothub/twin/res/") {
+		// twin request response: $iothub/twin/res/{status}/?$rid={request id}
+		if !strings.HasPrefix(topic, "$iothub/twin/res/200/") {
+			return nil
+		}
+		var twin struct {
+			Desired map[string]interface{} `json:"desired"`
+		}
+		if err := json.Unmarshal([]byte(message), &twin); err != nil {
+			return fmt.Errorf("ReceiveTwinDesired: Invalid twin on '%s': %s", topic, err)
+		}
+		desired = twin.Desired
+	} else if err := json.Unmarshal([]byte(message), &desired); err != nil {
+		return fmt.Errorf("ReceiveTwinDesired: Invalid desired properties on '%s': %s", topic, err)
+	}
+
+	messenger.updateMutex.Lock()
+	handler := messenger.twinHandler
+	messenger.updateMutex.Unlock()
+	if handler == nil {
+		return nil
+	}
+	for nodeHWID, value := range desired {
+		nodeProps, isObject := value.(map[string]interface{})
+		// skip metadata such as $version
+		if strings.HasPrefix(nodeHWID, "$") || !isObject {
+			continue
+		}
+		params := types.NodeAttrMap{}
+		for attrName, attrValue := range nodeProps {
+			if !strings.HasPrefix(attrName, "$") && attrValue != nil {
+				params[types.NodeAttr(attrName)] = fmt.Sprint(attrValue)
+			}
+		}
+		logrus.Infof("AzureIotMessenger.ReceiveTwinDesired: desired configuration of node %s: %v", nodeHWID, params)
+		handler(nodeHWID, params)
+	}
+	return nil
+}
+
+// SetTwinHandler sets the handler of the desired node configuration from the device twin
+// Use Publisher.UpdateNodeConfigValues to apply the configuration to registered nodes.
+func (messenger *AzureIotMessenger) SetTwinHandler(handler AzureTwinHandler) {
+	messenger.updateMutex.Lock()
+	defer messenger.updateMutex.Unlock()
+	messenger.twinHandler = handler
+}
+
+// Subscribe to cloud-to-device messages whose address match the given address
+func (messenger *AzureIotMessenger) Subscribe(
+	address string, onMessage func(address string, message string) error) {
+
+	messenger.updateMutex.Lock()
+	defer messenger.updateMutex.Unlock()
+	messenger.subscriptions = append(messenger.subscriptions, Subscription{address: address, handler: onMessage})
+}
+
+// TopicFromAddress returns the device-to-cloud message topic for publishing on the address
+func (messenger *AzureIotMessenger) TopicFromAddress(address string) string {
+	properties := url.Values{}
+	properties.Set(AzureIotAddressProperty, address)
+	return fmt.Sprintf("devices/%s/messages/events/%s", messenger.config.ClientID, properties.Encode())
+}
+
+// Unsubscribe the handler from cloud-to-device messages of the given address
+// If onMessage is nil then all handlers of the address are removed.
+func (messenger *AzureIotMessenger) Unsubscribe(
+	address string, onMessage func(address string, message string) error) {
+
+	messenger.updateMutex.Lock()
+	defer messenger.updateMutex.Unlock()
+	remaining := make([]Subscription, 0, len(messenger.subscriptions))
+	isRemoved := false
+	for _, sub := range messenger.subscriptions {
+		if sub.address == address && (onMessage == nil || !isRemoved) && isSameHandler(onMessage, sub.handler) {
+			isRemoved = true
+			continue
+		}
+		remaining = append(remaining, sub)
+	}
+	messenger.subscriptions = remaining
+}
+
+// UpdateReportedConfig reports the configuration of a node in the reported properties of the device twin
+// Intended to report the node configuration after it is applied.
+func (messenger *AzureIotMessenger) UpdateReportedConfig(nodeHWID string, config types.NodeAttrMap) error {
+	reported, err := json.Marshal(map[string]types.NodeAttrMap{nodeHWID: config})
+	if err != nil {
+		return fmt.Errorf("UpdateReportedConfig: Unable to marshal config of node %s: %s", nodeHWID, err)
+	}
+	topic := fmt.Sprintf("$iothub/twin/PATCH/properties/reported/?$rid=%d", messenger.nextRequestID())
+	return messenger.mqtt.Publish(topic, false, string(reported))
+}
+
+// credentials provides the MQTT username and a new SAS token as password on each (re)connect
+func (messenger *AzureIotMessenger) credentials() (username string, password string) {
+	hubHost := messenger.config.Server
+	deviceID := messenger.config.ClientID
+	validity := AzureIotDefaultTokenValidity
+	if messenger.azureConfig.TokenValiditySec > 0 {
+		validity = time.Duration(messenger.azureConfig.TokenValiditySec) * time.Second
+	}
+	username = fmt.Sprintf("%s/%s/?api-version=%s", hubHost, deviceID, AzureIotAPIVersion)
+	resourceURI := fmt.Sprintf("%s/devices/%s", hubHost, deviceID)
+	password, err := MakeAzureSASToken(resourceURI, messenger.azureConfig.SharedAccessKey, time.Now().Add(validity))
+	if err != nil {
+		logrus.Errorf("AzureIotMessenger.credentials: %s", err)
+	}
+	return username, password
+}
+
+// nextRequestID returns a new request ID for twin requests
+func (messenger *AzureIotMessenger) nextRequestID() int {
+	messenger.updateMutex.Lock()
+	defer messenger.updateMutex.Unlock()
+	messenger.requestID++
+	return messenger.requestID
+}
+
+// MakeAzureSASToken creates a shared access signature token for the given resource
+//  resourceURI is the resource to access, eg myhub.azure-devices.net/devices/mydevice
+//  key is the base64 encoded shared access key
+//  expiry is the time the token expires
+func MakeAzureSASToken(resourceURI string, key string, expiry time.Time) (string, error) {
+	decodedKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("MakeAzureSASToken: Invalid shared access key: %s", err)
+	}
+	encodedURI := url.QueryEscape(resourceURI)
+	expirySec := strconv.FormatInt(expiry.Unix(), 10)
+	mac := hmac.New(sha256.New, decodedKey)
+	mac.Write([]byte(encodedURI + "\n" + expirySec))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	token := fmt.Sprintf("SharedAccessSignature sr=%s&sig=%s&se=%s",
+		encodedURI, url.QueryEscape(signature), expirySec)
+	return token, nil
+}
+
+// NewAzureIotMessenger creates a messenger for Azure IoT Hub
+//  config holds the hub hostname as server and the device ID as client ID
+//  azureConfig holds the device shared access key
+func NewAzureIotMessenger(config *MessengerConfig, azureConfig *AzureIotConfig) *AzureIotMessenger {
+	mqtt := NewMqttMessenger(config)
+	mqtt.tlsCACertFile = azureConfig.CACertFile
+	mqtt.tlsVerifyServerCert = true
+	// the ping address isn't mapped to the topics the cloud broker allows
+	mqtt.pingInterval = 0
+
+	messenger := &AzureIotMessenger{
+		azureConfig:   azureConfig,
+		config:        config,
+		mqtt:          mqtt,
+		subscriptions: make([]Subscription, 0),
+		updateMutex:   &sync.Mutex{},
+	}
+	mqtt.credentials = messenger.credentials
+	return messenger
+}
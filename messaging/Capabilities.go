@@ -0,0 +1,23 @@
+// Package messaging - Capabilities of the message bus that publishers depend on
+package messaging
+
+// MessengerCapabilities with the features of the message bus that publishers and subscribers depend on
+type MessengerCapabilities struct {
+	Retained bool // the bus passes the last retained message of an address to new subscribers
+}
+
+// IMessengerCapabilities is implemented by messengers whose message bus lacks features of MQTT brokers
+type IMessengerCapabilities interface {
+	// Capabilities returns the features supported by the message bus
+	Capabilities() MessengerCapabilities
+}
+
+// GetCapabilities returns the capabilities of the message bus of a messenger.
+// Messengers that don't implement IMessengerCapabilities support all features.
+func GetCapabilities(messenger IMessenger) MessengerCapabilities {
+	withCapabilities, ok := messenger.(IMessengerCapabilities)
+	if !ok {
+		return MessengerCapabilities{Retained: true}
+	}
+	return withCapabilities.Capabilities()
+}
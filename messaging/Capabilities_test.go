@@ -0,0 +1,34 @@
+package messaging_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilities(t *testing.T) {
+	config := &messaging.MessengerConfig{Server: "localhost"}
+	noRetainConfig := &messaging.MessengerConfig{Server: "localhost", NoRetain: true}
+
+	// the capabilities are determined by the messenger and its configuration
+	dummy := messaging.NewDummyMessenger(nil)
+	assert.True(t, messaging.GetCapabilities(dummy).Retained)
+	assert.True(t, messaging.GetCapabilities(messaging.NewMqttMessenger(config)).Retained)
+	assert.False(t, messaging.GetCapabilities(messaging.NewMqttMessenger(noRetainConfig)).Retained)
+	aws := messaging.NewAwsIotMessenger(config, &messaging.AwsIotConfig{})
+	assert.False(t, messaging.GetCapabilities(aws).Retained)
+	azure := messaging.NewAzureIotMessenger(config, &messaging.AzureIotConfig{})
+	assert.False(t, messaging.GetCapabilities(azure).Retained)
+
+	// a multi messenger only retains if all its messengers do
+	multi := messaging.NewMultiMessenger()
+	multi.AddMessenger(dummy, nil)
+	assert.True(t, messaging.GetCapabilities(multi).Retained)
+	multi.AddMessenger(messaging.NewDummyMessenger(noRetainConfig), nil)
+	assert.False(t, messaging.GetCapabilities(multi).Retained)
+
+	// shared clients have the capabilities of the shared messenger
+	shared := messaging.NewSharedMessenger(aws)
+	assert.False(t, messaging.GetCapabilities(shared.NewClient()).Retained)
+}
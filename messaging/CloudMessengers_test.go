@@ -0,0 +1,118 @@
+package messaging_test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAwsIotTopics(t *testing.T) {
+	const address1 = "domain1/pub1/node1/temperature/0/$latest"
+	config := &messaging.MessengerConfig{Server: "test-ats.iot.us-east-1.amazonaws.com", ClientID: "thing1"}
+	messenger := messaging.NewAwsIotMessenger(config, &messaging.AwsIotConfig{TopicPrefix: "iotdomain/"})
+
+	topic, err := messenger.TopicFromAddress(address1)
+	assert.NoError(t, err)
+	assert.Equal(t, "iotdomain/"+address1, topic)
+	assert.Equal(t, address1, messenger.AddressFromTopic(topic))
+
+	_, err = messenger.TopicFromAddress("a/b/c/d/e/f/g/h")
+	assert.Error(t, err, "too many levels")
+	_, err = messenger.TopicFromAddress(strings.Repeat("a", messaging.AwsIotMaxTopicLength))
+	assert.Error(t, err, "too long")
+
+	// the thing certificate is required
+	err = messenger.Connect("", "")
+	assert.Error(t, err)
+}
+
+func TestAzureIotMessenger(t *testing.T) {
+	const address1 = "domain1/pub1/node1/switch/0/$set"
+	const node1HWID = "node1"
+	key := base64.StdEncoding.EncodeToString([]byte("secret"))
+	config := &messaging.MessengerConfig{Server: "myhub.azure-devices.net", ClientID: "device1"}
+	messenger := messaging.NewAzureIotMessenger(config, &messaging.AzureIotConfig{SharedAccessKey: key})
+
+	token, err := messaging.MakeAzureSASToken("myhub.azure-devices.net/devices/device1", key, time.Unix(1600000000, 0))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, "SharedAccessSignature sr=myhub.azure-devices.net%2Fdevices%2Fdevice1&sig="))
+	assert.True(t, strings.HasSuffix(token, "&se=1600000000"))
+	_, err = messaging.MakeAzureSASToken("myhub", "not base64!", time.Now())
+	assert.Error(t, err)
+
+	// the address is passed as message property
+	topic := messenger.TopicFromAddress(address1)
+	assert.True(t, strings.HasPrefix(topic, "devices/device1/messages/events/"))
+	assert.Equal(t, address1, messenger.AddressFromTopic(topic))
+
+	// cloud-to-device messages are passed to matching subscriptions
+	var rxMessage = ""
+	messenger.Subscribe("domain1/+/+/switch/#", func(address string, message string) error {
+		rxMessage = message
+		return nil
+	})
+	c2dTopic := "devices/device1/messages/devicebound/%24.to=%2Fdevices%2Fdevice1&address=" +
+		"domain1%2Fpub1%2Fnode1%2Fswitch%2F0%2F%24set"
+	err = messenger.ReceiveCloudMessage(c2dTopic, "on")
+	assert.NoError(t, err)
+	assert.Equal(t, "on", rxMessage)
+	err = messenger.ReceiveCloudMessage("devices/device1/messages/devicebound/", "off")
+	assert.Error(t, err)
+	messenger.Unsubscribe("domain1/+/+/switch/#", nil)
+	messenger.ReceiveCloudMessage(c2dTopic, "off")
+	assert.Equal(t, "on", rxMessage)
+
+	// desired twin properties are mapped to node configuration
+	var rxConfig types.NodeAttrMap
+	messenger.SetTwinHandler(func(nodeHWID string, params types.NodeAttrMap) bool {
+		assert.Equal(t, node1HWID, nodeHWID)
+		rxConfig = params
+		return true
+	})
+	err = messenger.ReceiveTwinDesired("$iothub/twin/PATCH/properties/desired/?$version=2",
+		`{"node1": {"name": "kitchen", "gain": 1.5}, "$version": 2}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "kitchen", rxConfig[types.NodeAttrName])
+	assert.Equal(t, "1.5", rxConfig[types.NodeAttrGain])
+	err = messenger.ReceiveTwinDesired("$iothub/twin/res/200/?$rid=1",
+		`{"desired": {"node1": {"name": "hall"}}, "reported": {}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "hall", rxConfig[types.NodeAttrName])
+	err = messenger.ReceiveTwinDesired("$iothub/twin/PATCH/properties/desired/", "not json")
+	assert.Error(t, err)
+}
+
+func TestAzureIotUnsubscribeHandler(t *testing.T) {
+	config := &messaging.MessengerConfig{Server: "myhub.azure-devices.net", ClientID: "device1"}
+	messenger := messaging.NewAzureIotMessenger(config, &messaging.AzureIotConfig{})
+	c2dTopic := "devices/device1/messages/devicebound/address=domain1%2Fpub1%2Fnode1%2Fswitch%2F0%2F%24set"
+	count1 := 0
+	count2 := 0
+	handler1 := func(address string, message string) error {
+		count1++
+		return nil
+	}
+	handler2 := func(address string, message string) error {
+		count2++
+		return nil
+	}
+	messenger.Subscribe("domain1/#", handler1)
+	messenger.Subscribe("domain1/#", handler2)
+	messenger.ReceiveCloudMessage(c2dTopic, "on")
+	assert.Equal(t, 1, count1)
+	assert.Equal(t, 1, count2)
+
+	// only the given handler is removed
+	messenger.Unsubscribe("domain1/#", handler2)
+	messenger.ReceiveCloudMessage(c2dTopic, "off")
+	assert.Equal(t, 2, count1)
+	assert.Equal(t, 1, count2)
+	messenger.Unsubscribe("domain1/#", handler1)
+	messenger.ReceiveCloudMessage(c2dTopic, "on")
+	assert.Equal(t, 2, count1)
+}
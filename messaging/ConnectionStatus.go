@@ -0,0 +1,44 @@
+// Package messaging - Connection status of messengers for diagnosing the connection to the message bus
+package messaging
+
+import "time"
+
+// ConnectionState of the connection to the message bus
+type ConnectionState string
+
+// Connection states
+const (
+	ConnectionStateConnected    ConnectionState = "connected"    // connected to the message bus
+	ConnectionStateConnecting   ConnectionState = "connecting"   // attempting the initial connection
+	ConnectionStateDisconnected ConnectionState = "disconnected" // not connected or gracefully disconnected
+	ConnectionStateLost         ConnectionState = "lost"         // the connection was lost, reconnecting
+	ConnectionStateUnknown      ConnectionState = "unknown"      // the messenger doesn't report its state
+)
+
+// ConnectionStatus with the connection metrics of a messenger
+type ConnectionStatus struct {
+	ConnectedSince time.Time       // time of the last (re)connect, zero if not connected
+	LastError      string          // the last connection error, "" if none
+	LastErrorTime  time.Time       // time of the last connection error
+	PingInterval   time.Duration   // interval of measuring the round trip time, 0 if disabled
+	Reconnects     int             // nr of times the connection was restored after it was lost
+	RTT            time.Duration   // round trip time to the broker of the last ping, 0 if not measured
+	Server         string          // URL of the message bus server
+	State          ConnectionState // state of the connection
+}
+
+// IConnectionStatus is implemented by messengers that report their connection status
+type IConnectionStatus interface {
+	// GetConnectionStatus returns the current connection status
+	GetConnectionStatus() ConnectionStatus
+}
+
+// GetConnectionStatus returns the connection status of a messenger.
+// Messengers that don't implement IConnectionStatus report the unknown state.
+func GetConnectionStatus(messenger IMessenger) ConnectionStatus {
+	withStatus, ok := messenger.(IConnectionStatus)
+	if !ok {
+		return ConnectionStatus{State: ConnectionStateUnknown}
+	}
+	return withStatus.GetConnectionStatus()
+}
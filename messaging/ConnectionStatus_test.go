@@ -0,0 +1,45 @@
+package messaging_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionStatus(t *testing.T) {
+	config := &messaging.MessengerConfig{Server: "localhost"}
+
+	// messengers are disconnected until they connect
+	mqtt := messaging.NewMqttMessenger(config)
+	status := messaging.GetConnectionStatus(mqtt)
+	assert.Equal(t, messaging.ConnectionStateDisconnected, status.State)
+	assert.Zero(t, status.Reconnects)
+	assert.Equal(t, messaging.PingInterval, status.PingInterval)
+	aws := messaging.NewAwsIotMessenger(config, &messaging.AwsIotConfig{})
+	assert.Equal(t, messaging.ConnectionStateDisconnected, messaging.GetConnectionStatus(aws).State)
+	azure := messaging.NewAzureIotMessenger(config, &messaging.AzureIotConfig{})
+	assert.Equal(t, messaging.ConnectionStateDisconnected, messaging.GetConnectionStatus(azure).State)
+
+	// cloud brokers reject the ping address so the wrapped messengers don't ping
+	assert.Zero(t, messaging.GetConnectionStatus(aws).PingInterval)
+	assert.Zero(t, messaging.GetConnectionStatus(azure).PingInterval)
+
+	// shared clients have the status of the shared messenger
+	dummy := messaging.NewDummyMessenger(config)
+	client := messaging.NewSharedMessenger(dummy).NewClient()
+	client.Connect("", "")
+	assert.Equal(t, messaging.ConnectionStateConnected, messaging.GetConnectionStatus(client).State)
+	client.Disconnect()
+	assert.Equal(t, messaging.ConnectionStateDisconnected, messaging.GetConnectionStatus(client).State)
+
+	// messengers without status are unknown
+	multi := messaging.NewMultiMessenger()
+	assert.Equal(t, messaging.ConnectionStateUnknown, messaging.GetConnectionStatus(multi).State)
+
+	// error case - ping without connection
+	_, err := mqtt.Ping()
+	assert.Error(t, err)
+	mqtt.Disconnect()
+	assert.Equal(t, messaging.ConnectionStateDisconnected, mqtt.GetConnectionStatus().State)
+}
@@ -0,0 +1,58 @@
+// Package messaging with capturing of received messages that are rejected by their handler
+package messaging
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// DeadLetter with a received message that failed to be handled, eg it failed signature or schema checks
+type DeadLetter struct {
+	Address   string `json:"address"`   // address the message was received on
+	Payload   string `json:"payload"`   // raw message as received, possibly signed and encrypted
+	Reason    string `json:"reason"`    // error returned by the handler
+	Timestamp string `json:"timestamp"` // time the message was received
+}
+
+// DeadLetterHandler is invoked with received messages that are rejected by their handler
+// Intended for debugging of interoperability problems with other implementations.
+type DeadLetterHandler func(letter *DeadLetter)
+
+// deadLetterFileMutex serializes appending to dead letter files
+var deadLetterFileMutex = &sync.Mutex{}
+
+// AppendDeadLetter appends a dead letter as a line of JSON to a file, creating the file if needed
+// The file is only readable by the owner as the payload can contain sensitive information.
+func AppendDeadLetter(filename string, letter *DeadLetter) error {
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	deadLetterFileMutex.Lock()
+	defer deadLetterFileMutex.Unlock()
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// NewDeadLetter creates a dead letter of a message received now
+func NewDeadLetter(address string, payload string, reason error) *DeadLetter {
+	letter := &DeadLetter{
+		Address:   address,
+		Payload:   payload,
+		Reason:    reason.Error(),
+		Timestamp: time.Now().Format(types.TimeFormat),
+	}
+	return letter
+}
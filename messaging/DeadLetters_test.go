@@ -0,0 +1,63 @@
+package messaging_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetterHandler(t *testing.T) {
+	const address1 = "test/publisher1/node1/$configure"
+	var rxLetter *messaging.DeadLetter
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	signer := messaging.NewMessageSigner(messenger, messaging.CreateAsymKeys(), nil)
+	signer.SetDeadLetterHandler(func(letter *messaging.DeadLetter) {
+		rxLetter = letter
+	})
+	signer.Subscribe(address1, func(address string, message string) error {
+		if message == "bad" {
+			return errors.New("invalid message")
+		}
+		return nil
+	})
+
+	messenger.Publish(address1, false, "good")
+	assert.Nil(t, rxLetter)
+	messenger.Publish(address1, false, "bad")
+	require.NotNil(t, rxLetter)
+	assert.Equal(t, address1, rxLetter.Address)
+	assert.Equal(t, "bad", rxLetter.Payload)
+	assert.Equal(t, "invalid message", rxLetter.Reason)
+	assert.NotEmpty(t, rxLetter.Timestamp)
+}
+
+func TestAppendDeadLetter(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "deadletters")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	filename := filepath.Join(tempDir, "deadletters.json")
+
+	letter := messaging.NewDeadLetter("test/publisher1/$identity", "payload1", errors.New("bad signature"))
+	err = messaging.AppendDeadLetter(filename, letter)
+	require.NoError(t, err)
+	err = messaging.AppendDeadLetter(filename, letter)
+	require.NoError(t, err)
+
+	contents, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	require.Equal(t, 2, len(lines))
+	var rxLetter messaging.DeadLetter
+	err = json.Unmarshal([]byte(lines[1]), &rxLetter)
+	require.NoError(t, err)
+	assert.Equal(t, "bad signature", rxLetter.Reason)
+	assert.Equal(t, "payload1", rxLetter.Payload)
+}
@@ -0,0 +1,56 @@
+// Package messaging with JWS signatures that are published separately from their payload
+package messaging
+
+import (
+	"crypto"
+	"errors"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// CreateDetachedJWSSignature signs the payload using JWS and returns the compact serialized signature
+// without the payload, eg "header..signature". Intended to sign payloads that are published as is,
+// for consumers that can't parse JWS.
+//  signingKey is an *ecdsa.PrivateKey with curve P-256, P-384 or P-521, or an ed25519.PrivateKey
+func CreateDetachedJWSSignature(payload string, signingKey crypto.Signer) (string, error) {
+	algorithm, err := JWSAlgorithmForKey(signingKey)
+	if err != nil {
+		return "", err
+	}
+	joseSigner, err := jose.NewSigner(jose.SigningKey{Algorithm: algorithm, Key: signingKey}, nil)
+	if err != nil {
+		return "", err
+	}
+	signedObject, err := joseSigner.Sign([]byte(payload))
+	if err != nil {
+		return "", err
+	}
+	return signedObject.DetachedCompactSerialize()
+}
+
+// CreateDetachedSignature signs the payload with the signing key of this signer and returns the
+// detached JWS signature. See also CreateDetachedJWSSignature.
+func (signer *MessageSigner) CreateDetachedSignature(payload string) (string, error) {
+	privateKey := signer.getPrivateKey()
+	if privateKey == nil {
+		return "", errors.New("CreateDetachedSignature: private key is nil")
+	}
+	return CreateDetachedJWSSignature(payload, privateKey)
+}
+
+// VerifyDetachedJWSSignature verifies a detached JWS signature of a payload with a public key of any
+// supported type. The signature must use the algorithm that belongs to the key.
+//  payload as it was signed, eg the published $raw value
+//  signature is the compact serialized signature without payload
+//  publicKey is an *ecdsa.PublicKey with curve P-256, P-384 or P-521, or an ed25519.PublicKey
+func VerifyDetachedJWSSignature(payload string, signature string, publicKey crypto.PublicKey) error {
+	jwsSignature, err := jose.ParseDetached(signature, []byte(payload))
+	if err != nil {
+		return err
+	}
+	err = VerifyJWSAlgorithm(jwsSignature, publicKey)
+	if err != nil {
+		return err
+	}
+	return jwsSignature.DetachedVerify([]byte(payload), publicKey)
+}
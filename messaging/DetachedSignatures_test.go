@@ -0,0 +1,51 @@
+package messaging_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetachedSignatures(t *testing.T) {
+	const payload = "raw value 20"
+	keys := messaging.CreateAsymKeys()
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+
+	// the compact serialization has an empty payload
+	signature, err := messaging.CreateDetachedJWSSignature(payload, keys)
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(strings.Split(signature, ".")))
+	assert.Empty(t, strings.Split(signature, ".")[1])
+	err = messaging.VerifyDetachedJWSSignature(payload, signature, &keys.PublicKey)
+	assert.NoError(t, err)
+
+	// a different payload, key or key type fails
+	err = messaging.VerifyDetachedJWSSignature("raw value 21", signature, &keys.PublicKey)
+	assert.Error(t, err)
+	otherKeys := messaging.CreateAsymKeys()
+	err = messaging.VerifyDetachedJWSSignature(payload, signature, &otherKeys.PublicKey)
+	assert.Error(t, err)
+	err = messaging.VerifyDetachedJWSSignature(payload, signature, edPub)
+	assert.Error(t, err)
+	err = messaging.VerifyDetachedJWSSignature(payload, "notasignature", &keys.PublicKey)
+	assert.Error(t, err)
+
+	edSignature, err := messaging.CreateDetachedJWSSignature(payload, edPriv)
+	require.NoError(t, err)
+	err = messaging.VerifyDetachedJWSSignature(payload, edSignature, edPub)
+	assert.NoError(t, err)
+
+	// the signer uses its signing key
+	signer := messaging.NewMessageSigner(messaging.NewDummyMessenger(nil), keys, nil)
+	signature, err = signer.CreateDetachedSignature(payload)
+	require.NoError(t, err)
+	err = messaging.VerifyDetachedJWSSignature(payload, signature, &keys.PublicKey)
+	assert.NoError(t, err)
+	_, err = messaging.NewMessageSigner(messaging.NewDummyMessenger(nil), nil, nil).CreateDetachedSignature(payload)
+	assert.Error(t, err)
+}
@@ -2,9 +2,9 @@
 package messaging
 
 import (
-	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/sirupsen/logrus"
@@ -14,6 +14,9 @@ import (
 type DummyMessenger struct {
 	publications  map[string]string
 	config        *MessengerConfig // for domain configuration
+	denials       *topicDenials    // operations denied by the simulated ACL
+	deniedTopics  map[TopicOperation][]string
+	isConnected   bool // connected until disconnected
 	subscriptions []Subscription
 	publishMutex  *sync.Mutex // mutex for concurrent publishing of messages
 }
@@ -24,13 +27,58 @@ type Subscription struct {
 	handler func(address string, message string) error
 }
 
+// Capabilities of the dummy messenger. Retained messages are supported unless disabled in the config.
+func (messenger *DummyMessenger) Capabilities() MessengerCapabilities {
+	return MessengerCapabilities{Retained: messenger.config == nil || !messenger.config.NoRetain}
+}
+
+// CheckTopicAccess verifies the addresses against the ACL that is simulated with DenyTopic
+// The simulated ACL applies to the publication address itself instead of its preflight subtopic.
+// Use nil subscribe addresses to return the denied subscriptions.
+func (messenger *DummyMessenger) CheckTopicAccess(publish []string, subscribe []string, timeout time.Duration) []TopicDenial {
+	denials := make([]TopicDenial, 0)
+	for _, address := range publish {
+		if messenger.isTopicDenied(TopicOperationPublish, replaceWildcards(address)) {
+			denials = append(denials, messenger.denials.add(TopicOperationPublish, address, "denied by the ACL"))
+		}
+	}
+	if subscribe == nil {
+		for _, denial := range messenger.denials.list() {
+			if denial.Operation == TopicOperationSubscribe {
+				denials = append(denials, denial)
+			}
+		}
+	}
+	for _, address := range subscribe {
+		if messenger.isTopicDenied(TopicOperationSubscribe, address) {
+			denials = append(denials, messenger.denials.add(TopicOperationSubscribe, address, "denied by the ACL"))
+		}
+	}
+	return denials
+}
+
 // Connect the messenger
 func (messenger *DummyMessenger) Connect(lastWillAddress string, lastWillValue string) error {
+	messenger.publishMutex.Lock()
+	messenger.isConnected = true
+	messenger.publishMutex.Unlock()
 	return nil
 }
 
+// DenyTopic simulates a broker ACL that denies an operation on addresses that match the given address
+// Denied publications are silently dropped and denied subscriptions are ignored, like with an MQTT broker.
+//  address with optional wildcards.
+func (messenger *DummyMessenger) DenyTopic(operation TopicOperation, address string) {
+	messenger.publishMutex.Lock()
+	defer messenger.publishMutex.Unlock()
+	messenger.deniedTopics[operation] = append(messenger.deniedTopics[operation], address)
+}
+
 // Disconnect gracefully disconnects the messenger
 func (messenger *DummyMessenger) Disconnect() {
+	messenger.publishMutex.Lock()
+	messenger.isConnected = false
+	messenger.publishMutex.Unlock()
 }
 
 // FindLastPublication with the given address
@@ -41,6 +89,16 @@ func (messenger *DummyMessenger) FindLastPublication(addr string) (message strin
 	return pub
 }
 
+// GetConnectionStatus returns the connected state of the dummy messenger
+func (messenger *DummyMessenger) GetConnectionStatus() ConnectionStatus {
+	messenger.publishMutex.Lock()
+	defer messenger.publishMutex.Unlock()
+	if messenger.isConnected {
+		return ConnectionStatus{Server: "dummy", State: ConnectionStateConnected}
+	}
+	return ConnectionStatus{Server: "dummy", State: ConnectionStateDisconnected}
+}
+
 // GetDomain returns the domain in which this messenger operates
 // This is provided via the messenger config file or defaults to types.LocalDomainID
 func (messenger *DummyMessenger) GetDomain() string {
@@ -51,6 +109,11 @@ func (messenger *DummyMessenger) GetDomain() string {
 	return domain
 }
 
+// GetTopicDenials returns the operations that are denied by the simulated ACL
+func (messenger *DummyMessenger) GetTopicDenials() []TopicDenial {
+	return messenger.denials.list()
+}
+
 // NrPublications returns the number of received publications
 func (messenger *DummyMessenger) NrPublications() int {
 	return len(messenger.publications)
@@ -76,6 +139,10 @@ func (messenger *DummyMessenger) OnReceive(address string, message string) {
 // retained (ignored)
 // message JSON text or raw message base64 encoded text
 func (messenger *DummyMessenger) Publish(address string, retained bool, message string) error {
+	if messenger.isTopicDenied(TopicOperationPublish, address) {
+		messenger.denials.add(TopicOperationPublish, address, "denied by the ACL")
+		return nil
+	}
 	messenger.publishMutex.Lock()
 	messenger.publications[address] = message
 	messenger.publishMutex.Unlock()
@@ -89,35 +156,50 @@ func (messenger *DummyMessenger) Subscribe(
 	address string, onMessage func(address string, message string) error) {
 
 	logrus.Infof("DummyMessenger.Subscribe: address %s", address)
+	if messenger.isTopicDenied(TopicOperationSubscribe, address) {
+		messenger.denials.add(TopicOperationSubscribe, address, "denied by the ACL")
+		return
+	}
 	subscription := Subscription{address: address, handler: onMessage}
 	messenger.publishMutex.Lock()
 	messenger.subscriptions = append(messenger.subscriptions, subscription)
 	messenger.publishMutex.Unlock()
 }
 
+// SetOnTopicDenied sets the handler that is invoked when the simulated ACL denies an operation
+func (messenger *DummyMessenger) SetOnTopicDenied(handler TopicDenialHandler) {
+	messenger.denials.setHandler(handler)
+}
+
 // Unsubscribe an address and handler
 func (messenger *DummyMessenger) Unsubscribe(
 	address string, onMessage func(address string, message string) error) {
 	messenger.publishMutex.Lock()
-	// https://stackoverflow.com/questions/9643205/how-do-i-compare-two-functions-for-pointer-equality-in-the-latest-go-weekly
-	var onMessageID = onMessage
-	onMessageStr := fmt.Sprintf("%v", &onMessageID)
-	for i, sub := range messenger.subscriptions {
-		// can't compare addresses directly so convert to string
-		var handlerID = sub.handler
-		handlerStr := fmt.Sprintf("%v", &handlerID)
-		if sub.address == address && (onMessage == nil || handlerStr == onMessageStr) {
-			// shift remainder left one index
-			copy(messenger.subscriptions[i:], messenger.subscriptions[i+1:])
-			messenger.subscriptions = messenger.subscriptions[:len(messenger.subscriptions)-1]
-			if onMessage != nil {
-				break
-			}
+	isRemoved := false
+	remaining := make([]Subscription, 0, len(messenger.subscriptions))
+	for _, sub := range messenger.subscriptions {
+		if sub.address == address && (onMessage == nil || !isRemoved) && isSameHandler(onMessage, sub.handler) {
+			isRemoved = true
+			continue
 		}
+		remaining = append(remaining, sub)
 	}
+	messenger.subscriptions = remaining
 	messenger.publishMutex.Unlock()
 }
 
+// isTopicDenied returns true if the simulated ACL denies an operation on an address
+func (messenger *DummyMessenger) isTopicDenied(operation TopicOperation, address string) bool {
+	messenger.publishMutex.Lock()
+	defer messenger.publishMutex.Unlock()
+	for _, deniedAddress := range messenger.deniedTopics[operation] {
+		if deniedAddress == address || messenger.matchAddress(address, deniedAddress) {
+			return true
+		}
+	}
+	return false
+}
+
 // test if a given address matches a subscription address with wildcards
 func (messenger *DummyMessenger) matchAddress(address string, subscription string) (match bool) {
 	subscriptionSegments := strings.Split(subscription, "/")
@@ -153,6 +235,8 @@ func (messenger *DummyMessenger) matchAddress(address string, subscription strin
 func NewDummyMessenger(config *MessengerConfig) *DummyMessenger {
 	var messenger = &DummyMessenger{
 		config:        config,
+		denials:       newTopicDenials(),
+		deniedTopics:  make(map[TopicOperation][]string),
 		publications:  make(map[string]string, 0),
 		subscriptions: make([]Subscription, 0),
 		publishMutex:  &sync.Mutex{},
@@ -32,7 +32,9 @@ func TestDummyConnect(t *testing.T) {
 	assert.Equal(t, types.LocalDomainID, domain)
 
 	assert.NoError(t, err, "Connection failed")
+	assert.Equal(t, messaging.ConnectionStateConnected, messaging.GetConnectionStatus(messenger).State)
 	messenger.Disconnect()
+	assert.Equal(t, messaging.ConnectionStateDisconnected, messenger.GetConnectionStatus().State)
 }
 
 // TestPublish a message
@@ -0,0 +1,164 @@
+// Package messaging - Recovery and reporting of application handler failures
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HandlerErrorCallback is invoked when an application handler returns an error or panics.
+// Intended for the application to decide whether to restart or degrade.
+//  address is the address of the message that was handled, if any
+//  isPanic is set if the handler panicked, in which case err holds the panic value
+type HandlerErrorCallback func(address string, err error, isPanic bool)
+
+// ErrDraining is returned when a received message is refused because the guard is draining
+var ErrDraining = errors.New("HandlerGuard: draining, message refused")
+
+// HandlerGuard invokes application handlers, recovers from their panics and reports their failures.
+// A panic in a handler is logged with its stack trace and counted instead of ending the process.
+// The guard tracks the handlers in flight so a shutdown can wait for them to finish.
+type HandlerGuard struct {
+	draining       bool                 // refuse new messages
+	errorCount     int                  // nr of errors returned by handlers
+	inFlight       int                  // nr of handlers being invoked
+	onHandlerError HandlerErrorCallback // optional application callback on failure
+	panicCount     int                  // nr of recovered panics
+	updateMutex    *sync.Mutex          // mutex for async updating of the counters
+}
+
+// Drain refuses new messages until Resume and waits until the handlers in flight have finished or the timeout expires
+// Handlers that are invoked by handlers in flight are still allowed.
+// This returns an error if handlers are still in flight after the timeout.
+func (guard *HandlerGuard) Drain(timeout time.Duration) error {
+	guard.updateMutex.Lock()
+	guard.draining = true
+	guard.updateMutex.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		inFlight := guard.InFlight()
+		if inFlight == 0 {
+			return nil
+		} else if time.Now().After(deadline) {
+			return fmt.Errorf("HandlerGuard.Drain: %d handlers still in flight after %s", inFlight, timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// ErrorCount returns the nr of errors returned by handlers
+func (guard *HandlerGuard) ErrorCount() int {
+	guard.updateMutex.Lock()
+	defer guard.updateMutex.Unlock()
+	return guard.errorCount
+}
+
+// InFlight returns the nr of handlers that are being invoked
+func (guard *HandlerGuard) InFlight() int {
+	guard.updateMutex.Lock()
+	defer guard.updateMutex.Unlock()
+	return guard.inFlight
+}
+
+// Invoke the handler and recover if it panics. This returns the handler error or the recovered panic.
+//  address is the address of the message being handled, used for reporting
+func (guard *HandlerGuard) Invoke(address string, handler func() error) error {
+	guard.updateMutex.Lock()
+	guard.inFlight++
+	guard.updateMutex.Unlock()
+	return guard.invoke(address, handler)
+}
+
+// InvokeMessage invokes the handler of a newly received message, unless the guard is draining
+// This returns ErrDraining if the message is refused, or the result of Invoke.
+//  address is the address of the received message
+func (guard *HandlerGuard) InvokeMessage(address string, handler func() error) error {
+	guard.updateMutex.Lock()
+	if guard.draining {
+		guard.updateMutex.Unlock()
+		return ErrDraining
+	}
+	// count before releasing the lock so a drain waits for this handler
+	guard.inFlight++
+	guard.updateMutex.Unlock()
+	return guard.invoke(address, handler)
+}
+
+// IsDraining returns whether the guard refuses new messages
+func (guard *HandlerGuard) IsDraining() bool {
+	guard.updateMutex.Lock()
+	defer guard.updateMutex.Unlock()
+	return guard.draining
+}
+
+// PanicCount returns the nr of recovered handler panics
+func (guard *HandlerGuard) PanicCount() int {
+	guard.updateMutex.Lock()
+	defer guard.updateMutex.Unlock()
+	return guard.panicCount
+}
+
+// Resume accepts new messages after a drain
+func (guard *HandlerGuard) Resume() {
+	guard.updateMutex.Lock()
+	defer guard.updateMutex.Unlock()
+	guard.draining = false
+}
+
+// SetOnHandlerError sets the callback that is invoked when a handler returns an error or panics
+func (guard *HandlerGuard) SetOnHandlerError(callback HandlerErrorCallback) {
+	guard.updateMutex.Lock()
+	defer guard.updateMutex.Unlock()
+	guard.onHandlerError = callback
+}
+
+// invoke the handler of an in-flight invocation and recover if it panics
+func (guard *HandlerGuard) invoke(address string, handler func() error) (err error) {
+	defer func() {
+		guard.updateMutex.Lock()
+		guard.inFlight--
+		guard.updateMutex.Unlock()
+	}()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("handler panic: %v", recovered)
+			logrus.Errorf("HandlerGuard.Invoke: Handler of '%s' panicked: %v\n%s", address, recovered, debug.Stack())
+			guard.report(address, err, true)
+		}
+	}()
+	err = handler()
+	if err != nil {
+		guard.report(address, err, false)
+	}
+	return err
+}
+
+// report counts the failure and passes it to the application callback
+func (guard *HandlerGuard) report(address string, err error, isPanic bool) {
+	guard.updateMutex.Lock()
+	if isPanic {
+		guard.panicCount++
+	} else {
+		guard.errorCount++
+	}
+	callback := guard.onHandlerError
+	guard.updateMutex.Unlock()
+
+	if callback != nil {
+		callback(address, err, isPanic)
+	}
+}
+
+// NewHandlerGuard creates a guard for invoking application handlers
+func NewHandlerGuard() *HandlerGuard {
+	guard := &HandlerGuard{
+		updateMutex: &sync.Mutex{},
+	}
+	return guard
+}
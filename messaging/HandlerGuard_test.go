@@ -0,0 +1,91 @@
+package messaging_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerGuard(t *testing.T) {
+	const address1 = "test/publisher1/node1/$configure"
+	var rxAddress = ""
+	var rxPanic = false
+	guard := messaging.NewHandlerGuard()
+	guard.SetOnHandlerError(func(address string, err error, isPanic bool) {
+		rxAddress = address
+		rxPanic = isPanic
+	})
+
+	err := guard.Invoke(address1, func() error { return nil })
+	assert.NoError(t, err)
+	assert.Empty(t, rxAddress)
+
+	err = guard.Invoke(address1, func() error { return errors.New("failed") })
+	assert.Error(t, err)
+	assert.Equal(t, address1, rxAddress)
+	assert.False(t, rxPanic)
+	assert.Equal(t, 1, guard.ErrorCount())
+
+	err = guard.Invoke(address1, func() error { panic("oops") })
+	assert.Error(t, err)
+	assert.True(t, rxPanic)
+	assert.Equal(t, 1, guard.PanicCount())
+}
+
+func TestSubscriberPanic(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	signer := messaging.NewMessageSigner(messenger, messaging.CreateAsymKeys(), nil)
+	signer.Subscribe("test/#", func(address string, message string) error {
+		var names []string
+		_ = names[1] // index out of range
+		return nil
+	})
+	err := signer.PublishObject("test/publisher1", false, TestObjectWithSender{Sender: "me"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, signer.HandlerGuard().PanicCount())
+}
+
+func TestHandlerGuardDrain(t *testing.T) {
+	const address1 = "test/publisher1/node1/$configure"
+	guard := messaging.NewHandlerGuard()
+	started := make(chan bool)
+	var nestedErr error
+	var finished = false
+
+	// a slow handler that is in flight when draining starts
+	go guard.InvokeMessage(address1, func() error {
+		started <- true
+		time.Sleep(50 * time.Millisecond)
+		// handlers invoked by a handler in flight are allowed
+		nestedErr = guard.Invoke(address1, func() error { return nil })
+		finished = true
+		return nil
+	})
+	<-started
+	assert.Equal(t, 1, guard.InFlight())
+
+	err := guard.Drain(time.Second)
+	assert.NoError(t, err)
+	assert.True(t, finished)
+	assert.NoError(t, nestedErr)
+	assert.Equal(t, 0, guard.InFlight())
+	assert.True(t, guard.IsDraining())
+
+	// new messages are refused
+	err = guard.InvokeMessage(address1, func() error { return nil })
+	assert.Equal(t, messaging.ErrDraining, err)
+
+	// drain timeout
+	guard.Resume()
+	go guard.InvokeMessage(address1, func() error {
+		started <- true
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	<-started
+	err = guard.Drain(10 * time.Millisecond)
+	assert.Error(t, err)
+}
@@ -1,18 +1,25 @@
 // Package messaging - Interface of messengers for publishers and subscribers
 package messaging
 
+import "reflect"
+
 // MessengerConfig with configuration of a messenger
 type MessengerConfig struct {
-	ClientID  string `yaml:"clientid,omitempty"`  // optional connect ID, must be unique. Default is generated.
-	Domain    string `yaml:"domain,omitempty"`    // Domain to be used by all publishers
-	Login     string `yaml:"login"`               // messenger login name
-	Port      uint16 `yaml:"port,omitempty"`      // optional port, default is 8883 for TLS
-	Password  string `yaml:"credentials"`         // messenger login credentials
-	PubQos    byte   `yaml:"pubqos,omitempty"`    // publishing QOS 0-2. Default=0
-	Server    string `yaml:"server"`              // Message bus server/broker hostname or ip address, required
-	Signing   bool   `yaml:"signing,omitempty"`   // Message signing to be used by all publishers.
-	SubQos    byte   `yaml:"subqos,omitempty"`    // Subscription QOS 0-2. Default=0
-	Messenger string `yaml:"messenger,omitempty"` // Messenger client type: "DummyMessenger" (default) or "MQTTMessenger"
+	ClientID          string `yaml:"clientid,omitempty"`          // optional connect ID, must be unique. Default is generated.
+	ClientIDSuffix    string `yaml:"clientIdSuffix,omitempty"`    // suffix of the client ID: none, pid, random or time. Default is time without clientid, none with
+	Domain            string `yaml:"domain,omitempty"`            // Domain to be used by all publishers
+	Login             string `yaml:"login"`                       // messenger login name
+	Port              uint16 `yaml:"port,omitempty"`              // optional port, default is 8883 for TLS
+	Prefix            string `yaml:"prefix,omitempty"`            // optional topic prefix required by the broker, eg "tenants/acme"
+	Password          string `yaml:"credentials"`                 // messenger login credentials
+	PubQos            byte   `yaml:"pubqos,omitempty"`            // publishing QOS 0-2. Default=0
+	Server            string `yaml:"server"`                      // Message bus server/broker hostname or ip address. Empty to locate with mDNS
+	Signing           bool   `yaml:"signing,omitempty"`           // Message signing to be used by all publishers.
+	SubQos            byte   `yaml:"subqos,omitempty"`            // Subscription QOS 0-2. Default=0
+	Messenger         string `yaml:"messenger,omitempty"`         // Messenger client type: "DummyMessenger" (default) or "MQTTMessenger"
+	NoRetain          bool   `yaml:"noRetain,omitempty"`          // the broker doesn't support retained messages
+	PersistentSession bool   `yaml:"persistentSession,omitempty"` // keep subscriptions and queued messages on the broker during disconnects. Requires a stable client ID
+	SessionExpiry     int    `yaml:"sessionExpiry,omitempty"`     // seconds to resume a lost persistent session. Default (0) always resumes
 }
 
 // IMessenger interface for messenger implementations
@@ -44,9 +51,18 @@ type IMessenger interface {
 	//  address to subscribe to with support for wildcards '+' and '#'. Non MQTT busses must convert to equivalent
 	//  onMessage callback is invoked when a message on this address is received
 	// Multiple subscriptions for the same address is supported.
+	// Messages on the same address MUST be delivered to the handler in publish order. Implementations
+	// that deliver asynchronously must keep a single queue per address. See also OrderingHarness.
 	Subscribe(address string, onMessage func(address string, message string) error)
 
 	// Unsubscribe from a previously subscribed address.
 	// If onMessage is nil then all subscriptions with the address will be removed
 	Unsubscribe(address string, onMessage func(address string, message string) error)
 }
+
+// isSameHandler returns true if both message handlers are the same function, for use by Unsubscribe.
+// Functions can't be compared directly so their code pointers are compared.
+// A nil onMessage matches any handler.
+func isSameHandler(onMessage func(address string, message string) error, handler func(address string, message string) error) bool {
+	return onMessage == nil || reflect.ValueOf(onMessage).Pointer() == reflect.ValueOf(handler).Pointer()
+}
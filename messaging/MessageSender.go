@@ -0,0 +1,101 @@
+// Package messaging with identification of the sender of received messages
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// ErrSpoofedSender is the error of signed messages that are not signed by the sender they claim.
+// Use errors.Is to test for it.
+var ErrSpoofedSender = errors.New("message is not signed by its claimed sender")
+
+// RejectedSignatureHandler is invoked when the signature of a received message is rejected
+//  sender is the claimed sender of the message, or its address if the sender is missing
+//  result is the reason of rejection, eg ReceiveResultRejectedSpoofed
+type RejectedSignatureHandler func(sender string, result ReceiveResult, err error)
+
+// MessageSender returns the sender of a message object.
+// Commands and other messages that have a 'Sender' field are identified by it, even if it is empty.
+// Messages without a 'Sender' field are sent by the publisher of their 'Address'.
+// This returns an error if the object has neither field or the sender is empty.
+//  object is a pointer to the decoded message
+func MessageSender(object interface{}) (sender string, err error) {
+	reflObject := reflect.ValueOf(object)
+	if reflObject.Kind() != reflect.Ptr || reflObject.Elem().Kind() != reflect.Struct {
+		return "", fmt.Errorf("MessageSender: object of type %T is not a pointer to a message", object)
+	}
+	reflSender := reflObject.Elem().FieldByName("Sender")
+	if !reflSender.IsValid() {
+		reflSender = reflObject.Elem().FieldByName("Address")
+	}
+	if reflSender.Kind() != reflect.String {
+		return "", errors.New("MessageSender: object doesn't have a Sender or Address field")
+	}
+	sender = reflSender.String()
+	if sender == "" {
+		return "", errors.New("MessageSender: Missing sender or address information in message")
+	}
+	return sender, nil
+}
+
+// IsSamePublisher returns true if two addresses have the same domain and publisher, eg the sender
+// address domain/publisher/node and the identity address domain/publisher/$identity
+func IsSamePublisher(address1 string, address2 string) bool {
+	segments1 := strings.SplitN(address1, "/", 3)
+	segments2 := strings.SplitN(address2, "/", 3)
+	if len(segments1) < 2 || len(segments2) < 2 {
+		return address1 == address2
+	}
+	return segments1[0] == segments2[0] && segments1[1] == segments2[1]
+}
+
+// SetOnRejectedSignature sets the handler that is invoked when the signature of a message that is
+// decoded or verified by this signer is rejected. Intended to log security events. Use nil to remove the handler.
+func (signer *MessageSigner) SetOnRejectedSignature(handler RejectedSignatureHandler) {
+	signer.onRejectedSignature = handler
+}
+
+// notifyRejectedSignature invokes the rejected signature handler if the result is a rejected signature
+func (signer *MessageSigner) notifyRejectedSignature(object interface{}, result ReceiveResult, err error) {
+	handler := signer.onRejectedSignature
+	if handler == nil || (result != ReceiveResultRejectedSignature && result != ReceiveResultRejectedSpoofed) {
+		return
+	}
+	_, sender := messageAddressAndSender(object)
+	handler(sender, result, err)
+}
+
+// messageAddressAndSender returns the address and sender of a message object
+// Messages without a valid sender are identified by their address.
+func messageAddressAndSender(object interface{}) (address string, sender string) {
+	reflObject := reflect.ValueOf(object)
+	if reflObject.Kind() == reflect.Ptr && reflObject.Elem().Kind() == reflect.Struct {
+		if reflAddress := reflObject.Elem().FieldByName("Address"); reflAddress.Kind() == reflect.String {
+			address = reflAddress.String()
+		}
+	}
+	sender, err := MessageSender(object)
+	if err != nil {
+		sender = address
+	}
+	return address, sender
+}
+
+// verifySenderKeyID verifies that the optional key ID in the JWS header identifies the claimed sender.
+// Senders that include a key ID use the address of their identity or one of their nodes.
+func verifySenderKeyID(jwsSignature *jose.JSONWebSignature, sender string) error {
+	if len(jwsSignature.Signatures) == 0 {
+		return nil
+	}
+	keyID := jwsSignature.Signatures[0].Header.KeyID
+	if keyID != "" && !IsSamePublisher(keyID, sender) {
+		return fmt.Errorf("VerifySenderJWSSignature: message from %s is signed with key '%s': %w",
+			sender, keyID, ErrSpoofedSender)
+	}
+	return nil
+}
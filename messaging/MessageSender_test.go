@@ -0,0 +1,86 @@
+package messaging_test
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestMessageSender(t *testing.T) {
+	sender, err := messaging.MessageSender(&TestObjectWithSender{Sender: "dom1/pub1/node1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "dom1/pub1/node1", sender)
+
+	sender, err = messaging.MessageSender(&TestObjectNoSender{Address: "dom1/pub2/node1/$node"})
+	assert.NoError(t, err)
+	assert.Equal(t, "dom1/pub2/node1/$node", sender)
+
+	// a message with a sender field doesn't fall back to its address
+	withBoth := struct {
+		Address string
+		Sender  string
+	}{Address: "dom1/pub2/node1/$set"}
+	_, err = messaging.MessageSender(&withBoth)
+	assert.Error(t, err)
+
+	_, err = messaging.MessageSender(TestObjectWithSender{Sender: "dom1/pub1/node1"})
+	assert.Error(t, err, "object must be a pointer")
+	_, err = messaging.MessageSender(&struct{ Field1 string }{})
+	assert.Error(t, err)
+
+	assert.True(t, messaging.IsSamePublisher("dom1/pub1/node1", "dom1/pub1/$identity"))
+	assert.False(t, messaging.IsSamePublisher("dom1/pub1/node1", "dom1/pub2/$identity"))
+	assert.False(t, messaging.IsSamePublisher("dom1/pub1/node1", "dom2/pub1/$identity"))
+}
+
+func TestSpoofedSender(t *testing.T) {
+	pub1Keys := messaging.CreateAsymKeys()
+	pub2Keys := messaging.CreateAsymKeys()
+	getPublicKey := func(address string) *ecdsa.PublicKey {
+		if messaging.IsSamePublisher(address, "dom1/pub1") {
+			return &pub1Keys.PublicKey
+		}
+		return &pub2Keys.PublicKey
+	}
+	payload, _ := json.Marshal(TestObjectWithSender{Field1: "open", Sender: "dom1/pub1/node1"})
+
+	// pub2 claims to be pub1
+	spoofed, err := messaging.CreateJWSSignature(string(payload), pub2Keys)
+	require.NoError(t, err)
+	var received TestObjectWithSender
+	isSigned, err := messaging.VerifySenderJWSSignature(spoofed, &received, getPublicKey)
+	assert.True(t, isSigned)
+	assert.True(t, errors.Is(err, messaging.ErrSpoofedSender))
+
+	// the key ID in the header must identify the claimed sender
+	signWithKeyID := func(keyID string) string {
+		options := (&jose.SignerOptions{}).WithHeader(jose.HeaderKey("kid"), keyID)
+		joseSigner, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: pub1Keys}, options)
+		require.NoError(t, err)
+		signedObject, err := joseSigner.Sign(payload)
+		require.NoError(t, err)
+		message, _ := signedObject.CompactSerialize()
+		return message
+	}
+	_, err = messaging.VerifySenderJWSSignature(signWithKeyID("dom1/pub1/$identity"), &received, getPublicKey)
+	assert.NoError(t, err)
+	_, err = messaging.VerifySenderJWSSignature(signWithKeyID("dom1/pub2/$identity"), &received, getPublicKey)
+	assert.True(t, errors.Is(err, messaging.ErrSpoofedSender))
+	// also without public key lookup
+	_, err = messaging.VerifySenderJWSSignature(signWithKeyID("dom1/pub2/$identity"), &received, nil)
+	assert.True(t, errors.Is(err, messaging.ErrSpoofedSender))
+
+	// spoofed messages are counted separately
+	signer := messaging.NewMessageSigner(messaging.NewDummyMessenger(nil), pub1Keys, getPublicKey)
+	_, _, err = signer.DecodeMessage(spoofed, &received)
+	assert.Error(t, err)
+	records := signer.ReceiveStats().GetReceiveStats()
+	require.Len(t, records, 1)
+	assert.Equal(t, 1, records[0].Counts[messaging.ReceiveResultRejectedSpoofed])
+}
@@ -10,20 +10,43 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"reflect"
+	"sync"
 
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/square/go-jose.v2"
 )
 
+// MessageHook is invoked with the address and payload of a message before it is published or delivered.
+// It returns whether the message is allowed and the payload to use instead, which can be modified.
+type MessageHook func(address string, payload string) (allow bool, modified string)
+
 // MessageSigner for signing and verifying of signed and encrypted messages
 type MessageSigner struct {
 	// GetPublicKey when available is used in mess to verify signature
 	GetPublicKey func(address string) *ecdsa.PublicKey // must be a variable
 	messenger    IMessenger
 	signMessages bool              // flag, sign outgoing messages. Default is true. Disable for testing
-	privateKey   *ecdsa.PrivateKey // private key for signing and decryption
+	privateKey   *ecdsa.PrivateKey // private key for signing and decryption, guarded by keyMutex
+	keyMutex     *sync.RWMutex     // mutex for swapping the private key while publishing
+
+	deadLetterHandler   DeadLetterHandler        // optional handler of received messages that are rejected by their handler
+	onRejectedSignature RejectedSignatureHandler // optional notification of messages with a rejected signature
+	preDeliverHook      MessageHook              // optional hook for received messages before they are passed to the handler
+	prePublishHook      MessageHook              // optional hook for the payload before it is signed and published
+
+	// handlers by subscribed address. The messenger is subscribed once per address.
+	handlers      map[string][]func(address string, message string) error
+	handlersMutex *sync.Mutex
+
+	handlerGuard   *HandlerGuard       // recovery of panics in message handlers
+	publishLimiter *PublishRateLimiter // limit of the nr of publications per second
+	receiveStats   *ReceiveStats       // counters of received messages by message type and sender
+	sequences      *SequenceNumbers    // sequence nrs of published message objects
+
+	// message types that are published without signature, and lookup of those declared by senders
+	unsignedTypes     map[types.MessageType]bool
+	isUnsignedAllowed func(address string) bool
 }
 
 // DecodeMessage decrypts the message and verifies the sender signature .
@@ -31,11 +54,36 @@ type MessageSigner struct {
 // Sender field is missing then the 'address' field is used as sender.
 // object must hold the expected message type to decode the json message containging the sender info
 func (signer *MessageSigner) DecodeMessage(rawMessage string, object interface{}) (isEncrypted bool, isSigned bool, err error) {
-	dmessage, isEncrypted, err := DecryptMessage(rawMessage, signer.privateKey)
-	isSigned, err = VerifySenderJWSSignature(dmessage, object, signer.GetPublicKey)
+	dmessage, isEncrypted, err := DecryptMessage(rawMessage, signer.getPrivateKey())
+	isSigned, result, err := verifySenderJWSSignature(dmessage, object, signer.GetPublicKey)
+	signer.receiveStats.updateFromObject(object, result)
+	signer.notifyRejectedSignature(object, result, err)
 	return isEncrypted, isSigned, err
 }
 
+// HandlerGuard returns the guard that invokes the message handlers of subscriptions
+// Use it to set the callback for handler errors and to obtain the panic count.
+func (signer *MessageSigner) HandlerGuard() *HandlerGuard {
+	return signer.handlerGuard
+}
+
+// PublishLimiter returns the limiter of the publish rate of this signer
+// Use SetMaxRate to limit the nr of publications per second. The default is unlimited.
+func (signer *MessageSigner) PublishLimiter() *PublishRateLimiter {
+	return signer.publishLimiter
+}
+
+// ReceiveStats returns the counters of received messages that are decoded or verified by this signer
+// Receivers that drop verified messages as duplicate can count them here.
+func (signer *MessageSigner) ReceiveStats() *ReceiveStats {
+	return signer.receiveStats
+}
+
+// SequenceNumbers returns the sequence nrs of published message objects
+func (signer *MessageSigner) SequenceNumbers() *SequenceNumbers {
+	return signer.sequences
+}
+
 // SignMessages returns whether messages MUST be signed on sending or receiving
 func (signer *MessageSigner) SignMessages() bool {
 	return signer.signMessages
@@ -46,14 +94,18 @@ func (signer *MessageSigner) SignMessages() bool {
 // Sender field is missing then the 'address' field contains the publisher.
 //  or 'address' field
 func (signer *MessageSigner) VerifySignedMessage(rawMessage string, object interface{}) (isSigned bool, err error) {
-	isSigned, err = VerifySenderJWSSignature(rawMessage, object, signer.GetPublicKey)
+	isSigned, result, err := verifySenderJWSSignature(rawMessage, object, signer.GetPublicKey)
+	signer.receiveStats.updateFromObject(object, result)
+	signer.notifyRejectedSignature(object, result, err)
 	return isSigned, err
 }
 
 // PublishObject encapsulates the message object in a payload, signs the message, and sends it.
 //  If an encryption key is provided then the signed message will be encrypted.
 //  The object to publish will be marshalled to JSON and signed by this publisher
+//  If the object has a Sequence field then it is set to the next sequence nr of the address.
 func (signer *MessageSigner) PublishObject(address string, retained bool, object interface{}, encryptionKey *ecdsa.PublicKey) error {
+	signer.sequences.setSequence(address, object)
 	// payload, err := json.Marshal(object)
 	payload, err := json.MarshalIndent(object, " ", " ")
 	if err != nil || object == nil {
@@ -68,23 +120,92 @@ func (signer *MessageSigner) PublishObject(address string, retained bool, object
 	return err
 }
 
+// SetDeadLetterHandler sets the handler that is invoked with the raw received messages that are
+// rejected by their subscription handler, eg because they fail signature or schema checks, together
+// with the reason. Use nil to remove the handler.
+func (signer *MessageSigner) SetDeadLetterHandler(handler DeadLetterHandler) {
+	signer.deadLetterHandler = handler
+}
+
+// SetPreDeliverHook sets the hook that is invoked with received messages before they are passed to
+// the subscriber. The hook can drop the message or modify it. The message is still signed and possibly
+// encrypted, so modifying a signed message invalidates its signature. Use nil to remove the hook.
+func (signer *MessageSigner) SetPreDeliverHook(hook MessageHook) {
+	signer.preDeliverHook = hook
+}
+
+// SetPrePublishHook sets the hook that is invoked with the payload of messages before they are signed
+// and published. The hook can drop the message or modify it. Use nil to remove the hook.
+func (signer *MessageSigner) SetPrePublishHook(hook MessageHook) {
+	signer.prePublishHook = hook
+}
+
+// SetPrivateKey replaces the private key used for signing and decryption, eg after the identity of
+// the publisher is renewed. Messages that are published concurrently use either the old or the new key.
+func (signer *MessageSigner) SetPrivateKey(privateKey *ecdsa.PrivateKey) {
+	signer.keyMutex.Lock()
+	defer signer.keyMutex.Unlock()
+	signer.privateKey = privateKey
+}
+
 // SetSignMessages enables or disables message signing. Intended for testing.
 func (signer *MessageSigner) SetSignMessages(sign bool) {
 	signer.signMessages = sign
 }
 
 // Subscribe to messages on the given address
+// Received messages are passed through the pre-deliver hook, if set, before they are passed to the handler.
+// A panic in the handler is recovered and reported through the handler guard. While the guard is
+// draining, received messages are refused. Messages that the handler rejects are passed to the
+// dead letter handler, if set.
+// Messages are passed to the handler in the order they are delivered by the messenger.
 func (signer *MessageSigner) Subscribe(
 	address string,
 	handler func(address string, message string) error) {
-	signer.messenger.Subscribe(address, handler)
+	signer.handlersMutex.Lock()
+	handlers := signer.handlers[address]
+	// copy on write as deliverMessage uses the list outside the lock
+	newHandlers := make([]func(address string, message string) error, 0, len(handlers)+1)
+	newHandlers = append(newHandlers, handlers...)
+	signer.handlers[address] = append(newHandlers, handler)
+	signer.handlersMutex.Unlock()
+	if len(handlers) == 0 {
+		signer.messenger.Subscribe(address, func(rxAddress string, message string) error {
+			return signer.deliverMessage(address, rxAddress, message)
+		})
+	}
 }
 
 // Unsubscribe to messages on the given address
+// If handler is nil then all handlers of the address are removed.
 func (signer *MessageSigner) Unsubscribe(
 	address string,
 	handler func(address string, message string) error) {
-	signer.messenger.Unsubscribe(address, handler)
+	signer.handlersMutex.Lock()
+	isRemoved := false
+	remaining := make([]func(address string, message string) error, 0)
+	for _, subscribed := range signer.handlers[address] {
+		if (handler == nil || !isRemoved) && isSameHandler(handler, subscribed) {
+			isRemoved = true
+			continue
+		}
+		remaining = append(remaining, subscribed)
+	}
+	if len(remaining) > 0 {
+		signer.handlers[address] = remaining
+	} else {
+		delete(signer.handlers, address)
+	}
+	signer.handlersMutex.Unlock()
+	if isRemoved && len(remaining) == 0 {
+		signer.messenger.Unsubscribe(address, nil)
+	}
+}
+
+// ClearRetained removes the retained publication on the given address by publishing an empty retained message.
+// The empty message is not signed as it carries no content.
+func (signer *MessageSigner) ClearRetained(address string) error {
+	return signer.messenger.Publish(address, true, "")
 }
 
 // PublishEncrypted sign and encrypts the payload and publish the resulting message on the given address
@@ -92,10 +213,17 @@ func (signer *MessageSigner) Unsubscribe(
 func (signer *MessageSigner) PublishEncrypted(
 	address string, retained bool, payload string, publicKey *ecdsa.PublicKey) error {
 	var err error
+	payload, allow := signer.applyPrePublishHook(address, payload)
+	if !allow {
+		return nil
+	}
+	if !signer.publishLimiter.AllowAddress(address) {
+		return fmt.Errorf("PublishEncrypted: publication on %s is rejected: %w", address, ErrPublishRateExceeded)
+	}
 	message := payload
 	// first sign, then encrypt as per RFC
-	if signer.signMessages {
-		message, _ = CreateJWSSignature(string(payload), signer.privateKey)
+	if signer.isSignedAddress(address) {
+		message, _ = CreateJWSSignature(string(payload), signer.getPrivateKey())
 	}
 	emessage, err := EncryptMessage(message, publicKey)
 	err = signer.messenger.Publish(address, retained, emessage)
@@ -104,15 +232,23 @@ func (signer *MessageSigner) PublishEncrypted(
 
 // PublishSigned sign the payload and publish the resulting message on the given address
 // Signing only happens if the publisher's signingMethod is set to SigningMethodJWS
+// and the message type of the address isn't opted out of signing.
 func (signer *MessageSigner) PublishSigned(
 	address string, retained bool, payload string) error {
 	var err error
+	payload, allow := signer.applyPrePublishHook(address, payload)
+	if !allow {
+		return nil
+	}
+	if !signer.publishLimiter.AllowAddress(address) {
+		return fmt.Errorf("PublishSigned: publication on %s is rejected: %w", address, ErrPublishRateExceeded)
+	}
 
 	// default is unsigned
 	message := payload
 
-	if signer.signMessages {
-		message, err = CreateJWSSignature(string(payload), signer.privateKey)
+	if signer.isSignedAddress(address) {
+		message, err = CreateJWSSignature(string(payload), signer.getPrivateKey())
 		if err != nil {
 			logrus.Errorf("Publisher.publishMessage: Error signing message for address %s: %s", address, err)
 		}
@@ -121,6 +257,62 @@ func (signer *MessageSigner) PublishSigned(
 	return err
 }
 
+// deliverMessage passes a received message to the handlers that are subscribed to the address
+//  address is the subscribed address
+//  rxAddress is the address the message is received on
+func (signer *MessageSigner) deliverMessage(address string, rxAddress string, message string) error {
+	hook := signer.preDeliverHook
+	if hook != nil {
+		allow, modified := hook(rxAddress, message)
+		if !allow {
+			logrus.Infof("MessageSigner.Subscribe: message on %s dropped by pre-deliver hook", rxAddress)
+			return nil
+		}
+		message = modified
+	}
+	signer.handlersMutex.Lock()
+	handlers := signer.handlers[address]
+	signer.handlersMutex.Unlock()
+
+	var firstErr error
+	for _, handler := range handlers {
+		handler := handler
+		err := signer.handlerGuard.InvokeMessage(rxAddress, func() error {
+			return handler(rxAddress, message)
+		})
+		deadLetterHandler := signer.deadLetterHandler
+		if err != nil && err != ErrDraining && deadLetterHandler != nil {
+			deadLetterHandler(NewDeadLetter(rxAddress, message, err))
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// getPrivateKey returns the current private key for signing and decryption
+func (signer *MessageSigner) getPrivateKey() *ecdsa.PrivateKey {
+	signer.keyMutex.RLock()
+	defer signer.keyMutex.RUnlock()
+	return signer.privateKey
+}
+
+// applyPrePublishHook passes the payload through the pre-publish hook if set
+// This returns the payload to publish and whether publication is allowed
+func (signer *MessageSigner) applyPrePublishHook(address string, payload string) (string, bool) {
+	hook := signer.prePublishHook
+	if hook == nil {
+		return payload, true
+	}
+	allow, modified := hook(address, payload)
+	if !allow {
+		logrus.Infof("MessageSigner.applyPrePublishHook: publication on %s dropped by pre-publish hook", address)
+		return payload, false
+	}
+	return modified, true
+}
+
 // NewMessageSigner creates a new instance for signing and verifying published messages
 // If getPublicKey is not provided, verification of signature is skipped
 func NewMessageSigner(messenger IMessenger, signingKey *ecdsa.PrivateKey,
@@ -128,10 +320,19 @@ func NewMessageSigner(messenger IMessenger, signingKey *ecdsa.PrivateKey,
 ) *MessageSigner {
 
 	signer := &MessageSigner{
-		GetPublicKey: getPublicKey,
-		messenger:    messenger,
-		signMessages: true,
-		privateKey:   signingKey, // private key for signing
+		GetPublicKey:   getPublicKey,
+		messenger:      messenger,
+		signMessages:   true,
+		privateKey:     signingKey, // private key for signing
+		keyMutex:       &sync.RWMutex{},
+		handlers:       make(map[string][]func(address string, message string) error),
+		handlersMutex:  &sync.Mutex{},
+		handlerGuard:   NewHandlerGuard(),
+		publishLimiter: NewPublishRateLimiter(0),
+		receiveStats:   NewReceiveStats(),
+		sequences:      NewSequenceNumbers(),
+
+		unsignedTypes: make(map[types.MessageType]bool),
 	}
 	return signer
 }
@@ -164,19 +365,13 @@ func SignIdentity(publicIdent *types.PublisherIdentityMessage, privKey *ecdsa.Pr
 	publicIdent.IdentitySignature = sigStr
 }
 
-// CreateJWSSignature signs the payload using JSE ES256 and return the JSE compact serialized message
+// CreateJWSSignature signs the payload using JWS and return the JWS compact serialized message
+// The algorithm is determined by the curve of the key, eg ES256 for P-256 and ES384 for P-384.
 func CreateJWSSignature(payload string, privateKey *ecdsa.PrivateKey) (string, error) {
-	joseSigner, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: privateKey}, nil)
-	if err != nil {
-		return "", err
-	}
-	signedObject, err := joseSigner.Sign([]byte(payload))
-	if err != nil {
-		return "", err
+	if privateKey == nil {
+		return "", errors.New("CreateJWSSignature: private key is nil")
 	}
-	// serialized := signedObject.FullSerialize()
-	serialized, err := signedObject.CompactSerialize()
-	return serialized, err
+	return CreateJWSSignatureWithKey(payload, privateKey)
 }
 
 // DecryptMessage deserializes and decrypts the message using JWE
@@ -210,6 +405,17 @@ func EncryptMessage(message string, publicKey *ecdsa.PublicKey) (serialized stri
 	return serialized, err
 }
 
+// JWSPayload returns the payload of a JWS signed message without verifying the signature.
+// If the message is not signed then the message itself is returned.
+// Use VerifySenderJWSSignature to verify the signature.
+func JWSPayload(message string) (payload string, isSigned bool) {
+	jwsSignature, err := jose.ParseSigned(message)
+	if err != nil {
+		return message, false
+	}
+	return string(jwsSignature.UnsafePayloadWithoutVerification()), true
+}
+
 // VerifyIdentitySignature verifies a base64URL encoded ECDSA256 signature in the identity
 // against the identity itself using the sender's public key.
 func VerifyIdentitySignature(ident *types.PublisherIdentityMessage, pubKey *ecdsa.PublicKey) error {
@@ -266,17 +472,13 @@ func VerifyJWSMessage(message string, publicKey *ecdsa.PublicKey) (payload strin
 		err := errors.New("VerifyJWSMessage: public key is nil")
 		return "", err
 	}
-	jwsSignature, err := jose.ParseSigned(message)
-	if err != nil {
-		return "", err
-	}
-	payloadB, err := jwsSignature.Verify(publicKey)
-	return string(payloadB), err
+	return VerifyJWSMessageWithKey(message, publicKey)
 }
 
 // VerifySenderJWSSignature verifies if a message is JWS signed. If signed then the signature is verified
-// using the 'Sender' or 'Address' attributes to determine the public key to verify with.
+// using the public key of the sender determined with MessageSender.
 // To verify correctly, the sender has to be a known publisher and verified with the DSS.
+// Messages that aren't signed by the claimed sender are rejected with an error that wraps ErrSpoofedSender.
 //  object MUST be a pointer to the type otherwise unmarshal fails.
 //
 // getPublicKey is a lookup function for providing the public key from the given sender address.
@@ -288,50 +490,63 @@ func VerifyJWSMessage(message string, publicKey *ecdsa.PublicKey) (payload strin
 //
 // This returns a flag if the message was signed and if so, an error if the verification failed
 func VerifySenderJWSSignature(rawMessage string, object interface{}, getPublicKey func(address string) *ecdsa.PublicKey) (isSigned bool, err error) {
+	isSigned, _, err = verifySenderJWSSignature(rawMessage, object, getPublicKey)
+	return isSigned, err
+}
+
+// verifySenderJWSSignature verifies the message signature and also returns the receive result
+// that is used to count received messages
+func verifySenderJWSSignature(rawMessage string, object interface{}, getPublicKey func(address string) *ecdsa.PublicKey) (isSigned bool, result ReceiveResult, err error) {
 
 	jwsSignature, err := jose.ParseSigned(rawMessage)
 	if err != nil {
 		// message is (probably) not signed, try to unmarshal it directly
 		err = json.Unmarshal([]byte(rawMessage), object)
-		return false, err
+		if err != nil {
+			return false, ReceiveResultRejectedSchema, err
+		}
+		return false, ReceiveResultUnsigned, err
 	}
 	payload := jwsSignature.UnsafePayloadWithoutVerification()
 	err = json.Unmarshal([]byte(payload), object)
 	if err != nil {
 		// message doesn't have a json payload
 		errTxt := fmt.Sprintf("VerifySenderSignature: Signature okay but message unmarshal failed: %s", err)
-		return true, errors.New(errTxt)
+		return true, ReceiveResultRejectedSchema, errors.New(errTxt)
 	}
 	// determine who the sender is
-	reflObject := reflect.ValueOf(object).Elem()
-	reflSender := reflObject.FieldByName("Sender")
-	if !reflSender.IsValid() {
-		reflSender = reflObject.FieldByName("Address")
-		if !reflSender.IsValid() {
-			err = errors.New("VerifySenderJWSSignature: object doesn't have a Sender or Address field")
-			return true, err
-		}
+	sender, err := MessageSender(object)
+	if err != nil {
+		return true, ReceiveResultRejectedSignature, err
 	}
-	sender := reflSender.String()
-	if sender == "" {
-		err := errors.New("VerifySenderJWSSignature: Missing sender or address information in message")
-		return true, err
+	err = verifySenderKeyID(jwsSignature, sender)
+	if err != nil {
+		logrus.Warningf("%s", err)
+		return true, ReceiveResultRejectedSpoofed, err
 	}
 	// verify the message signature using the sender's public key
 	if getPublicKey == nil {
-		return true, nil
+		return true, ReceiveResultVerified, nil
 	}
 	publicKey := getPublicKey(sender)
 	if publicKey == nil {
 		err := errors.New("VerifySenderJWSSignature: No public key available for sender " + sender)
-		return true, err
+		return true, ReceiveResultRejectedSignature, err
 	}
 
+	// the algorithm must match the sender's key to prevent algorithm confusion
+	err = VerifyJWSAlgorithm(jwsSignature, publicKey)
+	if err != nil {
+		return true, ReceiveResultRejectedSignature, err
+	}
+	// a signature that doesn't verify with the key of the published identity of the claimed sender
+	// is made by someone else or the message is modified
 	_, err = jwsSignature.Verify(publicKey)
 	if err != nil {
-		msg := fmt.Sprintf("VerifySenderJWSSignature: message signature from %s fails to verify with its public key", sender)
-		err := errors.New(msg)
-		return true, err
+		err := fmt.Errorf("VerifySenderJWSSignature: message signature from %s fails to verify with its public key: %w",
+			sender, ErrSpoofedSender)
+		logrus.Warningf("%s", err)
+		return true, ReceiveResultRejectedSpoofed, err
 	}
-	return true, err
+	return true, ReceiveResultVerified, err
 }
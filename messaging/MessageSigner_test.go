@@ -296,6 +296,57 @@ func TestSigner(t *testing.T) {
 	signer.Unsubscribe("test/+/#", nil)
 }
 
+func TestMessageHooks(t *testing.T) {
+	const payload1 = "payload 1"
+	const redacted = "redacted"
+	var received = ""
+	var rxCount = 0
+
+	config := messaging.MessengerConfig{}
+	messenger := messaging.NewDummyMessenger(&config)
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+	signer.Subscribe("test/+/#", func(address string, rawMessage string) error {
+		obj := TestObjectWithSender{}
+		_, _, err := signer.DecodeMessage(rawMessage, &obj)
+		assert.NoError(t, err)
+		received = obj.Field1
+		rxCount++
+		return nil
+	})
+
+	// modify the payload before it is signed
+	signer.SetPrePublishHook(func(address string, payload string) (bool, string) {
+		obj := TestObjectWithSender{}
+		json.Unmarshal([]byte(payload), &obj)
+		obj.Field1 = redacted
+		modified, _ := json.Marshal(obj)
+		return address != "test/bob/private", string(modified)
+	})
+	obj := TestObjectWithSender{Field1: payload1, Sender: "c'est moi"}
+	err := signer.PublishObject("test/bob/james", false, obj, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, redacted, received)
+	err = signer.PublishObject("test/bob/private", false, obj, &privKey.PublicKey)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, rxCount, "publication should be dropped")
+	signer.SetPrePublishHook(nil)
+
+	// drop received messages
+	signer.SetPreDeliverHook(func(address string, payload string) (bool, string) {
+		return address != "test/bob/dropped", payload
+	})
+	signer.PublishObject("test/bob/dropped", false, obj, nil)
+	assert.Equal(t, 1, rxCount, "message should not be delivered")
+	signer.PublishObject("test/bob/james", false, obj, nil)
+	assert.Equal(t, 2, rxCount)
+	assert.Equal(t, payload1, received)
+	signer.Unsubscribe("test/+/#", nil)
+}
+
 func TestSignIdentity(t *testing.T) {
 	dssKeys := messaging.CreateAsymKeys()
 	newIdent := types.PublisherFullIdentity{}
@@ -311,3 +362,37 @@ func TestSignIdentity(t *testing.T) {
 	err := messaging.VerifyIdentitySignature(&newIdent.PublisherIdentityMessage, &dssKeys.PublicKey)
 	assert.Nil(t, err)
 }
+
+func TestUnsubscribeHandler(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(nil)
+	signer := messaging.NewMessageSigner(messenger, messaging.CreateAsymKeys(), nil)
+	count1 := 0
+	count2 := 0
+	handler1 := func(address string, message string) error {
+		count1++
+		return nil
+	}
+	handler2 := func(address string, message string) error {
+		count2++
+		return nil
+	}
+	signer.Subscribe("test/#", handler1)
+	signer.Subscribe("test/#", handler2)
+	messenger.Publish("test/a", false, "msg1")
+	assert.Equal(t, 1, count1)
+	assert.Equal(t, 1, count2)
+
+	// only the given handler is removed
+	signer.Unsubscribe("test/#", handler1)
+	messenger.Publish("test/a", false, "msg2")
+	assert.Equal(t, 1, count1)
+	assert.Equal(t, 2, count2)
+
+	// the messenger is unsubscribed when no handlers remain
+	signer.Unsubscribe("test/#", handler2)
+	messenger.Publish("test/a", false, "msg3")
+	assert.Equal(t, 2, count2)
+	signer.Subscribe("test/#", handler1)
+	messenger.Publish("test/a", false, "msg4")
+	assert.Equal(t, 2, count1)
+}
@@ -2,16 +2,22 @@
 package messaging
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	pahomqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/iotdomain/iotdomain-go/lib/backoff"
 	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
 )
@@ -19,18 +25,50 @@ import (
 // ConnectionTimeoutSec constant with connection and reconnection timeouts
 const ConnectionTimeoutSec = 20
 
+// ConnectRetryMaxDelay is the max delay between attempts to connect to the broker
+const ConnectRetryMaxDelay = 120 * time.Second
+
 // TLSPort is the default secure port to connect to mqtt
 const TLSPort = 8883
 
+// Client ID suffix strategies
+const (
+	ClientIDSuffixNone   = "none"   // the client ID is used as is. Required for persistent sessions
+	ClientIDSuffixPID    = "pid"    // the process ID is appended, unique per running process on the host
+	ClientIDSuffixRandom = "random" // a random hex string is appended, unique per connection
+	ClientIDSuffixTime   = "time"   // the start time in seconds since epoch is appended
+)
+
+// PingAddress is the address of the QoS 1 publications that measure the round trip time to the broker.
+// The client ID is appended to the address.
+const PingAddress = "_ping"
+
+// PingInterval is the default interval of measuring the round trip time to the broker
+const PingInterval = ConnectionTimeoutSec * time.Second
+
+// subscribeFailure is the SUBACK return code of a subscription that is refused by the broker
+const subscribeFailure = 0x80
+
 // MqttMessenger that implements IMessenger
 type MqttMessenger struct {
+	clientID            string              // client ID with suffix, determined on the first connect
 	config              *MessengerConfig    // connect information
+	denials             *topicDenials       // operations denied by the broker
+	expiryTimer         *time.Timer         // expires the persistent session after the connection is lost
 	isRunning           bool                // listen for messages while running
+	lastWillAddress     string              // last will address of the connection
+	lastWillValue       string              // last will value of the connection
 	pahoClient          pahomqtt.Client     // Paho MQTT Client
+	pingInterval        time.Duration       // interval of measuring the round trip time, 0 to disable
 	subscriptions       []TopicSubscription // list of TopicSubscription for re-subscribing after reconnect
 	tlsVerifyServerCert bool                // verify the server certificate, this requires a Root CA signed cert
 	tlsCACertFile       string              // path to CA certificate
-	updateMutex         *sync.Mutex         // mutex for async updating of subscriptions
+	status              ConnectionStatus    // connection metrics
+	updateMutex         *sync.Mutex         // mutex for async updating of subscriptions and status
+
+	// optional authentication used by cloud broker adapters
+	credentials    pahomqtt.CredentialsProvider // provides username and password on each (re)connect
+	tlsClientCerts []tls.Certificate            // client certificates for mutual TLS
 }
 
 // TopicSubscription holds subscriptions to restore after disconnect
@@ -41,6 +79,80 @@ type TopicSubscription struct {
 	client  *MqttMessenger //
 }
 
+// Capabilities of the MQTT broker. Retained messages are supported unless disabled with noRetain in the config.
+func (messenger *MqttMessenger) Capabilities() MessengerCapabilities {
+	return MessengerCapabilities{Retained: !messenger.config.NoRetain}
+}
+
+// CheckTopicAccess verifies that the broker authorizes publishing and subscribing to addresses
+// MQTT 3.1.1 brokers don't acknowledge a denied publication, so each publication address is checked by
+// publishing an empty non-retained probe with QoS 1 on the PreflightSegment subtopic of the address and
+// waiting for it to be delivered to a subscription of the probe. Wildcards in publication addresses are
+// replaced with the PreflightSegment. Subscribers of the address itself don't receive the probe. Publication
+// addresses whose probe can't be subscribed to are only checked for an error of the publication.
+//  publish are the addresses to publish on
+//  subscribe are the addresses to subscribe to. Use nil to check the current subscriptions.
+//  timeout to wait for the broker to confirm an address. Use 0 for DefaultPreflightTimeout.
+// This returns the denials that are found, or an empty list if all addresses are authorized.
+func (messenger *MqttMessenger) CheckTopicAccess(publish []string, subscribe []string, timeout time.Duration) []TopicDenial {
+	if timeout <= 0 {
+		timeout = DefaultPreflightTimeout
+	}
+	denials := make([]TopicDenial, 0)
+	messenger.updateMutex.Lock()
+	pahoClient := messenger.pahoClient
+	subscriptions := make(map[string]pahomqtt.Token)
+	for _, subscription := range messenger.subscriptions {
+		subscriptions[subscription.address] = subscription.token
+	}
+	messenger.updateMutex.Unlock()
+	if pahoClient == nil || !pahoClient.IsConnected() {
+		logrus.Warningf("MqttMessenger.CheckTopicAccess: Unable to check topics. No connection with server.")
+		return denials
+	}
+	if subscribe == nil {
+		subscribe = make([]string, 0, len(subscriptions))
+		for address := range subscriptions {
+			subscribe = append(subscribe, address)
+		}
+	}
+
+	// the broker confirms each address separately so check them concurrently
+	resultMutex := &sync.Mutex{}
+	waitGroup := &sync.WaitGroup{}
+	addDenial := func(denial *TopicDenial) {
+		if denial != nil {
+			resultMutex.Lock()
+			denials = append(denials, *denial)
+			resultMutex.Unlock()
+		}
+	}
+	for _, address := range publish {
+		waitGroup.Add(1)
+		go func(address string) {
+			defer waitGroup.Done()
+			_, isSubscribed := subscriptions[MakePreflightAddress(address)]
+			addDenial(messenger.checkPublishAccess(pahoClient, address, isSubscribed, timeout))
+		}(address)
+	}
+	for _, address := range subscribe {
+		waitGroup.Add(1)
+		go func(address string) {
+			defer waitGroup.Done()
+			token, isSubscribed := subscriptions[address]
+			addDenial(messenger.checkSubscribeAccess(pahoClient, address, isSubscribed, token, timeout))
+		}(address)
+	}
+	waitGroup.Wait()
+	sort.Slice(denials, func(i, j int) bool {
+		if denials[i].Address != denials[j].Address {
+			return denials[i].Address < denials[j].Address
+		}
+		return denials[i].Operation < denials[j].Operation
+	})
+	return denials
+}
+
 // Connect to the MQTT broker and set the LWT
 // If a previous connection exists then it is disconnected first.
 // This publishes the LWT on the address baseTopic/nodeHWID/$state.
@@ -48,102 +160,35 @@ type TopicSubscription struct {
 //                       Use "" to ignore LWT feature.
 // @param lastWillValue to use as the last will
 func (messenger *MqttMessenger) Connect(lastWillAddress string, lastWillValue string) error {
-	config := messenger.config
-
 	// close existing connection
-	if messenger.pahoClient != nil && messenger.pahoClient.IsConnected() {
-		messenger.pahoClient.Disconnect(10 * ConnectionTimeoutSec)
+	messenger.updateMutex.Lock()
+	existingClient := messenger.pahoClient
+	// the client ID is kept when reconnecting
+	if messenger.clientID == "" {
+		messenger.clientID = MakeClientID(messenger.config.ClientID, messenger.config.ClientIDSuffix)
 	}
-
-	// set config defaults
-	// ClientID defaults to hostname-secondsSinceEpoc
-	hostName, _ := os.Hostname()
-	if config.ClientID == "" {
-		config.ClientID = fmt.Sprintf("%s-%d", hostName, time.Now().Unix())
+	messenger.lastWillAddress = lastWillAddress
+	messenger.lastWillValue = lastWillValue
+	messenger.updateMutex.Unlock()
+	if existingClient != nil && existingClient.IsConnected() {
+		existingClient.Disconnect(10 * ConnectionTimeoutSec)
 	}
-
-	// Connect using TLS
-	port := config.Port
-	if port == 0 {
-		port = TLSPort
+	if messenger.config.PersistentSession && getClientIDSuffix(messenger.config) != ClientIDSuffixNone {
+		logrus.Warningf("MqttMessenger.Connect: A persistent session requires a stable client ID. Use clientIdSuffix none.")
 	}
 
-	brokerURL := fmt.Sprintf("tls://%s:%d/", config.Server, port) // tcp://host:1883 ws://host:1883 tls://host:8883, tcps://awshost:8883/mqtt
-	// brokerURL := fmt.Sprintf("tls://mqtt.eclipse.org:8883/")
-	opts := pahomqtt.NewClientOptions()
-	opts.AddBroker(brokerURL)
-	opts.SetClientID(config.ClientID)
-	opts.SetAutoReconnect(true)
-	opts.SetConnectTimeout(10 * time.Second)
-	opts.SetMaxReconnectInterval(60 * time.Second) // max wait 1 minute for a reconnect
-	// Do not use MQTT persistence as not all brokers support it, and it causes problems on the broker if the client ID is
-	// randomly generated. CleanSession disables persistence.
-	opts.SetCleanSession(true)
-	opts.SetKeepAlive(ConnectionTimeoutSec * time.Second) // pings to detect a disconnect. Use same as reconnect interval
-	//opts.SetKeepAlive(60) // keepalive causes deadlock in v1.1.0. See github issue #126
-
-	opts.SetOnConnectHandler(func(client pahomqtt.Client) {
-		logrus.Warningf("MqttMessenger.onConnect: Connected to server at %s. Connected=%v. ClientId=%s",
-			brokerURL, client.IsConnected(), config.ClientID)
-		// Subscribe to addresss already registered by the app on connect or reconnect
-		messenger.resubscribe()
-	})
-	opts.SetConnectionLostHandler(func(client pahomqtt.Client, err error) {
-		log.Warningf("MqttMessenger.onConnectionLost: Disconnected from server %s. Error %s, ClientId=%s",
-			brokerURL, err, config.ClientID)
-	})
-	if lastWillAddress != "" {
-		opts.SetWill(lastWillAddress, lastWillValue, 1, false)
-	}
-	// Use TLS if a CA certificate is given
-	var rootCA *x509.CertPool
-	if messenger.tlsCACertFile != "" {
-		rootCA = x509.NewCertPool()
-		caFile, err := ioutil.ReadFile(messenger.tlsCACertFile)
-		if err != nil {
-			logrus.Errorf("MqttMessenger.Connect: Unable to read CA certificate chain: %s", err)
-		}
-		rootCA.AppendCertsFromPEM([]byte(caFile))
+	messenger.updateMutex.Lock()
+	messenger.status = ConnectionStatus{
+		Reconnects: messenger.status.Reconnects,
+		State:      ConnectionStateConnecting,
 	}
-	opts.SetTLSConfig(&tls.Config{
-		InsecureSkipVerify: !messenger.tlsVerifyServerCert,
-		RootCAs:            rootCA, // include the zcas cert in the host root ca set
-		// https://opium.io/blog/mqtt-in-go/
-		ServerName: "", // hostname on the server certificate. How to get this?
-	})
-
-	logrus.Infof("MqttMessenger.Connect: Connecting to MQTT server: %s with clientID %s"+
-		" AutoReconnect and CleanSession are set.",
-		brokerURL, config.ClientID)
-
-	// FIXME: PahoMqtt disconnects when sending a lot of messages, like on startup of some adapters.
-	messenger.pahoClient = pahomqtt.NewClient(opts)
-
 	// start listening for messages
 	messenger.isRunning = true
+	messenger.updateMutex.Unlock()
 	//go messenger.messageChanLoop()
 
-	// Auto reconnect doesn't work for initial attempt: https://github.com/eclipse/paho.mqtt.golang/issues/77
-	retryDelaySec := 1
-	for {
-		token := messenger.pahoClient.Connect()
-		token.Wait()
-		// Wait to give connection time to settle. Sending a lot of messages causes the connection to fail. Bug?
-		time.Sleep(1000 * time.Millisecond)
-		err := token.Error()
-		if err == nil {
-			break
-		}
-
-		logrus.Errorf("MqttMessenger.Connect: Connecting to broker on %s failed: %s. retrying in %d seconds.",
-			brokerURL, token.Error(), retryDelaySec)
-		time.Sleep(time.Duration(retryDelaySec) * time.Second)
-		// slowly increment wait time
-		if retryDelaySec < 120 {
-			retryDelaySec++
-		}
-	}
-	return nil
+	pahoClient := messenger.newPahoClient(!messenger.config.PersistentSession)
+	return messenger.connectClient(pahoClient)
 }
 
 // Disconnect from the MQTT broker and unsubscribe from all addresss and set
@@ -151,20 +196,69 @@ func (messenger *MqttMessenger) Connect(lastWillAddress string, lastWillValue st
 func (messenger *MqttMessenger) Disconnect() {
 	messenger.updateMutex.Lock()
 	messenger.isRunning = false
+	messenger.status.ConnectedSince = time.Time{}
+	messenger.status.State = ConnectionStateDisconnected
+	if messenger.expiryTimer != nil {
+		messenger.expiryTimer.Stop()
+		messenger.expiryTimer = nil
+	}
+	pahoClient := messenger.pahoClient
+	messenger.pahoClient = nil
 	messenger.updateMutex.Unlock()
 
-	if messenger.pahoClient != nil {
+	if pahoClient != nil {
 		logrus.Warningf("MqttMessenger.Disconnect: Set state to disconnected and close connection")
 		//messenger.publish("$state", "disconnected")
 		time.Sleep(time.Second / 10) // Disconnect doesn't seem to wait for all messages. A small delay ahead helps
-		messenger.pahoClient.Disconnect(10 * ConnectionTimeoutSec * 1000)
-		messenger.pahoClient = nil
+		pahoClient.Disconnect(10 * ConnectionTimeoutSec * 1000)
 
 		messenger.subscriptions = nil
 		//close(messenger.messageChannel)     // end the message handler loop
 	}
 }
 
+// GetConnectionStatus returns the state of the connection to the broker, the nr of reconnects, the
+// round trip time of the last ping and the last connection error
+func (messenger *MqttMessenger) GetConnectionStatus() ConnectionStatus {
+	messenger.updateMutex.Lock()
+	defer messenger.updateMutex.Unlock()
+	status := messenger.status
+	status.PingInterval = messenger.pingInterval
+	return status
+}
+
+// GetTopicDenials returns the publish and subscribe operations that are denied by the broker
+func (messenger *MqttMessenger) GetTopicDenials() []TopicDenial {
+	return messenger.denials.list()
+}
+
+// Ping measures the round trip time to the broker and updates the connection status
+// Paho doesn't expose its keep-alive pings, so the round trip is measured with the acknowledgement of a
+// QoS 1 publication on the PingAddress. This returns an error if not connected or the broker doesn't respond.
+func (messenger *MqttMessenger) Ping() (time.Duration, error) {
+	messenger.updateMutex.Lock()
+	pahoClient := messenger.pahoClient
+	messenger.updateMutex.Unlock()
+	if pahoClient == nil || !pahoClient.IsConnected() {
+		return 0, errors.New("MqttMessenger.Ping: no connection with server")
+	}
+	start := time.Now()
+	token := pahoClient.Publish(messenger.topicFromAddress(PingAddress+"/"+messenger.clientID), 1, false, "")
+	if !token.WaitTimeout(ConnectionTimeoutSec * time.Second) {
+		err := errors.New("MqttMessenger.Ping: no response from server")
+		messenger.setConnectionError("", err)
+		return 0, err
+	} else if token.Error() != nil {
+		messenger.setConnectionError("", token.Error())
+		return 0, token.Error()
+	}
+	rtt := time.Since(start)
+	messenger.updateMutex.Lock()
+	messenger.status.RTT = rtt
+	messenger.updateMutex.Unlock()
+	return rtt, nil
+}
+
 // Publish value using the device address as base
 // address to publish on.
 // retained to have the broker retain the address value
@@ -178,7 +272,7 @@ func (messenger *MqttMessenger) Publish(address string, retained bool, message s
 	}
 	logrus.Debugf("MqttMessenger.Publish []byte: address=%s, qos=%d, retained=%v",
 		address, messenger.config.PubQos, retained)
-	token := messenger.pahoClient.Publish(address, messenger.config.PubQos, retained, message)
+	token := messenger.pahoClient.Publish(messenger.topicFromAddress(address), messenger.config.PubQos, retained, message)
 
 	err = token.Error()
 	if err != nil {
@@ -197,7 +291,7 @@ func (messenger *MqttMessenger) PublishRaw(address string, retained bool, messag
 	}
 	// publication := Publication{Message: message}
 	// payload, err := json.Marshal(publication)
-	token := messenger.pahoClient.Publish(address, messenger.config.PubQos, retained, []byte(message))
+	token := messenger.pahoClient.Publish(messenger.topicFromAddress(address), messenger.config.PubQos, retained, []byte(message))
 
 	err := token.Error()
 	if err != nil {
@@ -208,12 +302,17 @@ func (messenger *MqttMessenger) PublishRaw(address string, retained bool, messag
 	return err
 }
 
+// SetOnTopicDenied sets the handler that is invoked when the broker denies an operation. Use nil to remove.
+func (messenger *MqttMessenger) SetOnTopicDenied(handler TopicDenialHandler) {
+	messenger.denials.setHandler(handler)
+}
+
 // Wrapper for message handling.
 // Use a channel to handle the message in a gorouting.
 // This fixes a problem with losing context in callbacks. Not sure what is going on though.
 func (subscription *TopicSubscription) onMessage(c pahomqtt.Client, msg pahomqtt.Message) {
 	// NOTE: Scope in this callback is not always retained. Pipe notifications through a channel and handle in goroutine
-	address := msg.Topic()
+	address := subscription.client.addressFromTopic(msg.Topic())
 	rawPayload := string(msg.Payload())
 
 	logrus.Infof("MqttMessenger.onMessage. address=%s, subscription=%s, retained=%v",
@@ -223,6 +322,254 @@ func (subscription *TopicSubscription) onMessage(c pahomqtt.Client, msg pahomqtt
 	//subscription.client.messageChannel <- message
 }
 
+// checkPublishAccess publishes a probe on the preflight address of a publication address and returns
+// the denial if the broker doesn't deliver it
+//  isSubscribed the preflight address is subscribed to by the application so the probe can't be received
+func (messenger *MqttMessenger) checkPublishAccess(
+	pahoClient pahomqtt.Client, address string, isSubscribed bool, timeout time.Duration) *TopicDenial {
+
+	probeTopic := messenger.topicFromAddress(MakePreflightAddress(address))
+	received := make(chan bool, 1)
+	canConfirm := false
+	if !isSubscribed {
+		subToken := pahoClient.Subscribe(probeTopic, 1, func(c pahomqtt.Client, msg pahomqtt.Message) {
+			select {
+			case received <- true:
+			default:
+			}
+		})
+		canConfirm = !isSubscribeRefused(subToken, timeout)
+		defer pahoClient.Unsubscribe(probeTopic)
+		if !canConfirm {
+			logrus.Warningf("MqttMessenger.checkPublishAccess: Unable to confirm publishing on %s. Subscribing to it is denied.", address)
+		}
+	}
+	pubToken := pahoClient.Publish(probeTopic, 1, false, "")
+	if !pubToken.WaitTimeout(timeout) {
+		denial := messenger.denials.add(TopicOperationPublish, address, "the broker doesn't acknowledge the publication")
+		return &denial
+	} else if pubToken.Error() != nil {
+		denial := messenger.denials.add(TopicOperationPublish, address, pubToken.Error().Error())
+		return &denial
+	} else if !canConfirm {
+		return nil
+	}
+	select {
+	case <-received:
+		return nil
+	case <-time.After(timeout):
+		denial := messenger.denials.add(TopicOperationPublish, address, "the broker doesn't deliver the publication")
+		return &denial
+	}
+}
+
+// checkSubscribeAccess returns the denial if the broker refuses a subscription to an address
+// Addresses that are subscribed to by the application are checked with the token of their subscription.
+// Other addresses are subscribed to and unsubscribed from after the broker acknowledges the subscription.
+func (messenger *MqttMessenger) checkSubscribeAccess(pahoClient pahomqtt.Client,
+	address string, isSubscribed bool, token pahomqtt.Token, timeout time.Duration) *TopicDenial {
+
+	if isSubscribed {
+		if token == nil || !isSubscribeRefused(token, timeout) {
+			return nil
+		}
+		// the denial is recorded when the subscription is acknowledged
+		if denial := messenger.denials.find(TopicOperationSubscribe, address); denial != nil {
+			return denial
+		}
+		return &TopicDenial{Address: address, Count: 1, LastTime: time.Now(),
+			Operation: TopicOperationSubscribe, Reason: "subscription refused by the broker"}
+	}
+	topic := messenger.topicFromAddress(address)
+	token = pahoClient.Subscribe(topic, messenger.config.SubQos, func(c pahomqtt.Client, msg pahomqtt.Message) {})
+	defer pahoClient.Unsubscribe(topic)
+	if !isSubscribeRefused(token, timeout) {
+		return nil
+	}
+	denial := messenger.denials.add(TopicOperationSubscribe, address, "subscription refused by the broker")
+	return &denial
+}
+
+// checkSubscribeToken records a denial if the broker refuses a subscription
+func (messenger *MqttMessenger) checkSubscribeToken(address string, token pahomqtt.Token) {
+	if isSubscribeRefused(token, ConnectionTimeoutSec*time.Second) {
+		messenger.denials.add(TopicOperationSubscribe, address, "subscription refused by the broker")
+	}
+}
+
+// connectClient connects the paho client, retrying until the connection is made or the client is replaced
+func (messenger *MqttMessenger) connectClient(pahoClient pahomqtt.Client) error {
+	// Auto reconnect doesn't work for initial attempt: https://github.com/eclipse/paho.mqtt.golang/issues/77
+	connectBackoff := backoff.NewBackoff(time.Second, ConnectRetryMaxDelay, 0)
+	return connectBackoff.Retry(context.Background(), func(attempt int) error {
+		messenger.updateMutex.Lock()
+		isCurrent := messenger.pahoClient == pahoClient
+		brokerURL := messenger.status.Server
+		messenger.updateMutex.Unlock()
+		if !isCurrent {
+			return nil
+		}
+		token := pahoClient.Connect()
+		token.Wait()
+		// Wait to give connection time to settle. Sending a lot of messages causes the connection to fail. Bug?
+		time.Sleep(1000 * time.Millisecond)
+		err := token.Error()
+		if err != nil {
+			logrus.Errorf("MqttMessenger.Connect: Connecting to broker on %s failed (attempt %d): %s. Retrying.",
+				brokerURL, attempt, err)
+			messenger.setConnectionError(ConnectionStateConnecting, err)
+		}
+		return err
+	})
+}
+
+// expireSession discards the persistent session of a connection that was lost for longer than the
+// session expiry. Connecting with a clean session discards the session on the broker, including the
+// queued messages, after which the messenger reconnects with a new persistent session.
+func (messenger *MqttMessenger) expireSession(expiredClient pahomqtt.Client) {
+	messenger.updateMutex.Lock()
+	isExpired := messenger.isRunning && messenger.pahoClient == expiredClient &&
+		messenger.status.State == ConnectionStateLost
+	messenger.updateMutex.Unlock()
+	if !isExpired {
+		return
+	}
+	logrus.Warningf("MqttMessenger.expireSession: Connection lost for more than %d seconds. Discarding the session.",
+		messenger.config.SessionExpiry)
+	expiredClient.Disconnect(0)
+	cleanClient := messenger.newPahoClient(true)
+	err := messenger.connectClient(cleanClient)
+
+	messenger.updateMutex.Lock()
+	isCurrent := messenger.isRunning && messenger.pahoClient == cleanClient
+	messenger.updateMutex.Unlock()
+	if err != nil || !isCurrent {
+		return
+	}
+	cleanClient.Disconnect(0)
+	messenger.connectClient(messenger.newPahoClient(false))
+}
+
+// newPahoClient creates a paho client with the messenger configuration and makes it the current client
+//  cleanSession to discard the session on the broker on connect and disconnect
+func (messenger *MqttMessenger) newPahoClient(cleanSession bool) pahomqtt.Client {
+	config := messenger.config
+	messenger.updateMutex.Lock()
+	clientID := messenger.clientID
+	lastWillAddress := messenger.lastWillAddress
+	lastWillValue := messenger.lastWillValue
+	messenger.updateMutex.Unlock()
+
+	// Connect using TLS
+	port := config.Port
+	if port == 0 {
+		port = TLSPort
+	}
+
+	brokerURL := fmt.Sprintf("tls://%s:%d/", config.Server, port) // tcp://host:1883 ws://host:1883 tls://host:8883, tcps://awshost:8883/mqtt
+	// brokerURL := fmt.Sprintf("tls://mqtt.eclipse.org:8883/")
+	opts := pahomqtt.NewClientOptions()
+	opts.AddBroker(brokerURL)
+	opts.SetClientID(clientID)
+	opts.SetAutoReconnect(true)
+	opts.SetConnectTimeout(10 * time.Second)
+	opts.SetMaxReconnectInterval(60 * time.Second) // max wait 1 minute for a reconnect
+	// MQTT persistence is disabled by default as not all brokers support it, and it causes problems on the broker if
+	// the client ID is randomly generated. A persistent session requires a stable client ID.
+	opts.SetCleanSession(cleanSession)
+	// deliver messages in order of arrival, as required by IMessenger
+	opts.SetOrderMatters(true)
+	opts.SetKeepAlive(ConnectionTimeoutSec * time.Second) // pings to detect a disconnect. Use same as reconnect interval
+	//opts.SetKeepAlive(60) // keepalive causes deadlock in v1.1.0. See github issue #126
+
+	opts.SetOnConnectHandler(func(client pahomqtt.Client) {
+		logrus.Warningf("MqttMessenger.onConnect: Connected to server at %s. Connected=%v. ClientId=%s",
+			brokerURL, client.IsConnected(), clientID)
+		messenger.updateMutex.Lock()
+		if messenger.status.State == ConnectionStateLost {
+			messenger.status.Reconnects++
+		}
+		if messenger.expiryTimer != nil {
+			messenger.expiryTimer.Stop()
+			messenger.expiryTimer = nil
+		}
+		messenger.status.ConnectedSince = time.Now()
+		messenger.status.State = ConnectionStateConnected
+		messenger.updateMutex.Unlock()
+		// Subscribe to addresss already registered by the app on connect or reconnect
+		messenger.resubscribe()
+	})
+	opts.SetConnectionLostHandler(func(client pahomqtt.Client, err error) {
+		log.Warningf("MqttMessenger.onConnectionLost: Disconnected from server %s. Error %s, ClientId=%s",
+			brokerURL, err, clientID)
+		messenger.setConnectionError(ConnectionStateLost, err)
+		// discard the persistent session if the connection isn't restored before the session expires
+		if !cleanSession && config.SessionExpiry > 0 {
+			messenger.updateMutex.Lock()
+			if messenger.expiryTimer != nil {
+				messenger.expiryTimer.Stop()
+			}
+			messenger.expiryTimer = time.AfterFunc(time.Duration(config.SessionExpiry)*time.Second, func() {
+				messenger.expireSession(client)
+			})
+			messenger.updateMutex.Unlock()
+		}
+	})
+	if lastWillAddress != "" {
+		opts.SetWill(messenger.topicFromAddress(lastWillAddress), lastWillValue, 1, false)
+	}
+	if messenger.credentials != nil {
+		opts.SetCredentialsProvider(messenger.credentials)
+	}
+	// Use TLS if a CA certificate is given
+	var rootCA *x509.CertPool
+	if messenger.tlsCACertFile != "" {
+		rootCA = x509.NewCertPool()
+		caFile, err := ioutil.ReadFile(messenger.tlsCACertFile)
+		if err != nil {
+			logrus.Errorf("MqttMessenger.Connect: Unable to read CA certificate chain: %s", err)
+		}
+		rootCA.AppendCertsFromPEM([]byte(caFile))
+	}
+	opts.SetTLSConfig(&tls.Config{
+		Certificates:       messenger.tlsClientCerts,
+		InsecureSkipVerify: !messenger.tlsVerifyServerCert,
+		RootCAs:            rootCA, // include the zcas cert in the host root ca set
+		// https://opium.io/blog/mqtt-in-go/
+		ServerName: "", // hostname on the server certificate. How to get this?
+	})
+
+	logrus.Infof("MqttMessenger.Connect: Connecting to MQTT server: %s with clientID %s"+
+		" AutoReconnect is set. CleanSession=%v.",
+		brokerURL, clientID, cleanSession)
+
+	// FIXME: PahoMqtt disconnects when sending a lot of messages, like on startup of some adapters.
+	pahoClient := pahomqtt.NewClient(opts)
+	messenger.updateMutex.Lock()
+	messenger.pahoClient = pahoClient
+	messenger.status.Server = brokerURL
+	messenger.updateMutex.Unlock()
+	if messenger.pingInterval > 0 {
+		go messenger.pingLoop(pahoClient, messenger.pingInterval)
+	}
+	return pahoClient
+}
+
+// pingLoop measures the round trip time to the broker until the client is disconnected or replaced
+func (messenger *MqttMessenger) pingLoop(pahoClient pahomqtt.Client, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		messenger.updateMutex.Lock()
+		isCurrent := messenger.isRunning && messenger.pahoClient == pahoClient
+		messenger.updateMutex.Unlock()
+		if !isCurrent {
+			return
+		} else if pahoClient.IsConnected() {
+			messenger.Ping()
+		}
+	}
+}
+
 // subscribe to addresss after establishing connection
 // The application can already subscribe to addresss before the connection is established. If connection is lost then
 // this will re-subscribe to those addresss as PahoMqtt drops the subscriptions after disconnect.
@@ -233,23 +580,39 @@ func (messenger *MqttMessenger) resubscribe() {
 	defer messenger.updateMutex.Unlock()
 
 	logrus.Infof("MqttMessenger.resubscribe to %d addresess", len(messenger.subscriptions))
-	for _, subscription := range messenger.subscriptions {
+	for index, subscription := range messenger.subscriptions {
 		// clear existing subscription
-		messenger.pahoClient.Unsubscribe(subscription.address)
+		messenger.pahoClient.Unsubscribe(messenger.topicFromAddress(subscription.address))
 
 		logrus.Infof("MqttMessenger.resubscribe: address %s", subscription.address)
 		// create a new variable to hold the subscription in the closure
 		newSubscr := subscription
-		token := messenger.pahoClient.Subscribe(newSubscr.address, messenger.config.PubQos, newSubscr.onMessage)
+		token := messenger.pahoClient.Subscribe(
+			messenger.topicFromAddress(newSubscr.address), messenger.config.PubQos, newSubscr.onMessage)
 		//token := messenger.pahoClient.Subscribe(newSubscr.address, newSubscr.qos, func (c pahomqtt.Client, msg pahomqtt.Message) {
 		//logrus.Infof("mqtt.resubscribe.onMessage: address %s, subscription %s", msg.Topic(), newSubscr.address)
 		//newSubscr.onMessage(c, msg)
 		//})
-		newSubscr.token = token
+		messenger.subscriptions[index].token = token
+		go messenger.checkSubscribeToken(newSubscr.address, token)
 	}
 	logrus.Infof("MqttMessenger.resubscribe complete")
 }
 
+// setConnectionError records a connection error
+//  state is the new connection state. Use "" to keep the current state.
+func (messenger *MqttMessenger) setConnectionError(state ConnectionState, err error) {
+	messenger.updateMutex.Lock()
+	defer messenger.updateMutex.Unlock()
+	if state != "" {
+		messenger.status.State = state
+	}
+	if err != nil {
+		messenger.status.LastError = err.Error()
+		messenger.status.LastErrorTime = time.Now()
+	}
+}
+
 // Subscribe to a address
 // Subscribers are automatically resubscribed after the connection is restored
 // If no connection exists, then subscriptions are stored until a connection is established.
@@ -271,44 +634,120 @@ func (messenger *MqttMessenger) Subscribe(
 	logrus.Infof("MqttMessenger.Subscribe: address %s, qos %d", address, messenger.config.SubQos)
 	//messenger.pahoClient.Subscribe(address, qos, addressSubscription.onMessage) //func(c pahomqtt.Client, msg pahomqtt.Message) {
 	if messenger.pahoClient != nil {
-		messenger.pahoClient.Subscribe(address, messenger.config.SubQos, subscription.onMessage) //func(c pahomqtt.Client, msg pahomqtt.Message) {
+		token := messenger.pahoClient.Subscribe(messenger.topicFromAddress(address), messenger.config.SubQos, subscription.onMessage) //func(c pahomqtt.Client, msg pahomqtt.Message) {
+		messenger.subscriptions[len(messenger.subscriptions)-1].token = token
+		go messenger.checkSubscribeToken(address, token)
 	}
 	// return nil
 }
 
+// addressFromTopic returns the address of a topic by removing the configured topic prefix
+func (messenger *MqttMessenger) addressFromTopic(topic string) string {
+	return strings.TrimPrefix(topic, messenger.topicPrefix())
+}
+
+// topicFromAddress returns the broker topic of an address by adding the configured topic prefix
+func (messenger *MqttMessenger) topicFromAddress(address string) string {
+	return messenger.topicPrefix() + address
+}
+
+// topicPrefix returns the configured topic prefix, ending with a '/' if set
+func (messenger *MqttMessenger) topicPrefix() string {
+	prefix := messenger.config.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
 // Unsubscribe an address and handler
 // if handler is nil then only the address needs to match
 func (messenger *MqttMessenger) Unsubscribe(
 	address string, onMessage func(address string, message string) error) {
-	// messenger.publishMutex.Lock()
-	var onMessageID = onMessage
-	// onMessageStr := fmt.Sprintf("%v", &onMessage)
-	for i, sub := range messenger.subscriptions {
-		// can't compare addresses directly so convert to string
-		// handlerStr := fmt.Sprintf("%v", &sub.handler)
-		var handlerID = sub.handler
-		// if sub.address == address && handlerStr == onMessageStr {
-		if sub.address == address && (onMessage == nil || &onMessageID == &handlerID) {
-			// shift remainder left one index
-			if i < len(messenger.subscriptions) {
-				copy(messenger.subscriptions[i:], messenger.subscriptions[i+1:])
-			}
-			messenger.subscriptions = messenger.subscriptions[:len(messenger.subscriptions)-1]
-			if onMessage != nil {
-				break
-			}
+	messenger.updateMutex.Lock()
+	defer messenger.updateMutex.Unlock()
+	isRemoved := false
+	hasRemaining := false
+	remaining := make([]TopicSubscription, 0, len(messenger.subscriptions))
+	for _, sub := range messenger.subscriptions {
+		if sub.address == address && (onMessage == nil || !isRemoved) && isSameHandler(onMessage, sub.handler) {
+			isRemoved = true
+			continue
+		} else if sub.address == address {
+			hasRemaining = true
 		}
+		remaining = append(remaining, sub)
+	}
+	messenger.subscriptions = remaining
+	// the broker keeps delivering messages until the last handler of the address is removed
+	if isRemoved && !hasRemaining && messenger.pahoClient != nil {
+		logrus.Infof("MqttMessenger.Unsubscribe: address %s", address)
+		messenger.pahoClient.Unsubscribe(messenger.topicFromAddress(address))
+	}
+}
+
+// MakeClientID returns the client ID to connect with
+//  clientID is the configured client ID. Use "" to use the hostname.
+//  suffix is the suffix strategy: none, pid, random or time. Use "" for time if no client ID is
+//   configured and none if it is. Unknown strategies are treated as none.
+func MakeClientID(clientID string, suffix string) string {
+	suffix = getClientIDSuffix(&MessengerConfig{ClientID: clientID, ClientIDSuffix: suffix})
+	if clientID == "" {
+		clientID, _ = os.Hostname()
+	}
+	switch suffix {
+	case ClientIDSuffixPID:
+		return fmt.Sprintf("%s-%d", clientID, os.Getpid())
+	case ClientIDSuffixRandom:
+		randomBytes := make([]byte, 4)
+		rand.Read(randomBytes)
+		return fmt.Sprintf("%s-%s", clientID, hex.EncodeToString(randomBytes))
+	case ClientIDSuffixTime:
+		return fmt.Sprintf("%s-%d", clientID, time.Now().Unix())
+	}
+	return clientID
+}
+
+// isSubscribeRefused waits for the broker to acknowledge a subscription and returns true if it is refused
+// Brokers refuse a subscription with the SUBACK return code 0x80. Subscriptions that aren't acknowledged
+// within the timeout, eg due to a lost connection, are not considered refused.
+func isSubscribeRefused(token pahomqtt.Token, timeout time.Duration) bool {
+	if !token.WaitTimeout(timeout) || token.Error() != nil {
+		return false
+	}
+	subToken, ok := token.(*pahomqtt.SubscribeToken)
+	if !ok {
+		return false
+	}
+	for _, code := range subToken.Result() {
+		if code == subscribeFailure {
+			return true
+		}
+	}
+	return false
+}
+
+// getClientIDSuffix returns the client ID suffix strategy of a configuration
+// The default is time if no client ID is configured and none if it is.
+func getClientIDSuffix(config *MessengerConfig) string {
+	if config.ClientIDSuffix != "" {
+		return config.ClientIDSuffix
+	} else if config.ClientID == "" {
+		return ClientIDSuffixTime
 	}
-	// messenger.publishMutex.Unlock()
+	return ClientIDSuffixNone
 }
 
 // NewMqttMessenger creates a new MQTT messenger instance
 func NewMqttMessenger(config *MessengerConfig) *MqttMessenger {
 	messenger := &MqttMessenger{
 		config:     config,
+		denials:    newTopicDenials(),
 		pahoClient: nil,
 		//messageChannel: make(chan *IncomingMessage),
+		pingInterval:        PingInterval,
 		tlsCACertFile:       "/etc/mosquitto/certs/zcas_ca.crt",
+		status:              ConnectionStatus{State: ConnectionStateDisconnected},
 		tlsVerifyServerCert: true,
 		updateMutex:         &sync.Mutex{},
 	}
@@ -2,6 +2,9 @@ package messaging_test
 
 import (
 	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -108,3 +111,20 @@ func TestPublishSubscribe(t *testing.T) {
 
 	assert.Equal(t, "bob", receivedMessage.Name, "Did not receive published message")
 }
+
+func TestMakeClientID(t *testing.T) {
+	hostname, _ := os.Hostname()
+	// without a client ID the hostname with the start time is used
+	clientID := messaging.MakeClientID("", "")
+	assert.True(t, strings.HasPrefix(clientID, hostname+"-"))
+	assert.Equal(t, hostname, messaging.MakeClientID("", messaging.ClientIDSuffixNone))
+
+	// configured client IDs are stable unless a suffix is set
+	assert.Equal(t, "pub1", messaging.MakeClientID("pub1", ""))
+	assert.Equal(t, fmt.Sprintf("pub1-%d", os.Getpid()), messaging.MakeClientID("pub1", messaging.ClientIDSuffixPID))
+	random1 := messaging.MakeClientID("pub1", messaging.ClientIDSuffixRandom)
+	random2 := messaging.MakeClientID("pub1", messaging.ClientIDSuffixRandom)
+	assert.Len(t, random1, len("pub1-")+8)
+	assert.NotEqual(t, random1, random2)
+	assert.Equal(t, "pub1", messaging.MakeClientID("pub1", "unknown"))
+}
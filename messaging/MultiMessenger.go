@@ -0,0 +1,177 @@
+// Package messaging - Messenger that fans out publications to multiple messengers
+package messaging
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MessengerFilter determines if a publication on the given address is sent to a messenger
+type MessengerFilter func(address string) bool
+
+// filteredMessenger is a messenger with its publication filter
+type filteredMessenger struct {
+	messenger IMessenger
+	filter    MessengerFilter // nil to publish everything
+}
+
+// MultiMessenger that implements IMessenger and passes messages to multiple messengers.
+// Intended for gateways that publish locally and uplink a subset to a cloud broker.
+// Each messenger has an optional filter for publications. Subscriptions apply to all messengers,
+// so a message received on more than one messenger is passed to the handler for each of them.
+type MultiMessenger struct {
+	messengers    []filteredMessenger
+	subscriptions []Subscription // subscriptions to apply to added messengers
+	updateMutex   *sync.Mutex    // mutex for concurrent adding of messengers and subscriptions
+}
+
+// AddMessenger adds a messenger to publish to.
+//  filter determines which publications are sent to this messenger. Use nil to send all publications.
+// Existing subscriptions are added to the messenger. The messenger must be connected separately if
+// the MultiMessenger is already connected.
+func (multi *MultiMessenger) AddMessenger(messenger IMessenger, filter MessengerFilter) {
+	multi.updateMutex.Lock()
+	defer multi.updateMutex.Unlock()
+	multi.messengers = append(multi.messengers, filteredMessenger{messenger: messenger, filter: filter})
+	for _, sub := range multi.subscriptions {
+		messenger.Subscribe(sub.address, sub.handler)
+	}
+}
+
+// Capabilities supported by all messengers
+func (multi *MultiMessenger) Capabilities() MessengerCapabilities {
+	capabilities := MessengerCapabilities{Retained: true}
+	for _, fm := range multi.getMessengers() {
+		capabilities.Retained = capabilities.Retained && GetCapabilities(fm.messenger).Retained
+	}
+	return capabilities
+}
+
+// Connect all messengers. This returns the first error, if any
+func (multi *MultiMessenger) Connect(lastWillAddress string, lastWillValue string) error {
+	var firstErr error
+	for _, fm := range multi.getMessengers() {
+		err := fm.messenger.Connect(lastWillAddress, lastWillValue)
+		if err != nil {
+			logrus.Errorf("MultiMessenger.Connect: %s", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Disconnect gracefully disconnects all messengers
+func (multi *MultiMessenger) Disconnect() {
+	for _, fm := range multi.getMessengers() {
+		fm.messenger.Disconnect()
+	}
+}
+
+// Publish the message on all messengers whose filter accepts the address.
+// This returns the first error, if any
+func (multi *MultiMessenger) Publish(address string, retained bool, message string) error {
+	var firstErr error
+	for _, fm := range multi.getMessengers() {
+		if fm.filter != nil && !fm.filter(address) {
+			continue
+		}
+		err := fm.messenger.Publish(address, retained, message)
+		if err != nil {
+			logrus.Errorf("MultiMessenger.Publish: address %s: %s", address, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Subscribe to the address on all messengers
+func (multi *MultiMessenger) Subscribe(
+	address string, onMessage func(address string, message string) error) {
+
+	multi.updateMutex.Lock()
+	multi.subscriptions = append(multi.subscriptions, Subscription{address: address, handler: onMessage})
+	messengers := multi.messengers
+	multi.updateMutex.Unlock()
+
+	for _, fm := range messengers {
+		fm.messenger.Subscribe(address, onMessage)
+	}
+}
+
+// Unsubscribe the handler from the address on all messengers
+// If onMessage is nil then all handlers of the address are removed.
+func (multi *MultiMessenger) Unsubscribe(
+	address string, onMessage func(address string, message string) error) {
+
+	multi.updateMutex.Lock()
+	remaining := make([]Subscription, 0, len(multi.subscriptions))
+	isRemoved := false
+	for _, sub := range multi.subscriptions {
+		if sub.address == address && (onMessage == nil || !isRemoved) && isSameHandler(onMessage, sub.handler) {
+			isRemoved = true
+			continue
+		}
+		remaining = append(remaining, sub)
+	}
+	multi.subscriptions = remaining
+	messengers := multi.messengers
+	multi.updateMutex.Unlock()
+
+	for _, fm := range messengers {
+		fm.messenger.Unsubscribe(address, onMessage)
+	}
+}
+
+// getMessengers returns the current list of messengers
+func (multi *MultiMessenger) getMessengers() []filteredMessenger {
+	multi.updateMutex.Lock()
+	defer multi.updateMutex.Unlock()
+	return multi.messengers
+}
+
+// MatchAddress tests if an address matches a subscription address with '+' and '#' wildcards
+func MatchAddress(address string, subscription string) bool {
+	subscriptionSegments := strings.Split(subscription, "/")
+	addressSegments := strings.Split(address, "/")
+
+	for index, subscriptionSegment := range subscriptionSegments {
+		if subscriptionSegment == "#" {
+			return true
+		} else if index >= len(addressSegments) {
+			return false
+		} else if subscriptionSegment != "+" && subscriptionSegment != addressSegments[index] {
+			return false
+		}
+	}
+	return len(subscriptionSegments) == len(addressSegments)
+}
+
+// NewAddressFilter returns a messenger filter that accepts addresses that match one of the
+// given subscription addresses, eg "domain/+/+/temperature/#"
+func NewAddressFilter(subscriptions ...string) MessengerFilter {
+	return func(address string) bool {
+		for _, subscription := range subscriptions {
+			if MatchAddress(address, subscription) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// NewMultiMessenger creates a messenger that passes messages to multiple messengers.
+// Use AddMessenger to add the messengers.
+func NewMultiMessenger() *MultiMessenger {
+	multi := &MultiMessenger{
+		messengers:    make([]filteredMessenger, 0),
+		subscriptions: make([]Subscription, 0),
+		updateMutex:   &sync.Mutex{},
+	}
+	return multi
+}
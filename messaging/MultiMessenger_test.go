@@ -0,0 +1,78 @@
+package messaging_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiMessenger(t *testing.T) {
+	const localAddr = "domain1/pub1/node1/$node"
+	const cloudAddr = "domain1/pub1/node1/temperature/0/$latest"
+	var rxCount = 0
+
+	local := messaging.NewDummyMessenger(&dummyConfig)
+	cloud := messaging.NewDummyMessenger(&dummyConfig)
+	multi := messaging.NewMultiMessenger()
+	multi.AddMessenger(local, nil)
+	multi.Subscribe("domain1/#", func(address string, message string) error {
+		rxCount++
+		return nil
+	})
+	// subscriptions are added to new messengers
+	multi.AddMessenger(cloud, messaging.NewAddressFilter("+/+/+/temperature/#"))
+	err := multi.Connect("", "")
+	assert.NoError(t, err)
+
+	// only matching publications are sent to the cloud
+	err = multi.Publish(localAddr, false, "local")
+	assert.NoError(t, err)
+	err = multi.Publish(cloudAddr, false, "both")
+	assert.NoError(t, err)
+	assert.Equal(t, "local", local.FindLastPublication(localAddr))
+	assert.Empty(t, cloud.FindLastPublication(localAddr))
+	assert.Equal(t, "both", cloud.FindLastPublication(cloudAddr))
+	assert.Equal(t, 3, rxCount)
+
+	multi.Unsubscribe("domain1/#", nil)
+	multi.Publish(cloudAddr, false, "both")
+	assert.Equal(t, 3, rxCount)
+	multi.Disconnect()
+}
+
+func TestMultiMessengerUnsubscribeHandler(t *testing.T) {
+	const address1 = "domain1/pub1/node1/$node"
+	local := messaging.NewDummyMessenger(&dummyConfig)
+	cloud := messaging.NewDummyMessenger(&dummyConfig)
+	multi := messaging.NewMultiMessenger()
+	multi.AddMessenger(local, nil)
+	count1 := 0
+	count2 := 0
+	handler1 := func(address string, message string) error {
+		count1++
+		return nil
+	}
+	handler2 := func(address string, message string) error {
+		count2++
+		return nil
+	}
+	multi.Subscribe("domain1/#", handler1)
+	multi.Subscribe("domain1/#", handler2)
+
+	// only the given handler is removed, also from messengers that are added later
+	multi.Unsubscribe("domain1/#", handler2)
+	multi.AddMessenger(cloud, nil)
+	multi.Publish(address1, false, "msg1")
+	assert.Equal(t, 2, count1)
+	assert.Equal(t, 0, count2)
+}
+
+func TestMatchAddress(t *testing.T) {
+	assert.True(t, messaging.MatchAddress("a/b/c", "a/b/c"))
+	assert.True(t, messaging.MatchAddress("a/b/c", "a/+/c"))
+	assert.True(t, messaging.MatchAddress("a/b/c", "a/#"))
+	assert.False(t, messaging.MatchAddress("a/b/c", "a/+"))
+	assert.False(t, messaging.MatchAddress("a/b", "a/b/c"))
+	assert.False(t, messaging.MatchAddress("a/b/c", "a/c/#"))
+}
@@ -6,6 +6,7 @@ package messaging
 //    MQTTMessenger, requires server, login and credentials properties set
 //
 // config holds the messenger configuration. If no server is given, 'localhost' will be used.
+// Use NewMultiMessenger to combine multiple messengers.
 func NewMessenger(messengerConfig *MessengerConfig) IMessenger {
 	var m IMessenger
 
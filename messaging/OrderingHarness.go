@@ -0,0 +1,77 @@
+// Package messaging - Test harness for verifying per-address ordered delivery of messages
+package messaging
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// OrderingHarness verifies that messages on the same address are delivered to handlers in
+// publish order. It publishes numbered messages per address and records deliveries that
+// are out of order. The messages can be signed; the sequence nr is read from the payload.
+// Intended for testing messengers and delivery pipelines.
+type OrderingHarness struct {
+	published   map[string]int // last published sequence nr by address
+	received    map[string]int // last received sequence nr by address
+	violations  []string       // description of out of order deliveries
+	updateMutex *sync.Mutex    // mutex for async delivery
+}
+
+// Handler records a delivered message. Use it as the subscription handler.
+// The message payload must be a sequence nr published by this harness.
+func (harness *OrderingHarness) Handler(address string, message string) error {
+	payload, _ := JWSPayload(message)
+	seq, err := strconv.Atoi(payload)
+	if err != nil {
+		return fmt.Errorf("OrderingHarness.Handler: message on %s is not a sequence nr: %s", address, err)
+	}
+	harness.updateMutex.Lock()
+	defer harness.updateMutex.Unlock()
+	prevSeq := harness.received[address]
+	if seq != prevSeq+1 {
+		harness.violations = append(harness.violations,
+			fmt.Sprintf("address %s: received %d after %d", address, seq, prevSeq))
+	}
+	if seq > prevSeq {
+		harness.received[address] = seq
+	}
+	return nil
+}
+
+// Publish publishes the next sequence nr on the address using the given publish function,
+// eg messenger.Publish or signer.PublishSigned.
+func (harness *OrderingHarness) Publish(address string,
+	publish func(address string, retained bool, message string) error) error {
+
+	harness.updateMutex.Lock()
+	harness.published[address]++
+	seq := harness.published[address]
+	harness.updateMutex.Unlock()
+	return publish(address, false, strconv.Itoa(seq))
+}
+
+// Received returns the highest received sequence nr on the address
+func (harness *OrderingHarness) Received(address string) int {
+	harness.updateMutex.Lock()
+	defer harness.updateMutex.Unlock()
+	return harness.received[address]
+}
+
+// Violations returns the out of order deliveries
+func (harness *OrderingHarness) Violations() []string {
+	harness.updateMutex.Lock()
+	defer harness.updateMutex.Unlock()
+	return append([]string{}, harness.violations...)
+}
+
+// NewOrderingHarness creates a harness for verifying ordered delivery
+func NewOrderingHarness() *OrderingHarness {
+	harness := &OrderingHarness{
+		published:   make(map[string]int),
+		received:    make(map[string]int),
+		violations:  make([]string, 0),
+		updateMutex: &sync.Mutex{},
+	}
+	return harness
+}
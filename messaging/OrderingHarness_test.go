@@ -0,0 +1,67 @@
+package messaging_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+const orderingMessageCount = 100
+
+// publish messages on multiple addresses concurrently and check they are delivered in order per address
+func checkOrderedDelivery(t *testing.T, subscribe func(address string, handler func(string, string) error),
+	publish func(address string, retained bool, message string) error) {
+
+	harness := messaging.NewOrderingHarness()
+	subscribe("test/#", harness.Handler)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		address := fmt.Sprintf("test/publisher1/node%d/temperature/0/$latest", i)
+		wg.Add(1)
+		go func() {
+			for n := 0; n < orderingMessageCount; n++ {
+				harness.Publish(address, publish)
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	assert.Empty(t, harness.Violations())
+	assert.Equal(t, orderingMessageCount, harness.Received("test/publisher1/node0/temperature/0/$latest"))
+}
+
+func TestOrderedDelivery(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	checkOrderedDelivery(t, func(address string, handler func(string, string) error) {
+		messenger.Subscribe(address, handler)
+	}, messenger.Publish)
+
+	// signed messages through the message signer
+	messenger = messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	signer := messaging.NewMessageSigner(messenger, messaging.CreateAsymKeys(), nil)
+	checkOrderedDelivery(t, func(address string, handler func(string, string) error) {
+		signer.Subscribe(address, handler)
+	}, signer.PublishSigned)
+
+	// fan out through multiple messengers
+	multi := messaging.NewMultiMessenger()
+	multi.AddMessenger(messaging.NewDummyMessenger(&messaging.MessengerConfig{}), nil)
+	checkOrderedDelivery(t, func(address string, handler func(string, string) error) {
+		multi.Subscribe(address, handler)
+	}, multi.Publish)
+}
+
+func TestOrderingHarness(t *testing.T) {
+	const address1 = "test/publisher1/node1/$event"
+	harness := messaging.NewOrderingHarness()
+	harness.Handler(address1, "1")
+	harness.Handler(address1, "3")
+	harness.Handler(address1, "2")
+	assert.Len(t, harness.Violations(), 2)
+	assert.Equal(t, 3, harness.Received(address1))
+	err := harness.Handler(address1, "not a number")
+	assert.Error(t, err)
+}
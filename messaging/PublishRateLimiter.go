@@ -0,0 +1,104 @@
+// Package messaging with limiting of the rate of publications
+package messaging
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// ErrPublishRateExceeded is the error of publications that are rejected because the max publish
+// rate is reached. Use errors.Is to test for it.
+var ErrPublishRateExceeded = errors.New("max publish rate exceeded")
+
+// rateExemptTypes are the control plane message types that are never rejected by the publish rate
+// limit. Dropped identity, status or discovery publications are not retried so consumers would miss them.
+// The nr of nodes and outputs is limited by their own quotas.
+var rateExemptTypes = map[types.MessageType]bool{
+	types.MessageTypeIdentity:        true,
+	types.MessageTypeInputDiscovery:  true,
+	types.MessageTypeNodeDiscovery:   true,
+	types.MessageTypeOutputDiscovery: true,
+	types.MessageTypeStatus:          true,
+}
+
+// PublishRateLimiter limits the nr of publications per second
+// Publications that exceed the rate are rejected, not delayed, so a runaway publisher can't flood
+// the message bus.
+type PublishRateLimiter struct {
+	count       int         // nr of publications in the current second
+	lastRate    int         // nr of publications in the previous second
+	maxRate     int         // max nr of publications per second. 0 is unlimited
+	rejected    int         // nr of rejected publications
+	updateMutex *sync.Mutex // mutex for concurrent publications
+	windowStart time.Time   // start of the current second
+}
+
+// Allow returns true if a publication is allowed within the max rate and counts it
+func (limiter *PublishRateLimiter) Allow() bool {
+	limiter.updateMutex.Lock()
+	defer limiter.updateMutex.Unlock()
+
+	now := time.Now()
+	if elapsed := now.Sub(limiter.windowStart); elapsed >= time.Second {
+		if elapsed >= 2*time.Second {
+			limiter.lastRate = 0
+		} else {
+			limiter.lastRate = limiter.count
+		}
+		limiter.count = 0
+		limiter.windowStart = now
+	}
+	if limiter.maxRate > 0 && limiter.count >= limiter.maxRate {
+		limiter.rejected++
+		return false
+	}
+	limiter.count++
+	return true
+}
+
+// AllowAddress returns true if a publication on an address is allowed within the max rate and counts it
+// Publications of control plane message types are always allowed.
+func (limiter *PublishRateLimiter) AllowAddress(address string) bool {
+	if rateExemptTypes[MessageTypeFromAddress(address)] {
+		return true
+	}
+	return limiter.Allow()
+}
+
+// GetRate returns the nr of publications in the previous second and the total nr of rejected publications
+func (limiter *PublishRateLimiter) GetRate() (rate int, rejected int) {
+	limiter.updateMutex.Lock()
+	defer limiter.updateMutex.Unlock()
+	if time.Now().Sub(limiter.windowStart) >= 2*time.Second {
+		return 0, limiter.rejected
+	}
+	return limiter.lastRate, limiter.rejected
+}
+
+// MaxRate returns the max nr of publications per second. 0 is unlimited.
+func (limiter *PublishRateLimiter) MaxRate() int {
+	limiter.updateMutex.Lock()
+	defer limiter.updateMutex.Unlock()
+	return limiter.maxRate
+}
+
+// SetMaxRate sets the max nr of publications per second. Use 0 for unlimited.
+func (limiter *PublishRateLimiter) SetMaxRate(maxRate int) {
+	limiter.updateMutex.Lock()
+	defer limiter.updateMutex.Unlock()
+	limiter.maxRate = maxRate
+}
+
+// NewPublishRateLimiter creates a limiter of the nr of publications per second
+//  maxRate is the max nr of publications per second. Use 0 for unlimited.
+func NewPublishRateLimiter(maxRate int) *PublishRateLimiter {
+	limiter := &PublishRateLimiter{
+		maxRate:     maxRate,
+		updateMutex: &sync.Mutex{},
+		windowStart: time.Now(),
+	}
+	return limiter
+}
@@ -0,0 +1,41 @@
+package messaging_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishRateLimiter(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(nil)
+	signer := messaging.NewMessageSigner(messenger, messaging.CreateAsymKeys(), nil)
+	signer.PublishLimiter().SetMaxRate(2)
+	assert.Equal(t, 2, signer.PublishLimiter().MaxRate())
+
+	assert.NoError(t, signer.PublishSigned("dom1/pub1/node1/$event", false, "1"))
+	assert.NoError(t, signer.PublishSigned("dom1/pub1/node2/$event", false, "2"))
+	err := signer.PublishSigned("dom1/pub1/node3/$event", false, "3")
+	assert.True(t, errors.Is(err, messaging.ErrPublishRateExceeded))
+	err = signer.PublishEncrypted("dom1/pub1/node4/$event", false, "4", &messaging.CreateAsymKeys().PublicKey)
+	assert.True(t, errors.Is(err, messaging.ErrPublishRateExceeded))
+	assert.Equal(t, 2, messenger.NrPublications())
+	// control plane publications are not rejected
+	assert.NoError(t, signer.PublishSigned("dom1/pub1/$identity", true, "{}"))
+	assert.NoError(t, signer.PublishSigned("dom1/pub1/node1/$node", true, "{}"))
+	assert.Equal(t, 4, messenger.NrPublications())
+
+	// publications are allowed again in the next second
+	time.Sleep(1100 * time.Millisecond)
+	assert.NoError(t, signer.PublishSigned("dom1/pub1/node1/$event", false, "5"))
+	rate, rejected := signer.PublishLimiter().GetRate()
+	assert.Equal(t, 2, rate)
+	assert.Equal(t, 2, rejected)
+
+	signer.PublishLimiter().SetMaxRate(0)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, signer.PublishSigned("dom1/pub1/node1/$event", false, "6"))
+	}
+}
@@ -0,0 +1,106 @@
+// Package messaging - Statistics of received messages by message type and sender
+package messaging
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ReceiveResult is the outcome of receiving a message
+type ReceiveResult string
+
+// Receive results that are counted
+const (
+	ReceiveResultDroppedDuplicate  ReceiveResult = "droppedDuplicate"  // verified message dropped by the receiver as duplicate or replay
+	ReceiveResultRejectedSchema    ReceiveResult = "rejectedSchema"    // message payload doesn't match the expected message
+	ReceiveResultRejectedSignature ReceiveResult = "rejectedSignature" // message signature is missing sender or its key
+	ReceiveResultRejectedSpoofed   ReceiveResult = "rejectedSpoofed"   // message isn't signed by the sender it claims
+	ReceiveResultUnsigned          ReceiveResult = "unsigned"          // message without signature
+	ReceiveResultVerified          ReceiveResult = "verified"          // message signature verified
+)
+
+// ReceiveStatsRecord with the counters of received messages of a message type from a sender
+type ReceiveStatsRecord struct {
+	MessageType string                `json:"messageType"` // message type from the message address, eg $latest
+	Sender      string                `json:"sender"`      // domain/publisherID of the sender
+	Counts      map[ReceiveResult]int `json:"counts"`      // nr of messages by receive result
+}
+
+// ReceiveStats tracks the counters of received messages by message type and sender
+type ReceiveStats struct {
+	records     map[string]*ReceiveStatsRecord // records by messageType and sender
+	updateMutex *sync.Mutex                    // mutex for async updating of counters
+}
+
+// GetReceiveStats returns a copy of the receive counters sorted by message type and sender
+func (stats *ReceiveStats) GetReceiveStats() []ReceiveStatsRecord {
+	stats.updateMutex.Lock()
+	defer stats.updateMutex.Unlock()
+	list := make([]ReceiveStatsRecord, 0, len(stats.records))
+	for _, record := range stats.records {
+		recordCopy := *record
+		recordCopy.Counts = make(map[ReceiveResult]int)
+		for result, count := range record.Counts {
+			recordCopy.Counts[result] = count
+		}
+		list = append(list, recordCopy)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].MessageType != list[j].MessageType {
+			return list[i].MessageType < list[j].MessageType
+		}
+		return list[i].Sender < list[j].Sender
+	})
+	return list
+}
+
+// Reset clears all counters
+func (stats *ReceiveStats) Reset() {
+	stats.updateMutex.Lock()
+	defer stats.updateMutex.Unlock()
+	stats.records = make(map[string]*ReceiveStatsRecord)
+}
+
+// Update increments the counter of a received message
+//  address is the message address or the sender address. The message type is its last segment.
+//  sender is the sender address. The domain/publisherID of the sender is used.
+func (stats *ReceiveStats) Update(address string, sender string, result ReceiveResult) {
+	messageType := ""
+	if segments := strings.Split(address, "/"); len(segments) > 1 {
+		messageType = segments[len(segments)-1]
+	}
+	if segments := strings.Split(sender, "/"); len(segments) > 2 {
+		sender = segments[0] + "/" + segments[1]
+	}
+	key := messageType + " " + sender
+
+	stats.updateMutex.Lock()
+	defer stats.updateMutex.Unlock()
+	record := stats.records[key]
+	if record == nil {
+		record = &ReceiveStatsRecord{
+			MessageType: messageType,
+			Sender:      sender,
+			Counts:      make(map[ReceiveResult]int),
+		}
+		stats.records[key] = record
+	}
+	record.Counts[result]++
+}
+
+// updateFromObject increments the counter of a received message using the address and sender
+// fields of the decoded message object
+func (stats *ReceiveStats) updateFromObject(object interface{}, result ReceiveResult) {
+	address, sender := messageAddressAndSender(object)
+	stats.Update(address, sender, result)
+}
+
+// NewReceiveStats creates a new collection of receive counters
+func NewReceiveStats() *ReceiveStats {
+	stats := &ReceiveStats{
+		records:     make(map[string]*ReceiveStatsRecord),
+		updateMutex: &sync.Mutex{},
+	}
+	return stats
+}
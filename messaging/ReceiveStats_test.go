@@ -0,0 +1,71 @@
+package messaging_test
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceiveStats(t *testing.T) {
+	const setAddress = "test/publisher1/node1/switch/0/$set"
+	privKey := messaging.CreateAsymKeys()
+	otherKey := messaging.CreateAsymKeys()
+	signer := messaging.NewMessageSigner(messaging.NewDummyMessenger(&messaging.MessengerConfig{}),
+		privKey, func(address string) *ecdsa.PublicKey {
+			if address == "test/publisher2/$identity" {
+				return &privKey.PublicKey
+			}
+			return &otherKey.PublicKey
+		})
+	stats := signer.ReceiveStats()
+
+	// verified message from publisher2
+	payload, _ := json.Marshal(TestObjectWithSender{Field1: "hi", Sender: "test/publisher2/$identity"})
+	signed, _ := messaging.CreateJWSSignature(string(payload), privKey)
+	var received TestObjectWithSender
+	_, err := signer.VerifySignedMessage(signed, &received)
+	assert.NoError(t, err)
+
+	// message with an address that fails signature verification
+	payload, _ = json.Marshal(TestObjectNoSender{Address: setAddress})
+	signed, _ = messaging.CreateJWSSignature(string(payload), privKey)
+	var received2 TestObjectNoSender
+	_, err = signer.VerifySignedMessage(signed, &received2)
+	assert.Error(t, err)
+
+	// payload that doesn't match the message
+	signed, _ = messaging.CreateJWSSignature(`{"address": 42}`, privKey)
+	var received3 TestObjectNoSender
+	_, err = signer.VerifySignedMessage(signed, &received3)
+	assert.Error(t, err)
+
+	// unsigned message and a duplicate reported by the receiver
+	_, _, err = signer.DecodeMessage(string(payload), &received2)
+	assert.NoError(t, err)
+	stats.Update(setAddress, setAddress, messaging.ReceiveResultDroppedDuplicate)
+
+	records := signer.ReceiveStats().GetReceiveStats()
+	require.Equal(t, 3, len(records))
+	// sorted by message type. Messages without address have no message type.
+	assert.Equal(t, "", records[0].MessageType)
+	assert.Equal(t, "", records[0].Sender)
+	assert.Equal(t, 1, records[0].Counts[messaging.ReceiveResultRejectedSchema])
+	assert.Equal(t, "test/publisher2", records[1].Sender)
+	assert.Equal(t, 1, records[1].Counts[messaging.ReceiveResultVerified])
+	assert.Equal(t, "$set", records[2].MessageType)
+	assert.Equal(t, "test/publisher1", records[2].Sender)
+	assert.Equal(t, 1, records[2].Counts[messaging.ReceiveResultRejectedSpoofed])
+	assert.Equal(t, 1, records[2].Counts[messaging.ReceiveResultUnsigned])
+	assert.Equal(t, 1, records[2].Counts[messaging.ReceiveResultDroppedDuplicate])
+
+	// the records are copies
+	records[1].Counts[messaging.ReceiveResultVerified] = 10
+	assert.Equal(t, 1, stats.GetReceiveStats()[1].Counts[messaging.ReceiveResultVerified])
+
+	stats.Reset()
+	assert.Equal(t, 0, len(stats.GetReceiveStats()))
+}
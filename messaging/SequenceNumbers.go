@@ -0,0 +1,151 @@
+// Package messaging with sequence numbers of publications for detecting missed messages
+package messaging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib/atomicfile"
+	"github.com/sirupsen/logrus"
+)
+
+// SequenceField is the name of the field of message objects that holds the sequence nr of the publication
+const SequenceField = "Sequence"
+
+// SequenceNumbers with the sequence nr of the last publication on each address
+// Message objects with a Sequence field are published with the next sequence nr of their address, so
+// consumers can detect missed messages. The sequence nrs are saved to keep increasing after a restart.
+type SequenceNumbers struct {
+	sequences   map[string]uint64 // sequence nr of the last publication by address
+	updateCount int               // nr of publications since the sequence nrs were last saved
+	updateMutex *sync.Mutex       // mutex for async publishing
+}
+
+// Get returns the sequence nr of the last publication on an address, or 0 if nothing was published
+func (seqNumbers *SequenceNumbers) Get(address string) uint64 {
+	seqNumbers.updateMutex.Lock()
+	defer seqNumbers.updateMutex.Unlock()
+	return seqNumbers.sequences[address]
+}
+
+// LoadSequences loads the sequence nrs from file
+// Addresses that are published before loading keep the highest sequence nr.
+func (seqNumbers *SequenceNumbers) LoadSequences(filename string) error {
+	sequences := make(map[string]uint64)
+	jsonSequences, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("LoadSequences: Unable to open file %s: %s", filename, err)
+	}
+	err = json.Unmarshal(jsonSequences, &sequences)
+	if err != nil {
+		return fmt.Errorf("LoadSequences: Error parsing JSON sequences file %s: %v", filename, err)
+	}
+	logrus.Infof("LoadSequences: Sequence nrs loaded successfully from %s", filename)
+	seqNumbers.updateMutex.Lock()
+	defer seqNumbers.updateMutex.Unlock()
+	for address, sequence := range sequences {
+		if sequence > seqNumbers.sequences[address] {
+			seqNumbers.sequences[address] = sequence
+		}
+	}
+	return nil
+}
+
+// Next increments and returns the sequence nr of a publication on an address. The first is 1.
+func (seqNumbers *SequenceNumbers) Next(address string) uint64 {
+	seqNumbers.updateMutex.Lock()
+	defer seqNumbers.updateMutex.Unlock()
+	seqNumbers.sequences[address]++
+	seqNumbers.updateCount++
+	return seqNumbers.sequences[address]
+}
+
+// SaveSequences saves the sequence nrs to file
+func (seqNumbers *SequenceNumbers) SaveSequences(filename string) error {
+	seqNumbers.updateMutex.Lock()
+	jsonText, err := json.MarshalIndent(seqNumbers.sequences, "", "  ")
+	seqNumbers.updateCount = 0
+	seqNumbers.updateMutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("SaveSequences: Error Marshalling JSON sequences '%s': %v", filename, err)
+	}
+	// write atomically so a crash while saving doesn't lose the sequence nrs
+	err = atomicfile.WriteFile(filename, jsonText, 0664)
+	if err != nil {
+		return fmt.Errorf("SaveSequences: Error saving sequences to JSON file %s: %v", filename, err)
+	}
+	logrus.Infof("SaveSequences: Sequence nrs saved successfully to JSON file %s", filename)
+	return nil
+}
+
+// UpdateCount returns the nr of publications since the sequence nrs were last saved
+func (seqNumbers *SequenceNumbers) UpdateCount() int {
+	seqNumbers.updateMutex.Lock()
+	defer seqNumbers.updateMutex.Unlock()
+	return seqNumbers.updateCount
+}
+
+// setSequence sets the Sequence field of a message object to the next sequence nr of its address
+// Objects without a Sequence field are not changed.
+func (seqNumbers *SequenceNumbers) setSequence(address string, object interface{}) {
+	reflObject := reflect.ValueOf(object)
+	if reflObject.Kind() != reflect.Ptr || reflObject.Elem().Kind() != reflect.Struct {
+		return
+	}
+	reflSequence := reflObject.Elem().FieldByName(SequenceField)
+	if reflSequence.Kind() == reflect.Uint64 && reflSequence.CanSet() {
+		reflSequence.SetUint(seqNumbers.Next(address))
+	}
+}
+
+// SequenceTracker tracks the sequence nrs of received messages to detect missed messages
+type SequenceTracker struct {
+	received    map[string]uint64 // sequence nr of the last received message by address
+	updateMutex *sync.Mutex       // mutex for async receiving
+}
+
+// Update records the sequence nr of a received message and returns the nr of missed messages since
+// the last received message on the address.
+// The first message on an address, messages without sequence nr and messages with a sequence nr that
+// isn't higher than the last received, eg a duplicate or a publisher that lost its sequence nrs, are
+// not considered a gap.
+func (tracker *SequenceTracker) Update(address string, sequence uint64) (missed uint64) {
+	if sequence == 0 {
+		return 0
+	}
+	tracker.updateMutex.Lock()
+	defer tracker.updateMutex.Unlock()
+	last, found := tracker.received[address]
+	if found && sequence <= last {
+		if sequence < last {
+			logrus.Warningf("SequenceTracker.Update: Sequence nr %d on %s is lower than %d. Assuming a restart.",
+				sequence, address, last)
+			tracker.received[address] = sequence
+		}
+		return 0
+	}
+	tracker.received[address] = sequence
+	if !found {
+		return 0
+	}
+	return sequence - last - 1
+}
+
+// NewSequenceNumbers creates a new collection of sequence nrs of publications
+func NewSequenceNumbers() *SequenceNumbers {
+	return &SequenceNumbers{
+		sequences:   make(map[string]uint64),
+		updateMutex: &sync.Mutex{},
+	}
+}
+
+// NewSequenceTracker creates a tracker of the sequence nrs of received messages
+func NewSequenceTracker() *SequenceTracker {
+	return &SequenceTracker{
+		received:    make(map[string]uint64),
+		updateMutex: &sync.Mutex{},
+	}
+}
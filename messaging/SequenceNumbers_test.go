@@ -0,0 +1,55 @@
+package messaging_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sequencedMessage struct {
+	Address  string `json:"address"`
+	Sequence uint64 `json:"sequence,omitempty"`
+}
+
+func TestSequenceNumbers(t *testing.T) {
+	const address1 = "test/pub1/node1/switch/0/$latest"
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	filename := filepath.Join(tempFolder, "sequences.json")
+
+	// objects with a Sequence field get the next sequence nr of their address
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	signer := messaging.NewMessageSigner(messenger, messaging.CreateAsymKeys(), nil)
+	message := &sequencedMessage{Address: address1}
+	signer.PublishObject(address1, false, message, nil)
+	assert.Equal(t, uint64(1), message.Sequence)
+	signer.PublishObject(address1, false, message, nil)
+	assert.Equal(t, uint64(2), message.Sequence)
+	signer.PublishObject("test/pub1/$identity", false, &struct{ Address string }{}, nil)
+	sequences := signer.SequenceNumbers()
+	assert.Equal(t, uint64(2), sequences.Get(address1))
+	assert.Equal(t, 2, sequences.UpdateCount())
+
+	// sequence nrs continue after a restart
+	require.NoError(t, sequences.SaveSequences(filename))
+	assert.Zero(t, sequences.UpdateCount())
+	sequences2 := messaging.NewSequenceNumbers()
+	require.NoError(t, sequences2.LoadSequences(filename))
+	assert.Equal(t, uint64(3), sequences2.Next(address1))
+	assert.Error(t, sequences2.LoadSequences(filepath.Join(tempFolder, "notafile.json")))
+
+	// receivers detect missed messages
+	tracker := messaging.NewSequenceTracker()
+	assert.Zero(t, tracker.Update(address1, 5), "the first message isn't a gap")
+	assert.Zero(t, tracker.Update(address1, 6))
+	assert.Equal(t, uint64(3), tracker.Update(address1, 10))
+	assert.Zero(t, tracker.Update(address1, 10), "duplicate")
+	assert.Zero(t, tracker.Update(address1, 0), "without sequence nr")
+	assert.Zero(t, tracker.Update(address1, 2), "publisher restart")
+	assert.Equal(t, uint64(1), tracker.Update(address1, 4))
+}
@@ -0,0 +1,216 @@
+// Package messaging - Messenger that shares a single connection between multiple publishers
+package messaging
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sharedSubscription is a subscription of a client of the shared messenger
+type sharedSubscription struct {
+	client  *SharedMessengerClient
+	handler func(address string, message string) error
+}
+
+// SharedMessenger shares the connection of a messenger between multiple clients.
+// Intended for hosting multiple publishers in a single process. Each publisher uses its own client.
+// The connection is made when the first client connects and closed when the last client disconnects.
+// A connection has a single last will, so clients of a shared messenger don't have a last will.
+type SharedMessenger struct {
+	connectCount  int                              // nr of connected clients
+	messenger     IMessenger                       // the shared messenger
+	subscriptions map[string][]*sharedSubscription // client subscriptions by subscription address
+	updateMutex   *sync.Mutex                      // mutex for concurrent access by clients
+}
+
+// NewClient returns a new client of the shared messenger
+func (shared *SharedMessenger) NewClient() *SharedMessengerClient {
+	return &SharedMessengerClient{shared: shared}
+}
+
+// connect the shared messenger if this is the first connected client
+func (shared *SharedMessenger) connect() error {
+	shared.updateMutex.Lock()
+	shared.connectCount++
+	isFirst := shared.connectCount == 1
+	shared.updateMutex.Unlock()
+	if isFirst {
+		return shared.messenger.Connect("", "")
+	}
+	return nil
+}
+
+// disconnect the shared messenger if this is the last connected client
+func (shared *SharedMessenger) disconnect() {
+	shared.updateMutex.Lock()
+	if shared.connectCount == 0 {
+		shared.updateMutex.Unlock()
+		return
+	}
+	shared.connectCount--
+	isLast := shared.connectCount == 0
+	shared.updateMutex.Unlock()
+	if isLast {
+		shared.messenger.Disconnect()
+	}
+}
+
+// dispatch passes a received message to the client subscriptions of the subscription address
+func (shared *SharedMessenger) dispatch(subscription string, address string, message string) error {
+	shared.updateMutex.Lock()
+	subs := shared.subscriptions[subscription]
+	shared.updateMutex.Unlock()
+
+	var firstErr error
+	for _, sub := range subs {
+		err := sub.handler(address, message)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// subscribe a client to the address. The shared messenger subscribes once per address.
+func (shared *SharedMessenger) subscribe(client *SharedMessengerClient,
+	address string, onMessage func(address string, message string) error) {
+
+	shared.updateMutex.Lock()
+	subs := shared.subscriptions[address]
+	isNew := len(subs) == 0
+	// copy on write as dispatch uses the list outside the lock
+	newSubs := make([]*sharedSubscription, 0, len(subs)+1)
+	newSubs = append(newSubs, subs...)
+	shared.subscriptions[address] = append(newSubs, &sharedSubscription{client: client, handler: onMessage})
+	shared.updateMutex.Unlock()
+
+	if isNew {
+		shared.messenger.Subscribe(address, func(rxAddress string, message string) error {
+			return shared.dispatch(address, rxAddress, message)
+		})
+	}
+}
+
+// unsubscribe a client from the address. The shared messenger unsubscribes when no clients remain.
+//  onMessage is the handler to remove. Use nil to remove all subscriptions of the client on the address.
+func (shared *SharedMessenger) unsubscribe(client *SharedMessengerClient,
+	address string, onMessage func(address string, message string) error) {
+
+	shared.updateMutex.Lock()
+	subs := shared.subscriptions[address]
+	remaining := make([]*sharedSubscription, 0, len(subs))
+	removed := false
+	for _, sub := range subs {
+		if sub.client == client && (onMessage == nil || !removed) && isSameHandler(onMessage, sub.handler) {
+			removed = true
+			continue
+		}
+		remaining = append(remaining, sub)
+	}
+	isLast := len(subs) > 0 && len(remaining) == 0
+	if len(remaining) == 0 {
+		delete(shared.subscriptions, address)
+	} else {
+		shared.subscriptions[address] = remaining
+	}
+	shared.updateMutex.Unlock()
+
+	if isLast {
+		shared.messenger.Unsubscribe(address, nil)
+	}
+}
+
+// unsubscribeAll removes all subscriptions of a client
+func (shared *SharedMessenger) unsubscribeAll(client *SharedMessengerClient) {
+	shared.updateMutex.Lock()
+	addresses := make([]string, 0, len(shared.subscriptions))
+	for address := range shared.subscriptions {
+		addresses = append(addresses, address)
+	}
+	shared.updateMutex.Unlock()
+
+	for _, address := range addresses {
+		shared.unsubscribe(client, address, nil)
+	}
+}
+
+// SharedMessengerClient implements IMessenger for a client of a shared messenger
+type SharedMessengerClient struct {
+	isConnected bool // this client is connected
+	shared      *SharedMessenger
+}
+
+// Capabilities of the shared messenger
+func (client *SharedMessengerClient) Capabilities() MessengerCapabilities {
+	return GetCapabilities(client.shared.messenger)
+}
+
+// Close disconnects the client and removes its subscriptions
+// Intended for removing a publisher from the process.
+func (client *SharedMessengerClient) Close() {
+	client.Disconnect()
+	client.shared.unsubscribeAll(client)
+}
+
+// Connect the client. The shared messenger is connected when the first client connects.
+// The last will is ignored as the connection is shared.
+func (client *SharedMessengerClient) Connect(lastWillAddress string, lastWillValue string) error {
+	client.shared.updateMutex.Lock()
+	wasConnected := client.isConnected
+	client.isConnected = true
+	client.shared.updateMutex.Unlock()
+	if wasConnected {
+		return nil
+	}
+	if lastWillAddress != "" {
+		logrus.Infof("SharedMessengerClient.Connect: Last will on %s is not supported by a shared messenger", lastWillAddress)
+	}
+	return client.shared.connect()
+}
+
+// Disconnect the client. The shared messenger is disconnected when the last client disconnects.
+// Subscriptions remain until the client is closed.
+func (client *SharedMessengerClient) Disconnect() {
+	client.shared.updateMutex.Lock()
+	wasConnected := client.isConnected
+	client.isConnected = false
+	client.shared.updateMutex.Unlock()
+	if wasConnected {
+		client.shared.disconnect()
+	}
+}
+
+// GetConnectionStatus returns the connection status of the shared messenger
+func (client *SharedMessengerClient) GetConnectionStatus() ConnectionStatus {
+	return GetConnectionStatus(client.shared.messenger)
+}
+
+// Publish a message on the shared messenger
+func (client *SharedMessengerClient) Publish(address string, retained bool, message string) error {
+	return client.shared.messenger.Publish(address, retained, message)
+}
+
+// Subscribe to an address on the shared messenger
+func (client *SharedMessengerClient) Subscribe(
+	address string, onMessage func(address string, message string) error) {
+	client.shared.subscribe(client, address, onMessage)
+}
+
+// Unsubscribe the client from an address
+// If onMessage is nil then all subscriptions of this client with the address are removed
+func (client *SharedMessengerClient) Unsubscribe(
+	address string, onMessage func(address string, message string) error) {
+	client.shared.unsubscribe(client, address, onMessage)
+}
+
+// NewSharedMessenger creates a messenger whose connection is shared between clients.
+// Use NewClient to create a messenger for each publisher.
+func NewSharedMessenger(messenger IMessenger) *SharedMessenger {
+	shared := &SharedMessenger{
+		messenger:     messenger,
+		subscriptions: make(map[string][]*sharedSubscription),
+		updateMutex:   &sync.Mutex{},
+	}
+	return shared
+}
@@ -0,0 +1,47 @@
+package messaging_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedMessenger(t *testing.T) {
+	const addr1 = "domain1/pub1/node1/$node"
+	var rx1Count = 0
+	var rx2Count = 0
+
+	dummy := messaging.NewDummyMessenger(&dummyConfig)
+	shared := messaging.NewSharedMessenger(dummy)
+	client1 := shared.NewClient()
+	client2 := shared.NewClient()
+	assert.NoError(t, client1.Connect("domain1/pub1/$status", "lost"))
+	assert.NoError(t, client2.Connect("", ""))
+
+	client1.Subscribe("domain1/#", func(address string, message string) error {
+		rx1Count++
+		return nil
+	})
+	client2.Subscribe("domain1/#", func(address string, message string) error {
+		rx2Count++
+		return nil
+	})
+	err := client1.Publish(addr1, false, "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", dummy.FindLastPublication(addr1))
+	assert.Equal(t, 1, rx1Count)
+	assert.Equal(t, 1, rx2Count)
+
+	// unsubscribing one client doesn't affect the other
+	client1.Unsubscribe("domain1/#", nil)
+	client2.Publish(addr1, false, "hello")
+	assert.Equal(t, 1, rx1Count)
+	assert.Equal(t, 2, rx2Count)
+
+	// closed clients no longer receive messages
+	client2.Close()
+	client1.Publish(addr1, false, "hello")
+	assert.Equal(t, 2, rx2Count)
+	client1.Disconnect()
+}
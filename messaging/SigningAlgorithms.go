@@ -0,0 +1,103 @@
+// Package messaging with selection of the JWS signing algorithm from the signing key type
+package messaging
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// SupportedSigningAlgorithms are the JWS algorithms that are accepted for signed messages
+var SupportedSigningAlgorithms = []jose.SignatureAlgorithm{jose.ES256, jose.ES384, jose.ES512, jose.EdDSA}
+
+// CreateJWSSignatureWithKey signs the payload using the JWS algorithm that belongs to the key type and
+// returns the JWS compact serialized message.
+//  signingKey is an *ecdsa.PrivateKey with curve P-256, P-384 or P-521, or an ed25519.PrivateKey
+func CreateJWSSignatureWithKey(payload string, signingKey crypto.Signer) (string, error) {
+	algorithm, err := JWSAlgorithmForKey(signingKey)
+	if err != nil {
+		return "", err
+	}
+	joseSigner, err := jose.NewSigner(jose.SigningKey{Algorithm: algorithm, Key: signingKey}, nil)
+	if err != nil {
+		return "", err
+	}
+	signedObject, err := joseSigner.Sign([]byte(payload))
+	if err != nil {
+		return "", err
+	}
+	return signedObject.CompactSerialize()
+}
+
+// JWSAlgorithmForKey returns the JWS signature algorithm that belongs to the type of a public or private key.
+// The algorithm is determined by the key and never by the message so that a sender can't
+// downgrade or replace the algorithm, eg with "none" or a HMAC using the public key as secret.
+// This returns an error if the key type is not supported.
+func JWSAlgorithmForKey(key interface{}) (jose.SignatureAlgorithm, error) {
+	var curve elliptic.Curve
+	switch typedKey := key.(type) {
+	case *ecdsa.PrivateKey:
+		if typedKey == nil {
+			return "", errors.New("JWSAlgorithmForKey: key is nil")
+		}
+		curve = typedKey.Curve
+	case *ecdsa.PublicKey:
+		if typedKey == nil {
+			return "", errors.New("JWSAlgorithmForKey: key is nil")
+		}
+		curve = typedKey.Curve
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return jose.EdDSA, nil
+	default:
+		return "", fmt.Errorf("JWSAlgorithmForKey: unsupported key type %T", key)
+	}
+	switch curve {
+	case elliptic.P256():
+		return jose.ES256, nil
+	case elliptic.P384():
+		return jose.ES384, nil
+	case elliptic.P521():
+		return jose.ES512, nil
+	}
+	return "", fmt.Errorf("JWSAlgorithmForKey: unsupported ECDSA curve %s", curve.Params().Name)
+}
+
+// VerifyJWSAlgorithm verifies that a JWS message has a single signature whose algorithm is the
+// algorithm that belongs to the sender's public key.
+// This returns an error if the message uses a different algorithm, which includes "none".
+func VerifyJWSAlgorithm(jwsSignature *jose.JSONWebSignature, publicKey crypto.PublicKey) error {
+	expected, err := JWSAlgorithmForKey(publicKey)
+	if err != nil {
+		return err
+	}
+	if len(jwsSignature.Signatures) != 1 {
+		return fmt.Errorf("VerifyJWSAlgorithm: expected 1 signature, got %d", len(jwsSignature.Signatures))
+	}
+	algorithm := jwsSignature.Signatures[0].Header.Algorithm
+	if algorithm != string(expected) {
+		return fmt.Errorf("VerifyJWSAlgorithm: algorithm '%s' doesn't match the %s key of the sender",
+			algorithm, expected)
+	}
+	return nil
+}
+
+// VerifyJWSMessageWithKey verifies a signed message with a public key of any supported type and
+// returns its payload. The message must be signed with the algorithm that belongs to the key.
+//  publicKey is an *ecdsa.PublicKey with curve P-256, P-384 or P-521, or an ed25519.PublicKey
+func VerifyJWSMessageWithKey(message string, publicKey crypto.PublicKey) (payload string, err error) {
+	jwsSignature, err := jose.ParseSigned(message)
+	if err != nil {
+		return "", err
+	}
+	err = VerifyJWSAlgorithm(jwsSignature, publicKey)
+	if err != nil {
+		return "", err
+	}
+	payloadB, err := jwsSignature.Verify(publicKey)
+	return string(payloadB), err
+}
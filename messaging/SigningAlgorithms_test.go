@@ -0,0 +1,109 @@
+package messaging_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestSigningAlgorithms(t *testing.T) {
+	payload, _ := json.Marshal(testObject)
+	p256Keys := messaging.CreateAsymKeys()
+	p384Keys := messaging.CreateAsymKeysWithCurve(elliptic.P384())
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+
+	// the algorithm is determined by the key type
+	alg, err := messaging.JWSAlgorithmForKey(p256Keys)
+	assert.NoError(t, err)
+	assert.Equal(t, jose.ES256, alg)
+	alg, _ = messaging.JWSAlgorithmForKey(&p384Keys.PublicKey)
+	assert.Equal(t, jose.ES384, alg)
+	alg, _ = messaging.JWSAlgorithmForKey(edPub)
+	assert.Equal(t, jose.EdDSA, alg)
+
+	// sign and verify with each key type
+	sig256, err := messaging.CreateJWSSignature(string(payload), p256Keys)
+	require.NoError(t, err)
+	received, err := messaging.VerifyJWSMessage(sig256, &p256Keys.PublicKey)
+	assert.NoError(t, err)
+	assert.Equal(t, string(payload), received)
+	sig384, err := messaging.CreateJWSSignature(string(payload), p384Keys)
+	require.NoError(t, err)
+	_, err = messaging.VerifyJWSMessage(sig384, &p384Keys.PublicKey)
+	assert.NoError(t, err)
+	sigEd, err := messaging.CreateJWSSignatureWithKey(string(payload), edPriv)
+	require.NoError(t, err)
+	_, err = messaging.VerifyJWSMessageWithKey(sigEd, edPub)
+	assert.NoError(t, err)
+
+	// error case - signature with the algorithm of another key
+	_, err = messaging.VerifyJWSMessage(sig256, &p384Keys.PublicKey)
+	assert.Error(t, err)
+	_, err = messaging.VerifyJWSMessageWithKey(sigEd, &p256Keys.PublicKey)
+	assert.Error(t, err)
+
+	// error case - unsupported keys
+	_, err = messaging.JWSAlgorithmForKey("not a key")
+	assert.Error(t, err)
+	_, err = messaging.JWSAlgorithmForKey((*ecdsa.PublicKey)(nil))
+	assert.Error(t, err)
+	_, err = messaging.CreateJWSSignature(string(payload), nil)
+	assert.Error(t, err)
+}
+
+func TestMixedAlgorithmDomain(t *testing.T) {
+	const sender384 = "dom1.testpub384.$identity"
+	p256Keys := messaging.CreateAsymKeys()
+	p384Keys := messaging.CreateAsymKeysWithCurve(elliptic.P384())
+	getPublicKey := func(address string) *ecdsa.PublicKey {
+		if address == sender384 {
+			return &p384Keys.PublicKey
+		}
+		return &p256Keys.PublicKey
+	}
+	// publishers with different key types verify in the same domain
+	object384 := testObject
+	object384.Sender = sender384
+	payload256, _ := json.Marshal(testObject)
+	payload384, _ := json.Marshal(object384)
+	sig256, _ := messaging.CreateJWSSignature(string(payload256), p256Keys)
+	sig384, _ := messaging.CreateJWSSignature(string(payload384), p384Keys)
+	var received TestObjectWithSender
+	isSigned, err := messaging.VerifySenderJWSSignature(sig256, &received, getPublicKey)
+	assert.True(t, isSigned)
+	assert.NoError(t, err)
+	isSigned, err = messaging.VerifySenderJWSSignature(sig384, &received, getPublicKey)
+	assert.True(t, isSigned)
+	assert.NoError(t, err)
+
+	// error case - sender claims the identity of the P-384 publisher using its own P-256 key
+	spoofed, _ := messaging.CreateJWSSignature(string(payload384), p256Keys)
+	_, err = messaging.VerifySenderJWSSignature(spoofed, &received, getPublicKey)
+	assert.Error(t, err)
+
+	// error case - algorithm "none" is rejected
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	unsecured := header + "." + base64.RawURLEncoding.EncodeToString(payload256) + "."
+	_, err = messaging.VerifySenderJWSSignature(unsecured, &received, getPublicKey)
+	assert.Error(t, err)
+	_, err = messaging.VerifyJWSMessage(unsecured, &p256Keys.PublicKey)
+	assert.Error(t, err)
+
+	// error case - HMAC using the public key of the sender as secret is rejected
+	secret := []byte(messaging.PublicKeyToPem(&p256Keys.PublicKey))
+	hmacSigner, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: secret}, nil)
+	require.NoError(t, err)
+	hmacObject, _ := hmacSigner.Sign(payload256)
+	hmacMessage, _ := hmacObject.CompactSerialize()
+	_, err = messaging.VerifySenderJWSSignature(hmacMessage, &received, getPublicKey)
+	assert.Error(t, err)
+}
@@ -0,0 +1,179 @@
+// Package messaging - Detection of publish and subscribe operations that are denied by the broker ACL
+package messaging
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultPreflightTimeout is the time to wait for the broker to confirm the topics of a preflight check
+const DefaultPreflightTimeout = 5 * time.Second
+
+// PreflightSegment replaces the wildcards of publication addresses that are checked with a preflight
+// and is the subtopic of the address that the preflight probe is published on
+const PreflightSegment = "_preflight"
+
+// TopicOperation that can be denied by the broker
+type TopicOperation string
+
+// Topic operations
+const (
+	TopicOperationPublish   TopicOperation = "publish"
+	TopicOperationSubscribe TopicOperation = "subscribe"
+)
+
+// TopicDenial with a publish or subscribe operation that is denied by the broker, eg due to its ACL
+type TopicDenial struct {
+	Address   string         // address the operation is denied on
+	Count     int            // nr of times the operation is denied on this address
+	LastTime  time.Time      // time of the last denial
+	Operation TopicOperation // publish or subscribe
+	Reason    string         // reason of the denial
+}
+
+// TopicDenialHandler is invoked when the broker denies a publish or subscribe operation
+type TopicDenialHandler func(denial TopicDenial)
+
+// ITopicAuthorization is implemented by messengers that detect whether the broker denies operations.
+// MQTT 3.1.1 brokers only report denied subscriptions. A denied publication is silently dropped or
+// closes the connection, so denied publications are only detected with CheckTopicAccess.
+type ITopicAuthorization interface {
+	// CheckTopicAccess verifies that the broker authorizes publishing and subscribing to addresses
+	// Wildcards in publish addresses are replaced with the PreflightSegment. Use nil subscribe addresses
+	// to check the current subscriptions. This returns the denials that are found.
+	CheckTopicAccess(publish []string, subscribe []string, timeout time.Duration) []TopicDenial
+
+	// GetTopicDenials returns the operations that are denied since the messenger was created
+	GetTopicDenials() []TopicDenial
+
+	// SetOnTopicDenied sets the handler that is invoked when an operation is denied. Use nil to remove.
+	SetOnTopicDenied(handler TopicDenialHandler)
+}
+
+// CheckTopicAccess verifies that the broker of a messenger authorizes publishing and subscribing to addresses
+// This returns an error if the messenger doesn't implement ITopicAuthorization.
+func CheckTopicAccess(messenger IMessenger, publish []string, subscribe []string, timeout time.Duration) ([]TopicDenial, error) {
+	withAuthorization, ok := messenger.(ITopicAuthorization)
+	if !ok {
+		return nil, errors.New("CheckTopicAccess: The messenger doesn't detect denied topics")
+	}
+	return withAuthorization.CheckTopicAccess(publish, subscribe, timeout), nil
+}
+
+// GetTopicDenials returns the operations that are denied by the broker of a messenger
+// Messengers that don't implement ITopicAuthorization have no denials.
+func GetTopicDenials(messenger IMessenger) []TopicDenial {
+	withAuthorization, ok := messenger.(ITopicAuthorization)
+	if !ok {
+		return make([]TopicDenial, 0)
+	}
+	return withAuthorization.GetTopicDenials()
+}
+
+// MakePreflightAddress returns the address that is published to check the authorization of a publication
+// address. Wildcards are replaced with the PreflightSegment and the probe is published on the PreflightSegment
+// subtopic, so subscribers of the publication address don't receive the probe.
+func MakePreflightAddress(address string) string {
+	return replaceWildcards(address) + "/" + PreflightSegment
+}
+
+// SetOnTopicDenied sets the handler that is invoked when the broker of a messenger denies an operation
+// This returns false if the messenger doesn't implement ITopicAuthorization.
+func SetOnTopicDenied(messenger IMessenger, handler TopicDenialHandler) bool {
+	withAuthorization, ok := messenger.(ITopicAuthorization)
+	if ok {
+		withAuthorization.SetOnTopicDenied(handler)
+	}
+	return ok
+}
+
+// replaceWildcards returns the address with its wildcards replaced by the PreflightSegment
+func replaceWildcards(address string) string {
+	segments := strings.Split(address, "/")
+	for index, segment := range segments {
+		if segment == "+" || segment == "#" {
+			segments[index] = PreflightSegment
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// topicDenials tracks the denied operations of a messenger
+type topicDenials struct {
+	denials     map[string]*TopicDenial // denials by operation and address
+	onDenied    TopicDenialHandler      // optional notification of denials
+	updateMutex *sync.Mutex             // mutex for async updating of denials
+}
+
+// add records a denied operation and notifies the handler
+// The first denial of an address is logged as an error.
+func (tracker *topicDenials) add(operation TopicOperation, address string, reason string) TopicDenial {
+	key := string(operation) + " " + address
+	tracker.updateMutex.Lock()
+	denial := tracker.denials[key]
+	if denial == nil {
+		logrus.Errorf("topicDenials.add: The broker denies to %s on address %s: %s", operation, address, reason)
+		denial = &TopicDenial{Address: address, Operation: operation}
+		tracker.denials[key] = denial
+	}
+	denial.Count++
+	denial.LastTime = time.Now()
+	denial.Reason = reason
+	denialCopy := *denial
+	handler := tracker.onDenied
+	tracker.updateMutex.Unlock()
+
+	if handler != nil {
+		handler(denialCopy)
+	}
+	return denialCopy
+}
+
+// find returns the denial of an operation on an address, or nil if it isn't denied
+func (tracker *topicDenials) find(operation TopicOperation, address string) *TopicDenial {
+	tracker.updateMutex.Lock()
+	defer tracker.updateMutex.Unlock()
+	denial := tracker.denials[string(operation)+" "+address]
+	if denial == nil {
+		return nil
+	}
+	denialCopy := *denial
+	return &denialCopy
+}
+
+// list returns a copy of the denials sorted by address and operation
+func (tracker *topicDenials) list() []TopicDenial {
+	tracker.updateMutex.Lock()
+	defer tracker.updateMutex.Unlock()
+	list := make([]TopicDenial, 0, len(tracker.denials))
+	for _, denial := range tracker.denials {
+		list = append(list, *denial)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Address != list[j].Address {
+			return list[i].Address < list[j].Address
+		}
+		return list[i].Operation < list[j].Operation
+	})
+	return list
+}
+
+// setHandler sets the handler that is invoked when an operation is denied
+func (tracker *topicDenials) setHandler(handler TopicDenialHandler) {
+	tracker.updateMutex.Lock()
+	defer tracker.updateMutex.Unlock()
+	tracker.onDenied = handler
+}
+
+// newTopicDenials creates a tracker of denied operations
+func newTopicDenials() *topicDenials {
+	return &topicDenials{
+		denials:     make(map[string]*TopicDenial),
+		updateMutex: &sync.Mutex{},
+	}
+}
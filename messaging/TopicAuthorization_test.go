@@ -0,0 +1,63 @@
+package messaging_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicAuthorization(t *testing.T) {
+	assert.Equal(t, "test/pub1/_preflight/$node/_preflight", messaging.MakePreflightAddress("test/pub1/+/$node"))
+	assert.Equal(t, "test/_preflight/_preflight", messaging.MakePreflightAddress("test/#"))
+	assert.Equal(t, "test/pub1/$identity/_preflight", messaging.MakePreflightAddress("test/pub1/$identity"))
+
+	dummy := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	dummy.DenyTopic(messaging.TopicOperationPublish, "test/pub1/+/$node")
+	dummy.DenyTopic(messaging.TopicOperationSubscribe, "test/+/$identity")
+	denied := make([]messaging.TopicDenial, 0)
+	assert.True(t, messaging.SetOnTopicDenied(dummy, func(denial messaging.TopicDenial) {
+		denied = append(denied, denial)
+	}))
+
+	// denied publications are dropped and denied subscriptions ignored
+	received := 0
+	dummy.Subscribe("test/+/$identity", func(address string, message string) error {
+		received++
+		return nil
+	})
+	dummy.Publish("test/pub1/$identity", false, "{}")
+	dummy.Publish("test/pub1/node1/$node", false, "{}")
+	dummy.Publish("test/pub1/node1/$node", false, "{}")
+	assert.Zero(t, received)
+	assert.Empty(t, dummy.FindLastPublication("test/pub1/node1/$node"))
+	require.Len(t, denied, 3)
+	denials := messaging.GetTopicDenials(dummy)
+	require.Len(t, denials, 2)
+	assert.Equal(t, "test/+/$identity", denials[0].Address)
+	assert.Equal(t, "test/pub1/node1/$node", denials[1].Address)
+	assert.Equal(t, 2, denials[1].Count)
+
+	// preflight check of explicit addresses
+	denials, err := messaging.CheckTopicAccess(dummy,
+		[]string{"test/pub1/+/$node", "test/pub1/$identity"}, []string{"test/pub1/$identity"}, 0)
+	require.NoError(t, err)
+	require.Len(t, denials, 2)
+	assert.Equal(t, messaging.TopicOperationPublish, denials[0].Operation)
+	assert.Equal(t, messaging.TopicOperationSubscribe, denials[1].Operation)
+
+	// the MQTT messenger can't check without connection
+	mqtt := messaging.NewMqttMessenger(&messaging.MessengerConfig{Server: "localhost"})
+	denials, err = messaging.CheckTopicAccess(mqtt, []string{"test/pub1/+/$node"}, nil, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, denials)
+	assert.Empty(t, mqtt.GetTopicDenials())
+
+	// messengers that can't detect denials
+	multi := messaging.NewMultiMessenger()
+	_, err = messaging.CheckTopicAccess(multi, nil, nil, 0)
+	assert.Error(t, err)
+	assert.Empty(t, messaging.GetTopicDenials(multi))
+	assert.False(t, messaging.SetOnTopicDenied(multi, nil))
+}
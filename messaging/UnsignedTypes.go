@@ -0,0 +1,76 @@
+// Package messaging with opting out of signing for selected message types
+package messaging
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// signingRequiredTypes are the commands, requests and identity messages that are always signed
+// The signature of consumer requests proves their sender, which the publisher uses for budgets and replies.
+var signingRequiredTypes = map[types.MessageType]bool{
+	types.MessageTypeConfigure:   true,
+	types.MessageTypeControl:     true,
+	types.MessageTypeCreate:      true,
+	types.MessageTypeDelete:      true,
+	types.MessageTypeIdentity:    true,
+	types.MessageTypeInterest:    true,
+	types.MessageTypeReplay:      true,
+	types.MessageTypeSetIdentity: true,
+	types.MessageTypeSetInput:    true,
+	types.MessageTypeSetNodeID:   true,
+	types.MessageTypeUpgrade:     true,
+}
+
+// IsSigningRequired returns true if messages of the given type must always be signed
+// Commands, consumer requests and identities can't opt out of signing.
+func IsSigningRequired(messageType types.MessageType) bool {
+	return signingRequiredTypes[messageType]
+}
+
+// MessageTypeFromAddress returns the message type of a publication address, eg $raw
+// The message type is the last segment of the address.
+func MessageTypeFromAddress(address string) types.MessageType {
+	return types.MessageType(address[strings.LastIndex(address, "/")+1:])
+}
+
+// IsUnsignedAllowed returns whether a message received on the address can be accepted without signature.
+// This is the case when signing is disabled or when the sender has declared the message type as unsigned.
+func (signer *MessageSigner) IsUnsignedAllowed(address string) bool {
+	if !signer.signMessages {
+		return true
+	} else if signer.isUnsignedAllowed == nil || IsSigningRequired(MessageTypeFromAddress(address)) {
+		return false
+	}
+	return signer.isUnsignedAllowed(address)
+}
+
+// SetIsUnsignedAllowed sets the lookup of message types that senders have declared as unsigned.
+// Intended to accept unsigned messages of the types declared in the identity of the sender.
+//  isUnsignedAllowed returns true if the publisher of the address declared its message type unsigned
+func (signer *MessageSigner) SetIsUnsignedAllowed(isUnsignedAllowed func(address string) bool) {
+	signer.isUnsignedAllowed = isUnsignedAllowed
+}
+
+// SetUnsignedMessageTypes sets the message types that are published without signature.
+// Intended to save CPU on constrained devices for high frequency publications like $raw values.
+// The types must be declared in the publisher's identity for receivers to accept them.
+// This returns an error if one of the types must always be signed, in which case nothing is changed.
+func (signer *MessageSigner) SetUnsignedMessageTypes(messageTypes []types.MessageType) error {
+	unsignedTypes := make(map[types.MessageType]bool)
+	for _, messageType := range messageTypes {
+		if IsSigningRequired(messageType) {
+			return fmt.Errorf("SetUnsignedMessageTypes: Messages of type %s must be signed", messageType)
+		}
+		unsignedTypes[messageType] = true
+	}
+	signer.unsignedTypes = unsignedTypes
+	return nil
+}
+
+// isSignedAddress returns whether messages published on the address are signed
+func (signer *MessageSigner) isSignedAddress(address string) bool {
+	return signer.signMessages && !signer.unsignedTypes[MessageTypeFromAddress(address)]
+}
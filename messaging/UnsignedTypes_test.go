@@ -0,0 +1,46 @@
+package messaging_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnsignedMessageTypes(t *testing.T) {
+	const rawAddress = "test/publisher1/node1/temperature/0/$raw"
+	const latestAddress = "test/publisher1/node1/temperature/0/$latest"
+	privKey := messaging.CreateAsymKeys()
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	signer := messaging.NewMessageSigner(messenger, privKey, nil)
+
+	// commands can't opt out of signing
+	err := signer.SetUnsignedMessageTypes([]types.MessageType{types.MessageTypeRaw, types.MessageTypeSetInput})
+	assert.Error(t, err)
+	assert.True(t, messaging.IsSigningRequired(types.MessageTypeControl))
+	assert.True(t, messaging.IsSigningRequired(types.MessageTypeReplay))
+	assert.True(t, messaging.IsSigningRequired(types.MessageTypeInterest))
+	assert.False(t, messaging.IsSigningRequired(types.MessageTypeRaw))
+
+	// $raw is published unsigned while $latest remains signed
+	err = signer.SetUnsignedMessageTypes([]types.MessageType{types.MessageTypeRaw})
+	assert.NoError(t, err)
+	signer.PublishSigned(rawAddress, false, "21.5")
+	assert.Equal(t, "21.5", messenger.FindLastPublication(rawAddress))
+	signer.PublishSigned(latestAddress, false, "21.5")
+	_, isSigned := messaging.JWSPayload(messenger.FindLastPublication(latestAddress))
+	assert.True(t, isSigned)
+
+	// receivers only accept unsigned messages of declared types
+	assert.False(t, signer.IsUnsignedAllowed(rawAddress))
+	signer.SetIsUnsignedAllowed(func(address string) bool {
+		return messaging.MessageTypeFromAddress(address) == types.MessageTypeRaw ||
+			messaging.MessageTypeFromAddress(address) == types.MessageTypeSetInput
+	})
+	assert.True(t, signer.IsUnsignedAllowed(rawAddress))
+	assert.False(t, signer.IsUnsignedAllowed(latestAddress))
+	assert.False(t, signer.IsUnsignedAllowed("test/publisher1/node1/switch/0/$setInput"))
+	signer.SetSignMessages(false)
+	assert.True(t, signer.IsUnsignedAllowed(latestAddress))
+}
@@ -18,9 +18,13 @@ type ECDSASignature struct {
 // CreateAsymKeys creates a asymmetric key set
 // Returns a private key that contains its associated public key
 func CreateAsymKeys() *ecdsa.PrivateKey {
-	rng := rand.Reader
-	curve := elliptic.P256()
-	privKey, _ := ecdsa.GenerateKey(curve, rng)
+	return CreateAsymKeysWithCurve(elliptic.P256())
+}
+
+// CreateAsymKeysWithCurve creates a asymmetric key set using the given curve
+// Messages signed with the key use the JWS algorithm of the curve, eg ES384 for elliptic.P384().
+func CreateAsymKeysWithCurve(curve elliptic.Curve) *ecdsa.PrivateKey {
+	privKey, _ := ecdsa.GenerateKey(curve, rand.Reader)
 	return privKey
 }
 
@@ -55,7 +59,8 @@ func PublicKeyFromPem(pemEncodedPub string) *ecdsa.PublicKey {
 	blockPub, _ := pem.Decode([]byte(pemEncodedPub))
 	x509EncodedPub := blockPub.Bytes
 	genericPublicKey, _ := x509.ParsePKIXPublicKey(x509EncodedPub)
-	publicKey := genericPublicKey.(*ecdsa.PublicKey)
+	// keys of other types, eg ed25519, are not ECDSA keys
+	publicKey, _ := genericPublicKey.(*ecdsa.PublicKey)
 
 	return publicKey
 }
@@ -3,7 +3,6 @@ package nodes
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"reflect"
@@ -32,6 +31,22 @@ func (domainNodes *DomainNodes) GetAllNodes() []*types.NodeDiscoveryMessage {
 	return allNodes
 }
 
+// GetGroups returns the sorted groups of the discovered nodes, including the parent groups
+func (domainNodes *DomainNodes) GetGroups() []string {
+	return getNodeGroups(domainNodes.GetAllNodes())
+}
+
+// GetNodesByGroup returns the discovered nodes in the group or one of its sub-groups
+//  group is the group path, eg building1/floor2
+func (domainNodes *DomainNodes) GetNodesByGroup(group string) []*types.NodeDiscoveryMessage {
+	return filterNodesByGroup(domainNodes.GetAllNodes(), group)
+}
+
+// GetNodesByTag returns the discovered nodes that have the given tag
+func (domainNodes *DomainNodes) GetNodesByTag(tag string) []*types.NodeDiscoveryMessage {
+	return filterNodesByTag(domainNodes.GetAllNodes(), tag)
+}
+
 // GetPublisherNodes returns a list of all nodes of a publisher
 // publisherAddress contains the domain/publisherID[/$identity]
 func (domainNodes *DomainNodes) GetPublisherNodes(publisherAddress string) []*types.NodeDiscoveryMessage {
@@ -62,32 +77,17 @@ func (domainNodes *DomainNodes) GetNodeAttr(address string, attrName types.NodeA
 }
 
 // GetNodeConfigValue returns the attribute value of a node in this list
-// This returns the provided default value if no value is set and no default is configured.
+// This returns the provided default value if no value is set and no default is configured, or on error.
 // An error is returned when the node or configuration doesn't exist.
+// Use ReadNodeConfigValue to distinguish between these cases.
 func (domainNodes *DomainNodes) GetNodeConfigValue(
 	address string, attrName types.NodeAttr, defaultValue string) (value string, err error) {
 
-	// in case of error, always return defaultValue
-	node := domainNodes.GetNodeByAddress(address)
-	if node == nil {
-		msg := fmt.Sprintf("NodeList.GetNodeConfigValue: Node '%s' not found", address)
-		return defaultValue, errors.New(msg)
-	}
-	config, configExists := node.Config[attrName]
-	if !configExists {
-		msg := fmt.Sprintf("NodeList.GetNodeConfigValue: Node '%s' configuration '%s' does not exist", address, attrName)
-		return defaultValue, errors.New(msg)
-	}
-	// if no value is known, use the configuration default
-	attrValue, exists := node.Attr[attrName]
-	if !exists || attrValue == "" {
-		attrValue = config.Default
+	value, found, err := domainNodes.ReadNodeConfigValue(address, attrName)
+	if err != nil || !found {
+		return defaultValue, err
 	}
-	// if still no value is known, use the provided default
-	if attrValue == "" {
-		return defaultValue, nil
-	}
-	return attrValue, nil
+	return value, nil
 }
 
 // LoadNodes loads saved discovered nodes from file
@@ -111,6 +111,24 @@ func (domainNodes *DomainNodes) LoadNodes(filename string) error {
 
 }
 
+// ReadNodeConfigValue reads the node configuration value, or the configuration default if no value is set
+// This returns found false if no value is set and no default is configured. Errors wrap
+// ErrNodeNotFound or ErrConfigNotFound.
+func (domainNodes *DomainNodes) ReadNodeConfigValue(
+	address string, attrName types.NodeAttr) (value string, found bool, err error) {
+
+	node := domainNodes.GetNodeByAddress(address)
+	if node == nil {
+		return "", false, fmt.Errorf("DomainNodes.ReadNodeConfigValue: Node '%s': %w", address, ErrNodeNotFound)
+	}
+	value, found, err = readNodeConfig(node, attrName)
+	if err != nil {
+		return "", false, fmt.Errorf("DomainNodes.ReadNodeConfigValue: Node '%s' configuration '%s': %w",
+			address, attrName, err)
+	}
+	return value, found, nil
+}
+
 // RemoveNode removes a node using its address.
 // If the node doesn't exist, this is ignored.
 func (domainNodes *DomainNodes) RemoveNode(address string) {
@@ -0,0 +1,95 @@
+// Package nodes with detection of flapping nodes that appear and disappear rapidly
+package nodes
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultFlapWindow is the time window in which the transitions of a node are counted for flap detection
+const DefaultFlapWindow = 10 * time.Minute
+
+// FlapDetector detects nodes that flap, eg devices with a poor connection that repeatedly appear and
+// disappear. A node flaps when it has maxTransitions or more transitions within the time window. It
+// remains flapping until it has fewer transitions within the window, so a node must be stable for a
+// while before it is considered stable again.
+type FlapDetector struct {
+	flapping       map[string]bool        // flapping nodes by hwID
+	maxTransitions int                    // nr of transitions within the window that makes a node flap
+	transitions    map[string][]time.Time // time of the recent transitions by node hwID
+	updateMutex    *sync.Mutex            // mutex for async recording of transitions
+	window         time.Duration          // time window in which transitions are counted
+}
+
+// AddTransition records a transition of a node, eg a change of its run state or its reappearance
+// This returns true if the node is flapping.
+func (detector *FlapDetector) AddTransition(hwID string, now time.Time) (flapping bool) {
+	detector.updateMutex.Lock()
+	defer detector.updateMutex.Unlock()
+	transitions := detector.pruneTransitions(hwID, now)
+	transitions = append(transitions, now)
+	detector.transitions[hwID] = transitions
+	if len(transitions) >= detector.maxTransitions && !detector.flapping[hwID] {
+		logrus.Warningf("FlapDetector.AddTransition: Node '%s' is flapping with %d transitions within %s",
+			hwID, len(transitions), detector.window)
+		detector.flapping[hwID] = true
+	}
+	return detector.flapping[hwID]
+}
+
+// IsFlapping returns true if the node is flapping
+func (detector *FlapDetector) IsFlapping(hwID string) bool {
+	detector.updateMutex.Lock()
+	defer detector.updateMutex.Unlock()
+	return detector.flapping[hwID]
+}
+
+// UpdateStable returns the hwIDs of the flapping nodes that are stable again and no longer flap
+// Transitions that are older than the time window are removed.
+func (detector *FlapDetector) UpdateStable(now time.Time) (stable []string) {
+	detector.updateMutex.Lock()
+	defer detector.updateMutex.Unlock()
+	stable = make([]string, 0)
+	for hwID := range detector.transitions {
+		transitions := detector.pruneTransitions(hwID, now)
+		if len(transitions) == 0 {
+			delete(detector.transitions, hwID)
+		} else {
+			detector.transitions[hwID] = transitions
+		}
+		if detector.flapping[hwID] && len(transitions) < detector.maxTransitions {
+			logrus.Infof("FlapDetector.UpdateStable: Node '%s' is stable", hwID)
+			delete(detector.flapping, hwID)
+			stable = append(stable, hwID)
+		}
+	}
+	return stable
+}
+
+// pruneTransitions returns the transitions of a node within the time window. Use within a locked section.
+func (detector *FlapDetector) pruneTransitions(hwID string, now time.Time) []time.Time {
+	transitions := detector.transitions[hwID]
+	for len(transitions) > 0 && now.Sub(transitions[0]) >= detector.window {
+		transitions = transitions[1:]
+	}
+	return transitions
+}
+
+// NewFlapDetector creates a detector of flapping nodes
+//  maxTransitions is the nr of transitions within the window that makes a node flap
+//  window is the time in which transitions are counted. Use 0 for DefaultFlapWindow
+func NewFlapDetector(maxTransitions int, window time.Duration) *FlapDetector {
+	if window <= 0 {
+		window = DefaultFlapWindow
+	}
+	detector := &FlapDetector{
+		flapping:       make(map[string]bool),
+		maxTransitions: maxTransitions,
+		transitions:    make(map[string][]time.Time),
+		updateMutex:    &sync.Mutex{},
+		window:         window,
+	}
+	return detector
+}
@@ -0,0 +1,58 @@
+// Package nodes with reading of node configuration values that reports their presence explicitly
+package nodes
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// Errors of the node configuration read functions. Use errors.Is to test for them.
+var (
+	ErrConfigNotFound     = errors.New("configuration does not exist")
+	ErrInvalidConfigValue = errors.New("configuration value has the wrong type")
+	ErrNodeNotFound       = errors.New("node not found")
+)
+
+// readNodeConfig returns the configuration value of a node, or the configuration default if no value is set
+// Nodes are immutable so this doesn't need the lock of the collection the node came from.
+// This returns found false if neither a value nor a configuration default is set.
+func readNodeConfig(node *types.NodeDiscoveryMessage, attrName types.NodeAttr) (value string, found bool, err error) {
+	config, configExists := node.Config[attrName]
+	if !configExists {
+		return "", false, ErrConfigNotFound
+	}
+	value = node.Attr[attrName]
+	if value == "" {
+		value = config.Default
+	}
+	return value, value != "", nil
+}
+
+// parseConfigBool parses a configuration value as a boolean
+func parseConfigBool(valueStr string) (bool, error) {
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		return false, ErrInvalidConfigValue
+	}
+	return value, nil
+}
+
+// parseConfigFloat parses a configuration value as a floating point number
+func parseConfigFloat(valueStr string) (float32, error) {
+	value, err := strconv.ParseFloat(valueStr, 32)
+	if err != nil {
+		return 0, ErrInvalidConfigValue
+	}
+	return float32(value), nil
+}
+
+// parseConfigInt parses a configuration value as an integer
+func parseConfigInt(valueStr string) (int, error) {
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		return 0, ErrInvalidConfigValue
+	}
+	return value, nil
+}
@@ -0,0 +1,112 @@
+// Package nodes with grouping of nodes by location or group path and tags
+package nodes
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// GroupSeparator separates the levels of a group path, eg building/floor/room
+const GroupSeparator = "/"
+
+// TagSeparator separates the tags in the tags attribute
+const TagSeparator = ","
+
+// GetNodeTags returns the tags of a node from its tags attribute
+func GetNodeTags(node *types.NodeDiscoveryMessage) []string {
+	tags := make([]string, 0)
+	if node == nil {
+		return tags
+	}
+	for _, tag := range strings.Split(node.Attr[types.NodeAttrTags], TagSeparator) {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// HasNodeTag returns true if the node has the given tag
+func HasNodeTag(node *types.NodeDiscoveryMessage, tag string) bool {
+	for _, nodeTag := range GetNodeTags(node) {
+		if nodeTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNodeInGroup returns true if the node is in the group or in one of its sub-groups.
+// For example a node in building1/floor2/room3 is in the groups building1 and building1/floor2.
+//  group is the group path. Use "" to match all nodes.
+func IsNodeInGroup(node *types.NodeDiscoveryMessage, group string) bool {
+	if node == nil {
+		return false
+	}
+	group = strings.Trim(group, GroupSeparator)
+	nodeGroup := strings.Trim(node.Attr[types.NodeAttrGroup], GroupSeparator)
+	if group == "" || nodeGroup == group {
+		return true
+	}
+	return strings.HasPrefix(nodeGroup, group+GroupSeparator)
+}
+
+// MakeGroupPath joins the levels of a location hierarchy into a group path
+//  levels of the hierarchy, eg building, floor, room
+func MakeGroupPath(levels ...string) string {
+	return strings.Join(levels, GroupSeparator)
+}
+
+// SelectNodesInGroup returns a selector of nodes in the group or one of its sub-groups
+// Intended for bulk updates of the nodes in a group.
+func SelectNodesInGroup(group string) NodeSelector {
+	return func(node *types.NodeDiscoveryMessage) bool {
+		return IsNodeInGroup(node, group)
+	}
+}
+
+// filterNodesByGroup returns the nodes that are in the group or one of its sub-groups
+func filterNodesByGroup(nodeList []*types.NodeDiscoveryMessage, group string) []*types.NodeDiscoveryMessage {
+	groupNodes := make([]*types.NodeDiscoveryMessage, 0)
+	for _, node := range nodeList {
+		if IsNodeInGroup(node, group) {
+			groupNodes = append(groupNodes, node)
+		}
+	}
+	return groupNodes
+}
+
+// filterNodesByTag returns the nodes that have the given tag
+func filterNodesByTag(nodeList []*types.NodeDiscoveryMessage, tag string) []*types.NodeDiscoveryMessage {
+	tagNodes := make([]*types.NodeDiscoveryMessage, 0)
+	for _, node := range nodeList {
+		if HasNodeTag(node, tag) {
+			tagNodes = append(tagNodes, node)
+		}
+	}
+	return tagNodes
+}
+
+// getNodeGroups returns the sorted groups of the nodes including their parent groups
+func getNodeGroups(nodeList []*types.NodeDiscoveryMessage) []string {
+	groupMap := make(map[string]bool)
+	for _, node := range nodeList {
+		nodeGroup := strings.Trim(node.Attr[types.NodeAttrGroup], GroupSeparator)
+		if nodeGroup == "" {
+			continue
+		}
+		levels := strings.Split(nodeGroup, GroupSeparator)
+		for i := range levels {
+			groupMap[MakeGroupPath(levels[:i+1]...)] = true
+		}
+	}
+	groups := make([]string, 0, len(groupMap))
+	for group := range groupMap {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
@@ -0,0 +1,50 @@
+package nodes_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeGroups(t *testing.T) {
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	collection.CreateNode("room1light", types.NodeTypeSmartlight)
+	collection.CreateNode("room2light", types.NodeTypeSmartlight)
+	collection.CreateNode("garagedoor", types.NodeTypeLock)
+	collection.CreateNode("ungrouped", types.NodeTypeUnknown)
+	collection.GetUpdatedNodes(true)
+
+	changed := collection.SetNodeGroup("room1light", nodes.MakeGroupPath("building1", "floor1", "room1"), "lighting")
+	assert.True(t, changed)
+	collection.SetNodeGroup("room2light", "building1/floor2/room2/", "lighting", "dimmable")
+	collection.SetNodeGroup("garagedoor", "building2", "outdoor")
+	assert.Len(t, collection.GetUpdatedNodes(true), 3, "Group changes should be published")
+	changed = collection.SetNodeGroup("garagedoor", "building2", "outdoor")
+	assert.False(t, changed)
+
+	// sub-groups are included in their parent group
+	assert.Len(t, collection.GetNodesByGroup("building1"), 2)
+	assert.Len(t, collection.GetNodesByGroup("building1/floor2"), 1)
+	assert.Len(t, collection.GetNodesByGroup("building1/floor"), 0, "Partial group names shouldn't match")
+	assert.Len(t, collection.GetNodesByGroup(""), 4)
+	assert.Len(t, collection.GetNodesByTag("lighting"), 2)
+	assert.Len(t, collection.GetNodesByTag("dimmable"), 1)
+	assert.Equal(t, []string{"building1", "building1/floor1", "building1/floor1/room1",
+		"building1/floor2", "building1/floor2/room2", "building2"}, collection.GetGroups())
+
+	// groups are included in the discovery of the node
+	node := collection.GetNodeByHWID("room2light")
+	assert.Equal(t, []string{"lighting", "dimmable"}, nodes.GetNodeTags(node))
+	domainNodes := nodes.NewDomainNodes(messaging.NewMessageSigner(messaging.NewDummyMessenger(nil), nil, nil))
+	domainNodes.AddNode(node)
+	assert.Len(t, domainNodes.GetNodesByGroup("building1"), 1)
+	assert.Len(t, domainNodes.GetNodesByTag("dimmable"), 1)
+	assert.Len(t, domainNodes.GetGroups(), 3)
+
+	// error case - nil node
+	assert.False(t, nodes.IsNodeInGroup(nil, ""))
+	assert.Empty(t, nodes.GetNodeTags(nil))
+}
@@ -0,0 +1,193 @@
+// Package nodes with the persisted mapping of node hardware IDs to the node IDs they are published with
+package nodes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/sirupsen/logrus"
+)
+
+// NodeIDMappingChange with a change of the node ID that a hardware ID is mapped to
+type NodeIDMappingChange struct {
+	HWID           string // hardware ID of the node
+	NodeID         string // new node ID, the hardware ID if the mapping is removed
+	PreviousNodeID string // node ID before the change
+}
+
+// NodeIDMappings is the table of hardware IDs and the node IDs their nodes are published with.
+// A node ID is mapped to a single hardware ID. Hardware IDs without mapping are published with their
+// hardware ID as node ID. Mappings remain when a node is deleted so a rediscovered node keeps its node ID.
+type NodeIDMappings struct {
+	hwIDs       map[string]string         // hardware ID by mapped node ID
+	isInUse     func(string, string) bool // optional check if a node other than hwID uses a node ID
+	nodeIDs     map[string]string         // mapped node ID by hardware ID
+	updateCount int                       // nr of changes since the mappings were last saved
+	updated     []NodeIDMappingChange     // changes since the last GetUpdatedMappings
+	updateMutex *sync.Mutex               // mutex for async updating of mappings
+}
+
+// GetAllMappings returns a copy of the mapped node IDs by hardware ID
+func (mappings *NodeIDMappings) GetAllMappings() map[string]string {
+	mappings.updateMutex.Lock()
+	defer mappings.updateMutex.Unlock()
+
+	allMappings := make(map[string]string)
+	for hwID, nodeID := range mappings.nodeIDs {
+		allMappings[hwID] = nodeID
+	}
+	return allMappings
+}
+
+// GetHWID returns the hardware ID of the node that is published with a node ID
+// A node ID that isn't mapped is the hardware ID itself, unless that hardware ID is mapped to a
+// different node ID in which case this returns "".
+func (mappings *NodeIDMappings) GetHWID(nodeID string) string {
+	mappings.updateMutex.Lock()
+	defer mappings.updateMutex.Unlock()
+
+	if hwID, found := mappings.hwIDs[nodeID]; found {
+		return hwID
+	} else if _, isMapped := mappings.nodeIDs[nodeID]; isMapped {
+		return ""
+	}
+	return nodeID
+}
+
+// GetNodeID returns the node ID that a hardware ID is published with
+// This returns the hardware ID if it isn't mapped.
+func (mappings *NodeIDMappings) GetNodeID(hwID string) string {
+	mappings.updateMutex.Lock()
+	defer mappings.updateMutex.Unlock()
+
+	if nodeID, found := mappings.nodeIDs[hwID]; found {
+		return nodeID
+	}
+	return hwID
+}
+
+// GetUpdatedMappings returns the mapping changes in order of change
+//  clearUpdates clears the list of changes on return
+func (mappings *NodeIDMappings) GetUpdatedMappings(clearUpdates bool) []NodeIDMappingChange {
+	mappings.updateMutex.Lock()
+	defer mappings.updateMutex.Unlock()
+
+	changes := append([]NodeIDMappingChange{}, mappings.updated...)
+	if clearUpdates {
+		mappings.updated = nil
+	}
+	return changes
+}
+
+// LoadMappings loads previously saved mappings from a JSON file with node IDs by hardware ID
+// Loaded mappings replace existing mappings of the same hardware ID and are not reported as changes.
+// Mappings whose node ID is already mapped to another hardware ID are ignored.
+func (mappings *NodeIDMappings) LoadMappings(filename string) error {
+	loaded := make(map[string]string)
+
+	jsonMappings, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return lib.MakeErrorf("LoadMappings: Unable to open file %s: %s", filename, err)
+	}
+	err = json.Unmarshal(jsonMappings, &loaded)
+	if err != nil {
+		return lib.MakeErrorf("LoadMappings: Error parsing JSON node ID mappings file %s: %v", filename, err)
+	}
+	logrus.Infof("LoadMappings: Node ID mappings loaded successfully from %s", filename)
+	mappings.updateMutex.Lock()
+	defer mappings.updateMutex.Unlock()
+	for hwID, nodeID := range loaded {
+		if existingHWID, found := mappings.hwIDs[nodeID]; found && existingHWID != hwID {
+			logrus.Warningf("LoadMappings: Node ID '%s' of '%s' is already mapped to '%s'. Ignored.",
+				nodeID, hwID, existingHWID)
+			continue
+		}
+		mappings.setMapping(hwID, nodeID)
+	}
+	return nil
+}
+
+// SaveMappings saves the mappings to a JSON file with node IDs by hardware ID
+func (mappings *NodeIDMappings) SaveMappings(filename string) error {
+	mappings.updateMutex.Lock()
+	jsonText, err := json.MarshalIndent(mappings.nodeIDs, "", "  ")
+	mappings.updateCount = 0
+	mappings.updateMutex.Unlock()
+	if err != nil {
+		return lib.MakeErrorf("SaveMappings: Error Marshalling JSON node ID mappings '%s': %v", filename, err)
+	}
+	err = ioutil.WriteFile(filename, jsonText, 0664)
+	if err != nil {
+		return lib.MakeErrorf("SaveMappings: Error saving node ID mappings to JSON file %s: %v", filename, err)
+	}
+	logrus.Infof("SaveMappings: Node ID mappings saved successfully to JSON file %s", filename)
+	return nil
+}
+
+// SetMapping maps a hardware ID to a node ID and records the change
+//  nodeID to publish the node with. Use "" or the hardware ID to remove the mapping.
+// This returns an error if the node ID is already mapped to another hardware ID, is the hardware ID
+// of another mapped node, or is the node ID or hardware ID of another registered node.
+func (mappings *NodeIDMappings) SetMapping(hwID string, nodeID string) error {
+	// check before locking as the registered nodes lock the mappings while locked themselves
+	if nodeID != "" && nodeID != hwID && mappings.isInUse != nil && mappings.isInUse(nodeID, hwID) {
+		return lib.MakeErrorf("SetMapping: Node ID '%s' is already used by another node", nodeID)
+	}
+	mappings.updateMutex.Lock()
+	defer mappings.updateMutex.Unlock()
+
+	if existingHWID, found := mappings.hwIDs[nodeID]; found && existingHWID != hwID {
+		return lib.MakeErrorf("SetMapping: Node ID '%s' is already mapped to '%s'", nodeID, existingHWID)
+	} else if _, isMappedHWID := mappings.nodeIDs[nodeID]; isMappedHWID && nodeID != hwID {
+		return lib.MakeErrorf("SetMapping: Node ID '%s' is the hardware ID of another node", nodeID)
+	}
+	previousNodeID, isMapped := mappings.nodeIDs[hwID]
+	if !isMapped {
+		previousNodeID = hwID
+	}
+	if nodeID == "" {
+		nodeID = hwID
+	}
+	if nodeID == previousNodeID {
+		return nil
+	}
+	mappings.setMapping(hwID, nodeID)
+	mappings.updateCount++
+	mappings.updated = append(mappings.updated, NodeIDMappingChange{
+		HWID:           hwID,
+		NodeID:         nodeID,
+		PreviousNodeID: previousNodeID,
+	})
+	return nil
+}
+
+// UpdateCount returns the nr of mapping changes since the mappings were last saved
+func (mappings *NodeIDMappings) UpdateCount() int {
+	mappings.updateMutex.Lock()
+	defer mappings.updateMutex.Unlock()
+	return mappings.updateCount
+}
+
+// setMapping replaces the mapping of a hardware ID. A node ID that is empty or the hardware ID
+// removes the mapping. Use within a locked section.
+func (mappings *NodeIDMappings) setMapping(hwID string, nodeID string) {
+	if previousNodeID, isMapped := mappings.nodeIDs[hwID]; isMapped {
+		delete(mappings.hwIDs, previousNodeID)
+		delete(mappings.nodeIDs, hwID)
+	}
+	if nodeID != "" && nodeID != hwID {
+		mappings.hwIDs[nodeID] = hwID
+		mappings.nodeIDs[hwID] = nodeID
+	}
+}
+
+// NewNodeIDMappings creates a new table of node ID mappings
+func NewNodeIDMappings() *NodeIDMappings {
+	return &NodeIDMappings{
+		hwIDs:       make(map[string]string),
+		nodeIDs:     make(map[string]string),
+		updateMutex: &sync.Mutex{},
+	}
+}
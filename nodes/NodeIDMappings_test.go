@@ -0,0 +1,104 @@
+package nodes_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeIDMappings(t *testing.T) {
+	mappings := nodes.NewNodeIDMappings()
+	assert.Equal(t, "hw1", mappings.GetNodeID("hw1"))
+	assert.Equal(t, "hw1", mappings.GetHWID("hw1"))
+
+	// lookup both ways
+	err := mappings.SetMapping("hw1", "kitchen")
+	require.NoError(t, err)
+	assert.Equal(t, "kitchen", mappings.GetNodeID("hw1"))
+	assert.Equal(t, "hw1", mappings.GetHWID("kitchen"))
+	assert.Equal(t, "", mappings.GetHWID("hw1"), "A mapped hardware ID is not a node ID")
+
+	// node IDs are unique
+	err = mappings.SetMapping("hw2", "kitchen")
+	assert.Error(t, err)
+	assert.Equal(t, "hw2", mappings.GetNodeID("hw2"))
+
+	// changes are recorded in order
+	err = mappings.SetMapping("hw1", "hall")
+	require.NoError(t, err)
+	assert.Equal(t, "hw1", mappings.GetHWID("hall"))
+	err = mappings.SetMapping("hw1", "hall")
+	require.NoError(t, err)
+	changes := mappings.GetUpdatedMappings(true)
+	require.Equal(t, 2, len(changes))
+	assert.Equal(t, nodes.NodeIDMappingChange{HWID: "hw1", NodeID: "kitchen", PreviousNodeID: "hw1"}, changes[0])
+	assert.Equal(t, nodes.NodeIDMappingChange{HWID: "hw1", NodeID: "hall", PreviousNodeID: "kitchen"}, changes[1])
+	assert.Empty(t, mappings.GetUpdatedMappings(false))
+
+	// save and load
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	filename := filepath.Join(tempFolder, "nodeids.json")
+	assert.Equal(t, 2, mappings.UpdateCount())
+	err = mappings.SaveMappings(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 0, mappings.UpdateCount())
+	mappings2 := nodes.NewNodeIDMappings()
+	err = mappings2.LoadMappings(filename)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"hw1": "hall"}, mappings2.GetAllMappings())
+	assert.Empty(t, mappings2.GetUpdatedMappings(false))
+	err = mappings2.LoadMappings(filepath.Join(tempFolder, "notafile.json"))
+	assert.Error(t, err)
+
+	// remove the mapping
+	err = mappings.SetMapping("hw1", "")
+	require.NoError(t, err)
+	assert.Equal(t, "hw1", mappings.GetNodeID("hw1"))
+	assert.Empty(t, mappings.GetAllMappings())
+}
+
+func TestRegisteredNodeIDMappings(t *testing.T) {
+	regNodes := nodes.NewRegisteredNodes("test", "publisher1")
+	node1 := regNodes.CreateNode("hw1", types.NodeTypeMultisensor)
+	regNodes.CreateNode("hw2", types.NodeTypeMultisensor)
+	mappings := regNodes.NodeIDMappings()
+
+	// the node ID is stored in the mappings
+	require.True(t, regNodes.SetNodeID(node1, "kitchen"))
+	assert.Equal(t, "kitchen", mappings.GetNodeID("hw1"))
+	assert.False(t, regNodes.SetNodeID(regNodes.GetNodeByHWID("hw2"), "kitchen"))
+	assert.Equal(t, "hw2", mappings.GetNodeID("hw2"))
+
+	// the hardware ID of another node can't be used, whether that node is mapped or not
+	assert.False(t, regNodes.SetNodeID(regNodes.GetNodeByHWID("hw2"), "hw1"))
+	assert.Error(t, mappings.SetMapping("hw1", "hw2"))
+	assert.Error(t, mappings.SetMapping("hw3", "hw1"))
+	assert.Equal(t, "hw1", mappings.GetHWID("kitchen"))
+
+	// a deleted and rediscovered node keeps its node ID
+	regNodes.DeleteNode("hw1")
+	node1 = regNodes.CreateNode("hw1", types.NodeTypeMultisensor)
+	assert.Equal(t, "kitchen", node1.NodeID)
+	assert.Equal(t, "test/publisher1/kitchen/$node", node1.Address)
+
+	// node IDs of loaded nodes are added to the mappings, and mappings take precedence
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	filename := filepath.Join(tempFolder, "nodes.json")
+	require.True(t, regNodes.SetNodeID(regNodes.GetNodeByHWID("hw2"), "hall"))
+	require.NoError(t, regNodes.SaveNodes(filename))
+	regNodes2 := nodes.NewRegisteredNodes("test", "publisher1")
+	regNodes2.NodeIDMappings().SetMapping("hw2", "garage")
+	require.NoError(t, regNodes2.LoadNodes(filename))
+	assert.Equal(t, "kitchen", regNodes2.NodeIDMappings().GetNodeID("hw1"))
+	assert.Equal(t, "kitchen", regNodes2.GetNodeByHWID("hw1").NodeID)
+	assert.Equal(t, "garage", regNodes2.GetNodeByHWID("hw2").NodeID)
+	assert.NotNil(t, regNodes2.GetNodeByNodeID("garage"))
+}
@@ -0,0 +1,352 @@
+// Package nodes with twins of nodes that track their desired and reported properties
+package nodes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DesiredHandler is invoked when the desired properties of a node twin change
+// The handler is expected to apply the properties to the device and report them with SetReported.
+//  changed holds the changed desired properties. An empty value is a removed property.
+type DesiredHandler func(hwID string, changed map[string]string)
+
+// ConvergedHandler is invoked when the reported properties of a node twin match its desired properties
+// after a change of the desired properties
+type ConvergedHandler func(hwID string, twin *types.NodeTwinMessage)
+
+// NodeTwin with the desired and reported properties of a node
+type NodeTwin struct {
+	Desired         map[string]string `json:"desired"`                   // desired properties
+	DesiredVersion  uint64            `json:"desiredVersion"`            // incremented on each change of the desired properties
+	Reported        map[string]string `json:"reported,omitempty"`        // reported properties, not persisted
+	ReportedVersion uint64            `json:"reportedVersion,omitempty"` // incremented on each change of the reported properties
+	converged       bool              // the reported properties matched the desired properties at the last change
+	loaded          bool              // the twin is loaded and the desired handler isn't notified yet
+}
+
+// NodeTwins with the twins of registered nodes by node hardware ID
+// The desired properties are persisted with SaveTwins. The reported properties are reported by the
+// device after a restart.
+type NodeTwins struct {
+	onConverged ConvergedHandler     // optional notification of converged twins
+	onDesired   DesiredHandler       // optional notification of changed desired properties
+	twins       map[string]*NodeTwin // twins by node hwID
+	updateCount int                  // nr of desired updates since the twins were last saved
+	updated     map[string]bool      // hwIDs of twins that are updated since the last publication
+	updateMutex *sync.Mutex          // mutex for async updating of twins
+}
+
+// ClearUpdated removes node twins from the list of updated twins, intended once they are published
+func (nodeTwins *NodeTwins) ClearUpdated(hwIDs []string) {
+	nodeTwins.updateMutex.Lock()
+	defer nodeTwins.updateMutex.Unlock()
+	for _, hwID := range hwIDs {
+		delete(nodeTwins.updated, hwID)
+	}
+}
+
+// GetPending returns the desired properties of a node twin that don't match the reported properties
+func (nodeTwins *NodeTwins) GetPending(hwID string) map[string]string {
+	nodeTwins.updateMutex.Lock()
+	defer nodeTwins.updateMutex.Unlock()
+	pending := make(map[string]string)
+	twin := nodeTwins.twins[hwID]
+	if twin != nil {
+		for _, name := range twin.pending() {
+			pending[name] = twin.Desired[name]
+		}
+	}
+	return pending
+}
+
+// GetTwin returns a copy of the twin of a node, or nil if the node has no twin
+func (nodeTwins *NodeTwins) GetTwin(hwID string) *types.NodeTwinMessage {
+	nodeTwins.updateMutex.Lock()
+	defer nodeTwins.updateMutex.Unlock()
+	twin := nodeTwins.twins[hwID]
+	if twin == nil {
+		return nil
+	}
+	return twin.makeMessage()
+}
+
+// GetUpdatedTwins returns the hwIDs of the nodes whose twin is updated
+//  clearUpdates clears the list upon return
+func (nodeTwins *NodeTwins) GetUpdatedTwins(clearUpdates bool) []string {
+	nodeTwins.updateMutex.Lock()
+	defer nodeTwins.updateMutex.Unlock()
+	hwIDs := make([]string, 0, len(nodeTwins.updated))
+	for hwID := range nodeTwins.updated {
+		hwIDs = append(hwIDs, hwID)
+	}
+	sort.Strings(hwIDs)
+	if clearUpdates {
+		nodeTwins.updated = make(map[string]bool)
+	}
+	return hwIDs
+}
+
+// LoadTwins loads the desired properties of node twins from file
+// Twins that are updated before loading keep their desired properties. The loaded twins are marked as
+// updated and the desired handler is notified of their pending properties with NotifyLoadedTwin.
+func (nodeTwins *NodeTwins) LoadTwins(filename string) error {
+	twins := make(map[string]*NodeTwin)
+	jsonTwins, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return lib.MakeErrorf("LoadTwins: Unable to open file %s: %s", filename, err)
+	}
+	err = json.Unmarshal(jsonTwins, &twins)
+	if err != nil {
+		return lib.MakeErrorf("LoadTwins: Error parsing JSON twins file %s: %v", filename, err)
+	}
+	logrus.Infof("LoadTwins: Node twins loaded successfully from %s", filename)
+	nodeTwins.updateMutex.Lock()
+	defer nodeTwins.updateMutex.Unlock()
+	for hwID, loaded := range twins {
+		if nodeTwins.twins[hwID] == nil && loaded != nil && loaded.Desired != nil {
+			loaded.Reported = make(map[string]string)
+			loaded.ReportedVersion = 0
+			loaded.converged = len(loaded.pending()) == 0
+			loaded.loaded = true
+			nodeTwins.twins[hwID] = loaded
+			nodeTwins.updated[hwID] = true
+		}
+	}
+	return nil
+}
+
+// NotifyLoadedTwin notifies the desired handler of the pending properties of a loaded twin
+// Intended for when the node of the twin is created, as the device has to apply the desired properties
+// after a restart. The handler is only notified once for each loaded twin.
+func (nodeTwins *NodeTwins) NotifyLoadedTwin(hwID string) {
+	nodeTwins.updateMutex.Lock()
+	twin := nodeTwins.twins[hwID]
+	if twin == nil || !twin.loaded {
+		nodeTwins.updateMutex.Unlock()
+		return
+	}
+	twin.loaded = false
+	pending := make(map[string]string)
+	for _, name := range twin.pending() {
+		pending[name] = twin.Desired[name]
+	}
+	handler := nodeTwins.onDesired
+	nodeTwins.updateMutex.Unlock()
+
+	if len(pending) > 0 && handler != nil {
+		logrus.Infof("NodeTwins.NotifyLoadedTwin: Pending properties of node '%s': %v", hwID, pending)
+		handler(hwID, pending)
+	}
+}
+
+// SaveTwins saves the desired properties of node twins to file
+func (nodeTwins *NodeTwins) SaveTwins(filename string) error {
+	nodeTwins.updateMutex.Lock()
+	saved := make(map[string]*NodeTwin, len(nodeTwins.twins))
+	for hwID, twin := range nodeTwins.twins {
+		saved[hwID] = &NodeTwin{Desired: twin.Desired, DesiredVersion: twin.DesiredVersion}
+	}
+	jsonText, err := json.MarshalIndent(saved, "", "  ")
+	nodeTwins.updateCount = 0
+	nodeTwins.updateMutex.Unlock()
+	if err != nil {
+		return lib.MakeErrorf("SaveTwins: Error Marshalling JSON twins '%s': %v", filename, err)
+	}
+	err = ioutil.WriteFile(filename, jsonText, 0664)
+	if err != nil {
+		return lib.MakeErrorf("SaveTwins: Error saving twins to JSON file %s: %v", filename, err)
+	}
+	logrus.Infof("SaveTwins: Node twins saved successfully to JSON file %s", filename)
+	return nil
+}
+
+// SetDesired changes the desired properties of a node twin and notifies the desired handler of the
+// changes. The twin is created if it doesn't exist.
+//  desired holds the properties to change. An empty value removes the desired property.
+// This returns the changed properties.
+func (nodeTwins *NodeTwins) SetDesired(hwID string, desired map[string]string) (changed map[string]string) {
+	nodeTwins.updateMutex.Lock()
+	twin := nodeTwins.getTwin(hwID)
+	changed = mergeTwinProperties(twin.Desired, desired)
+	if len(changed) > 0 {
+		twin.DesiredVersion++
+		nodeTwins.updateCount++
+		nodeTwins.updated[hwID] = true
+	}
+	converged := nodeTwins.updateConverged(twin)
+	handler := nodeTwins.onDesired
+	convergedHandler := nodeTwins.onConverged
+	nodeTwins.updateMutex.Unlock()
+
+	if len(changed) > 0 {
+		logrus.Infof("NodeTwins.SetDesired: Desired properties of node '%s' changed: %v", hwID, changed)
+		if handler != nil {
+			handler(hwID, changed)
+		}
+	}
+	if converged != nil && convergedHandler != nil {
+		convergedHandler(hwID, converged)
+	}
+	return changed
+}
+
+// SetOnConverged sets the handler that is invoked when a node twin converges. Use nil to remove the handler.
+func (nodeTwins *NodeTwins) SetOnConverged(handler ConvergedHandler) {
+	nodeTwins.updateMutex.Lock()
+	defer nodeTwins.updateMutex.Unlock()
+	nodeTwins.onConverged = handler
+}
+
+// SetOnDesired sets the handler that is invoked when desired properties change. Use nil to remove the handler.
+func (nodeTwins *NodeTwins) SetOnDesired(handler DesiredHandler) {
+	nodeTwins.updateMutex.Lock()
+	defer nodeTwins.updateMutex.Unlock()
+	nodeTwins.onDesired = handler
+}
+
+// SetReported changes the reported properties of a node twin. The twin is created if it doesn't exist.
+//  reported holds the properties reported by the device. An empty value removes the reported property.
+// This returns true if the reported properties have changed.
+func (nodeTwins *NodeTwins) SetReported(hwID string, reported map[string]string) bool {
+	nodeTwins.updateMutex.Lock()
+	twin := nodeTwins.getTwin(hwID)
+	changed := mergeTwinProperties(twin.Reported, reported)
+	if len(changed) > 0 {
+		twin.ReportedVersion++
+		nodeTwins.updated[hwID] = true
+	}
+	converged := nodeTwins.updateConverged(twin)
+	convergedHandler := nodeTwins.onConverged
+	nodeTwins.updateMutex.Unlock()
+
+	if converged != nil {
+		logrus.Infof("NodeTwins.SetReported: Twin of node '%s' converged at desired version %d",
+			hwID, converged.DesiredVersion)
+		if convergedHandler != nil {
+			convergedHandler(hwID, converged)
+		}
+	}
+	return len(changed) > 0
+}
+
+// UpdateCount returns the nr of desired property updates since the twins were last saved
+func (nodeTwins *NodeTwins) UpdateCount() int {
+	nodeTwins.updateMutex.Lock()
+	defer nodeTwins.updateMutex.Unlock()
+	return nodeTwins.updateCount
+}
+
+// getTwin returns the twin of a node and creates it if it doesn't exist. Use within a locked section.
+func (nodeTwins *NodeTwins) getTwin(hwID string) *NodeTwin {
+	twin := nodeTwins.twins[hwID]
+	if twin == nil {
+		twin = &NodeTwin{
+			Desired:   make(map[string]string),
+			Reported:  make(map[string]string),
+			converged: true,
+		}
+		nodeTwins.twins[hwID] = twin
+	}
+	return twin
+}
+
+// updateConverged updates the converged state of a twin and returns a copy of the twin if it has
+// converged since the last update, or nil if not. Use within a locked section.
+func (nodeTwins *NodeTwins) updateConverged(twin *NodeTwin) *types.NodeTwinMessage {
+	converged := len(twin.pending()) == 0
+	hasConverged := converged && !twin.converged
+	twin.converged = converged
+	if !hasConverged {
+		return nil
+	}
+	return twin.makeMessage()
+}
+
+// makeMessage returns the twin message of a twin without the address
+func (twin *NodeTwin) makeMessage() *types.NodeTwinMessage {
+	message := &types.NodeTwinMessage{
+		Desired:         make(map[string]string, len(twin.Desired)),
+		DesiredVersion:  twin.DesiredVersion,
+		Pending:         twin.pending(),
+		Reported:        make(map[string]string, len(twin.Reported)),
+		ReportedVersion: twin.ReportedVersion,
+	}
+	for name, value := range twin.Desired {
+		message.Desired[name] = value
+	}
+	for name, value := range twin.Reported {
+		message.Reported[name] = value
+	}
+	message.Converged = len(message.Pending) == 0
+	return message
+}
+
+// pending returns the sorted names of the desired properties that don't match the reported properties
+func (twin *NodeTwin) pending() []string {
+	pending := make([]string, 0)
+	for name, value := range twin.Desired {
+		if twin.Reported[name] != value {
+			pending = append(pending, name)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+// mergeTwinProperties merges properties into a twin property map and returns the changed properties
+// An empty value removes the property.
+func mergeTwinProperties(properties map[string]string, update map[string]string) map[string]string {
+	changed := make(map[string]string)
+	for name, value := range update {
+		current, found := properties[name]
+		if value == "" && found {
+			delete(properties, name)
+			changed[name] = value
+		} else if value != "" && (!found || current != value) {
+			properties[name] = value
+			changed[name] = value
+		}
+	}
+	return changed
+}
+
+// MakeTwinAddress returns the address of the $twin publication of a node
+func MakeTwinAddress(domain string, publisherID string, nodeID string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", domain, publisherID, nodeID, types.MessageTypeTwin)
+}
+
+// PublishNodeTwin publishes the twin of a node retained=true
+//  twin is the twin of the node obtained with GetTwin
+func PublishNodeTwin(node *types.NodeDiscoveryMessage, twin *types.NodeTwinMessage,
+	messageSigner *messaging.MessageSigner) error {
+
+	// twins are published using the node alias address, if any
+	segments := strings.Split(node.Address, "/")
+	if len(segments) < 3 {
+		return lib.MakeErrorf("PublishNodeTwin: Node address %s is invalid", node.Address)
+	}
+	twin.Address = MakeTwinAddress(segments[0], segments[1], segments[2])
+	twin.Timestamp = time.Now().Format(types.TimeFormat)
+	logrus.Infof("PublishNodeTwin: Twin of node %s to %s", node.HWID, twin.Address)
+	return messageSigner.PublishObject(twin.Address, true, twin, nil)
+}
+
+// NewNodeTwins creates a new collection of node twins
+func NewNodeTwins() *NodeTwins {
+	return &NodeTwins{
+		twins:       make(map[string]*NodeTwin),
+		updated:     make(map[string]bool),
+		updateMutex: &sync.Mutex{},
+	}
+}
@@ -0,0 +1,75 @@
+package nodes_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNodeTwins(t *testing.T) {
+	twins := nodes.NewNodeTwins()
+	assert.Nil(t, twins.GetTwin("hw1"))
+	converged := 0
+	twins.SetOnConverged(func(hwID string, twin *types.NodeTwinMessage) {
+		converged++
+	})
+
+	changed := twins.SetDesired("hw1", map[string]string{"brightness": "50", "color": "red"})
+	assert.Len(t, changed, 2)
+	assert.Empty(t, twins.SetDesired("hw1", map[string]string{"brightness": "50"}))
+	assert.Equal(t, []string{"hw1"}, twins.GetUpdatedTwins(true))
+	assert.Empty(t, twins.GetUpdatedTwins(false))
+
+	// reporting one property leaves the other pending
+	assert.True(t, twins.SetReported("hw1", map[string]string{"brightness": "50"}))
+	assert.Equal(t, map[string]string{"color": "red"}, twins.GetPending("hw1"))
+	assert.Equal(t, 0, converged)
+
+	// removing the pending desired property converges the twin
+	changed = twins.SetDesired("hw1", map[string]string{"color": ""})
+	assert.Equal(t, map[string]string{"color": ""}, changed)
+	assert.Equal(t, 1, converged)
+	twin := twins.GetTwin("hw1")
+	require.NotNil(t, twin)
+	assert.True(t, twin.Converged)
+	assert.Equal(t, uint64(2), twin.DesiredVersion)
+	assert.Equal(t, uint64(1), twin.ReportedVersion)
+	assert.False(t, twins.SetReported("hw1", map[string]string{"brightness": "50"}))
+
+	// the desired properties are saved and loaded
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	filename := filepath.Join(tempFolder, "twins.json")
+	assert.Equal(t, 2, twins.UpdateCount())
+	err := twins.SaveTwins(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 0, twins.UpdateCount())
+
+	loaded := nodes.NewNodeTwins()
+	err = loaded.LoadTwins(filename)
+	require.NoError(t, err)
+	twin = loaded.GetTwin("hw1")
+	require.NotNil(t, twin)
+	assert.Equal(t, map[string]string{"brightness": "50"}, twin.Desired)
+	assert.Empty(t, twin.Reported)
+	assert.Equal(t, []string{"brightness"}, twin.Pending)
+
+	// the desired handler is notified once of the pending properties of a loaded twin
+	notified := make([]map[string]string, 0)
+	loaded.SetOnDesired(func(hwID string, changed map[string]string) {
+		notified = append(notified, changed)
+	})
+	loaded.NotifyLoadedTwin("hw1")
+	loaded.NotifyLoadedTwin("hw1")
+	loaded.NotifyLoadedTwin("hw2")
+	assert.Equal(t, []map[string]string{{"brightness": "50"}}, notified)
+	loaded.ClearUpdated([]string{"hw1"})
+	assert.Empty(t, loaded.GetUpdatedTwins(false))
+	assert.Error(t, loaded.LoadTwins(filepath.Join(tempFolder, "missing.json")))
+}
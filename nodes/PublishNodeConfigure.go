@@ -17,6 +17,24 @@ func PublishNodeConfigure(
 	destinationAddress string, attr types.NodeAttrMap, sender string,
 	messageSigner *messaging.MessageSigner, encryptionKey *ecdsa.PublicKey) {
 
+	publishNodeConfigure(destinationAddress, attr, sender, false, messageSigner, encryptionKey)
+}
+
+// PublishNodeConfigureValidate sends a command to validate a configuration update of a remote node
+// without applying it. The node's publisher responds on the node's $configureResult address with
+// the changes that would be made.
+func PublishNodeConfigureValidate(
+	destinationAddress string, attr types.NodeAttrMap, sender string,
+	messageSigner *messaging.MessageSigner, encryptionKey *ecdsa.PublicKey) {
+
+	publishNodeConfigure(destinationAddress, attr, sender, true, messageSigner, encryptionKey)
+}
+
+// publishNodeConfigure sends the configure command, optionally as validate-only
+func publishNodeConfigure(
+	destinationAddress string, attr types.NodeAttrMap, sender string, validateOnly bool,
+	messageSigner *messaging.MessageSigner, encryptionKey *ecdsa.PublicKey) {
+
 	logrus.Infof("PublishNodeConfigure: publishing encrypted configuration to %s", destinationAddress)
 	// Check that address is one of our inputs
 	segments := strings.Split(destinationAddress, "/")
@@ -31,10 +49,11 @@ func PublishNodeConfigure(
 	// Encecode the SetMessage
 	timeStampStr := time.Now().Format("2006-01-02T15:04:05.000-0700")
 	var configureMessage = types.NodeConfigureMessage{
-		Address:   configAddr,
-		Sender:    sender,
-		Timestamp: timeStampStr,
-		Attr:      attr,
+		Address:      configAddr,
+		Sender:       sender,
+		Timestamp:    timeStampStr,
+		Attr:         attr,
+		ValidateOnly: validateOnly,
 	}
 	messageSigner.PublishObject(configAddr, false, &configureMessage, encryptionKey)
 }
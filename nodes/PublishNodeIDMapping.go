@@ -0,0 +1,37 @@
+// Package nodes with publication of changes to the node ID mappings of a publisher
+package nodes
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// MakeNodeIDMappingAddress returns the address of the node ID mapping changes of a publisher
+func MakeNodeIDMappingAddress(domain string, publisherID string) string {
+	return fmt.Sprintf("%s/%s/%s", domain, publisherID, types.MessageTypeNodeIDMapping)
+}
+
+// PublishNodeIDMapping publishes a change of a node ID mapping with all mappings of the publisher (retained)
+// Consumers use this to resolve references with a previous node ID to the hardware ID of the node.
+//  change of the mapping
+//  mappings with the node IDs by hardware ID of all mapped nodes
+func PublishNodeIDMapping(domain string, publisherID string, change NodeIDMappingChange,
+	mappings map[string]string, messageSigner *messaging.MessageSigner) error {
+
+	addr := MakeNodeIDMappingAddress(domain, publisherID)
+	logrus.Infof("PublishNodeIDMapping: Node '%s' node ID changed from '%s' to '%s'",
+		change.HWID, change.PreviousNodeID, change.NodeID)
+	message := &types.NodeIDMappingMessage{
+		Address:        addr,
+		HWID:           change.HWID,
+		Mappings:       mappings,
+		NodeID:         change.NodeID,
+		PreviousNodeID: change.PreviousNodeID,
+		Timestamp:      time.Now().Format(types.TimeFormat),
+	}
+	return messageSigner.PublishObject(addr, true, message, nil)
+}
@@ -0,0 +1,35 @@
+// Package nodes with command to set the desired properties of a remote node twin
+package nodes
+
+import (
+	"crypto/ecdsa"
+	"strings"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// PublishSetDesired publishes the command to change the desired properties of a remote node twin using
+// the node address. This signs and encrypts the message for the destination
+//  desired holds the properties to change. An empty value removes the desired property.
+func PublishSetDesired(
+	nodeAddress string, desired map[string]string, sender string,
+	messageSigner *messaging.MessageSigner, encryptionKey *ecdsa.PublicKey) error {
+
+	logrus.Infof("PublishSetDesired: publishing encrypted message to %s", nodeAddress)
+	segments := strings.Split(nodeAddress, "/")
+	if len(segments) < 3 {
+		return lib.MakeErrorf("PublishSetDesired: Node address %s is invalid", nodeAddress)
+	}
+	setDesiredAddr := MakeSetDesiredAddress(segments[0], segments[1], segments[2])
+	var message = types.SetDesiredMessage{
+		Address:   setDesiredAddr,
+		Desired:   desired,
+		Sender:    sender,
+		Timestamp: time.Now().Format(types.TimeFormat),
+	}
+	return messageSigner.PublishObject(setDesiredAddr, false, &message, encryptionKey)
+}
@@ -4,6 +4,7 @@ package nodes
 import (
 	"crypto/ecdsa"
 	"sync"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
@@ -15,6 +16,11 @@ import (
 // The handler is only invoked if the node is confirmed to exist.
 type NodeConfigureHandler func(nodeHWID string, params types.NodeAttrMap)
 
+// NodeValidateHandler application handler to validate a node's configuration without applying it.
+// It returns the configuration values it rejects with the reason. The handler is invoked with
+// the values that pass datatype validation and would change.
+type NodeValidateHandler func(nodeHWID string, params types.NodeAttrMap) map[types.NodeAttr]string
+
 // ReceiveNodeConfigure with handling of node configure commands aimed at nodes managed by this publisher.
 // This decrypts incoming messages determines the sender and verifies the signature with
 // the sender public key.
@@ -22,6 +28,7 @@ type ReceiveNodeConfigure struct {
 	domain               string                   // the domain of this publisher
 	publisherID          string                   // the registered publisher for the inputs
 	nodeConfigureHandler NodeConfigureHandler     // handler to pass the command to
+	nodeValidateHandler  NodeValidateHandler      // handler to pass validate-only commands to
 	messageSigner        *messaging.MessageSigner // subscription and publication messenger
 	privateKey           *ecdsa.PrivateKey        // private key for decrypting set command messages
 	registeredNodes      *RegisteredNodes         // registered nodes of this publisher
@@ -34,6 +41,12 @@ func (nodeConfigure *ReceiveNodeConfigure) SetConfigureNodeHandler(
 	nodeConfigure.nodeConfigureHandler = handler
 }
 
+// SetValidateNodeHandler set the handler for validating node configuration without applying it
+func (nodeConfigure *ReceiveNodeConfigure) SetValidateNodeHandler(
+	handler func(nodeHWID string, params types.NodeAttrMap) map[types.NodeAttr]string) {
+	nodeConfigure.nodeValidateHandler = handler
+}
+
 // Start listening for configure commands
 func (nodeConfigure *ReceiveNodeConfigure) Start() {
 	nodeConfigure.updateMutex.Lock()
@@ -55,6 +68,7 @@ func (nodeConfigure *ReceiveNodeConfigure) Stop() {
 // - check if the message is encrypted
 // - check if the signature is valid
 // - check if the node is valid
+// - if the command is validate-only, publish the changes that would be made and stop
 // - if a configuration handler is set, let it apply the configuration
 // - save node configuration if persistence is set
 // TODO: support for authorization per node
@@ -79,6 +93,10 @@ func (nodeConfigure *ReceiveNodeConfigure) receiveConfigureCommand(nodeAddress s
 	logrus.Infof("receiveConfigureCommand configure command on address %s. isEncrypted=%t, isSigned=%t", nodeAddress, isEncrypted, isSigned)
 
 	params := configureMessage.Attr
+	if configureMessage.ValidateOnly {
+		nodeConfigure.validateConfigureCommand(node, &configureMessage)
+		return nil
+	}
 	if nodeConfigure.nodeConfigureHandler != nil {
 		// A handler can determine which configuration updates are applied
 		nodeConfigure.nodeConfigureHandler(node.HWID, params)
@@ -89,6 +107,36 @@ func (nodeConfigure *ReceiveNodeConfigure) receiveConfigureCommand(nodeAddress s
 	return nil
 }
 
+// validateConfigureCommand validates the configuration without applying it and publishes the
+// result to the node's $configureResult address. The result is encrypted if the sender's key is known.
+func (nodeConfigure *ReceiveNodeConfigure) validateConfigureCommand(
+	node *types.NodeDiscoveryMessage, configureMessage *types.NodeConfigureMessage) {
+
+	changes, errs := nodeConfigure.registeredNodes.ValidateNodeConfigValues(node.HWID, configureMessage.Attr)
+	if nodeConfigure.nodeValidateHandler != nil && len(changes) > 0 {
+		rejected := nodeConfigure.nodeValidateHandler(node.HWID, changes)
+		for key, reason := range rejected {
+			delete(changes, key)
+			errs[key] = reason
+		}
+	}
+	resultAddr := MakeNodeConfigureResultAddress(nodeConfigure.domain, nodeConfigure.publisherID, node.NodeID)
+	resultMessage := types.NodeConfigureResultMessage{
+		Address:   resultAddr,
+		Changes:   changes,
+		Errors:    errs,
+		Sender:    configureMessage.Sender,
+		Timestamp: time.Now().Format(types.TimeFormat),
+	}
+	var encryptionKey *ecdsa.PublicKey
+	if nodeConfigure.messageSigner.GetPublicKey != nil {
+		encryptionKey = nodeConfigure.messageSigner.GetPublicKey(configureMessage.Sender)
+	}
+	logrus.Infof("validateConfigureCommand: publish configure result of node %s with %d changes and %d errors",
+		node.Address, len(changes), len(errs))
+	nodeConfigure.messageSigner.PublishObject(resultAddr, false, &resultMessage, encryptionKey)
+}
+
 // NewReceiveNodeConfigure returns a new instance of handling of node configuration commands.
 func NewReceiveNodeConfigure(
 	domain string,
@@ -0,0 +1,102 @@
+// Package nodes with receiving of the command to set the desired properties of a node twin
+package nodes
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// SetDesiredHandler callback when a command to set the desired properties of a node twin is received
+//  nodeAddress is the discovery address of the node
+type SetDesiredHandler func(nodeAddress string, message *types.SetDesiredMessage)
+
+// ReceiveSetDesired listener
+// This decrypts incoming messages, determines the sender and verifies the signature with
+// the sender public key.
+type ReceiveSetDesired struct {
+	domain        string                   // the domain of this publisher
+	publisherID   string                   // the registered publisher for the nodes
+	messageSigner *messaging.MessageSigner // subscription and publication messenger
+	handler       SetDesiredHandler        // handler to pass the command to
+	updateMutex   *sync.Mutex              // mutex for async handling of commands
+}
+
+// SetDesiredHandler sets the handler for setting desired properties
+func (setDesired *ReceiveSetDesired) SetDesiredHandler(handler SetDesiredHandler) {
+	setDesired.updateMutex.Lock()
+	defer setDesired.updateMutex.Unlock()
+	setDesired.handler = handler
+}
+
+// Start listening for set desired commands
+func (setDesired *ReceiveSetDesired) Start() {
+	addr := MakeSetDesiredAddress(setDesired.domain, setDesired.publisherID, "+")
+	setDesired.messageSigner.Subscribe(addr, setDesired.decodeSetDesiredCommand)
+}
+
+// Stop listening for set desired commands
+func (setDesired *ReceiveSetDesired) Stop() {
+	addr := MakeSetDesiredAddress(setDesired.domain, setDesired.publisherID, "+")
+	setDesired.messageSigner.Unsubscribe(addr, setDesired.decodeSetDesiredCommand)
+}
+
+// decodeSetDesiredCommand decrypts and verifies the signature of an incoming set desired command.
+// If successful this passes the command to the handler
+func (setDesired *ReceiveSetDesired) decodeSetDesiredCommand(address string, message string) error {
+	var setDesiredMessage types.SetDesiredMessage
+
+	// a full address is required: domain/pub/node/$setDesired
+	segments := strings.Split(address, "/")
+	if len(segments) < 4 {
+		return lib.MakeErrorf("decodeSetDesiredCommand: address '%s' is incomplete", address)
+	}
+	segments[3] = types.MessageTypeNodeDiscovery
+	nodeAddr := strings.Join(segments, "/")
+
+	isEncrypted, isSigned, err := setDesired.messageSigner.DecodeMessage(message, &setDesiredMessage)
+	if !isEncrypted {
+		return lib.MakeErrorf("decodeSetDesiredCommand: Update of '%s' is not encrypted. Message discarded.", address)
+	} else if !isSigned {
+		return lib.MakeErrorf("decodeSetDesiredCommand: Update of '%s' is not signed. Message discarded.", address)
+	} else if err != nil {
+		return lib.MakeErrorf("decodeSetDesiredCommand: Message to %s. Error %s'. Message discarded.", address, err)
+	}
+	logrus.Infof("decodeSetDesiredCommand on address %s from %s", address, setDesiredMessage.Sender)
+
+	setDesired.updateMutex.Lock()
+	handler := setDesired.handler
+	setDesired.updateMutex.Unlock()
+	if handler != nil {
+		handler(nodeAddr, &setDesiredMessage)
+	} else {
+		logrus.Errorf("decodeSetDesiredCommand: command received on address %s, but no handler is configured.", address)
+	}
+	return nil
+}
+
+// MakeSetDesiredAddress creates the address used to set the desired properties of a node twin
+func MakeSetDesiredAddress(domain string, publisherID string, nodeID string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", domain, publisherID, nodeID, types.MessageTypeSetDesired)
+}
+
+// NewReceiveSetDesired returns a new instance of handling of the $setDesired command.
+func NewReceiveSetDesired(
+	domain string,
+	publisherID string,
+	setDesiredHandler SetDesiredHandler,
+	messageSigner *messaging.MessageSigner) *ReceiveSetDesired {
+	receiver := &ReceiveSetDesired{
+		domain:        domain,
+		messageSigner: messageSigner,
+		handler:       setDesiredHandler,
+		publisherID:   publisherID,
+		updateMutex:   &sync.Mutex{},
+	}
+	return receiver
+}
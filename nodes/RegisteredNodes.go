@@ -3,9 +3,9 @@ package nodes
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,6 +16,9 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// NodeSelector selects the nodes for a bulk update. It returns true for nodes to include.
+type NodeSelector func(node *types.NodeDiscoveryMessage) bool
+
 // RegisteredNodes manages the publisher's node registration and publication for discovery
 // Nodes are immutable. Any modifications made are applied to a new instance. The old node instance
 // is discarded and replaced with the new instance.
@@ -23,13 +26,21 @@ import (
 // A registered node is identified by its hwID which is immutable and relates to the hardware the
 // node is attached to. Its nodeID is used for publication and can change.
 type RegisteredNodes struct {
+	cachedHWIDs map[string]bool                        // nodes loaded from cache that haven't been rediscovered
 	domain      string                                 // domain these nodes belong to
 	publisherID string                                 // ID of the publisher these nodes belong to
 	deviceMap   map[string]*types.NodeDiscoveryMessage // registered nodes by device ID
+	flapping    *FlapDetector                          // detection of flapping nodes, nil when disabled
+	maxNodes    int                                    // max nr of registered nodes. 0 is unlimited
 	// onSetNodeID  func(node *types.NodeDiscoveryMessage, newID string) // notify of a change in node ID. Use this to update input and output addresses
-	nodeMap      map[string]*types.NodeDiscoveryMessage // registered nodes by node ID
-	updatedNodes map[string]*types.NodeDiscoveryMessage // updated nodes by device ID
-	updateMutex  *sync.Mutex                            // mutex for async updating of nodes
+	nodeMap        map[string]*types.NodeDiscoveryMessage // registered nodes by node ID
+	nodeIDMappings *NodeIDMappings                        // persisted node IDs by device ID
+	provisioned    map[string]types.NodeAttrMap           // provisioned attributes by device ID that take precedence over discovered attributes
+	rejectedNodes  int                                    // nr of new nodes rejected because the max nr of nodes is reached
+	updatedNodes   map[string]*types.NodeDiscoveryMessage // updated nodes by device ID
+	updateMutex    *sync.Mutex                            // mutex for async updating of nodes
+	updateNotifier *lib.UpdateNotifier                    // notify subscribers of updated nodes
+	updateTracker  *lib.UpdateTracker                     // track updates for consumers with a cursor
 }
 
 // Clone returns a copy of the node with new Attr, Config and Status maps
@@ -42,8 +53,11 @@ func (regNodes *RegisteredNodes) Clone(node *types.NodeDiscoveryMessage) *types.
 	for key, value := range node.Attr {
 		newNode.Attr[key] = value
 	}
-	// Shallow copy of the config list
-	newNode.Config = node.Config
+	// the config values are copied. Their Enum lists are shared.
+	newNode.Config = make(map[types.NodeAttr]types.ConfigAttr)
+	for key, value := range node.Config {
+		newNode.Config[key] = value
+	}
 
 	newNode.Status = make(map[types.NodeStatus]string)
 	for key, value := range node.Status {
@@ -53,21 +67,93 @@ func (regNodes *RegisteredNodes) Clone(node *types.NodeDiscoveryMessage) *types.
 }
 
 // CreateNode creates a node instance for a device or service and adds it to the list. If the node exists it will remain unchanged.
-// This returns the existing node instance or a newly created instance
+// Creating a node that was loaded from cache marks it as rediscovered. If it was marked missing its
+// runState is set to ready and its lastSeen status is cleared.
+// A new node is published with the node ID that its hwID is mapped to, if any.
+// Creating a provisioned node marks it as discovered. Its runState is set to ready and its type is
+// set if the type wasn't provisioned.
+// Use CreateOrUpdateNode to also refresh the type and attributes of an existing node.
+// This returns the existing node instance or a newly created instance, or nil if the max nr of nodes
+// is reached. See SetMaxNodes.
 func (regNodes *RegisteredNodes) CreateNode(hwID string, nodeType types.NodeType) *types.NodeDiscoveryMessage {
-	existingNode := regNodes.GetNodeByHWID(hwID)
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+
+	delete(regNodes.cachedHWIDs, hwID)
+	existingNode := regNodes.deviceMap[hwID]
 	if existingNode != nil {
+		runState := existingNode.Status[types.NodeStatusRunState]
+		_, hasLastSeen := existingNode.Status[types.NodeStatusLastSeen]
+		if runState == types.NodeRunStateMissing || runState == types.NodeRunStateProvisioned || hasLastSeen {
+			newNode := regNodes.Clone(existingNode)
+			markRediscovered(newNode)
+			if newNode.Attr[types.NodeAttrType] == string(types.NodeTypeUnknown) && nodeType != "" {
+				newNode.Attr[types.NodeAttrType] = string(nodeType)
+			}
+			regNodes.updateNode(newNode)
+			return newNode
+		}
 		return existingNode
 	}
-
-	regNodes.updateMutex.Lock()
-	defer regNodes.updateMutex.Unlock()
+	if regNodes.isNodeQuotaReached("CreateNode", hwID) {
+		return nil
+	}
 
 	newNode := NewNode(regNodes.domain, regNodes.publisherID, hwID, nodeType)
+	regNodes.applyNodeIDMapping(newNode)
 	regNodes.updateNode(newNode)
 	return newNode
 }
 
+// CreateOrUpdateNode creates a node like CreateNode and merges the given attributes into the node.
+// Intended for discovery that is repeated to refresh the attributes of the node. Unlike CreateNode the
+// type of an existing node is updated, unless it was provisioned. Provisioned attributes remain unchanged.
+// The node is only marked as updated if it is new or rediscovered, or its type or attributes have changed.
+//  nodeType of the device. Use "" or types.NodeTypeUnknown to keep the type of an existing node
+//  attr with the attributes to merge, for example the manufacturer and model. Use nil to only create the node.
+// This returns the updated, existing or newly created node instance, or nil if the max nr of nodes is reached
+func (regNodes *RegisteredNodes) CreateOrUpdateNode(
+	hwID string, nodeType types.NodeType, attr types.NodeAttrMap) *types.NodeDiscoveryMessage {
+
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+
+	delete(regNodes.cachedHWIDs, hwID)
+	provisioned, isProvisioned := regNodes.provisioned[hwID]
+	existingNode := regNodes.deviceMap[hwID]
+	var newNode *types.NodeDiscoveryMessage
+	if existingNode == nil {
+		if regNodes.isNodeQuotaReached("CreateOrUpdateNode", hwID) {
+			return nil
+		}
+		if nodeType == "" {
+			nodeType = types.NodeTypeUnknown
+		}
+		newNode = NewNode(regNodes.domain, regNodes.publisherID, hwID, nodeType)
+		if newNode == nil {
+			return nil
+		}
+		regNodes.applyNodeIDMapping(newNode)
+	} else {
+		newNode = regNodes.Clone(existingNode)
+		markRediscovered(newNode)
+		if nodeType != "" && nodeType != types.NodeTypeUnknown &&
+			(!isProvisioned || newNode.Attr[types.NodeAttrType] == string(types.NodeTypeUnknown)) {
+			newNode.Attr[types.NodeAttrType] = string(nodeType)
+		}
+	}
+	for key, value := range attr {
+		if _, isProvisionedAttr := provisioned[key]; !isProvisionedAttr {
+			newNode.Attr[key] = value
+		}
+	}
+	if existingNode == nil || isNodeChanged(existingNode, newNode) {
+		regNodes.updateNode(newNode)
+		return newNode
+	}
+	return existingNode
+}
+
 // CreateNodeConfig creates a new node configuration instance and adds it to the node with the given ID.
 // If the configuration already exists, its dataType, description and defaultValue are updated
 //  attrName is the configuration attribute name. See also types.NodeAttr for standard IDs
@@ -94,6 +180,9 @@ func (regNodes *RegisteredNodes) CreateNodeConfig(
 			Description: description,
 			Default:     defaultValue,
 		}
+	} else if config.DataType == dataType && config.Default == defaultValue && config.Description == description {
+		// unchanged configuration doesn't need republishing
+		return &config
 	} else {
 		config.DataType = dataType
 		config.Default = defaultValue
@@ -106,8 +195,25 @@ func (regNodes *RegisteredNodes) CreateNodeConfig(
 }
 
 // DeleteNode deletes a node from the collection of registered nodes
+// The node's inputs and outputs are not affected.
 func (regNodes *RegisteredNodes) DeleteNode(hwAddress string) {
-	// TODO
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+
+	node := regNodes.deviceMap[hwAddress]
+	if node == nil {
+		return
+	}
+	delete(regNodes.cachedHWIDs, hwAddress)
+	delete(regNodes.deviceMap, hwAddress)
+	delete(regNodes.nodeMap, node.NodeID)
+	if regNodes.updatedNodes == nil {
+		regNodes.updatedNodes = make(map[string]*types.NodeDiscoveryMessage)
+	}
+	// the nil node isn't published but marks the collection as changed so the deletion is saved
+	regNodes.updatedNodes[node.Address] = nil
+	regNodes.updateNotifier.Notify(hwAddress)
+	regNodes.updateTracker.Update(hwAddress)
 }
 
 // GetAllNodes returns a list of nodes
@@ -122,6 +228,28 @@ func (regNodes *RegisteredNodes) GetAllNodes() []*types.NodeDiscoveryMessage {
 	return nodeList
 }
 
+// GetGroups returns the sorted groups of the registered nodes, including the parent groups
+func (regNodes *RegisteredNodes) GetGroups() []string {
+	return getNodeGroups(regNodes.GetAllNodes())
+}
+
+// GetMissingNodes returns the nodes that were loaded from cache and haven't been rediscovered
+// with CreateNode since. Intended to detect devices that are no longer present after discovery.
+// Provisioned nodes that haven't been discovered yet are not missing.
+func (regNodes *RegisteredNodes) GetMissingNodes() []*types.NodeDiscoveryMessage {
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+
+	var nodeList = make([]*types.NodeDiscoveryMessage, 0)
+	for hwID := range regNodes.cachedHWIDs {
+		node := regNodes.deviceMap[hwID]
+		if node != nil && node.Status[types.NodeStatusRunState] != types.NodeRunStateProvisioned {
+			nodeList = append(nodeList, node)
+		}
+	}
+	return nodeList
+}
+
 // GetNodeAttr returns a node attribute value
 func (regNodes *RegisteredNodes) GetNodeAttr(nodeHWID string, attrName types.NodeAttr) string {
 	regNodes.updateMutex.Lock()
@@ -169,106 +297,78 @@ func (regNodes *RegisteredNodes) GetNodeByNodeID(nodeID string) *types.NodeDisco
 }
 
 // GetNodeConfigBool returns the node configuration value as a boolean
-// address starts with the node's address
-// This retuns the provided default value if no value is set or no default is configured, or the value is not an integer
-// An error is returned when the node or configuration doesn't exist
+// This retuns the provided default value if no value is set and no default is configured, or on error.
+// An error is returned when the node or configuration doesn't exist or the value is not a boolean.
+// Use ReadNodeConfigBool to distinguish between these cases.
 func (regNodes *RegisteredNodes) GetNodeConfigBool(
 	nodeHWID string, attrName types.NodeAttr, defaultValue bool) (value bool, err error) {
 
-	valueStr, err := regNodes.GetNodeConfigString(nodeHWID, attrName, "")
-	if err != nil {
+	value, found, err := regNodes.ReadNodeConfigBool(nodeHWID, attrName)
+	if err != nil || !found {
 		return defaultValue, err
 	}
-	if valueStr == "" {
-		return defaultValue, nil
-	}
-	value, err = strconv.ParseBool(valueStr)
-	if err != nil {
-		msg := fmt.Sprintf("NodeList.GetNodeConfigBool: Node '%s' configuration '%s' is not a boolean: %s",
-			nodeHWID, attrName, err)
-		return defaultValue, errors.New(msg)
-	}
 	return value, nil
 }
 
 // GetNodeConfigFloat returns the node configuration value as an floating point number
-// address starts with the node's address
-// This retuns the provided default value if no value is set or no default is configured, or the value is not an integer
-// An error is returned when the node or configuration doesn't exist or is not an integer
+// This retuns the provided default value if no value is set and no default is configured, or on error.
+// An error is returned when the node or configuration doesn't exist or the value is not a number.
+// Use ReadNodeConfigFloat to distinguish between these cases.
 func (regNodes *RegisteredNodes) GetNodeConfigFloat(
 	nodeHWID string, attrName types.NodeAttr, defaultValue float32) (value float32, err error) {
 
-	valueStr, err := regNodes.GetNodeConfigString(nodeHWID, attrName, "")
-	if err != nil {
+	value, found, err := regNodes.ReadNodeConfigFloat(nodeHWID, attrName)
+	if err != nil || !found {
 		return defaultValue, err
 	}
-	if valueStr == "" {
-		return defaultValue, nil
-	}
-	value64, err := strconv.ParseFloat(valueStr, 32)
-	value = float32(value64)
-	if err != nil {
-		msg := fmt.Sprintf("NodeList.GetNodeConfigFloat: Node '%s' configuration '%s' is not a float: %s",
-			nodeHWID, attrName, err)
-		return defaultValue, errors.New(msg)
-	}
 	return value, nil
 }
 
 // GetNodeConfigInt returns the node configuration value as an integer
-// This retuns the provided default value if no value is set or no default is configured, or the value is not an integer
-// An error is returned when the node or configuration doesn't exist or is not an integer
+// This retuns the provided default value if no value is set and no default is configured, or on error.
+// An error is returned when the node or configuration doesn't exist or the value is not an integer.
+// Use ReadNodeConfigInt to distinguish between these cases.
 func (regNodes *RegisteredNodes) GetNodeConfigInt(
 	nodeHWID string, attrName types.NodeAttr, defaultValue int) (value int, err error) {
 
-	valueStr, err := regNodes.GetNodeConfigString(nodeHWID, attrName, "")
-	if err != nil {
+	value, found, err := regNodes.ReadNodeConfigInt(nodeHWID, attrName)
+	if err != nil || !found {
 		return defaultValue, err
 	}
-	if valueStr == "" {
-		return defaultValue, nil
-	}
-	value, err = strconv.Atoi(valueStr)
-	if err != nil {
-		msg := fmt.Sprintf("NodeList.GetNodeConfigInt: Node '%s' configuration '%s' is not an integer: %s",
-			nodeHWID, attrName, err)
-		return defaultValue, errors.New(msg)
-	}
 	return value, nil
 }
 
 // GetNodeConfigString returns the attribute value of a node in this list
-// This retuns the provided default value if no value is set and no default is configured.
+// This retuns the provided default value if no value is set and no default is configured, or on error.
 // An error is returned when the node or configuration doesn't exist.
+// Use ReadNodeConfigString to distinguish between these cases.
 func (regNodes *RegisteredNodes) GetNodeConfigString(
 	nodeHWID string, attrName types.NodeAttr, defaultValue string) (value string, err error) {
-	regNodes.updateMutex.Lock()
-	defer regNodes.updateMutex.Unlock()
 
-	var node = regNodes.deviceMap[nodeHWID]
-	if node == nil {
-		msg := fmt.Sprintf("NodeList.GetNodeConfigString: Device '%s' not found", nodeHWID)
-		return defaultValue, errors.New(msg)
+	value, found, err := regNodes.ReadNodeConfigString(nodeHWID, attrName)
+	if err != nil || !found {
+		return defaultValue, err
 	}
+	return value, nil
+}
 
-	// in case of error, always return defaultValue
+// GetNodeQuota returns the nr of registered nodes, the max nr of nodes, and the nr of new nodes that
+// are rejected because the max was reached. A max of 0 is unlimited.
+func (regNodes *RegisteredNodes) GetNodeQuota() (nodeCount int, maxNodes int, rejected int) {
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+	return len(regNodes.deviceMap), regNodes.maxNodes, regNodes.rejectedNodes
+}
 
-	config, configExists := node.Config[attrName]
-	if !configExists {
-		msg := fmt.Sprintf("NodeList.GetNodeConfigString: Device '%s' configuration '%s' does not exist",
-			nodeHWID, attrName)
-		return defaultValue, errors.New(msg)
-	}
-	// if no value is known, use the configuration default
-	attrValue, exists := node.Attr[attrName]
-	if !exists || attrValue == "" {
-		attrValue = config.Default
-	}
-	// if still no value is known, use the provided default
-	if attrValue == "" {
-		return defaultValue, nil
-	}
-	return attrValue, nil
+// GetNodesByGroup returns the registered nodes in the group or one of its sub-groups
+//  group is the group path, eg building1/floor2
+func (regNodes *RegisteredNodes) GetNodesByGroup(group string) []*types.NodeDiscoveryMessage {
+	return filterNodesByGroup(regNodes.GetAllNodes(), group)
+}
+
+// GetNodesByTag returns the registered nodes that have the given tag
+func (regNodes *RegisteredNodes) GetNodesByTag(tag string) []*types.NodeDiscoveryMessage {
+	return filterNodesByTag(regNodes.GetAllNodes(), tag)
 }
 
 // GetUpdatedNodes returns the list of nodes that have been updated
@@ -290,6 +390,28 @@ func (regNodes *RegisteredNodes) GetUpdatedNodes(clearUpdates bool) []*types.Nod
 	return updateList
 }
 
+// GetUpdatedNodesSince returns the nodes that are updated after the given cursor without clearing
+// the updates. Intended for consumers that observe changes alongside the publisher heartbeat.
+// Deleted nodes are not included.
+//  cursor is the cursor returned by the previous call. Use 0 to get all nodes updated since creation.
+// This returns the updated nodes in order of update and the cursor to use for the next call.
+func (regNodes *RegisteredNodes) GetUpdatedNodesSince(cursor uint64) (
+	updateList []*types.NodeDiscoveryMessage, newCursor uint64) {
+
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+
+	hwIDs, newCursor := regNodes.updateTracker.GetUpdatedSince(cursor)
+	updateList = make([]*types.NodeDiscoveryMessage, 0)
+	for _, hwID := range hwIDs {
+		node := regNodes.deviceMap[hwID]
+		if node != nil {
+			updateList = append(updateList, node)
+		}
+	}
+	return updateList, newCursor
+}
+
 // // HandleSetAliasMessage handles a message for setting the node alias using the node address
 // func (regNodes *RegisteredNodes) HandleSetAliasMessage(nodeAddress string, msg *types.NodeAliasMessage) {
 // 	segments := strings.Split(nodeAddress, "/")
@@ -300,6 +422,10 @@ func (regNodes *RegisteredNodes) GetUpdatedNodes(clearUpdates bool) []*types.Nod
 
 // LoadNodes loads previously saved registered nodes.
 // Intended to persist changes to node configuration.
+// The node ID mappings take precedence over the node ID of loaded nodes. Node IDs of loaded nodes
+// without mapping are added to the mappings.
+// Loaded nodes are not marked as updated as their discovery was published before they were saved.
+// Only nodes that replace a different existing node are marked as updated.
 func (regNodes *RegisteredNodes) LoadNodes(filename string) error {
 	nodeList := make([]*types.NodeDiscoveryMessage, 0)
 
@@ -312,10 +438,146 @@ func (regNodes *RegisteredNodes) LoadNodes(filename string) error {
 		return lib.MakeErrorf("LoadNodes: Error parsing JSON node file %s: %v", filename, err)
 	}
 	logrus.Infof("LoadNodes: Node list loaded successfully from %s", filename)
-	regNodes.UpdateNodes(nodeList)
+	for _, node := range nodeList {
+		if node != nil {
+			regNodes.restoreNodeIDMapping(node)
+		}
+	}
+	regNodes.updateNodes(nodeList, false)
 	return nil
 }
 
+// NodeIDMappings returns the table of node IDs by hardware ID
+// Intended for persisting the mappings and looking up node IDs of nodes that aren't registered.
+func (regNodes *RegisteredNodes) NodeIDMappings() *NodeIDMappings {
+	return regNodes.nodeIDMappings
+}
+
+// OnUpdated subscribes a handler to updates of nodes. The handler is invoked asynchronously with the
+// hardware ID of each node that is created, updated or deleted, in order of update.
+// Intended for service-style consumers that don't want to poll GetUpdatedNodes.
+func (regNodes *RegisteredNodes) OnUpdated(handler func(nodeHWID string)) {
+	regNodes.updateNotifier.Subscribe(handler)
+}
+
+// ProvisionNode pre-registers a node that is expected to be discovered, for example before the
+// device is installed. A node that doesn't exist is created with the provisioned runState until it is
+// discovered with CreateNode. The provisioned attributes are merged with those of an existing node
+// and take precedence over attributes that are discovered with UpdateNodeAttr.
+//  hwID is the hardware ID of the expected device
+//  nodeType of the device. Use types.NodeTypeUnknown or "" to use the type of the discovered device
+//  attr with the provisioned attributes, for example its name and location name
+// This returns the provisioned node instance or nil if hwID is empty or the max nr of nodes is reached
+func (regNodes *RegisteredNodes) ProvisionNode(hwID string, nodeType types.NodeType, attr types.NodeAttrMap) *types.NodeDiscoveryMessage {
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+
+	if nodeType == "" {
+		nodeType = types.NodeTypeUnknown
+	}
+	var newNode *types.NodeDiscoveryMessage
+	existingNode := regNodes.deviceMap[hwID]
+	if existingNode == nil {
+		if regNodes.isNodeQuotaReached("ProvisionNode", hwID) {
+			return nil
+		}
+		newNode = NewNode(regNodes.domain, regNodes.publisherID, hwID, nodeType)
+		if newNode == nil {
+			return nil
+		}
+		newNode.Status[types.NodeStatusRunState] = types.NodeRunStateProvisioned
+		regNodes.applyNodeIDMapping(newNode)
+	} else {
+		newNode = regNodes.Clone(existingNode)
+		if nodeType != types.NodeTypeUnknown {
+			newNode.Attr[types.NodeAttrType] = string(nodeType)
+		}
+	}
+	provisioned := types.NodeAttrMap{}
+	for key, value := range attr {
+		provisioned[key] = value
+		newNode.Attr[key] = value
+	}
+	regNodes.provisioned[hwID] = provisioned
+	if existingNode == nil || isNodeChanged(existingNode, newNode) {
+		regNodes.updateNode(newNode)
+		return newNode
+	}
+	return existingNode
+}
+
+// ReadNodeConfigBool reads the node configuration value as a boolean
+// This returns found false if no value is set and no default is configured. Errors wrap
+// ErrNodeNotFound, ErrConfigNotFound or ErrInvalidConfigValue.
+func (regNodes *RegisteredNodes) ReadNodeConfigBool(
+	nodeHWID string, attrName types.NodeAttr) (value bool, found bool, err error) {
+
+	valueStr, found, err := regNodes.ReadNodeConfigString(nodeHWID, attrName)
+	if err != nil || !found {
+		return false, found, err
+	}
+	value, err = parseConfigBool(valueStr)
+	if err != nil {
+		return false, true, fmt.Errorf("RegisteredNodes.ReadNodeConfigBool: Node '%s' configuration '%s' value '%s': %w",
+			nodeHWID, attrName, valueStr, err)
+	}
+	return value, true, nil
+}
+
+// ReadNodeConfigFloat reads the node configuration value as a floating point number
+// This returns found false if no value is set and no default is configured. Errors wrap
+// ErrNodeNotFound, ErrConfigNotFound or ErrInvalidConfigValue.
+func (regNodes *RegisteredNodes) ReadNodeConfigFloat(
+	nodeHWID string, attrName types.NodeAttr) (value float32, found bool, err error) {
+
+	valueStr, found, err := regNodes.ReadNodeConfigString(nodeHWID, attrName)
+	if err != nil || !found {
+		return 0, found, err
+	}
+	value, err = parseConfigFloat(valueStr)
+	if err != nil {
+		return 0, true, fmt.Errorf("RegisteredNodes.ReadNodeConfigFloat: Node '%s' configuration '%s' value '%s': %w",
+			nodeHWID, attrName, valueStr, err)
+	}
+	return value, true, nil
+}
+
+// ReadNodeConfigInt reads the node configuration value as an integer
+// This returns found false if no value is set and no default is configured. Errors wrap
+// ErrNodeNotFound, ErrConfigNotFound or ErrInvalidConfigValue.
+func (regNodes *RegisteredNodes) ReadNodeConfigInt(
+	nodeHWID string, attrName types.NodeAttr) (value int, found bool, err error) {
+
+	valueStr, found, err := regNodes.ReadNodeConfigString(nodeHWID, attrName)
+	if err != nil || !found {
+		return 0, found, err
+	}
+	value, err = parseConfigInt(valueStr)
+	if err != nil {
+		return 0, true, fmt.Errorf("RegisteredNodes.ReadNodeConfigInt: Node '%s' configuration '%s' value '%s': %w",
+			nodeHWID, attrName, valueStr, err)
+	}
+	return value, true, nil
+}
+
+// ReadNodeConfigString reads the node configuration value, or the configuration default if no value is set
+// This returns found false if no value is set and no default is configured. Errors wrap
+// ErrNodeNotFound or ErrConfigNotFound.
+func (regNodes *RegisteredNodes) ReadNodeConfigString(
+	nodeHWID string, attrName types.NodeAttr) (value string, found bool, err error) {
+
+	node := regNodes.GetNodeByHWID(nodeHWID)
+	if node == nil {
+		return "", false, fmt.Errorf("RegisteredNodes.ReadNodeConfigString: Device '%s': %w", nodeHWID, ErrNodeNotFound)
+	}
+	value, found, err = readNodeConfig(node, attrName)
+	if err != nil {
+		return "", false, fmt.Errorf("RegisteredNodes.ReadNodeConfigString: Device '%s' configuration '%s': %w",
+			nodeHWID, attrName, err)
+	}
+	return value, found, nil
+}
+
 // SaveNodes saves the current registered nodes to a JSON file
 func (regNodes *RegisteredNodes) SaveNodes(filename string) error {
 	collection := regNodes.GetAllNodes()
@@ -331,7 +593,63 @@ func (regNodes *RegisteredNodes) SaveNodes(filename string) error {
 	return nil
 }
 
-// SetNodeID changes the nodeID and address of the node
+// SetFlapDetection enables the detection of flapping nodes that repeatedly appear and disappear,
+// to avoid discovery churn and publish storms. A node flaps when it is created or changes its runState
+// maxTransitions times within the window. A flapping node gets the 'flapping' status and further
+// changes of its runState are not published until it is stable again. See UpdateFlapStatus.
+//  maxTransitions is the nr of transitions that makes a node flap. Use 0 to disable flap detection.
+//  window is the time in which transitions are counted. Use 0 for DefaultFlapWindow.
+func (regNodes *RegisteredNodes) SetFlapDetection(maxTransitions int, window time.Duration) {
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+	if maxTransitions <= 0 {
+		regNodes.flapping = nil
+		return
+	}
+	regNodes.flapping = NewFlapDetector(maxTransitions, window)
+}
+
+// SetMaxNodes sets the max nr of registered nodes, including provisioned and cached nodes. New nodes
+// are rejected with an error in the log when the max is reached. Existing nodes are not removed.
+// Intended to protect shared gateways from adapters that discover an unbounded nr of devices.
+//  maxNodes is the max nr of nodes. Use 0 for unlimited.
+func (regNodes *RegisteredNodes) SetMaxNodes(maxNodes int) {
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+	regNodes.maxNodes = maxNodes
+}
+
+// SetNodeGroup sets the group and tags of a node. The group and tags are published with the node.
+//  group is the group path, eg building1/floor2/room3. See also MakeGroupPath
+//  tags are optional free-form tags
+// returns true when node has changed, false if node doesn't exist or group and tags haven't changed
+func (regNodes *RegisteredNodes) SetNodeGroup(nodeHWID string, group string, tags ...string) (changed bool) {
+	return regNodes.UpdateNodeAttr(nodeHWID, map[types.NodeAttr]string{
+		types.NodeAttrGroup: strings.Trim(group, GroupSeparator),
+		types.NodeAttrTags:  strings.Join(tags, TagSeparator),
+	})
+}
+
+// SetPublisher changes the domain and publisherID of the registered nodes and updates the node addresses.
+// Intended for migrating nodes that were loaded from a file saved under a previous publisher identity.
+// Nodes whose address changes are marked as updated for publication.
+func (regNodes *RegisteredNodes) SetPublisher(domain string, publisherID string) {
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+	regNodes.domain = domain
+	regNodes.publisherID = publisherID
+	for _, node := range regNodes.nodeMap {
+		newAddress := MakeNodeDiscoveryAddress(domain, publisherID, node.NodeID)
+		if node.Address != newAddress {
+			newNode := regNodes.Clone(node)
+			newNode.Address = newAddress
+			newNode.PublisherID = publisherID
+			regNodes.updateNode(newNode)
+		}
+	}
+}
+
+// SetNodeID changes the nodeID and address of the node and its mapping in NodeIDMappings
 //  Use an empty ID to restore the nodeID and address to the hwAddress.
 //  This creates a new node instance and marks it as updated for publication. The existing
 // node publication remains unchanged.
@@ -346,13 +664,12 @@ func (regNodes *RegisteredNodes) SetNodeID(node *types.NodeDiscoveryMessage, new
 	if newNodeID == "" {
 		newNode.NodeID = node.HWID
 	} else {
-		// The new alias must not be an existing node unless it is the device
-		existingNode := regNodes.GetNodeByNodeID(newNodeID)
-		if existingNode != nil && newNodeID != node.HWID {
-			return false
-		}
 		newNode.NodeID = newNodeID
 	}
+	// The new alias must not be the node ID or hardware ID of another node, see isNodeIDInUse
+	if err := regNodes.nodeIDMappings.SetMapping(node.HWID, newNode.NodeID); err != nil {
+		return false
+	}
 	// Note: the old alias remains in existence on the domain with the last updated timestamp. should
 	// this be removed?
 	regNodes.updateMutex.Lock()
@@ -408,9 +725,65 @@ func (regNodes *RegisteredNodes) UpdateErrorStatus(nodeHWID string, runState str
 	return changed
 }
 
+// UpdateFlapStatus clears the flapping status of nodes that are stable again and marks them as updated
+// so their current runState is published. Intended to be invoked periodically, eg each heartbeat.
+// This returns the nodes that are stable again.
+func (regNodes *RegisteredNodes) UpdateFlapStatus() []*types.NodeDiscoveryMessage {
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+
+	stableNodes := make([]*types.NodeDiscoveryMessage, 0)
+	if regNodes.flapping == nil {
+		return stableNodes
+	}
+	for _, hwID := range regNodes.flapping.UpdateStable(time.Now()) {
+		node := regNodes.deviceMap[hwID]
+		if node == nil {
+			continue
+		}
+		newNode := regNodes.Clone(node)
+		delete(newNode.Status, types.NodeStatusFlapping)
+		regNodes.updateNode(newNode)
+		stableNodes = append(stableNodes, newNode)
+	}
+	return stableNodes
+}
+
+// UpdateAttrForAll updates the attributes of all selected nodes in a single locked operation.
+// Intended for gateways to update a shared attribute, eg the firmware version of all nodes behind a hub.
+// Only nodes whose attributes change are updated and published.
+//  selector selects the nodes to update. It must not call back into the registered nodes. Use nil for all nodes.
+//  attrParams are the attributes to set
+// This returns the nodes that have changed.
+func (regNodes *RegisteredNodes) UpdateAttrForAll(selector NodeSelector, attrParams types.NodeAttrMap) []*types.NodeDiscoveryMessage {
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+
+	changedNodes := make([]*types.NodeDiscoveryMessage, 0)
+	for _, node := range regNodes.deviceMap {
+		if selector != nil && !selector(node) {
+			continue
+		}
+		var newNode *types.NodeDiscoveryMessage
+		for key, value := range attrParams {
+			if node.Attr[key] != value {
+				if newNode == nil {
+					newNode = regNodes.Clone(node)
+				}
+				newNode.Attr[key] = value
+			}
+		}
+		if newNode != nil {
+			regNodes.updateNode(newNode)
+			changedNodes = append(changedNodes, newNode)
+		}
+	}
+	return changedNodes
+}
+
 // UpdateNodeAttr updates node's attributes and publishes the updated node.
 // Node is marked as modified for publication only if one of the attrParams has changes
-// Use when additional node attributes has been discovered.
+// Use when additional node attributes has been discovered. Provisioned attributes remain unchanged.
 // returns true when node has changed, false if node doesn't exist or attributes haven't changed
 func (regNodes *RegisteredNodes) UpdateNodeAttr(nodeHWID string, attrParams map[types.NodeAttr]string) (changed bool) {
 	node := regNodes.GetNodeByHWID(nodeHWID)
@@ -421,9 +794,13 @@ func (regNodes *RegisteredNodes) UpdateNodeAttr(nodeHWID string, attrParams map[
 	regNodes.updateMutex.Lock()
 	defer regNodes.updateMutex.Unlock()
 	newNode := regNodes.Clone(node)
+	provisioned := regNodes.provisioned[nodeHWID]
 
 	changed = false
 	for key, value := range attrParams {
+		if _, isProvisioned := provisioned[key]; isProvisioned {
+			continue
+		}
 		if newNode.Attr[key] != value {
 			newNode.Attr[key] = value
 			changed = true
@@ -474,6 +851,33 @@ func (regNodes *RegisteredNodes) UpdateNodeConfigValues(nodeHWID string, params
 	return changed
 }
 
+// ValidateNodeConfigValues determines which configuration values would change without applying them.
+// Values are validated against the datatype, min/max and enum of the node configuration.
+// Returns the configuration values that would change and the rejected values with the reason.
+func (regNodes *RegisteredNodes) ValidateNodeConfigValues(nodeHWID string, params types.NodeAttrMap) (
+	changes types.NodeAttrMap, errs map[types.NodeAttr]string) {
+
+	changes = make(types.NodeAttrMap)
+	errs = make(map[types.NodeAttr]string)
+	node := regNodes.GetNodeByHWID(nodeHWID)
+	if node == nil {
+		return changes, errs
+	}
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+	for key, newValue := range params {
+		configAttr, configExists := node.Config[key]
+		if !configExists {
+			errs[key] = "not a configuration attribute"
+		} else if err := validateConfigValue(&configAttr, newValue); err != nil {
+			errs[key] = err.Error()
+		} else if oldValue, attrExists := node.Attr[key]; !attrExists || oldValue != newValue {
+			changes[key] = newValue
+		}
+	}
+	return changes, errs
+}
+
 // // UpdateNode replaces a node or adds a new node based on node.Address.
 // //
 // // Intended to support Node immutability by making changes to a copy of a node and replacing
@@ -497,33 +901,20 @@ func (regNodes *RegisteredNodes) UpdateNodeConfig(nodeHWID string, attrName type
 	regNodes.updateMutex.Lock()
 	defer regNodes.updateMutex.Unlock()
 
+	if oldConfig, exists := node.Config[attrName]; exists && reflect.DeepEqual(oldConfig, *configAttr) {
+		return
+	}
 	newNode := regNodes.Clone(node)
 	newNode.Config[attrName] = *configAttr
 	regNodes.updateNode(newNode)
 }
 
 // UpdateNodes updates a list of nodes.
+// Nodes that are identical to the existing node, apart from their timestamp, are not marked as updated.
 //
 // Intended to update the list with nodes from persistent storage
 func (regNodes *RegisteredNodes) UpdateNodes(updates []*types.NodeDiscoveryMessage) {
-	regNodes.updateMutex.Lock()
-	defer regNodes.updateMutex.Unlock()
-
-	for _, node := range updates {
-		// fill in missing fields
-		if node != nil {
-			if node.Attr == nil {
-				node.Attr = map[types.NodeAttr]string{}
-			}
-			if node.Config == nil {
-				node.Config = map[types.NodeAttr]types.ConfigAttr{}
-			}
-			if node.Status == nil {
-				node.Status = make(map[types.NodeStatus]string)
-			}
-			regNodes.updateNode(node)
-		}
-	}
+	regNodes.updateNodes(updates, true)
 }
 
 // UpdateNodeStatus updates one or more node's status attributes.
@@ -555,12 +946,179 @@ func (regNodes *RegisteredNodes) UpdateNodeStatus(nodeHWID string, statusAttr ma
 	return changed
 }
 
+// UpdateStatusForAll updates the status of all selected nodes in a single locked operation.
+// Only nodes whose status changes are updated and published.
+//  selector selects the nodes to update. It must not call back into the registered nodes. Use nil for all nodes.
+//  statusAttr are the status attributes to set
+// This returns the nodes that have changed.
+func (regNodes *RegisteredNodes) UpdateStatusForAll(
+	selector NodeSelector, statusAttr map[types.NodeStatus]string) []*types.NodeDiscoveryMessage {
+
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+
+	changedNodes := make([]*types.NodeDiscoveryMessage, 0)
+	for _, node := range regNodes.deviceMap {
+		if selector != nil && !selector(node) {
+			continue
+		}
+		var newNode *types.NodeDiscoveryMessage
+		for key, value := range statusAttr {
+			if node.Status[key] != value {
+				if newNode == nil {
+					newNode = regNodes.Clone(node)
+				}
+				newNode.Status[key] = value
+			}
+		}
+		if newNode != nil {
+			regNodes.updateNode(newNode)
+			changedNodes = append(changedNodes, newNode)
+		}
+	}
+	return changedNodes
+}
+
+// applyNodeIDMapping sets the node ID and address of a new node to the node ID its hwID is mapped to
+// The mapping is not applied if the node ID is used by another node. Use within a locked section.
+func (regNodes *RegisteredNodes) applyNodeIDMapping(node *types.NodeDiscoveryMessage) {
+	if node == nil {
+		return
+	}
+	nodeID := regNodes.nodeIDMappings.GetNodeID(node.HWID)
+	if nodeID != node.NodeID && regNodes.nodeMap[nodeID] == nil {
+		node.NodeID = nodeID
+		node.Address = MakeNodeDiscoveryAddress(regNodes.domain, regNodes.publisherID, nodeID)
+	}
+}
+
+// restoreNodeIDMapping applies the mapped node ID to a loaded node, or adds the node ID of the loaded
+// node to the mappings if its hwID isn't mapped. Added mappings are not reported as changes.
+func (regNodes *RegisteredNodes) restoreNodeIDMapping(node *types.NodeDiscoveryMessage) {
+	mappings := regNodes.nodeIDMappings
+	nodeID := mappings.GetNodeID(node.HWID)
+	if nodeID != node.HWID && nodeID != node.NodeID {
+		node.NodeID = nodeID
+		node.Address = MakeNodeDiscoveryAddress(regNodes.domain, regNodes.publisherID, nodeID)
+	} else if nodeID == node.HWID && node.NodeID != "" && node.NodeID != node.HWID {
+		mappings.updateMutex.Lock()
+		if _, found := mappings.hwIDs[node.NodeID]; !found {
+			mappings.setMapping(node.HWID, node.NodeID)
+			mappings.updateCount++
+		}
+		mappings.updateMutex.Unlock()
+	}
+}
+
+// isNodeIDInUse returns true if a node other than the given hardware ID is published with the node ID,
+// or has the node ID as its hardware ID and would collide when its mapping is removed.
+func (regNodes *RegisteredNodes) isNodeIDInUse(nodeID string, hwID string) bool {
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+
+	if node := regNodes.nodeMap[nodeID]; node != nil && node.HWID != hwID {
+		return true
+	}
+	node := regNodes.deviceMap[nodeID]
+	return node != nil && node.HWID != hwID
+}
+
+// isNodeQuotaReached returns true and logs an error if no new node can be added because the max nr
+// of nodes is reached. Use within a locked section.
+func (regNodes *RegisteredNodes) isNodeQuotaReached(funcName string, hwID string) bool {
+	if regNodes.maxNodes <= 0 || len(regNodes.deviceMap) < regNodes.maxNodes {
+		return false
+	}
+	regNodes.rejectedNodes++
+	logrus.Errorf("RegisteredNodes.%s: Node '%s' is rejected. The max of %d nodes is reached",
+		funcName, hwID, regNodes.maxNodes)
+	return true
+}
+
+// isFlapDampened records the appearance or runState change of a node for flap detection and returns
+// true if publication of the node must be dampened because it flaps. The first transition that makes a
+// node flap sets its flapping status and is published. Later changes of only the status of a flapping node
+// are dampened. Use within a locked section with flap detection enabled.
+func (regNodes *RegisteredNodes) isFlapDampened(existing *types.NodeDiscoveryMessage, node *types.NodeDiscoveryMessage) bool {
+	if node.Status == nil {
+		node.Status = make(map[types.NodeStatus]string)
+	}
+	if existing != nil && existing.Status[types.NodeStatusRunState] == node.Status[types.NodeStatusRunState] {
+		// no transition. Retain the flapping status
+		if regNodes.flapping.IsFlapping(node.HWID) {
+			node.Status[types.NodeStatusFlapping] = "true"
+		}
+		return false
+	}
+	wasFlapping := regNodes.flapping.IsFlapping(node.HWID)
+	if !regNodes.flapping.AddTransition(node.HWID, time.Now()) {
+		return false
+	}
+	node.Status[types.NodeStatusFlapping] = "true"
+	if !wasFlapping || existing == nil {
+		return false
+	}
+	// dampen if only the status changed
+	existingCopy := *existing
+	existingCopy.Status = node.Status
+	existingCopy.Timestamp = node.Timestamp
+	return reflect.DeepEqual(&existingCopy, node)
+}
+
+// updateNodes adds or replaces a list of nodes, filling in missing fields
+// Nodes that are identical to the existing node, apart from their timestamp, are ignored.
+//  markNew marks nodes that don't exist yet as updated. Use false for nodes that were published before.
+func (regNodes *RegisteredNodes) updateNodes(updates []*types.NodeDiscoveryMessage, markNew bool) {
+	regNodes.updateMutex.Lock()
+	defer regNodes.updateMutex.Unlock()
+
+	for _, node := range updates {
+		// fill in missing fields
+		if node != nil {
+			if node.Attr == nil {
+				node.Attr = map[types.NodeAttr]string{}
+			}
+			if node.Config == nil {
+				node.Config = map[types.NodeAttr]types.ConfigAttr{}
+			}
+			if node.Status == nil {
+				node.Status = make(map[types.NodeStatus]string)
+			}
+			existingNode := regNodes.deviceMap[node.HWID]
+			if existingNode == nil && regNodes.isNodeQuotaReached("UpdateNodes", node.HWID) {
+				continue
+			} else if existingNode == nil && !markNew {
+				regNodes.nodeMap[node.NodeID] = node
+				regNodes.deviceMap[node.HWID] = node
+				regNodes.cachedHWIDs[node.HWID] = true
+			} else if existingNode == nil || isNodeChanged(existingNode, node) {
+				regNodes.updateNode(node)
+			}
+		}
+	}
+}
+
 // updateNode replaces a node and adds it to the list of updated nodes.
+// A replacement instance whose content equals the existing node, apart from its timestamp, is not
+// marked as updated. The existing instance itself is always marked as it could have been modified.
 //  Use within a locked section.
 func (regNodes *RegisteredNodes) updateNode(node *types.NodeDiscoveryMessage) {
 	if node == nil {
 		return
 	}
+	existing := regNodes.deviceMap[node.HWID]
+	if regNodes.flapping != nil && existing != node && regNodes.isFlapDampened(existing, node) {
+		node.Timestamp = existing.Timestamp
+		regNodes.nodeMap[node.NodeID] = node
+		regNodes.deviceMap[node.HWID] = node
+		return
+	}
+	if existing != nil && existing != node && !isNodeChanged(existing, node) {
+		node.Timestamp = existing.Timestamp
+		regNodes.nodeMap[node.NodeID] = node
+		regNodes.deviceMap[node.HWID] = node
+		return
+	}
 	regNodes.nodeMap[node.NodeID] = node
 	regNodes.deviceMap[node.HWID] = node
 	if regNodes.updatedNodes == nil {
@@ -568,6 +1126,25 @@ func (regNodes *RegisteredNodes) updateNode(node *types.NodeDiscoveryMessage) {
 	}
 	node.Timestamp = time.Now().Format(types.TimeFormat)
 	regNodes.updatedNodes[node.Address] = node
+	regNodes.updateNotifier.Notify(node.HWID)
+	regNodes.updateTracker.Update(node.HWID)
+}
+
+// markRediscovered sets the runState of a missing or provisioned node to ready and clears the time
+// it was last seen while missing
+func markRediscovered(node *types.NodeDiscoveryMessage) {
+	runState := node.Status[types.NodeStatusRunState]
+	if runState == types.NodeRunStateMissing || runState == types.NodeRunStateProvisioned {
+		node.Status[types.NodeStatusRunState] = types.NodeRunStateReady
+	}
+	delete(node.Status, types.NodeStatusLastSeen)
+}
+
+// isNodeChanged returns true if the nodes differ in more than their timestamp
+func isNodeChanged(oldNode *types.NodeDiscoveryMessage, newNode *types.NodeDiscoveryMessage) bool {
+	oldCopy := *oldNode
+	oldCopy.Timestamp = newNode.Timestamp
+	return !reflect.DeepEqual(&oldCopy, newNode)
 }
 
 // MakeNodeAddress generates the publication address of a node: domain/publisherID/nodeID[/messageType].
@@ -583,11 +1160,53 @@ func MakeNodeAddress(domain string, publisherID string, nodeID string, messageTy
 	return address
 }
 
+// validateConfigValue checks if a value is valid for the configuration datatype
+func validateConfigValue(configAttr *types.ConfigAttr, value string) error {
+	switch configAttr.DataType {
+	case types.DataTypeBool:
+		lowerValue := strings.ToLower(value)
+		if lowerValue != "on" && lowerValue != "off" {
+			if _, err := strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("value '%s' is not a boolean", value)
+			}
+		}
+	case types.DataTypeInt, types.DataTypeNumber:
+		var number float64
+		var err error
+		if configAttr.DataType == types.DataTypeInt {
+			var intValue int
+			intValue, err = strconv.Atoi(value)
+			number = float64(intValue)
+		} else {
+			number, err = strconv.ParseFloat(value, 64)
+		}
+		if err != nil {
+			return fmt.Errorf("value '%s' is not a %s", value, configAttr.DataType)
+		}
+		if (configAttr.Min != 0 || configAttr.Max != 0) && (number < configAttr.Min || number > configAttr.Max) {
+			return fmt.Errorf("value %s is outside the range %v - %v", value, configAttr.Min, configAttr.Max)
+		}
+	case types.DataTypeEnum:
+		for _, enumValue := range configAttr.Enum {
+			if enumValue == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("value '%s' is not one of %v", value, configAttr.Enum)
+	}
+	return nil
+}
+
 // MakeNodeConfigureAddress generates the address to configure a node
 func MakeNodeConfigureAddress(domain string, publisherID string, nodeID string) string {
 	return MakeNodeAddress(domain, publisherID, nodeID, types.MessageTypeConfigure)
 }
 
+// MakeNodeConfigureResultAddress generates the address of the result of a validate-only configure command
+func MakeNodeConfigureResultAddress(domain string, publisherID string, nodeID string) string {
+	return MakeNodeAddress(domain, publisherID, nodeID, types.MessageTypeConfigureResult)
+}
+
 // MakeNodeDiscoveryAddress generates the address of a node: domain/publisherID/nodeID/$node.
 func MakeNodeDiscoveryAddress(domain string, publisherID string, nodeID string) string {
 	return MakeNodeAddress(domain, publisherID, nodeID, types.MessageTypeNodeDiscovery)
@@ -642,13 +1261,19 @@ func NewNode(domain string, publisherID string, nodeHWID string, nodeType types.
 // onSetNodeID is the handler for changes in nodeID configuration. Use this to update input and output addresses
 func NewRegisteredNodes(domain string, publisherID string) *RegisteredNodes {
 	nodes := RegisteredNodes{
-		domain:       domain,
-		publisherID:  publisherID,
-		deviceMap:    make(map[string]*types.NodeDiscoveryMessage),
-		nodeMap:      make(map[string]*types.NodeDiscoveryMessage),
-		updatedNodes: make(map[string]*types.NodeDiscoveryMessage),
-		updateMutex:  &sync.Mutex{},
+		cachedHWIDs:    make(map[string]bool),
+		domain:         domain,
+		publisherID:    publisherID,
+		deviceMap:      make(map[string]*types.NodeDiscoveryMessage),
+		nodeMap:        make(map[string]*types.NodeDiscoveryMessage),
+		nodeIDMappings: NewNodeIDMappings(),
+		provisioned:    make(map[string]types.NodeAttrMap),
+		updatedNodes:   make(map[string]*types.NodeDiscoveryMessage),
+		updateMutex:    &sync.Mutex{},
+		updateNotifier: lib.NewUpdateNotifier(),
+		updateTracker:  lib.NewUpdateTracker(),
 	}
+	nodes.nodeIDMappings.isInUse = nodes.isNodeIDInUse
 	return &nodes
 }
 
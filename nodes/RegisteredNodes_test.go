@@ -2,8 +2,10 @@ package nodes_test
 
 import (
 	"crypto/ecdsa"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/nodes"
@@ -109,6 +111,34 @@ func TestAttrStatus(t *testing.T) {
 
 }
 
+func TestBulkUpdate(t *testing.T) {
+	const hubGroup = "hub1"
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	collection.CreateNode("sensor1", types.NodeTypeSensor)
+	collection.CreateNode("sensor2", types.NodeTypeSensor)
+	collection.CreateNode("hub2sensor", types.NodeTypeSensor)
+	collection.SetNodeGroup("sensor1", hubGroup)
+	collection.SetNodeGroup("sensor2", hubGroup)
+	collection.UpdateNodeAttr("sensor2", types.NodeAttrMap{types.NodeAttrSoftwareVersion: "1.1"})
+	collection.GetUpdatedNodes(true)
+
+	// only nodes that change are updated
+	firmware := types.NodeAttrMap{types.NodeAttrSoftwareVersion: "1.1"}
+	changedNodes := collection.UpdateAttrForAll(nodes.SelectNodesInGroup(hubGroup), firmware)
+	require.Len(t, changedNodes, 1)
+	assert.Equal(t, "sensor1", changedNodes[0].HWID)
+	assert.Len(t, collection.GetUpdatedNodes(true), 1)
+	assert.Equal(t, "", collection.GetNodeAttr("hub2sensor", types.NodeAttrSoftwareVersion))
+
+	// a nil selector updates all nodes
+	lastSeen := map[types.NodeStatus]string{types.NodeStatusLastSeen: "now"}
+	changedNodes = collection.UpdateStatusForAll(nil, lastSeen)
+	assert.Len(t, changedNodes, 3)
+	changedNodes = collection.UpdateStatusForAll(nil, lastSeen)
+	assert.Len(t, changedNodes, 0)
+	assert.Len(t, collection.GetUpdatedNodes(true), 3)
+}
+
 // TestConfigure tests if the node configuration is handled
 func TestConfigure(t *testing.T) {
 	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
@@ -200,6 +230,46 @@ func TestConfigure(t *testing.T) {
 	assert.Equal(t, "NewName", value2, "Configuration wasn't applied")
 }
 
+// TestReadNodeConfig tests that reading configuration distinguishes missing nodes, missing values and wrong types
+func TestReadNodeConfig(t *testing.T) {
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	collection.CreateNode(node1ID, types.NodeTypeUnknown)
+	collection.CreateNodeConfig(node1ID, types.NodeAttrMin, types.DataTypeInt, "min", "")
+
+	_, found, err := collection.ReadNodeConfigInt("notanode", types.NodeAttrMin)
+	assert.True(t, errors.Is(err, nodes.ErrNodeNotFound))
+	assert.False(t, found)
+	_, found, err = collection.ReadNodeConfigInt(node1ID, types.NodeAttrMax)
+	assert.True(t, errors.Is(err, nodes.ErrConfigNotFound))
+	assert.False(t, found)
+	// not set is not an error
+	_, found, err = collection.ReadNodeConfigInt(node1ID, types.NodeAttrMin)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	collection.UpdateNodeConfigValues(node1ID, types.NodeAttrMap{types.NodeAttrMin: "abc"})
+	_, found, err = collection.ReadNodeConfigInt(node1ID, types.NodeAttrMin)
+	assert.True(t, errors.Is(err, nodes.ErrInvalidConfigValue))
+	assert.True(t, found)
+	_, _, err = collection.ReadNodeConfigFloat(node1ID, types.NodeAttrMin)
+	assert.True(t, errors.Is(err, nodes.ErrInvalidConfigValue))
+	_, _, err = collection.ReadNodeConfigBool(node1ID, types.NodeAttrMin)
+	assert.True(t, errors.Is(err, nodes.ErrInvalidConfigValue))
+
+	collection.UpdateNodeConfigValues(node1ID, types.NodeAttrMap{types.NodeAttrMin: "3"})
+	intValue, found, err := collection.ReadNodeConfigInt(node1ID, types.NodeAttrMin)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 3, intValue)
+
+	// the configuration default is used when no value is set
+	collection.CreateNodeConfig(node1ID, types.NodeAttrDisabled, types.DataTypeBool, "disabled", "true")
+	boolValue, found, err := collection.ReadNodeConfigBool(node1ID, types.NodeAttrDisabled)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, boolValue)
+}
+
 func TestReceiveConfig(t *testing.T) {
 	const node1ID = "node1"
 	const publisher1ID = "publisher1"
@@ -245,6 +315,59 @@ func TestReceiveConfig(t *testing.T) {
 	assert.Equal(t, "bob", name)
 }
 
+func TestValidateConfig(t *testing.T) {
+	const node1ID = "node1"
+	const publisher1ID = "publisher1"
+	var privKey = messaging.CreateAsymKeys()
+	var result types.NodeConfigureResultMessage
+	var rxCount = 0
+
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	node1 := collection.CreateNode(node1ID, types.NodeTypeUnknown)
+	collection.UpdateNodeConfig(node1ID, "level", &types.ConfigAttr{DataType: types.DataTypeInt, Min: 1, Max: 10})
+	collection.UpdateNodeConfig(node1ID, "mode", &types.ConfigAttr{DataType: types.DataTypeEnum, Enum: []string{"auto", "manual"}})
+
+	getPublisherKey := func(addr string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	msgr := messaging.NewDummyMessenger(nil)
+	signer := messaging.NewMessageSigner(msgr, privKey, getPublisherKey)
+	receiver := nodes.NewReceiveNodeConfigure(domain, publisher1ID, nil, signer, collection, privKey)
+	receiver.SetConfigureNodeHandler(func(hwID string, params types.NodeAttrMap) {
+		rxCount++
+	})
+	receiver.SetValidateNodeHandler(func(hwID string, params types.NodeAttrMap) map[types.NodeAttr]string {
+		if params["mode"] == "manual" {
+			return map[types.NodeAttr]string{"mode": "manual mode not supported"}
+		}
+		return nil
+	})
+	receiver.Start()
+	resultAddr := nodes.MakeNodeConfigureResultAddress(domain, publisher1ID, node1ID)
+	signer.Subscribe(resultAddr, func(address string, message string) error {
+		_, _, err := signer.DecodeMessage(message, &result)
+		return err
+	})
+
+	nodes.PublishNodeConfigureValidate(node1.Address, types.NodeAttrMap{
+		types.NodeAttrName: "bob",
+		"level":            "20",
+		"mode":             "manual",
+		"notaconfig":       "1",
+	}, "senderaddress", signer, &privKey.PublicKey)
+	receiver.Stop()
+
+	assert.Equal(t, 0, rxCount, "Configure handler should not be invoked in validate-only mode")
+	assert.Equal(t, "bob", result.Changes[types.NodeAttrName])
+	assert.Len(t, result.Changes, 1)
+	assert.Contains(t, result.Errors, types.NodeAttr("level"))
+	assert.Contains(t, result.Errors, types.NodeAttr("mode"))
+	assert.Contains(t, result.Errors, types.NodeAttr("notaconfig"))
+	assert.Equal(t, "senderaddress", result.Sender)
+	name := collection.GetNodeAttr(node1ID, types.NodeAttrName)
+	assert.Empty(t, name, "Validate-only mode should not apply the configuration")
+}
+
 func TestLoadSave(t *testing.T) {
 	const domain = "test"
 	const publisher1ID = "publisher1"
@@ -259,6 +382,24 @@ func TestLoadSave(t *testing.T) {
 	collection2 := nodes.NewRegisteredNodes(domain, publisher1ID)
 	err = collection2.LoadNodes(filename)
 	assert.NoError(t, err)
+	assert.NotNil(t, collection2.GetNodeByHWID(device1ID))
+
+	// loaded nodes are clean until they change
+	assert.Empty(t, collection2.GetUpdatedNodes(true), "loaded nodes should not be republished")
+	collection2.CreateNode(device1ID, types.NodeTypeUnknown)
+	collection2.UpdateNodeAttr(device1ID, types.NodeAttrMap{})
+	assert.Empty(t, collection2.GetUpdatedNodes(true))
+	collection2.CreateNodeConfig(device1ID, types.NodeAttrName, types.DataTypeString, "name", "")
+	assert.Len(t, collection2.GetUpdatedNodes(true), 1)
+	collection2.CreateNodeConfig(device1ID, types.NodeAttrName, types.DataTypeString, "name", "")
+	assert.Empty(t, collection2.GetUpdatedNodes(true), "unchanged config")
+
+	// updating with identical nodes doesn't mark them as updated
+	node := collection2.GetNodeByHWID(device1ID)
+	identical := collection2.Clone(node)
+	identical.Timestamp = "later"
+	collection2.UpdateNodes([]*types.NodeDiscoveryMessage{identical})
+	assert.Empty(t, collection2.GetUpdatedNodes(true))
 }
 
 func TestChangeNodeID(t *testing.T) {
@@ -335,3 +476,163 @@ func TestPublishReceive(t *testing.T) {
 	nodes.PublishRegisteredNodes(allNodes, signer)
 
 }
+
+func TestOnUpdated(t *testing.T) {
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	updated := make(chan string, 10)
+	collection.OnUpdated(func(nodeHWID string) {
+		// the handler is invoked outside the lock so the collection can be used
+		node := collection.GetNodeByHWID(nodeHWID)
+		if node != nil {
+			updated <- node.HWID
+		} else {
+			updated <- "deleted:" + nodeHWID
+		}
+	})
+	collection.CreateNode(node1ID, types.NodeTypeUnknown)
+	assert.Equal(t, node1ID, <-updated)
+
+	collection.DeleteNode(node1ID)
+	select {
+	case hwid := <-updated:
+		assert.Equal(t, "deleted:"+node1ID, hwid)
+	case <-time.After(time.Second):
+		t.Fatal("Delete notification not received")
+	}
+}
+
+func TestGetUpdatedNodesSince(t *testing.T) {
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	collection.CreateNode(node1ID, types.NodeTypeUnknown)
+
+	// clearing the updates for the heartbeat doesn't affect cursor based observers
+	updated := collection.GetUpdatedNodes(true)
+	assert.Len(t, updated, 1)
+	updated, cursor := collection.GetUpdatedNodesSince(0)
+	require.Len(t, updated, 1)
+	assert.Equal(t, node1ID, updated[0].HWID)
+
+	updated, cursor = collection.GetUpdatedNodesSince(cursor)
+	assert.Empty(t, updated)
+	collection.UpdateNodeAttr(node1ID, types.NodeAttrMap{types.NodeAttrName: "name1"})
+	updated, _ = collection.GetUpdatedNodesSince(cursor)
+	assert.Len(t, updated, 1)
+	assert.Len(t, collection.GetUpdatedNodes(false), 1)
+}
+
+func TestProvisionNode(t *testing.T) {
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	node := collection.ProvisionNode(node1ID, types.NodeTypeUnknown, types.NodeAttrMap{types.NodeAttrName: "Kitchen"})
+	require.NotNil(t, node)
+	assert.Equal(t, types.NodeRunStateProvisioned, node.Status[types.NodeStatusRunState])
+	assert.Empty(t, collection.GetMissingNodes())
+
+	// discovered hardware keeps the provisioned attributes and sets its type
+	node = collection.CreateNode(node1ID, types.NodeTypeMultisensor)
+	assert.Equal(t, types.NodeRunStateReady, node.Status[types.NodeStatusRunState])
+	assert.Equal(t, string(types.NodeTypeMultisensor), node.Attr[types.NodeAttrType])
+	collection.UpdateNodeAttr(node1ID, types.NodeAttrMap{types.NodeAttrName: "Sensor", types.NodeAttrManufacturer: "Bob"})
+	node = collection.GetNodeByHWID(node1ID)
+	assert.Equal(t, "Kitchen", node.Attr[types.NodeAttrName])
+	assert.Equal(t, "Bob", node.Attr[types.NodeAttrManufacturer])
+
+	// provisioning a discovered node merges its attributes
+	node = collection.ProvisionNode(node1ID, "", types.NodeAttrMap{types.NodeAttrLocationName: "Upstairs"})
+	assert.Equal(t, types.NodeRunStateReady, node.Status[types.NodeStatusRunState])
+	assert.Equal(t, string(types.NodeTypeMultisensor), node.Attr[types.NodeAttrType])
+	assert.Equal(t, "Upstairs", node.Attr[types.NodeAttrLocationName])
+	assert.Nil(t, collection.ProvisionNode("", types.NodeTypeUnknown, nil))
+}
+
+func TestMaxNodes(t *testing.T) {
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	collection.CreateNode(node1ID, types.NodeTypeMultisensor)
+	collection.SetMaxNodes(2)
+	assert.NotNil(t, collection.ProvisionNode("node2", types.NodeTypeUnknown, nil))
+	assert.Nil(t, collection.CreateNode("node3", types.NodeTypeMultisensor))
+	assert.Nil(t, collection.ProvisionNode("node3", types.NodeTypeUnknown, nil))
+	collection.UpdateNodes([]*types.NodeDiscoveryMessage{nodes.NewNode(domain, publisher1ID, "node3", types.NodeTypeUnknown)})
+	assert.Nil(t, collection.GetNodeByHWID("node3"))
+
+	// existing nodes can still be updated
+	node := collection.CreateNode("node2", types.NodeTypeMultisensor)
+	require.NotNil(t, node)
+	assert.Equal(t, types.NodeRunStateReady, node.Status[types.NodeStatusRunState])
+	nodeCount, maxNodes, rejected := collection.GetNodeQuota()
+	assert.Equal(t, 2, nodeCount)
+	assert.Equal(t, 2, maxNodes)
+	assert.Equal(t, 3, rejected)
+
+	collection.SetMaxNodes(0)
+	assert.NotNil(t, collection.CreateNode("node3", types.NodeTypeMultisensor))
+}
+
+func TestCreateOrUpdateNode(t *testing.T) {
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	node := collection.CreateOrUpdateNode(node1ID, types.NodeTypeMultisensor,
+		types.NodeAttrMap{types.NodeAttrManufacturer: "Bob", types.NodeAttrModel: "m1"})
+	require.NotNil(t, node)
+	assert.Equal(t, "Bob", node.Attr[types.NodeAttrManufacturer])
+	assert.Len(t, collection.GetUpdatedNodes(true), 1)
+
+	// repeated discovery without changes doesn't update the node
+	node2 := collection.CreateOrUpdateNode(node1ID, types.NodeTypeMultisensor, types.NodeAttrMap{types.NodeAttrModel: "m1"})
+	assert.Equal(t, node, node2)
+	assert.Empty(t, collection.GetUpdatedNodes(true))
+
+	// changed attributes and type are merged
+	node = collection.CreateOrUpdateNode(node1ID, types.NodeTypeSensor, types.NodeAttrMap{types.NodeAttrModel: "m2"})
+	assert.Equal(t, "Bob", node.Attr[types.NodeAttrManufacturer])
+	assert.Equal(t, "m2", node.Attr[types.NodeAttrModel])
+	assert.Equal(t, string(types.NodeTypeSensor), node.Attr[types.NodeAttrType])
+	assert.Len(t, collection.GetUpdatedNodes(true), 1)
+	node = collection.CreateOrUpdateNode(node1ID, "", nil)
+	assert.Equal(t, string(types.NodeTypeSensor), node.Attr[types.NodeAttrType])
+	assert.Empty(t, collection.GetUpdatedNodes(true))
+
+	// provisioned attributes take precedence
+	collection.ProvisionNode("node2", types.NodeTypeAlarm, types.NodeAttrMap{types.NodeAttrName: "Porch"})
+	node = collection.CreateOrUpdateNode("node2", types.NodeTypeMultisensor,
+		types.NodeAttrMap{types.NodeAttrName: "Sensor", types.NodeAttrModel: "m3"})
+	assert.Equal(t, types.NodeRunStateReady, node.Status[types.NodeStatusRunState])
+	assert.Equal(t, string(types.NodeTypeAlarm), node.Attr[types.NodeAttrType])
+	assert.Equal(t, "Porch", node.Attr[types.NodeAttrName])
+	assert.Equal(t, "m3", node.Attr[types.NodeAttrModel])
+}
+
+func TestFlapDetection(t *testing.T) {
+	collection := nodes.NewRegisteredNodes(domain, publisher1ID)
+	collection.SetFlapDetection(3, 100*time.Millisecond)
+	collection.CreateNode(node1ID, types.NodeTypeMultisensor)
+	collection.UpdateErrorStatus(node1ID, types.NodeRunStateError, "lost connection")
+	assert.Len(t, collection.GetUpdatedNodes(true), 1)
+
+	// the third transition makes the node flap and is published with the flapping status
+	collection.UpdateErrorStatus(node1ID, types.NodeRunStateReady, "")
+	updated := collection.GetUpdatedNodes(true)
+	require.Len(t, updated, 1)
+	assert.Equal(t, "true", updated[0].Status[types.NodeStatusFlapping])
+
+	// further runState changes are dampened
+	collection.UpdateErrorStatus(node1ID, types.NodeRunStateError, "lost connection")
+	assert.Empty(t, collection.GetUpdatedNodes(true))
+	node := collection.GetNodeByHWID(node1ID)
+	assert.Equal(t, types.NodeRunStateError, node.Status[types.NodeStatusRunState])
+	assert.Equal(t, "true", node.Status[types.NodeStatusFlapping])
+	assert.Empty(t, collection.UpdateFlapStatus())
+
+	// a stable node is published with its current runState
+	time.Sleep(110 * time.Millisecond)
+	assert.Len(t, collection.UpdateFlapStatus(), 1)
+	updated = collection.GetUpdatedNodes(true)
+	require.Len(t, updated, 1)
+	assert.Equal(t, types.NodeRunStateError, updated[0].Status[types.NodeStatusRunState])
+	assert.Empty(t, updated[0].Status[types.NodeStatusFlapping])
+
+	// disabled flap detection publishes all changes
+	collection.SetFlapDetection(0, 0)
+	collection.UpdateErrorStatus(node1ID, types.NodeRunStateReady, "")
+	collection.UpdateErrorStatus(node1ID, types.NodeRunStateError, "lost connection")
+	assert.Len(t, collection.GetUpdatedNodes(true), 1)
+	assert.Empty(t, collection.UpdateFlapStatus())
+}
@@ -0,0 +1,116 @@
+// Package outputs with publication of output values on templated addresses for legacy consumers
+package outputs
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Placeholders that can be used in an address template
+const (
+	TemplateDomain      = "{domain}"    // domain of the publisher
+	TemplatePublisherID = "{publisher}" // ID of the publisher
+	TemplateNodeID      = "{nodeId}"    // node ID or alias as used in the output address
+	TemplateNodeHWID    = "{hwId}"      // hardware ID of the node
+	TemplateOutputType  = "{type}"      // output type
+	TemplateInstance    = "{instance}"  // output instance
+	TemplateMessageType = "{msgtype}"   // message type, eg $raw or $latest
+)
+
+// templatePlaceholders are the valid placeholders of an address template
+var templatePlaceholders = []string{TemplateDomain, TemplatePublisherID, TemplateNodeID, TemplateNodeHWID,
+	TemplateOutputType, TemplateInstance, TemplateMessageType}
+
+// templatePlaceholderExpr matches a placeholder in an address template
+var templatePlaceholderExpr = regexp.MustCompile(`{[^{}]*}`)
+
+// MakeTemplateAddress creates the address of an output publication from an address template
+//  template contains the placeholders to substitute, eg "{domain}/{publisher}/{nodeId}/{type}/{instance}/{msgtype}"
+//  output is the output whose address fields are substituted
+//  messageType is the message type to substitute
+func MakeTemplateAddress(template string, output *types.OutputDiscoveryMessage, messageType types.MessageType) string {
+	// domain/publisher/nodeId/type/instance/$output
+	segments := strings.Split(output.Address, "/")
+	if len(segments) < 6 {
+		segments = append(segments, make([]string, 6-len(segments))...)
+	}
+	replacer := strings.NewReplacer(
+		TemplateDomain, segments[0],
+		TemplatePublisherID, segments[1],
+		TemplateNodeID, segments[2],
+		TemplateNodeHWID, output.NodeHWID,
+		TemplateOutputType, string(output.OutputType),
+		TemplateInstance, output.Instance,
+		TemplateMessageType, string(messageType),
+	)
+	return replacer.Replace(template)
+}
+
+// PublishCompatOutputValue publishes the raw value of an output on the address from the template.
+// If the template contains the message type then the $latest message is published as well.
+// Compat publications are intended for legacy consumers and are published unsigned and retained.
+// The canonical publications to domain peers are not affected.
+//  template is the address template. See MakeTemplateAddress
+//  messenger is the messenger used to publish the compat publications
+func PublishCompatOutputValue(template string, output *types.OutputDiscoveryMessage,
+	latest *types.OutputValue, messenger messaging.IMessenger) error {
+
+	rawAddr := MakeTemplateAddress(template, output, types.MessageTypeRaw)
+	logrus.Debugf("PublishCompatOutputValue: output value to: %s", rawAddr)
+	err := messenger.Publish(rawAddr, true, latest.Value)
+	if err != nil || !strings.Contains(template, TemplateMessageType) {
+		return err
+	}
+	latestAddr := MakeTemplateAddress(template, output, types.MessageTypeLatest)
+	latestMessage := &types.OutputLatestMessage{
+		Address:   latestAddr,
+		Quality:   latest.Quality,
+		Timestamp: latest.Timestamp,
+		Unit:      output.Unit,
+		Value:     latest.Value,
+	}
+	payload, err := json.Marshal(latestMessage)
+	if err != nil {
+		return lib.MakeErrorf("PublishCompatOutputValue: Unable to marshal latest message for %s: %s", latestAddr, err)
+	}
+	return messenger.Publish(latestAddr, true, string(payload))
+}
+
+// ValidateAddressTemplate checks that an address template only contains known placeholders and
+// no subscription wildcards. Templates that resolve to the address of a canonical publication of the
+// publisher, eg {domain}/{publisher}/{nodeId}/{type}/{instance}/{msgtype}, are rejected as the unsigned
+// compat publications would replace the signed publications to domain peers.
+//  domain and publisherID are those of the publisher that uses the template
+func ValidateAddressTemplate(template string, domain string, publisherID string) error {
+	if template == "" || strings.ContainsAny(template, "+#") {
+		return lib.MakeErrorf("ValidateAddressTemplate: Template '%s' is empty or contains a wildcard", template)
+	}
+	for _, placeholder := range templatePlaceholderExpr.FindAllString(template, -1) {
+		isKnown := false
+		for _, known := range templatePlaceholders {
+			if placeholder == known {
+				isKnown = true
+			}
+		}
+		if !isKnown {
+			return lib.MakeErrorf("ValidateAddressTemplate: Unknown placeholder %s in template '%s'", placeholder, template)
+		}
+	}
+	// canonical addresses are {domain}/{publisher}/{nodeId}/{type}/{instance}/{msgtype}
+	output := NewOutput(domain, publisherID, "node", "type", "instance")
+	canonicalPrefix := domain + "/" + publisherID + "/"
+	for _, messageType := range []types.MessageType{types.MessageTypeRaw, types.MessageTypeLatest} {
+		addr := MakeTemplateAddress(template, output, messageType)
+		segments := strings.Split(addr, "/")
+		if strings.HasPrefix(addr, canonicalPrefix) && strings.HasPrefix(segments[len(segments)-1], "$") {
+			return lib.MakeErrorf("ValidateAddressTemplate: Template '%s' resolves to the canonical address %s", template, addr)
+		}
+	}
+	return nil
+}
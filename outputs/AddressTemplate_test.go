@@ -0,0 +1,46 @@
+package outputs_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressTemplate(t *testing.T) {
+	const template1 = "legacy/{publisher}/{nodeId}/{type}/{instance}/{msgtype}"
+	output := outputs.NewOutput("test", "pub1", "node1", types.OutputTypeTemperature, "0")
+	addr := outputs.MakeTemplateAddress(template1, output, types.MessageTypeRaw)
+	assert.Equal(t, "legacy/pub1/node1/temperature/0/$raw", addr)
+	addr = outputs.MakeTemplateAddress("{domain}/{hwId}-{type}", output, types.MessageTypeRaw)
+	assert.Equal(t, "test/node1-temperature", addr)
+
+	assert.NoError(t, outputs.ValidateAddressTemplate(template1, "test", "pub1"))
+	assert.Error(t, outputs.ValidateAddressTemplate("", "test", "pub1"))
+	assert.Error(t, outputs.ValidateAddressTemplate("legacy/#", "test", "pub1"))
+	assert.Error(t, outputs.ValidateAddressTemplate("legacy/{node}/{type}", "test", "pub1"))
+	// templates of canonical addresses are refused
+	assert.Error(t, outputs.ValidateAddressTemplate("{domain}/{publisher}/{nodeId}/{type}/{instance}/{msgtype}", "test", "pub1"))
+	assert.Error(t, outputs.ValidateAddressTemplate("{domain}/{publisher}/{hwId}/{type}/{instance}/$raw", "test", "pub1"))
+	assert.Error(t, outputs.ValidateAddressTemplate("test/pub1/{nodeId}/{type}/{instance}/{msgtype}", "test", "pub1"))
+	assert.NoError(t, outputs.ValidateAddressTemplate("{domain}/{publisher}/{nodeId}/{type}/{instance}", "test", "pub1"))
+}
+
+func TestPublishCompatOutputValue(t *testing.T) {
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	output := outputs.NewOutput("test", "pub1", "node1", types.OutputTypeTemperature, "0")
+	latest := &types.OutputValue{Value: "20"}
+
+	err := outputs.PublishCompatOutputValue("legacy/{nodeId}/{type}", output, latest, messenger)
+	require.NoError(t, err)
+	assert.Equal(t, "20", messenger.FindLastPublication("legacy/node1/temperature"))
+	assert.Equal(t, 1, messenger.NrPublications())
+
+	err = outputs.PublishCompatOutputValue("legacy/{nodeId}/{type}/{msgtype}", output, latest, messenger)
+	require.NoError(t, err)
+	assert.Equal(t, "20", messenger.FindLastPublication("legacy/node1/temperature/$raw"))
+	assert.Contains(t, messenger.FindLastPublication("legacy/node1/temperature/$latest"), `"value":"20"`)
+}
@@ -0,0 +1,112 @@
+// Package outputs with encoding and validation of composite output values such as colors and locations
+package outputs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// MakeColorHSVValue returns the canonical json representation of an HSV color value
+func MakeColorHSVValue(hue float64, saturation float64, value float64) string {
+	return encodeCompositeValue(types.ColorHSV{H: hue, S: saturation, V: value})
+}
+
+// MakeColorRGBValue returns the canonical json representation of an RGB color value
+func MakeColorRGBValue(red uint8, green uint8, blue uint8) string {
+	return encodeCompositeValue(types.ColorRGB{R: red, G: green, B: blue})
+}
+
+// MakeLocationValue returns the canonical json representation of a GPS location value
+//  altitude is optional. Use 0 if unknown.
+func MakeLocationValue(latitude float64, longitude float64, altitude float64) string {
+	return encodeCompositeValue(types.Location{Latitude: latitude, Longitude: longitude, Altitude: altitude})
+}
+
+// MakeVectorValue returns the canonical json representation of a 3D vector value
+func MakeVectorValue(x float64, y float64, z float64) string {
+	return encodeCompositeValue(types.Vector{X: x, Y: y, Z: z})
+}
+
+// ParseColorHSVValue parses and validates an HSV color value
+// Returns an error if a component is missing or out of range
+func ParseColorHSVValue(value string) (color types.ColorHSV, err error) {
+	err = decodeCompositeValue(value, &color, "h", "s", "v")
+	if err == nil && (color.H < 0 || color.H > 360 || color.S < 0 || color.S > 1 || color.V < 0 || color.V > 1) {
+		err = fmt.Errorf("ParseColorHSVValue: Value '%s' is out of range", value)
+	}
+	return color, err
+}
+
+// ParseColorRGBValue parses and validates an RGB color value
+// Returns an error if a component is missing or out of range
+func ParseColorRGBValue(value string) (color types.ColorRGB, err error) {
+	err = decodeCompositeValue(value, &color, "r", "g", "b")
+	return color, err
+}
+
+// ParseLocationValue parses and validates a GPS location value
+// Returns an error if the latitude or longitude is missing or out of range
+func ParseLocationValue(value string) (location types.Location, err error) {
+	err = decodeCompositeValue(value, &location, "lat", "lon")
+	if err == nil && (location.Latitude < -90 || location.Latitude > 90 ||
+		location.Longitude < -180 || location.Longitude > 180) {
+		err = fmt.Errorf("ParseLocationValue: Value '%s' is out of range", value)
+	}
+	return location, err
+}
+
+// ParseVectorValue parses and validates a 3D vector value
+// Returns an error if a coordinate is missing
+func ParseVectorValue(value string) (vector types.Vector, err error) {
+	err = decodeCompositeValue(value, &vector, "x", "y", "z")
+	return vector, err
+}
+
+// validateCompositeValue validates a value of a composite data type
+// Returns nil if the data type isn't a composite type
+func validateCompositeValue(dataType types.DataType, value string) (err error) {
+	switch dataType {
+	case types.DataTypeColorHSV:
+		_, err = ParseColorHSVValue(value)
+	case types.DataTypeColorRGB:
+		_, err = ParseColorRGBValue(value)
+	case types.DataTypeLocation:
+		_, err = ParseLocationValue(value)
+	case types.DataTypeVector:
+		_, err = ParseVectorValue(value)
+	}
+	return err
+}
+
+// decodeCompositeValue decodes the json representation of a composite value
+// Unknown fields are not allowed.
+//  required are the json names of the fields that must be present
+func decodeCompositeValue(value string, target interface{}, required ...string) error {
+	fields := make(map[string]json.RawMessage)
+	err := json.Unmarshal([]byte(value), &fields)
+	if err != nil {
+		return fmt.Errorf("decodeCompositeValue: Value '%s' is not a json object", value)
+	}
+	for _, name := range required {
+		if _, found := fields[name]; !found {
+			return fmt.Errorf("decodeCompositeValue: Value '%s' is missing field '%s'", value, name)
+		}
+	}
+	// decode again to reject unknown fields and values of the wrong type
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(target)
+	if err != nil {
+		return fmt.Errorf("decodeCompositeValue: Invalid value '%s': %s", value, err)
+	}
+	return nil
+}
+
+// encodeCompositeValue returns the json representation of a composite value
+func encodeCompositeValue(value interface{}) string {
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
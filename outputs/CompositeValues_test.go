@@ -0,0 +1,49 @@
+package outputs_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompositeValues(t *testing.T) {
+	rgb := outputs.MakeColorRGBValue(255, 128, 0)
+	assert.Equal(t, `{"r":255,"g":128,"b":0}`, rgb)
+	color, err := outputs.ParseColorRGBValue(rgb)
+	require.NoError(t, err)
+	assert.Equal(t, types.ColorRGB{R: 255, G: 128, B: 0}, color)
+	_, err = outputs.ParseColorRGBValue(`{"r":256,"g":0,"b":0}`)
+	assert.Error(t, err)
+	_, err = outputs.ParseColorRGBValue(`{"r":1,"g":0}`)
+	assert.Error(t, err)
+
+	hsv := outputs.MakeColorHSVValue(120, 0.5, 1)
+	_, err = outputs.ParseColorHSVValue(hsv)
+	assert.NoError(t, err)
+	_, err = outputs.ParseColorHSVValue(`{"h":120,"s":1.5,"v":1}`)
+	assert.Error(t, err)
+
+	location := outputs.MakeLocationValue(49.28, -123.12, 0)
+	assert.Equal(t, `{"lat":49.28,"lon":-123.12}`, location)
+	_, err = outputs.ParseLocationValue(location)
+	assert.NoError(t, err)
+	_, err = outputs.ParseLocationValue(`{"lat":91,"lon":0}`)
+	assert.Error(t, err)
+
+	vector := outputs.MakeVectorValue(1, 2, 3)
+	_, err = outputs.ParseVectorValue(vector)
+	assert.NoError(t, err)
+	_, err = outputs.ParseVectorValue(`{"x":1,"y":2,"z":3,"w":4}`)
+	assert.Error(t, err)
+	_, err = outputs.ParseVectorValue("1,2,3")
+	assert.Error(t, err)
+
+	// output values are validated against the composite data type
+	output := outputs.NewOutput("test", "publisher1", "node1", types.OutputTypeColor, types.DefaultOutputInstance)
+	output.DataType = types.DataTypeColorRGB
+	assert.NoError(t, outputs.ValidateOutputValue(output, rgb))
+	assert.Error(t, outputs.ValidateOutputValue(output, "#ff8000"))
+}
@@ -0,0 +1,176 @@
+// Package outputs with tracking of the interest of consumers in expensive publications
+package outputs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultInterestDuration is the nr of seconds an announced interest lasts if the announcement has no duration
+const DefaultInterestDuration = 300
+
+// MaxInterestDuration is the max nr of seconds an announced interest lasts before it must be renewed
+const MaxInterestDuration = 3600
+
+// InterestHandler is invoked when consumers gain interest in publications that had no interest
+//  nodeID is the node of interest, or "" for all nodes of the publisher
+//  messageTypes are the message types that gained interest
+type InterestHandler func(nodeID string, messageTypes []string)
+
+// interestKey identifies the interest in a message type of a node
+type interestKey struct {
+	messageType string // message type of interest, eg $history
+	nodeID      string // node of interest, "" for all nodes
+}
+
+// ConsumerInterest tracks the $interest announcements of consumers in the publications of this publisher.
+// Interest expires after its duration unless the consumer renews it.
+type ConsumerInterest struct {
+	domain        string                    // the domain of this publisher
+	interests     map[interestKey]time.Time // expiry of the interest
+	messageSigner *messaging.MessageSigner  // subscription to interest announcements
+	onInterest    InterestHandler           // optional notification of gained interest
+	publisherID   string                    // the publisher whose publications are of interest
+	updateMutex   *sync.Mutex               // mutex for async updating of interest
+}
+
+// AddInterest adds or renews the interest in message types of a node. This notifies the interest
+// handler of the message types that had no interest.
+//  nodeID is the node of interest. Use "" for all nodes of the publisher.
+//  messageTypes are the message types of interest, eg $history, $event or $raw
+//  duration of the interest
+func (consumerInterest *ConsumerInterest) AddInterest(nodeID string, messageTypes []string, duration time.Duration) {
+	consumerInterest.updateMutex.Lock()
+	now := time.Now()
+	expiry := now.Add(duration)
+	gained := make([]string, 0)
+	for _, messageType := range messageTypes {
+		key := interestKey{messageType: messageType, nodeID: nodeID}
+		if !consumerInterest.hasInterest(nodeID, messageType, now) {
+			gained = append(gained, messageType)
+		}
+		if expiry.After(consumerInterest.interests[key]) {
+			consumerInterest.interests[key] = expiry
+		}
+	}
+	handler := consumerInterest.onInterest
+	consumerInterest.updateMutex.Unlock()
+
+	if len(gained) > 0 {
+		logrus.Infof("ConsumerInterest.AddInterest: Gained interest in %v of node '%s'", gained, nodeID)
+		if handler != nil {
+			handler(nodeID, gained)
+		}
+	}
+}
+
+// HasInterest returns true if a consumer is interested in a message type of a node
+//  nodeID is the node ID used in the publication address
+//  messageType is the type of the publication
+func (consumerInterest *ConsumerInterest) HasInterest(nodeID string, messageType string) bool {
+	consumerInterest.updateMutex.Lock()
+	defer consumerInterest.updateMutex.Unlock()
+	return consumerInterest.hasInterest(nodeID, messageType, time.Now())
+}
+
+// SetOnInterest sets the handler that is invoked when consumers gain interest. Use nil to remove the handler.
+func (consumerInterest *ConsumerInterest) SetOnInterest(handler InterestHandler) {
+	consumerInterest.updateMutex.Lock()
+	defer consumerInterest.updateMutex.Unlock()
+	consumerInterest.onInterest = handler
+}
+
+// Start listening for interest announcements
+func (consumerInterest *ConsumerInterest) Start() {
+	addr := MakeInterestAddress(consumerInterest.domain, consumerInterest.publisherID)
+	consumerInterest.messageSigner.Subscribe(addr, consumerInterest.receiveInterest)
+}
+
+// Stop listening for interest announcements
+func (consumerInterest *ConsumerInterest) Stop() {
+	addr := MakeInterestAddress(consumerInterest.domain, consumerInterest.publisherID)
+	consumerInterest.messageSigner.Unsubscribe(addr, consumerInterest.receiveInterest)
+}
+
+// hasInterest returns true if the interest in the message type of the node or all nodes hasn't expired
+// Expired interest is removed. Use within a locked section.
+func (consumerInterest *ConsumerInterest) hasInterest(nodeID string, messageType string, now time.Time) bool {
+	interested := false
+	for _, key := range []interestKey{{messageType: messageType, nodeID: nodeID}, {messageType: messageType}} {
+		expiry, found := consumerInterest.interests[key]
+		if found && now.Before(expiry) {
+			interested = true
+		} else if found {
+			delete(consumerInterest.interests, key)
+		}
+	}
+	return interested
+}
+
+// receiveInterest handles an interest announcement. The announcement must be signed by the consumer
+// unless signing is disabled.
+func (consumerInterest *ConsumerInterest) receiveInterest(address string, message string) error {
+	var interestMessage types.InterestMessage
+	_, isSigned, err := consumerInterest.messageSigner.DecodeMessage(message, &interestMessage)
+	if err != nil {
+		return lib.MakeErrorf("receiveInterest: Message to %s. Error %s. Message discarded.", address, err)
+	} else if !isSigned && !consumerInterest.messageSigner.IsUnsignedAllowed(address) {
+		return lib.MakeErrorf("receiveInterest: Interest on address %s isn't signed. Message discarded.", address)
+	}
+	duration := interestMessage.Duration
+	if duration <= 0 {
+		duration = DefaultInterestDuration
+	} else if duration > MaxInterestDuration {
+		duration = MaxInterestDuration
+	}
+	logrus.Infof("receiveInterest: Interest of %s in %v for %d seconds", interestMessage.Sender,
+		interestMessage.MessageTypes, duration)
+	consumerInterest.AddInterest(interestMessage.NodeID, interestMessage.MessageTypes, time.Duration(duration)*time.Second)
+	return nil
+}
+
+// MakeInterestAddress returns the address to announce interest in publications of a publisher
+func MakeInterestAddress(domain string, publisherID string) string {
+	return fmt.Sprintf("%s/%s/%s", domain, publisherID, types.MessageTypeInterest)
+}
+
+// PublishInterest announces the interest in publications of a publisher. The announcement is signed
+// and not retained. Renew the interest before its duration expires.
+//  domain and publisherID of the publisher of interest
+//  nodeID is the node of interest. Use "" for all nodes of the publisher.
+//  messageTypes are the message types of interest, eg $history, $event or $raw
+//  duration is the nr of seconds the interest lasts. Use 0 for DefaultInterestDuration.
+//  sender is the identity address of the consumer
+func PublishInterest(domain string, publisherID string, nodeID string, messageTypes []string, duration int,
+	sender string, messageSigner *messaging.MessageSigner) error {
+
+	addr := MakeInterestAddress(domain, publisherID)
+	logrus.Infof("PublishInterest: Interest in %v to %s", messageTypes, addr)
+	message := &types.InterestMessage{
+		Address:      addr,
+		Duration:     duration,
+		MessageTypes: messageTypes,
+		NodeID:       nodeID,
+		Sender:       sender,
+		Timestamp:    time.Now().Format(types.TimeFormat),
+	}
+	return messageSigner.PublishObject(addr, false, message, nil)
+}
+
+// NewConsumerInterest creates a new instance for tracking the interest in publications of a publisher.
+// Use Start() to start listening for interest announcements.
+func NewConsumerInterest(domain string, publisherID string, messageSigner *messaging.MessageSigner) *ConsumerInterest {
+	return &ConsumerInterest{
+		domain:        domain,
+		interests:     make(map[interestKey]time.Time),
+		messageSigner: messageSigner,
+		publisherID:   publisherID,
+		updateMutex:   &sync.Mutex{},
+	}
+}
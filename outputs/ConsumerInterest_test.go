@@ -0,0 +1,53 @@
+package outputs_test
+
+import (
+	"crypto/ecdsa"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsumerInterest(t *testing.T) {
+	const domain = "test"
+	const publisherID = "pub1"
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+	interest := outputs.NewConsumerInterest(domain, publisherID, signer)
+	gained := make([]string, 0)
+	interest.SetOnInterest(func(nodeID string, messageTypes []string) {
+		gained = append(gained, messageTypes...)
+	})
+	interest.Start()
+	assert.False(t, interest.HasInterest("node1", types.MessageTypeHistory))
+
+	// interest in all nodes
+	err := outputs.PublishInterest(domain, publisherID, "", []string{types.MessageTypeHistory}, 60, "test/consumer1/$identity", signer)
+	assert.NoError(t, err)
+	assert.True(t, interest.HasInterest("node1", types.MessageTypeHistory))
+	assert.False(t, interest.HasInterest("node1", types.MessageTypeRaw))
+	assert.Equal(t, []string{types.MessageTypeHistory}, gained)
+
+	// renewing interest doesn't notify again
+	outputs.PublishInterest(domain, publisherID, "", []string{types.MessageTypeHistory, types.MessageTypeRaw}, 60, "test/consumer1/$identity", signer)
+	assert.Equal(t, []string{types.MessageTypeHistory, types.MessageTypeRaw}, gained)
+
+	// interest in a single node expires
+	interest.AddInterest("node2", []string{types.MessageTypeEvent}, 50*time.Millisecond)
+	assert.True(t, interest.HasInterest("node2", types.MessageTypeEvent))
+	assert.False(t, interest.HasInterest("node1", types.MessageTypeEvent))
+	time.Sleep(60 * time.Millisecond)
+	assert.False(t, interest.HasInterest("node2", types.MessageTypeEvent))
+
+	// invalid announcements are ignored
+	messenger.Publish(outputs.MakeInterestAddress(domain, publisherID), false, "not an announcement")
+	assert.False(t, interest.HasInterest("node3", types.MessageTypeEvent))
+	interest.Stop()
+}
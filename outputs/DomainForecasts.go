@@ -0,0 +1,155 @@
+// Package outputs with merging of the forecasts of discovered outputs
+package outputs
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// DomainForecastRetention is how long values of a merged forecast are kept after their time has passed
+const DomainForecastRetention = 24 * time.Hour
+
+// ForecastHandler is invoked when a forecast of a domain output is received and merged
+//  outputAddress is the address of the output without message type
+//  forecast is the merged forecast of the output, ordered by time
+type ForecastHandler func(outputAddress string, forecast []types.OutputValue)
+
+// DomainForecasts with the merged forecasts of discovered outputs, eg for planning energy use against
+// a solar production or price forecast.
+// A received forecast supersedes the values of previous forecasts within its horizon, the time range from
+// its first to its last value. Previous values outside the horizon are kept, so a day-ahead and an
+// hour-ahead forecast combine into a single forecast. Forecasts that are created before the last merged
+// forecast of the output, eg a delayed retained message, are ignored.
+type DomainForecasts struct {
+	created       map[string]time.Time           // creation time of the last merged forecast by output address
+	forecasts     map[string][]types.OutputValue // merged forecast by output address, ordered by time
+	messageSigner *messaging.MessageSigner       // subscription to forecast messages
+	onForecast    ForecastHandler                // optional notification of merged forecasts
+	updateMutex   *sync.Mutex                    // mutex for async updating of forecasts
+}
+
+// GetForecast returns the values of the merged forecast of an output within a time range
+//  outputAddress is the address of the output, with or without message type
+//  from is the start of the range, inclusive. Use a zero time for all values before 'to'
+//  to is the end of the range, inclusive. Use a zero time for all values after 'from'
+// This returns the values ordered by time, or an empty list if no forecast was received
+func (domainForecasts *DomainForecasts) GetForecast(outputAddress string, from time.Time, to time.Time) []types.OutputValue {
+	domainForecasts.updateMutex.Lock()
+	defer domainForecasts.updateMutex.Unlock()
+
+	values := make([]types.OutputValue, 0)
+	for _, value := range domainForecasts.forecasts[lib.MakeBaseAddress(outputAddress)] {
+		valueTime := GetValueTime(&value)
+		if (from.IsZero() || !valueTime.Before(from)) && (to.IsZero() || !valueTime.After(to)) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// MergeForecast merges a forecast into the forecast of its output
+// This returns false if the forecast is created before the last merged forecast of the output.
+func (domainForecasts *DomainForecasts) MergeForecast(forecast *types.OutputForecastMessage) bool {
+	outputAddress := lib.MakeBaseAddress(forecast.Address)
+	created, _ := time.Parse(types.TimeFormat, forecast.Timestamp)
+
+	domainForecasts.updateMutex.Lock()
+	lastCreated, found := domainForecasts.created[outputAddress]
+	if found && created.Before(lastCreated) {
+		domainForecasts.updateMutex.Unlock()
+		return false
+	}
+	merged := mergeForecastValues(domainForecasts.forecasts[outputAddress], forecast.Forecast,
+		time.Now().Add(-DomainForecastRetention))
+	domainForecasts.created[outputAddress] = created
+	domainForecasts.forecasts[outputAddress] = merged
+	handler := domainForecasts.onForecast
+	domainForecasts.updateMutex.Unlock()
+
+	if handler != nil {
+		handler(outputAddress, merged)
+	}
+	return true
+}
+
+// SetOnForecast sets the handler that is invoked when a forecast is received and merged.
+// Use nil to remove the handler.
+func (domainForecasts *DomainForecasts) SetOnForecast(handler ForecastHandler) {
+	domainForecasts.updateMutex.Lock()
+	defer domainForecasts.updateMutex.Unlock()
+	domainForecasts.onForecast = handler
+}
+
+// Subscribe to the output forecasts of a domain publisher
+func (domainForecasts *DomainForecasts) Subscribe(domain string, publisherID string) {
+	domainForecasts.messageSigner.Subscribe(
+		MakeOutputValueAddress(domain, publisherID, types.MessageTypeForecast), domainForecasts.handleForecast)
+}
+
+// Unsubscribe from the output forecasts of a domain publisher
+func (domainForecasts *DomainForecasts) Unsubscribe(domain string, publisherID string) {
+	domainForecasts.messageSigner.Unsubscribe(
+		MakeOutputValueAddress(domain, publisherID, types.MessageTypeForecast), domainForecasts.handleForecast)
+}
+
+// handleForecast merges a received forecast of a domain output
+// This verifies that the message is properly signed by its publisher
+func (domainForecasts *DomainForecasts) handleForecast(address string, message string) error {
+	var forecastMsg types.OutputForecastMessage
+	isSigned, err := domainForecasts.messageSigner.VerifySignedMessage(message, &forecastMsg)
+	if err != nil {
+		return lib.MakeErrorf("handleForecast: Failed verifying signature on address %s: %s", address, err)
+	} else if !isSigned && !domainForecasts.messageSigner.IsUnsignedAllowed(address) {
+		return lib.MakeErrorf("handleForecast: Forecast on address %s isn't signed. Ignored", address)
+	}
+	forecastMsg.Address = address
+	domainForecasts.MergeForecast(&forecastMsg)
+	return nil
+}
+
+// mergeForecastValues returns the values of the current forecast outside the horizon of the new
+// forecast, combined with the values of the new forecast, ordered by time
+//  expiry is the time before which values are removed
+func mergeForecastValues(current []types.OutputValue, newValues []types.OutputValue, expiry time.Time) []types.OutputValue {
+	merged := make([]types.OutputValue, 0, len(current)+len(newValues))
+	var horizonStart, horizonEnd time.Time
+	for index, value := range newValues {
+		valueTime := GetValueTime(&value)
+		if index == 0 || valueTime.Before(horizonStart) {
+			horizonStart = valueTime
+		}
+		if index == 0 || valueTime.After(horizonEnd) {
+			horizonEnd = valueTime
+		}
+	}
+	for _, value := range current {
+		valueTime := GetValueTime(&value)
+		if len(newValues) > 0 && !valueTime.Before(horizonStart) && !valueTime.After(horizonEnd) {
+			continue
+		}
+		merged = append(merged, value)
+	}
+	merged = append(merged, newValues...)
+	sort.SliceStable(merged, func(i, j int) bool {
+		return GetValueTime(&merged[i]).Before(GetValueTime(&merged[j]))
+	})
+	for len(merged) > 0 && GetValueTime(&merged[0]).Before(expiry) {
+		merged = merged[1:]
+	}
+	return merged
+}
+
+// NewDomainForecasts creates a new instance for merging the forecasts of discovered outputs
+func NewDomainForecasts(messageSigner *messaging.MessageSigner) *DomainForecasts {
+	return &DomainForecasts{
+		created:       make(map[string]time.Time),
+		forecasts:     make(map[string][]types.OutputValue),
+		messageSigner: messageSigner,
+		updateMutex:   &sync.Mutex{},
+	}
+}
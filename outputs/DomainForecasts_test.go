@@ -0,0 +1,91 @@
+package outputs_test
+
+import (
+	"crypto/ecdsa"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// makeForecast returns forecast values of each hour from start
+func makeForecast(start time.Time, values ...string) []types.OutputValue {
+	forecast := make([]types.OutputValue, 0, len(values))
+	for index, value := range values {
+		valueTime := start.Add(time.Duration(index) * time.Hour)
+		forecast = append(forecast, types.OutputValue{
+			Timestamp: valueTime.Format(types.TimeFormat),
+			EpochTime: valueTime.Unix(),
+			Value:     value,
+		})
+	}
+	return forecast
+}
+
+func TestDomainForecasts(t *testing.T) {
+	const outputAddr = "test/pub1/solar1/power/0"
+	const forecastAddr = outputAddr + "/" + types.MessageTypeForecast
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+	forecasts := outputs.NewDomainForecasts(signer)
+	merged := 0
+	forecasts.SetOnForecast(func(address string, forecast []types.OutputValue) {
+		assert.Equal(t, outputAddr, address)
+		merged++
+	})
+	forecasts.Subscribe("test", "pub1")
+	assert.Empty(t, forecasts.GetForecast(outputAddr, time.Time{}, time.Time{}))
+
+	// a day-ahead forecast of 6 hours
+	start := time.Now().Truncate(time.Hour).Add(time.Hour)
+	created := time.Now()
+	signer.PublishObject(forecastAddr, true, &types.OutputForecastMessage{
+		Address:   forecastAddr,
+		Forecast:  makeForecast(start, "1", "2", "3", "4", "5", "6"),
+		Timestamp: created.Format(types.TimeFormat),
+	}, nil)
+	require.Equal(t, 1, merged)
+	assert.Len(t, forecasts.GetForecast(forecastAddr, time.Time{}, time.Time{}), 6)
+
+	// a later forecast for hours 2-3 supersedes the overlapping values
+	accepted := forecasts.MergeForecast(&types.OutputForecastMessage{
+		Address:   forecastAddr,
+		Forecast:  makeForecast(start.Add(2*time.Hour), "30", "40"),
+		Timestamp: created.Add(time.Minute).Format(types.TimeFormat),
+	})
+	assert.True(t, accepted)
+	forecast := forecasts.GetForecast(outputAddr, time.Time{}, time.Time{})
+	require.Len(t, forecast, 6)
+	assert.Equal(t, []string{"1", "2", "30", "40", "5", "6"},
+		[]string{forecast[0].Value, forecast[1].Value, forecast[2].Value, forecast[3].Value, forecast[4].Value, forecast[5].Value})
+
+	// query a time range
+	forecast = forecasts.GetForecast(outputAddr, start.Add(time.Hour), start.Add(3*time.Hour))
+	require.Len(t, forecast, 3)
+	assert.Equal(t, "2", forecast[0].Value)
+	assert.Equal(t, "40", forecast[2].Value)
+	forecast = forecasts.GetForecast(outputAddr, start.Add(4*time.Hour), time.Time{})
+	assert.Len(t, forecast, 2)
+
+	// an older forecast is ignored
+	accepted = forecasts.MergeForecast(&types.OutputForecastMessage{
+		Address:   forecastAddr,
+		Forecast:  makeForecast(start, "0"),
+		Timestamp: created.Add(-time.Hour).Format(types.TimeFormat),
+	})
+	assert.False(t, accepted)
+	assert.Equal(t, 2, merged)
+
+	// unsigned forecasts are rejected
+	messenger.Publish(forecastAddr, true, "{}")
+	assert.Equal(t, 2, merged)
+	forecasts.Unsubscribe("test", "pub1")
+}
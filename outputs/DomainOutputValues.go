@@ -2,12 +2,30 @@
 package outputs
 
 import (
+	"fmt"
 	"sync"
 
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
 )
 
+// OutputValueHandler is invoked when the latest value of a domain output is received
+//  latest is the received value. Its Address is the $latest address of the output.
+type OutputValueHandler func(latest *types.OutputLatestMessage)
+
+// OutputGapHandler is invoked when values of a domain output are missed, eg during a disconnect
+// Gaps are detected with the sequence nr of the received latest values. The missed values can be filled
+// from the history of the output, see GetHistory, or requested with PublishReplayRequest.
+//  latest is the first received value after the gap
+//  missed is the nr of missed values
+type OutputGapHandler func(latest *types.OutputLatestMessage, missed uint64)
+
+// OutputReplayHandler is invoked when replayed values of a domain output are received
+//  result holds the replayed values, oldest first, and the identity address of the requester
+type OutputReplayHandler func(result *types.OutputReplayResultMessage)
+
 // DomainOutputValues for managing values of discovered outputs
 type DomainOutputValues struct {
 	// c             lib.DomainCollection //
@@ -15,8 +33,20 @@ type DomainOutputValues struct {
 	latest        map[string]*types.OutputLatestMessage
 	history       map[string]*types.OutputHistoryMessage
 	event         map[string]*types.OutputEventMessage
-	messageSigner *messaging.MessageSigner // subscription to output discovery messages
-	updateMutex   *sync.Mutex              // mutex for async updating of outputs
+	messageSigner *messaging.MessageSigner   // subscription to output discovery messages
+	onGap         OutputGapHandler           // optional notification of missed latest values
+	onLatest      OutputValueHandler         // optional notification of received latest values
+	onReplay      OutputReplayHandler        // optional notification of received replayed values
+	sequences     *messaging.SequenceTracker // sequence nrs of the received latest values
+	updateMutex   *sync.Mutex                // mutex for async updating of outputs
+}
+
+// GetHistory returns the 'history' value message of an output
+func (dov *DomainOutputValues) GetHistory(historyAddress string) (value *types.OutputHistoryMessage, found bool) {
+	dov.updateMutex.Lock()
+	defer dov.updateMutex.Unlock()
+	value, found = dov.history[historyAddress]
+	return value, found
 }
 
 // GetRaw returns the latest raw value of an output
@@ -35,6 +65,60 @@ func (dov *DomainOutputValues) GetLatest(latestAddress string) (value *types.Out
 	return value, found
 }
 
+// SetOnGap sets the handler that is invoked when missed latest values of an output are detected.
+// Use nil to remove the handler.
+func (dov *DomainOutputValues) SetOnGap(handler OutputGapHandler) {
+	dov.updateMutex.Lock()
+	defer dov.updateMutex.Unlock()
+	dov.onGap = handler
+}
+
+// SetOnLatest sets the handler that is invoked when the latest value of an output is received.
+// Use nil to remove the handler.
+func (dov *DomainOutputValues) SetOnLatest(handler OutputValueHandler) {
+	dov.updateMutex.Lock()
+	defer dov.updateMutex.Unlock()
+	dov.onLatest = handler
+}
+
+// SetOnReplay sets the handler that is invoked when replayed values of an output are received, after
+// requesting them with PublishReplayRequest. Use nil to remove the handler.
+func (dov *DomainOutputValues) SetOnReplay(handler OutputReplayHandler) {
+	dov.updateMutex.Lock()
+	defer dov.updateMutex.Unlock()
+	dov.onReplay = handler
+}
+
+// Subscribe to the latest and history output values from a domain publisher
+func (dov *DomainOutputValues) Subscribe(domain string, publisherID string) {
+	dov.messageSigner.Subscribe(MakeOutputValueAddress(domain, publisherID, types.MessageTypeLatest), dov.handleLatest)
+	dov.messageSigner.Subscribe(MakeOutputValueAddress(domain, publisherID, types.MessageTypeHistory), dov.handleHistory)
+}
+
+// SubscribeReplay subscribes to the output values that are replayed at the request of a consumer
+//  requester is the identity address of the consumer that requests the replay
+func (dov *DomainOutputValues) SubscribeReplay(requester string) error {
+	addr, err := MakeReplayResultAddress(requester)
+	if err == nil {
+		dov.messageSigner.Subscribe(addr, dov.handleReplay)
+	}
+	return err
+}
+
+// Unsubscribe from publisher output values
+func (dov *DomainOutputValues) Unsubscribe(domain string, publisherID string) {
+	dov.messageSigner.Unsubscribe(MakeOutputValueAddress(domain, publisherID, types.MessageTypeLatest), dov.handleLatest)
+	dov.messageSigner.Unsubscribe(MakeOutputValueAddress(domain, publisherID, types.MessageTypeHistory), dov.handleHistory)
+}
+
+// UnsubscribeReplay unsubscribes from the replayed output values of a consumer
+func (dov *DomainOutputValues) UnsubscribeReplay(requester string) {
+	addr, err := MakeReplayResultAddress(requester)
+	if err == nil {
+		dov.messageSigner.Unsubscribe(addr, dov.handleReplay)
+	}
+}
+
 // UpdateEvent replaces the node event value
 func (dov *DomainOutputValues) UpdateEvent(value *types.OutputEventMessage) {
 	dov.updateMutex.Lock()
@@ -63,11 +147,89 @@ func (dov *DomainOutputValues) UpdateRaw(address string, value string) {
 	dov.raw[address] = value
 }
 
+// handleHistory updates the history value of a domain output
+// This verifies that the message is properly signed by its publisher
+func (dov *DomainOutputValues) handleHistory(address string, message string) error {
+	var historyMsg types.OutputHistoryMessage
+	err := dov.verifyValue(address, message, &historyMsg)
+	if err != nil {
+		return err
+	}
+	historyMsg.Address = address
+	dov.UpdateHistory(&historyMsg)
+	return nil
+}
+
+// handleLatest updates the latest value of a domain output
+// This verifies that the message is properly signed by its publisher
+func (dov *DomainOutputValues) handleLatest(address string, message string) error {
+	var latestMsg types.OutputLatestMessage
+	err := dov.verifyValue(address, message, &latestMsg)
+	if err != nil {
+		return err
+	}
+	latestMsg.Address = address
+	dov.UpdateLatest(&latestMsg)
+	missed := dov.sequences.Update(address, latestMsg.Sequence)
+
+	dov.updateMutex.Lock()
+	handler := dov.onLatest
+	gapHandler := dov.onGap
+	dov.updateMutex.Unlock()
+	if missed > 0 {
+		logrus.Infof("DomainOutputValues.handleLatest: %d values missed on %s before sequence nr %d",
+			missed, address, latestMsg.Sequence)
+		if gapHandler != nil {
+			gapHandler(&latestMsg, missed)
+		}
+	}
+	if handler != nil {
+		handler(&latestMsg)
+	}
+	return nil
+}
+
+// handleReplay notifies the handler of replayed values of a domain output
+// This verifies that the message is properly signed by its publisher. The address of the message is
+// that of the replayed output. Replayed values don't affect the latest value or the detection of gaps.
+func (dov *DomainOutputValues) handleReplay(address string, message string) error {
+	var replayMsg types.OutputReplayResultMessage
+	err := dov.verifyValue(address, message, &replayMsg)
+	if err != nil {
+		return err
+	}
+	dov.updateMutex.Lock()
+	handler := dov.onReplay
+	dov.updateMutex.Unlock()
+	if handler != nil {
+		handler(&replayMsg)
+	}
+	return nil
+}
+
+// verifyValue verifies the signature of an output value message and decodes it into object
+func (dov *DomainOutputValues) verifyValue(address string, message string, object interface{}) error {
+	isSigned, err := dov.messageSigner.VerifySignedMessage(message, object)
+	if err != nil {
+		return lib.MakeErrorf("verifyValue: Failed verifying signature on address %s: %s", address, err)
+	} else if !isSigned && !dov.messageSigner.IsUnsignedAllowed(address) {
+		return lib.MakeErrorf("verifyValue: Value on address %s isn't signed. Ignored", address)
+	}
+	return nil
+}
+
+// MakeOutputValueAddress creates the subscription address for the values of all outputs of a publisher
+//  messageType is the type of value, eg types.MessageTypeLatest
+func MakeOutputValueAddress(domain string, publisherID string, messageType types.MessageType) string {
+	return fmt.Sprintf("%s/%s/+/+/+/%s", domain, publisherID, messageType)
+}
+
 // NewDomainOutputValues creates a new instance for handling of discovered output values
 func NewDomainOutputValues(messageSigner *messaging.MessageSigner) *DomainOutputValues {
 	return &DomainOutputValues{
 		// c:             lib.NewDomainCollection(messageSigner, reflect.TypeOf(&types.OutputLatestMessage{})),
 		messageSigner: messageSigner,
+		sequences:     messaging.NewSequenceTracker(),
 		updateMutex:   &sync.Mutex{},
 		raw:           make(map[string]string, 0),
 		latest:        make(map[string]*types.OutputLatestMessage, 0),
@@ -9,6 +9,7 @@ import (
 	"github.com/iotdomain/iotdomain-go/outputs"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCreateDomainOutputValues(t *testing.T) {
@@ -39,3 +40,37 @@ func TestCreateDomainOutputValues(t *testing.T) {
 	collection.UpdateLatest(&types.OutputLatestMessage{})
 	collection.UpdateRaw(out1Addr, "raw")
 }
+
+func TestDomainOutputValueGaps(t *testing.T) {
+	const latestAddr = "test/pub1/node1/switch/0/" + types.MessageTypeLatest
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+	collection := outputs.NewDomainOutputValues(signer)
+	var gapLatest *types.OutputLatestMessage
+	var gapMissed uint64
+	collection.SetOnGap(func(latest *types.OutputLatestMessage, missed uint64) {
+		gapLatest = latest
+		gapMissed = missed
+	})
+	collection.Subscribe("test", "pub1")
+
+	// consecutive values have no gap
+	signer.PublishObject(latestAddr, true, &types.OutputLatestMessage{Address: latestAddr, Value: "1"}, nil)
+	signer.PublishObject(latestAddr, true, &types.OutputLatestMessage{Address: latestAddr, Value: "2"}, nil)
+	latest, found := collection.GetLatest(latestAddr)
+	require.True(t, found)
+	assert.Equal(t, uint64(2), latest.Sequence)
+	assert.Nil(t, gapLatest)
+
+	// values that aren't delivered, eg while the consumer is down, are missed
+	signer.SequenceNumbers().Next(latestAddr)
+	signer.SequenceNumbers().Next(latestAddr)
+	signer.PublishObject(latestAddr, true, &types.OutputLatestMessage{Address: latestAddr, Value: "5"}, nil)
+	require.NotNil(t, gapLatest)
+	assert.Equal(t, "5", gapLatest.Value)
+	assert.Equal(t, uint64(2), gapMissed)
+}
@@ -0,0 +1,47 @@
+// Package outputs with output values obtained by running a command
+package outputs
+
+import (
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// ExecOutput is an output whose value is the output of a command.
+// Intended to wrap command line tools as domain devices without writing Go.
+type ExecOutput struct {
+	args    []string                      // arguments of the command
+	command string                        // command to run
+	output  *types.OutputDiscoveryMessage // the registered output
+	Timeout time.Duration                 // max time the command can run. Default is lib.DefaultCommandTimeout
+}
+
+// Output returns the registered output of the exec output
+func (execOutput *ExecOutput) Output() *types.OutputDiscoveryMessage {
+	return execOutput.output
+}
+
+// Run runs the command and returns its output with surrounding whitespace removed
+func (execOutput *ExecOutput) Run() (value string, err error) {
+	value, err = lib.RunCommand(execOutput.Timeout, nil, execOutput.command, execOutput.args...)
+	if err != nil {
+		return "", lib.MakeErrorf("ExecOutput.Run: Output %s: %s", execOutput.output.Address, err)
+	}
+	return value, nil
+}
+
+// NewExecOutput creates and registers an output whose value is the output of a command
+// The command is run directly, not through a shell.
+//  command and args is the command to run
+func NewExecOutput(registeredOutputs *RegisteredOutputs,
+	nodeHWID string, outputType types.OutputType, instance string,
+	command string, args ...string) *ExecOutput {
+
+	execOutput := &ExecOutput{
+		args:    args,
+		command: command,
+		output:  registeredOutputs.CreateOutput(nodeHWID, outputType, instance),
+	}
+	return execOutput
+}
@@ -0,0 +1,249 @@
+// Package outputs with retention of past forecasts and their accuracy against realized output values
+package outputs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Instance suffixes of the companion outputs with the accuracy of the forecasts of an output
+const (
+	ForecastMAESuffix  = "-mae"  // companion output with the mean absolute error of past forecasts
+	ForecastBiasSuffix = "-bias" // companion output with the mean error of past forecasts, positive if too high
+)
+
+// ForecastAccuracyWindow is the period of realized forecast values that is included in the accuracy
+const ForecastAccuracyWindow = 7 * 24 * time.Hour
+
+// MaxPendingForecastValues is the max nr of past forecast values of an output that wait to be realized
+const MaxPendingForecastValues = 10000
+
+// ForecastAccuracy with the accuracy of the past forecasts of an output
+type ForecastAccuracy struct {
+	Bias  float64 // mean of forecast minus realized value, positive if forecasts are too high
+	Count int     // nr of realized forecast values in the accuracy window
+	MAE   float64 // mean absolute error of the forecast values
+}
+
+// ForecastError with the error of a past forecast value once it is realized
+type ForecastError struct {
+	Error     float64 `json:"error"`     // forecast minus realized value
+	Timestamp string  `json:"timestamp"` // time of the forecast value
+}
+
+// PastForecast with the past forecast values of an output that aren't realized yet and the errors
+// of the realized values
+type PastForecast struct {
+	Errors  []ForecastError `json:"errors"`  // errors of the realized values in the accuracy window
+	Pending OutputForecast  `json:"pending"` // numeric forecast values that aren't realized yet
+}
+
+// EvaluateForecast compares the past forecast values of an output with its realized values. A forecast
+// value is realized once the history has a value at or after its time. The realized value is linearly
+// interpolated from the history values around the forecast time. Forecast values older than the history
+// can't be realized and are dropped. Errors older than the ForecastAccuracyWindow are removed.
+//  history of the output, most recent first as in the output history
+//  now is the time the accuracy window ends
+// This returns the updated accuracy, or nil if no forecast value was realized
+func (regForecasts *RegisteredForecastValues) EvaluateForecast(
+	outputID string, history OutputHistory, now time.Time) *ForecastAccuracy {
+
+	regForecasts.updateMutex.Lock()
+	defer regForecasts.updateMutex.Unlock()
+
+	past := regForecasts.pastForecasts[outputID]
+	samples := sortHistory(history)
+	if past == nil || len(past.Pending) == 0 || len(samples) == 0 {
+		return nil
+	}
+	oldest := samples[0].time
+	newest := samples[len(samples)-1].time
+	pending := make(OutputForecast, 0, len(past.Pending))
+	realized := 0
+	for i := range past.Pending {
+		forecastValue := past.Pending[i]
+		forecastTime := GetValueTime(&forecastValue)
+		if forecastTime.After(newest) {
+			pending = append(pending, forecastValue)
+			continue
+		} else if forecastTime.Before(oldest) {
+			continue
+		}
+		// find the most recent sample at or before the forecast time
+		index := 0
+		for index < len(samples)-1 && !samples[index+1].time.After(forecastTime) {
+			index++
+		}
+		value, err := interpolate(samples, index, forecastTime)
+		if err != nil {
+			continue
+		}
+		predicted, _ := strconv.ParseFloat(forecastValue.Value, 64)
+		past.Errors = append(past.Errors, ForecastError{
+			Error:     predicted - value,
+			Timestamp: forecastTime.Format(types.TimeFormat),
+		})
+		realized++
+	}
+	if len(pending) == len(past.Pending) {
+		return nil
+	}
+	past.Pending = pending
+	past.Errors = pruneForecastErrors(past.Errors, now.Add(-ForecastAccuracyWindow))
+	regForecasts.pastUpdateCount++
+	if realized == 0 {
+		return nil
+	}
+	return getForecastAccuracy(past.Errors)
+}
+
+// GetForecastAccuracy returns the accuracy of the realized past forecast values of an output
+// Returns nil if no forecast value of the output has been realized
+func (regForecasts *RegisteredForecastValues) GetForecastAccuracy(outputID string) *ForecastAccuracy {
+	regForecasts.updateMutex.Lock()
+	defer regForecasts.updateMutex.Unlock()
+
+	past := regForecasts.pastForecasts[outputID]
+	if past == nil {
+		return nil
+	}
+	return getForecastAccuracy(past.Errors)
+}
+
+// GetPastForecast returns a copy of the past forecast of an output, or nil if the output has none
+func (regForecasts *RegisteredForecastValues) GetPastForecast(outputID string) *PastForecast {
+	regForecasts.updateMutex.Lock()
+	defer regForecasts.updateMutex.Unlock()
+
+	past := regForecasts.pastForecasts[outputID]
+	if past == nil {
+		return nil
+	}
+	return &PastForecast{
+		Errors:  append([]ForecastError{}, past.Errors...),
+		Pending: append(OutputForecast{}, past.Pending...),
+	}
+}
+
+// LoadPastForecasts loads the past forecasts of outputs from a JSON file
+// Past forecasts of outputs that already have a forecast are kept.
+func (regForecasts *RegisteredForecastValues) LoadPastForecasts(filename string) error {
+	pastForecasts := make(map[string]*PastForecast)
+
+	jsonForecasts, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return lib.MakeErrorf("LoadPastForecasts: Unable to open file %s: %s", filename, err)
+	}
+	err = json.Unmarshal(jsonForecasts, &pastForecasts)
+	if err != nil {
+		return lib.MakeErrorf("LoadPastForecasts: Error parsing JSON forecasts file %s: %v", filename, err)
+	}
+	logrus.Infof("LoadPastForecasts: Past forecasts loaded successfully from %s", filename)
+	regForecasts.updateMutex.Lock()
+	defer regForecasts.updateMutex.Unlock()
+	for outputID, loaded := range pastForecasts {
+		if regForecasts.pastForecasts[outputID] == nil && loaded != nil {
+			regForecasts.pastForecasts[outputID] = loaded
+		}
+	}
+	return nil
+}
+
+// PastForecastUpdateCount returns the nr of past forecast updates since they were last saved
+func (regForecasts *RegisteredForecastValues) PastForecastUpdateCount() int {
+	regForecasts.updateMutex.Lock()
+	defer regForecasts.updateMutex.Unlock()
+	return regForecasts.pastUpdateCount
+}
+
+// SavePastForecasts saves the past forecasts of outputs to a JSON file
+func (regForecasts *RegisteredForecastValues) SavePastForecasts(filename string) error {
+	regForecasts.updateMutex.Lock()
+	jsonText, err := json.MarshalIndent(regForecasts.pastForecasts, "", "  ")
+	regForecasts.pastUpdateCount = 0
+	regForecasts.updateMutex.Unlock()
+	if err != nil {
+		return lib.MakeErrorf("SavePastForecasts: Error Marshalling JSON forecasts '%s': %v", filename, err)
+	}
+	err = ioutil.WriteFile(filename, jsonText, 0664)
+	if err != nil {
+		return lib.MakeErrorf("SavePastForecasts: Error saving forecasts to JSON file %s: %v", filename, err)
+	}
+	logrus.Infof("SavePastForecasts: Past forecasts saved successfully to JSON file %s", filename)
+	return nil
+}
+
+// addPastForecast retains the numeric values of a forecast until they are realized
+// A value for a time that is already pending replaces the pending value, so overlapping forecasts are
+// evaluated once using the most recent forecast.
+// The oldest values are dropped if more than MaxPendingForecastValues are waiting. Use within a locked section.
+func (regForecasts *RegisteredForecastValues) addPastForecast(outputID string, forecast OutputForecast) {
+	past := regForecasts.pastForecasts[outputID]
+	if past == nil {
+		past = &PastForecast{Errors: make([]ForecastError, 0), Pending: make(OutputForecast, 0)}
+	}
+	pendingIndex := make(map[int64]int, len(past.Pending))
+	for i := range past.Pending {
+		pendingIndex[GetValueTime(&past.Pending[i]).UnixNano()] = i
+	}
+	added := 0
+	for _, forecastValue := range forecast {
+		if _, err := strconv.ParseFloat(forecastValue.Value, 64); err != nil {
+			continue
+		}
+		forecastTime := GetValueTime(&forecastValue).UnixNano()
+		if i, isPending := pendingIndex[forecastTime]; isPending {
+			past.Pending[i] = forecastValue
+		} else {
+			pendingIndex[forecastTime] = len(past.Pending)
+			past.Pending = append(past.Pending, forecastValue)
+		}
+		added++
+	}
+	if added == 0 {
+		return
+	}
+	if len(past.Pending) > MaxPendingForecastValues {
+		samples := sortHistory(OutputHistory(past.Pending))
+		past.Pending = make(OutputForecast, 0, MaxPendingForecastValues)
+		for _, sample := range samples[len(samples)-MaxPendingForecastValues:] {
+			past.Pending = append(past.Pending, sample.value)
+		}
+	}
+	regForecasts.pastForecasts[outputID] = past
+	regForecasts.pastUpdateCount++
+}
+
+// getForecastAccuracy returns the accuracy of forecast errors, or nil if there are no errors
+func getForecastAccuracy(forecastErrors []ForecastError) *ForecastAccuracy {
+	if len(forecastErrors) == 0 {
+		return nil
+	}
+	accuracy := &ForecastAccuracy{Count: len(forecastErrors)}
+	for _, forecastError := range forecastErrors {
+		accuracy.Bias += forecastError.Error
+		accuracy.MAE += math.Abs(forecastError.Error)
+	}
+	accuracy.Bias /= float64(accuracy.Count)
+	accuracy.MAE /= float64(accuracy.Count)
+	return accuracy
+}
+
+// pruneForecastErrors returns the forecast errors at or after the start of the accuracy window
+func pruneForecastErrors(forecastErrors []ForecastError, windowStart time.Time) []ForecastError {
+	pruned := make([]ForecastError, 0, len(forecastErrors))
+	for _, forecastError := range forecastErrors {
+		errorTime, err := time.Parse(types.TimeFormat, forecastError.Timestamp)
+		if err != nil || !errorTime.Before(windowStart) {
+			pruned = append(pruned, forecastError)
+		}
+	}
+	return pruned
+}
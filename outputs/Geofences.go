@@ -0,0 +1,114 @@
+// Package outputs with geofences that detect when a location output enters or leaves a region
+package outputs
+
+import (
+	"math"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// EarthRadius is the mean radius of the earth in meters
+const EarthRadius = 6371000
+
+// Geofence events
+const (
+	GeofenceEventEnter = "enter" // the location entered the geofence
+	GeofenceEventLeave = "leave" // the location left the geofence
+)
+
+// Geofence is a circular region around a GPS location
+type Geofence struct {
+	Name      string  `yaml:"name"`      // name of the region, eg home
+	Latitude  float64 `yaml:"latitude"`  // latitude of the center in degrees
+	Longitude float64 `yaml:"longitude"` // longitude of the center in degrees
+	Radius    float64 `yaml:"radius"`    // radius of the region in meters
+}
+
+// Contains returns true if the location is within the geofence
+func (fence *Geofence) Contains(location types.Location) bool {
+	center := types.Location{Latitude: fence.Latitude, Longitude: fence.Longitude}
+	return LocationDistance(center, location) <= fence.Radius
+}
+
+// GeofenceEvent describes a location output entering or leaving a geofence
+type GeofenceEvent struct {
+	Event    string // GeofenceEventEnter or GeofenceEventLeave
+	Fence    string // name of the geofence
+	OutputID string // ID of the location output
+}
+
+// RegisteredGeofences tracks the geofences of location outputs and whether the outputs are inside them
+type RegisteredGeofences struct {
+	fences      map[string][]Geofence      // geofences by location output ID
+	inside      map[string]map[string]bool // inside state by output ID and fence name
+	updateMutex *sync.Mutex                // mutex for async updating of locations
+}
+
+// GetGeofences returns the geofences of a location output
+func (regFences *RegisteredGeofences) GetGeofences(outputID string) []Geofence {
+	regFences.updateMutex.Lock()
+	defer regFences.updateMutex.Unlock()
+	return regFences.fences[outputID]
+}
+
+// SetGeofences sets the geofences for a location output, replacing existing geofences
+// The inside state is determined by the next location update.
+func (regFences *RegisteredGeofences) SetGeofences(outputID string, fences []Geofence) {
+	regFences.updateMutex.Lock()
+	defer regFences.updateMutex.Unlock()
+	regFences.fences[outputID] = fences
+	delete(regFences.inside, outputID)
+}
+
+// UpdateLocation updates the location of an output and returns the geofences it entered or left
+// The first location of an output determines its inside state without events.
+func (regFences *RegisteredGeofences) UpdateLocation(outputID string, location types.Location) []GeofenceEvent {
+	regFences.updateMutex.Lock()
+	defer regFences.updateMutex.Unlock()
+
+	events := make([]GeofenceEvent, 0)
+	fences := regFences.fences[outputID]
+	if len(fences) == 0 {
+		return events
+	}
+	inside, isKnown := regFences.inside[outputID]
+	if !isKnown {
+		inside = make(map[string]bool)
+		regFences.inside[outputID] = inside
+	}
+	for _, fence := range fences {
+		isInside := fence.Contains(location)
+		if isKnown && isInside != inside[fence.Name] {
+			event := GeofenceEventLeave
+			if isInside {
+				event = GeofenceEventEnter
+			}
+			events = append(events, GeofenceEvent{Event: event, Fence: fence.Name, OutputID: outputID})
+		}
+		inside[fence.Name] = isInside
+	}
+	return events
+}
+
+// LocationDistance returns the great circle distance in meters between two locations
+func LocationDistance(location1 types.Location, location2 types.Location) float64 {
+	lat1 := location1.Latitude * math.Pi / 180
+	lat2 := location2.Latitude * math.Pi / 180
+	deltaLat := lat2 - lat1
+	deltaLon := (location2.Longitude - location1.Longitude) * math.Pi / 180
+
+	a := math.Sin(deltaLat/2)*math.Sin(deltaLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(deltaLon/2)*math.Sin(deltaLon/2)
+	return 2 * EarthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// NewRegisteredGeofences creates a collection of geofences without regions
+func NewRegisteredGeofences() *RegisteredGeofences {
+	regFences := &RegisteredGeofences{
+		fences:      make(map[string][]Geofence),
+		inside:      make(map[string]map[string]bool),
+		updateMutex: &sync.Mutex{},
+	}
+	return regFences
+}
@@ -0,0 +1,44 @@
+package outputs_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeofences(t *testing.T) {
+	const outputID = "node1.location.0"
+	home := outputs.Geofence{Name: "home", Latitude: 49.2827, Longitude: -123.1207, Radius: 500}
+	nearby := types.Location{Latitude: 49.2830, Longitude: -123.1210}
+	faraway := types.Location{Latitude: 49.3000, Longitude: -123.1207}
+
+	// about 1.9 km north
+	distance := outputs.LocationDistance(types.Location{Latitude: home.Latitude, Longitude: home.Longitude}, faraway)
+	assert.InDelta(t, 1924, distance, 10)
+	assert.True(t, home.Contains(nearby))
+	assert.False(t, home.Contains(faraway))
+
+	regFences := outputs.NewRegisteredGeofences()
+	// without geofences there are no events
+	events := regFences.UpdateLocation(outputID, nearby)
+	assert.Empty(t, events)
+
+	regFences.SetGeofences(outputID, []outputs.Geofence{home})
+	assert.Len(t, regFences.GetGeofences(outputID), 1)
+	// the first location determines the inside state
+	events = regFences.UpdateLocation(outputID, nearby)
+	assert.Empty(t, events)
+
+	events = regFences.UpdateLocation(outputID, faraway)
+	require.Len(t, events, 1)
+	assert.Equal(t, outputs.GeofenceEventLeave, events[0].Event)
+	assert.Equal(t, "home", events[0].Fence)
+	events = regFences.UpdateLocation(outputID, faraway)
+	assert.Empty(t, events)
+	events = regFences.UpdateLocation(outputID, nearby)
+	require.Len(t, events, 1)
+	assert.Equal(t, outputs.GeofenceEventEnter, events[0].Event)
+}
@@ -0,0 +1,183 @@
+// Package outputs with resampling and rate of change of output value histories
+package outputs
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// Methods to resample a history onto a fixed time grid
+const (
+	ResampleLinear = "linear" // linear interpolation between the surrounding values, numeric values only
+	ResampleLOCF   = "locf"   // last observation carried forward
+)
+
+// MaxResamplePoints is the max nr of grid points of a resampled history
+const MaxResamplePoints = 100000
+
+// Time units of the rate of change companion output of an output
+const (
+	RateUnitOff    = "off"    // rate of change is disabled
+	RateUnitSecond = "second" // change per second
+	RateUnitMinute = "minute" // change per minute
+	RateUnitHour   = "hour"   // change per hour, eg kW from a kWh counter
+)
+
+// RateUnits are the configurable time units of the rate of change
+var RateUnits = []string{RateUnitOff, RateUnitSecond, RateUnitMinute, RateUnitHour}
+
+// RateSuffix is the instance suffix of the companion output with the rate of change of an output
+const RateSuffix = "-rate"
+
+// historySample is a history value with its parsed time
+type historySample struct {
+	time  time.Time
+	value types.OutputValue
+}
+
+// GetRateUnitDuration returns the duration of a rate unit, or 0 if the unit is off or unknown
+func GetRateUnitDuration(unit string) time.Duration {
+	switch unit {
+	case RateUnitSecond:
+		return time.Second
+	case RateUnitMinute:
+		return time.Minute
+	case RateUnitHour:
+		return time.Hour
+	}
+	return 0
+}
+
+// GetValueTime returns the time of a history value from its timestamp, or from its epoch time if
+// the timestamp is missing or invalid
+func GetValueTime(value *types.OutputValue) time.Time {
+	timestamp, err := time.Parse(types.TimeFormat, value.Timestamp)
+	if err != nil {
+		return time.Unix(value.EpochTime, 0)
+	}
+	return timestamp
+}
+
+// RateOfChange returns the rate of change between consecutive values of a history. Each rate has
+// the timestamp of the most recent of the two values. Values with the same time are skipped.
+//  history with numeric values, most recent first as in the output history
+//  per is the time unit of the rate, eg time.Hour for kW from a kWh counter
+// This returns the rates, most recent first, or an error if a value is not a number.
+func RateOfChange(history OutputHistory, per time.Duration) (OutputHistory, error) {
+	if per <= 0 {
+		return nil, fmt.Errorf("RateOfChange: Invalid time unit %s", per)
+	}
+	samples := sortHistory(history)
+	rates := make(OutputHistory, 0)
+	for i := len(samples) - 1; i > 0; i-- {
+		newer, older := samples[i], samples[i-1]
+		elapsed := newer.time.Sub(older.time)
+		if elapsed <= 0 {
+			continue
+		}
+		newerValue, err := strconv.ParseFloat(newer.value.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("RateOfChange: Value '%s' is not a number", newer.value.Value)
+		}
+		olderValue, err := strconv.ParseFloat(older.value.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("RateOfChange: Value '%s' is not a number", older.value.Value)
+		}
+		rate := (newerValue - olderValue) * float64(per) / float64(elapsed)
+		rates = append(rates, types.OutputValue{
+			EpochTime: newer.value.EpochTime,
+			Quality:   newer.value.Quality,
+			Timestamp: newer.value.Timestamp,
+			Value:     strconv.FormatFloat(rate, 'f', -1, 64),
+		})
+	}
+	return rates, nil
+}
+
+// ResampleHistory resamples a history onto a fixed time grid from start to end. Grid points before
+// the oldest value of the history are omitted. Points after the most recent value hold that value.
+//  history with the values to resample, most recent first as in the output history
+//  start and end time of the grid. Both are included.
+//  interval between grid points
+//  method is ResampleLinear for numeric values or ResampleLOCF
+// This returns the resampled values, most recent first, or an error if the grid is invalid or a
+// value can't be interpolated.
+func ResampleHistory(history OutputHistory, start time.Time, end time.Time, interval time.Duration,
+	method string) (OutputHistory, error) {
+
+	if method != ResampleLinear && method != ResampleLOCF {
+		return nil, lib.MakeErrorf("ResampleHistory: Unknown resample method '%s'", method)
+	} else if interval <= 0 || end.Before(start) {
+		return nil, lib.MakeErrorf("ResampleHistory: Invalid grid from %s to %s with interval %s", start, end, interval)
+	} else if int64(end.Sub(start)/interval) >= MaxResamplePoints {
+		return nil, lib.MakeErrorf("ResampleHistory: Grid exceeds %d points", MaxResamplePoints)
+	}
+	samples := sortHistory(history)
+	resampled := make(OutputHistory, 0)
+	index := 0
+	for gridTime := start; !gridTime.After(end); gridTime = gridTime.Add(interval) {
+		// find the most recent sample at or before the grid time
+		for index < len(samples)-1 && !samples[index+1].time.After(gridTime) {
+			index++
+		}
+		if len(samples) == 0 || samples[index].time.After(gridTime) {
+			continue
+		}
+		value := samples[index].value.Value
+		if method == ResampleLinear {
+			interpolated, err := interpolate(samples, index, gridTime)
+			if err != nil {
+				return nil, err
+			}
+			value = strconv.FormatFloat(interpolated, 'f', -1, 64)
+		}
+		resampled = append(resampled, types.OutputValue{
+			EpochTime: gridTime.Unix(),
+			Quality:   samples[index].value.Quality,
+			Timestamp: gridTime.Format(types.TimeFormat),
+			Value:     value,
+		})
+	}
+	// most recent first
+	for i, j := 0, len(resampled)-1; i < j; i, j = i+1, j-1 {
+		resampled[i], resampled[j] = resampled[j], resampled[i]
+	}
+	return resampled, nil
+}
+
+// interpolate returns the linear interpolation at the grid time between a sample and the next sample
+// The sample value is used if there is no next sample.
+func interpolate(samples []historySample, index int, gridTime time.Time) (float64, error) {
+	before := samples[index]
+	beforeValue, err := strconv.ParseFloat(before.value.Value, 64)
+	if err != nil {
+		return 0, lib.MakeErrorf("ResampleHistory: Value '%s' is not a number", before.value.Value)
+	}
+	if index == len(samples)-1 || !before.time.Before(gridTime) {
+		return beforeValue, nil
+	}
+	after := samples[index+1]
+	afterValue, err := strconv.ParseFloat(after.value.Value, 64)
+	if err != nil {
+		return 0, lib.MakeErrorf("ResampleHistory: Value '%s' is not a number", after.value.Value)
+	}
+	fraction := float64(gridTime.Sub(before.time)) / float64(after.time.Sub(before.time))
+	return beforeValue + (afterValue-beforeValue)*fraction, nil
+}
+
+// sortHistory returns the history values with their time, oldest first
+func sortHistory(history OutputHistory) []historySample {
+	samples := make([]historySample, 0, len(history))
+	for i := range history {
+		samples = append(samples, historySample{time: GetValueTime(&history[i]), value: history[i]})
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].time.Before(samples[j].time)
+	})
+	return samples
+}
@@ -0,0 +1,89 @@
+package outputs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// makeHistory returns a history with the values at the given minutes after the start, most recent first
+func makeHistory(start time.Time, minutes []int, values []string) outputs.OutputHistory {
+	history := make(outputs.OutputHistory, 0)
+	for i := len(minutes) - 1; i >= 0; i-- {
+		timestamp := start.Add(time.Duration(minutes[i]) * time.Minute)
+		history = append(history, types.OutputValue{
+			EpochTime: timestamp.Unix(),
+			Timestamp: timestamp.Format(types.TimeFormat),
+			Value:     values[i],
+		})
+	}
+	return history
+}
+
+func TestResampleHistory(t *testing.T) {
+	start := time.Date(2020, 6, 1, 10, 0, 0, 0, time.Local)
+	history := makeHistory(start, []int{5, 15, 20}, []string{"10", "30", "20"})
+
+	// linear from 10:00 to 10:25 every 5 minutes. 10:00 is before the first value.
+	resampled, err := outputs.ResampleHistory(history, start, start.Add(25*time.Minute), 5*time.Minute, outputs.ResampleLinear)
+	require.NoError(t, err)
+	values := make([]string, 0)
+	for _, value := range resampled {
+		values = append(values, value.Value)
+	}
+	assert.Equal(t, []string{"20", "20", "30", "20", "10"}, values)
+	assert.Equal(t, start.Add(25*time.Minute).Format(types.TimeFormat), resampled[0].Timestamp)
+
+	// last observation carried forward
+	resampled, err = outputs.ResampleHistory(history, start, start.Add(25*time.Minute), 5*time.Minute, outputs.ResampleLOCF)
+	require.NoError(t, err)
+	values = make([]string, 0)
+	for _, value := range resampled {
+		values = append(values, value.Value)
+	}
+	assert.Equal(t, []string{"20", "20", "30", "10", "10"}, values)
+
+	// strings can be carried forward but not interpolated
+	textHistory := makeHistory(start, []int{0, 10}, []string{"on", "off"})
+	resampled, err = outputs.ResampleHistory(textHistory, start, start.Add(10*time.Minute), 5*time.Minute, outputs.ResampleLOCF)
+	require.NoError(t, err)
+	assert.Len(t, resampled, 3)
+	_, err = outputs.ResampleHistory(textHistory, start, start.Add(10*time.Minute), 5*time.Minute, outputs.ResampleLinear)
+	assert.Error(t, err)
+
+	// invalid grids
+	_, err = outputs.ResampleHistory(history, start, start.Add(time.Hour), 0, outputs.ResampleLinear)
+	assert.Error(t, err)
+	_, err = outputs.ResampleHistory(history, start, start.Add(-time.Hour), time.Minute, outputs.ResampleLinear)
+	assert.Error(t, err)
+	_, err = outputs.ResampleHistory(history, start, start.Add(time.Hour), time.Minute, "cubic")
+	assert.Error(t, err)
+	_, err = outputs.ResampleHistory(history, start, start.Add(24*time.Hour), time.Millisecond, outputs.ResampleLOCF)
+	assert.Error(t, err)
+	resampled, err = outputs.ResampleHistory(nil, start, start.Add(time.Hour), time.Minute, outputs.ResampleLinear)
+	assert.NoError(t, err)
+	assert.Empty(t, resampled)
+}
+
+func TestRateOfChange(t *testing.T) {
+	start := time.Date(2020, 6, 1, 10, 0, 0, 0, time.Local)
+	// energy counter in kWh
+	history := makeHistory(start, []int{0, 30, 60}, []string{"100", "101", "103"})
+	rates, err := outputs.RateOfChange(history, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, rates, 2)
+	assert.Equal(t, "4", rates[0].Value)
+	assert.Equal(t, history[0].Timestamp, rates[0].Timestamp)
+	assert.Equal(t, "2", rates[1].Value)
+
+	_, err = outputs.RateOfChange(makeHistory(start, []int{0, 1}, []string{"1", "on"}), time.Hour)
+	assert.Error(t, err)
+	_, err = outputs.RateOfChange(history, 0)
+	assert.Error(t, err)
+	assert.Equal(t, time.Hour, outputs.GetRateUnitDuration(outputs.RateUnitHour))
+	assert.Equal(t, time.Duration(0), outputs.GetRateUnitDuration(outputs.RateUnitOff))
+}
@@ -0,0 +1,35 @@
+// Package outputs with calibration of output values
+package outputs
+
+import (
+	"strconv"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// CalibrateValue applies the calibration gain and offset to a numeric value: value * gain + offset
+// The calculation is done with float64 precision so large values and decimal offsets are not rounded.
+// Returns an error if the value is not a number
+func CalibrateValue(value string, gain float32, offset float32) (string, error) {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value, lib.MakeErrorf("CalibrateValue: value '%s' is not a number", value)
+	}
+	calibrated := number*widenFloat32(gain) + widenFloat32(offset)
+	return strconv.FormatFloat(calibrated, 'f', -1, 64), nil
+}
+
+// widenFloat32 returns the float64 of the shortest decimal that represents a float32, eg 0.1 instead
+// of 0.10000000149011612
+func widenFloat32(value float32) float64 {
+	widened, _ := strconv.ParseFloat(strconv.FormatFloat(float64(value), 'f', -1, 32), 64)
+	return widened
+}
+
+// MakeCalibrationAttr returns the name of the node configuration attribute that holds a calibration
+// setting of an output: {outputType}/{instance}/{attr}
+// attr is the calibration setting, eg types.NodeAttrGain or types.NodeAttrOffset
+func MakeCalibrationAttr(outputType types.OutputType, instance string, attr types.NodeAttr) types.NodeAttr {
+	return types.NodeAttr(string(outputType) + "/" + instance + "/" + string(attr))
+}
@@ -0,0 +1,109 @@
+// Package outputs with groups of outputs that are published together as one $group message
+package outputs
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// RegisteredOutputGroups with the groups of outputs that are published together, eg all phases of a
+// power meter, so consumers receive coherent snapshots of their values
+type RegisteredOutputGroups struct {
+	groups      map[string][]string // output IDs by group ID
+	updateMutex *sync.Mutex         // mutex for async updating of groups
+}
+
+// GetGroup returns the output IDs of a group, or nil if the group doesn't exist
+func (regGroups *RegisteredOutputGroups) GetGroup(groupID string) []string {
+	regGroups.updateMutex.Lock()
+	defer regGroups.updateMutex.Unlock()
+	return regGroups.groups[groupID]
+}
+
+// GetGroupIDs returns the sorted IDs of the groups that contain one or more of the outputs
+func (regGroups *RegisteredOutputGroups) GetGroupIDs(outputIDs []string) []string {
+	regGroups.updateMutex.Lock()
+	defer regGroups.updateMutex.Unlock()
+	groupIDs := make([]string, 0)
+	for groupID, members := range regGroups.groups {
+		if containsAnyOutput(members, outputIDs) {
+			groupIDs = append(groupIDs, groupID)
+		}
+	}
+	sort.Strings(groupIDs)
+	return groupIDs
+}
+
+// RemoveGroup removes a group. Its outputs remain.
+func (regGroups *RegisteredOutputGroups) RemoveGroup(groupID string) {
+	regGroups.updateMutex.Lock()
+	defer regGroups.updateMutex.Unlock()
+	delete(regGroups.groups, groupID)
+}
+
+// SetGroup sets the outputs of a group, replacing the existing outputs of the group
+// An output can be a member of multiple groups.
+func (regGroups *RegisteredOutputGroups) SetGroup(groupID string, outputIDs []string) {
+	members := make([]string, len(outputIDs))
+	copy(members, outputIDs)
+	regGroups.updateMutex.Lock()
+	defer regGroups.updateMutex.Unlock()
+	regGroups.groups[groupID] = members
+}
+
+// containsAnyOutput returns true if one of the outputs is a member
+func containsAnyOutput(members []string, outputIDs []string) bool {
+	for _, member := range members {
+		for _, outputID := range outputIDs {
+			if member == outputID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MakeGroupAddress returns the address of the $group publication of an output group
+func MakeGroupAddress(domain string, publisherID string, groupID string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", domain, publisherID, groupID, types.MessageTypeGroup)
+}
+
+// PublishOutputGroup publishes a snapshot of the values of a group of outputs in one $group
+// message retained=true. All values are taken at the same time so they are coherent.
+//  groupOutputs are the outputs of the group in order of publication
+//  values holds the latest values by output ID, obtained with RegisteredOutputValues.GetOutputValues
+func PublishOutputGroup(address string, groupOutputs []*types.OutputDiscoveryMessage,
+	values map[string]types.OutputValue, messageSigner *messaging.MessageSigner) error {
+
+	logrus.Infof("PublishOutputGroup: %d outputs to %s", len(groupOutputs), address)
+	groupMessage := &types.OutputGroupMessage{
+		Address:   address,
+		Outputs:   make([]types.OutputGroupValue, 0, len(groupOutputs)),
+		Timestamp: time.Now().Format(types.TimeFormat),
+	}
+	for _, output := range groupOutputs {
+		value := values[output.OutputID]
+		groupMessage.Outputs = append(groupMessage.Outputs, types.OutputGroupValue{
+			Address:   output.Address,
+			Quality:   value.Quality,
+			Timestamp: value.Timestamp,
+			Unit:      output.Unit,
+			Value:     value.Value,
+		})
+	}
+	return messageSigner.PublishObject(address, true, groupMessage, nil)
+}
+
+// NewRegisteredOutputGroups creates a new collection of output groups
+func NewRegisteredOutputGroups() *RegisteredOutputGroups {
+	return &RegisteredOutputGroups{
+		groups:      make(map[string][]string),
+		updateMutex: &sync.Mutex{},
+	}
+}
@@ -0,0 +1,24 @@
+package outputs_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputGroups(t *testing.T) {
+	groups := outputs.NewRegisteredOutputGroups()
+	assert.Nil(t, groups.GetGroup("meter1"))
+
+	groups.SetGroup("meter1", []string{"node1.voltage.l1", "node1.voltage.l2", "node1.voltage.l3"})
+	groups.SetGroup("total", []string{"node1.voltage.l1", "node2.power.0"})
+	assert.Len(t, groups.GetGroup("meter1"), 3)
+	assert.Equal(t, []string{"meter1", "total"}, groups.GetGroupIDs([]string{"node1.voltage.l1"}))
+	assert.Equal(t, []string{"total"}, groups.GetGroupIDs([]string{"node2.power.0", "node3.power.0"}))
+	assert.Empty(t, groups.GetGroupIDs([]string{"node3.power.0"}))
+
+	groups.RemoveGroup("total")
+	assert.Equal(t, []string{"meter1"}, groups.GetGroupIDs([]string{"node1.voltage.l1", "node2.power.0"}))
+	assert.Equal(t, "test/pub1/meter1/$group", outputs.MakeGroupAddress("test", "pub1", "meter1"))
+}
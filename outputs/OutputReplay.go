@@ -0,0 +1,203 @@
+// Package outputs with replaying of missed output values at the request of consumers
+package outputs
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// ReplayBudgetWindow is the time window of the replay budget
+const ReplayBudgetWindow = time.Minute
+
+// OutputReplay republishes the output values that consumers missed, at their request with a signed
+// $replay message. The values are taken from the output history and published on the $replayResult
+// address of the requester. The nr of replayed values is limited by a budget of values per minute for
+// each requester to protect the publisher and message bus against excessive requests.
+type OutputReplay struct {
+	budget                 int                      // max nr of replayed values per requester per window, 0 is disabled
+	budgetStart            time.Time                // start of the current budget window
+	budgetUsed             map[string]int           // nr of values replayed in the current window by requester
+	domain                 string                   // the domain of this publisher
+	messageSigner          *messaging.MessageSigner // subscription to replay requests
+	publisherID            string                   // the publisher whose outputs are replayed
+	registeredOutputs      *RegisteredOutputs       // outputs that can be replayed
+	registeredOutputValues *RegisteredOutputValues  // history of the replayed values
+	updateMutex            *sync.Mutex              // mutex for async updating of the budget
+}
+
+// Replay returns the history values of an output that are requested, limited by the replay budget of
+// the requester. The result is not complete if the budget doesn't allow all requested values. The oldest values are
+// replayed first so the consumer can request the remainder with the sequence nr of the last replayed value.
+func (replay *OutputReplay) Replay(request *types.ReplayMessage) (*types.OutputReplayResultMessage, error) {
+	output := replay.registeredOutputs.GetOutputByAddress(MakeOutputReplayDiscoveryAddress(request.OutputAddress))
+	if output == nil {
+		return nil, lib.MakeErrorf("Replay: Output '%s' not found", request.OutputAddress)
+	}
+	since := time.Time{}
+	if request.SinceTime != "" {
+		var err error
+		since, err = time.Parse(types.TimeFormat, request.SinceTime)
+		if err != nil {
+			return nil, lib.MakeErrorf("Replay: Invalid sinceTime '%s': %s", request.SinceTime, err)
+		}
+	}
+	history := replay.registeredOutputValues.GetHistorySince(output.OutputID, request.SinceSequence, since)
+	allowed := replay.useBudget(request.Sender, len(history), time.Now())
+	result := &types.OutputReplayResultMessage{
+		Address:   ReplaceMessageType(output.Address, types.MessageTypeReplayResult),
+		Complete:  allowed == len(history),
+		Replay:    history[:allowed],
+		Requester: request.Sender,
+		Timestamp: time.Now().Format(types.TimeFormat),
+		Unit:      output.Unit,
+	}
+	if !result.Complete {
+		logrus.Warningf("OutputReplay.Replay: Replay budget of %s exceeded. Replaying %d of %d values of %s",
+			request.Sender, allowed, len(history), output.Address)
+	}
+	return result, nil
+}
+
+// SetBudget sets the max nr of values that are replayed per minute to each requester. Use 0 to disable replay.
+func (replay *OutputReplay) SetBudget(valuesPerMinute int) {
+	replay.updateMutex.Lock()
+	defer replay.updateMutex.Unlock()
+	replay.budget = valuesPerMinute
+}
+
+// Start listening for replay requests
+func (replay *OutputReplay) Start() {
+	addr := MakeReplayAddress(replay.domain, replay.publisherID)
+	replay.messageSigner.Subscribe(addr, replay.receiveReplay)
+}
+
+// Stop listening for replay requests
+func (replay *OutputReplay) Stop() {
+	addr := MakeReplayAddress(replay.domain, replay.publisherID)
+	replay.messageSigner.Unsubscribe(addr, replay.receiveReplay)
+}
+
+// receiveReplay handles a replay request and publishes the replayed values. The request must be signed
+// by the consumer unless signing is disabled. The signature is verified with the key of the Sender, so
+// the Sender that the budget and the result address are based on is the signer.
+func (replay *OutputReplay) receiveReplay(address string, message string) error {
+	var replayMessage types.ReplayMessage
+	_, isSigned, err := replay.messageSigner.DecodeMessage(message, &replayMessage)
+	if err != nil {
+		return lib.MakeErrorf("receiveReplay: Message to %s. Error %s. Message discarded.", address, err)
+	} else if !isSigned && !replay.messageSigner.IsUnsignedAllowed(address) {
+		return lib.MakeErrorf("receiveReplay: Replay request on address %s isn't signed. Message discarded.", address)
+	} else if replayMessage.Sender == "" {
+		// without sender the signature is verified with the key of the message address
+		return lib.MakeErrorf("receiveReplay: Replay request on address %s has no sender. Message discarded.", address)
+	}
+	resultAddr, err := MakeReplayResultAddress(replayMessage.Sender)
+	if err != nil {
+		return err
+	}
+	result, err := replay.Replay(&replayMessage)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("receiveReplay: Replaying %d values of %s to %s", len(result.Replay),
+		result.Address, resultAddr)
+	return replay.messageSigner.PublishObject(resultAddr, false, result, nil)
+}
+
+// useBudget takes up to count values from the replay budget of a requester in the current window and
+// returns the nr of values that can be replayed
+func (replay *OutputReplay) useBudget(requester string, count int, now time.Time) int {
+	replay.updateMutex.Lock()
+	defer replay.updateMutex.Unlock()
+	if now.Sub(replay.budgetStart) >= ReplayBudgetWindow {
+		replay.budgetStart = now
+		replay.budgetUsed = make(map[string]int)
+	}
+	remaining := replay.budget - replay.budgetUsed[requester]
+	if remaining < 0 {
+		remaining = 0
+	}
+	if count > remaining {
+		count = remaining
+	}
+	replay.budgetUsed[requester] += count
+	return count
+}
+
+// MakeOutputReplayDiscoveryAddress returns the discovery address of the output of a replay request
+// The output address can be given with or without a message type, eg domain/publisherId/nodeId/type/instance/$latest
+func MakeOutputReplayDiscoveryAddress(outputAddress string) string {
+	segments := strings.Split(outputAddress, "/")
+	if len(segments) == 5 {
+		segments = append(segments, types.MessageTypeOutputDiscovery)
+	} else if len(segments) == 6 {
+		segments[5] = types.MessageTypeOutputDiscovery
+	}
+	return strings.Join(segments, "/")
+}
+
+// MakeReplayResultAddress returns the address of the replayed values for a requester
+//  requester is the identity address of the consumer, eg domain/publisherId/$identity
+func MakeReplayResultAddress(requester string) (string, error) {
+	segments := strings.Split(requester, "/")
+	if len(segments) < 2 || segments[0] == "" || segments[1] == "" {
+		return "", fmt.Errorf("MakeReplayResultAddress: Invalid requester address '%s'", requester)
+	}
+	return fmt.Sprintf("%s/%s/%s", segments[0], segments[1], types.MessageTypeReplayResult), nil
+}
+
+// MakeReplayAddress returns the address to request the replay of output values of a publisher
+func MakeReplayAddress(domain string, publisherID string) string {
+	return fmt.Sprintf("%s/%s/%s", domain, publisherID, types.MessageTypeReplay)
+}
+
+// PublishReplayRequest requests a publisher to replay the values of an output, eg after detecting a gap in
+// the sequence nrs of its latest values. The request is signed and not retained. The replayed values are
+// published on the $replayResult address of the sender, see MakeReplayResultAddress.
+//  outputAddress is the address of the output, eg domain/publisherId/nodeId/type/instance/$latest
+//  sinceSequence replays the values with a higher sequence nr. Use 0 to ignore.
+//  since replays the values after this time. Use the zero time to ignore.
+//  sender is the identity address of the consumer
+func PublishReplayRequest(outputAddress string, sinceSequence uint64, since time.Time,
+	sender string, messageSigner *messaging.MessageSigner) error {
+
+	segments := strings.Split(outputAddress, "/")
+	if len(segments) < 5 {
+		return lib.MakeErrorf("PublishReplayRequest: Invalid output address '%s'", outputAddress)
+	}
+	addr := MakeReplayAddress(segments[0], segments[1])
+	logrus.Infof("PublishReplayRequest: Replay of %s since sequence %d to %s", outputAddress, sinceSequence, addr)
+	message := &types.ReplayMessage{
+		Address:       addr,
+		OutputAddress: outputAddress,
+		Sender:        sender,
+		SinceSequence: sinceSequence,
+		Timestamp:     time.Now().Format(types.TimeFormat),
+	}
+	if !since.IsZero() {
+		message.SinceTime = since.Format(types.TimeFormat)
+	}
+	return messageSigner.PublishObject(addr, false, message, nil)
+}
+
+// NewOutputReplay creates a new instance for replaying the output values of a publisher
+// Use SetBudget to enable replay and Start() to start listening for replay requests.
+func NewOutputReplay(domain string, publisherID string, registeredOutputs *RegisteredOutputs,
+	registeredOutputValues *RegisteredOutputValues, messageSigner *messaging.MessageSigner) *OutputReplay {
+	return &OutputReplay{
+		domain:                 domain,
+		messageSigner:          messageSigner,
+		publisherID:            publisherID,
+		registeredOutputs:      registeredOutputs,
+		registeredOutputValues: registeredOutputValues,
+		budgetUsed:             make(map[string]int),
+		updateMutex:            &sync.Mutex{},
+	}
+}
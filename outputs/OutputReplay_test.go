@@ -0,0 +1,117 @@
+package outputs_test
+
+import (
+	"crypto/ecdsa"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutputReplay(t *testing.T) {
+	const domain = "test"
+	const publisherID = "pub1"
+	messenger := messaging.NewDummyMessenger(&messaging.MessengerConfig{})
+	privKey := messaging.CreateAsymKeys()
+	getPubKey := func(address string) *ecdsa.PublicKey {
+		return &privKey.PublicKey
+	}
+	signer := messaging.NewMessageSigner(messenger, privKey, getPubKey)
+	regOutputs := outputs.NewRegisteredOutputs(domain, publisherID)
+	regValues := outputs.NewRegisteredOutputValues(domain, publisherID)
+	output := regOutputs.CreateOutput("node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	latestAddr := outputs.ReplaceMessageType(output.Address, types.MessageTypeLatest)
+
+	// publish 5 values with sequence nrs 1-5
+	for _, value := range []string{"1", "2", "3", "4", "5"} {
+		regValues.UpdateOutputValue(output.OutputID, value)
+		latest := regValues.GetOutputValueByID(output.OutputID)
+		sequence := outputs.PublishOutputLatest(output, latest, signer)
+		assert.True(t, regValues.SetSequence(output.OutputID, latest.Timestamp, sequence))
+	}
+	assert.Equal(t, uint64(5), regValues.GetOutputValueByID(output.OutputID).Sequence)
+
+	// the consumer receives the replayed values
+	consumer := outputs.NewDomainOutputValues(signer)
+	var result *types.OutputReplayResultMessage
+	consumer.SetOnReplay(func(replayed *types.OutputReplayResultMessage) {
+		result = replayed
+	})
+	consumer.Subscribe(domain, publisherID)
+	require.NoError(t, consumer.SubscribeReplay("test/consumer1/$identity"))
+	assert.Error(t, consumer.SubscribeReplay("consumer1"))
+	replay := outputs.NewOutputReplay(domain, publisherID, regOutputs, regValues, signer)
+	replay.SetBudget(4)
+	replay.Start()
+
+	// replay the values after sequence 2, oldest first
+	err := outputs.PublishReplayRequest(latestAddr, 2, time.Time{}, "test/consumer1/$identity", signer)
+	assert.NoError(t, err)
+	require.NotNil(t, result)
+	assert.True(t, result.Complete)
+	assert.Equal(t, "test/consumer1/$identity", result.Requester)
+	require.Len(t, result.Replay, 3)
+	assert.Equal(t, "3", result.Replay[0].Value)
+	assert.Equal(t, uint64(5), result.Replay[2].Sequence)
+
+	// the budget limits the replay to the oldest value
+	result = nil
+	err = outputs.PublishReplayRequest(latestAddr, 0, time.Time{}, "test/consumer1/$identity", signer)
+	assert.NoError(t, err)
+	require.NotNil(t, result)
+	assert.False(t, result.Complete)
+	require.Len(t, result.Replay, 1)
+	assert.Equal(t, "1", result.Replay[0].Value)
+
+	// the budget is per requester and the result is published to the requester
+	result = nil
+	err = outputs.PublishReplayRequest(latestAddr, 0, time.Time{}, "test/consumer2/$identity", signer)
+	assert.NoError(t, err)
+	assert.Nil(t, result)
+	assert.NotEmpty(t, messenger.FindLastPublication("test/consumer2/"+types.MessageTypeReplayResult))
+	replayed, err := replay.Replay(&types.ReplayMessage{OutputAddress: latestAddr, Sender: "test/consumer3/$identity"})
+	require.NoError(t, err)
+	assert.Len(t, replayed.Replay, 4)
+	assert.Equal(t, outputs.ReplaceMessageType(output.Address, types.MessageTypeReplayResult), replayed.Address)
+
+	// values without sequence nr that are newer than the requested sequence nr are included
+	replay.SetBudget(100)
+	time.Sleep(time.Millisecond)
+	regValues.UpdateOutputValue(output.OutputID, "6")
+	replayed, err = replay.Replay(&types.ReplayMessage{OutputAddress: latestAddr, SinceSequence: 5})
+	require.NoError(t, err)
+	require.Len(t, replayed.Replay, 1)
+	assert.Equal(t, "6", replayed.Replay[0].Value)
+	replayed, err = replay.Replay(&types.ReplayMessage{OutputAddress: latestAddr, SinceSequence: 4})
+	require.NoError(t, err)
+	assert.Len(t, replayed.Replay, 2)
+
+	// nothing is newer than now
+	replayed, err = replay.Replay(&types.ReplayMessage{
+		OutputAddress: output.Address,
+		SinceTime:     time.Now().Add(time.Second).Format(types.TimeFormat),
+	})
+	require.NoError(t, err)
+	assert.True(t, replayed.Complete)
+	assert.Empty(t, replayed.Replay)
+
+	// unsigned requests are rejected even if the sender declared the type unsigned
+	result = nil
+	signer.SetIsUnsignedAllowed(func(address string) bool { return true })
+	unsigned := `{"outputAddress": "` + latestAddr + `", "sender": "test/consumer1/$identity"}`
+	messenger.Publish(outputs.MakeReplayAddress(domain, publisherID), false, unsigned)
+	assert.Nil(t, result)
+
+	// unknown outputs and invalid times are rejected
+	_, err = replay.Replay(&types.ReplayMessage{OutputAddress: "test/pub1/node2/temperature/0"})
+	assert.Error(t, err)
+	_, err = replay.Replay(&types.ReplayMessage{OutputAddress: latestAddr, SinceTime: "yesterday"})
+	assert.Error(t, err)
+	replay.Stop()
+	consumer.Unsubscribe(domain, publisherID)
+	consumer.UnsubscribeReplay("test/consumer1/$identity")
+}
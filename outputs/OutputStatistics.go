@@ -0,0 +1,99 @@
+// Package outputs with running min, max and average statistics of numeric output values
+package outputs
+
+import (
+	"sync"
+	"time"
+)
+
+// Periods after which the statistics of an output are reset
+const (
+	StatisticsPeriodOff    = "off"    // statistics are disabled
+	StatisticsPeriodHourly = "hourly" // statistics are reset at the start of each hour
+	StatisticsPeriodDaily  = "daily"  // statistics are reset at midnight local time
+)
+
+// StatisticsPeriods are the configurable statistics periods
+var StatisticsPeriods = []string{StatisticsPeriodOff, StatisticsPeriodHourly, StatisticsPeriodDaily}
+
+// Instance suffixes of the companion outputs with the statistics of an output
+const (
+	StatisticsMinSuffix = "-min" // companion output with the minimum value of the period
+	StatisticsMaxSuffix = "-max" // companion output with the maximum value of the period
+	StatisticsAvgSuffix = "-avg" // companion output with the average value of the period
+)
+
+// OutputStatistics with the running statistics of an output in the current period
+type OutputStatistics struct {
+	Average     float64   // average of the values in the period
+	Count       int       // nr of values in the period
+	Max         float64   // maximum value in the period
+	Min         float64   // minimum value in the period
+	PeriodStart time.Time // start of the period
+	sum         float64   // sum of values in the period
+}
+
+// RegisteredStatistics with the running statistics of outputs by output ID
+type RegisteredStatistics struct {
+	statistics  map[string]*OutputStatistics // statistics by output ID
+	updateMutex *sync.Mutex                  // mutex for async updating of statistics
+}
+
+// GetStatistics returns a copy of the statistics of an output, or nil if it has no statistics
+func (regStats *RegisteredStatistics) GetStatistics(outputID string) *OutputStatistics {
+	regStats.updateMutex.Lock()
+	defer regStats.updateMutex.Unlock()
+	stats := regStats.statistics[outputID]
+	if stats == nil {
+		return nil
+	}
+	statsCopy := *stats
+	return &statsCopy
+}
+
+// UpdateStatistics adds a value to the statistics of an output. The statistics are reset when
+// the value is in a new period.
+//  period is StatisticsPeriodHourly or StatisticsPeriodDaily
+//  timestamp is the time of the value
+// This returns a copy of the updated statistics
+func (regStats *RegisteredStatistics) UpdateStatistics(
+	outputID string, value float64, period string, timestamp time.Time) OutputStatistics {
+
+	regStats.updateMutex.Lock()
+	defer regStats.updateMutex.Unlock()
+	periodStart := GetStatisticsPeriodStart(period, timestamp)
+	stats := regStats.statistics[outputID]
+	if stats == nil || !stats.PeriodStart.Equal(periodStart) {
+		stats = &OutputStatistics{Min: value, Max: value, PeriodStart: periodStart}
+		regStats.statistics[outputID] = stats
+	}
+	if value < stats.Min {
+		stats.Min = value
+	}
+	if value > stats.Max {
+		stats.Max = value
+	}
+	stats.sum += value
+	stats.Count++
+	stats.Average = stats.sum / float64(stats.Count)
+	return *stats
+}
+
+// GetStatisticsPeriodStart returns the start of the statistics period that contains the timestamp
+//  period is StatisticsPeriodHourly or StatisticsPeriodDaily. Any other period is daily.
+func GetStatisticsPeriodStart(period string, timestamp time.Time) time.Time {
+	year, month, day := timestamp.Date()
+	if period == StatisticsPeriodHourly {
+		return time.Date(year, month, day, timestamp.Hour(), 0, 0, 0, timestamp.Location())
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, timestamp.Location())
+}
+
+// NewRegisteredStatistics creates a collection of output statistics
+func NewRegisteredStatistics() *RegisteredStatistics {
+	regStats := &RegisteredStatistics{
+		statistics:  make(map[string]*OutputStatistics),
+		updateMutex: &sync.Mutex{},
+	}
+	return regStats
+}
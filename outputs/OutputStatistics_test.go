@@ -0,0 +1,41 @@
+package outputs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateStatistics(t *testing.T) {
+	const output1ID = "node1/temperature/0"
+	regStats := outputs.NewRegisteredStatistics()
+	assert.Nil(t, regStats.GetStatistics(output1ID))
+
+	startTime := time.Date(2020, 6, 1, 10, 15, 0, 0, time.Local)
+	regStats.UpdateStatistics(output1ID, 20, outputs.StatisticsPeriodDaily, startTime)
+	regStats.UpdateStatistics(output1ID, 10, outputs.StatisticsPeriodDaily, startTime.Add(time.Hour))
+	stats := regStats.UpdateStatistics(output1ID, 30, outputs.StatisticsPeriodDaily, startTime.Add(2*time.Hour))
+	assert.Equal(t, 3, stats.Count)
+	assert.Equal(t, 10.0, stats.Min)
+	assert.Equal(t, 30.0, stats.Max)
+	assert.Equal(t, 20.0, stats.Average)
+	require.NotNil(t, regStats.GetStatistics(output1ID))
+	assert.Equal(t, 3, regStats.GetStatistics(output1ID).Count)
+
+	// a value after midnight starts a new period
+	stats = regStats.UpdateStatistics(output1ID, 5, outputs.StatisticsPeriodDaily, startTime.Add(24*time.Hour))
+	assert.Equal(t, 1, stats.Count)
+	assert.Equal(t, 5.0, stats.Min)
+	assert.Equal(t, 5.0, stats.Average)
+}
+
+func TestGetStatisticsPeriodStart(t *testing.T) {
+	timestamp := time.Date(2020, 6, 1, 10, 15, 30, 0, time.Local)
+	hourStart := outputs.GetStatisticsPeriodStart(outputs.StatisticsPeriodHourly, timestamp)
+	assert.Equal(t, time.Date(2020, 6, 1, 10, 0, 0, 0, time.Local), hourStart)
+	dayStart := outputs.GetStatisticsPeriodStart(outputs.StatisticsPeriodDaily, timestamp)
+	assert.Equal(t, time.Date(2020, 6, 1, 0, 0, 0, 0, time.Local), dayStart)
+}
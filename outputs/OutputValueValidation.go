@@ -0,0 +1,95 @@
+// Package outputs with validation of output values against the declared data type of the output
+package outputs
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// ValidateOutputValue checks that a value matches the declared data type of the output, so consumers
+// can parse it. Enum outputs require one of their enum values and composite data types such as
+// colors and locations require their canonical json representation. Outputs without data type,
+// or with a string, bytes, date or secret data type accept any value.
+// Returns an error if the value doesn't match the data type. The error isn't logged, that is up to the caller.
+func ValidateOutputValue(output *types.OutputDiscoveryMessage, value string) error {
+	if output == nil {
+		return nil
+	}
+	var err error
+	switch output.DataType {
+	case types.DataTypeBool:
+		_, err = parseBoolValue(value)
+	case types.DataTypeInt:
+		_, err = strconv.Atoi(value)
+	case types.DataTypeNumber:
+		_, err = strconv.ParseFloat(value, 64)
+	case types.DataTypeJSON:
+		if !json.Valid([]byte(value)) {
+			err = fmt.Errorf("invalid json")
+		}
+	case types.DataTypeEnum:
+		if len(output.EnumValues) > 0 && !output.EnumValues.Contains(value) {
+			return fmt.Errorf("ValidateOutputValue: Value '%s' of output %s is not one of %v",
+				value, output.Address, output.EnumValues)
+		}
+	case types.DataTypeColorHSV, types.DataTypeColorRGB, types.DataTypeLocation, types.DataTypeVector:
+		err = validateCompositeValue(output.DataType, value)
+	}
+	if err != nil {
+		return fmt.Errorf("ValidateOutputValue: Value '%s' of output %s is not a %s",
+			value, output.Address, output.DataType)
+	}
+	return nil
+}
+
+// CoerceOutputValue converts a value to the representation of the declared data type of the output.
+// Numbers are trimmed and rounded for int outputs, booleans become true or false, enum values are
+// matched case insensitive and invalid json is encoded as a json string. Values that already match the data type are returned as is.
+// Returns the original value and an error if the value can't be converted.
+func CoerceOutputValue(output *types.OutputDiscoveryMessage, value string) (string, error) {
+	if ValidateOutputValue(output, value) == nil {
+		return value, nil
+	}
+	trimmed := strings.TrimSpace(value)
+	switch output.DataType {
+	case types.DataTypeBool:
+		boolValue, err := parseBoolValue(trimmed)
+		if err == nil {
+			return strconv.FormatBool(boolValue), nil
+		}
+	case types.DataTypeInt, types.DataTypeNumber:
+		number, err := strconv.ParseFloat(trimmed, 64)
+		if err == nil && output.DataType == types.DataTypeInt {
+			return strconv.FormatInt(int64(math.Round(number)), 10), nil
+		} else if err == nil {
+			return strconv.FormatFloat(number, 'f', -1, 64), nil
+		}
+	case types.DataTypeJSON:
+		jsonValue, _ := json.Marshal(value)
+		return string(jsonValue), nil
+	case types.DataTypeEnum:
+		for _, enumValue := range output.EnumValues {
+			if strings.EqualFold(enumValue, trimmed) {
+				return enumValue, nil
+			}
+		}
+	}
+	return value, fmt.Errorf("CoerceOutputValue: Value '%s' of output %s can't be converted to %s",
+		value, output.Address, output.DataType)
+}
+
+// parseBoolValue parses a boolean value: true/false, 1/0, on/off
+func parseBoolValue(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
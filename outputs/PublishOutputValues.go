@@ -35,11 +35,12 @@ func PublishOutputHistory(
 
 // PublishOutputLatest publishes the $latest output value
 // not thread-safe, using within a locked section
+// This returns the sequence nr of the publication.
 func PublishOutputLatest(
 	output *types.OutputDiscoveryMessage,
 	latest *types.OutputValue,
 	messageSigner *messaging.MessageSigner,
-) {
+) uint64 {
 	// output values are published using their alias address, if any
 	addr := ReplaceMessageType(output.Address, types.MessageTypeLatest)
 	logrus.Infof("PublishOutputLatest to: %s", addr)
@@ -48,11 +49,13 @@ func PublishOutputLatest(
 	// zone/publisher/node/iotype/instance/$latest
 	latestMessage := &types.OutputLatestMessage{
 		Address:   addr,
+		Quality:   latest.Quality,
 		Timestamp: latest.Timestamp,
 		Unit:      output.Unit,
 		Value:     latest.Value,
 	}
 	messageSigner.PublishObject(addr, true, latestMessage, nil)
+	return latestMessage.Sequence
 }
 
 // PublishOutputRaw publishes the raw output $raw (retained)
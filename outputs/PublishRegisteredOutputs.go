@@ -8,6 +8,7 @@ import (
 )
 
 // PublishRegisteredOutputs publishes output discovery messages
+// Outputs with a non-standard type or unit are published with a warning.
 func PublishRegisteredOutputs(
 	outputs []*types.OutputDiscoveryMessage,
 	messageSigner *messaging.MessageSigner) {
@@ -15,6 +16,7 @@ func PublishRegisteredOutputs(
 	// publish updated output discovery
 	for _, output := range outputs {
 		logrus.Infof("PublishRegisteredOutputs: publish output discovery for: %s", output.Address)
+		_ = ValidateOutput(output)
 		messageSigner.PublishObject(output.Address, true, output, nil)
 	}
 	// todo: move save output configuration
@@ -0,0 +1,76 @@
+// Package outputs with detached signatures of raw output values for consumers that can't parse JWS
+package outputs
+
+import (
+	"crypto"
+	"encoding/json"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// MakeRawSignatureAddress returns the $rawsig address with the detached signature of a $raw address
+func MakeRawSignatureAddress(rawAddress string) string {
+	return ReplaceMessageType(rawAddress, types.MessageTypeRawSignature)
+}
+
+// MakeRawSignaturePayload returns the payload that is signed by the detached signature of a raw value
+// The address and timestamp are included so a signature can't be moved to another output or replayed.
+func MakeRawSignaturePayload(rawAddress string, timestamp string, value string) string {
+	return rawAddress + "|" + timestamp + "|" + value
+}
+
+// PublishOutputRawSignature publishes the detached signature of a raw output value on $rawsig (retained)
+// The raw value itself is published unchanged with PublishOutputRaw.
+func PublishOutputRawSignature(output *types.OutputDiscoveryMessage, value string,
+	messageSigner *messaging.MessageSigner) error {
+
+	rawAddr := ReplaceMessageType(output.Address, types.MessageTypeRaw)
+	timestamp := time.Now().Format(types.TimeFormat)
+	signature, err := messageSigner.CreateDetachedSignature(MakeRawSignaturePayload(rawAddr, timestamp, value))
+	if err != nil {
+		return lib.MakeErrorf("PublishOutputRawSignature: Unable to sign value of %s: %s", rawAddr, err)
+	}
+	addr := MakeRawSignatureAddress(rawAddr)
+	logrus.Infof("PublishOutputRawSignature: signature of output value to: %s", addr)
+	sigMessage := &types.OutputRawSignatureMessage{
+		Address:   rawAddr,
+		Signature: signature,
+		Timestamp: timestamp,
+	}
+	return messageSigner.PublishObject(addr, true, sigMessage, nil)
+}
+
+// VerifyRawSignature verifies a raw output value with its detached signature. Intended for consumers
+// that subscribe to $raw and $rawsig of an output.
+//  rawAddress is the $raw address the value was received on
+//  value is the received raw value
+//  rawSigMessage is the message received on the $rawsig address, signed or unsigned
+//  getPublicKey provides the public key of the publisher of an address, eg an *ecdsa.PublicKey or ed25519.PublicKey
+// This returns an error if the signature is missing, doesn't belong to the address or doesn't match the value.
+// The signature timestamp is signed as well; consumers that need to detect replays should check it is recent.
+func VerifyRawSignature(rawAddress string, value string, rawSigMessage string,
+	getPublicKey func(address string) crypto.PublicKey) error {
+
+	var sigMessage types.OutputRawSignatureMessage
+	payload, _ := messaging.JWSPayload(rawSigMessage)
+	err := json.Unmarshal([]byte(payload), &sigMessage)
+	if err != nil {
+		return lib.MakeErrorf("VerifyRawSignature: Invalid signature message for %s: %s", rawAddress, err)
+	} else if sigMessage.Address != rawAddress {
+		return lib.MakeErrorf("VerifyRawSignature: Signature is for %s instead of %s", sigMessage.Address, rawAddress)
+	}
+	publicKey := getPublicKey(rawAddress)
+	if publicKey == nil {
+		return lib.MakeErrorf("VerifyRawSignature: Unknown publisher of %s", rawAddress)
+	}
+	payload = MakeRawSignaturePayload(rawAddress, sigMessage.Timestamp, value)
+	err = messaging.VerifyDetachedJWSSignature(payload, sigMessage.Signature, publicKey)
+	if err != nil {
+		return lib.MakeErrorf("VerifyRawSignature: Signature of %s doesn't match the value: %s", rawAddress, err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+// Package outputs with counter outputs that accumulate meter readings with rollover
+package outputs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/sirupsen/logrus"
+)
+
+// CounterState with the accumulated state of a counter output
+type CounterState struct {
+	HasReading bool          `json:"hasReading"` // a raw reading has been received
+	LastRaw    float64       `json:"lastRaw"`    // last raw counter reading
+	LastTime   time.Time     `json:"lastTime"`   // time of the last raw counter reading
+	RatePeriod time.Duration `json:"ratePeriod"` // period of the rate, eg time.Hour. Default (0) is per second
+	Rollover   float64       `json:"rollover"`   // raw value at which the meter wraps to 0. 0 if it doesn't wrap
+	Total      float64       `json:"total"`      // accumulated total since the counter was created
+}
+
+// RegisteredCounters with the state of counter outputs by output ID
+// A counter output accumulates raw meter readings into a total that survives meter rollover,
+// meter replacement and restarts of the publisher, and determines the delta and rate of each reading.
+type RegisteredCounters struct {
+	counters    map[string]*CounterState // counter state by output ID
+	updateCount int                      // nr of updates since last save
+	updateMutex *sync.Mutex              // mutex for async updating of counters
+}
+
+// CreateCounter creates a counter for an output. If the counter already exists, for example
+// because it was loaded, its accumulated state is retained.
+//  rollover is the raw value at which the meter wraps to 0. Use 0 if it doesn't wrap.
+//  ratePeriod is the period the rate is expressed in, eg time.Hour. Use 0 for per second.
+func (regCounters *RegisteredCounters) CreateCounter(outputID string, rollover float64, ratePeriod time.Duration) {
+	regCounters.updateMutex.Lock()
+	defer regCounters.updateMutex.Unlock()
+	counter := regCounters.counters[outputID]
+	if counter == nil {
+		counter = &CounterState{}
+		regCounters.counters[outputID] = counter
+	}
+	counter.Rollover = rollover
+	counter.RatePeriod = ratePeriod
+}
+
+// GetCounter returns a copy of the state of a counter, or nil if the counter doesn't exist
+func (regCounters *RegisteredCounters) GetCounter(outputID string) *CounterState {
+	regCounters.updateMutex.Lock()
+	defer regCounters.updateMutex.Unlock()
+	counter := regCounters.counters[outputID]
+	if counter == nil {
+		return nil
+	}
+	counterCopy := *counter
+	return &counterCopy
+}
+
+// LoadCounters loads previously saved counter states
+// Intended to retain the accumulated totals after a restart. Counters that have received a reading
+// since startup take precedence over loaded counters.
+func (regCounters *RegisteredCounters) LoadCounters(filename string) error {
+	counters := make(map[string]*CounterState)
+
+	jsonCounters, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return lib.MakeErrorf("LoadCounters: Unable to open file %s: %s", filename, err)
+	}
+	err = json.Unmarshal(jsonCounters, &counters)
+	if err != nil {
+		return lib.MakeErrorf("LoadCounters: Error parsing JSON counters file %s: %v", filename, err)
+	}
+	logrus.Infof("LoadCounters: Counters loaded successfully from %s", filename)
+	regCounters.updateMutex.Lock()
+	defer regCounters.updateMutex.Unlock()
+	for outputID, loaded := range counters {
+		counter := regCounters.counters[outputID]
+		if counter == nil {
+			regCounters.counters[outputID] = loaded
+		} else if !counter.HasReading {
+			// the counter settings are those of the created counter
+			loaded.Rollover = counter.Rollover
+			loaded.RatePeriod = counter.RatePeriod
+			*counter = *loaded
+		}
+	}
+	return nil
+}
+
+// SaveCounters saves the counter states to a JSON file and resets the update count
+func (regCounters *RegisteredCounters) SaveCounters(filename string) error {
+	regCounters.updateMutex.Lock()
+	jsonText, err := json.MarshalIndent(regCounters.counters, "", "  ")
+	regCounters.updateCount = 0
+	regCounters.updateMutex.Unlock()
+	if err != nil {
+		return lib.MakeErrorf("SaveCounters: Error Marshalling JSON counters '%s': %v", filename, err)
+	}
+	err = ioutil.WriteFile(filename, jsonText, 0664)
+	if err != nil {
+		return lib.MakeErrorf("SaveCounters: Error saving counters to JSON file %s: %v", filename, err)
+	}
+	logrus.Infof("SaveCounters: Counters saved successfully to JSON file %s", filename)
+	return nil
+}
+
+// UpdateCount returns the nr of counter updates since the counters were last saved
+func (regCounters *RegisteredCounters) UpdateCount() int {
+	regCounters.updateMutex.Lock()
+	defer regCounters.updateMutex.Unlock()
+	return regCounters.updateCount
+}
+
+// UpdateCounter adds a raw meter reading to a counter and returns the new total, and the
+// delta and rate since the previous reading. The first reading of a counter establishes the
+// baseline with a delta of 0. A reading that is lower than the previous reading is a rollover if
+// the counter has a rollover value, otherwise the meter is assumed to be reset to 0.
+//  timestamp is the time of the reading
+// This returns hasRate false if the rate can't be determined, eg on the first reading.
+// Returns an error if the counter doesn't exist or the reading exceeds the rollover value.
+func (regCounters *RegisteredCounters) UpdateCounter(outputID string, raw float64, timestamp time.Time) (
+	total float64, delta float64, rate float64, hasRate bool, err error) {
+
+	regCounters.updateMutex.Lock()
+	defer regCounters.updateMutex.Unlock()
+	counter := regCounters.counters[outputID]
+	if counter == nil {
+		return 0, 0, 0, false, lib.MakeErrorf("UpdateCounter: Unknown counter %s", outputID)
+	} else if counter.Rollover > 0 && raw > counter.Rollover {
+		return counter.Total, 0, 0, false, lib.MakeErrorf(
+			"UpdateCounter: Reading %v of counter %s exceeds its rollover value %v", raw, outputID, counter.Rollover)
+	}
+	if counter.HasReading {
+		if raw >= counter.LastRaw {
+			delta = raw - counter.LastRaw
+		} else if counter.Rollover > 0 {
+			delta = counter.Rollover - counter.LastRaw + raw
+		} else {
+			logrus.Warningf("UpdateCounter: Reading %v of counter %s is lower than %v. Assuming a meter reset.",
+				raw, outputID, counter.LastRaw)
+			delta = raw
+		}
+		elapsed := timestamp.Sub(counter.LastTime)
+		if elapsed > 0 {
+			period := counter.RatePeriod
+			if period <= 0 {
+				period = time.Second
+			}
+			rate = delta * float64(period) / float64(elapsed)
+			hasRate = true
+		}
+	}
+	counter.Total += delta
+	counter.LastRaw = raw
+	counter.LastTime = timestamp
+	counter.HasReading = true
+	regCounters.updateCount++
+	return counter.Total, delta, rate, hasRate, nil
+}
+
+// NewRegisteredCounters creates a collection of counters
+func NewRegisteredCounters() *RegisteredCounters {
+	regCounters := &RegisteredCounters{
+		counters:    make(map[string]*CounterState),
+		updateMutex: &sync.Mutex{},
+	}
+	return regCounters
+}
@@ -0,0 +1,80 @@
+package outputs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounterRollover(t *testing.T) {
+	const outputID = "meter1.energy.0"
+	start := time.Now()
+	regCounters := outputs.NewRegisteredCounters()
+	_, _, _, _, err := regCounters.UpdateCounter(outputID, 10, start)
+	assert.Error(t, err, "Unknown counter")
+
+	regCounters.CreateCounter(outputID, 1000, time.Hour)
+	// the first reading is the baseline
+	total, delta, _, hasRate, err := regCounters.UpdateCounter(outputID, 990, start)
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, total)
+	assert.Equal(t, 0.0, delta)
+	assert.False(t, hasRate)
+
+	// rollover from 990 to 5 is a delta of 15
+	total, delta, rate, hasRate, err := regCounters.UpdateCounter(outputID, 5, start.Add(30*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 15.0, delta)
+	assert.Equal(t, 15.0, total)
+	assert.True(t, hasRate)
+	assert.InDelta(t, 30.0, rate, 0.001)
+
+	_, _, _, _, err = regCounters.UpdateCounter(outputID, 1001, start.Add(time.Hour))
+	assert.Error(t, err, "Reading exceeds the rollover")
+	assert.Equal(t, 2, regCounters.UpdateCount())
+
+	// without rollover a lower reading is a meter reset
+	regCounters.CreateCounter(outputID, 0, 0)
+	total, delta, _, _, err = regCounters.UpdateCounter(outputID, 3, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, delta)
+	assert.Equal(t, 18.0, total)
+}
+
+func TestSaveLoadCounters(t *testing.T) {
+	const outputID = "meter1.energy.0"
+	tempDir, err := ioutil.TempDir("", "counters")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	filename := filepath.Join(tempDir, "counters.json")
+
+	regCounters := outputs.NewRegisteredCounters()
+	regCounters.CreateCounter(outputID, 0, 0)
+	regCounters.UpdateCounter(outputID, 100, time.Now())
+	regCounters.UpdateCounter(outputID, 150, time.Now())
+	err = regCounters.SaveCounters(filename)
+	require.NoError(t, err)
+	assert.Equal(t, 0, regCounters.UpdateCount())
+
+	// after a restart the total continues from the saved state
+	regCounters2 := outputs.NewRegisteredCounters()
+	regCounters2.CreateCounter(outputID, 0, time.Hour)
+	err = regCounters2.LoadCounters(filename)
+	require.NoError(t, err)
+	counter := regCounters2.GetCounter(outputID)
+	require.NotNil(t, counter)
+	assert.Equal(t, time.Hour, counter.RatePeriod)
+	total, delta, _, _, err := regCounters2.UpdateCounter(outputID, 160, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, delta)
+	assert.Equal(t, 60.0, total)
+
+	err = regCounters2.LoadCounters(filepath.Join(tempDir, "doesnotexist.json"))
+	assert.Error(t, err)
+}
@@ -16,6 +16,8 @@ type RegisteredForecastValues struct {
 	domain           string // domain this forcast list belongs to
 	publisherID      string // publisher of the forcasts
 	forecastMap      map[string]OutputForecast
+	pastForecasts    map[string]*PastForecast // retained forecast values and errors for the forecast accuracy
+	pastUpdateCount  int                      // nr of past forecast updates since they were last saved
 	updateMutex      *sync.Mutex
 	updatedForecasts map[string]string // map of output IDs with updated forecasts
 }
@@ -50,6 +52,7 @@ func (regForecasts *RegisteredForecastValues) GetUpdatedForecasts(clearUpdates b
 }
 
 // UpdateForecast updates the output forecast list of values
+// The numeric values are retained as past forecast until they are realized. See EvaluateForecast.
 func (regForecasts *RegisteredForecastValues) UpdateForecast(
 	outputID string, forecast OutputForecast) {
 
@@ -57,6 +60,7 @@ func (regForecasts *RegisteredForecastValues) UpdateForecast(
 	defer regForecasts.updateMutex.Unlock()
 
 	regForecasts.forecastMap[outputID] = forecast
+	regForecasts.addPastForecast(outputID, forecast)
 
 	if regForecasts.updatedForecasts == nil {
 		regForecasts.updatedForecasts = make(map[string]string)
@@ -69,10 +73,11 @@ func (regForecasts *RegisteredForecastValues) UpdateForecast(
 // NewRegisteredForecastValues creates a new instance for storing output forecasts
 func NewRegisteredForecastValues(domain string, publisherID string) *RegisteredForecastValues {
 	rfv := RegisteredForecastValues{
-		domain:      domain,
-		publisherID: publisherID,
-		forecastMap: make(map[string]OutputForecast),
-		updateMutex: &sync.Mutex{},
+		domain:        domain,
+		publisherID:   publisherID,
+		forecastMap:   make(map[string]OutputForecast),
+		pastForecasts: make(map[string]*PastForecast),
+		updateMutex:   &sync.Mutex{},
 	}
 	return &rfv
 }
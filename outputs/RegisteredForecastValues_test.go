@@ -2,12 +2,17 @@ package outputs_test
 
 import (
 	"crypto/ecdsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/iotdomain/iotdomain-go/messaging"
 	"github.com/iotdomain/iotdomain-go/outputs"
 	"github.com/iotdomain/iotdomain-go/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCreateForecastValues(t *testing.T) {
@@ -69,3 +74,63 @@ func TestPublishForecast(t *testing.T) {
 
 	// TODO: verify the forecast was published
 }
+
+func TestForecastAccuracy(t *testing.T) {
+	const output1ID = "node1:temperature:0"
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	collection := outputs.NewRegisteredForecastValues("test", "publisher1")
+
+	// forecast 12 and 13 at minute 10 and 30, and a non-numeric value that is ignored
+	forecast := outputs.OutputForecast(makeHistory(start, []int{10, 20, 30}, []string{"12", "none", "13"}))
+	collection.UpdateForecast(output1ID, forecast)
+	past := collection.GetPastForecast(output1ID)
+	require.NotNil(t, past)
+	assert.Equal(t, 2, len(past.Pending))
+
+	// an overlapping forecast replaces the pending value at minute 30 with 14
+	collection.UpdateForecast(output1ID, outputs.OutputForecast(makeHistory(start, []int{30}, []string{"14"})))
+	past = collection.GetPastForecast(output1ID)
+	assert.Equal(t, 2, len(past.Pending))
+	assert.Nil(t, collection.GetForecastAccuracy(output1ID))
+
+	// realized values only cover the first forecast value, interpolated to 10 at minute 10
+	history := makeHistory(start, []int{0, 20}, []string{"5", "15"})
+	accuracy := collection.EvaluateForecast(output1ID, history, time.Now())
+	require.NotNil(t, accuracy)
+	assert.Equal(t, 1, accuracy.Count)
+	assert.InDelta(t, 2.0, accuracy.MAE, 0.001)
+	assert.InDelta(t, 2.0, accuracy.Bias, 0.001)
+	assert.Nil(t, collection.EvaluateForecast(output1ID, history, time.Now()), "Expect no newly realized values")
+
+	// the second forecast value is too low by 2
+	history = makeHistory(start, []int{0, 20, 40}, []string{"5", "15", "17"})
+	accuracy = collection.EvaluateForecast(output1ID, history, time.Now())
+	require.NotNil(t, accuracy)
+	assert.Equal(t, 2, accuracy.Count)
+	assert.InDelta(t, 2.0, accuracy.MAE, 0.001)
+	assert.InDelta(t, 0.0, accuracy.Bias, 0.001)
+	assert.Equal(t, 0, len(collection.GetPastForecast(output1ID).Pending))
+
+	// errors age out of the accuracy window
+	collection.UpdateForecast(output1ID, outputs.OutputForecast(makeHistory(start, []int{50}, []string{"17"})))
+	history = makeHistory(start, []int{0, 20, 40, 60}, []string{"5", "15", "17", "17"})
+	accuracy = collection.EvaluateForecast(output1ID, history, start.Add(50*time.Minute+outputs.ForecastAccuracyWindow))
+	require.NotNil(t, accuracy)
+	assert.Equal(t, 1, accuracy.Count)
+	assert.InDelta(t, 0.0, accuracy.MAE, 0.001)
+
+	// save and reload the past forecasts
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	filename := filepath.Join(tempFolder, "forecasts.json")
+	assert.Equal(t, 6, collection.PastForecastUpdateCount())
+	err := collection.SavePastForecasts(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, collection.PastForecastUpdateCount())
+	collection2 := outputs.NewRegisteredForecastValues("test", "publisher1")
+	err = collection2.LoadPastForecasts(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, accuracy, collection2.GetForecastAccuracy(output1ID))
+	err = collection2.LoadPastForecasts(filepath.Join(tempFolder, "notafile.json"))
+	assert.Error(t, err)
+}
@@ -6,18 +6,25 @@ import (
 	"sync"
 	"time"
 
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/types"
 )
 
 // OutputHistory with history values
 type OutputHistory []types.OutputValue
 
+// historyValueOverhead is the estimated memory in bytes of a history value, excluding its value text
+const historyValueOverhead = 128
+
 // RegisteredOutputValues with values for all registered outputs, stored in the history map.
 type RegisteredOutputValues struct {
 	domain         string                   // the domain of this publisher
 	publisherID    string                   // the registered publisher for the inputs
 	historyMap     map[string]OutputHistory // history lists by output ID
+	maxHistory     int                      // max estimated memory in bytes of the history of all outputs. 0 is unlimited
 	updateMutex    *sync.Mutex              // mutex for async updating of outputs
+	updateNotifier *lib.UpdateNotifier      // notify subscribers of updated output values
+	updateTracker  *lib.UpdateTracker       // track updates for consumers with a cursor
 	updatedOutputs map[string]string        // IDs of updated outputs
 }
 
@@ -30,6 +37,54 @@ func (outputValues *RegisteredOutputValues) GetHistory(outputID string) OutputHi
 	return historyList
 }
 
+// GetHistoryMemory returns the estimated memory in bytes used by the history of all outputs and the
+// configured max. A max of 0 is unlimited.
+func (outputValues *RegisteredOutputValues) GetHistoryMemory() (historyMemory int, maxHistoryMemory int) {
+	outputValues.updateMutex.Lock()
+	defer outputValues.updateMutex.Unlock()
+	for _, history := range outputValues.historyMap {
+		if len(history) > 0 {
+			historyMemory += len(history) * historyValueSize(&history[0])
+		}
+	}
+	return historyMemory, outputValues.maxHistory
+}
+
+// GetHistorySince returns a copy of the history values of an output that are newer than a sequence nr or
+// time, oldest first. Intended for replaying missed values to a consumer.
+//  sinceSequence returns the values published on $latest with a higher sequence nr. Use 0 to ignore.
+//   Values without sequence nr, eg repeated readings, are included if they are newer than the newest
+//   value with a sequence nr up to sinceSequence.
+//  since returns the values after this time, with a resolution of seconds. Use the zero time to ignore.
+func (outputValues *RegisteredOutputValues) GetHistorySince(
+	outputID string, sinceSequence uint64, since time.Time) OutputHistory {
+
+	outputValues.updateMutex.Lock()
+	defer outputValues.updateMutex.Unlock()
+	history := outputValues.historyMap[outputID]
+	result := make(OutputHistory, 0)
+	// the history is newest first so values without sequence nr are newer if their index is lower
+	sinceSequenceIndex := len(history)
+	for index, value := range history {
+		if sinceSequence > 0 && value.Sequence > 0 && value.Sequence <= sinceSequence {
+			sinceSequenceIndex = index
+			break
+		}
+	}
+	for index := len(history) - 1; index >= 0; index-- {
+		value := history[index]
+		if sinceSequence > 0 && value.Sequence > 0 && value.Sequence <= sinceSequence {
+			continue
+		} else if sinceSequence > 0 && value.Sequence == 0 && index >= sinceSequenceIndex {
+			continue
+		} else if !since.IsZero() && value.EpochTime <= since.Unix() {
+			continue
+		}
+		result = append(result, value)
+	}
+	return result
+}
+
 // GetOutputValueByID returns the most recent output value by output ID
 // This returns a HistoryValue object with the latest value and timestamp it was updated
 func (outputValues *RegisteredOutputValues) GetOutputValueByID(outputID string) *types.OutputValue {
@@ -54,6 +109,22 @@ func (outputValues *RegisteredOutputValues) GetOutputValueByType(
 	return outputValues.GetOutputValueByID(outputID)
 }
 
+// GetOutputValues returns a copy of the latest values of outputs at the same point in time, by output ID
+// Outputs without a value are not included.
+func (outputValues *RegisteredOutputValues) GetOutputValues(outputIDs []string) map[string]types.OutputValue {
+	outputValues.updateMutex.Lock()
+	defer outputValues.updateMutex.Unlock()
+
+	values := make(map[string]types.OutputValue, len(outputIDs))
+	for _, outputID := range outputIDs {
+		history := outputValues.historyMap[outputID]
+		if len(history) > 0 {
+			values[outputID] = history[0]
+		}
+	}
+	return values
+}
+
 // GetUpdatedOutputValues returns a list of output IDs that have updated values
 //  clearUpdates clears the list upon return
 func (outputValues *RegisteredOutputValues) GetUpdatedOutputValues(clearUpdates bool) []string {
@@ -73,6 +144,83 @@ func (outputValues *RegisteredOutputValues) GetUpdatedOutputValues(clearUpdates
 	return idList
 }
 
+// GetUpdatedOutputValuesSince returns the IDs of outputs whose values are updated after the given
+// cursor without clearing the updates.
+//  cursor is the cursor returned by the previous call. Use 0 to get all outputs updated since creation.
+// This returns the output IDs in order of update and the cursor to use for the next call.
+func (outputValues *RegisteredOutputValues) GetUpdatedOutputValuesSince(cursor uint64) (
+	idList []string, newCursor uint64) {
+
+	return outputValues.updateTracker.GetUpdatedSince(cursor)
+}
+
+// OnUpdated subscribes a handler to updates of output values. The handler is invoked asynchronously
+// with the ID of each output whose value is updated, in order of update.
+func (outputValues *RegisteredOutputValues) OnUpdated(handler func(outputID string)) {
+	outputValues.updateNotifier.Subscribe(handler)
+}
+
+// SetMaxHistoryMemory sets the max estimated memory used by the history of all outputs. Each output
+// gets an equal share of the memory and its oldest history values are removed when it exceeds its share.
+// The latest value of an output is always retained.
+//  maxHistoryMemory is the max memory in bytes. Use 0 for unlimited.
+func (outputValues *RegisteredOutputValues) SetMaxHistoryMemory(maxHistoryMemory int) {
+	outputValues.updateMutex.Lock()
+	defer outputValues.updateMutex.Unlock()
+	outputValues.maxHistory = maxHistoryMemory
+}
+
+// SetSequence records the sequence nr of the $latest publication of an output value in its history
+// This returns false if the history has no value with the timestamp.
+//  timestamp of the published value
+//  sequence nr of the publication
+func (outputValues *RegisteredOutputValues) SetSequence(outputID string, timestamp string, sequence uint64) bool {
+	outputValues.updateMutex.Lock()
+	defer outputValues.updateMutex.Unlock()
+	history := outputValues.historyMap[outputID]
+	for index := range history {
+		if history[index].Timestamp == timestamp {
+			history[index].Sequence = sequence
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshot returns a copy of the latest value of all outputs at this point in time, by output ID.
+// Intended for rendering a dashboard or computing aggregates without racing the updates. The values
+// are copies so the snapshot is not affected by later updates.
+func (outputValues *RegisteredOutputValues) Snapshot() map[string]types.OutputValue {
+	outputValues.updateMutex.Lock()
+	defer outputValues.updateMutex.Unlock()
+
+	snapshot := make(map[string]types.OutputValue, len(outputValues.historyMap))
+	for outputID, history := range outputValues.historyMap {
+		if len(history) > 0 {
+			snapshot[outputID] = history[0]
+		}
+	}
+	return snapshot
+}
+
+// UpdateCalibratedOutputValue calibrates a raw numeric output value and adds it to the front of the history.
+// The calibrated value is rawValue * gain + offset. The raw value is retained in the output value's RawValue.
+// Values that are not numeric are added without calibration.
+// returns true if history is updated, false if history has not been updated
+func (outputValues *RegisteredOutputValues) UpdateCalibratedOutputValue(
+	outputID string, rawValue string, gain float32, offset float32, quality types.ValueQuality) bool {
+
+	calibratedValue, err := CalibrateValue(rawValue, gain, offset)
+	if err != nil {
+		return outputValues.UpdateOutputValueWithQuality(outputID, rawValue, quality)
+	}
+	return outputValues.updateOutputValue(outputID, types.OutputValue{
+		Quality:  quality,
+		RawValue: rawValue,
+		Value:    calibratedValue,
+	})
+}
+
 // UpdateOutputFloatList adds a list of floats as the output value in the format: "[value1, value2, ...]"
 func (outputValues *RegisteredOutputValues) UpdateOutputFloatList(outputID string, values []float32) bool {
 	valuesAsString, _ := json.Marshal(values)
@@ -97,6 +245,22 @@ func (outputValues *RegisteredOutputValues) UpdateOutputStringList(outputID stri
 // The history retains a max of 24 hours
 // returns true if history is updated, false if history has not been updated
 func (outputValues *RegisteredOutputValues) UpdateOutputValue(outputID string, newValue string) bool {
+	return outputValues.UpdateOutputValueWithQuality(outputID, newValue, "")
+}
+
+// UpdateOutputValueWithQuality adds the new node output value with its quality to the front of the history
+// Use this when the sensor reports an error or when the value is interpolated. A change in quality
+// is published even if the value itself has not changed.
+// returns true if history is updated, false if history has not been updated
+func (outputValues *RegisteredOutputValues) UpdateOutputValueWithQuality(
+	outputID string, newValue string, quality types.ValueQuality) bool {
+
+	return outputValues.updateOutputValue(outputID, types.OutputValue{Quality: quality, Value: newValue})
+}
+
+// updateOutputValue adds the new value to the front of the history if it has changed or its repeat
+// delay has passed. The timestamp of the new value is set to the current time.
+func (outputValues *RegisteredOutputValues) updateOutputValue(outputID string, newValue types.OutputValue) bool {
 	var previous *types.OutputValue
 	var repeatDelay = 3600 // default repeat delay is 1 hour
 	var ageSeconds = -1
@@ -120,10 +284,22 @@ func (outputValues *RegisteredOutputValues) UpdateOutputValue(outputID string, n
 		age := time.Now().Sub(prevTime)
 		ageSeconds = int(age.Seconds())
 	}
-	doUpdate := ageSeconds < 0 || ageSeconds > repeatDelay || newValue != previous.Value
+	doUpdate := ageSeconds < 0 || ageSeconds > repeatDelay ||
+		newValue.Value != previous.Value || newValue.Quality != previous.Quality
 	if doUpdate {
-		// 24 hour history
-		newHistory := updateHistory(history, newValue, 0)
+		// 24 hour history, limited to the share of the max history memory
+		maxHistorySize := 0
+		if outputValues.maxHistory > 0 {
+			outputCount := len(outputValues.historyMap)
+			if history == nil {
+				outputCount++
+			}
+			maxHistorySize = outputValues.maxHistory / outputCount / historyValueSize(&newValue)
+			if maxHistorySize < 1 {
+				maxHistorySize = 1
+			}
+		}
+		newHistory := updateHistory(history, newValue, maxHistorySize)
 
 		outputValues.historyMap[outputID] = newHistory
 		hasUpdated = true
@@ -132,7 +308,8 @@ func (outputValues *RegisteredOutputValues) UpdateOutputValue(outputID string, n
 			outputValues.updatedOutputs = make(map[string]string)
 		}
 		outputValues.updatedOutputs[outputID] = outputID
-
+		outputValues.updateNotifier.Notify(outputID)
+		outputValues.updateTracker.Update(outputID)
 	}
 	return hasUpdated
 }
@@ -141,19 +318,17 @@ func (outputValues *RegisteredOutputValues) UpdateOutputValue(outputID string, n
 // The resulting list contains a max of historySize entries limited to 24 hours
 // This function is not thread-safe and should only be used from within a locked section
 // history is optional and used to insert the value in the front. If nil then a new history is returned
-// newValue contains the value to include in the history along with the current timestamp
+// newValue contains the value to include in the history. Its timestamp is set to the current time.
 // maxHistorySize is optional and limits the size in addition to the 24 hour limit
 // returns the history list with the new value at the front of the list
-func updateHistory(history OutputHistory, newValue string, maxHistorySize int) OutputHistory {
+func updateHistory(history OutputHistory, newValue types.OutputValue, maxHistorySize int) OutputHistory {
 
 	timeStamp := time.Now()
 	timeStampStr := timeStamp.Format(types.TimeFormat)
 
-	latest := types.OutputValue{
-		Timestamp: timeStampStr,
-		EpochTime: timeStamp.Unix(),
-		Value:     newValue,
-	}
+	latest := newValue
+	latest.Timestamp = timeStampStr
+	latest.EpochTime = timeStamp.Unix()
 	if history == nil {
 		history = make(OutputHistory, 1)
 	} else {
@@ -179,13 +354,20 @@ func updateHistory(history OutputHistory, newValue string, maxHistorySize int) O
 	return history
 }
 
+// historyValueSize returns the estimated memory in bytes of a history value
+func historyValueSize(value *types.OutputValue) int {
+	return historyValueOverhead + len(value.Value) + len(value.RawValue) + len(value.Quality)
+}
+
 // NewRegisteredOutputValues creates a new instance for output value and history management
 func NewRegisteredOutputValues(domain string, publisherID string) *RegisteredOutputValues {
 	outputs := RegisteredOutputValues{
-		domain:      domain,
-		publisherID: publisherID,
-		historyMap:  make(map[string]OutputHistory),
-		updateMutex: &sync.Mutex{},
+		domain:         domain,
+		publisherID:    publisherID,
+		historyMap:     make(map[string]OutputHistory),
+		updateMutex:    &sync.Mutex{},
+		updateNotifier: lib.NewUpdateNotifier(),
+		updateTracker:  lib.NewUpdateTracker(),
 	}
 	return &outputs
 }
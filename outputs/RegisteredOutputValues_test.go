@@ -2,6 +2,7 @@ package outputs_test
 
 import (
 	"crypto/ecdsa"
+	"fmt"
 	"testing"
 
 	"github.com/iotdomain/iotdomain-go/messaging"
@@ -58,6 +59,87 @@ func TestCreateOutputValues(t *testing.T) {
 	assert.Equal(t, val3.Value, "[\"a\",\"b\",\"c\"]")
 }
 
+func TestOutputValueQuality(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "publisher1"
+	const node1ID = "node1"
+	collection := outputs.NewRegisteredOutputValues(domain, publisher1ID)
+	outputID := outputs.MakeOutputID(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+
+	res := collection.UpdateOutputValue(outputID, "20")
+	assert.True(t, res)
+	val1 := collection.GetOutputValueByID(outputID)
+	require.NotNil(t, val1)
+	assert.Empty(t, val1.Quality, "Default quality should be empty")
+
+	// same value with a different quality is an update
+	res = collection.UpdateOutputValueWithQuality(outputID, "20", types.ValueQualityUncertain)
+	assert.True(t, res)
+	val1 = collection.GetOutputValueByID(outputID)
+	assert.Equal(t, types.ValueQualityUncertain, val1.Quality)
+	res = collection.UpdateOutputValueWithQuality(outputID, "20", types.ValueQualityUncertain)
+	assert.False(t, res)
+	history := collection.GetHistory(outputID)
+	assert.Len(t, history, 2)
+}
+
+func TestOutputValuesSnapshot(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "publisher1"
+	collection := outputs.NewRegisteredOutputValues(domain, publisher1ID)
+	output1ID := outputs.MakeOutputID("node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	output2ID := outputs.MakeOutputID("node2", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	collection.UpdateOutputValue(output1ID, "20")
+	collection.UpdateOutputValue(output2ID, "21")
+
+	snapshot := collection.Snapshot()
+	require.Len(t, snapshot, 2)
+	assert.Equal(t, "20", snapshot[output1ID].Value)
+
+	// later updates don't affect the snapshot
+	collection.UpdateOutputValue(output1ID, "25")
+	assert.Equal(t, "20", snapshot[output1ID].Value)
+	assert.Equal(t, "25", collection.Snapshot()[output1ID].Value)
+}
+
+func TestCalibrateOutputValue(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "publisher1"
+	const node1ID = "node1"
+	collection := outputs.NewRegisteredOutputValues(domain, publisher1ID)
+	outputID := outputs.MakeOutputID(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+
+	calibrated, err := outputs.CalibrateValue("20", 1.5, -2)
+	assert.NoError(t, err)
+	assert.Equal(t, "28", calibrated)
+	// large values and decimal offsets are not rounded
+	calibrated, err = outputs.CalibrateValue("123456789", 1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "123456789", calibrated)
+	calibrated, err = outputs.CalibrateValue("21.5", 1, 0.1)
+	assert.NoError(t, err)
+	assert.Equal(t, "21.6", calibrated)
+	_, err = outputs.CalibrateValue("hot", 1.5, -2)
+	assert.Error(t, err)
+
+	res := collection.UpdateCalibratedOutputValue(outputID, "20", 1.5, -2, "")
+	assert.True(t, res)
+	val1 := collection.GetOutputValueByID(outputID)
+	require.NotNil(t, val1)
+	assert.Equal(t, "28", val1.Value)
+	assert.Equal(t, "20", val1.RawValue)
+
+	// non numeric values are not calibrated
+	res = collection.UpdateCalibratedOutputValue(outputID, "hot", 1.5, -2, "")
+	assert.True(t, res)
+	val1 = collection.GetOutputValueByID(outputID)
+	assert.Equal(t, "hot", val1.Value)
+	assert.Empty(t, val1.RawValue)
+
+	attr := outputs.MakeCalibrationAttr(types.OutputTypeTemperature, types.DefaultOutputInstance, types.NodeAttrGain)
+	assert.Equal(t, types.NodeAttr("temperature/0/gain"), attr)
+}
+
 func TestPublishOutputValues(t *testing.T) {
 	const domain = "test"
 	const publisher1ID = "publisher1"
@@ -87,3 +169,78 @@ func TestPublishOutputValues(t *testing.T) {
 		"and Gregorian calendars.", signer)
 
 }
+
+func TestValidateOutputValue(t *testing.T) {
+	output := outputs.NewOutput("test", "publisher1", "node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	// without data type any value is valid
+	assert.NoError(t, outputs.ValidateOutputValue(output, "hot"))
+
+	output.DataType = types.DataTypeNumber
+	assert.NoError(t, outputs.ValidateOutputValue(output, "21.5"))
+	assert.Error(t, outputs.ValidateOutputValue(output, "hot"))
+	coerced, err := outputs.CoerceOutputValue(output, " 21.5 ")
+	assert.NoError(t, err)
+	assert.Equal(t, "21.5", coerced)
+	_, err = outputs.CoerceOutputValue(output, "hot")
+	assert.Error(t, err)
+
+	output.DataType = types.DataTypeInt
+	coerced, err = outputs.CoerceOutputValue(output, "21.6")
+	assert.NoError(t, err)
+	assert.Equal(t, "22", coerced)
+
+	output.DataType = types.DataTypeBool
+	assert.NoError(t, outputs.ValidateOutputValue(output, "true"))
+	coerced, err = outputs.CoerceOutputValue(output, " on ")
+	assert.NoError(t, err)
+	assert.Equal(t, "true", coerced)
+
+	output.DataType = types.DataTypeJSON
+	assert.NoError(t, outputs.ValidateOutputValue(output, `{"a":1}`))
+	assert.Error(t, outputs.ValidateOutputValue(output, "hot"))
+	coerced, err = outputs.CoerceOutputValue(output, "hot")
+	assert.NoError(t, err)
+	assert.Equal(t, `"hot"`, coerced)
+
+	regOutputs := outputs.NewRegisteredOutputs("test", "publisher1")
+	output = regOutputs.CreateEnumOutput("node1", types.OutputTypeSwitch, types.DefaultOutputInstance,
+		types.EnumThermostatMode)
+	assert.Equal(t, types.DataTypeEnum, output.DataType)
+	assert.NoError(t, outputs.ValidateOutputValue(output, "cool"))
+	assert.Error(t, outputs.ValidateOutputValue(output, "boil"))
+	coerced, err = outputs.CoerceOutputValue(output, "Cool ")
+	assert.NoError(t, err)
+	assert.Equal(t, "cool", coerced)
+	_, err = outputs.CoerceOutputValue(output, "boil")
+	assert.Error(t, err)
+}
+
+func TestMaxHistoryMemory(t *testing.T) {
+	collection := outputs.NewRegisteredOutputValues("test", "publisher1")
+	output1ID := outputs.MakeOutputID("node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	output2ID := outputs.MakeOutputID("node2", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	for i := 0; i < 100; i++ {
+		collection.UpdateOutputValue(output1ID, fmt.Sprintf("%d", i))
+	}
+	assert.Len(t, collection.GetHistory(output1ID), 100)
+	historyMemory, maxHistoryMemory := collection.GetHistoryMemory()
+	assert.Equal(t, 0, maxHistoryMemory)
+
+	// the oldest values are removed when an output exceeds its share of the memory
+	collection.SetMaxHistoryMemory(historyMemory / 2)
+	collection.UpdateOutputValue(output1ID, "100")
+	history := collection.GetHistory(output1ID)
+	assert.InDelta(t, 50, len(history), 2)
+	assert.Equal(t, "100", history[0].Value)
+	collection.UpdateOutputValue(output2ID, "1")
+	collection.UpdateOutputValue(output1ID, "101")
+	assert.InDelta(t, 25, len(collection.GetHistory(output1ID)), 2)
+	historyMemory, maxHistoryMemory = collection.GetHistoryMemory()
+	assert.LessOrEqual(t, historyMemory, maxHistoryMemory)
+
+	// the latest value is always retained
+	collection.SetMaxHistoryMemory(1)
+	collection.UpdateOutputValue(output1ID, "102")
+	require.Len(t, collection.GetHistory(output1ID), 1)
+	assert.Equal(t, "102", collection.GetOutputValueByID(output1ID).Value)
+}
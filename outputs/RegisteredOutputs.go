@@ -2,23 +2,83 @@
 package outputs
 
 import (
+	"reflect"
 	"sync"
 	"time"
 
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
 )
 
 // RegisteredOutputs manages registration of publisher outputs
+// Registered outputs are replaced rather than modified so they can be read concurrently. GetAllOutputs
+// returns copies. To change a registered output use ModifyOutput, or update a Clone using UpdateOutput.
 type RegisteredOutputs struct {
-	addressMap       map[string]string                        // lookup outputID by output publication address
-	domain           string                                   // the domain of this publisher
-	publisherID      string                                   // the registered publisher for the inputs
-	outputsByID      map[string]*types.OutputDiscoveryMessage // lookup output by output ID
-	updatedOutputIDs map[string]string                        // IDs of updated outputs
-	updateMutex      *sync.Mutex                              // mutex for async updating of outputs
+	addressMap       map[string]string                          // lookup outputID by output publication address
+	customTypes      map[types.OutputType]*types.CustomTypeInfo // registered vendor specific output types
+	domain           string                                     // the domain of this publisher
+	isNodeRegistered func(nodeHWID string) bool                 // optional check that the node of new outputs is registered
+	maxNodeOutputs   int                                        // max nr of outputs per node. 0 is unlimited
+	publisherID      string                                     // the registered publisher for the inputs
+	rejectedOutputs  int                                        // nr of new outputs rejected because their node has the max nr of outputs
+	outputsByID      map[string]*types.OutputDiscoveryMessage   // lookup output by output ID
+	updatedOutputIDs map[string]string                          // IDs of updated outputs
+	updateMutex      *sync.Mutex                                // mutex for async updating of outputs
+	updateNotifier   *lib.UpdateNotifier                        // notify subscribers of updated outputs
+	updateTracker    *lib.UpdateTracker                         // track updates for consumers with a cursor
+}
+
+// Clone returns a copy of the output with new Attr, Config and EnumValues
+// Intended for updating the output in a concurrent safe manner in combination with UpdateOutput()
+// The type info of custom types is shared as it doesn't change after registration.
+func (regOutputs *RegisteredOutputs) Clone(output *types.OutputDiscoveryMessage) *types.OutputDiscoveryMessage {
+	newOutput := *output
+	if output.Attr != nil {
+		newOutput.Attr = make(types.NodeAttrMap, len(output.Attr))
+		for key, value := range output.Attr {
+			newOutput.Attr[key] = value
+		}
+	}
+	if output.Config != nil {
+		newOutput.Config = make(types.ConfigAttrMap, len(output.Config))
+		for key, value := range output.Config {
+			newOutput.Config[key] = value
+		}
+	}
+	if output.EnumValues != nil {
+		newOutput.EnumValues = append(types.EnumValues{}, output.EnumValues...)
+	}
+	return &newOutput
+}
+
+// Compact removes the address lookups of outputs that no longer use the address and reclaims the
+// memory of the lookup map. Intended as a periodic maintenance call for long running publishers.
+// Returns the nr of removed lookups.
+func (regOutputs *RegisteredOutputs) Compact() int {
+	regOutputs.updateMutex.Lock()
+	defer regOutputs.updateMutex.Unlock()
+	removed := 0
+	for address, outputID := range regOutputs.addressMap {
+		output := regOutputs.outputsByID[outputID]
+		if output == nil || output.Address != address {
+			delete(regOutputs.addressMap, address)
+			removed++
+		}
+	}
+	// maps don't shrink when entries are deleted
+	if removed > 0 {
+		addressMap := make(map[string]string, len(regOutputs.addressMap))
+		for address, outputID := range regOutputs.addressMap {
+			addressMap[address] = outputID
+		}
+		regOutputs.addressMap = addressMap
+	}
+	return removed
 }
 
 // CreateOutput creates and registers a new output. If the output already exists, it is replaced.
+// If the node has the max nr of outputs the new output is returned without being registered.
 func (regOutputs *RegisteredOutputs) CreateOutput(
 	hwID string, outputType types.OutputType, instance string) *types.OutputDiscoveryMessage {
 
@@ -26,18 +86,55 @@ func (regOutputs *RegisteredOutputs) CreateOutput(
 
 	regOutputs.updateMutex.Lock()
 	defer regOutputs.updateMutex.Unlock()
+	output.TypeInfo = regOutputs.customTypes[outputType]
 	regOutputs.updateOutput(output)
 	return output
 }
 
-// GetAllOutputs returns the list of outputs
+// CreateEnumOutput creates and registers a new enum output whose values are one of the given enum values
+// If the output already exists, it is replaced. See also CreateOutput.
+func (regOutputs *RegisteredOutputs) CreateEnumOutput(
+	hwID string, outputType types.OutputType, instance string, enum types.EnumValues) *types.OutputDiscoveryMessage {
+
+	output := NewOutput(regOutputs.domain, regOutputs.publisherID, hwID, outputType, instance)
+	output.DataType = types.DataTypeEnum
+	output.EnumValues = enum
+
+	regOutputs.updateMutex.Lock()
+	defer regOutputs.updateMutex.Unlock()
+	output.TypeInfo = regOutputs.customTypes[outputType]
+	regOutputs.updateOutput(output)
+	return output
+}
+
+// DeleteOutput unregisters the output
+//  outputID is the output's ID based on the node HWID
+func (regOutputs *RegisteredOutputs) DeleteOutput(outputID string) {
+	regOutputs.updateMutex.Lock()
+	defer regOutputs.updateMutex.Unlock()
+
+	output := regOutputs.outputsByID[outputID]
+	if output == nil {
+		return
+	}
+	regOutputs.removeAddress(output.Address, outputID)
+	delete(regOutputs.outputsByID, outputID)
+	if regOutputs.updatedOutputIDs != nil {
+		delete(regOutputs.updatedOutputIDs, outputID)
+	}
+	regOutputs.updateNotifier.Notify(outputID)
+	regOutputs.updateTracker.Update(outputID)
+}
+
+// GetAllOutputs returns copies of the registered outputs
+// Changes to the copies don't affect the registered outputs. Use UpdateOutput to apply them.
 func (regOutputs *RegisteredOutputs) GetAllOutputs() []*types.OutputDiscoveryMessage {
 	regOutputs.updateMutex.Lock()
 	defer regOutputs.updateMutex.Unlock()
 
 	var outputList = make([]*types.OutputDiscoveryMessage, 0)
 	for _, output := range regOutputs.outputsByID {
-		outputList = append(outputList, output)
+		outputList = append(outputList, regOutputs.Clone(output))
 	}
 	return outputList
 }
@@ -53,6 +150,14 @@ func (regOutputs *RegisteredOutputs) GetOutputByAddress(outputAddr string) *type
 	return output
 }
 
+// GetOutputQuota returns the nr of registered outputs, the max nr of outputs per node, and the nr of
+// new outputs that are rejected because their node has the max nr of outputs. A max of 0 is unlimited.
+func (regOutputs *RegisteredOutputs) GetOutputQuota() (outputCount int, maxNodeOutputs int, rejected int) {
+	regOutputs.updateMutex.Lock()
+	defer regOutputs.updateMutex.Unlock()
+	return len(regOutputs.outputsByID), regOutputs.maxNodeOutputs, regOutputs.rejectedOutputs
+}
+
 // GetOutputsByNodeHWID returns a list of all outputs of a given device
 func (regOutputs *RegisteredOutputs) GetOutputsByNodeHWID(hwID string) []*types.OutputDiscoveryMessage {
 	outputList := make([]*types.OutputDiscoveryMessage, 0)
@@ -106,17 +211,106 @@ func (regOutputs *RegisteredOutputs) GetUpdatedOutputs(clearUpdates bool) []*typ
 	return updateList
 }
 
+// GetUpdatedOutputsSince returns the outputs that are updated after the given cursor without clearing
+// the updates. Deleted outputs are not included.
+//  cursor is the cursor returned by the previous call. Use 0 to get all outputs updated since creation.
+// This returns the updated outputs in order of update and the cursor to use for the next call.
+func (regOutputs *RegisteredOutputs) GetUpdatedOutputsSince(cursor uint64) (
+	updateList []*types.OutputDiscoveryMessage, newCursor uint64) {
+
+	regOutputs.updateMutex.Lock()
+	defer regOutputs.updateMutex.Unlock()
+
+	outputIDs, newCursor := regOutputs.updateTracker.GetUpdatedSince(cursor)
+	updateList = make([]*types.OutputDiscoveryMessage, 0)
+	for _, outputID := range outputIDs {
+		output := regOutputs.outputsByID[outputID]
+		if output != nil {
+			updateList = append(updateList, output)
+		}
+	}
+	return updateList, newCursor
+}
+
+// ModifyOutput applies a modification to a copy of the output and replaces the registered output with
+// the copy. The modification is made in a locked section and must not use the registered outputs.
+// Returns the modified output, or nil if the output doesn't exist.
+func (regOutputs *RegisteredOutputs) ModifyOutput(
+	outputID string, modify func(output *types.OutputDiscoveryMessage)) *types.OutputDiscoveryMessage {
+
+	regOutputs.updateMutex.Lock()
+	defer regOutputs.updateMutex.Unlock()
+	output := regOutputs.outputsByID[outputID]
+	if output == nil {
+		return nil
+	}
+	newOutput := regOutputs.Clone(output)
+	modify(newOutput)
+	regOutputs.updateOutput(newOutput)
+	return newOutput
+}
+
+// OnUpdated subscribes a handler to updates of outputs. The handler is invoked asynchronously with the
+// ID of each output that is created, updated or deleted, in order of update.
+func (regOutputs *RegisteredOutputs) OnUpdated(handler func(outputID string)) {
+	regOutputs.updateNotifier.Subscribe(handler)
+}
+
+// RegisterCustomType registers a vendor specific output type under the given namespace.
+// Outputs of this type created with CreateOutput include the type info in their discovery.
+// info is optional. A copy is registered with the namespace and name of the type, so the caller's
+// info isn't modified and changing it afterwards doesn't affect the outputs.
+// Returns the output type to use with CreateOutput, eg: x-acme:valve-position
+func (regOutputs *RegisteredOutputs) RegisterCustomType(
+	namespace string, name string, info *types.CustomTypeInfo) (types.OutputType, error) {
+
+	outputType := types.MakeCustomType(namespace, name)
+	if _, _, isCustom := types.SplitCustomType(outputType); !isCustom {
+		return "", lib.MakeErrorf("RegisterCustomType: Invalid namespace '%s' or type name '%s'", namespace, name)
+	}
+	typeInfo := types.CustomTypeInfo{}
+	if info != nil {
+		typeInfo = *info
+	}
+	typeInfo.Namespace = namespace
+	typeInfo.Name = name
+
+	regOutputs.updateMutex.Lock()
+	defer regOutputs.updateMutex.Unlock()
+	regOutputs.customTypes[types.OutputType(outputType)] = &typeInfo
+	return types.OutputType(outputType), nil
+}
+
+// SetMaxNodeOutputs sets the max nr of outputs per node. New outputs of a node that has the max nr of
+// outputs are not registered and an error is logged. Existing outputs are not removed.
+//  maxNodeOutputs is the max nr of outputs per node. Use 0 for unlimited.
+func (regOutputs *RegisteredOutputs) SetMaxNodeOutputs(maxNodeOutputs int) {
+	regOutputs.updateMutex.Lock()
+	defer regOutputs.updateMutex.Unlock()
+	regOutputs.maxNodeOutputs = maxNodeOutputs
+}
+
+// SetNodeFilter sets the check that the node of a new output is registered. New outputs of nodes that
+// aren't registered, eg because the max nr of nodes is reached, are not registered. Existing outputs are
+// not removed.
+//  isNodeRegistered returns true if the node is registered. Use nil to accept outputs of any node.
+func (regOutputs *RegisteredOutputs) SetNodeFilter(isNodeRegistered func(nodeHWID string) bool) {
+	regOutputs.updateMutex.Lock()
+	defer regOutputs.updateMutex.Unlock()
+	regOutputs.isNodeRegistered = isNodeRegistered
+}
+
 // SetNodeID updates the address of all outputs with the given node hardware address
+// The lookup by the previous address is removed.
 func (regOutputs *RegisteredOutputs) SetNodeID(nodeHWID string, alias string) {
 	outputList := regOutputs.GetOutputsByNodeHWID(nodeHWID)
+	regOutputs.updateMutex.Lock()
+	defer regOutputs.updateMutex.Unlock()
 	for _, output := range outputList {
-		newAddress := MakeOutputDiscoveryAddress(
+		newOutput := regOutputs.Clone(output)
+		newOutput.Address = MakeOutputDiscoveryAddress(
 			regOutputs.domain, regOutputs.publisherID, alias, output.OutputType, output.Instance)
-		output.Address = newAddress
-
-		regOutputs.updateMutex.Lock()
-		defer regOutputs.updateMutex.Unlock()
-		regOutputs.updateOutput(output)
+		regOutputs.updateOutput(newOutput)
 	}
 }
 
@@ -127,20 +321,84 @@ func (regOutputs *RegisteredOutputs) UpdateOutput(output *types.OutputDiscoveryM
 	regOutputs.updateOutput(output)
 }
 
+// removeAddress removes the lookup of an address if it refers to the output
+// For internal use only. Use within locked section.
+func (regOutputs *RegisteredOutputs) removeAddress(address string, outputID string) {
+	if regOutputs.addressMap[address] == outputID {
+		delete(regOutputs.addressMap, address)
+	}
+}
+
+// isNodeQuotaReached returns true and logs an error if the node has the max nr of outputs
+// For internal use only. Use within locked section.
+func (regOutputs *RegisteredOutputs) isNodeQuotaReached(output *types.OutputDiscoveryMessage) bool {
+	if regOutputs.maxNodeOutputs <= 0 {
+		return false
+	}
+	nodeOutputCount := 0
+	for _, existing := range regOutputs.outputsByID {
+		if existing.NodeHWID == output.NodeHWID {
+			nodeOutputCount++
+		}
+	}
+	if nodeOutputCount < regOutputs.maxNodeOutputs {
+		return false
+	}
+	regOutputs.rejectedOutputs++
+	logrus.Errorf("RegisteredOutputs.updateOutput: Output '%s' is rejected. Node '%s' has the max of %d outputs",
+		output.OutputID, output.NodeHWID, regOutputs.maxNodeOutputs)
+	return true
+}
+
+// isNodeRejected returns true and logs a warning if the node of the output isn't registered
+// For internal use only. Use within locked section.
+func (regOutputs *RegisteredOutputs) isNodeRejected(output *types.OutputDiscoveryMessage) bool {
+	if regOutputs.isNodeRegistered == nil || regOutputs.isNodeRegistered(output.NodeHWID) {
+		return false
+	}
+	logrus.Warningf("RegisteredOutputs.updateOutput: Output '%s' is rejected. Node '%s' isn't registered",
+		output.OutputID, output.NodeHWID)
+	return true
+}
+
 // updateOutput replaces the output and updates its timestamp.
+// The lookup by the address of a replaced output instance is removed.
+// New outputs of a node that isn't registered or has the max nr of outputs are not registered.
+// A replacement instance whose content equals the existing output, apart from its timestamp, is not
+// marked as updated. The existing instance itself is always marked as it could have been modified.
 // For internal use only. Use within locked section.
 func (regOutputs *RegisteredOutputs) updateOutput(output *types.OutputDiscoveryMessage) {
 	if output == nil {
 		return
 	}
+	existing := regOutputs.outputsByID[output.OutputID]
+	if existing == nil && (regOutputs.isNodeRejected(output) || regOutputs.isNodeQuotaReached(output)) {
+		return
+	}
+	if existing != nil && existing != output {
+		regOutputs.removeAddress(existing.Address, output.OutputID)
+	}
 	regOutputs.outputsByID[output.OutputID] = output
 	regOutputs.addressMap[output.Address] = output.OutputID
+	if existing != nil && existing != output && !isOutputChanged(existing, output) {
+		output.Timestamp = existing.Timestamp
+		return
+	}
 
 	if regOutputs.updatedOutputIDs == nil {
 		regOutputs.updatedOutputIDs = make(map[string]string)
 	}
 	output.Timestamp = time.Now().Format(types.TimeFormat)
 	regOutputs.updatedOutputIDs[output.OutputID] = output.OutputID
+	regOutputs.updateNotifier.Notify(output.OutputID)
+	regOutputs.updateTracker.Update(output.OutputID)
+}
+
+// isOutputChanged returns true if the outputs differ in more than their timestamp
+func isOutputChanged(oldOutput *types.OutputDiscoveryMessage, newOutput *types.OutputDiscoveryMessage) bool {
+	oldCopy := *oldOutput
+	oldCopy.Timestamp = newOutput.Timestamp
+	return !reflect.DeepEqual(&oldCopy, newOutput)
 }
 
 // MakeOutputID creates the internal ID to identify the output of the owning node
@@ -149,6 +407,24 @@ func MakeOutputID(nodeHWID string, outputType types.OutputType, instance string)
 	return outputID
 }
 
+// ValidateOutput checks the output type and unit against the standard output types.
+// Unknown types or units are not an error in the protocol so this only logs a warning and
+// returns an error for the caller to decide what to do with it.
+// Vendor specific types are not validated.
+func ValidateOutput(output *types.OutputDiscoveryMessage) error {
+	if _, _, isCustom := types.SplitCustomType(string(output.OutputType)); isCustom {
+		return nil
+	}
+	knownType, knownUnit := types.IsStandardOutputUnit(output.OutputType, output.Unit)
+	if !knownType {
+		return lib.MakeErrorf("ValidateOutput: Output %s has unknown output type '%s'", output.Address, output.OutputType)
+	} else if !knownUnit {
+		return lib.MakeErrorf("ValidateOutput: Output %s of type '%s' has unexpected unit '%s'",
+			output.Address, output.OutputType, output.Unit)
+	}
+	return nil
+}
+
 // NewOutput creates a new output for the given device .
 // It is not immediately added to allow for further updates of the ouput definition.
 // To add it to the list use 'UpdateOutput'
@@ -173,11 +449,14 @@ func NewOutput(domain string, publisherID string, nodeHWID string, outputType ty
 // NewRegisteredOutputs creates a new instance for registered output management
 func NewRegisteredOutputs(domain string, publisherID string) *RegisteredOutputs {
 	regOutputs := RegisteredOutputs{
-		domain:      domain,
-		publisherID: publisherID,
-		addressMap:  make(map[string]string),
-		outputsByID: make(map[string]*types.OutputDiscoveryMessage),
-		updateMutex: &sync.Mutex{},
+		domain:         domain,
+		publisherID:    publisherID,
+		addressMap:     make(map[string]string),
+		customTypes:    make(map[types.OutputType]*types.CustomTypeInfo),
+		outputsByID:    make(map[string]*types.OutputDiscoveryMessage),
+		updateMutex:    &sync.Mutex{},
+		updateNotifier: lib.NewUpdateNotifier(),
+		updateTracker:  lib.NewUpdateTracker(),
 	}
 	return &regOutputs
 }
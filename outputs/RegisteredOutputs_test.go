@@ -2,6 +2,7 @@ package outputs_test
 
 import (
 	"crypto/ecdsa"
+	"encoding/json"
 	"fmt"
 	"testing"
 
@@ -45,6 +46,9 @@ func TestCreateOutputs(t *testing.T) {
 	nodeOuts := collection.GetOutputsByNodeHWID(node1ID)
 	assert.Equal(t, 1, len(nodeOuts), "Expected 1 output")
 
+	collection.DeleteOutput(output.OutputID)
+	assert.Nil(t, collection.GetOutputByAddress(node1Output1Addr), "Output not deleted")
+	assert.Equal(t, 0, len(collection.GetAllOutputs()))
 }
 
 func TestUpdateOutputs(t *testing.T) {
@@ -68,6 +72,16 @@ func TestUpdateOutputs(t *testing.T) {
 	if !(assert.Equal(t, 1, len(updated), "Expected 1 updated output")) {
 		return
 	}
+
+	// replacing the output with identical content doesn't mark it as updated
+	collection.GetUpdatedOutputs(true)
+	collection.ModifyOutput(output1.OutputID, func(output *types.OutputDiscoveryMessage) {})
+	collection.CreateOutput(node1ID, types.OutputTypeSwitch, types.DefaultOutputInstance)
+	assert.Empty(t, collection.GetUpdatedOutputs(true))
+	collection.ModifyOutput(output1.OutputID, func(output *types.OutputDiscoveryMessage) {
+		output.Unit = types.UnitCelcius
+	})
+	assert.Len(t, collection.GetUpdatedOutputs(true), 1)
 }
 
 func TestAlias(t *testing.T) {
@@ -84,6 +98,83 @@ func TestAlias(t *testing.T) {
 	require.NotNilf(t, output1b, "Output not retrievable using alias nodeID")
 }
 
+// TestConcurrentGetAllOutputs tests that the outputs from GetAllOutputs can be read while outputs
+// are renamed and modified. Run with -race to detect concurrent access.
+func TestConcurrentGetAllOutputs(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "publisher1"
+	const device1ID = "device1"
+	collection := outputs.NewRegisteredOutputs(domain, publisher1ID)
+	output := collection.CreateOutput(device1ID, types.OutputTypeSwitch, types.DefaultOutputInstance)
+	output.Attr = types.NodeAttrMap{types.NodeAttrName: "switch"}
+	collection.UpdateOutput(output)
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			collection.SetNodeID(device1ID, fmt.Sprintf("name%d", i))
+			collection.ModifyOutput(output.OutputID, func(output *types.OutputDiscoveryMessage) {
+				output.Attr[types.NodeAttrName] = fmt.Sprintf("switch%d", i)
+			})
+		}
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		for _, output := range collection.GetAllOutputs() {
+			_, err := json.Marshal(output)
+			assert.NoError(t, err)
+		}
+	}
+	// changing a copy doesn't change the registered output
+	copies := collection.GetAllOutputs()
+	require.Len(t, copies, 1)
+	copies[0].Attr[types.NodeAttrName] = "changed"
+	assert.Equal(t, "switch99", collection.GetAllOutputs()[0].Attr[types.NodeAttrName])
+
+	assert.Nil(t, collection.ModifyOutput("notanoutput", func(output *types.OutputDiscoveryMessage) {}))
+}
+
+// TestRenameAddressCleanup tests that the address lookups don't grow when nodes are renamed repeatedly
+func TestRenameAddressCleanup(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "publisher1"
+	const device1ID = "device1"
+	collection := outputs.NewRegisteredOutputs(domain, publisher1ID)
+	collection.CreateOutput(device1ID, types.OutputTypeSwitch, types.DefaultOutputInstance)
+	collection.CreateOutput(device1ID, types.OutputTypeSwitch, "1")
+	firstAddress := outputs.MakeOutputDiscoveryAddress(domain, publisher1ID, device1ID, types.OutputTypeSwitch, "1")
+
+	for i := 0; i < 100; i++ {
+		collection.SetNodeID(device1ID, fmt.Sprintf("name%d", i))
+	}
+	assert.Nil(t, collection.GetOutputByAddress(firstAddress), "Old address should not resolve")
+	lastAddress := outputs.MakeOutputDiscoveryAddress(domain, publisher1ID, "name99", types.OutputTypeSwitch, "1")
+	assert.NotNil(t, collection.GetOutputByAddress(lastAddress))
+	assert.Equal(t, 0, collection.Compact(), "Renaming should not leave stale addresses")
+
+	// re-registering an output with another address replaces its lookup
+	collection.CreateOutput(device1ID, types.OutputTypeSwitch, "1")
+	assert.Nil(t, collection.GetOutputByAddress(lastAddress))
+	assert.NotNil(t, collection.GetOutputByAddress(firstAddress))
+	collection.DeleteOutput(outputs.MakeOutputID(device1ID, types.OutputTypeSwitch, "1"))
+	assert.Nil(t, collection.GetOutputByAddress(firstAddress))
+	assert.Equal(t, 0, collection.Compact())
+
+	// addresses changed in place are removed by compacting
+	output := collection.GetOutputByNodeHWID(device1ID, types.OutputTypeSwitch, types.DefaultOutputInstance)
+	oldAddress := output.Address
+	output.Address = outputs.MakeOutputDiscoveryAddress(domain, publisher1ID, "other", types.OutputTypeSwitch, types.DefaultOutputInstance)
+	collection.UpdateOutput(output)
+	assert.Equal(t, 1, collection.Compact())
+	assert.Nil(t, collection.GetOutputByAddress(oldAddress))
+	assert.NotNil(t, collection.GetOutputByAddress(output.Address))
+}
+
 func TestPublishOutputs(t *testing.T) {
 	const domain = "test"
 	const publisher1ID = "publisher1"
@@ -107,3 +198,77 @@ func TestPublishOutputs(t *testing.T) {
 
 	outputs.PublishRegisteredOutputs(allOutputs, signer)
 }
+
+func TestValidateOutput(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "publisher1"
+	const node1ID = "node1"
+
+	collection := outputs.NewRegisteredOutputs(domain, publisher1ID)
+	output := collection.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	err := outputs.ValidateOutput(output)
+	assert.NoError(t, err, "Output without unit should use the default unit")
+
+	output.Unit = types.UnitFahrenheit
+	err = outputs.ValidateOutput(output)
+	assert.NoError(t, err, "Fahrenheit is a valid temperature unit")
+
+	output.Unit = types.UnitVolt
+	err = outputs.ValidateOutput(output)
+	assert.Error(t, err, "Volt is not a valid temperature unit")
+
+	output = collection.CreateOutput(node1ID, types.OutputTypeParticulateMatter25, types.DefaultOutputInstance)
+	output.Unit = types.UnitMicrogramPerM3
+	err = outputs.ValidateOutput(output)
+	assert.NoError(t, err)
+
+	output = collection.CreateOutput(node1ID, types.OutputTypeSwitch, types.DefaultOutputInstance)
+	output.Unit = types.UnitCelcius
+	err = outputs.ValidateOutput(output)
+	assert.Error(t, err, "Switch is unitless")
+
+	output = collection.CreateOutput(node1ID, "notatype", types.DefaultOutputInstance)
+	err = outputs.ValidateOutput(output)
+	assert.Error(t, err, "Unknown output type")
+}
+
+func TestCustomOutputType(t *testing.T) {
+	const domain = "test"
+	const publisher1ID = "publisher1"
+	const node1ID = "node1"
+
+	collection := outputs.NewRegisteredOutputs(domain, publisher1ID)
+	outputType, err := collection.RegisterCustomType("acme", "valve-position", nil)
+	require.NoError(t, err)
+	assert.Equal(t, types.OutputType("x-acme:valve-position"), outputType)
+
+	output := collection.CreateOutput(node1ID, outputType, types.DefaultOutputInstance)
+	require.NotNil(t, output.TypeInfo, "Missing custom type info")
+	assert.Equal(t, "acme", output.TypeInfo.Namespace)
+	assert.Equal(t, node1ID+"/x-acme:valve-position/0/$output", output.Address[len(domain+"/"+publisher1ID+"/"):])
+	err = outputs.ValidateOutput(output)
+	assert.NoError(t, err, "Custom types should not produce a warning")
+
+	_, err = collection.RegisterCustomType("acme", "valve:position", nil)
+	assert.Error(t, err)
+}
+
+func TestMaxNodeOutputs(t *testing.T) {
+	const node1ID = "node1"
+	collection := outputs.NewRegisteredOutputs("test", "publisher1")
+	collection.SetMaxNodeOutputs(1)
+	collection.CreateOutput(node1ID, types.OutputTypeSwitch, types.DefaultOutputInstance)
+	output := collection.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	require.NotNil(t, output, "rejected outputs are returned unregistered")
+	collection.UpdateOutput(output)
+	assert.Nil(t, collection.GetOutputByID(output.OutputID))
+	assert.Len(t, collection.GetOutputsByNodeHWID(node1ID), 1)
+
+	// replacing an existing output and outputs of other nodes are allowed
+	assert.NotNil(t, collection.CreateEnumOutput(node1ID, types.OutputTypeSwitch, types.DefaultOutputInstance, nil))
+	collection.CreateOutput("node2", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	outputCount, maxNodeOutputs, rejected := collection.GetOutputQuota()
+	assert.Equal(t, 2, outputCount)
+	assert.Equal(t, 1, maxNodeOutputs)
+	assert.Equal(t, 2, rejected)
+}
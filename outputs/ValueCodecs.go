@@ -0,0 +1,128 @@
+// Package outputs with codecs for encoding $raw output values for legacy consumers
+package outputs
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// Built-in codecs of $raw output values
+const (
+	ValueCodecCSV   = "csv"   // CSV row with timestamp,value,unit
+	ValueCodecJSON  = "json"  // JSON object with the timestamp, value, unit and quality
+	ValueCodecPlain = "plain" // the value as is, the default
+)
+
+// ValueCodec encodes an output value for publication on the $raw address of the output
+// The $latest, $history and $event publications always use the canonical value.
+type ValueCodec func(output *types.OutputDiscoveryMessage, value *types.OutputValue) (string, error)
+
+// jsonCodecValue is the JSON encoding of an output value by the json codec
+type jsonCodecValue struct {
+	Quality   types.ValueQuality `json:"quality,omitempty"`
+	Timestamp string             `json:"timestamp"`
+	Unit      types.Unit         `json:"unit,omitempty"`
+	Value     string             `json:"value"`
+}
+
+// ValueCodecs with the built-in and registered codecs of $raw output values by name
+type ValueCodecs struct {
+	codecs      map[string]ValueCodec // codecs by name
+	updateMutex *sync.Mutex           // mutex for async registration of codecs
+}
+
+// Encode encodes an output value with the codec of the given name. Use "" for the plain codec.
+// Returns an error if the codec is unknown or fails. The error isn't logged, that is up to the caller.
+func (valueCodecs *ValueCodecs) Encode(codecName string, output *types.OutputDiscoveryMessage,
+	value *types.OutputValue) (string, error) {
+
+	if codecName == "" {
+		codecName = ValueCodecPlain
+	}
+	valueCodecs.updateMutex.Lock()
+	codec := valueCodecs.codecs[codecName]
+	valueCodecs.updateMutex.Unlock()
+	if codec == nil {
+		return "", fmt.Errorf("Encode: Unknown value codec '%s'", codecName)
+	}
+	return codec(output, value)
+}
+
+// Names returns the sorted names of the available codecs
+func (valueCodecs *ValueCodecs) Names() []string {
+	valueCodecs.updateMutex.Lock()
+	defer valueCodecs.updateMutex.Unlock()
+	names := make([]string, 0, len(valueCodecs.codecs))
+	for name := range valueCodecs.codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Register adds a custom codec, eg a protobuf encoding. The built-in codecs can't be replaced.
+func (valueCodecs *ValueCodecs) Register(codecName string, codec ValueCodec) error {
+	if codecName == "" || codec == nil {
+		return fmt.Errorf("Register: Missing codec name or codec")
+	} else if codecName == ValueCodecCSV || codecName == ValueCodecJSON || codecName == ValueCodecPlain {
+		return fmt.Errorf("Register: Codec '%s' is built-in and can't be replaced", codecName)
+	}
+	valueCodecs.updateMutex.Lock()
+	defer valueCodecs.updateMutex.Unlock()
+	valueCodecs.codecs[codecName] = codec
+	return nil
+}
+
+// EncodeValueCSV encodes an output value as a CSV row with the timestamp, value and unit
+// Values containing a comma or quote are quoted.
+func EncodeValueCSV(output *types.OutputDiscoveryMessage, value *types.OutputValue) (string, error) {
+	buffer := bytes.Buffer{}
+	writer := csv.NewWriter(&buffer)
+	err := writer.Write([]string{value.Timestamp, value.Value, string(output.Unit)})
+	if err == nil {
+		writer.Flush()
+		err = writer.Error()
+	}
+	if err != nil {
+		return "", fmt.Errorf("EncodeValueCSV: %s", err)
+	}
+	return strings.TrimSuffix(buffer.String(), "\n"), nil
+}
+
+// EncodeValueJSON encodes an output value as a JSON object with the timestamp, value, unit and quality
+func EncodeValueJSON(output *types.OutputDiscoveryMessage, value *types.OutputValue) (string, error) {
+	jsonValue, err := json.Marshal(&jsonCodecValue{
+		Quality:   value.Quality,
+		Timestamp: value.Timestamp,
+		Unit:      output.Unit,
+		Value:     value.Value,
+	})
+	if err != nil {
+		return "", fmt.Errorf("EncodeValueJSON: %s", err)
+	}
+	return string(jsonValue), nil
+}
+
+// EncodeValuePlain returns the output value as is
+func EncodeValuePlain(output *types.OutputDiscoveryMessage, value *types.OutputValue) (string, error) {
+	return value.Value, nil
+}
+
+// NewValueCodecs creates a collection of value codecs with the built-in csv, json and plain codecs
+func NewValueCodecs() *ValueCodecs {
+	return &ValueCodecs{
+		codecs: map[string]ValueCodec{
+			ValueCodecCSV:   EncodeValueCSV,
+			ValueCodecJSON:  EncodeValueJSON,
+			ValueCodecPlain: EncodeValuePlain,
+		},
+		updateMutex: &sync.Mutex{},
+	}
+}
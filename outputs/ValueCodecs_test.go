@@ -0,0 +1,40 @@
+package outputs_test
+
+import (
+	"testing"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValueCodecs(t *testing.T) {
+	output := outputs.NewOutput("test", "pub1", "node1", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	output.Unit = types.UnitCelcius
+	value := &types.OutputValue{Timestamp: "2020-01-02T03:04:05.000-0700", Value: "[1, 2]", Quality: types.ValueQualityUncertain}
+	codecs := outputs.NewValueCodecs()
+	assert.Equal(t, []string{outputs.ValueCodecCSV, outputs.ValueCodecJSON, outputs.ValueCodecPlain}, codecs.Names())
+
+	encoded, err := codecs.Encode("", output, value)
+	assert.NoError(t, err)
+	assert.Equal(t, "[1, 2]", encoded)
+	encoded, err = codecs.Encode(outputs.ValueCodecCSV, output, value)
+	assert.NoError(t, err)
+	assert.Equal(t, `2020-01-02T03:04:05.000-0700,"[1, 2]",C`, encoded)
+	encoded, err = codecs.Encode(outputs.ValueCodecJSON, output, value)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"quality":"uncertain","timestamp":"2020-01-02T03:04:05.000-0700","unit":"C","value":"[1, 2]"}`, encoded)
+
+	// custom codecs
+	_, err = codecs.Encode("upper", output, value)
+	assert.Error(t, err)
+	err = codecs.Register("upper", func(output *types.OutputDiscoveryMessage, value *types.OutputValue) (string, error) {
+		return "UP " + value.Value, nil
+	})
+	assert.NoError(t, err)
+	encoded, err = codecs.Encode("upper", output, value)
+	assert.NoError(t, err)
+	assert.Equal(t, "UP [1, 2]", encoded)
+	assert.Error(t, codecs.Register(outputs.ValueCodecPlain, outputs.EncodeValueCSV))
+	assert.Error(t, codecs.Register("", outputs.EncodeValueCSV))
+}
@@ -0,0 +1,122 @@
+// Package publisher with cleanup of stale retained publications of this publisher
+package publisher
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultRetainedSnapshotTime is the time to collect retained publications after subscribing
+const DefaultRetainedSnapshotTime = 3 * time.Second
+
+// retainedMessageTypes are the message types of publications that are published with retained=true
+var retainedMessageTypes = map[string]bool{
+	types.MessageTypeEvent:           true,
+	types.MessageTypeForecast:        true,
+	types.MessageTypeHistory:         true,
+	types.MessageTypeIdentity:        true,
+	types.MessageTypeInputDiscovery:  true,
+	types.MessageTypeLatest:          true,
+	types.MessageTypeNodeDiscovery:   true,
+	types.MessageTypeOutputDiscovery: true,
+	types.MessageTypeRaw:             true,
+	types.MessageTypeRawSignature:    true,
+	types.MessageTypeStatus:          true,
+}
+
+// retainedOutputMessageTypes are the retained publications of an output in addition to its discovery
+var retainedOutputMessageTypes = []types.MessageType{
+	types.MessageTypeForecast,
+	types.MessageTypeHistory,
+	types.MessageTypeLatest,
+	types.MessageTypeRaw,
+	types.MessageTypeRawSignature,
+}
+
+// CleanupRetained clears the retained publications of this publisher that no longer correspond to its
+// registered nodes, inputs and outputs. Intended to fix stale state after a crash or removal of devices.
+// The retained publications are collected by subscribing to the addresses of this publisher and
+// waiting for the snapshot time. The publisher must be started.
+//  snapshotTime is the time to wait for retained publications. Use 0 for DefaultRetainedSnapshotTime
+// This returns the addresses that were cleared.
+func (pub *Publisher) CleanupRetained(snapshotTime time.Duration) (cleared []string, err error) {
+	if snapshotTime <= 0 {
+		snapshotTime = DefaultRetainedSnapshotTime
+	}
+	snapshotAddress := pub.Domain() + "/" + pub.PublisherID() + "/#"
+	snapshot := make([]string, 0)
+	snapshotMutex := &sync.Mutex{}
+
+	pub.messageSigner.Subscribe(snapshotAddress, func(address string, message string) error {
+		// empty messages are already cleared
+		if message != "" {
+			snapshotMutex.Lock()
+			snapshot = append(snapshot, address)
+			snapshotMutex.Unlock()
+		}
+		return nil
+	})
+	time.Sleep(snapshotTime)
+	// subscription handlers are wrapped so only the address can be matched
+	pub.messageSigner.Unsubscribe(snapshotAddress, nil)
+
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+	return pub.ClearStaleRetained(snapshot)
+}
+
+// ClearStaleRetained clears the given retained publications of this publisher that no longer correspond
+// to its identity, status or registered nodes, inputs and outputs.
+//  retainedAddresses are the addresses of the retained publications on the message bus
+// This returns the addresses that were cleared.
+func (pub *Publisher) ClearStaleRetained(retainedAddresses []string) (cleared []string, err error) {
+	current := make(map[string]bool)
+	for _, address := range pub.retainedAddresses() {
+		current[address] = true
+	}
+	prefix := pub.Domain() + "/" + pub.PublisherID() + "/"
+	cleared = make([]string, 0)
+	for _, address := range retainedAddresses {
+		messageType := address[strings.LastIndex(address, "/")+1:]
+		if !strings.HasPrefix(address, prefix) || !retainedMessageTypes[messageType] || current[address] {
+			continue
+		}
+		logrus.Infof("Publisher.ClearStaleRetained: clearing stale publication on %s", address)
+		err = pub.messageSigner.ClearRetained(address)
+		if err != nil {
+			return cleared, lib.MakeErrorf("ClearStaleRetained: Failed clearing retained publication on %s: %s", address, err)
+		}
+		current[address] = true // prevent clearing twice
+		cleared = append(cleared, address)
+	}
+	return cleared, nil
+}
+
+// retainedAddresses returns the addresses of the retained publications of the identity, status,
+// and registered nodes, inputs and outputs of this publisher
+func (pub *Publisher) retainedAddresses() []string {
+	addresses := []string{
+		identities.MakePublisherIdentityAddress(pub.Domain(), pub.PublisherID()),
+		identities.MakePublisherStatusAddress(pub.Domain(), pub.PublisherID()),
+	}
+	for _, node := range pub.registeredNodes.GetAllNodes() {
+		addresses = append(addresses, node.Address, outputs.ReplaceMessageType(node.Address, types.MessageTypeEvent))
+	}
+	for _, input := range pub.registeredInputs.GetAllInputs() {
+		addresses = append(addresses, input.Address)
+	}
+	for _, output := range pub.registeredOutputs.GetAllOutputs() {
+		addresses = append(addresses, output.Address)
+		for _, messageType := range retainedOutputMessageTypes {
+			addresses = append(addresses, outputs.ReplaceMessageType(output.Address, messageType))
+		}
+	}
+	return addresses
+}
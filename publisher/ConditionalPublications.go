@@ -0,0 +1,59 @@
+// Package publisher with publication of expensive message types only while consumers are interested
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/sirupsen/logrus"
+)
+
+// HasConsumerInterest returns true if a consumer announced interest in a message type of a node
+// and the interest hasn't expired
+//  nodeID is the node ID used in the publication address
+//  messageType is the type of the publication, eg $history
+func (pub *Publisher) HasConsumerInterest(nodeID string, messageType string) bool {
+	return pub.consumerInterest.HasInterest(nodeID, messageType)
+}
+
+// PublishInterest announces interest in the publications of a publisher that only publishes them while
+// consumers are interested. Renew the interest before its duration expires.
+//  domain and publisherID of the publisher of interest
+//  nodeID is the node of interest. Use "" for all nodes of the publisher.
+//  messageTypes are the message types of interest, eg $history, $event or $raw
+//  duration is the nr of seconds the interest lasts. Use 0 for outputs.DefaultInterestDuration.
+func (pub *Publisher) PublishInterest(domain string, publisherID string, nodeID string, messageTypes []string, duration int) error {
+	return outputs.PublishInterest(domain, publisherID, nodeID, messageTypes, duration, pub.Address(), pub.messageSigner)
+}
+
+// handleInterest republishes the output values of the nodes that consumers gained interest in. This
+// publishes the values that were suppressed while there was no interest.
+//  nodeID is the node of interest, or "" for all nodes
+func (pub *Publisher) handleInterest(nodeID string, messageTypes []string) {
+	outputIDs := make([]string, 0)
+	for _, node := range pub.registeredNodes.GetAllNodes() {
+		if nodeID != "" && node.NodeID != nodeID {
+			continue
+		}
+		for _, output := range pub.registeredOutputs.GetOutputsByNodeHWID(node.HWID) {
+			if pub.registeredOutputValues.GetOutputValueByID(output.OutputID) != nil {
+				outputIDs = append(outputIDs, output.OutputID)
+			}
+		}
+	}
+	if len(outputIDs) > 0 {
+		logrus.Infof("Publisher.handleInterest: Publishing %d output values of node '%s' for interest in %v",
+			len(outputIDs), nodeID, messageTypes)
+		pub.PublishUpdatedOutputValues(outputIDs, pub.messageSigner)
+	}
+}
+
+// isPublicationWanted returns true if a message type of a node is published. Message types of the
+// interestTypes configuration are only published while a consumer is interested.
+//  nodeID is the node ID used in the publication address
+func (pub *Publisher) isPublicationWanted(nodeID string, messageType string) bool {
+	for _, interestType := range pub.config.InterestTypes {
+		if interestType == messageType {
+			return pub.consumerInterest.HasInterest(nodeID, messageType)
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+// Package publisher with counter outputs for energy and other metering
+package publisher
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Instance suffixes of the outputs that are derived from a counter output
+const (
+	CounterDeltaSuffix = "-delta" // output with the delta of the last reading
+	CounterRateSuffix  = "-rate"  // output with the rate of the last reading
+)
+
+// CreateCounterOutput creates a counter output with the accumulated total of raw meter readings, and
+// its derived delta and rate outputs with instance {instance}-delta and {instance}-rate.
+// Use UpdateCounterValue to update the outputs with a new raw reading. The accumulated total is
+// retained across restarts when cacheCounters is configured.
+//  rollover is the raw value at which the meter wraps to 0. Use 0 if it doesn't wrap.
+//  ratePeriod is the period the rate is expressed in, eg time.Hour for kW from kWh. Use 0 for per second.
+// This returns the output with the total
+func (pub *Publisher) CreateCounterOutput(nodeHWID string, outputType types.OutputType, instance string,
+	rollover float64, ratePeriod time.Duration) *types.OutputDiscoveryMessage {
+
+	var total *types.OutputDiscoveryMessage
+	for _, outputInstance := range []string{instance, instance + CounterDeltaSuffix, instance + CounterRateSuffix} {
+		output := outputs.NewOutput(pub.Domain(), pub.PublisherID(), nodeHWID, outputType, outputInstance)
+		output.DataType = types.DataTypeNumber
+		pub.registeredOutputs.UpdateOutput(output)
+		if total == nil {
+			total = output
+		}
+	}
+	pub.registeredCounters.CreateCounter(total.OutputID, rollover, ratePeriod)
+	return total
+}
+
+// UpdateCounterValue adds a raw meter reading to a counter output and updates its total, delta
+// and rate output values. See also CreateCounterOutput.
+// This returns false if the counter doesn't exist or the reading is invalid.
+func (pub *Publisher) UpdateCounterValue(nodeHWID string, outputType types.OutputType, instance string,
+	raw float64) bool {
+
+	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
+	total, delta, rate, hasRate, err := pub.registeredCounters.UpdateCounter(outputID, raw, time.Now())
+	if err != nil {
+		logrus.Warningf("Publisher.UpdateCounterValue: %s", err)
+		return false
+	}
+	pub.updateOutputValue(nodeHWID, outputType, instance, formatCounterValue(total), "")
+	pub.updateOutputValue(nodeHWID, outputType, instance+CounterDeltaSuffix, formatCounterValue(delta), "")
+	if hasRate {
+		pub.updateOutputValue(nodeHWID, outputType, instance+CounterRateSuffix, formatCounterValue(rate), "")
+	}
+	return true
+}
+
+// formatCounterValue formats a counter value without trailing zeros
+func formatCounterValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
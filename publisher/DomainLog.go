@@ -0,0 +1,122 @@
+// Package publisher with publication of security relevant events on the domain log
+package publisher
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// RejectedSignatureLogInterval is the interval in which the rejected signatures of a sender are logged once
+// The rejected signatures that follow in the interval are logged as a single event at the end of the interval.
+const RejectedSignatureLogInterval = time.Minute
+
+// rejectedSignatureCount with the rejected signatures of a sender since its last domain log event
+type rejectedSignatureCount struct {
+	count int       // nr of rejected signatures that are not yet logged
+	since time.Time // time of the last domain log event of the sender
+}
+
+// LogDomainEvent publishes a security relevant event on the $domainlog address of this publisher.
+// The publisher logs identity changes and conflicts, changed keys, spoofed messages and node ID changes.
+// Applications can log other events, eg revocations by a DSS.
+// Events are only published if the domainLog configuration is enabled. They are signed and not retained.
+//  subject is the address of the publisher, node or sender the event is about
+func (pub *Publisher) LogDomainEvent(event types.DomainLogEvent, severity types.DomainLogSeverity,
+	subject string, description string) error {
+
+	if !pub.config.DomainLog {
+		return nil
+	}
+	logrus.Infof("Publisher.LogDomainEvent: %s (%s) %s: %s", event, severity, subject, description)
+	message := &types.DomainLogMessage{
+		Address:     MakeDomainLogAddress(pub.Domain(), pub.PublisherID()),
+		Description: description,
+		Event:       event,
+		Sender:      identities.MakePublisherIdentityAddress(pub.Domain(), pub.PublisherID()),
+		Severity:    severity,
+		Subject:     subject,
+		Timestamp:   time.Now().Format(types.TimeFormat),
+	}
+	return pub.messageSigner.PublishObject(message.Address, false, message, nil)
+}
+
+// handleIdentityUpdate logs the renewal of this publisher's identity by the DSS
+func (pub *Publisher) handleIdentityUpdate(identity *types.PublisherFullIdentity) {
+	pub.LogDomainEvent(types.DomainLogEventIdentityChanged, types.DomainLogSeverityInfo, identity.Address,
+		fmt.Sprintf("Identity is renewed by %s", identity.Sender))
+}
+
+// handlePublisherKeyChange logs a received identity whose key differs from its pinned key and
+// notifies the application handler
+func (pub *Publisher) handlePublisherKeyChange(publisherAddress string, pinnedKey string, newKey string) {
+	pub.updateMutex.Lock()
+	handler := pub.onPublisherKeyChange
+	pub.updateMutex.Unlock()
+
+	pub.LogDomainEvent(types.DomainLogEventKeyChanged, types.DomainLogSeverityCritical, publisherAddress,
+		"Received identity has a different key than its pinned key. Identity is rejected.")
+	if handler != nil {
+		handler(publisherAddress, pinnedKey, newKey)
+	}
+}
+
+// handleRejectedSignature logs received messages that aren't signed by their claimed sender
+// Signatures that are rejected because the sender isn't known yet are not logged, as they are common
+// until the identities of the domain are received. To avoid flooding the domain log, the first rejected
+// signature of a sender is logged and the following are counted until the end of the log interval.
+func (pub *Publisher) handleRejectedSignature(sender string, result messaging.ReceiveResult, err error) {
+	if result != messaging.ReceiveResultRejectedSpoofed || !pub.config.DomainLog {
+		return
+	}
+	pub.updateMutex.Lock()
+	if rejected, found := pub.rejectedSignatures[sender]; found {
+		rejected.count++
+		pub.updateMutex.Unlock()
+		return
+	}
+	pub.rejectedSignatures[sender] = &rejectedSignatureCount{since: time.Now()}
+	pub.updateMutex.Unlock()
+
+	pub.LogDomainEvent(types.DomainLogEventRejectedSignature, types.DomainLogSeverityCritical, sender, err.Error())
+}
+
+// logAliasChange logs the change of the node ID of a node of this publisher
+func (pub *Publisher) logAliasChange(change nodes.NodeIDMappingChange) {
+	nodeAddress := nodes.MakeNodeDiscoveryAddress(pub.Domain(), pub.PublisherID(), change.NodeID)
+	pub.LogDomainEvent(types.DomainLogEventAliasChanged, types.DomainLogSeverityInfo, nodeAddress,
+		fmt.Sprintf("Node ID of node '%s' is changed from '%s' to '%s'", change.HWID, change.PreviousNodeID, change.NodeID))
+}
+
+// updateRejectedSignatures logs the rejected signatures of senders whose log interval has ended
+// Invoked by the heartbeat.
+func (pub *Publisher) updateRejectedSignatures() {
+	now := time.Now()
+	logged := make(map[string]*rejectedSignatureCount)
+	pub.updateMutex.Lock()
+	for sender, rejected := range pub.rejectedSignatures {
+		if now.Sub(rejected.since) >= RejectedSignatureLogInterval {
+			delete(pub.rejectedSignatures, sender)
+			if rejected.count > 0 {
+				logged[sender] = rejected
+			}
+		}
+	}
+	pub.updateMutex.Unlock()
+
+	for sender, rejected := range logged {
+		pub.LogDomainEvent(types.DomainLogEventRejectedSignature, types.DomainLogSeverityCritical, sender,
+			fmt.Sprintf("%d more messages with a rejected signature since %s",
+				rejected.count, rejected.since.Format(types.TimeFormat)))
+	}
+}
+
+// MakeDomainLogAddress returns the address the domain log events of a publisher are published on
+func MakeDomainLogAddress(domain string, publisherID string) string {
+	return fmt.Sprintf("%s/%s/%s", domain, publisherID, types.MessageTypeDomainLog)
+}
@@ -0,0 +1,48 @@
+// Package publisher with inputs and outputs that run external commands
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// CreateExecInput creates an input that runs a command when it receives a set command.
+// The value is appended to the arguments and passed in the IOTDOMAIN_VALUE environment variable.
+// If an input of the given nodeHWID, type and instance already exist it will be replaced.
+func (pub *Publisher) CreateExecInput(nodeHWID string, inputType types.InputType, instance string,
+	command string, args ...string) *inputs.ExecInput {
+
+	return inputs.NewExecInput(pub.inputFromSetCommands, nodeHWID, inputType, instance, command, args...)
+}
+
+// CreateExecOutput creates an output whose value is the output of a command.
+// The command is run each poll interval and its output is used as the new output value.
+func (pub *Publisher) CreateExecOutput(nodeHWID string, outputType types.OutputType, instance string,
+	command string, args ...string) *outputs.ExecOutput {
+
+	execOutput := outputs.NewExecOutput(pub.registeredOutputs, nodeHWID, outputType, instance, command, args...)
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	pub.execOutputs = append(pub.execOutputs, execOutput)
+	return execOutput
+}
+
+// PollExecOutputs runs the commands of the exec outputs and updates the output values
+// This is invoked each poll interval.
+func (pub *Publisher) PollExecOutputs() {
+	pub.updateMutex.Lock()
+	execOutputs := pub.execOutputs
+	pub.updateMutex.Unlock()
+
+	for _, execOutput := range execOutputs {
+		output := execOutput.Output()
+		value, err := execOutput.Run()
+		if err != nil {
+			logrus.Warningf("Publisher.PollExecOutputs: %s", err)
+			continue
+		}
+		pub.updateOutputValue(output.NodeHWID, output.OutputType, output.Instance, value, "")
+	}
+}
@@ -0,0 +1,171 @@
+// Package publisher with firmware updates of nodes through the firmware input
+package publisher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// MaxFirmwareSize is the max size in bytes of a firmware image that is downloaded
+const MaxFirmwareSize = 64 * 1024 * 1024
+
+// FirmwareDownloadTimeout is the max time to download a firmware image
+const FirmwareDownloadTimeout = 5 * time.Minute
+
+// FirmwareUpdateHandler is the adapter provided handler that installs a verified firmware image on a node
+//  nodeHWID is the hardware ID of the node to update
+//  descriptor describes the firmware
+//  image is the downloaded image whose hash is verified
+//  progress reports the installation progress in percent. It is published in the node status.
+// This returns an error if the installation failed
+type FirmwareUpdateHandler func(nodeHWID string, descriptor *types.FirmwareDescriptor, image []byte,
+	progress func(percent int)) error
+
+// EnableFirmwareUpdates adds the firmware input to a node. The value of a set command on the input
+// is a types.FirmwareDescriptor in JSON. The image is downloaded, its hash is verified and it is
+// passed to the handler. The progress is published in the node firmware status. Descriptors of
+// the version the node already runs, or for a group the node isn't in, are ignored. This allows
+// a staged rollout by sending the descriptor to all nodes with a group.
+// Only descriptors from senders with the admin role in the controlSenders or controlRoles configuration
+// are accepted, as the firmware replaces the software of the node.
+//  handler is invoked with the verified image
+// This returns the firmware input, or an error if the node doesn't exist
+func (pub *Publisher) EnableFirmwareUpdates(nodeHWID string, handler FirmwareUpdateHandler) (
+	*types.InputDiscoveryMessage, error) {
+
+	if pub.registeredNodes.GetNodeByHWID(nodeHWID) == nil {
+		return nil, lib.MakeErrorf("EnableFirmwareUpdates: Unknown node %s", nodeHWID)
+	}
+	input := pub.CreateInput(nodeHWID, types.InputTypeFirmware, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			if !pub.isFirmwareSenderAuthorized(sender) {
+				logrus.Warningf("Publisher.EnableFirmwareUpdates: Sender %s is not authorized to update the firmware of node %s. Ignored.",
+					sender, nodeHWID)
+				return
+			}
+			var descriptor types.FirmwareDescriptor
+			err := json.Unmarshal([]byte(value), &descriptor)
+			if err != nil || descriptor.URL == "" || descriptor.SHA256 == "" {
+				logrus.Warningf("Publisher.EnableFirmwareUpdates: Invalid firmware descriptor for node %s from %s. Ignored.",
+					nodeHWID, sender)
+				return
+			}
+			node := pub.registeredNodes.GetNodeByHWID(nodeHWID)
+			if !nodes.IsNodeInGroup(node, descriptor.Group) {
+				logrus.Infof("Publisher.EnableFirmwareUpdates: Node %s is not in rollout group '%s'. Ignored.",
+					nodeHWID, descriptor.Group)
+				return
+			} else if node.Attr[types.NodeAttrSoftwareVersion] == descriptor.Version {
+				logrus.Infof("Publisher.EnableFirmwareUpdates: Node %s already runs version %s. Ignored.",
+					nodeHWID, descriptor.Version)
+				return
+			}
+			pub.updateMutex.Lock()
+			isUpdating := pub.firmwareUpdating[nodeHWID]
+			pub.firmwareUpdating[nodeHWID] = true
+			pub.updateMutex.Unlock()
+			if isUpdating {
+				logrus.Warningf("Publisher.EnableFirmwareUpdates: Node %s is already updating. Ignored.", nodeHWID)
+				return
+			}
+			// downloading and installing can take a while
+			go pub.updateFirmware(nodeHWID, &descriptor, handler)
+		})
+	return input, nil
+}
+
+// isFirmwareSenderAuthorized returns true if the sender of a firmware descriptor has the admin role
+// Senders in controlSenders have the admin role, unless controlRoles assigns another role.
+func (pub *Publisher) isFirmwareSenderAuthorized(sender string) bool {
+	role := types.RoleNone
+	for _, controlSender := range pub.config.ControlSenders {
+		if controlSender == sender {
+			role = types.RoleAdmin
+		}
+	}
+	if senderRole, hasRole := pub.config.ControlRoles[sender]; hasRole {
+		role = senderRole
+	}
+	return lib.HasRole(role, types.RoleAdmin)
+}
+
+// updateFirmware downloads and verifies the firmware image and passes it to the handler
+// The progress and result are published in the node status.
+func (pub *Publisher) updateFirmware(nodeHWID string, descriptor *types.FirmwareDescriptor,
+	handler FirmwareUpdateHandler) {
+
+	setStatus := func(state string, percent int, errorMsg string) {
+		pub.registeredNodes.UpdateNodeStatus(nodeHWID, map[types.NodeStatus]string{
+			types.NodeStatusFirmware:    state,
+			types.NodeStatusFirmwarePct: strconv.Itoa(percent),
+			types.NodeStatusLastError:   errorMsg,
+		})
+	}
+	logrus.Infof("Publisher.updateFirmware: Updating node %s to version %s from %s",
+		nodeHWID, descriptor.Version, descriptor.URL)
+	setStatus(types.FirmwareStateDownloading, 0, "")
+	image, err := downloadFirmware(descriptor)
+	if err == nil {
+		setStatus(types.FirmwareStateInstalling, 0, "")
+		err = handler(nodeHWID, descriptor, image, func(percent int) {
+			setStatus(types.FirmwareStateInstalling, percent, "")
+		})
+		if err != nil {
+			err = lib.MakeErrorf("updateFirmware: Installing version %s on node %s failed: %s",
+				descriptor.Version, nodeHWID, err)
+		}
+	}
+	// a new update can be started once the result is known
+	pub.updateMutex.Lock()
+	delete(pub.firmwareUpdating, nodeHWID)
+	pub.updateMutex.Unlock()
+	if err != nil {
+		logrus.Errorf("Publisher.updateFirmware: %s", err)
+		setStatus(types.FirmwareStateFailed, 0, err.Error())
+		return
+	}
+	pub.registeredNodes.UpdateNodeAttr(nodeHWID, map[types.NodeAttr]string{
+		types.NodeAttrSoftwareVersion: descriptor.Version})
+	setStatus(types.FirmwareStateDone, 100, "")
+}
+
+// downloadFirmware downloads the firmware image of the descriptor and verifies its hash
+func downloadFirmware(descriptor *types.FirmwareDescriptor) ([]byte, error) {
+	imageURL, err := url.Parse(descriptor.URL)
+	if err != nil || (imageURL.Scheme != "http" && imageURL.Scheme != "https") {
+		return nil, lib.MakeErrorf("downloadFirmware: Invalid firmware URL '%s'", descriptor.URL)
+	}
+	client := &http.Client{Timeout: FirmwareDownloadTimeout}
+	resp, err := client.Get(descriptor.URL)
+	if err != nil {
+		return nil, lib.MakeErrorf("downloadFirmware: Download from %s failed: %s", descriptor.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, lib.MakeErrorf("downloadFirmware: Download from %s failed: %s", descriptor.URL, resp.Status)
+	}
+	image, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxFirmwareSize+1))
+	if err != nil {
+		return nil, lib.MakeErrorf("downloadFirmware: Download from %s failed: %s", descriptor.URL, err)
+	} else if len(image) > MaxFirmwareSize {
+		return nil, lib.MakeErrorf("downloadFirmware: Image from %s is larger than %d bytes", descriptor.URL, MaxFirmwareSize)
+	}
+	hash := sha256.Sum256(image)
+	if hex.EncodeToString(hash[:]) != strings.ToLower(descriptor.SHA256) {
+		return nil, lib.MakeErrorf("downloadFirmware: Image from %s doesn't match its hash", descriptor.URL)
+	}
+	return image, nil
+}
@@ -0,0 +1,60 @@
+// Package publisher with forecast accuracy companion outputs of outputs with forecasts
+package publisher
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// EnableForecastAccuracy creates the companion outputs with the accuracy of the past forecasts of an
+// output, with instance {instance}-mae for the mean absolute error and {instance}-bias for the mean
+// error. The accuracy is updated when new output values realize the values of past forecasts, as
+// provided with UpdateOutputForecast. Use the cacheForecasts configuration to retain the past forecasts
+// between restarts. The node must exist.
+func (pub *Publisher) EnableForecastAccuracy(nodeHWID string, outputType types.OutputType, instance string) {
+	for _, suffix := range []string{outputs.ForecastMAESuffix, outputs.ForecastBiasSuffix} {
+		output := outputs.NewOutput(pub.Domain(), pub.PublisherID(), nodeHWID, outputType, instance+suffix)
+		output.DataType = types.DataTypeNumber
+		pub.registeredOutputs.UpdateOutput(output)
+	}
+}
+
+// GetForecastAccuracy returns the accuracy of the past forecasts of a registered output
+// Returns nil if no forecast value of the output has been realized
+func (pub *Publisher) GetForecastAccuracy(nodeHWID string, outputType types.OutputType, instance string) *outputs.ForecastAccuracy {
+	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
+	return pub.registeredForecastValues.GetForecastAccuracy(outputID)
+}
+
+// updateForecastAccuracy compares the past forecasts of an output with its history and updates the
+// forecast accuracy companion outputs if they are enabled. This does nothing if no forecast value is realized.
+// A reading that repeats the previous value isn't added to the history, so the previous value is realized until now.
+//  isNewValue the reading is added to the history
+func (pub *Publisher) updateForecastAccuracy(nodeHWID string, outputType types.OutputType, instance string, isNewValue bool) {
+	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
+	history := pub.registeredOutputValues.GetHistory(outputID)
+	if !isNewValue && len(history) > 0 {
+		now := time.Now()
+		current := history[0]
+		current.EpochTime = now.Unix()
+		current.Timestamp = now.Format(types.TimeFormat)
+		history = append(outputs.OutputHistory{current}, history...)
+	}
+	accuracy := pub.registeredForecastValues.EvaluateForecast(outputID, history, time.Now())
+	if accuracy == nil {
+		return
+	}
+	companions := map[string]float64{
+		outputs.ForecastMAESuffix:  accuracy.MAE,
+		outputs.ForecastBiasSuffix: accuracy.Bias,
+	}
+	for suffix, accuracyValue := range companions {
+		companionID := outputs.MakeOutputID(nodeHWID, outputType, instance+suffix)
+		if pub.registeredOutputs.GetOutputByID(companionID) != nil {
+			pub.registeredOutputValues.UpdateOutputValue(companionID, strconv.FormatFloat(accuracyValue, 'f', -1, 64))
+		}
+	}
+}
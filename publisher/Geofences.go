@@ -0,0 +1,60 @@
+// Package publisher with location outputs and geofence alarms for asset tracking
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// GeofenceAlarmInstance is the instance of the alarm output of a node with geofences
+const GeofenceAlarmInstance = "geofence"
+
+// CreateLocationOutput creates a new location output of a node whose value is a GPS location
+// Use UpdateLocation to update its value.
+func (pub *Publisher) CreateLocationOutput(nodeHWID string, instance string) *types.OutputDiscoveryMessage {
+	output := outputs.NewOutput(pub.Domain(), pub.PublisherID(), nodeHWID, types.OutputTypeLocation, instance)
+	output.DataType = types.DataTypeLocation
+	pub.registeredOutputs.UpdateOutput(output)
+	return output
+}
+
+// SetGeofences sets the geofences of a location output of a node. When an update of the location
+// enters or leaves a geofence, the node's geofence alarm output is updated with "enter:{name}" or
+// "leave:{name}" and an $event with the node's output values is published.
+// The alarm output is created if it doesn't exist. Use nil to remove the geofences.
+func (pub *Publisher) SetGeofences(nodeHWID string, instance string, fences []outputs.Geofence) {
+	outputID := outputs.MakeOutputID(nodeHWID, types.OutputTypeLocation, instance)
+	pub.registeredGeofences.SetGeofences(outputID, fences)
+	alarm := pub.registeredOutputs.GetOutputByNodeHWID(nodeHWID, types.OutputTypeAlarm, GeofenceAlarmInstance)
+	if alarm == nil && len(fences) > 0 {
+		pub.registeredOutputs.CreateOutput(nodeHWID, types.OutputTypeAlarm, GeofenceAlarmInstance)
+	}
+}
+
+// UpdateLocation updates the value of a location output of a node and checks its geofences
+// The first location after setting the geofences determines whether it is inside without creating alarms.
+//  altitude is optional. Use 0 if unknown.
+// This returns true if the location has changed.
+func (pub *Publisher) UpdateLocation(nodeHWID string, instance string,
+	latitude float64, longitude float64, altitude float64) bool {
+
+	value := outputs.MakeLocationValue(latitude, longitude, altitude)
+	changed := pub.updateOutputValue(nodeHWID, types.OutputTypeLocation, instance, value, "")
+
+	outputID := outputs.MakeOutputID(nodeHWID, types.OutputTypeLocation, instance)
+	location := types.Location{Latitude: latitude, Longitude: longitude, Altitude: altitude}
+	events := pub.registeredGeofences.UpdateLocation(outputID, location)
+	for _, event := range events {
+		logrus.Infof("Publisher.UpdateLocation: Output %s %s geofence %s", outputID, event.Event, event.Fence)
+		pub.updateOutputValue(nodeHWID, types.OutputTypeAlarm, GeofenceAlarmInstance, event.Event+":"+event.Fence, "")
+	}
+	node := pub.registeredNodes.GetNodeByHWID(nodeHWID)
+	if len(events) > 0 && node != nil && !pub.queueWhilePaused(pendingKindEvent, nodeHWID) {
+		err := pub.PublishOutputEvent(node)
+		if err != nil {
+			logrus.Warningf("Publisher.UpdateLocation: Failed publishing geofence event of node %s: %s", nodeHWID, err)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,51 @@
+// Package publisher with the standard identify input for locating a device
+package publisher
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultIdentifyDuration is the nr of seconds a device identifies itself if no duration is given
+const DefaultIdentifyDuration = 10
+
+// IdentifyHandler is the adapter provided handler that makes a device identify itself, eg by
+// blinking a LED or making a beep.
+//  nodeHWID is the hardware ID of the node to identify
+//  sender is the address of the publisher that requested the identification
+//  duration is the nr of seconds to identify
+type IdentifyHandler func(nodeHWID string, sender string, duration int)
+
+// EnableIdentify adds the standard identify input to a node and marks the node as identifiable in
+// its discovery attributes so UIs can show an identify button. The value of a set command on the
+// input is the duration in seconds, or empty for DefaultIdentifyDuration.
+//  handler is invoked when a valid identify command is received
+// This returns the identify input, or an error if the node doesn't exist
+func (pub *Publisher) EnableIdentify(nodeHWID string, handler IdentifyHandler) (*types.InputDiscoveryMessage, error) {
+	if pub.registeredNodes.GetNodeByHWID(nodeHWID) == nil {
+		return nil, lib.MakeErrorf("EnableIdentify: Unknown node %s", nodeHWID)
+	}
+	input := pub.CreateInput(nodeHWID, types.InputTypeIdentify, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			duration := DefaultIdentifyDuration
+			value = strings.TrimSpace(value)
+			if value != "" {
+				var err error
+				duration, err = strconv.Atoi(value)
+				if err != nil || duration <= 0 {
+					logrus.Warningf("Publisher.EnableIdentify: Invalid duration '%s' for node %s from %s. Ignored.",
+						value, nodeHWID, sender)
+					return
+				}
+			}
+			logrus.Infof("Publisher.EnableIdentify: Identify node %s for %d seconds, requested by %s",
+				nodeHWID, duration, sender)
+			handler(nodeHWID, sender, duration)
+		})
+	pub.registeredNodes.UpdateNodeAttr(nodeHWID, map[types.NodeAttr]string{types.NodeAttrIdentify: "true"})
+	return input, nil
+}
@@ -0,0 +1,79 @@
+// Package publisher with detection of other publishers that use the same publisher ID
+package publisher
+
+import (
+	"fmt"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// HasIdentityConflict returns true if an identity was received for this publisher's address that
+// has a different key. This means another publisher runs with the same publisher ID.
+func (pub *Publisher) HasIdentityConflict() bool {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	return pub.identityConflict
+}
+
+// IdentityClaimError returns the error that another publisher holds the claim to this publisher's ID,
+// or nil if this publisher holds the claim. See identities.ArbitrateIdentity for the arbitration.
+// When the claim is lost the publisher backs off and no longer publishes its updates and status.
+func (pub *Publisher) IdentityClaimError() error {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	return pub.identityClaimError
+}
+
+// SetOnIdentityConflict sets the handler that is invoked when an identity is received for this
+// publisher's address that has a different key. Intended to raise a security alert.
+// Use nil to remove the handler.
+func (pub *Publisher) SetOnIdentityConflict(handler identities.IdentityConflictHandler) {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	pub.onIdentityConflict = handler
+}
+
+// handleIdentityConflict arbitrates the claim to the publisher ID with the conflicting identity.
+// If the other publisher holds the claim then this publisher backs off by stopping its publications.
+// Otherwise this publisher republishes its identity to reclaim the retained identity, and raises the
+// identity conflict alert in the publisher status. If configured with stopOnConflict, the publication of
+// updates is stopped as well to avoid two publishers overwriting each other's retained publications.
+func (pub *Publisher) handleIdentityConflict(identity *types.PublisherIdentityMessage) {
+	myIdent := pub.GetIdentity()
+	hasClaim := identities.ArbitrateIdentity(myIdent, identity)
+
+	pub.updateMutex.Lock()
+	isNewConflict := !pub.identityConflict
+	pub.identityConflict = true
+	if !hasClaim {
+		pub.identityClaimError = lib.MakeErrorf(
+			"Another publisher holds the claim to publisher ID %s. Publications are stopped.", pub.PublisherID())
+	}
+	handler := pub.onIdentityConflict
+	pub.updateMutex.Unlock()
+
+	if !hasClaim {
+		logrus.Errorf("Publisher.handleIdentityConflict: Another publisher with a different key holds the claim to publisher ID %s. Backing off.",
+			pub.PublisherID())
+		pub.Pause(PausePolicyDrop)
+	} else {
+		logrus.Errorf("Publisher.handleIdentityConflict: Another publisher uses publisher ID %s with a different key. Reclaiming the ID.",
+			pub.PublisherID())
+		if isNewConflict {
+			// raise the alert before the publications are stopped
+			pub.SetPublisherStatus(types.PublisherRunStateConnected)
+			if pub.config.StopOnIdentityConflict {
+				pub.Pause(PausePolicyDrop)
+			}
+		}
+		identities.PublishIdentity(myIdent, pub.messageSigner)
+	}
+	pub.LogDomainEvent(types.DomainLogEventIdentityConflict, types.DomainLogSeverityCritical, identity.Address,
+		fmt.Sprintf("Another publisher uses publisher ID %s with a different key", pub.PublisherID()))
+	if handler != nil {
+		handler(identity)
+	}
+}
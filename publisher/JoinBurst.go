@@ -0,0 +1,58 @@
+// Package publisher with republication of discovery when other publishers join the domain
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// handlePublisherJoin republishes the discovery of this publisher when another publisher joins, that is
+// when an unknown publisher or a publisher with a new key is received. Republished identities don't
+// trigger a republication, so publishers don't keep triggering each other.
+// Consumers on brokers without retained messages otherwise don't receive the discovery until the
+// nodes, inputs and outputs are updated. The republication is rate-limited by the republishOnJoin
+// interval. A publisher that joins within the interval is handled when the interval has passed.
+func (pub *Publisher) handlePublisherJoin(identity *types.PublisherIdentityMessage) {
+	if identity.Address == pub.GetIdentity().Address {
+		return
+	}
+	pub.updateMutex.Lock()
+	burstNow := pub.joinBurstCountdown <= 0
+	if burstNow {
+		pub.joinBurstCountdown = pub.config.RepublishOnJoin
+	} else {
+		pub.joinBurstPending = true
+	}
+	pub.updateMutex.Unlock()
+	if burstNow {
+		logrus.Infof("Publisher.handlePublisherJoin: Publisher %s joined", identity.Address)
+		pub.republishDiscovery()
+	}
+}
+
+// republishDiscovery republishes the discovery unless publications are paused
+func (pub *Publisher) republishDiscovery() {
+	if pub.IsPaused() || pub.IdentityClaimError() != nil {
+		logrus.Infof("Publisher.republishDiscovery: Publications are stopped. Discovery is not republished")
+		return
+	}
+	pub.RepublishAll()
+}
+
+// updateJoinBurst counts down the rate-limit interval each heartbeat and republishes the discovery
+// if publishers joined during the interval
+func (pub *Publisher) updateJoinBurst() {
+	pub.updateMutex.Lock()
+	if pub.joinBurstCountdown > 0 {
+		pub.joinBurstCountdown--
+	}
+	burstNow := pub.joinBurstCountdown <= 0 && pub.joinBurstPending
+	if burstNow {
+		pub.joinBurstPending = false
+		pub.joinBurstCountdown = pub.config.RepublishOnJoin
+	}
+	pub.updateMutex.Unlock()
+	if burstNow {
+		pub.republishDiscovery()
+	}
+}
@@ -0,0 +1,49 @@
+// Package publisher with locating the message bus server on the LAN for zero-config installs
+package publisher
+
+import (
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/zeroconf"
+	"github.com/sirupsen/logrus"
+)
+
+// LocateMessengerServer locates the message bus server with mDNS and updates the messenger
+// configuration. An advertised MQTT broker is used if found, otherwise the broker is assumed to
+// run on the host of the advertised DSS. The port and domain are only set if they are not configured.
+//  messengerConfig to update with the located server
+//  timeout is the max time to browse for each service. Use 0 for zeroconf.DefaultBrowseTimeout.
+// This returns an error if neither a broker nor the DSS was found.
+func LocateMessengerServer(messengerConfig *messaging.MessengerConfig, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = zeroconf.DefaultBrowseTimeout
+	}
+	broker, err := zeroconf.LocateBroker(timeout)
+	if err != nil {
+		return err
+	}
+	if broker != nil {
+		messengerConfig.Server = broker.Address()
+		if messengerConfig.Port == 0 && broker.Port != 0 {
+			messengerConfig.Port = uint16(broker.Port)
+		}
+		logrus.Infof("LocateMessengerServer: Located broker '%s' at %s:%d",
+			broker.Instance, messengerConfig.Server, broker.Port)
+		return nil
+	}
+	dss, err := zeroconf.LocateDSS(timeout)
+	if err != nil {
+		return err
+	} else if dss == nil {
+		return lib.MakeErrorf("LocateMessengerServer: No broker or DSS found on the local network")
+	}
+	messengerConfig.Server = dss.Address()
+	if messengerConfig.Domain == "" {
+		messengerConfig.Domain = dss.Text[zeroconf.TextDomain]
+	}
+	logrus.Infof("LocateMessengerServer: Located DSS of domain '%s' at %s",
+		dss.Text[zeroconf.TextDomain], messengerConfig.Server)
+	return nil
+}
@@ -0,0 +1,57 @@
+// Package publisher with migration of publications after a change of publisher identity address
+package publisher
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// MigrateIdentity moves the publications of this publisher from the address of its previous identity
+// to the address of its current identity, after the domain or publisherID has changed.
+// This clears the retained identity, status and discovery publications on the old addresses so renames
+// don't leave ghost nodes, and republishes the identity, nodes, inputs and outputs on the new addresses.
+// Nodes loaded from a file saved under the old identity get the new address.
+//  oldIdentity is the previous identity of this publisher. Only its domain and publisherID are used.
+// The publisher must be started. This returns an error if the old identity address is the current address.
+func (pub *Publisher) MigrateIdentity(oldIdentity *types.PublisherIdentityMessage) error {
+	domain := pub.Domain()
+	publisherID := pub.PublisherID()
+	if oldIdentity == nil || oldIdentity.Domain == "" || oldIdentity.PublisherID == "" {
+		return lib.MakeErrorf("MigrateIdentity: Missing domain or publisherID of the old identity")
+	} else if oldIdentity.Domain == domain && oldIdentity.PublisherID == publisherID {
+		return lib.MakeErrorf("MigrateIdentity: Old identity %s/%s is the current identity", domain, publisherID)
+	}
+	logrus.Warningf("Publisher.MigrateIdentity: Migrating publications from %s/%s to %s/%s",
+		oldIdentity.Domain, oldIdentity.PublisherID, domain, publisherID)
+
+	for _, address := range pub.retainedAddresses() {
+		oldAddress := migrateAddress(address, oldIdentity.Domain, oldIdentity.PublisherID)
+		err := pub.messageSigner.ClearRetained(oldAddress)
+		if err != nil {
+			return lib.MakeErrorf("MigrateIdentity: Failed clearing retained publication on %s: %s", oldAddress, err)
+		}
+	}
+
+	pub.registeredNodes.SetPublisher(domain, publisherID)
+	pub.RepublishAll()
+	pub.SetPublisherStatus(types.PublisherRunStateConnected)
+	pub.LogDomainEvent(types.DomainLogEventIdentityChanged, types.DomainLogSeverityInfo, oldIdentity.Address,
+		fmt.Sprintf("Publisher %s/%s is migrated to %s/%s", oldIdentity.Domain, oldIdentity.PublisherID, domain, publisherID))
+	return nil
+}
+
+// migrateAddress replaces the domain and publisherID of a publication address
+//  address is of the form domain/publisherID/nodeID/...
+func migrateAddress(address string, domain string, publisherID string) string {
+	segments := strings.Split(address, "/")
+	if len(segments) < 3 {
+		return address
+	}
+	segments[0] = domain
+	segments[1] = publisherID
+	return strings.Join(segments, "/")
+}
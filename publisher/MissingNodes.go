@@ -0,0 +1,72 @@
+// Package publisher with detection of cached nodes that are no longer discovered
+package publisher
+
+import (
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultMissingNodeGrace is the default time in seconds after the first discovery run before
+// cached nodes that weren't rediscovered are marked as missing
+const DefaultMissingNodeGrace = 60
+
+// CheckMissingNodes marks the nodes that were loaded from cache but weren't rediscovered as missing.
+// Nodes that are missing longer than the configured RemoveMissingDays are deleted.
+// This is invoked automatically after the first discovery run and the grace period.
+// The time a node was last seen is kept in its lastSeen status.
+// This returns the nodes that are missing, including the ones that were deleted.
+func (pub *Publisher) CheckMissingNodes() (missing []*types.NodeDiscoveryMessage) {
+	missing = pub.registeredNodes.GetMissingNodes()
+	now := time.Now()
+	for _, node := range missing {
+		lastSeen := node.Status[types.NodeStatusLastSeen]
+		if lastSeen == "" {
+			// the node was last updated before it was saved
+			lastSeen = node.Timestamp
+		}
+		pub.registeredNodes.UpdateNodeStatus(node.HWID, map[types.NodeStatus]string{
+			types.NodeStatusRunState: types.NodeRunStateMissing,
+			types.NodeStatusLastSeen: lastSeen,
+		})
+		lastSeenTime, err := time.Parse(types.TimeFormat, lastSeen)
+		removeAfter := time.Duration(pub.config.RemoveMissingDays) * 24 * time.Hour
+		if pub.config.RemoveMissingDays > 0 && err == nil && now.Sub(lastSeenTime) > removeAfter {
+			logrus.Warningf("Publisher.CheckMissingNodes: Node %s is missing since %s. Removing it.", node.HWID, lastSeen)
+			pub.DeleteNode(node.HWID)
+		} else {
+			logrus.Warningf("Publisher.CheckMissingNodes: Node %s is missing since %s", node.HWID, lastSeen)
+		}
+	}
+	return missing
+}
+
+// scheduleMissingCheck schedules the check for missing nodes after the first discovery run
+func (pub *Publisher) scheduleMissingCheck() {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	if pub.missingCheckScheduled {
+		return
+	}
+	pub.missingCheckScheduled = true
+	pub.missingCheckCountdown = pub.config.MissingNodeGrace
+	if pub.missingCheckCountdown <= 0 {
+		pub.missingCheckCountdown = DefaultMissingNodeGrace
+	}
+}
+
+// updateMissingCheck counts down the grace period and checks for missing nodes when it expires
+// Invoked each heartbeat.
+func (pub *Publisher) updateMissingCheck() {
+	pub.updateMutex.Lock()
+	checkNow := false
+	if pub.missingCheckCountdown > 0 {
+		pub.missingCheckCountdown--
+		checkNow = pub.missingCheckCountdown == 0
+	}
+	pub.updateMutex.Unlock()
+	if checkNow {
+		pub.CheckMissingNodes()
+	}
+}
@@ -1,52 +1,142 @@
 package publisher
 
 import (
+	"os"
+	"path/filepath"
+
+	"github.com/iotdomain/iotdomain-go/identities"
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
 )
 
 // NewAppPublisher function for all the boilerplate. This:
-//  1. Loads messenger config and create messenger instance
+//  1. Loads messenger config and create messenger instance. Without server the broker is located with mDNS.
 //  2. Load PublisherConfig from <appID>.yaml
 //  3. Load appconfig from <appID>.yaml (yes same file)
-//  4. Create a publisher using the domain from messenger config and publisherID from <appID>.yaml
-//  5. Set to persist nodes and load previously saved nodes
+//  4. Validate the configuration. Unknown keys are logged as warnings.
+//  5. Create a publisher using the domain from messenger config and publisherID from <appID>.yaml
+//  6. Set to persist nodes and load previously saved nodes
 //
 //  - appID is the application ID, used as publisher ID unless overridden in <appID>.yaml.
 //  - configFolder contains the identity, messenger and application configuration
-//     Use "" for default location (~/.config/iotdomain).
+//     Use "" for default location, lib.DefaultConfigFolder (~/.config/iotdomain on Linux).
 //  - cacheFolder contains the saved discovered nodes and publishers files.
-//     Use "" for default location (~/.cache/iotdomain).
+//     Use "" for default location, lib.DefaultCacheFolder (~/.cache/iotdomain on Linux).
 //  - appConfig optional application object to load <appID>.yaml configuration into
 //  - cacheDiscovery loads and saves discovered publisher identities and nodes from cache
 //
-// This returns publisher instance or error if messenger fails to load
+// This returns publisher instance or error if messenger fails to load or the server can't be located.
+// If the configuration is invalid then no publisher is created and a lib.ConfigError is returned with
+// all problems found, before anything connects.
 func NewAppPublisher(appID string, configFolder string, appConfig interface{},
 	cacheFolder string, cacheDiscovery bool) (*Publisher, error) {
 
+	if cacheFolder == "" {
+		cacheFolder = lib.DefaultCacheFolder
+	}
 	// 1: load messenger config shared with other publishers
 	var messengerConfig = messaging.MessengerConfig{}
 	err := lib.LoadMessengerConfig(configFolder, &messengerConfig)
-	messenger := messaging.NewMessenger(&messengerConfig)
+	if err == nil {
+		warnUnknownYamlKeys(configFolder, lib.MessengerConfigFile, &messengerConfig)
+	}
 
 	// 2: load Publisher config fields from appconfig
 	pubConfig := &PublisherConfig{
 		SaveDiscoveredNodes:      cacheDiscovery,
 		SaveDiscoveredPublishers: cacheDiscovery,
 		ConfigFolder:             configFolder,
-		CacheFolder:              lib.DefaultCacheFolder,
+		CacheFolder:              cacheFolder,
 		Loglevel:                 "warning",
 		Domain:                   messengerConfig.Domain,
 		PublisherID:              appID,
 	}
-	lib.LoadAppConfig(configFolder, appID, &pubConfig)
+	appConfigFile := appID + lib.AppConfigSuffix
+	configErr := &lib.ConfigError{Filename: appConfigFile}
+	loadErr := lib.LoadAppConfig(configFolder, appID, &pubConfig)
+	if loadErr != nil && !os.IsNotExist(loadErr) {
+		configErr.Add("%s", loadErr)
+	}
 
 	// 3: load application configuration itself
 	if appConfig != nil {
-		lib.LoadAppConfig(configFolder, appID, appConfig)
+		loadErr = lib.LoadAppConfig(configFolder, appID, appConfig)
+		if loadErr != nil && !os.IsNotExist(loadErr) {
+			configErr.Add("application configuration: %s", loadErr)
+		}
+		warnUnknownYamlKeys(configFolder, appConfigFile, pubConfig, appConfig)
+	} else {
+		warnUnknownYamlKeys(configFolder, appConfigFile, pubConfig)
+	}
+
+	// 4: report all configuration problems at once, before anything connects
+	if validationErr := pubConfig.Validate(); validationErr != nil {
+		configErr.Problems = append(configErr.Problems, validationErr.(*lib.ConfigError).Problems...)
+	}
+	if len(configErr.Problems) > 0 {
+		logrus.Errorf("NewAppPublisher: %s", configErr)
+		return nil, configErr
+	}
+
+	// zero-config LAN installs leave the server empty and locate it with mDNS
+	// The domain advertised by the DSS applies unless the publisher configures its own.
+	if err == nil && messengerConfig.Server == "" {
+		err = LocateMessengerServer(&messengerConfig, 0)
+		if pubConfig.Domain == "" {
+			pubConfig.Domain = messengerConfig.Domain
+		}
 	}
-	// 4: create the publisher. Reload its identity if available.
+	messenger := messaging.NewMessenger(&messengerConfig)
+
+	// 5: create the publisher. Reload its identity if available.
 	pub := NewPublisher(pubConfig, messenger)
 
 	return pub, err
 }
+
+// warnUnknownYamlKeys logs a warning for each key in a configuration file that isn't used by any of
+// the targets it is loaded into, as these are likely typos
+func warnUnknownYamlKeys(configFolder string, filename string, targets ...interface{}) {
+	unknownKeys, err := lib.FindUnknownYamlKeys(configFolder, filename, targets...)
+	if err != nil {
+		return
+	}
+	for _, unknownKey := range unknownKeys {
+		logrus.Warningf("NewAppPublisher: Configuration %s has an %s", filename, unknownKey)
+	}
+}
+
+// InitAppConfig generates the configuration of a new publisher in the config folder. This writes the
+// template messenger.yaml and <appID>.yaml with commented defaults and a fresh identity, simplifying
+// the bootstrap of a publisher in a container. Existing files are kept so this is safe to run on
+// every start.
+//  - appID is the application ID, used as publisher ID
+//  - configFolder to write the files to. Use "" for default location, lib.DefaultConfigFolder.
+//  - domain of the publisher. Use "" for the local domain.
+//
+// This returns the paths of the files that were written.
+func InitAppConfig(appID string, configFolder string, domain string) (written []string, err error) {
+	if configFolder == "" {
+		configFolder = lib.DefaultConfigFolder
+	}
+	if domain == "" {
+		domain = types.LocalDomainID
+	}
+	written, err = lib.WriteConfigTemplates(configFolder, appID, domain)
+	if err != nil {
+		return written, err
+	}
+	identityFile := filepath.Join(configFolder, appID+RegisteredIdentityFileSuffix)
+	if _, err = os.Stat(identityFile); err == nil {
+		return written, nil
+	}
+	registeredIdentity := identities.NewRegisteredIdentity(domain, appID, identityFile)
+	err = registeredIdentity.SaveIdentity()
+	if err != nil {
+		return written, err
+	}
+	written = append(written, identityFile)
+	return written, nil
+}
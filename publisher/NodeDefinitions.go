@@ -0,0 +1,264 @@
+// Package publisher with export and import of registered node definitions in YAML
+package publisher
+
+import (
+	"io/ioutil"
+	"sort"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// InOutputDefinition with the definition of a node input or output
+type InOutputDefinition struct {
+	Attr       types.NodeAttrMap   `yaml:"attr,omitempty"`       // attributes describing the input or output
+	Config     types.ConfigAttrMap `yaml:"config,omitempty"`     // configuration attributes
+	DataType   types.DataType      `yaml:"dataType,omitempty"`   // value data type
+	EnumValues types.EnumValues    `yaml:"enumValues,omitempty"` // valid values of enum data types
+	Instance   string              `yaml:"instance"`             // instance of the input or output
+	Max        float32             `yaml:"max,omitempty"`        // max value of numeric data types
+	Min        float32             `yaml:"min,omitempty"`        // min value of numeric data types
+	Type       string              `yaml:"type"`                 // input or output type
+	Unit       types.Unit          `yaml:"unit,omitempty"`       // unit of the value
+}
+
+// NodeDefinition with the definition of a registered node and its inputs and outputs
+type NodeDefinition struct {
+	Attr    types.NodeAttrMap    `yaml:"attr,omitempty"`    // node attributes, including its type
+	Config  types.ConfigAttrMap  `yaml:"config,omitempty"`  // configuration attributes
+	HWID    string               `yaml:"hwId"`              // hardware ID of the node
+	Inputs  []InOutputDefinition `yaml:"inputs,omitempty"`  // inputs of the node
+	NodeID  string               `yaml:"nodeId,omitempty"`  // node ID in the address. Default is the hardware ID
+	Outputs []InOutputDefinition `yaml:"outputs,omitempty"` // outputs of the node
+}
+
+// NodeDefinitions is the YAML document with the definitions of registered nodes
+type NodeDefinitions struct {
+	Nodes []NodeDefinition `yaml:"nodes"`
+}
+
+// nodeType returns the type attribute of the node definition or types.NodeTypeUnknown if it isn't set
+func (nodeDef *NodeDefinition) nodeType() types.NodeType {
+	nodeType := types.NodeType(nodeDef.Attr[types.NodeAttrType])
+	if nodeType == "" {
+		nodeType = types.NodeTypeUnknown
+	}
+	return nodeType
+}
+
+// ApplyNodeDefinitions creates or updates the registered nodes, inputs and outputs of the definitions.
+// Attributes and configuration are merged with those of existing nodes, inputs and outputs. Other fields
+// of existing inputs and outputs are replaced if they are set in the definition. Inputs that don't exist
+// are created without a set command handler. Use CreateInput to set the handler.
+// This returns an error, without applying any definition, if a definition lacks its hwId or the type of
+// an input or output.
+func (pub *Publisher) ApplyNodeDefinitions(definitions *NodeDefinitions) error {
+	err := validateNodeDefinitions(definitions)
+	if err != nil {
+		return err
+	}
+	for _, nodeDef := range definitions.Nodes {
+		pub.applyNodeDefinition(&nodeDef)
+	}
+	logrus.Infof("Publisher.ApplyNodeDefinitions: Applied the definitions of %d nodes", len(definitions.Nodes))
+	return nil
+}
+
+// ExportNodeDefinitions writes the definitions of the registered nodes to a YAML file
+func (pub *Publisher) ExportNodeDefinitions(filename string) error {
+	yamlText, err := yaml.Marshal(pub.GetNodeDefinitions())
+	if err != nil {
+		return lib.MakeErrorf("ExportNodeDefinitions: Error marshalling node definitions: %s", err)
+	}
+	err = ioutil.WriteFile(filename, yamlText, 0664)
+	if err != nil {
+		return lib.MakeErrorf("ExportNodeDefinitions: Error writing node definitions to %s: %s", filename, err)
+	}
+	return nil
+}
+
+// GetNodeDefinitions returns the definitions of the registered nodes and their inputs and outputs
+// Nodes are sorted by hardware ID and inputs and outputs by type and instance.
+func (pub *Publisher) GetNodeDefinitions() *NodeDefinitions {
+	definitions := &NodeDefinitions{Nodes: make([]NodeDefinition, 0)}
+	for _, node := range pub.registeredNodes.GetAllNodes() {
+		nodeDef := NodeDefinition{
+			Attr:   node.Attr,
+			Config: node.Config,
+			HWID:   node.HWID,
+		}
+		if node.NodeID != node.HWID {
+			nodeDef.NodeID = node.NodeID
+		}
+		for _, input := range pub.registeredInputs.GetInputsByNodeHWID(node.HWID) {
+			nodeDef.Inputs = append(nodeDef.Inputs, InOutputDefinition{
+				Attr: input.Attr, Config: input.Config, DataType: input.DataType, EnumValues: input.EnumValues,
+				Instance: input.Instance, Max: input.Max, Min: input.Min, Type: string(input.InputType), Unit: input.Unit,
+			})
+		}
+		for _, output := range pub.registeredOutputs.GetOutputsByNodeHWID(node.HWID) {
+			nodeDef.Outputs = append(nodeDef.Outputs, InOutputDefinition{
+				Attr: output.Attr, Config: output.Config, DataType: output.DataType, EnumValues: output.EnumValues,
+				Instance: output.Instance, Max: output.Max, Min: output.Min, Type: string(output.OutputType), Unit: output.Unit,
+			})
+		}
+		sortInOutputDefinitions(nodeDef.Inputs)
+		sortInOutputDefinitions(nodeDef.Outputs)
+		definitions.Nodes = append(definitions.Nodes, nodeDef)
+	}
+	sort.Slice(definitions.Nodes, func(i, j int) bool {
+		return definitions.Nodes[i].HWID < definitions.Nodes[j].HWID
+	})
+	return definitions
+}
+
+// ImportNodeDefinitions reads the definitions of nodes from a YAML file and applies them
+// See ApplyNodeDefinitions for details.
+func (pub *Publisher) ImportNodeDefinitions(filename string) error {
+	definitions, err := readNodeDefinitions(filename)
+	if err != nil {
+		return err
+	}
+	return pub.ApplyNodeDefinitions(definitions)
+}
+
+// applyInOutputDefinitions creates or updates the inputs and outputs of a node definition
+func (pub *Publisher) applyInOutputDefinitions(nodeDef *NodeDefinition) {
+	for _, inputDef := range nodeDef.Inputs {
+		inputType := types.InputType(inputDef.Type)
+		input := pub.registeredInputs.GetInputByNodeHWID(nodeDef.HWID, inputType, inputDef.Instance)
+		if input == nil {
+			input = pub.registeredInputs.CreateInput(nodeDef.HWID, inputType, inputDef.Instance, nil)
+		}
+		newInput := *input
+		newInput.Attr = mergeAttr(input.Attr, inputDef.Attr)
+		newInput.Config = mergeConfig(input.Config, inputDef.Config)
+		applyValueDefinition(&inputDef, &newInput.DataType, &newInput.EnumValues, &newInput.Max, &newInput.Min, &newInput.Unit)
+		pub.registeredInputs.UpdateInput(&newInput)
+	}
+	for _, outputDef := range nodeDef.Outputs {
+		outputType := types.OutputType(outputDef.Type)
+		output := pub.registeredOutputs.GetOutputByNodeHWID(nodeDef.HWID, outputType, outputDef.Instance)
+		if output == nil {
+			output = pub.registeredOutputs.CreateOutput(nodeDef.HWID, outputType, outputDef.Instance)
+		}
+		newOutput := *output
+		newOutput.Attr = mergeAttr(output.Attr, outputDef.Attr)
+		newOutput.Config = mergeConfig(output.Config, outputDef.Config)
+		applyValueDefinition(&outputDef, &newOutput.DataType, &newOutput.EnumValues, &newOutput.Max, &newOutput.Min, &newOutput.Unit)
+		pub.registeredOutputs.UpdateOutput(&newOutput)
+	}
+}
+
+// applyNodeConfig updates the configuration and node ID of a node definition
+// The node ID changes the address of existing inputs and outputs so apply it after creating them.
+func (pub *Publisher) applyNodeConfig(nodeDef *NodeDefinition) {
+	for attrName, configAttr := range nodeDef.Config {
+		configCopy := configAttr
+		pub.registeredNodes.UpdateNodeConfig(nodeDef.HWID, attrName, &configCopy)
+	}
+	node := pub.registeredNodes.GetNodeByHWID(nodeDef.HWID)
+	if node != nil && nodeDef.NodeID != "" && nodeDef.NodeID != node.NodeID {
+		pub.registeredNodes.SetNodeID(node, nodeDef.NodeID)
+		pub.registeredInputs.SetNodeID(nodeDef.HWID, nodeDef.NodeID)
+		pub.registeredOutputs.SetNodeID(nodeDef.HWID, nodeDef.NodeID)
+	}
+}
+
+// applyNodeDefinition creates or updates a node and its inputs and outputs
+func (pub *Publisher) applyNodeDefinition(nodeDef *NodeDefinition) {
+	if pub.registeredNodes.GetNodeByHWID(nodeDef.HWID) == nil {
+		pub.registeredNodes.CreateNode(nodeDef.HWID, nodeDef.nodeType())
+	}
+	pub.registeredNodes.UpdateNodeAttr(nodeDef.HWID, nodeDef.Attr)
+	pub.applyInOutputDefinitions(nodeDef)
+	pub.applyNodeConfig(nodeDef)
+}
+
+// applyValueDefinition replaces the value fields of an input or output that are set in the definition
+func applyValueDefinition(ioDef *InOutputDefinition,
+	dataType *types.DataType, enumValues *types.EnumValues, max *float32, min *float32, unit *types.Unit) {
+	if ioDef.DataType != "" {
+		*dataType = ioDef.DataType
+	}
+	if len(ioDef.EnumValues) > 0 {
+		*enumValues = ioDef.EnumValues
+	}
+	if ioDef.Max != 0 {
+		*max = ioDef.Max
+	}
+	if ioDef.Min != 0 {
+		*min = ioDef.Min
+	}
+	if ioDef.Unit != "" {
+		*unit = ioDef.Unit
+	}
+}
+
+// mergeAttr returns a new attribute map with the attributes of both maps. Updates take precedence.
+func mergeAttr(attr types.NodeAttrMap, updates types.NodeAttrMap) types.NodeAttrMap {
+	merged := types.NodeAttrMap{}
+	for key, value := range attr {
+		merged[key] = value
+	}
+	for key, value := range updates {
+		merged[key] = value
+	}
+	return merged
+}
+
+// mergeConfig returns a new configuration map with the configuration of both maps. Updates take precedence.
+func mergeConfig(config types.ConfigAttrMap, updates types.ConfigAttrMap) types.ConfigAttrMap {
+	if len(config) == 0 && len(updates) == 0 {
+		return config
+	}
+	merged := types.ConfigAttrMap{}
+	for key, value := range config {
+		merged[key] = value
+	}
+	for key, value := range updates {
+		merged[key] = value
+	}
+	return merged
+}
+
+// readNodeDefinitions reads the definitions of nodes from a YAML file
+func readNodeDefinitions(filename string) (*NodeDefinitions, error) {
+	yamlText, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, lib.MakeErrorf("readNodeDefinitions: Unable to read node definitions from %s: %s", filename, err)
+	}
+	definitions := &NodeDefinitions{}
+	err = yaml.Unmarshal(yamlText, definitions)
+	if err != nil {
+		return nil, lib.MakeErrorf("readNodeDefinitions: Invalid node definitions in %s: %s", filename, err)
+	}
+	return definitions, nil
+}
+
+// sortInOutputDefinitions sorts the definitions by type and instance
+func sortInOutputDefinitions(definitions []InOutputDefinition) {
+	sort.Slice(definitions, func(i, j int) bool {
+		if definitions[i].Type != definitions[j].Type {
+			return definitions[i].Type < definitions[j].Type
+		}
+		return definitions[i].Instance < definitions[j].Instance
+	})
+}
+
+// validateNodeDefinitions returns an error if a definition lacks its hwId or the type of an input or output
+func validateNodeDefinitions(definitions *NodeDefinitions) error {
+	for _, nodeDef := range definitions.Nodes {
+		if nodeDef.HWID == "" {
+			return lib.MakeErrorf("validateNodeDefinitions: Node definition without hwId")
+		}
+		for _, ioDef := range append(append([]InOutputDefinition{}, nodeDef.Inputs...), nodeDef.Outputs...) {
+			if ioDef.Type == "" {
+				return lib.MakeErrorf("validateNodeDefinitions: Input or output of node '%s' without type", nodeDef.HWID)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Package publisher with twins of registered nodes that track their desired and reported properties
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// GetNodeTwin returns a copy of the twin of a registered node with its desired and reported properties,
+// or nil if the node has no twin
+func (pub *Publisher) GetNodeTwin(nodeHWID string) *types.NodeTwinMessage {
+	return pub.registeredNodeTwins.GetTwin(nodeHWID)
+}
+
+// GetPendingProperties returns the desired properties of a node twin that are not yet reported by the device
+func (pub *Publisher) GetPendingProperties(nodeHWID string) map[string]string {
+	return pub.registeredNodeTwins.GetPending(nodeHWID)
+}
+
+// PublishSetDesired publishes the command to change the desired properties of a remote node twin
+// The command is signed and encrypted for the publisher of the node.
+//  desired holds the properties to change. An empty value removes the desired property.
+func (pub *Publisher) PublishSetDesired(nodeAddr string, desired map[string]string) error {
+	destPubKey := pub.GetPublisherKey(nodeAddr)
+	if destPubKey == nil {
+		return lib.MakeErrorf("PublishSetDesired: no public key found to encrypt command for %s"+
+			". Message not sent.", nodeAddr)
+	}
+	return nodes.PublishSetDesired(nodeAddr, desired, pub.Address(), pub.messageSigner, destPubKey)
+}
+
+// SetDesiredProperties changes the desired properties of the twin of a registered node, as if they were
+// set remotely with $setDesired. The handler of SetOnDesiredProperties is notified of the changes.
+//  desired holds the properties to change. An empty value removes the desired property.
+// This returns the changed properties or an error if the node doesn't exist.
+func (pub *Publisher) SetDesiredProperties(nodeHWID string, desired map[string]string) (map[string]string, error) {
+	if pub.registeredNodes.GetNodeByHWID(nodeHWID) == nil {
+		return nil, lib.MakeErrorf("SetDesiredProperties: Node '%s' doesn't exist", nodeHWID)
+	}
+	return pub.registeredNodeTwins.SetDesired(nodeHWID, desired), nil
+}
+
+// SetOnDesiredProperties sets the handler that is invoked when the desired properties of a node twin
+// change. The handler applies the properties to the device and reports them with SetReportedProperties.
+// Use nil to remove the handler.
+func (pub *Publisher) SetOnDesiredProperties(handler nodes.DesiredHandler) {
+	pub.registeredNodeTwins.SetOnDesired(handler)
+}
+
+// SetOnTwinConverged sets the handler that is invoked when the reported properties of a node twin match
+// its desired properties after they changed. Use nil to remove the handler.
+func (pub *Publisher) SetOnTwinConverged(handler nodes.ConvergedHandler) {
+	pub.registeredNodeTwins.SetOnConverged(handler)
+}
+
+// SetReportedProperties changes the properties of a node twin that are reported by the device
+// The twin is published on $twin with the next update when the reported properties change.
+//  reported holds the reported properties to change. An empty value removes the reported property.
+// This returns an error if the node doesn't exist.
+func (pub *Publisher) SetReportedProperties(nodeHWID string, reported map[string]string) error {
+	if pub.registeredNodes.GetNodeByHWID(nodeHWID) == nil {
+		return lib.MakeErrorf("SetReportedProperties: Node '%s' doesn't exist", nodeHWID)
+	}
+	pub.registeredNodeTwins.SetReported(nodeHWID, reported)
+	return nil
+}
+
+// handleNodeTwinUpdated notifies the desired handler of the pending properties of a loaded twin when
+// its node is created
+func (pub *Publisher) handleNodeTwinUpdated(nodeHWID string) {
+	if pub.registeredNodes.GetNodeByHWID(nodeHWID) != nil {
+		pub.registeredNodeTwins.NotifyLoadedTwin(nodeHWID)
+	}
+}
+
+// handleSetDesired handles the command to set the desired properties of a node twin
+func (pub *Publisher) handleSetDesired(address string, message *types.SetDesiredMessage) {
+	node := pub.registeredNodes.GetNodeByAddress(address)
+	if node == nil {
+		logrus.Warningf("Publisher.handleSetDesired: Node '%s' not found. Command from %s ignored.",
+			address, message.Sender)
+		return
+	}
+	pub.registeredNodeTwins.SetDesired(node.HWID, message.Desired)
+}
+
+// getUpdatedTwins returns the hwIDs of the updated twins whose node exists and clears their update
+// Twins of nodes that don't exist yet, eg loaded twins, remain updated until their node is created.
+func (pub *Publisher) getUpdatedTwins() []string {
+	hwIDs := make([]string, 0)
+	for _, hwID := range pub.registeredNodeTwins.GetUpdatedTwins(false) {
+		if pub.registeredNodes.GetNodeByHWID(hwID) != nil {
+			hwIDs = append(hwIDs, hwID)
+		}
+	}
+	pub.registeredNodeTwins.ClearUpdated(hwIDs)
+	return hwIDs
+}
+
+// publishNodeTwin publishes the twin of a registered node
+func (pub *Publisher) publishNodeTwin(nodeHWID string) {
+	node := pub.registeredNodes.GetNodeByHWID(nodeHWID)
+	twin := pub.registeredNodeTwins.GetTwin(nodeHWID)
+	if node != nil && twin != nil {
+		err := nodes.PublishNodeTwin(node, twin, pub.messageSigner)
+		if err != nil {
+			logrus.Warningf("Publisher.publishNodeTwin: %s", err)
+		}
+	}
+}
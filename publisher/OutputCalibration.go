@@ -0,0 +1,52 @@
+// Package publisher with calibration of registered output values using node configuration
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// EnableOutputCalibration adds the gain and offset calibration configuration of an output to its node.
+// Once enabled, the calibration can be set with $configure and is applied to new output values.
+// The node must exist. Use MakeCalibrationAttr to obtain the configuration attribute names.
+func (pub *Publisher) EnableOutputCalibration(nodeHWID string, outputType types.OutputType, instance string) {
+	gainAttr := outputs.MakeCalibrationAttr(outputType, instance, types.NodeAttrGain)
+	offsetAttr := outputs.MakeCalibrationAttr(outputType, instance, types.NodeAttrOffset)
+	pub.registeredNodes.UpdateNodeConfig(nodeHWID, gainAttr,
+		&types.ConfigAttr{DataType: types.DataTypeNumber, Description: "Calibration gain", Default: "1"})
+	pub.registeredNodes.UpdateNodeConfig(nodeHWID, offsetAttr,
+		&types.ConfigAttr{DataType: types.DataTypeNumber, Description: "Calibration offset", Default: "0"})
+}
+
+// updateOutputValue adds the new output value, applying the output's calibration if it is enabled
+// The value is validated against the output data type before calibration. The statistics, rate of
+// change and forecast accuracy of the output are updated with the calibrated value if they are enabled.
+// The statistics, rate of change and forecast accuracy include readings that repeat the previous value. Values of overridden outputs are ignored.
+func (pub *Publisher) updateOutputValue(nodeHWID string, outputType types.OutputType, instance string,
+	newValue string, quality types.ValueQuality) bool {
+
+	var updated bool
+	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
+	if pub.isOutputOverridden(outputID) {
+		return false
+	}
+	newValue, isValid := pub.checkOutputValue(outputID, newValue)
+	if !isValid {
+		return false
+	}
+	gainAttr := outputs.MakeCalibrationAttr(outputType, instance, types.NodeAttrGain)
+	offsetAttr := outputs.MakeCalibrationAttr(outputType, instance, types.NodeAttrOffset)
+	// calibration is not enabled if the configuration doesn't exist
+	gain, err := pub.registeredNodes.GetNodeConfigFloat(nodeHWID, gainAttr, 1)
+	if err != nil {
+		updated = pub.registeredOutputValues.UpdateOutputValueWithQuality(outputID, newValue, quality)
+	} else {
+		offset, _ := pub.registeredNodes.GetNodeConfigFloat(nodeHWID, offsetAttr, 0)
+		updated = pub.registeredOutputValues.UpdateCalibratedOutputValue(outputID, newValue, gain, offset, quality)
+	}
+	// repeated readings don't update the history but are included in the statistics
+	pub.updateOutputStatistics(nodeHWID, outputType, instance)
+	pub.updateOutputRate(nodeHWID, outputType, instance, updated)
+	pub.updateForecastAccuracy(nodeHWID, outputType, instance, updated)
+	return updated
+}
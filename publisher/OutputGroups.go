@@ -0,0 +1,70 @@
+// Package publisher with groups of outputs that are always published together
+package publisher
+
+import (
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// PublishOutputGroup publishes a snapshot of the values of a group of outputs on its $group address
+func (pub *Publisher) PublishOutputGroup(groupID string) error {
+	outputIDs := pub.registeredOutputGroups.GetGroup(groupID)
+	if outputIDs == nil {
+		return lib.MakeErrorf("PublishOutputGroup: Output group '%s' doesn't exist", groupID)
+	}
+	groupOutputs := make([]*types.OutputDiscoveryMessage, 0, len(outputIDs))
+	for _, outputID := range outputIDs {
+		output := pub.registeredOutputs.GetOutputByID(outputID)
+		if output != nil {
+			groupOutputs = append(groupOutputs, output)
+		}
+	}
+	values := pub.registeredOutputValues.GetOutputValues(outputIDs)
+	addr := outputs.MakeGroupAddress(pub.Domain(), pub.PublisherID(), groupID)
+	return outputs.PublishOutputGroup(addr, groupOutputs, values, pub.messageSigner)
+}
+
+// RemoveOutputGroup removes a group of outputs. The outputs remain. The retained $group publication
+// isn't removed.
+func (pub *Publisher) RemoveOutputGroup(groupID string) {
+	pub.registeredOutputGroups.RemoveGroup(groupID)
+}
+
+// SetOutputGroup declares a group of outputs that are always published together in one $group message
+// with a consistent timestamp, eg all phases of a power meter. The group is published on
+// {domain}/{publisherId}/{groupId}/$group when the value of one or more of its outputs is published.
+// The group publication counts as an update in the publish budget. This replaces the outputs of an existing group.
+//  groupID is the ID of the group. It can't be the ID of a registered node.
+//  outputIDs are the IDs of registered outputs in order of publication
+func (pub *Publisher) SetOutputGroup(groupID string, outputIDs ...string) error {
+	if groupID == "" || strings.ContainsAny(groupID, "/+#$") {
+		return lib.MakeErrorf("SetOutputGroup: Invalid group ID '%s'", groupID)
+	} else if len(outputIDs) == 0 {
+		return lib.MakeErrorf("SetOutputGroup: Group '%s' has no outputs", groupID)
+	} else if pub.registeredNodes.GetNodeByNodeID(groupID) != nil {
+		return lib.MakeErrorf("SetOutputGroup: Group ID '%s' is the ID of a node", groupID)
+	}
+	for _, outputID := range outputIDs {
+		if pub.registeredOutputs.GetOutputByID(outputID) == nil {
+			return lib.MakeErrorf("SetOutputGroup: Output '%s' of group '%s' doesn't exist", outputID, groupID)
+		}
+	}
+	pub.registeredOutputGroups.SetGroup(groupID, outputIDs)
+	return nil
+}
+
+// publishOutputGroup publishes a group whose output values are updated
+// The group can have been removed after its update was queued.
+func (pub *Publisher) publishOutputGroup(groupID string) {
+	if pub.registeredOutputGroups.GetGroup(groupID) == nil {
+		return
+	}
+	err := pub.PublishOutputGroup(groupID)
+	if err != nil {
+		logrus.Warningf("Publisher.publishOutputGroup: Failed publishing group '%s': %s", groupID, err)
+	}
+}
@@ -0,0 +1,77 @@
+// Package publisher with resampled output history and rate of change companion outputs
+package publisher
+
+import (
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// EnableOutputRate adds the rate of change configuration of an output to its node and creates the
+// companion output with the rate of change between the two most recent values, with instance
+// {instance}-rate. The time unit of the rate can be changed with $configure and is one of
+// outputs.RateUnits. The node must exist.
+//  unit is the default time unit of the rate, eg outputs.RateUnitHour for kW from a kWh counter
+func (pub *Publisher) EnableOutputRate(nodeHWID string, outputType types.OutputType, instance string, unit string) {
+	rateAttr := outputs.MakeCalibrationAttr(outputType, instance, types.NodeAttrRate)
+	pub.registeredNodes.UpdateNodeConfig(nodeHWID, rateAttr, &types.ConfigAttr{
+		DataType:    types.DataTypeEnum,
+		Description: "Rate of change unit of output " + string(outputType) + "/" + instance,
+		Default:     unit,
+		Enum:        outputs.RateUnits,
+	})
+	output := outputs.NewOutput(pub.Domain(), pub.PublisherID(), nodeHWID, outputType, instance+outputs.RateSuffix)
+	output.DataType = types.DataTypeNumber
+	pub.registeredOutputs.UpdateOutput(output)
+}
+
+// GetResampledHistory returns the history of a registered output resampled onto a fixed time grid
+// See outputs.ResampleHistory for details.
+//  start and end time of the grid
+//  interval between grid points
+//  method is outputs.ResampleLinear or outputs.ResampleLOCF
+func (pub *Publisher) GetResampledHistory(nodeHWID string, outputType types.OutputType, instance string,
+	start time.Time, end time.Time, interval time.Duration, method string) (outputs.OutputHistory, error) {
+
+	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
+	if pub.registeredOutputs.GetOutputByID(outputID) == nil {
+		return nil, lib.MakeErrorf("GetResampledHistory: Output %s doesn't exist", outputID)
+	}
+	return outputs.ResampleHistory(pub.registeredOutputValues.GetHistory(outputID), start, end, interval, method)
+}
+
+// updateOutputRate updates the rate of change companion output with the two most recent values of an output
+// A reading that repeats the previous value isn't added to the history, so its rate is determined from the
+// previous value until now.
+// This does nothing if the rate is not enabled for the output or the values are not numbers.
+//  isNewValue the reading is added to the history
+func (pub *Publisher) updateOutputRate(nodeHWID string, outputType types.OutputType, instance string, isNewValue bool) {
+	rateAttr := outputs.MakeCalibrationAttr(outputType, instance, types.NodeAttrRate)
+	unit, err := pub.registeredNodes.GetNodeConfigString(nodeHWID, rateAttr, outputs.RateUnitOff)
+	per := outputs.GetRateUnitDuration(unit)
+	if err != nil || per == 0 {
+		return
+	}
+	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
+	history := pub.registeredOutputValues.GetHistory(outputID)
+	var samples outputs.OutputHistory
+	if !isNewValue && len(history) > 0 {
+		now := time.Now()
+		current := history[0]
+		current.EpochTime = now.Unix()
+		current.Timestamp = now.Format(types.TimeFormat)
+		samples = outputs.OutputHistory{current, history[0]}
+	} else if len(history) >= 2 {
+		samples = history[:2]
+	} else {
+		return
+	}
+	rates, err := outputs.RateOfChange(samples, per)
+	if err != nil || len(rates) == 0 {
+		return
+	}
+	companionID := outputs.MakeOutputID(nodeHWID, outputType, instance+outputs.RateSuffix)
+	pub.registeredOutputValues.UpdateOutputValue(companionID, rates[0].Value)
+}
@@ -0,0 +1,61 @@
+// Package publisher with running min, max and average companion outputs of numeric outputs
+package publisher
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// EnableOutputStatistics adds the statistics configuration of an output to its node and creates the
+// companion outputs with the min, max and average value of the period, with instance {instance}-min,
+// {instance}-max and {instance}-avg. The period can be changed with $configure and is one of
+// outputs.StatisticsPeriods. The node must exist.
+//  period is the default period, eg outputs.StatisticsPeriodDaily
+func (pub *Publisher) EnableOutputStatistics(nodeHWID string, outputType types.OutputType, instance string,
+	period string) {
+
+	statsAttr := outputs.MakeCalibrationAttr(outputType, instance, types.NodeAttrStatistics)
+	pub.registeredNodes.UpdateNodeConfig(nodeHWID, statsAttr, &types.ConfigAttr{
+		DataType:    types.DataTypeEnum,
+		Description: "Statistics period of output " + string(outputType) + "/" + instance,
+		Default:     period,
+		Enum:        outputs.StatisticsPeriods,
+	})
+	for _, suffix := range []string{outputs.StatisticsMinSuffix, outputs.StatisticsMaxSuffix, outputs.StatisticsAvgSuffix} {
+		output := outputs.NewOutput(pub.Domain(), pub.PublisherID(), nodeHWID, outputType, instance+suffix)
+		output.DataType = types.DataTypeNumber
+		pub.registeredOutputs.UpdateOutput(output)
+	}
+}
+
+// updateOutputStatistics updates the statistics companion outputs with the latest value of an output
+// This does nothing if statistics are not enabled for the output or the value is not a number.
+func (pub *Publisher) updateOutputStatistics(nodeHWID string, outputType types.OutputType, instance string) {
+	statsAttr := outputs.MakeCalibrationAttr(outputType, instance, types.NodeAttrStatistics)
+	period, err := pub.registeredNodes.GetNodeConfigString(nodeHWID, statsAttr, outputs.StatisticsPeriodOff)
+	if err != nil || period == outputs.StatisticsPeriodOff {
+		return
+	}
+	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
+	latest := pub.registeredOutputValues.GetOutputValueByID(outputID)
+	if latest == nil {
+		return
+	}
+	value, err := strconv.ParseFloat(latest.Value, 64)
+	if err != nil {
+		return
+	}
+	stats := pub.registeredStatistics.UpdateStatistics(outputID, value, period, time.Now())
+	companions := map[string]float64{
+		outputs.StatisticsMinSuffix: stats.Min,
+		outputs.StatisticsMaxSuffix: stats.Max,
+		outputs.StatisticsAvgSuffix: stats.Average,
+	}
+	for suffix, statValue := range companions {
+		companionID := outputs.MakeOutputID(nodeHWID, outputType, instance+suffix)
+		pub.registeredOutputValues.UpdateOutputValue(companionID, strconv.FormatFloat(statValue, 'f', -1, 64))
+	}
+}
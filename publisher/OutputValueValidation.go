@@ -0,0 +1,46 @@
+// Package publisher with validation of registered output values against their declared data type
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/sirupsen/logrus"
+)
+
+// GetOutputValueViolations returns the nr of values that didn't match the data type of their output,
+// by output ID. Intended for detecting adapters that publish values consumers can't parse.
+func (pub *Publisher) GetOutputValueViolations() map[string]int {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	violations := make(map[string]int)
+	for outputID, count := range pub.valueViolations {
+		violations[outputID] = count
+	}
+	return violations
+}
+
+// checkOutputValue validates a new value against the data type of the output. Violations are logged
+// and counted. If coerceOutputs is configured the value is converted to the data type.
+// This returns the value to use and false if the value must be dropped as it can't be converted.
+func (pub *Publisher) checkOutputValue(outputID string, value string) (string, bool) {
+	output := pub.registeredOutputs.GetOutputByID(outputID)
+	err := outputs.ValidateOutputValue(output, value)
+	if err == nil {
+		return value, true
+	}
+	pub.updateMutex.Lock()
+	pub.valueViolations[outputID]++
+	coerce := pub.config.CoerceOutputValues
+	pub.updateMutex.Unlock()
+
+	if !coerce {
+		logrus.Warningf("Publisher.checkOutputValue: %s", err)
+		return value, true
+	}
+	coerced, err := outputs.CoerceOutputValue(output, value)
+	if err != nil {
+		logrus.Warningf("Publisher.checkOutputValue: %s. Value dropped.", err)
+		return value, false
+	}
+	logrus.Infof("Publisher.checkOutputValue: Value '%s' of output %s converted to '%s'", value, outputID, coerced)
+	return coerced, true
+}
@@ -0,0 +1,100 @@
+// Package publisher with operator overrides of inputs and outputs for commissioning and maintenance
+package publisher
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// ClearOverride removes the override of an input or output. Values reported for the output are
+// used again and the input accepts set commands again.
+//  id is the input ID or output ID. If an input and output have the same ID both are cleared.
+func (pub *Publisher) ClearOverride(id string) error {
+	var nodeHWID string
+	if input := pub.registeredInputs.GetInputByID(id); input != nil {
+		nodeHWID = input.NodeHWID
+		pub.inputFromSetCommands.SetOverride(id, false)
+	}
+	if output := pub.registeredOutputs.GetOutputByID(id); output != nil {
+		nodeHWID = output.NodeHWID
+		pub.updateMutex.Lock()
+		delete(pub.outputOverrides, id)
+		pub.updateMutex.Unlock()
+	}
+	if nodeHWID == "" {
+		return lib.MakeErrorf("ClearOverride: Unknown input or output %s", id)
+	}
+	logrus.Warningf("Publisher.ClearOverride: Override of %s is cleared", id)
+	pub.updateOverrideStatus(nodeHWID)
+	return nil
+}
+
+// IsOverridden returns true if the input or output is overridden
+//  id is the input ID or output ID
+func (pub *Publisher) IsOverridden(id string) bool {
+	pub.updateMutex.Lock()
+	_, isOverridden := pub.outputOverrides[id]
+	pub.updateMutex.Unlock()
+	return isOverridden || pub.inputFromSetCommands.IsOverridden(id)
+}
+
+// OverrideInput makes an input ignore set commands until the override is cleared.
+// The override is published in the node's overridden status.
+func (pub *Publisher) OverrideInput(inputID string) error {
+	input := pub.registeredInputs.GetInputByID(inputID)
+	if input == nil {
+		return lib.MakeErrorf("OverrideInput: Unknown input %s", inputID)
+	}
+	logrus.Warningf("Publisher.OverrideInput: Input %s ignores set commands", inputID)
+	pub.inputFromSetCommands.SetOverride(inputID, true)
+	pub.updateOverrideStatus(input.NodeHWID)
+	return nil
+}
+
+// OverrideOutput forces an output to a fixed value until the override is cleared. Values reported
+// by the adapter are ignored. The value is published with quality overridden and the override is
+// published in the node's overridden status.
+func (pub *Publisher) OverrideOutput(outputID string, value string) error {
+	output := pub.registeredOutputs.GetOutputByID(outputID)
+	if output == nil {
+		return lib.MakeErrorf("OverrideOutput: Unknown output %s", outputID)
+	}
+	logrus.Warningf("Publisher.OverrideOutput: Output %s is forced to '%s'", outputID, value)
+	pub.updateMutex.Lock()
+	pub.outputOverrides[outputID] = value
+	pub.updateMutex.Unlock()
+	pub.registeredOutputValues.UpdateOutputValueWithQuality(outputID, value, types.ValueQualityOverridden)
+	pub.updateOverrideStatus(output.NodeHWID)
+	return nil
+}
+
+// isOutputOverridden returns true if the output value is forced by an override
+func (pub *Publisher) isOutputOverridden(outputID string) bool {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	_, isOverridden := pub.outputOverrides[outputID]
+	return isOverridden
+}
+
+// updateOverrideStatus updates the node status with the overridden inputs and outputs of the node
+func (pub *Publisher) updateOverrideStatus(nodeHWID string) {
+	overridden := make([]string, 0)
+	for _, input := range pub.registeredInputs.GetAllInputs() {
+		if input.NodeHWID == nodeHWID && pub.inputFromSetCommands.IsOverridden(input.InputID) {
+			overridden = append(overridden, input.InputID)
+		}
+	}
+	for _, output := range pub.registeredOutputs.GetOutputsByNodeHWID(nodeHWID) {
+		if pub.isOutputOverridden(output.OutputID) {
+			overridden = append(overridden, output.OutputID)
+		}
+	}
+	sort.Strings(overridden)
+	pub.registeredNodes.UpdateNodeStatus(nodeHWID, map[types.NodeStatus]string{
+		types.NodeStatusOverridden: strings.Join(overridden, ","),
+	})
+}
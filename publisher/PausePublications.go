@@ -0,0 +1,76 @@
+// Package publisher with pausing of the publication of updates
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/sirupsen/logrus"
+)
+
+// PausePolicy determines what happens with updates while publications are paused
+type PausePolicy string
+
+// Available pause policies
+const (
+	PausePolicyBuffer PausePolicy = "buffer" // updates are published when resumed
+	PausePolicyDrop   PausePolicy = "drop"   // updates made while paused are not published
+)
+
+// IsPaused returns true if the publication of updates is paused
+func (pub *Publisher) IsPaused() bool {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	return pub.pausePolicy != ""
+}
+
+// Pause suspends the publication of updates to nodes, inputs, outputs and output values, geofence
+// events and the publisher status, and the republication of the discovery, while commands are still
+// received and handled. Intended for bulk reconfiguration or broker maintenance.
+// Buffered updates only publish the latest version of each node, input, output and value, and the
+// last publisher status.
+//  policy is PausePolicyBuffer or PausePolicyDrop
+func (pub *Publisher) Pause(policy PausePolicy) error {
+	if policy != PausePolicyBuffer && policy != PausePolicyDrop {
+		return lib.MakeErrorf("Pause: Invalid pause policy '%s'", policy)
+	}
+	logrus.Warningf("Publisher.Pause: Publications of publisher %s are paused with policy %s", pub.PublisherID(), policy)
+	pub.updateMutex.Lock()
+	pub.pausePolicy = policy
+	pub.updateMutex.Unlock()
+	return nil
+}
+
+// Resume the publication of updates and publish the buffered updates
+// This returns an error if another publisher holds the claim to this publisher's ID, in which case
+// publications remain paused.
+func (pub *Publisher) Resume() error {
+	if err := pub.IdentityClaimError(); err != nil {
+		return lib.MakeErrorf("Resume: Publisher %s remains paused: %s", pub.PublisherID(), err)
+	}
+	pub.updateMutex.Lock()
+	isPaused := pub.pausePolicy != ""
+	pub.pausePolicy = ""
+	pausedStatus := pub.pausedStatus
+	pub.pausedStatus = ""
+	publishBudget := pub.config.PublishBudget
+	pub.updateMutex.Unlock()
+	if isPaused {
+		logrus.Warningf("Publisher.Resume: Publications of publisher %s are resumed", pub.PublisherID())
+		if pausedStatus != "" {
+			pub.SetPublisherStatus(pausedStatus)
+		}
+		pub.publishUpdates(publishBudget)
+	}
+	return nil
+}
+
+// queueWhilePaused queues an update that is published outside the publication of updates when
+// publications are paused with the buffer policy, or drops it with the drop policy.
+// This returns false if publications aren't paused and the update should be published now.
+func (pub *Publisher) queueWhilePaused(kind string, id string) bool {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	if pub.pausePolicy == PausePolicyBuffer {
+		pub.queueUpdate(kind, id)
+	}
+	return pub.pausePolicy != ""
+}
@@ -0,0 +1,209 @@
+// Package publisher with runtime profiling to diagnose performance issues of publishers in the field
+package publisher
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	httppprof "net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultCPUProfileSeconds is the duration of a CPU profile that is captured with the profileCPU
+// control command without a duration
+const DefaultCPUProfileSeconds = 30
+
+// CaptureHeapProfile writes a heap profile of the live objects to the profile folder
+// The profile folder is the profileFolder configuration, or the cache folder if not set.
+// Use 'go tool pprof <filename>' to analyze the profile. This returns the filename of the profile.
+func (pub *Publisher) CaptureHeapProfile() (filename string, err error) {
+	filename = pub.makeProfileFilename("heap")
+	profileFile, err := os.Create(filename)
+	if err != nil {
+		return "", lib.MakeErrorf("CaptureHeapProfile: Unable to create profile %s: %s", filename, err)
+	}
+	defer profileFile.Close()
+	// collect garbage to get up to date statistics of the live objects
+	runtime.GC()
+	err = pprof.WriteHeapProfile(profileFile)
+	if err != nil {
+		return "", lib.MakeErrorf("CaptureHeapProfile: Unable to write profile %s: %s", filename, err)
+	}
+	logrus.Warningf("Publisher.CaptureHeapProfile: Heap profile written to %s", filename)
+	return filename, nil
+}
+
+// PprofAddress returns the address the net/http/pprof endpoint listens on, or "" if it isn't running
+// The endpoint is enabled with the pprofListen configuration and runs while the publisher is started.
+func (pub *Publisher) PprofAddress() string {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	if pub.pprofListener == nil {
+		return ""
+	}
+	return pub.pprofListener.Addr().String()
+}
+
+// StartCPUProfile starts writing a CPU profile to the profile folder until StopCPUProfile is called
+// Intended for capturing the load of a benchmark or of a performance issue in the field.
+// Only one CPU profile can be captured at a time. This returns the filename of the profile.
+func (pub *Publisher) StartCPUProfile() (filename string, err error) {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	if pub.cpuProfileFile != nil {
+		return "", lib.MakeErrorf("StartCPUProfile: CPU profile %s is already being captured", pub.cpuProfileFile.Name())
+	}
+	filename = pub.makeProfileFilename("cpu")
+	profileFile, err := os.Create(filename)
+	if err != nil {
+		return "", lib.MakeErrorf("StartCPUProfile: Unable to create profile %s: %s", filename, err)
+	}
+	err = pprof.StartCPUProfile(profileFile)
+	if err != nil {
+		profileFile.Close()
+		os.Remove(filename)
+		return "", lib.MakeErrorf("StartCPUProfile: %s", err)
+	}
+	pub.cpuProfileFile = profileFile
+	logrus.Warningf("Publisher.StartCPUProfile: Capturing CPU profile to %s", filename)
+	return filename, nil
+}
+
+// StopCPUProfile stops capturing the CPU profile and returns its filename, or "" if no profile is captured
+func (pub *Publisher) StopCPUProfile() (filename string) {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	return pub.stopCPUProfile()
+}
+
+// captureCPUProfile captures a CPU profile in the background for the given nr of seconds
+// Invoked with the profileCPU control command.
+//  value is the nr of seconds to capture. Use "" for DefaultCPUProfileSeconds.
+func (pub *Publisher) captureCPUProfile(value string) error {
+	seconds := DefaultCPUProfileSeconds
+	if value != "" {
+		var err error
+		seconds, err = strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			return lib.MakeErrorf("captureCPUProfile: Invalid duration '%s'. Expected nr of seconds", value)
+		}
+	}
+	filename, err := pub.StartCPUProfile()
+	if err != nil {
+		return err
+	}
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	pub.cpuProfileTimer = time.AfterFunc(time.Duration(seconds)*time.Second, func() {
+		pub.updateMutex.Lock()
+		defer pub.updateMutex.Unlock()
+		// the profile can already have been stopped and another one started
+		if pub.cpuProfileFile != nil && pub.cpuProfileFile.Name() == filename {
+			pub.stopCPUProfile()
+		}
+	})
+	return nil
+}
+
+// isLoopbackListenAddress returns true if the listen address host:port only listens on the loopback interface
+// An empty host listens on all interfaces.
+func isLoopbackListenAddress(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	} else if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// makeProfileFilename returns the path of a new profile in the profile folder
+//  kind of profile, eg cpu or heap
+func (pub *Publisher) makeProfileFilename(kind string) string {
+	folder := pub.config.ProfileFolder
+	if folder == "" {
+		folder = pub.config.CacheFolder
+	}
+	name := fmt.Sprintf("%s-%s-%s.pprof", pub.PublisherID(), kind, time.Now().Format("20060102-150405.000"))
+	return filepath.Join(folder, name)
+}
+
+// startPprofServer starts the net/http/pprof endpoint if enabled with the pprofListen configuration
+// The endpoint has no authentication so it only listens on a non-loopback address if pprofAllowRemote is set.
+func (pub *Publisher) startPprofServer() {
+	listen := pub.config.PprofListen
+	if listen == "" {
+		return
+	}
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	if pub.pprofServer != nil {
+		return
+	}
+	if !isLoopbackListenAddress(listen) {
+		if !pub.config.PprofAllowRemote {
+			logrus.Errorf("Publisher.startPprofServer: Refusing to listen on non-loopback address %s. "+
+				"Set pprofAllowRemote to allow access from the network.", listen)
+			return
+		}
+		logrus.Warningf("Publisher.startPprofServer: The pprof endpoint on %s is accessible without authentication", listen)
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		logrus.Errorf("Publisher.startPprofServer: Unable to listen on %s: %s", listen, err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", httppprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+	pub.pprofListener = listener
+	pub.pprofServer = &http.Server{Handler: mux}
+	go func(server *http.Server) {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("Publisher.startPprofServer: Server stopped: %s", err)
+		}
+	}(pub.pprofServer)
+	logrus.Warningf("Publisher.startPprofServer: pprof endpoint listening on %s/debug/pprof/", listener.Addr())
+}
+
+// stopCPUProfile stops capturing the CPU profile and returns its filename, or "" if no profile is captured
+// This cancels the timer of the profileCPU command. The caller must hold the update mutex.
+func (pub *Publisher) stopCPUProfile() (filename string) {
+	if pub.cpuProfileTimer != nil {
+		pub.cpuProfileTimer.Stop()
+		pub.cpuProfileTimer = nil
+	}
+	if pub.cpuProfileFile == nil {
+		return ""
+	}
+	pprof.StopCPUProfile()
+	filename = pub.cpuProfileFile.Name()
+	pub.cpuProfileFile.Close()
+	pub.cpuProfileFile = nil
+	logrus.Warningf("Publisher.StopCPUProfile: CPU profile written to %s", filename)
+	return filename
+}
+
+// stopPprofServer stops the net/http/pprof endpoint if it is running
+// The caller must hold the update mutex.
+func (pub *Publisher) stopPprofServer() {
+	if pub.pprofServer == nil {
+		return
+	}
+	pub.pprofServer.Close()
+	pub.pprofServer = nil
+	pub.pprofListener = nil
+}
@@ -0,0 +1,50 @@
+// Package publisher with pre-registration of expected nodes from a provisioning file
+package publisher
+
+import (
+	"path/filepath"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// LoadProvisioning reads the nodes to pre-register from a provisioning YAML file and provisions them.
+// The file has the same format as the node definitions of ExportNodeDefinitions.
+// This is invoked on creation of the publisher if the configuration has a provisionFile.
+//  filename of the provisioning file. Relative paths are in the configuration folder.
+func (pub *Publisher) LoadProvisioning(filename string) error {
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(pub.config.ConfigFolder, filename)
+	}
+	definitions, err := readNodeDefinitions(filename)
+	if err != nil {
+		return err
+	}
+	return pub.ProvisionNodes(definitions)
+}
+
+// ProvisionNodes pre-registers the nodes of the definitions before they are discovered, for example to
+// prepare their node ID alias, name and location ahead of the installation of the devices.
+// Nodes that haven't been discovered have the provisioned runState until the adapter creates them. The
+// provisioned attributes are merged with the attributes of the discovered hardware and take precedence
+// over attributes the adapter updates. The type of the discovered node is used if the definition has no type.
+// This returns an error, without provisioning any node, if the definitions are invalid.
+func (pub *Publisher) ProvisionNodes(definitions *NodeDefinitions) error {
+	err := validateNodeDefinitions(definitions)
+	if err != nil {
+		return err
+	}
+	for _, nodeDef := range definitions.Nodes {
+		attr := types.NodeAttrMap{}
+		for key, value := range nodeDef.Attr {
+			if key != types.NodeAttrType {
+				attr[key] = value
+			}
+		}
+		pub.registeredNodes.ProvisionNode(nodeDef.HWID, nodeDef.nodeType(), attr)
+		pub.applyInOutputDefinitions(&nodeDef)
+		pub.applyNodeConfig(&nodeDef)
+	}
+	logrus.Infof("Publisher.ProvisionNodes: Provisioned %d nodes", len(definitions.Nodes))
+	return nil
+}
@@ -0,0 +1,67 @@
+// Package publisher with caching of the output publication policies of nodes
+package publisher
+
+import (
+	"errors"
+
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// publishPolicy with the resolved publish configuration of a node
+type publishPolicy struct {
+	event   bool                        // publish the $event of all node outputs
+	history bool                        // publish the output $history
+	latest  bool                        // publish the output $latest
+	node    *types.NodeDiscoveryMessage // node instance the policy was resolved from
+	raw     bool                        // publish the output $raw value
+}
+
+// getPublishPolicy returns the cached publish policy of a node, resolving it after a node update
+// Updated nodes are new instances, so a cached policy of another instance is outdated.
+func (pub *Publisher) getPublishPolicy(node *types.NodeDiscoveryMessage) publishPolicy {
+	pub.updateMutex.Lock()
+	policy, found := pub.publishPolicies[node.HWID]
+	pub.updateMutex.Unlock()
+	if found && policy.node == node {
+		return policy
+	}
+	policy = publishPolicy{
+		event:   pub.readPublishPolicy(node.HWID, types.NodeAttrPublishEvent, false),
+		history: pub.readPublishPolicy(node.HWID, types.NodeAttrPublishHistory, true),
+		latest:  pub.readPublishPolicy(node.HWID, types.NodeAttrPublishLatest, true),
+		node:    node,
+		raw:     pub.readPublishPolicy(node.HWID, types.NodeAttrPublishRaw, true),
+	}
+	pub.updateMutex.Lock()
+	pub.publishPolicies[node.HWID] = policy
+	pub.updateMutex.Unlock()
+	return policy
+}
+
+// handleNodeUpdated removes the cached publish policy of an updated or deleted node
+func (pub *Publisher) handleNodeUpdated(nodeHWID string) {
+	pub.updateMutex.Lock()
+	delete(pub.publishPolicies, nodeHWID)
+	pub.updateMutex.Unlock()
+}
+
+// readPublishPolicy returns the node's publish policy configuration, or the default if it isn't set
+// Nodes that don't set the policy use the policy of the publisher node if it exists. Nodes from before
+// the policy was configurable don't have the configuration and use the default.
+func (pub *Publisher) readPublishPolicy(nodeHWID string, attrName types.NodeAttr, defaultValue bool) bool {
+	node := pub.registeredNodes.GetNodeByHWID(nodeHWID)
+	publisherNode := pub.registeredNodes.GetNodeByHWID(PublisherNodeHWID)
+	if node != nil && publisherNode != nil && node.Attr[attrName] == "" {
+		nodeHWID = PublisherNodeHWID
+	}
+	value, found, err := pub.registeredNodes.ReadNodeConfigBool(nodeHWID, attrName)
+	if errors.Is(err, nodes.ErrInvalidConfigValue) {
+		logrus.Warningf("Publisher.readPublishPolicy: %s. Using default %v", err, defaultValue)
+	}
+	if err != nil || !found {
+		return defaultValue
+	}
+	return value
+}
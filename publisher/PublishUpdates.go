@@ -14,26 +14,178 @@ import (
 )
 
 // PublishUpdates publishes changes to registered nodes, inputs, outputs, values and this publisher identity
+// This includes the updates that were carried over from previous heartbeats by the publish budget.
 func (publisher *Publisher) PublishUpdates() {
+	publisher.publishUpdates(0)
+}
+
+// Kinds of pending updates
+const (
+	pendingKindCompatValue = "compat"
+	pendingKindEvent       = "event"
+	pendingKindGroup       = "group"
+	pendingKindInput       = "input"
+	pendingKindNode        = "node"
+	pendingKindOutput      = "output"
+	pendingKindOutputValue = "value"
+	pendingKindTwin        = "twin"
+)
 
+// pendingUpdate identifies an updated node, input, output, output value, group, twin or event that is waiting to be published
+type pendingUpdate struct {
+	kind string // node, input, output, value, compat value, group, twin or event
+	id   string // node hardware ID, input ID, output ID or group ID
+}
+
+// publishUpdates queues the changes to registered nodes, inputs, outputs, values, groups and twins and publishes them
+// in order of update, up to the budget. The remainder is carried over to the next call.
+// The latest version of an entity is published so repeated updates are only published once.
+// While paused the updates remain queued or are dropped, depending on the pause policy.
+//  budget is the max nr of updates to publish. Use 0 to publish all updates.
+func (publisher *Publisher) publishUpdates(budget int) {
 	updatedNodes := publisher.registeredNodes.GetUpdatedNodes(true)
-	nodes.PublishRegisteredNodes(updatedNodes, publisher.messageSigner)
 	if len(updatedNodes) > 0 && publisher.config.ConfigFolder != "" {
 		publisher.SaveRegisteredNodes()
 	}
-
+	if publisher.registeredNodes.NodeIDMappings().UpdateCount() > 0 && publisher.config.ConfigFolder != "" {
+		publisher.SaveNodeIDMappings()
+	}
 	updatedInputs := publisher.registeredInputs.GetUpdatedInputs(true)
-	inputs.PublishRegisteredInputs(updatedInputs, publisher.messageSigner)
-
 	updatedOutputs := publisher.registeredOutputs.GetUpdatedOutputs(true)
-	outputs.PublishRegisteredOutputs(updatedOutputs, publisher.messageSigner)
-
 	updatedOutputIDs := publisher.registeredOutputValues.GetUpdatedOutputValues(true)
-	publisher.PublishUpdatedOutputValues(updatedOutputIDs, publisher.messageSigner)
+	updatedTwinIDs := publisher.getUpdatedTwins()
+	updatedGroupIDs := publisher.registeredOutputGroups.GetGroupIDs(updatedOutputIDs)
+
+	publisher.updateMutex.Lock()
+	if publisher.pausePolicy == PausePolicyDrop {
+		publisher.pendingKeys = make(map[pendingUpdate]bool)
+		publisher.pendingUpdates = nil
+		publisher.updateMutex.Unlock()
+		return
+	}
+	for _, node := range updatedNodes {
+		// nil nodes are no longer valid and are not published
+		if node != nil {
+			publisher.queueUpdate(pendingKindNode, node.HWID)
+		}
+	}
+	for _, input := range updatedInputs {
+		publisher.queueUpdate(pendingKindInput, input.InputID)
+	}
+	for _, output := range updatedOutputs {
+		publisher.queueUpdate(pendingKindOutput, output.OutputID)
+	}
+	for _, outputID := range updatedOutputIDs {
+		publisher.queueUpdate(pendingKindOutputValue, outputID)
+	}
+	// compat publications for legacy consumers count as separate updates
+	if publisher.config.AddressTemplate != "" {
+		for _, outputID := range updatedOutputIDs {
+			publisher.queueUpdate(pendingKindCompatValue, outputID)
+		}
+	}
+	// groups of outputs are published once after the values of their outputs
+	for _, groupID := range updatedGroupIDs {
+		publisher.queueUpdate(pendingKindGroup, groupID)
+	}
+	for _, hwID := range updatedTwinIDs {
+		publisher.queueUpdate(pendingKindTwin, hwID)
+	}
+	count := len(publisher.pendingUpdates)
+	paused := publisher.pausePolicy == PausePolicyBuffer
+	if paused {
+		count = 0
+	} else if budget > 0 && count > budget {
+		count = budget
+	}
+	batch := publisher.pendingUpdates[:count]
+	publisher.pendingUpdates = publisher.pendingUpdates[count:]
+	for _, update := range batch {
+		delete(publisher.pendingKeys, update)
+	}
+	remaining := len(publisher.pendingUpdates)
+	publisher.updateMutex.Unlock()
+
+	if remaining > 0 && count > 0 {
+		logrus.Infof("Publisher.publishUpdates: publish budget of %d reached. %d updates carried over", budget, remaining)
+	}
+	// node ID changes are published ahead of the discovery of the renamed nodes
+	if !paused {
+		publisher.publishNodeIDMappings()
+	}
+	for _, update := range batch {
+		switch update.kind {
+		case pendingKindNode:
+			node := publisher.registeredNodes.GetNodeByHWID(update.id)
+			if node != nil {
+				nodes.PublishRegisteredNodes([]*types.NodeDiscoveryMessage{node}, publisher.messageSigner)
+			}
+		case pendingKindInput:
+			input := publisher.registeredInputs.GetInputByID(update.id)
+			if input != nil {
+				inputs.PublishRegisteredInputs([]*types.InputDiscoveryMessage{input}, publisher.messageSigner)
+			}
+		case pendingKindOutput:
+			output := publisher.registeredOutputs.GetOutputByID(update.id)
+			if output != nil {
+				outputs.PublishRegisteredOutputs([]*types.OutputDiscoveryMessage{output}, publisher.messageSigner)
+			}
+		case pendingKindOutputValue:
+			publisher.PublishUpdatedOutputValues([]string{update.id}, publisher.messageSigner)
+		case pendingKindCompatValue:
+			publisher.publishCompatOutputValue(update.id)
+		case pendingKindGroup:
+			publisher.publishOutputGroup(update.id)
+		case pendingKindTwin:
+			publisher.publishNodeTwin(update.id)
+		case pendingKindEvent:
+			node := publisher.registeredNodes.GetNodeByHWID(update.id)
+			if node != nil {
+				publisher.PublishOutputEvent(node)
+			}
+		}
+	}
+}
+
+// publishNodeIDMappings publishes the changes of node ID mappings since the last publication
+func (publisher *Publisher) publishNodeIDMappings() {
+	mappings := publisher.registeredNodes.NodeIDMappings()
+	changes := mappings.GetUpdatedMappings(true)
+	if len(changes) == 0 {
+		return
+	}
+	allMappings := mappings.GetAllMappings()
+	for _, change := range changes {
+		nodes.PublishNodeIDMapping(publisher.Domain(), publisher.PublisherID(), change, allMappings, publisher.messageSigner)
+		publisher.logAliasChange(change)
+	}
+}
+
+// publishCompatOutputValue publishes the latest value of an output on the address template for
+// legacy consumers
+func (publisher *Publisher) publishCompatOutputValue(outputID string) {
+	output := publisher.registeredOutputs.GetOutputByID(outputID)
+	latestValue := publisher.registeredOutputValues.GetOutputValueByID(outputID)
+	if output == nil || latestValue == nil {
+		return
+	}
+	outputs.PublishCompatOutputValue(publisher.config.AddressTemplate, output, latestValue, publisher.messenger)
+}
+
+// queueUpdate adds an update to the pending updates unless it is already pending
+//  Use within a locked section.
+func (publisher *Publisher) queueUpdate(kind string, id string) {
+	update := pendingUpdate{kind: kind, id: id}
+	if publisher.pendingKeys[update] {
+		return
+	}
+	publisher.pendingKeys[update] = true
+	publisher.pendingUpdates = append(publisher.pendingUpdates, update)
 }
 
 // PublishUpdatedOutputValues publishes updated outputs discovery and values of registered outputs
 // This uses the node config to determine which output publications to use: eg raw, latest, history
+// The publish configuration is cached per node until the node is updated.
 func (publisher *Publisher) PublishUpdatedOutputValues(
 	updatedOutputIDs []string,
 	messageSigner *messaging.MessageSigner) {
@@ -54,21 +206,27 @@ func (publisher *Publisher) PublishUpdatedOutputValues(
 		} else if latestValue == nil {
 			logrus.Warningf("PublishOutputValues: no latest value for %s. This is unexpected", outputID)
 		} else {
-			pubRaw, _ := publisher.registeredNodes.GetNodeConfigBool(node.Address, types.NodeAttrPublishRaw, true)
-			if pubRaw {
-				outputs.PublishOutputRaw(output, latestValue.Value, messageSigner)
+			policy := publisher.getPublishPolicy(node)
+			if policy.raw && publisher.isPublicationWanted(node.NodeID, types.MessageTypeRaw) {
+				rawValue, err := publisher.encodeRawValue(output, latestValue)
+				if err != nil {
+					logrus.Warningf("PublishOutputValues: output %s: %s. $raw not published.", outputID, err)
+				} else {
+					outputs.PublishOutputRaw(output, rawValue, messageSigner)
+					if publisher.config.RawSignatures {
+						outputs.PublishOutputRawSignature(output, rawValue, messageSigner)
+					}
+				}
 			}
-			pubLatest, _ := publisher.registeredNodes.GetNodeConfigBool(node.Address, types.NodeAttrPublishLatest, true)
-			if pubLatest {
-				outputs.PublishOutputLatest(output, latestValue, messageSigner)
+			if policy.latest && publisher.isPublicationWanted(node.NodeID, types.MessageTypeLatest) {
+				sequence := outputs.PublishOutputLatest(output, latestValue, messageSigner)
+				regOutputValues.SetSequence(outputID, latestValue.Timestamp, sequence)
 			}
-			pubHistory, _ := publisher.registeredNodes.GetNodeConfigBool(node.Address, types.NodeAttrPublishHistory, true)
-			if pubHistory {
+			if policy.history && publisher.isPublicationWanted(node.NodeID, types.MessageTypeHistory) {
 				history := regOutputValues.GetHistory(outputID)
 				outputs.PublishOutputHistory(output, history, messageSigner)
 			}
-			pubEvent, _ := publisher.registeredNodes.GetNodeConfigBool(node.Address, types.NodeAttrPublishEvent, false)
-			if pubEvent {
+			if policy.event && publisher.isPublicationWanted(node.NodeID, types.MessageTypeEvent) {
 				PublishOutputEvent(node, publisher.registeredOutputs, publisher.registeredOutputValues, messageSigner)
 			}
 		}
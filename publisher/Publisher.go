@@ -8,9 +8,11 @@ package publisher
 
 import (
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
-	"path"
+	"path/filepath"
 	"strings"
 	"sync"
 	"syscall"
@@ -23,6 +25,7 @@ import (
 	"github.com/iotdomain/iotdomain-go/nodes"
 	"github.com/iotdomain/iotdomain-go/outputs"
 	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/iotdomain/iotdomain-go/zeroconf"
 	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
 )
@@ -31,6 +34,15 @@ const (
 	// DefaultPollInterval in which the registered nodes, inputs and outputs are queried for
 	// polling based sources
 	DefaultPollInterval = 600
+	// DefaultShutdownDeadline is the max time to finish handlers and flush updates on shutdown
+	DefaultShutdownDeadline = 10 * time.Second
+	// MinShutdownHeartbeatWait is the min time to wait for the heartbeat to end when the drain used up the deadline
+	MinShutdownHeartbeatWait = 100 * time.Millisecond
+	// DefaultRepublishInterval in seconds of the discovery when the message bus doesn't retain messages
+	DefaultRepublishInterval = 30
+	// DefaultStatusInterval in seconds of republishing the publisher status while running. The status
+	// timestamp is a sample of the publisher clock for the clock skew estimate of the domain.
+	DefaultStatusInterval = 60
 
 	// RegisteredNodesFileSuffix to append to name of the file containing registered nodes
 	RegisteredNodesFileSuffix = "-nodes.json"
@@ -38,57 +50,123 @@ const (
 	RegisteredIdentityFileSuffix = "-identity.json"
 	// DomainPublishersFileSuffix to append to the name of the file containing domain publisher identities
 	DomainPublishersFileSuffix = "-domainpublishers.json"
+	// InputValuesFileSuffix to append to the name of the file containing the last received input values
+	InputValuesFileSuffix = "-inputvalues.json"
+	// CountersFileSuffix to append to the name of the file containing the accumulated counter outputs
+	CountersFileSuffix = "-counters.json"
+	// NodeIDMappingsFileSuffix to append to the name of the file containing the node IDs by hardware ID
+	NodeIDMappingsFileSuffix = "-nodeids.json"
+	// ForecastsFileSuffix to append to the name of the file containing the past forecasts of outputs
+	ForecastsFileSuffix = "-forecasts.json"
+	// SequencesFileSuffix to append to the name of the file containing the sequence nrs of publications
+	SequencesFileSuffix = "-sequences.json"
+	// TwinsFileSuffix to append to the name of the file containing the desired properties of node twins
+	TwinsFileSuffix = "-twins.json"
 	// note, domain nodes are not saved
 )
 
 // PublisherConfig defined configuration fields read from the application configuration
 type PublisherConfig struct {
-	SaveDiscoveredPublishers bool   `yaml:"cachePublishers"`   // load/save discovered publisher identities to cache
-	SaveDiscoveredNodes      bool   `yaml:"cacheNodes"`        // load/save discovered nodes to cache
-	CacheFolder              string `yaml:"cacheFolder"`       // location of discovered domain nodes and publishers
-	ConfigFolder             string `yaml:"configFolder"`      // location of yaml configuration files and registered nodes and identity
-	Domain                   string `yaml:"domain"`            // optional override per publisher. Default is local
-	PublisherID              string `yaml:"publisherId"`       // this publisher's ID
-	Loglevel                 string `yaml:"loglevel"`          // error, warning, info, debug
-	Logfile                  string `yaml:"logfile"`           //
-	DisableConfig            bool   `yaml:"disableConfig"`     // disable configuration over the bus, default is enabled
-	DisableInput             bool   `yaml:"disableInput"`      // disable inputs over the bus, default is enabled
-	DisablePublishers        bool   `yaml:"disablePublishers"` // disable listening for available publishers (enable for signature verification)
-	SecuredDomain            bool   `yaml:"securedDomain"`     // require secured domain and signed messages
+	SaveDiscoveredPublishers bool     `yaml:"cachePublishers"`   // load/save discovered publisher identities to cache
+	SaveDiscoveredNodes      bool     `yaml:"cacheNodes"`        // load/save discovered nodes to cache
+	SaveInputValues          bool     `yaml:"cacheInputs"`       // load/save last received input values to cache
+	RestoreInputValues       bool     `yaml:"restoreInputs"`     // replay cached input values to input handlers after the first discovery
+	SaveCounters             bool     `yaml:"cacheCounters"`     // load/save accumulated counter outputs to cache
+	SaveForecasts            bool     `yaml:"cacheForecasts"`    // load/save past forecasts for the forecast accuracy to cache
+	SaveSequences            bool     `yaml:"cacheSequences"`    // load/save the sequence nrs of publications to cache
+	SaveTwins                bool     `yaml:"cacheTwins"`        // load/save the desired properties of node twins to cache
+	CacheFolder              string   `yaml:"cacheFolder"`       // location of discovered domain nodes and publishers
+	ConfigFolder             string   `yaml:"configFolder"`      // location of yaml configuration files and registered nodes and identity
+	Domain                   string   `yaml:"domain"`            // optional override per publisher. Default is local
+	PublisherID              string   `yaml:"publisherId"`       // this publisher's ID
+	ControlSenders           []string `yaml:"controlSenders"`    // identity addresses of publishers allowed to send $control commands
+	Loglevel                 string   `yaml:"loglevel"`          // error, warning, info, debug
+	Logfile                  string   `yaml:"logfile"`           //
+	DisableConfig            bool     `yaml:"disableConfig"`     // disable configuration over the bus, default is enabled
+	DisableInput             bool     `yaml:"disableInput"`      // disable inputs over the bus, default is enabled
+	DisablePublishers        bool     `yaml:"disablePublishers"` // disable listening for available publishers (enable for signature verification)
+	SecuredDomain            bool     `yaml:"securedDomain"`     // require secured domain and signed messages
+	WidenTimeWindows         bool     `yaml:"widenTimeWindows"`  // widen time verification windows with the domain clock skew
+	ShutdownDeadline         int      `yaml:"shutdownDeadline"`  // seconds to finish handlers and flush on shutdown. Default is 10
+	PublishBudget            int      `yaml:"publishBudget"`     // max nr of updates published per heartbeat. Default (0) is unlimited
+	MissingNodeGrace         int      `yaml:"missingGrace"`      // seconds after the first discovery before cached nodes are missing. Default is 60
+	RemoveMissingDays        int      `yaml:"removeMissing"`     // days after which missing nodes are removed. Default (0) keeps them
+	PinPublisherKeys         bool     `yaml:"pinPublisherKeys"`  // pin self-signed publisher keys on first use and reject changed keys
+	SelfConfigure            bool     `yaml:"selfConfigure"`     // enable configuration of this publisher through its own node
+	PublisherNodeID          string   `yaml:"publisherNodeId"`   // fixed node ID of the publisher's own node. Default is its hardware ID 'publisher'
+	UnsignedTypes            []string `yaml:"unsignedTypes"`     // message types to publish without signature, eg $raw. Commands are always signed
+	CoerceOutputValues       bool     `yaml:"coerceOutputs"`     // convert output values to the output data type and drop values that can't be converted
+	AddressTemplate          string   `yaml:"addressTemplate"`   // also publish output values on this template for legacy consumers, eg legacy/{publisher}/{nodeId}/{type}/{instance}/{msgtype}
+	DeadLetterFile           string   `yaml:"deadLetterFile"`    // append received messages that are rejected to this file for debugging. Default is none
+	StopOnIdentityConflict   bool     `yaml:"stopOnConflict"`    // stop publishing when another publisher uses this publisher ID, to avoid split-brain
+	IdentityPriority         int      `yaml:"identityPriority"`  // priority of the claim to the publisher ID when another publisher uses it. Default is 0
+	RepublishOnJoin          int      `yaml:"republishOnJoin"`   // republish discovery when a publisher joins, at most once per this nr of seconds. Default (0) is disabled
+	RepublishInterval        int      `yaml:"republishInterval"` // seconds between republishing discovery when the message bus doesn't retain messages. Default is 30
+	StatusInterval           int      `yaml:"statusInterval"`    // seconds between republishing the publisher status for the clock skew estimate of the domain. Default is 60
+	ProvisionFile            string   `yaml:"provisionFile"`     // YAML file with nodes to pre-register before they are discovered. Relative to the config folder
+	InterestTypes            []string `yaml:"interestTypes"`     // message types only published while consumers announce $interest, eg $history, $event, $raw
+	RawSignatures            bool     `yaml:"rawSignatures"`     // publish $raw values unsigned with their detached signature on $rawsig
+	Advertise                bool     `yaml:"advertise"`         // advertise this publisher on the LAN with mDNS as _iotdomain._tcp
+	DomainLog                bool     `yaml:"domainLog"`         // publish security relevant events on $domainlog
+	PreflightTopics          bool     `yaml:"preflightTopics"`   // check at startup that the broker ACL authorizes the publications and subscriptions
+	ReplayBudget             int      `yaml:"replayBudget"`      // max nr of output values replayed per minute at the request of consumers. Default (0) is disabled
+	MaxNodes                 int      `yaml:"maxNodes"`          // max nr of nodes. New nodes are rejected when reached. Default (0) is unlimited
+	MaxNodeOutputs           int      `yaml:"maxNodeOutputs"`    // max nr of outputs per node. Default (0) is unlimited
+	MaxHistoryKB             int      `yaml:"maxHistoryKB"`      // max memory in KB of the history of output values. Default (0) is unlimited
+	MaxPublishRate           int      `yaml:"maxPublishRate"`    // max nr of value publications per second. Identity, status and discovery are exempt. Default (0) is unlimited
+	FlapTransitions          int      `yaml:"flapTransitions"`   // nr of appearances or runState changes within flapMinutes that make a node flap. Default (0) is disabled
+	FlapMinutes              int      `yaml:"flapMinutes"`       // time window in minutes of flap detection. Default (0) is 10 minutes
+	PprofListen              string   `yaml:"pprofListen"`       // listen address of the net/http/pprof endpoint, eg localhost:6060. Default is disabled
+	PprofAllowRemote         bool     `yaml:"pprofAllowRemote"`  // allow pprofListen on a non-loopback address. The endpoint has no authentication. Default is false
+	ProfileFolder            string   `yaml:"profileFolder"`     // folder of profiles captured with the profileCPU and profileHeap commands. Default is the cache folder
+
+	// role of publishers allowed to send $control commands by identity address: viewer, operator or admin
+	// Publishers in controlSenders have the admin role. See types.PublisherControlRoles for the required roles.
+	ControlRoles map[string]types.Role `yaml:"controlRoles"`
 }
 
 // Publisher carries the operating state of 'this' publisher
 type Publisher struct {
 	config PublisherConfig // determines publisher behavior
 
+	domainForecasts    *outputs.DomainForecasts              // merged forecasts of outputs from the domain
 	domainIdentities   *identities.DomainPublisherIdentities // discovered publisher identities
 	domainInputs       *inputs.DomainInputs                  // discovered inputs from the domain
 	domainNodes        *nodes.DomainNodes                    // discovered nodes from the domain
 	domainOutputs      *outputs.DomainOutputs                // discovered outputs from the domain
 	domainOutputValues *outputs.DomainOutputValues           // output values from the domain
+	domainStatus       *identities.DomainPublisherStatus     // runtime status of domain publishers
 
 	inputFromHTTP        *inputs.ReceiveFromHTTP        // trigger inputs with http poll result
 	inputFromFiles       *inputs.ReceiveFromFiles       // trigger inputs on file changes
 	inputFromOutputs     *inputs.ReceiveFromOutputs     // subscribe input to an output (latest) value
 	inputFromSetCommands *inputs.ReceiveFromSetCommands // trigger inputs with set commands for registered inputs
 
+	receiveControl          *identities.ReceivePublisherControl // listener for publisher control commands
 	receiveMyIdentityUpdate *identities.ReceiveRegisteredIdentityUpdate
 	receiveDomainIdentities *identities.ReceiveDomainPublisherIdentities // listener for identity updates
 	receiveNodeConfigure    *nodes.ReceiveNodeConfigure                  // listener for node configure for registered nodes
 	receiveSetNodeID        *nodes.ReceiveSetNodeID                      // listener for set node alias
+	receiveSetDesired       *nodes.ReceiveSetDesired                     // listener for set desired twin properties
 
+	registeredCounters       *outputs.RegisteredCounters       // accumulated counter outputs
 	registeredForecastValues *outputs.RegisteredForecastValues // output forecasts values published by this publisher
+	registeredGeofences      *outputs.RegisteredGeofences      // geofences of location outputs
 	registeredIdentity       *identities.RegisteredIdentity    // registered/published identity of this publisher
 	registeredInputs         *inputs.RegisteredInputs          // registered/published inputs from this publisher
 	registeredNodes          *nodes.RegisteredNodes            // registered/published nodes from this publisher
+	registeredNodeTwins      *nodes.NodeTwins                  // desired and reported properties of registered nodes
+	registeredOutputGroups   *outputs.RegisteredOutputGroups   // groups of outputs that are published together
 	registeredOutputs        *outputs.RegisteredOutputs        // registered/published outputs from this publisher
 	registeredOutputValues   *outputs.RegisteredOutputValues   // registered/published output values from this publisher
+	registeredStatistics     *outputs.RegisteredStatistics     // running statistics of output values
 
 	// fullIdentity        *types.PublisherFullIdentity                         // this publishers identity
 	// identityPrivateKey  *ecdsa.PrivateKey                                    // key for signing and encryption
 	isRunning bool // publisher was started and is running
 	// runStateAddress string
 
+	deadLetterHandler   messaging.DeadLetterHandler                          // application handler of rejected messages
 	messenger           messaging.IMessenger                                 // Message bus messenger to use
 	messageSigner       *messaging.MessageSigner                             // publishing signed messages
 	onNodeConfigHandler nodes.NodeConfigureHandler                           // handle before applying configuration
@@ -97,17 +175,126 @@ type Publisher struct {
 	pollCountdown       int                                                  // countdown each heartbeat
 	pollInterval        int                                                  // value polling interval in seconds
 
+	// cached nodes that aren't rediscovered are marked missing after the first discovery run
+	missingCheckCountdown int  // seconds until the check for missing nodes
+	missingCheckScheduled bool // the check for missing nodes has been scheduled
+
+	// cached input values are restored after the first discovery run as the poll handler creates the inputs
+	inputRestorePending bool
+
+	// outputs whose values are polled by running a command
+	execOutputs []*outputs.ExecOutput
+
+	// discovery is republished when publishers join, rate-limited by the republishOnJoin interval
+	joinBurstCountdown int  // seconds until the next republication is allowed
+	joinBurstPending   bool // a publisher joined during the countdown
+	republishCountdown int  // seconds until discovery is republished when the bus doesn't retain messages
+	statusCountdown    int  // seconds until the publisher status is republished
+
+	// nodes with a firmware update in progress
+	firmwareUpdating map[string]bool
+
+	// forced values of overridden outputs by output ID
+	outputOverrides map[string]string
+
+	// publication of updates is paused with this policy, "" when not paused
+	pausePolicy  PausePolicy
+	pausedStatus types.PublisherRunState // last status set while paused with the buffer policy
+
+	// another publisher uses this publisher ID with a different key
+	identityClaimError error                              // the other publisher holds the claim to the publisher ID
+	identityConflict   bool                               // a conflicting identity was received
+	onIdentityConflict identities.IdentityConflictHandler // optional application notification of conflicts
+
+	// optional application notification of received keys that differ from their pinned key
+	onPublisherKeyChange identities.KeyChangeHandler
+
+	// rejected signatures by sender that are aggregated in the domain log, see RejectedSignatureLogInterval
+	rejectedSignatures map[string]*rejectedSignatureCount
+
+	// nr of publications rejected by the publish rate quota at the last quota status update
+	rejectedPublications int
+
+	// clock skew tracking of the domain
+	clockInSync bool                       // the clock was in sync at the last heartbeat
+	timeSync    *identities.DomainTimeSync // clock skew estimate from received publisher status
+
+	// mDNS advertisement of this publisher, nil when advertising is disabled
+	advertiser *zeroconf.Advertiser
+
+	// runtime profiling, nil when not in use
+	cpuProfileFile  *os.File     // CPU profile that is being captured
+	cpuProfileTimer *time.Timer  // timer that ends the CPU profile of the profileCPU command
+	pprofListener   net.Listener // listener of the net/http/pprof endpoint
+	pprofServer     *http.Server // net/http/pprof endpoint enabled with pprofListen
+
+	// pinned keys of self-signed publishers, nil when pinning is disabled
+	trustStore *identities.TrustStore
+
+	// updates that exceed the publish budget of a heartbeat are carried over to the next heartbeat
+	pendingKeys    map[pendingUpdate]bool // pending updates for removing duplicates
+	pendingUpdates []pendingUpdate        // pending updates in order of update
+
+	// nr of output values that didn't match the output data type by output ID
+	valueViolations map[string]int
+
+	// resolved publish configuration of nodes by node hardware ID
+	publishPolicies map[string]publishPolicy
+
+	// interest of consumers in the message types of the interestTypes configuration
+	consumerInterest *outputs.ConsumerInterest
+
+	// replay of missed output values at the request of consumers
+	outputReplay *outputs.OutputReplay
+
+	// codecs of $raw output values that are configured with the rawCodec output configuration
+	valueCodecs *outputs.ValueCodecs
+
 	// background publications require a mutex to prevent concurrent access
 	heartbeatChannel chan bool
 	updateMutex      *sync.Mutex // mutex for async updating and publishing
 }
 
+// ClockSkew returns the estimated clock skew between the domain and this publisher,
+// and whether it is within the allowed maximum. The estimate is based on the timestamp of received
+// publisher status messages. The status of the domain publishers is subscribed to on start.
+func (pub *Publisher) ClockSkew() (skew time.Duration, inSync bool) {
+	return pub.timeSync.ClockSkew(), pub.timeSync.IsInSync()
+}
+
+// ClockSkewSamples returns the nr of received publisher status messages the clock skew estimate is
+// based on, up to the nr of samples that are averaged. 0 means the clock skew isn't estimated yet.
+func (pub *Publisher) ClockSkewSamples() int {
+	return pub.timeSync.SampleCount()
+}
+
+// GetConnectionStatus returns the state of the connection to the message bus, the nr of reconnects,
+// the round trip time to the broker and the last connection error
+func (pub *Publisher) GetConnectionStatus() messaging.ConnectionStatus {
+	return messaging.GetConnectionStatus(pub.messenger)
+}
+
+// GetReceiveStats returns the counters of received messages by message type and sender
+// The counters show verified messages and the messages that are rejected or dropped.
+func (pub *Publisher) GetReceiveStats() []messaging.ReceiveStatsRecord {
+	return pub.messageSigner.ReceiveStats().GetReceiveStats()
+}
+
+// HandlerPanicCount returns the nr of panics in application handlers that were recovered
+func (pub *Publisher) HandlerPanicCount() int {
+	return pub.messageSigner.HandlerGuard().PanicCount()
+}
+
 // HandleSetNodeIDCommand handles the command to change the ID of a node. This updates the address
 // of a node, its inputs and its outputs.
 func (pub *Publisher) HandleSetNodeIDCommand(address string, message *types.SetNodeIDMessage) {
 	node := pub.registeredNodes.GetNodeByAddress(address)
 	if node == nil {
 		return
+	} else if node.HWID == PublisherNodeHWID && pub.config.PublisherNodeID != "" {
+		logrus.Warningf("Publisher.HandleSetNodeIDCommand: Node ID of the publisher node is configured as '%s'. Command ignored.",
+			pub.config.PublisherNodeID)
+		return
 	}
 	pub.registeredNodes.SetNodeID(node, message.NodeID)
 	pub.registeredInputs.SetNodeID(node.HWID, message.NodeID)
@@ -117,39 +304,186 @@ func (pub *Publisher) HandleSetNodeIDCommand(address string, message *types.SetN
 // LoadDomainPublishers loads discovered publisher identities from the cache folder.
 // Intended to cache the public signing keys to verify messages from these publishers
 func (pub *Publisher) LoadDomainPublishers() error {
-	filename := path.Join(pub.config.CacheFolder, pub.PublisherID()+DomainPublishersFileSuffix)
+	filename := filepath.Join(pub.config.CacheFolder, pub.PublisherID()+DomainPublishersFileSuffix)
 	err := pub.domainIdentities.LoadIdentities(filename)
 	return err
 }
 
+// LoadCounters loads the accumulated counter outputs from the cache folder.
+func (pub *Publisher) LoadCounters() error {
+	filename := filepath.Join(pub.config.CacheFolder, pub.PublisherID()+CountersFileSuffix)
+	err := pub.registeredCounters.LoadCounters(filename)
+	return err
+}
+
+// LoadForecasts loads the past forecasts of outputs from the cache folder.
+// Intended to continue the forecast accuracy after a restart.
+func (pub *Publisher) LoadForecasts() error {
+	filename := filepath.Join(pub.config.CacheFolder, pub.PublisherID()+ForecastsFileSuffix)
+	err := pub.registeredForecastValues.LoadPastForecasts(filename)
+	return err
+}
+
+// LoadSequences loads the sequence nrs of publications from the cache folder.
+// Intended to keep the sequence nrs increasing after a restart, so consumers can detect missed values.
+func (pub *Publisher) LoadSequences() error {
+	filename := filepath.Join(pub.config.CacheFolder, pub.PublisherID()+SequencesFileSuffix)
+	err := pub.messageSigner.SequenceNumbers().LoadSequences(filename)
+	return err
+}
+
+// LoadTwins loads the desired properties of node twins from the cache folder.
+// Intended to restore the desired state of nodes after a restart. The handler of SetOnDesiredProperties
+// is notified of the pending properties of a loaded twin when its node exists.
+func (pub *Publisher) LoadTwins() error {
+	filename := filepath.Join(pub.config.CacheFolder, pub.PublisherID()+TwinsFileSuffix)
+	err := pub.registeredNodeTwins.LoadTwins(filename)
+	for _, node := range pub.registeredNodes.GetAllNodes() {
+		pub.registeredNodeTwins.NotifyLoadedTwin(node.HWID)
+	}
+	return err
+}
+
+// LoadInputValues loads the last received input values from the cache folder.
+// Intended to restore input values such as setpoints after a restart.
+func (pub *Publisher) LoadInputValues() error {
+	filename := filepath.Join(pub.config.CacheFolder, pub.PublisherID()+InputValuesFileSuffix)
+	err := pub.registeredInputs.LoadInputValues(filename)
+	return err
+}
+
+// LoadNodeIDMappings loads the node IDs by hardware ID from the config folder.
+// Intended to restore the node IDs of nodes before they are loaded or discovered.
+func (pub *Publisher) LoadNodeIDMappings() error {
+	filename := filepath.Join(pub.config.ConfigFolder, pub.PublisherID()+NodeIDMappingsFileSuffix)
+	err := pub.registeredNodes.NodeIDMappings().LoadMappings(filename)
+	return err
+}
+
 // LoadRegisteredNodes loads saved registered nodes from the config folder.
 // Intended to restore node configuration.
 func (pub *Publisher) LoadRegisteredNodes() error {
-	filename := path.Join(pub.config.ConfigFolder, pub.PublisherID()+RegisteredNodesFileSuffix)
+	filename := filepath.Join(pub.config.ConfigFolder, pub.PublisherID()+RegisteredNodesFileSuffix)
 	err := pub.registeredNodes.LoadNodes(filename)
 	return err
 }
 
+// RepublishAll immediately publishes the identity and the discovery of all registered nodes,
+// inputs and outputs, instead of only those that have been updated.
+// Intended for consumers that have lost their retained discovery messages.
+// This returns an error if another publisher holds the claim to this publisher's ID, or if publications are paused.
+func (pub *Publisher) RepublishAll() error {
+	if err := pub.IdentityClaimError(); err != nil {
+		logrus.Warningf("Publisher.RepublishAll: Not republishing publisher %s: %s", pub.PublisherID(), err)
+		return err
+	}
+	if pub.IsPaused() {
+		logrus.Warningf("Publisher.RepublishAll: Publications of publisher %s are paused", pub.PublisherID())
+		return fmt.Errorf("RepublishAll: Publications of publisher %s are paused", pub.PublisherID())
+	}
+	logrus.Infof("Publisher.RepublishAll: republish discovery of publisher %s", pub.PublisherID())
+	myIdent, _ := pub.registeredIdentity.GetFullIdentity()
+	identities.PublishIdentity(&myIdent.PublisherIdentityMessage, pub.messageSigner)
+	nodes.PublishRegisteredNodes(pub.registeredNodes.GetAllNodes(), pub.messageSigner)
+	inputs.PublishRegisteredInputs(pub.registeredInputs.GetAllInputs(), pub.messageSigner)
+	outputs.PublishRegisteredOutputs(pub.registeredOutputs.GetAllOutputs(), pub.messageSigner)
+	return nil
+}
+
+// SaveCounters saves the accumulated counter outputs to the cache folder
+func (pub *Publisher) SaveCounters() error {
+	filename := filepath.Join(pub.config.CacheFolder, pub.PublisherID()+CountersFileSuffix)
+	err := pub.registeredCounters.SaveCounters(filename)
+	return err
+}
+
 // SaveDomainPublishers saves discovered domain publisher identities
 func (pub *Publisher) SaveDomainPublishers() error {
-	filename := path.Join(pub.config.CacheFolder, pub.PublisherID()+DomainPublishersFileSuffix)
+	filename := filepath.Join(pub.config.CacheFolder, pub.PublisherID()+DomainPublishersFileSuffix)
 	err := pub.domainIdentities.SaveIdentities(filename)
 	return err
 }
 
+// SaveForecasts saves the past forecasts of outputs to the cache folder
+func (pub *Publisher) SaveForecasts() error {
+	filename := filepath.Join(pub.config.CacheFolder, pub.PublisherID()+ForecastsFileSuffix)
+	err := pub.registeredForecastValues.SavePastForecasts(filename)
+	return err
+}
+
+// SaveSequences saves the sequence nrs of publications to the cache folder
+func (pub *Publisher) SaveSequences() error {
+	filename := filepath.Join(pub.config.CacheFolder, pub.PublisherID()+SequencesFileSuffix)
+	err := pub.messageSigner.SequenceNumbers().SaveSequences(filename)
+	return err
+}
+
+// SaveTwins saves the desired properties of node twins to the cache folder
+func (pub *Publisher) SaveTwins() error {
+	filename := filepath.Join(pub.config.CacheFolder, pub.PublisherID()+TwinsFileSuffix)
+	err := pub.registeredNodeTwins.SaveTwins(filename)
+	return err
+}
+
+// SaveInputValues saves the last received input values to the cache folder
+func (pub *Publisher) SaveInputValues() error {
+	filename := filepath.Join(pub.config.CacheFolder, pub.PublisherID()+InputValuesFileSuffix)
+	err := pub.registeredInputs.SaveInputValues(filename)
+	return err
+}
+
+// SaveNodeIDMappings saves the node IDs by hardware ID to the config folder
+func (pub *Publisher) SaveNodeIDMappings() error {
+	filename := filepath.Join(pub.config.ConfigFolder, pub.PublisherID()+NodeIDMappingsFileSuffix)
+	err := pub.registeredNodes.NodeIDMappings().SaveMappings(filename)
+	return err
+}
+
 // SaveRegisteredNodes saves current registered nodes to the config folder
 func (pub *Publisher) SaveRegisteredNodes() error {
-	filename := path.Join(pub.config.ConfigFolder, pub.PublisherID()+RegisteredNodesFileSuffix)
+	filename := filepath.Join(pub.config.ConfigFolder, pub.PublisherID()+RegisteredNodesFileSuffix)
 	err := pub.registeredNodes.SaveNodes(filename)
 	return err
 }
 
 // SetNodeConfigHandler set the handler for updating node configuration.
 // The handler is invoked if a configuration update for a node is received and the node exists.
+// Configuration of the publisher node itself is handled by the publisher.
 func (pub *Publisher) SetNodeConfigHandler(
 	handler func(nodeHWID string, config types.NodeAttrMap)) {
 
-	pub.receiveNodeConfigure.SetConfigureNodeHandler(handler)
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	pub.onNodeConfigHandler = handler
+}
+
+// SetNodeValidateHandler set the handler for validating node configuration without applying it.
+// The handler is invoked on validate-only configuration commands with the values that would change,
+// and returns the values it rejects with the reason.
+func (pub *Publisher) SetNodeValidateHandler(
+	handler func(nodeHWID string, config types.NodeAttrMap) map[types.NodeAttr]string) {
+
+	pub.receiveNodeConfigure.SetValidateNodeHandler(handler)
+}
+
+// SetOnHandlerError sets the callback that is invoked when an application handler returns an error or panics.
+// This includes message, input and poll handlers. Panics are recovered and logged with their stack trace.
+// Intended for the application to decide whether to restart or degrade.
+func (pub *Publisher) SetOnHandlerError(callback messaging.HandlerErrorCallback) {
+	pub.messageSigner.HandlerGuard().SetOnHandlerError(callback)
+}
+
+// SetOnPublisherKeyChange sets the callback that is invoked when a self-signed publisher identity is
+// received whose key differs from its pinned key. The identity is rejected.
+// Requires the pinPublisherKeys configuration. Intended to raise a security alert.
+func (pub *Publisher) SetOnPublisherKeyChange(handler identities.KeyChangeHandler) {
+	if pub.trustStore == nil {
+		logrus.Warningf("Publisher.SetOnPublisherKeyChange: Publisher key pinning is not enabled")
+		return
+	}
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	pub.onPublisherKeyChange = handler
 }
 
 // SetPollInterval is a convenience function for periodic polling of updates to registered
@@ -166,12 +500,62 @@ func (pub *Publisher) SetPollInterval(seconds int, handler func(pub *Publisher))
 	pub.pollHandler = handler
 }
 
+// SetDeadLetterHandler sets the handler that is invoked with received messages that are rejected,
+// eg because they fail signature or schema checks, with the raw payload and the reason.
+// Intended for debugging interoperability problems. Use nil to remove the handler.
+func (pub *Publisher) SetDeadLetterHandler(handler messaging.DeadLetterHandler) {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	pub.deadLetterHandler = handler
+}
+
+// SetPreDeliverHook sets the hook that filters or modifies received messages before they are handled
+// by this publisher. Received messages are signed and possibly encrypted. Use nil to remove the hook.
+func (pub *Publisher) SetPreDeliverHook(hook messaging.MessageHook) {
+	pub.messageSigner.SetPreDeliverHook(hook)
+}
+
+// SetPrePublishHook sets the hook that filters or modifies the payload of messages before they are
+// signed and published by this publisher. Intended for redaction or enrichment. Use nil to remove the hook.
+func (pub *Publisher) SetPrePublishHook(hook messaging.MessageHook) {
+	pub.messageSigner.SetPrePublishHook(hook)
+}
+
 // SetPublisherStatus sets the publisher runtime status and publishes the message
+// The status is not published when another publisher holds the claim to the publisher ID. While
+// publications are paused the status is published on resume, or dropped, depending on the pause policy.
 func (pub *Publisher) SetPublisherStatus(status types.PublisherRunState) {
+	if pub.IdentityClaimError() != nil {
+		logrus.Warningf("Publisher.SetPublisherStatus: Status %s of publisher %s not published. %s",
+			status, pub.PublisherID(), pub.IdentityClaimError())
+		return
+	}
+	pub.updateMutex.Lock()
+	pausePolicy := pub.pausePolicy
+	if pausePolicy == PausePolicyBuffer {
+		pub.pausedStatus = status
+	}
+	pub.updateMutex.Unlock()
+	if pausePolicy != "" {
+		logrus.Infof("Publisher.SetPublisherStatus: Publications are paused. Status %s is not published", status)
+		return
+	}
 	addr := identities.MakePublisherStatusAddress(pub.Domain(), pub.PublisherID())
+	clockSkew := pub.timeSync.ClockSkew()
 	msg := types.PublisherStatusMessage{
-		Address: addr,
-		Status:  status,
+		Address:        addr,
+		ClockOutOfSync: !pub.timeSync.IsInSync(),
+		ClockSkewMSec:  int64(clockSkew / time.Millisecond),
+		Status:         status,
+		Timestamp:      time.Now().Format(types.TimeFormat),
+	}
+	pub.updateMutex.Lock()
+	msg.IdentityConflict = pub.identityConflict
+	pub.updateMutex.Unlock()
+	if pub.registeredIdentity.DaysUntilExpiry() <= identities.DefaultIdentityExpiryWarningDays {
+		ident, _ := pub.registeredIdentity.GetFullIdentity()
+		msg.IdentityExpiry = ident.ValidUntil
+		logrus.Warningf("Publisher.SetPublisherStatus: Identity of publisher %s expires at %s", pub.PublisherID(), ident.ValidUntil)
 	}
 	identities.PublishStatus(&msg, pub.messageSigner)
 }
@@ -185,6 +569,10 @@ func (pub *Publisher) Start() {
 		pub.updateMutex.Lock()
 		pub.isRunning = true
 		pub.updateMutex.Unlock()
+		// accept messages after a previous shutdown
+		pub.messageSigner.HandlerGuard().Resume()
+		// remotely configured settings of the publisher node override the local settings
+		pub.startPublisherNode()
 
 		go pub.heartbeatLoop()
 		// wait for the heartbeat to start
@@ -194,6 +582,12 @@ func (pub *Publisher) Start() {
 		myIdent, _ := pub.registeredIdentity.GetFullIdentity()
 		pub.domainIdentities.AddIdentity(&myIdent.PublisherIdentityMessage)
 
+		// reload pinned publisher keys and pin our own key to avoid false alarms after a key renewal
+		if pub.trustStore != nil {
+			pub.trustStore.Load()
+			pub.trustStore.Pin(pub.Domain()+"/"+pub.PublisherID(), myIdent.PublicKey)
+		}
+
 		// reload previously discovered publishers
 		if pub.config.SaveDiscoveredPublishers {
 			pub.domainIdentities.LoadIdentities(pub.config.CacheFolder)
@@ -202,10 +596,37 @@ func (pub *Publisher) Start() {
 		if pub.config.SaveDiscoveredNodes {
 			pub.domainNodes.LoadNodes(pub.config.CacheFolder)
 		}
+		// reload and replay the last received input values to registered inputs
+		if pub.config.SaveInputValues || pub.config.RestoreInputValues {
+			pub.LoadInputValues()
+		}
+		if pub.config.RestoreInputValues {
+			pub.updateMutex.Lock()
+			pub.inputRestorePending = true
+			pub.updateMutex.Unlock()
+		}
+		// reload the accumulated totals of counter outputs
+		if pub.config.SaveCounters {
+			pub.LoadCounters()
+		}
+		// reload the past forecasts of the forecast accuracy
+		if pub.config.SaveForecasts {
+			pub.LoadForecasts()
+		}
+		// reload the sequence nrs of publications to continue where they left off
+		if pub.config.SaveSequences {
+			pub.LoadSequences()
+		}
+		// restore the desired properties of node twins
+		if pub.config.SaveTwins {
+			pub.LoadTwins()
+		}
 
 		// discover domain entities, eg identities, nodes, inputs and outputs
 		if !pub.config.DisablePublishers {
 			pub.receiveDomainIdentities.Start()
+			// the periodic status of domain publishers is a sample of their clock. The DSS clock is leading.
+			pub.domainStatus.Subscribe(pub.Domain(), "+")
 		}
 		// receive registered input set commands
 		if !pub.config.DisableInput {
@@ -214,7 +635,22 @@ func (pub *Publisher) Start() {
 		// Receive registered node configuration commands
 		if !pub.config.DisableConfig {
 			pub.receiveNodeConfigure.Start()
+			pub.receiveSetDesired.Start()
+		}
+		// Receive control commands from authorized senders
+		if len(pub.config.ControlSenders) > 0 || len(pub.config.ControlRoles) > 0 {
+			pub.receiveControl.Start()
+		}
+		// Receive the interest of consumers in conditional publications
+		if len(pub.config.InterestTypes) > 0 {
+			pub.consumerInterest.Start()
+		}
+		// Replay missed output values at the request of consumers
+		if pub.config.ReplayBudget > 0 {
+			pub.outputReplay.Start()
 		}
+		// Receive the output values that are replayed at the request of this publisher
+		pub.domainOutputValues.SubscribeReplay(pub.Address())
 		// in secured domains the DSS can update the identity
 		if pub.config.SecuredDomain {
 			pub.receiveMyIdentityUpdate.Start()
@@ -224,49 +660,253 @@ func (pub *Publisher) Start() {
 		pub.messenger.Connect(lwtStatusAddress, string(types.PublisherRunStateLost))
 
 		pub.SetPublisherStatus(types.PublisherRunStateConnected)
-		identities.PublishIdentity(&myIdent.PublisherIdentityMessage, pub.messageSigner)
+		// a publisher that lost the claim to its ID doesn't overwrite the other publisher's identity
+		if pub.IdentityClaimError() == nil {
+			identities.PublishIdentity(&myIdent.PublisherIdentityMessage, pub.messageSigner)
+		}
+		if pub.config.PreflightTopics {
+			// the broker can take a while to confirm the topics so don't hold up the start
+			go pub.preflightTopics()
+		}
+
+		// let others on the LAN locate this publisher, eg the DSS
+		if pub.advertiser != nil {
+			err := pub.advertiser.Start()
+			if err != nil {
+				logrus.Warningf("Publisher.Start: %s", err)
+			}
+		}
+		pub.startPprofServer()
 	}
 }
 
-// Stop publishing, and set the run status to disconnected and disconnect from the
-// message bus. Wait until the heartbeat loop has finished processing messages
-func (pub *Publisher) Stop() {
-	logrus.Warningf("Publisher.Stop: Stopping publisher %s", pub.PublisherID())
-	pub.updateMutex.Lock()
-	if pub.isRunning {
-		pub.isRunning = false
+// Shutdown drains and stops the publisher within the given deadline. This:
+// - stops accepting new messages and commands
+// - waits for in-flight message, input and poll handlers to finish
+// - publishes pending updates and saves the caches
+// - sets the run status to disconnected and disconnects from the message bus
+//  deadline is the max time to wait for handlers. Use 0 for the configured shutdown deadline.
+// This returns an error if the deadline expired, in which case the publisher is still stopped.
+func (pub *Publisher) Shutdown(deadline time.Duration) error {
+	if deadline <= 0 {
+		deadline = time.Duration(pub.config.ShutdownDeadline) * time.Second
+	}
+	if deadline <= 0 {
+		deadline = DefaultShutdownDeadline
+	}
+	logrus.Warningf("Publisher.Shutdown: Shutting down publisher %s within %s", pub.PublisherID(), deadline)
+	expiry := time.Now().Add(deadline)
 
-		pub.receiveMyIdentityUpdate.Stop()
-		pub.receiveDomainIdentities.Stop()
-		pub.receiveNodeConfigure.Stop()
-		pub.receiveSetNodeID.Stop()
+	err := pub.messageSigner.HandlerGuard().Drain(deadline)
+	if err != nil {
+		logrus.Errorf("Publisher.Shutdown: %s", err)
+	}
+	// a timeout of 0 waits forever so don't let an expired deadline hang the shutdown
+	heartbeatWait := time.Until(expiry)
+	if heartbeatWait < MinShutdownHeartbeatWait {
+		heartbeatWait = MinShutdownHeartbeatWait
+	}
+	if !pub.stopRunning(heartbeatWait) && err == nil {
+		err = lib.MakeErrorf("Shutdown: Heartbeat of publisher %s didn't end within %s", pub.PublisherID(), deadline)
+		logrus.Errorf("Publisher.%s", err)
+	}
 
-		pub.updateMutex.Unlock()
-		// wait for heartbeat to end
-		<-pub.heartbeatChannel
-	} else {
-		pub.updateMutex.Unlock()
+	// flush pending publications and caches
+	pub.PublishUpdates()
+	if pub.config.SaveDiscoveredPublishers {
+		pub.SaveDomainPublishers()
+	}
+	if pub.config.SaveInputValues {
+		pub.SaveInputValues()
+	}
+	if pub.config.SaveCounters {
+		pub.SaveCounters()
+	}
+	if pub.config.SaveForecasts {
+		pub.SaveForecasts()
+	}
+	if pub.config.SaveSequences {
+		pub.SaveSequences()
+	}
+	if pub.config.SaveTwins {
+		pub.SaveTwins()
 	}
 	pub.SetPublisherStatus(types.PublisherRunStateDisconnected)
 	pub.messenger.Disconnect()
 	logrus.Info("... bye bye")
+	return err
+}
+
+// Stop publishing, and set the run status to disconnected and disconnect from the
+// message bus. Wait until the heartbeat loop has finished processing messages.
+// Use Shutdown to also wait for in-flight handlers and flush pending updates.
+func (pub *Publisher) Stop() {
+	logrus.Warningf("Publisher.Stop: Stopping publisher %s", pub.PublisherID())
+	pub.stopRunning(0)
+	pub.SetPublisherStatus(types.PublisherRunStateDisconnected)
+	pub.messenger.Disconnect()
+	logrus.Info("... bye bye")
+}
+
+// TriggerDiscovery immediately invokes the poll handler to discover nodes, inputs and outputs
+// instead of waiting for the poll interval to expire. The next poll is scheduled a full poll
+// interval from now. This does nothing if no poll handler is set.
+func (pub *Publisher) TriggerDiscovery() {
+	pub.updateMutex.Lock()
+	pollHandler := pub.pollHandler
+	pub.pollCountdown = pub.pollInterval
+	pub.updateMutex.Unlock()
+
+	logrus.Infof("Publisher.TriggerDiscovery: trigger discovery of publisher %s", pub.PublisherID())
+	pub.PollExecOutputs()
+	if pollHandler != nil {
+		pub.invokePollHandler(pollHandler)
+		pub.scheduleMissingCheck()
+	}
+	pub.restorePendingInputValues()
 }
 
 // WaitForSignal waits until a TERM or INT signal is received
+// A HUP signal reloads the publisher identity from file and continues waiting. See ReloadIdentity.
 func (pub *Publisher) WaitForSignal() {
 
 	// catch all signals since not explicitly listing
 	exitChannel := make(chan os.Signal, 1)
 
-	//signal.Notify(exitChannel, syscall.SIGTERM|syscall.SIGHUP|syscall.SIGINT)
-	signal.Notify(exitChannel, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(exitChannel, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(exitChannel)
 
 	sig := <-exitChannel
+	for sig == syscall.SIGHUP {
+		log.Warningf("RECEIVED SIGNAL: %s. Reloading identity", sig)
+		pub.ReloadIdentity()
+		sig = <-exitChannel
+	}
 	log.Warningf("RECEIVED SIGNAL: %s", sig)
 	fmt.Println()
 	fmt.Println(sig)
 }
 
+// WaitForSignalAndShutdown waits until a TERM or INT signal is received and shuts down the publisher
+// within the configured shutdown deadline. See also Shutdown.
+func (pub *Publisher) WaitForSignalAndShutdown() error {
+	pub.WaitForSignal()
+	return pub.Shutdown(0)
+}
+
+// checkTimeSync publishes the publisher status when the clock gets in or out of sync with the domain
+func (pub *Publisher) checkTimeSync() {
+	inSync := pub.timeSync.IsInSync()
+	pub.updateMutex.Lock()
+	changed := inSync != pub.clockInSync
+	pub.clockInSync = inSync
+	pub.updateMutex.Unlock()
+	if !changed {
+		return
+	}
+	if !inSync {
+		logrus.Warningf("Publisher.checkTimeSync: Clock of publisher %s is out of sync with the domain by %s",
+			pub.PublisherID(), pub.timeSync.ClockSkew())
+	} else {
+		logrus.Infof("Publisher.checkTimeSync: Clock of publisher %s is back in sync with the domain", pub.PublisherID())
+	}
+	pub.SetPublisherStatus(types.PublisherRunStateConnected)
+}
+
+// updateStatusRepublish counts down the status interval each heartbeat and republishes the publisher
+// status when it has passed, so other publishers receive fresh samples of this publisher's clock
+func (pub *Publisher) updateStatusRepublish() {
+	pub.updateMutex.Lock()
+	pub.statusCountdown--
+	republishNow := pub.isRunning && pub.statusCountdown <= 0
+	if republishNow {
+		pub.statusCountdown = pub.config.StatusInterval
+	}
+	pub.updateMutex.Unlock()
+	if republishNow {
+		pub.SetPublisherStatus(types.PublisherRunStateConnected)
+	}
+}
+
+// handleDeadLetter logs a rejected message, appends it to the dead letter file if configured and
+// passes it to the application dead letter handler
+func (pub *Publisher) handleDeadLetter(letter *messaging.DeadLetter) {
+	logrus.Warningf("Publisher.handleDeadLetter: Message on %s is rejected: %s", letter.Address, letter.Reason)
+	pub.updateMutex.Lock()
+	handler := pub.deadLetterHandler
+	deadLetterFile := pub.config.DeadLetterFile
+	pub.updateMutex.Unlock()
+	if deadLetterFile != "" {
+		err := messaging.AppendDeadLetter(deadLetterFile, letter)
+		if err != nil {
+			logrus.Errorf("Publisher.handleDeadLetter: Unable to write to %s: %s", deadLetterFile, err)
+		}
+	}
+	if handler != nil {
+		handler(letter)
+	}
+}
+
+// invokePollHandler invokes the poll handler and recovers if it panics
+func (pub *Publisher) invokePollHandler(pollHandler func(pub *Publisher)) {
+	pub.messageSigner.HandlerGuard().Invoke("", func() error {
+		pollHandler(pub)
+		return nil
+	})
+}
+
+// restorePendingInputValues replays the cached input values to the input handlers if a restore is pending
+// Invoked after discovery so the inputs created by the poll handler are restored as well.
+func (pub *Publisher) restorePendingInputValues() {
+	pub.updateMutex.Lock()
+	restoreNow := pub.inputRestorePending
+	pub.inputRestorePending = false
+	pub.updateMutex.Unlock()
+	if restoreNow {
+		pub.registeredInputs.RestoreInputValues()
+	}
+}
+
+// stopRunning stops the receivers and waits for the heartbeat loop to end
+//  timeout is the max time to wait for the heartbeat. Use 0 to wait until it ends.
+// This returns false if the heartbeat didn't end within the timeout.
+func (pub *Publisher) stopRunning(timeout time.Duration) bool {
+	pub.updateMutex.Lock()
+	if !pub.isRunning {
+		pub.updateMutex.Unlock()
+		return true
+	}
+	pub.isRunning = false
+
+	pub.receiveControl.Stop()
+	pub.consumerInterest.Stop()
+	pub.outputReplay.Stop()
+	pub.domainOutputValues.UnsubscribeReplay(pub.Address())
+	pub.receiveMyIdentityUpdate.Stop()
+	pub.receiveDomainIdentities.Stop()
+	pub.domainStatus.Unsubscribe(pub.Domain(), "+")
+	pub.receiveNodeConfigure.Stop()
+	pub.receiveSetNodeID.Stop()
+	pub.receiveSetDesired.Stop()
+	if pub.advertiser != nil {
+		pub.advertiser.Stop()
+	}
+	pub.stopPprofServer()
+	pub.updateMutex.Unlock()
+
+	// wait for heartbeat to end
+	if timeout <= 0 {
+		<-pub.heartbeatChannel
+		return true
+	}
+	select {
+	case <-pub.heartbeatChannel:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
 // Main heartbeat loop to publish, discove and poll value updates
 func (pub *Publisher) heartbeatLoop() {
 	logrus.Infof("Publisher.heartbeatLoop: starting heartbeat loop")
@@ -276,18 +916,57 @@ func (pub *Publisher) heartbeatLoop() {
 		time.Sleep(time.Second)
 
 		// FIXME: The duration of publishing these updates adds to the heartbeat which delays the heartbeat
-		pub.PublishUpdates()
+		// Use the publish budget to limit the nr of updates published in a heartbeat.
+		pub.updateMutex.Lock()
+		publishBudget := pub.config.PublishBudget
+		pub.updateMutex.Unlock()
+		pub.publishUpdates(publishBudget)
 
 		if pub.config.SaveDiscoveredPublishers && pub.domainIdentities.UpdateCount() > 0 {
 			pub.SaveDomainPublishers()
 		}
+		if pub.config.SaveInputValues && pub.registeredInputs.ValueUpdateCount() > 0 {
+			pub.SaveInputValues()
+		}
+		if pub.config.SaveCounters && pub.registeredCounters.UpdateCount() > 0 {
+			pub.SaveCounters()
+		}
+		if pub.config.SaveForecasts && pub.registeredForecastValues.PastForecastUpdateCount() > 0 {
+			pub.SaveForecasts()
+		}
+		if pub.config.SaveSequences && pub.messageSigner.SequenceNumbers().UpdateCount() > 0 {
+			pub.SaveSequences()
+		}
+		if pub.config.SaveTwins && pub.registeredNodeTwins.UpdateCount() > 0 {
+			pub.SaveTwins()
+		}
+		pub.checkTimeSync()
+		pub.updatePublisherNodeStatus()
+		pub.updateQuotaStatus()
+		pub.registeredNodes.UpdateFlapStatus()
+		pub.updateJoinBurst()
+		pub.updateRepublish()
+		pub.updateStatusRepublish()
+		pub.updateRejectedSignatures()
 
 		// poll for discovery and values of registered nodes, inputs and outputs
-		if (pub.pollCountdown <= 0) && (pub.pollHandler != nil) {
-			pub.pollHandler(pub)
+		pub.updateMutex.Lock()
+		pollNow := (pub.pollCountdown <= 0) && (pub.pollHandler != nil || len(pub.execOutputs) > 0)
+		if pollNow {
 			pub.pollCountdown = pub.pollInterval
 		}
 		pub.pollCountdown--
+		pollHandler := pub.pollHandler
+		pub.updateMutex.Unlock()
+		if pollNow {
+			pub.PollExecOutputs()
+			if pollHandler != nil {
+				pub.invokePollHandler(pollHandler)
+				pub.scheduleMissingCheck()
+			}
+		}
+		pub.updateMissingCheck()
+		pub.restorePendingInputValues()
 
 		pub.updateMutex.Lock()
 		isRunning := pub.isRunning
@@ -361,7 +1040,8 @@ func SetLogging(levelName string, filename string) error {
 //
 // signingMethod indicates if and how publications must be signed. The default is jws. For testing 'none' can be used.
 //
-// messenger for publishing onto the message bus is required
+// messenger for publishing onto the message bus is required. Use a messaging.MultiMessenger to publish
+// onto multiple message busses, eg a local broker and a subset to a cloud broker.
 func NewPublisher(config *PublisherConfig, messenger messaging.IMessenger,
 ) *Publisher {
 
@@ -377,20 +1057,59 @@ func NewPublisher(config *PublisherConfig, messenger messaging.IMessenger,
 	if config.ConfigFolder == "" {
 		config.ConfigFolder = lib.DefaultConfigFolder
 	}
+	if config.RepublishInterval <= 0 {
+		config.RepublishInterval = DefaultRepublishInterval
+	}
+	if config.StatusInterval <= 0 {
+		config.StatusInterval = DefaultStatusInterval
+	}
 	SetLogging(config.Loglevel, config.Logfile)
+	if config.AddressTemplate != "" {
+		err := outputs.ValidateAddressTemplate(config.AddressTemplate, config.Domain, config.PublisherID)
+		if err != nil {
+			logrus.Errorf("NewPublisher: %s. Compat publications are disabled.", err)
+			config.AddressTemplate = ""
+		}
+	}
 
-	identityFile := path.Join(config.ConfigFolder, config.PublisherID+RegisteredIdentityFileSuffix)
+	identityFile := filepath.Join(config.ConfigFolder, config.PublisherID+RegisteredIdentityFileSuffix)
 	registeredIdentity := identities.NewRegisteredIdentity(
 		config.Domain, config.PublisherID, identityFile)
 	_, privKey, err := registeredIdentity.LoadIdentity()
 	if err != nil {
 		// save the identity as the loaded one isnt' valid
 		registeredIdentity.SaveIdentity()
+		// use the key of the new identity
+		privKey = registeredIdentity.GetPrivateKey()
+	}
+	unsignedTypes := make([]types.MessageType, 0)
+	for _, messageType := range config.UnsignedTypes {
+		if messaging.IsSigningRequired(types.MessageType(messageType)) {
+			logrus.Errorf("NewPublisher: Messages of type %s must be signed. Ignored.", messageType)
+			continue
+		}
+		unsignedTypes = append(unsignedTypes, types.MessageType(messageType))
+	}
+	// raw values with a detached signature are published as is for consumers that can't parse JWS
+	if config.RawSignatures {
+		unsignedTypes = append(unsignedTypes, types.MessageTypeRaw, types.MessageTypeRawSignature)
+	}
+	// the unsigned types are declared in the identity so receivers accept them
+	err = registeredIdentity.SetUnsignedTypes(unsignedTypes)
+	if err != nil {
+		logrus.Errorf("NewPublisher: %s", err)
+	}
+	err = registeredIdentity.SetPriority(config.IdentityPriority)
+	if err != nil {
+		logrus.Errorf("NewPublisher: %s", err)
 	}
 	domainIdentities := identities.NewDomainPublisherIdentities()
 
 	// These are the basis for signing and identifying publishers
 	messageSigner := messaging.NewMessageSigner(messenger, privKey, domainIdentities.GetPublisherKey)
+	myIdent, _ := registeredIdentity.GetFullIdentity()
+	messageSigner.SetUnsignedMessageTypes(myIdent.UnsignedTypes)
+	messageSigner.SetIsUnsignedAllowed(domainIdentities.IsUnsignedMessageType)
 
 	// application services
 	domainInputs := inputs.NewDomainInputs(messageSigner)
@@ -399,14 +1118,37 @@ func NewPublisher(config *PublisherConfig, messenger messaging.IMessenger,
 	domainOutputValues := outputs.NewDomainOutputValues(messageSigner)
 	registeredInputs := inputs.NewRegisteredInputs(config.Domain, config.PublisherID)
 	registeredNodes := nodes.NewRegisteredNodes(config.Domain, config.PublisherID)
+	registeredNodes.SetFlapDetection(config.FlapTransitions, time.Duration(config.FlapMinutes)*time.Minute)
 	registeredOutputs := outputs.NewRegisteredOutputs(config.Domain, config.PublisherID)
 	registeredOutputValues := outputs.NewRegisteredOutputValues(config.Domain, config.PublisherID)
 	registeredForecastValues := outputs.NewRegisteredForecastValues(config.Domain, config.PublisherID)
 
+	receiveControl := identities.NewReceivePublisherControl(config.Domain, config.PublisherID,
+		config.ControlSenders, nil, messageSigner)
+	receiveControl.SetSenderRoles(config.ControlRoles)
 	receiveMyIdentityUpdate := identities.NewReceiveRegisteredIdentityUpdate(
 		registeredIdentity, messageSigner)
 	receiveDomainIdentities := identities.NewReceivePublisherIdentities(config.Domain,
 		domainIdentities, messageSigner)
+	timeSync := identities.NewDomainTimeSync(0)
+	timeSync.SetAutoWiden(config.WidenTimeWindows)
+	timeSync.SetLocalPublisherID(config.PublisherID)
+	receiveDomainIdentities.SetTimeSync(timeSync)
+	domainStatus := identities.NewDomainPublisherStatus(messageSigner)
+	domainStatus.SetTimeSync(timeSync)
+	var trustStore *identities.TrustStore
+	if config.PinPublisherKeys {
+		trustStoreFile := filepath.Join(config.ConfigFolder, config.PublisherID+identities.TrustStoreFileSuffix)
+		trustStore = identities.NewTrustStore(trustStoreFile, nil)
+		receiveDomainIdentities.SetTrustStore(trustStore)
+	}
+	var advertiser *zeroconf.Advertiser
+	if config.Advertise {
+		advertiser = zeroconf.NewAdvertiser(config.PublisherID, zeroconf.PublisherServiceType, 0, map[string]string{
+			zeroconf.TextDomain:    config.Domain,
+			zeroconf.TextPublisher: config.PublisherID,
+		})
+	}
 	receiveNodeConfigure := nodes.NewReceiveNodeConfigure(
 		config.Domain, config.PublisherID, nil, messageSigner, registeredNodes, privKey)
 	receiveSetNodeID := nodes.NewReceiveSetNodeID(
@@ -415,10 +1157,12 @@ func NewPublisher(config *PublisherConfig, messenger messaging.IMessenger,
 	var pub = &Publisher{
 		config:             *config,
 		domainIdentities:   domainIdentities,
+		domainForecasts:    outputs.NewDomainForecasts(messageSigner),
 		domainInputs:       domainInputs,
 		domainNodes:        domainNodes,
 		domainOutputs:      domainOutputs,
 		domainOutputValues: domainOutputValues,
+		domainStatus:       domainStatus,
 
 		inputFromSetCommands: inputs.NewReceiveFromSetCommands(
 			config.Domain, config.PublisherID, messageSigner, registeredInputs),
@@ -426,7 +1170,11 @@ func NewPublisher(config *PublisherConfig, messenger messaging.IMessenger,
 		inputFromFiles:   inputs.NewReceiveFromFiles(registeredInputs),
 		inputFromOutputs: inputs.NewReceiveFromOutputs(messageSigner, registeredInputs),
 
+		firmwareUpdating: make(map[string]bool),
 		heartbeatChannel: make(chan bool),
+		outputOverrides:  make(map[string]string),
+		pendingKeys:      make(map[pendingUpdate]bool),
+		publishPolicies:  make(map[string]publishPolicy),
 		// fullIdentity:       identity,
 		// identityPrivateKey: privateKey,
 		// runStateAddress: fmt.Sprintf("%s/%s/%s", config.Domain, config.PublisherID, types.MessageTypeRunState),
@@ -435,24 +1183,69 @@ func NewPublisher(config *PublisherConfig, messenger messaging.IMessenger,
 		messageSigner:           messageSigner,
 		pollCountdown:           0,
 		pollInterval:            DefaultPollInterval,
+		receiveControl:          receiveControl,
 		receiveDomainIdentities: receiveDomainIdentities,
 		receiveMyIdentityUpdate: receiveMyIdentityUpdate,
 		receiveNodeConfigure:    receiveNodeConfigure,
 		receiveSetNodeID:        receiveSetNodeID,
+		receiveSetDesired:       nodes.NewReceiveSetDesired(config.Domain, config.PublisherID, nil, messageSigner),
 
+		registeredCounters:       outputs.NewRegisteredCounters(),
 		registeredForecastValues: registeredForecastValues,
+		registeredGeofences:      outputs.NewRegisteredGeofences(),
 		registeredIdentity:       registeredIdentity,
 		registeredInputs:         registeredInputs,
 		registeredNodes:          registeredNodes,
+		registeredNodeTwins:      nodes.NewNodeTwins(),
+		registeredOutputGroups:   outputs.NewRegisteredOutputGroups(),
 		registeredOutputs:        registeredOutputs,
 		registeredOutputValues:   registeredOutputValues,
+		registeredStatistics:     outputs.NewRegisteredStatistics(),
 
+		advertiser:  advertiser,
+		clockInSync: true,
+		timeSync:    timeSync,
+		trustStore:  trustStore,
 		updateMutex: &sync.Mutex{},
+
+		rejectedSignatures: make(map[string]*rejectedSignatureCount),
+		valueViolations:    make(map[string]int),
+
+		consumerInterest: outputs.NewConsumerInterest(config.Domain, config.PublisherID, messageSigner),
+		outputReplay: outputs.NewOutputReplay(config.Domain, config.PublisherID,
+			registeredOutputs, registeredOutputValues, messageSigner),
+		valueCodecs: outputs.NewValueCodecs(),
 	}
+	pub.outputReplay.SetBudget(config.ReplayBudget)
+	pub.consumerInterest.SetOnInterest(pub.handleInterest)
+	registeredNodes.OnUpdated(pub.handleNodeUpdated)
+	registeredNodes.OnUpdated(pub.handleNodeTwinUpdated)
 	receiveSetNodeID.SetNodeIDHandler(pub.HandleSetNodeIDCommand)
+	pub.receiveSetDesired.SetDesiredHandler(pub.handleSetDesired)
+	receiveNodeConfigure.SetConfigureNodeHandler(pub.handleNodeConfigure)
+	receiveControl.SetControlHandler(pub.HandleControlCommand)
+	registeredInputs.SetHandlerGuard(messageSigner.HandlerGuard())
+	messageSigner.SetDeadLetterHandler(pub.handleDeadLetter)
+	receiveDomainIdentities.SetIdentityConflictHandler(registeredIdentity, pub.handleIdentityConflict)
+	receiveMyIdentityUpdate.SetOnUpdate(pub.handleIdentityUpdate)
+	messageSigner.SetOnRejectedSignature(pub.handleRejectedSignature)
+	if trustStore != nil {
+		trustStore.SetOnKeyChange(pub.handlePublisherKeyChange)
+	}
+	if config.RepublishOnJoin > 0 {
+		receiveDomainIdentities.SetOnPublisherJoin(pub.handlePublisherJoin)
+	}
 
 	// Load configuration of previously registered nodes from config
+	pub.LoadNodeIDMappings()
 	pub.LoadRegisteredNodes()
+	if config.ProvisionFile != "" {
+		pub.LoadProvisioning(config.ProvisionFile)
+	}
+	if config.SelfConfigure {
+		pub.createPublisherNode()
+	}
+	pub.applyQuotas()
 
 	return pub
 }
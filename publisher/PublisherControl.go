@@ -0,0 +1,81 @@
+// Package publisher with handling of remote publisher control commands
+package publisher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// HandleControlCommand executes a control command received on the publisher's $control address.
+// The sender is already verified to be authorized to control this publisher.
+func (pub *Publisher) HandleControlCommand(command types.PublisherControlCommand, value string, sender string) error {
+	logrus.Warningf("Publisher.HandleControlCommand: command '%s' with value '%s' from %s", command, value, sender)
+
+	switch command {
+	case types.PublisherControlClearOverride:
+		return pub.ClearOverride(value)
+	case types.PublisherControlDiscover:
+		pub.TriggerDiscovery()
+	case types.PublisherControlFlushCache:
+		pub.flushCache()
+	case types.PublisherControlOverrideInput:
+		return pub.OverrideInput(value)
+	case types.PublisherControlOverrideOutput:
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return lib.MakeErrorf("HandleControlCommand: Invalid output override '%s'. Expected {outputID}={value}", value)
+		}
+		return pub.OverrideOutput(parts[0], parts[1])
+	case types.PublisherControlPause:
+		policy := PausePolicy(value)
+		if policy == "" {
+			policy = PausePolicyBuffer
+		}
+		return pub.Pause(policy)
+	case types.PublisherControlProfileCPU:
+		return pub.captureCPUProfile(value)
+	case types.PublisherControlProfileHeap:
+		_, err := pub.CaptureHeapProfile()
+		return err
+	case types.PublisherControlReloadIdentity:
+		return pub.ReloadIdentity()
+	case types.PublisherControlRepublish:
+		return pub.RepublishAll()
+	case types.PublisherControlRestart:
+		// restart in the background as stopping waits for the message handlers to complete
+		go func() {
+			pub.Stop()
+			pub.Start()
+		}()
+	case types.PublisherControlResume:
+		return pub.Resume()
+	case types.PublisherControlSetLogLevel:
+		level, err := logrus.ParseLevel(value)
+		if err != nil {
+			return lib.MakeErrorf("HandleControlCommand: Invalid log level '%s'", value)
+		}
+		logrus.SetLevel(level)
+	default:
+		return lib.MakeErrorf("HandleControlCommand: Unknown control command '%s' from %s", command, sender)
+	}
+	return nil
+}
+
+// flushCache removes the cached discovered publishers and input values of this publisher
+func (pub *Publisher) flushCache() {
+	cacheFiles := []string{
+		filepath.Join(pub.config.CacheFolder, pub.PublisherID()+DomainPublishersFileSuffix),
+		filepath.Join(pub.config.CacheFolder, pub.PublisherID()+InputValuesFileSuffix),
+	}
+	for _, filename := range cacheFiles {
+		err := os.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			lib.MakeErrorf("Publisher.flushCache: Unable to remove cache file %s: %s", filename, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Package publisher with hosting of multiple publishers in a single process
+package publisher
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/sirupsen/logrus"
+)
+
+// managedPublisher is a publisher hosted by the publisher manager
+type managedPublisher struct {
+	client *messaging.SharedMessengerClient // the publisher's client of the shared messenger
+	pub    *Publisher
+}
+
+// PublisherManager hosts multiple publishers in a single process that share a messenger.
+// Each publisher has its own identity and registered nodes, inputs and outputs.
+// Intended for hubs that represent each bridged ecosystem as its own publisher.
+type PublisherManager struct {
+	isRunning   bool                         // the manager is started
+	messenger   *messaging.SharedMessenger   // messenger shared by the publishers
+	publishers  map[string]*managedPublisher // hosted publishers by publisherID
+	updateMutex *sync.Mutex                  // mutex for adding and removing publishers at runtime
+}
+
+// AddPublisher creates a publisher that uses the shared messenger and adds it to the manager.
+// The publisher is started if the manager is running.
+//  config of the publisher. The publisherID must be unique within the manager.
+// This returns the new publisher or an error if a publisher with the ID already exists.
+func (manager *PublisherManager) AddPublisher(config *PublisherConfig) (*Publisher, error) {
+	if config == nil || config.PublisherID == "" {
+		return nil, lib.MakeErrorf("AddPublisher: Missing publisherID")
+	}
+	manager.updateMutex.Lock()
+	if _, exists := manager.publishers[config.PublisherID]; exists {
+		manager.updateMutex.Unlock()
+		return nil, lib.MakeErrorf("AddPublisher: Publisher %s already exists", config.PublisherID)
+	}
+	client := manager.messenger.NewClient()
+	pub := NewPublisher(config, client)
+	manager.publishers[config.PublisherID] = &managedPublisher{client: client, pub: pub}
+	isRunning := manager.isRunning
+	manager.updateMutex.Unlock()
+
+	logrus.Infof("PublisherManager.AddPublisher: Added publisher %s", config.PublisherID)
+	if isRunning {
+		pub.Start()
+	}
+	return pub, nil
+}
+
+// GetPublisher returns the hosted publisher with the given ID, or nil if it isn't hosted
+func (manager *PublisherManager) GetPublisher(publisherID string) *Publisher {
+	manager.updateMutex.Lock()
+	defer manager.updateMutex.Unlock()
+	managed := manager.publishers[publisherID]
+	if managed == nil {
+		return nil
+	}
+	return managed.pub
+}
+
+// GetPublisherIDs returns the sorted IDs of the hosted publishers
+func (manager *PublisherManager) GetPublisherIDs() []string {
+	manager.updateMutex.Lock()
+	defer manager.updateMutex.Unlock()
+	publisherIDs := make([]string, 0, len(manager.publishers))
+	for publisherID := range manager.publishers {
+		publisherIDs = append(publisherIDs, publisherID)
+	}
+	sort.Strings(publisherIDs)
+	return publisherIDs
+}
+
+// RemovePublisher stops the publisher and removes it with its subscriptions from the manager
+// This returns an error if the publisher isn't hosted by the manager.
+func (manager *PublisherManager) RemovePublisher(publisherID string) error {
+	manager.updateMutex.Lock()
+	managed := manager.publishers[publisherID]
+	delete(manager.publishers, publisherID)
+	manager.updateMutex.Unlock()
+
+	if managed == nil {
+		return lib.MakeErrorf("RemovePublisher: Publisher %s not found", publisherID)
+	}
+	logrus.Infof("PublisherManager.RemovePublisher: Removing publisher %s", publisherID)
+	managed.pub.Stop()
+	managed.client.Close()
+	return nil
+}
+
+// Start all hosted publishers. Publishers that are added later are started when added.
+func (manager *PublisherManager) Start() {
+	manager.updateMutex.Lock()
+	manager.isRunning = true
+	manager.updateMutex.Unlock()
+	for _, publisherID := range manager.GetPublisherIDs() {
+		pub := manager.GetPublisher(publisherID)
+		if pub != nil {
+			pub.Start()
+		}
+	}
+}
+
+// Stop all hosted publishers. The shared messenger disconnects when the last publisher is stopped.
+func (manager *PublisherManager) Stop() {
+	manager.updateMutex.Lock()
+	manager.isRunning = false
+	manager.updateMutex.Unlock()
+	for _, publisherID := range manager.GetPublisherIDs() {
+		pub := manager.GetPublisher(publisherID)
+		if pub != nil {
+			pub.Stop()
+		}
+	}
+}
+
+// NewPublisherManager creates a manager for hosting publishers that share the given messenger
+//  messenger to share between the publishers. The manager connects and disconnects it.
+func NewPublisherManager(messenger messaging.IMessenger) *PublisherManager {
+	manager := &PublisherManager{
+		messenger:   messaging.NewSharedMessenger(messenger),
+		publishers:  make(map[string]*managedPublisher),
+		updateMutex: &sync.Mutex{},
+	}
+	return manager
+}
@@ -1,14 +1,31 @@
 package publisher_test
 
 import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/iotdomain/iotdomain-go/identities"
 	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/outputs"
 	"github.com/iotdomain/iotdomain-go/publisher"
 	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/iotdomain/iotdomain-go/zeroconf"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -64,6 +81,81 @@ func TestNewAppPublisher(t *testing.T) {
 	assert.Error(t, err) // no messenger config
 }
 
+func TestInitAppConfig(t *testing.T) {
+	appID := "initapp"
+	configFolder, err := ioutil.TempDir("", "initapp")
+	require.NoError(t, err)
+	defer os.RemoveAll(configFolder)
+
+	written, err := publisher.InitAppConfig(appID, configFolder, "test")
+	require.NoError(t, err)
+	assert.Len(t, written, 3)
+	// existing files are kept
+	written, err = publisher.InitAppConfig(appID, configFolder, "test")
+	require.NoError(t, err)
+	assert.Empty(t, written)
+
+	// the generated configuration and identity are used by the app publisher
+	appPub, err := publisher.NewAppPublisher(appID, configFolder, nil, configFolder, false)
+	require.NoError(t, err)
+	require.NotNil(t, appPub)
+	assert.Equal(t, "test", appPub.Domain())
+	assert.Equal(t, appID, appPub.PublisherID())
+	regIdentity := identities.NewRegisteredIdentity("test", appID,
+		filepath.Join(configFolder, appID+publisher.RegisteredIdentityFileSuffix))
+	savedIdentity, _, err := regIdentity.LoadIdentity()
+	require.NoError(t, err)
+	assert.Equal(t, savedIdentity.PublicKey, appPub.GetIdentity().PublicKey)
+}
+
+func TestValidateConfig(t *testing.T) {
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder,
+		Domain: "test", PublisherID: "valid1", Loglevel: "warning"}
+	assert.NoError(t, config.Validate())
+
+	// all problems are reported at once
+	config = &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: filepath.Join(tempFolder, "missing"),
+		SaveDiscoveredNodes: true, PublisherID: "in/valid", Loglevel: "verbose", RepublishInterval: -1,
+		ControlRoles: map[string]types.Role{"test/admin": "root"}, UnsignedTypes: []string{string(types.MessageTypeSetInput)}}
+	err := config.Validate()
+	require.Error(t, err)
+	configErr, ok := err.(*lib.ConfigError)
+	require.True(t, ok)
+	assert.Len(t, configErr.Problems, 6)
+
+	// NewAppPublisher refuses an invalid configuration before connecting
+	appYaml := "publisherId: validapp\nloglevl: debug\nloglevel: verbose\nshutdownDeadline: -5\n"
+	ioutil.WriteFile(filepath.Join(tempFolder, "validapp"+lib.AppConfigSuffix), []byte(appYaml), 0600)
+	appPub, err := publisher.NewAppPublisher("validapp", tempFolder, nil, tempFolder, false)
+	assert.Nil(t, appPub)
+	require.Error(t, err)
+	assert.Len(t, err.(*lib.ConfigError).Problems, 2)
+}
+
+func TestLocateMessengerServer(t *testing.T) {
+	freeConn, _ := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	zeroconf.MDNSAddress = freeConn.LocalAddr().String()
+	freeConn.Close()
+	messengerConfig := messaging.MessengerConfig{}
+	err := publisher.LocateMessengerServer(&messengerConfig, 200*time.Millisecond)
+	assert.Error(t, err)
+
+	// the advertised DSS of an advertising publisher provides the server and domain
+	configFolder, _ := ioutil.TempDir("", "locatedss")
+	defer os.RemoveAll(configFolder)
+	dssConfig := &publisher.PublisherConfig{Advertise: true, ConfigFolder: configFolder, CacheFolder: configFolder,
+		Domain: "test", PublisherID: types.DSSPublisherID}
+	dss := publisher.NewPublisher(dssConfig, messaging.NewDummyMessenger(msgConfig))
+	dss.Start()
+	err = publisher.LocateMessengerServer(&messengerConfig, 200*time.Millisecond)
+	dss.Stop()
+	require.NoError(t, err)
+	assert.NotEmpty(t, messengerConfig.Server)
+	assert.Equal(t, "test", messengerConfig.Domain)
+}
+
 func TestStartStop(t *testing.T) {
 	var testMessenger = messaging.NewDummyMessenger(msgConfig)
 	var pollHandlerCalled = false
@@ -93,6 +185,971 @@ func TestStartStop(t *testing.T) {
 
 }
 
+func TestShutdown(t *testing.T) {
+	const nodeHWID = "node7"
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	shutdownConfig := *test1Config
+	shutdownConfig.ConfigFolder = tempFolder
+	shutdownConfig.CacheFolder = tempFolder
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(&shutdownConfig, testMessenger)
+	pub1.Start()
+	pub1.CreateNode(nodeHWID, types.NodeTypeUnknown)
+
+	// pending updates are published on shutdown
+	err := pub1.Shutdown(2 * time.Second)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, testMessenger.FindLastPublication("test/publisher1/node7/$node"))
+	status, _ := messaging.JWSPayload(testMessenger.FindLastPublication("test/publisher1/$status"))
+	assert.Contains(t, status, types.PublisherRunStateDisconnected)
+
+	// should be no problem to shutdown again
+	err = pub1.Shutdown(0)
+	assert.NoError(t, err)
+
+	// a deadline that is used up by the drain doesn't hang the shutdown
+	pub2 := publisher.NewPublisher(&shutdownConfig, testMessenger)
+	pub2.Start()
+	start := time.Now()
+	pub2.Shutdown(time.Nanosecond)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestPublishBudget(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	var rxCount = 0
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	budgetConfig := *test1Config
+	budgetConfig.ConfigFolder = tempFolder
+	budgetConfig.CacheFolder = tempFolder
+	budgetConfig.PublishBudget = 1
+	pub1 := publisher.NewPublisher(&budgetConfig, testMessenger)
+	testMessenger.Subscribe("test/publisher1/+/$node", func(address string, message string) error {
+		rxCount++
+		return nil
+	})
+	pub1.CreateNode("node8", types.NodeTypeUnknown)
+	pub1.CreateNode("node9", types.NodeTypeUnknown)
+
+	// a heartbeat publishes one update
+	pub1.Start()
+	time.Sleep(1500 * time.Millisecond)
+	assert.Equal(t, 1, rxCount)
+
+	// the remainder is published on request
+	pub1.PublishUpdates()
+	assert.NotEmpty(t, testMessenger.FindLastPublication("test/publisher1/node8/$node"))
+	assert.NotEmpty(t, testMessenger.FindLastPublication("test/publisher1/node9/$node"))
+	pub1.Stop()
+}
+
+func TestPublisherManager(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	manager := publisher.NewPublisherManager(testMessenger)
+	// new publishers create their identity in the config folder
+	tempDir, err := ioutil.TempDir("", "publishermanager")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	config1 := *test1Config
+	config1.ConfigFolder = tempDir
+	config2 := config1
+	config2.PublisherID = "publisher2"
+
+	pub1, err := manager.AddPublisher(&config1)
+	require.NoError(t, err)
+	manager.Start()
+	// publishers added later are started when added
+	pub2, err := manager.AddPublisher(&config2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"publisher1", "publisher2"}, manager.GetPublisherIDs())
+	assert.Equal(t, pub2, manager.GetPublisher("publisher2"))
+
+	// each publisher has its own registry
+	pub1.CreateNode("node11", types.NodeTypeUnknown)
+	pub2.CreateNode("node11", types.NodeTypeUnknown)
+	pub1.PublishUpdates()
+	pub2.PublishUpdates()
+	assert.NotEmpty(t, testMessenger.FindLastPublication("test/publisher1/node11/$node"))
+	assert.NotEmpty(t, testMessenger.FindLastPublication("test/publisher2/node11/$node"))
+	assert.NotEqual(t, pub1.GetIdentity().PublicKey, pub2.GetIdentity().PublicKey)
+
+	err = manager.RemovePublisher("publisher2")
+	assert.NoError(t, err)
+	assert.Nil(t, manager.GetPublisher("publisher2"))
+	manager.Stop()
+
+	// error cases
+	_, err = manager.AddPublisher(&config1)
+	assert.Error(t, err, "duplicate publisher")
+	_, err = manager.AddPublisher(nil)
+	assert.Error(t, err)
+	err = manager.RemovePublisher("publisher2")
+	assert.Error(t, err)
+}
+
+func TestTriggerDiscoveryAndRepublish(t *testing.T) {
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	var pollCount = 0
+
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.SetPollInterval(0, func(pub *publisher.Publisher) {
+		pollCount++
+	})
+	pub1.TriggerDiscovery()
+	assert.Equal(t, 1, pollCount)
+
+	// discovery is republished even without updates
+	var rxCount = 0
+	testMessenger.Subscribe("test/publisher1/+/$node", func(address string, message string) error {
+		rxCount++
+		return nil
+	})
+	pub1.PublishUpdates()
+	rxCount = 0
+	pub1.PublishUpdates()
+	assert.Equal(t, 0, rxCount)
+	pub1.RepublishAll()
+	assert.Equal(t, len(pub1.GetNodes()), rxCount)
+	assert.NotZero(t, rxCount)
+	assert.NotEmpty(t, testMessenger.FindLastPublication(pub1.Address()))
+}
+
+func TestMigrateIdentity(t *testing.T) {
+	const nodeHWID = "node5"
+	const oldNodeAddr = "test/oldpub/node5/$node"
+	const oldOutputAddr = "test/oldpub/node5/switch/0/$latest"
+	const oldIdentityAddr = "test/oldpub/$identity"
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.CreateNode(nodeHWID, types.NodeTypeUnknown)
+	pub1.CreateOutput(nodeHWID, types.OutputTypeSwitch, types.DefaultOutputInstance)
+
+	// retained publications of the old identity
+	testMessenger.Publish(oldIdentityAddr, true, "old identity")
+	testMessenger.Publish(oldNodeAddr, true, "old node")
+	testMessenger.Publish(oldOutputAddr, true, "old value")
+
+	err := pub1.MigrateIdentity(&types.PublisherIdentityMessage{Domain: "test", PublisherID: "oldpub"})
+	require.NoError(t, err)
+	assert.Empty(t, testMessenger.FindLastPublication(oldIdentityAddr))
+	assert.Empty(t, testMessenger.FindLastPublication(oldNodeAddr))
+	assert.Empty(t, testMessenger.FindLastPublication(oldOutputAddr))
+	assert.NotEmpty(t, testMessenger.FindLastPublication(pub1.Address()))
+	assert.NotEmpty(t, testMessenger.FindLastPublication("test/publisher1/node5/$node"))
+	assert.NotEmpty(t, testMessenger.FindLastPublication("test/publisher1/node5/switch/0/$output"))
+
+	// the current identity can't be migrated to itself
+	err = pub1.MigrateIdentity(&types.PublisherIdentityMessage{Domain: "test", PublisherID: "publisher1"})
+	assert.Error(t, err)
+	err = pub1.MigrateIdentity(nil)
+	assert.Error(t, err)
+}
+
+func TestCleanupRetained(t *testing.T) {
+	const nodeHWID = "node6"
+	const staleNodeAddr = "test/publisher1/removed/$node"
+	const staleOutputAddr = "test/publisher1/node6/switch/1/$latest"
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.CreateNode(nodeHWID, types.NodeTypeUnknown)
+	pub1.CreateOutput(nodeHWID, types.OutputTypeSwitch, types.DefaultOutputInstance)
+	pub1.RepublishAll()
+
+	// only stale retained publications of this publisher are cleared
+	cleared, err := pub1.ClearStaleRetained([]string{
+		"test/publisher1/node6/$node",
+		"test/publisher1/node6/switch/0/$output",
+		"test/publisher1/node6/switch/0/$set",
+		"test/publisher2/removed/$node",
+		staleNodeAddr,
+		staleOutputAddr,
+		staleOutputAddr,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{staleNodeAddr, staleOutputAddr}, cleared)
+	assert.NotEmpty(t, testMessenger.FindLastPublication("test/publisher1/node6/$node"))
+
+	// retained publications received during the snapshot are cleared
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		testMessenger.Publish(staleNodeAddr, true, "stale node")
+	}()
+	cleared, err = pub1.CleanupRetained(100 * time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, []string{staleNodeAddr}, cleared)
+	assert.Empty(t, testMessenger.FindLastPublication(staleNodeAddr))
+}
+
+func TestOutputCalibration(t *testing.T) {
+	const node1HWID = "node1"
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.CreateNode(node1HWID, types.NodeTypeUnknown)
+	pub1.CreateOutput(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+
+	// without calibration the value is used as is
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	val := pub1.GetOutputValueByNodeHWID(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	require.NotNil(t, val)
+	assert.Equal(t, "20", val.Value)
+	assert.Empty(t, val.RawValue)
+
+	// configure the calibration
+	pub1.EnableOutputCalibration(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	gainAttr := outputs.MakeCalibrationAttr(types.OutputTypeTemperature, types.DefaultOutputInstance, types.NodeAttrGain)
+	offsetAttr := outputs.MakeCalibrationAttr(types.OutputTypeTemperature, types.DefaultOutputInstance, types.NodeAttrOffset)
+	pub1.UpdateNodeConfigValues(node1HWID, types.NodeAttrMap{gainAttr: "2", offsetAttr: "0.5"})
+
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	val = pub1.GetOutputValueByNodeHWID(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	assert.Equal(t, "40.5", val.Value)
+	assert.Equal(t, "20", val.RawValue)
+}
+
+func TestOutputValueValidation(t *testing.T) {
+	const node1HWID = "node1"
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	config := *test1Config
+	pub1 := publisher.NewPublisher(&config, testMessenger)
+	pub1.CreateNode(node1HWID, types.NodeTypeUnknown)
+	output := pub1.CreateOutput(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	output.DataType = types.DataTypeNumber
+	pub1.UpdateOutput(output)
+
+	// violations are counted but the value is used
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, " 20 ")
+	val := pub1.GetOutputValueByNodeHWID(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	require.NotNil(t, val)
+	assert.Equal(t, " 20 ", val.Value)
+	assert.Equal(t, 1, pub1.GetOutputValueViolations()[output.OutputID])
+
+	// with coercion the value is converted or dropped
+	config.CoerceOutputValues = true
+	pub1 = publisher.NewPublisher(&config, testMessenger)
+	pub1.CreateNode(node1HWID, types.NodeTypeUnknown)
+	output = pub1.CreateOutput(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	output.DataType = types.DataTypeNumber
+	pub1.UpdateOutput(output)
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, " 20 ")
+	val = pub1.GetOutputValueByNodeHWID(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	require.NotNil(t, val)
+	assert.Equal(t, "20", val.Value)
+	updated := pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "hot")
+	assert.False(t, updated)
+	val = pub1.GetOutputValueByNodeHWID(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	assert.Equal(t, "20", val.Value)
+	assert.Equal(t, 2, pub1.GetOutputValueViolations()[output.OutputID])
+}
+
+func TestOutputStatistics(t *testing.T) {
+	const node1HWID = "node1"
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.CreateNode(node1HWID, types.NodeTypeUnknown)
+	pub1.CreateOutput(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.EnableOutputStatistics(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance,
+		outputs.StatisticsPeriodDaily)
+	minOutput := pub1.GetOutputByNodeHWID(node1HWID, types.OutputTypeTemperature,
+		types.DefaultOutputInstance+outputs.StatisticsMinSuffix)
+	require.NotNil(t, minOutput)
+
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "10")
+	minVal := pub1.GetOutputValueByNodeHWID(node1HWID, types.OutputTypeTemperature,
+		types.DefaultOutputInstance+outputs.StatisticsMinSuffix)
+	maxVal := pub1.GetOutputValueByNodeHWID(node1HWID, types.OutputTypeTemperature,
+		types.DefaultOutputInstance+outputs.StatisticsMaxSuffix)
+	avgVal := pub1.GetOutputValueByNodeHWID(node1HWID, types.OutputTypeTemperature,
+		types.DefaultOutputInstance+outputs.StatisticsAvgSuffix)
+	require.NotNil(t, minVal)
+	require.NotNil(t, maxVal)
+	require.NotNil(t, avgVal)
+	assert.Equal(t, "10", minVal.Value)
+	assert.Equal(t, "20", maxVal.Value)
+	assert.Equal(t, "15", avgVal.Value)
+
+	// repeated readings that don't update the history are included
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "10")
+	avgVal = pub1.GetOutputValueByNodeHWID(node1HWID, types.OutputTypeTemperature,
+		types.DefaultOutputInstance+outputs.StatisticsAvgSuffix)
+	assert.Equal(t, "13.333333333333334", avgVal.Value)
+}
+
+func TestAddressTemplate(t *testing.T) {
+	const node1HWID = "node1"
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	config := *test1Config
+	config.AddressTemplate = "legacy/{nodeId}/{type}/{instance}"
+	pub1 := publisher.NewPublisher(&config, testMessenger)
+	pub1.CreateNode(node1HWID, types.NodeTypeUnknown)
+	pub1.CreateOutput(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	pub1.PublishUpdates()
+	assert.Equal(t, "20", testMessenger.FindLastPublication("legacy/node1/temperature/0"))
+	// the canonical publication is unchanged
+	assert.NotEmpty(t, testMessenger.FindLastPublication("test/publisher1/node1/temperature/0/$raw"))
+
+	// compat publications are paused with the other updates
+	pub1.Pause(publisher.PausePolicyBuffer)
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "21")
+	pub1.PublishUpdates()
+	assert.Equal(t, "20", testMessenger.FindLastPublication("legacy/node1/temperature/0"))
+	pub1.Resume()
+	assert.Equal(t, "21", testMessenger.FindLastPublication("legacy/node1/temperature/0"))
+
+	// invalid templates are ignored
+	config.AddressTemplate = "legacy/{unknown}"
+	pub1 = publisher.NewPublisher(&config, testMessenger)
+	require.NotNil(t, pub1)
+}
+
+func TestGeofences(t *testing.T) {
+	const node1HWID = "node1"
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.CreateNode(node1HWID, types.NodeTypeUnknown)
+	output := pub1.CreateLocationOutput(node1HWID, types.DefaultOutputInstance)
+	assert.Equal(t, types.DataTypeLocation, output.DataType)
+	pub1.SetGeofences(node1HWID, types.DefaultOutputInstance, []outputs.Geofence{
+		{Name: "home", Latitude: 49.2827, Longitude: -123.1207, Radius: 500},
+	})
+	pub1.Start()
+	defer pub1.Stop()
+
+	pub1.UpdateLocation(node1HWID, types.DefaultOutputInstance, 49.2830, -123.1210, 0)
+	alarm := pub1.GetOutputValueByNodeHWID(node1HWID, types.OutputTypeAlarm, publisher.GeofenceAlarmInstance)
+	assert.Nil(t, alarm)
+	val := pub1.GetOutputValueByNodeHWID(node1HWID, types.OutputTypeLocation, types.DefaultOutputInstance)
+	require.NotNil(t, val)
+	assert.Equal(t, `{"lat":49.283,"lon":-123.121}`, val.Value)
+	assert.Empty(t, pub1.GetOutputValueViolations())
+
+	pub1.UpdateLocation(node1HWID, types.DefaultOutputInstance, 49.3000, -123.1207, 0)
+	alarm = pub1.GetOutputValueByNodeHWID(node1HWID, types.OutputTypeAlarm, publisher.GeofenceAlarmInstance)
+	require.NotNil(t, alarm)
+	assert.Equal(t, "leave:home", alarm.Value)
+}
+
+func TestCounterOutput(t *testing.T) {
+	const node1HWID = "node1"
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.CreateNode(node1HWID, types.NodeTypeUnknown)
+	output := pub1.CreateCounterOutput(node1HWID, types.OutputTypeElectricEnergy, types.DefaultOutputInstance, 100, time.Hour)
+	require.NotNil(t, output)
+	assert.NotNil(t, pub1.GetOutputByNodeHWID(node1HWID, types.OutputTypeElectricEnergy,
+		types.DefaultOutputInstance+publisher.CounterRateSuffix))
+
+	assert.True(t, pub1.UpdateCounterValue(node1HWID, types.OutputTypeElectricEnergy, types.DefaultOutputInstance, 95))
+	assert.True(t, pub1.UpdateCounterValue(node1HWID, types.OutputTypeElectricEnergy, types.DefaultOutputInstance, 2))
+	total := pub1.GetOutputValueByNodeHWID(node1HWID, types.OutputTypeElectricEnergy, types.DefaultOutputInstance)
+	require.NotNil(t, total)
+	assert.Equal(t, "7", total.Value)
+	delta := pub1.GetOutputValueByNodeHWID(node1HWID, types.OutputTypeElectricEnergy,
+		types.DefaultOutputInstance+publisher.CounterDeltaSuffix)
+	require.NotNil(t, delta)
+	assert.Equal(t, "7", delta.Value)
+
+	assert.False(t, pub1.UpdateCounterValue(node1HWID, types.OutputTypeElectricEnergy, "notacounter", 1))
+}
+
+func TestExecInputOutput(t *testing.T) {
+	const node10HWID = "node10"
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.CreateNode(node10HWID, types.NodeTypeUnknown)
+
+	// the output value is the output of the command
+	pub1.CreateExecOutput(node10HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "echo", "21.5")
+	pub1.TriggerDiscovery()
+	val := pub1.GetOutputValueByNodeHWID(node10HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	require.NotNil(t, val)
+	assert.Equal(t, "21.5", val.Value)
+
+	// a failing command doesn't update the value
+	pub1.CreateExecOutput(node10HWID, types.OutputTypeHumidity, types.DefaultOutputInstance, "false")
+	pub1.PollExecOutputs()
+	val = pub1.GetOutputValueByNodeHWID(node10HWID, types.OutputTypeHumidity, types.DefaultOutputInstance)
+	assert.Nil(t, val)
+
+	// the set input value is passed to the command
+	tempDir, err := ioutil.TempDir("", "execinput")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	outFile := filepath.Join(tempDir, "value")
+	pub1.Start()
+	execInput := pub1.CreateExecInput(node10HWID, types.InputTypeSwitch, types.DefaultInputInstance,
+		"sh", "-c", "echo \"$IOTDOMAIN_VALUE\" > "+outFile)
+	setAddr := outputs.ReplaceMessageType(execInput.Input().Address, types.MessageTypeSetInput)
+	err = pub1.PublishSetInput(setAddr, "on")
+	assert.NoError(t, err)
+	contents, err := ioutil.ReadFile(outFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "on\n", string(contents))
+	pub1.Stop()
+}
+
+func TestIdentifyNode(t *testing.T) {
+	const node15HWID = "node15"
+	var identifyDuration int
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	identifyConfig := *test1Config
+	identifyConfig.ConfigFolder = tempFolder
+	identifyConfig.CacheFolder = tempFolder
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(&identifyConfig, testMessenger)
+	_, err := pub1.EnableIdentify(node15HWID, nil)
+	assert.Error(t, err, "Unknown node")
+
+	pub1.CreateNode(node15HWID, types.NodeTypeUnknown)
+	input, err := pub1.EnableIdentify(node15HWID, func(nodeHWID string, sender string, duration int) {
+		identifyDuration = duration
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "true", pub1.GetNodeAttr(node15HWID, types.NodeAttrIdentify))
+
+	pub1.Start()
+	setAddr := outputs.ReplaceMessageType(input.Address, types.MessageTypeSetInput)
+	err = pub1.PublishSetInput(setAddr, "")
+	assert.NoError(t, err)
+	assert.Equal(t, publisher.DefaultIdentifyDuration, identifyDuration)
+	err = pub1.PublishSetInput(setAddr, "3")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, identifyDuration)
+	// invalid durations are ignored
+	pub1.PublishSetInput(setAddr, "soon")
+	assert.Equal(t, 3, identifyDuration)
+	pub1.Stop()
+}
+
+func TestFirmwareUpdate(t *testing.T) {
+	const node16HWID = "node16"
+	image := []byte("firmware image v2")
+	hash := sha256.Sum256(image)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(image)
+	}))
+	defer server.Close()
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	firmwareConfig := *test1Config
+	firmwareConfig.ConfigFolder = tempFolder
+	firmwareConfig.ControlSenders = []string{
+		identities.MakePublisherIdentityAddress(firmwareConfig.Domain, firmwareConfig.PublisherID)}
+	pub1 := publisher.NewPublisher(&firmwareConfig, testMessenger)
+	pub1.CreateNode(node16HWID, types.NodeTypeUnknown)
+	pub1.UpdateNodeAttr(node16HWID, map[types.NodeAttr]string{
+		types.NodeAttrGroup:           "building1/floor1",
+		types.NodeAttrSoftwareVersion: "1",
+	})
+	installed := make(chan []byte, 1)
+	input, err := pub1.EnableFirmwareUpdates(node16HWID,
+		func(nodeHWID string, descriptor *types.FirmwareDescriptor, image []byte, progress func(percent int)) error {
+			progress(50)
+			installed <- image
+			return nil
+		})
+	require.NoError(t, err)
+	pub1.Start()
+	defer pub1.Stop()
+	setAddr := outputs.ReplaceMessageType(input.Address, types.MessageTypeSetInput)
+
+	// nodes outside the rollout group ignore the update
+	descriptor, _ := json.Marshal(&types.FirmwareDescriptor{
+		Group: "building2", SHA256: hex.EncodeToString(hash[:]), URL: server.URL, Version: "2"})
+	pub1.PublishSetInput(setAddr, string(descriptor))
+	// an image that doesn't match the hash fails
+	descriptor, _ = json.Marshal(&types.FirmwareDescriptor{
+		Group: "building1", SHA256: "0123", URL: server.URL, Version: "2"})
+	pub1.PublishSetInput(setAddr, string(descriptor))
+	assert.Eventually(t, func() bool {
+		node := pub1.GetNodeByHWID(node16HWID)
+		return node.Status[types.NodeStatusFirmware] == types.FirmwareStateFailed
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, installed)
+
+	descriptor, _ = json.Marshal(&types.FirmwareDescriptor{
+		Group: "building1", SHA256: hex.EncodeToString(hash[:]), URL: server.URL, Version: "2"})
+	pub1.PublishSetInput(setAddr, string(descriptor))
+	select {
+	case received := <-installed:
+		assert.Equal(t, image, received)
+	case <-time.After(time.Second):
+		assert.Fail(t, "firmware was not installed")
+	}
+	assert.Eventually(t, func() bool {
+		return pub1.GetNodeAttr(node16HWID, types.NodeAttrSoftwareVersion) == "2"
+	}, time.Second, 10*time.Millisecond)
+	node := pub1.GetNodeByHWID(node16HWID)
+	assert.Equal(t, types.FirmwareStateDone, node.Status[types.NodeStatusFirmware])
+	assert.Equal(t, "100", node.Status[types.NodeStatusFirmwarePct])
+}
+
+func TestFirmwareUpdateUnauthorized(t *testing.T) {
+	const node16HWID = "node16"
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	firmwareConfig := *test1Config
+	firmwareConfig.ConfigFolder = tempFolder
+	pub1 := publisher.NewPublisher(&firmwareConfig, testMessenger)
+	pub1.CreateNode(node16HWID, types.NodeTypeUnknown)
+	pub1.UpdateNodeAttr(node16HWID, map[types.NodeAttr]string{types.NodeAttrGroup: "building1"})
+	input, err := pub1.EnableFirmwareUpdates(node16HWID,
+		func(nodeHWID string, descriptor *types.FirmwareDescriptor, image []byte, progress func(percent int)) error {
+			assert.Fail(t, "firmware from an unauthorized sender was installed")
+			return nil
+		})
+	require.NoError(t, err)
+	pub1.Start()
+	defer pub1.Stop()
+
+	// without controlSenders the publisher itself isn't authorized
+	setAddr := outputs.ReplaceMessageType(input.Address, types.MessageTypeSetInput)
+	descriptor, _ := json.Marshal(&types.FirmwareDescriptor{
+		Group: "building1", SHA256: "0123", URL: "http://localhost/firmware", Version: "2"})
+	pub1.PublishSetInput(setAddr, string(descriptor))
+	node := pub1.GetNodeByHWID(node16HWID)
+	assert.Empty(t, node.Status[types.NodeStatusFirmware])
+}
+
+func TestPausePublications(t *testing.T) {
+	const node17HWID = "node17"
+	const node18HWID = "node18"
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	pauseConfig := *test1Config
+	pauseConfig.ConfigFolder = tempFolder
+	pauseConfig.CacheFolder = tempFolder
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(&pauseConfig, testMessenger)
+	assert.Error(t, pub1.Pause("later"))
+	assert.False(t, pub1.IsPaused())
+
+	// buffered updates are published on resume
+	err := pub1.HandleControlCommand(types.PublisherControlPause, "", "")
+	require.NoError(t, err)
+	assert.True(t, pub1.IsPaused())
+	pub1.CreateNode(node17HWID, types.NodeTypeUnknown)
+	pub1.PublishUpdates()
+	assert.Empty(t, testMessenger.FindLastPublication("test/publisher1/node17/$node"))
+	err = pub1.HandleControlCommand(types.PublisherControlResume, "", "")
+	require.NoError(t, err)
+	assert.False(t, pub1.IsPaused())
+	assert.NotEmpty(t, testMessenger.FindLastPublication("test/publisher1/node17/$node"))
+
+	// dropped updates are not published
+	pub1.Pause(publisher.PausePolicyDrop)
+	pub1.CreateNode(node18HWID, types.NodeTypeUnknown)
+	pub1.PublishUpdates()
+	pub1.Resume()
+	pub1.PublishUpdates()
+	assert.Empty(t, testMessenger.FindLastPublication("test/publisher1/node18/$node"))
+
+	// the status, republication and geofence events are paused as well
+	statusAddr := identities.MakePublisherStatusAddress(pauseConfig.Domain, pauseConfig.PublisherID)
+	eventAddr := "test/publisher1/node17/$event"
+	pub1.CreateLocationOutput(node17HWID, types.DefaultOutputInstance)
+	pub1.SetGeofences(node17HWID, types.DefaultOutputInstance, []outputs.Geofence{
+		{Name: "home", Latitude: 49.2827, Longitude: -123.1207, Radius: 500},
+	})
+	pub1.UpdateLocation(node17HWID, types.DefaultOutputInstance, 49.2830, -123.1210, 0)
+	pub1.PublishUpdates()
+	pub1.Pause(publisher.PausePolicyBuffer)
+	pub1.SetPublisherStatus(types.PublisherRunStateConnected)
+	assert.Empty(t, testMessenger.FindLastPublication(statusAddr))
+	assert.Error(t, pub1.RepublishAll())
+	pub1.UpdateLocation(node17HWID, types.DefaultOutputInstance, 49.3000, -123.1207, 0)
+	assert.Empty(t, testMessenger.FindLastPublication(eventAddr))
+	pub1.Resume()
+	assert.NotEmpty(t, testMessenger.FindLastPublication(statusAddr))
+	assert.NotEmpty(t, testMessenger.FindLastPublication(eventAddr))
+	assert.NoError(t, pub1.RepublishAll())
+}
+
+func TestOverrides(t *testing.T) {
+	const node19HWID = "node19"
+	var receivedValue string
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(test1Config, testMessenger)
+	pub1.CreateNode(node19HWID, types.NodeTypeUnknown)
+	output := pub1.CreateOutput(node19HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	input := pub1.CreateInput(node19HWID, types.InputTypeSwitch, types.DefaultInputInstance,
+		func(input *types.InputDiscoveryMessage, sender string, value string) {
+			receivedValue = value
+		})
+	assert.Error(t, pub1.OverrideOutput("fakeoutput", "1"))
+	assert.Error(t, pub1.HandleControlCommand(types.PublisherControlOverrideOutput, "novalue", ""))
+
+	// overridden output values are forced
+	err := pub1.HandleControlCommand(types.PublisherControlOverrideOutput, output.OutputID+"=18", "")
+	require.NoError(t, err)
+	pub1.UpdateOutputValue(node19HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "25")
+	val := pub1.GetOutputValueByNodeHWID(node19HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	require.NotNil(t, val)
+	assert.Equal(t, "18", val.Value)
+	assert.Equal(t, types.ValueQualityOverridden, val.Quality)
+	assert.True(t, pub1.IsOverridden(output.OutputID))
+
+	// overridden inputs ignore set commands
+	err = pub1.HandleControlCommand(types.PublisherControlOverrideInput, input.InputID, "")
+	require.NoError(t, err)
+	node := pub1.GetNodeByHWID(node19HWID)
+	assert.Equal(t, input.InputID+","+output.OutputID, node.Status[types.NodeStatusOverridden])
+	pub1.Start()
+	setAddr := outputs.ReplaceMessageType(input.Address, types.MessageTypeSetInput)
+	pub1.PublishSetInput(setAddr, "on")
+	assert.Empty(t, receivedValue)
+
+	// cleared overrides restore normal operation
+	err = pub1.HandleControlCommand(types.PublisherControlClearOverride, input.InputID, "")
+	require.NoError(t, err)
+	pub1.PublishSetInput(setAddr, "on")
+	assert.Equal(t, "on", receivedValue)
+	pub1.ClearOverride(output.OutputID)
+	pub1.UpdateOutputValue(node19HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "25")
+	val = pub1.GetOutputValueByNodeHWID(node19HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	assert.Equal(t, "25", val.Value)
+	node = pub1.GetNodeByHWID(node19HWID)
+	assert.Empty(t, node.Status[types.NodeStatusOverridden])
+	pub1.Stop()
+}
+
+func TestIdentityConflict(t *testing.T) {
+	var conflictCount int
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	config := *test1Config
+	config.StopOnIdentityConflict = true
+	pub1 := publisher.NewPublisher(&config, testMessenger)
+	pub1.SetOnIdentityConflict(func(identity *types.PublisherIdentityMessage) {
+		conflictCount++
+	})
+	pub1.Start()
+	assert.False(t, pub1.HasIdentityConflict())
+
+	// republishing our own identity is not a conflict
+	myIdent := pub1.GetIdentity()
+	mySigner := messaging.NewMessageSigner(testMessenger, pub1.GetIdentityKeys(), nil)
+	identities.PublishIdentity(myIdent, mySigner)
+	assert.False(t, pub1.HasIdentityConflict())
+
+	// another publisher with the same ID raises the alert and stops publishing
+	otherIdent, otherKeys := identities.CreateIdentity(config.Domain, config.PublisherID)
+	otherSigner := messaging.NewMessageSigner(testMessenger, otherKeys, nil)
+	identities.PublishIdentity(&otherIdent.PublisherIdentityMessage, otherSigner)
+	assert.True(t, pub1.HasIdentityConflict())
+	assert.True(t, pub1.IsPaused())
+	assert.Equal(t, 1, conflictCount)
+	statusAddr := identities.MakePublisherStatusAddress(config.Domain, config.PublisherID)
+	var status types.PublisherStatusMessage
+	_, err := messaging.VerifySenderJWSSignature(testMessenger.FindLastPublication(statusAddr), &status, nil)
+	require.NoError(t, err)
+	assert.True(t, status.IdentityConflict)
+
+	// our own key remains in use and the identity is reclaimed
+	for _, ident := range pub1.GetDomainPublishers() {
+		if ident.Address == myIdent.Address {
+			assert.Equal(t, myIdent.PublicKey, ident.PublicKey)
+		}
+	}
+	assert.NoError(t, pub1.IdentityClaimError())
+	var published types.PublisherIdentityMessage
+	_, err = messaging.VerifySenderJWSSignature(testMessenger.FindLastPublication(myIdent.Address), &published, nil)
+	require.NoError(t, err)
+	assert.Equal(t, myIdent.PublicKey, published.PublicKey)
+	pub1.Stop()
+
+	// a publisher with a higher priority holds the claim
+	var testMessenger2 = messaging.NewDummyMessenger(msgConfig)
+	pub2 := publisher.NewPublisher(test1Config, testMessenger2)
+	pub2.Start()
+	otherIdent.Priority = 1
+	messaging.SignIdentity(&otherIdent.PublisherIdentityMessage, otherKeys)
+	otherSigner = messaging.NewMessageSigner(testMessenger2, otherKeys, nil)
+	identities.PublishIdentity(&otherIdent.PublisherIdentityMessage, otherSigner)
+	assert.Error(t, pub2.IdentityClaimError())
+	assert.True(t, pub2.IsPaused())
+	_, err = messaging.VerifySenderJWSSignature(testMessenger2.FindLastPublication(myIdent.Address), &published, nil)
+	require.NoError(t, err)
+	assert.Equal(t, otherIdent.PublicKey, published.PublicKey)
+
+	// the losing publisher can't resume or republish its identity
+	assert.Error(t, pub2.Resume())
+	assert.True(t, pub2.IsPaused())
+	assert.Error(t, pub2.RepublishAll())
+	_, err = messaging.VerifySenderJWSSignature(testMessenger2.FindLastPublication(myIdent.Address), &published, nil)
+	require.NoError(t, err)
+	assert.Equal(t, otherIdent.PublicKey, published.PublicKey)
+	pub2.Stop()
+	_, err = messaging.VerifySenderJWSSignature(testMessenger2.FindLastPublication(statusAddr), &status, nil)
+	require.NoError(t, err)
+	assert.Equal(t, types.PublisherRunStateConnected, status.Status, "Status should not be published after losing the claim")
+}
+
+func TestRepublishOnJoin(t *testing.T) {
+	const node20HWID = "node20"
+	var nodePublishCount int32
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	config := *test1Config
+	config.RepublishOnJoin = 2
+	pub1 := publisher.NewPublisher(&config, testMessenger)
+	node20Addr := nodes.MakeNodeDiscoveryAddress(config.Domain, config.PublisherID, node20HWID)
+	pub1.SetPrePublishHook(func(address string, payload string) (bool, string) {
+		if address == node20Addr {
+			atomic.AddInt32(&nodePublishCount, 1)
+		}
+		return true, payload
+	})
+	pub1.CreateNode(node20HWID, types.NodeTypeUnknown)
+	pub1.PublishUpdates()
+	pub1.Start()
+	atomic.StoreInt32(&nodePublishCount, 0)
+
+	// a joining publisher receives the discovery immediately
+	pub2Ident, pub2Keys := identities.CreateIdentity(config.Domain, "publisher2")
+	pub2Signer := messaging.NewMessageSigner(testMessenger, pub2Keys, nil)
+	identities.PublishIdentity(&pub2Ident.PublisherIdentityMessage, pub2Signer)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&nodePublishCount))
+
+	// publishers that join within the interval are handled after the interval
+	pub3Ident, pub3Keys := identities.CreateIdentity(config.Domain, "publisher3")
+	pub3Signer := messaging.NewMessageSigner(testMessenger, pub3Keys, nil)
+	identities.PublishIdentity(&pub3Ident.PublisherIdentityMessage, pub3Signer)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&nodePublishCount))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&nodePublishCount) == 2
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// republished identities of known publishers are not a join
+	time.Sleep(2100 * time.Millisecond)
+	identities.PublishIdentity(&pub2Ident.PublisherIdentityMessage, pub2Signer)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&nodePublishCount))
+	pub1.Stop()
+}
+
+func TestRepublishWithoutRetained(t *testing.T) {
+	const node21HWID = "node21"
+	var nodePublishCount int32
+	config := *test1Config
+	config.RepublishInterval = 1
+	pub1 := publisher.NewPublisher(&config, messaging.NewDummyMessenger(msgConfig))
+	assert.True(t, pub1.IsRetainedSupported())
+
+	// discovery is republished periodically
+	var testMessenger = messaging.NewDummyMessenger(&messaging.MessengerConfig{NoRetain: true})
+	pub2 := publisher.NewPublisher(&config, testMessenger)
+	assert.False(t, pub2.IsRetainedSupported())
+	node21Addr := nodes.MakeNodeDiscoveryAddress(config.Domain, config.PublisherID, node21HWID)
+	pub2.SetPrePublishHook(func(address string, payload string) (bool, string) {
+		if address == node21Addr {
+			atomic.AddInt32(&nodePublishCount, 1)
+		}
+		return true, payload
+	})
+	pub2.CreateNode(node21HWID, types.NodeTypeUnknown)
+	pub2.PublishUpdates()
+	pub2.Start()
+	atomic.StoreInt32(&nodePublishCount, 0)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&nodePublishCount) >= 2
+	}, 5*time.Second, 100*time.Millisecond)
+	pub2.Stop()
+}
+
+func TestStatusClockSkew(t *testing.T) {
+	config1 := publisher.PublisherConfig{Domain: "test", PublisherID: "publisher1", StatusInterval: 1}
+	config2 := publisher.PublisherConfig{Domain: "test", PublisherID: "publisher2", StatusInterval: 1}
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(&config1, testMessenger)
+	pub2 := publisher.NewPublisher(&config2, testMessenger)
+	pub1.Start()
+	pub2.Start()
+
+	// the periodic status of the other publisher is a sample of its clock.
+	// The dummy messenger doesn't retain messages so only publisher1 receives the identity of the other.
+	assert.Zero(t, pub1.ClockSkewSamples())
+	assert.Eventually(t, func() bool {
+		return pub1.ClockSkewSamples() > 0
+	}, 5*time.Second, 100*time.Millisecond)
+	skew, inSync := pub1.ClockSkew()
+	assert.True(t, inSync)
+	assert.InDelta(t, 0, float64(skew), float64(time.Second))
+	pub2.Stop()
+	pub1.Stop()
+}
+
+func TestRestoreInputsAfterDiscovery(t *testing.T) {
+	const node22HWID = "node22"
+	var restoredValue atomic.Value
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder,
+		Domain: "test", PublisherID: "publisher1", RestoreInputValues: true}
+	inputID := inputs.MakeInputHWID(node22HWID, types.InputTypeSwitch, types.DefaultInputInstance)
+	inputValuesFile := filepath.Join(tempFolder, config.PublisherID+publisher.InputValuesFileSuffix)
+	require.NoError(t, ioutil.WriteFile(inputValuesFile, []byte(`{"`+inputID+`": "on"}`), 0644))
+
+	// the input is created by the poll handler after the values are loaded
+	pub1 := publisher.NewPublisher(&config, messaging.NewDummyMessenger(msgConfig))
+	pub1.SetPollInterval(60, func(pub *publisher.Publisher) {
+		pub.CreateNode(node22HWID, types.NodeTypeUnknown)
+		pub.CreateInput(node22HWID, types.InputTypeSwitch, types.DefaultInputInstance,
+			func(input *types.InputDiscoveryMessage, sender string, value string) {
+				if sender == inputs.RestoredInputSender {
+					restoredValue.Store(value)
+				}
+			})
+	})
+	pub1.Start()
+	assert.Eventually(t, func() bool {
+		return restoredValue.Load() == "on"
+	}, 5*time.Second, 100*time.Millisecond)
+	pub1.Stop()
+}
+
+func TestMissingNodes(t *testing.T) {
+	const presentHWID = "node12"
+	const missingHWID = "node13"
+	const removedHWID = "node14"
+	tempDir, err := ioutil.TempDir("", "missingnodes")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	config := *test1Config
+	config.ConfigFolder = tempDir
+	config.RemoveMissingDays = 1
+
+	// cache the nodes of a previous run
+	pub1 := publisher.NewPublisher(&config, messaging.NewDummyMessenger(msgConfig))
+	pub1.CreateNode(presentHWID, types.NodeTypeUnknown)
+	pub1.CreateNode(missingHWID, types.NodeTypeUnknown)
+	pub1.CreateNode(removedHWID, types.NodeTypeUnknown)
+	lastWeek := time.Now().Add(-7 * 24 * time.Hour).Format(types.TimeFormat)
+	pub1.UpdateNodeStatus(removedHWID, map[types.NodeStatus]string{types.NodeStatusLastSeen: lastWeek})
+	require.NoError(t, pub1.SaveRegisteredNodes())
+
+	// only one node is rediscovered
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub2 := publisher.NewPublisher(&config, testMessenger)
+	pub2.SetPollInterval(0, func(pub *publisher.Publisher) {
+		pub.CreateNode(presentHWID, types.NodeTypeUnknown)
+	})
+	pub2.TriggerDiscovery()
+	missing := pub2.CheckMissingNodes()
+	assert.Len(t, missing, 2)
+	runState, _ := pub2.GetNodeStatus(missingHWID, types.NodeStatusRunState)
+	assert.Equal(t, types.NodeRunStateMissing, runState)
+	assert.Nil(t, pub2.GetNodeByHWID(removedHWID), "node missing too long should be removed")
+	assert.NotNil(t, pub2.GetNodeByHWID(presentHWID))
+
+	// rediscovered nodes are ready and no longer have a last seen time
+	pub2.CreateNode(missingHWID, types.NodeTypeUnknown)
+	runState, _ = pub2.GetNodeStatus(missingHWID, types.NodeStatusRunState)
+	assert.Equal(t, types.NodeRunStateReady, runState)
+	_, hasLastSeen := pub2.GetNodeStatus(missingHWID, types.NodeStatusLastSeen)
+	assert.False(t, hasLastSeen)
+	assert.Len(t, pub2.CheckMissingNodes(), 0)
+
+	// removed nodes remain removed after a restart
+	pub2.PublishUpdates()
+	pub3 := publisher.NewPublisher(&config, messaging.NewDummyMessenger(msgConfig))
+	assert.Nil(t, pub3.GetNodeByHWID(removedHWID))
+	assert.NotNil(t, pub3.GetNodeByHWID(missingHWID))
+}
+
+func TestSelfConfigure(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "selfconfigure")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	defer logrus.SetLevel(logrus.DebugLevel)
+	config := *test1Config
+	config.ConfigFolder = tempDir
+	config.SelfConfigure = true
+
+	pub1 := publisher.NewPublisher(&config, messaging.NewDummyMessenger(msgConfig))
+	selfNode := pub1.GetNodeByHWID(publisher.PublisherNodeHWID)
+	require.NotNil(t, selfNode, "Missing publisher node")
+	pub1.Start()
+	defer pub1.Stop()
+
+	// configuration is applied through the standard $configure command
+	logrus.SetLevel(logrus.DebugLevel)
+	sent := pub1.PublishNodeConfigure(selfNode.Address, types.NodeAttrMap{
+		publisher.PublisherNodeAttrLogLevel:      "info",
+		publisher.PublisherNodeAttrPublishBudget: "not a number",
+		types.NodeAttrPollInterval:               "30",
+	})
+	require.True(t, sent)
+	assert.Equal(t, logrus.InfoLevel, logrus.GetLevel())
+	interval, _ := pub1.GetNodeConfigInt(publisher.PublisherNodeHWID, types.NodeAttrPollInterval, 0)
+	assert.Equal(t, 30, interval)
+	budget, _ := pub1.GetNodeConfigInt(publisher.PublisherNodeHWID, publisher.PublisherNodeAttrPublishBudget, -1)
+	assert.Equal(t, 0, budget, "Invalid values should be rejected")
+
+	// configured values are restored when the publisher starts
+	require.NoError(t, pub1.SaveRegisteredNodes())
+	logrus.SetLevel(logrus.DebugLevel)
+	pub2 := publisher.NewPublisher(&config, messaging.NewDummyMessenger(msgConfig))
+	pub2.Start()
+	assert.Equal(t, logrus.InfoLevel, logrus.GetLevel())
+	pub2.Stop()
+
+	// the connection status is reported in the publisher node status
+	assert.Equal(t, messaging.ConnectionStateConnected, pub1.GetConnectionStatus().State)
+	assert.Eventually(t, func() bool {
+		connection, _ := pub1.GetNodeStatus(publisher.PublisherNodeHWID, publisher.PublisherNodeStatusConnection)
+		return connection == string(messaging.ConnectionStateConnected)
+	}, 3*time.Second, 100*time.Millisecond)
+	reconnects, _ := pub1.GetNodeStatus(publisher.PublisherNodeHWID, publisher.PublisherNodeStatusReconnects)
+	assert.Equal(t, "0", reconnects)
+
+	// error case - unsigned configuration is rejected
+	logrus.SetLevel(logrus.DebugLevel)
+	pub1.SetSigningOnOff(false)
+	pub1.PublishNodeConfigure(selfNode.Address, types.NodeAttrMap{publisher.PublisherNodeAttrLogLevel: "error"})
+	assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+	pub1.SetSigningOnOff(true)
+}
+
+func TestPublisherNodeID(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "publishernodeid")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+	defer logrus.SetLevel(logrus.DebugLevel)
+	config := *test1Config
+	config.ConfigFolder = tempDir
+	config.SelfConfigure = true
+	config.PublisherNodeID = "gateway"
+
+	pub1 := publisher.NewPublisher(&config, messaging.NewDummyMessenger(msgConfig))
+	selfNode := pub1.GetNodeByHWID(publisher.PublisherNodeHWID)
+	require.NotNil(t, selfNode, "Missing publisher node")
+	assert.Equal(t, "gateway", selfNode.NodeID)
+	assert.Equal(t, "test/publisher1/gateway/$node", selfNode.Address)
+	// the publisher addresses are not affected
+	assert.Equal(t, "test/publisher1/$identity", pub1.Address())
+	pub1.Start()
+	defer pub1.Stop()
+
+	// the publisher node is configured on its node ID
+	logrus.SetLevel(logrus.DebugLevel)
+	sent := pub1.PublishNodeConfigure(selfNode.Address, types.NodeAttrMap{publisher.PublisherNodeAttrLogLevel: "info"})
+	require.True(t, sent)
+	assert.Equal(t, logrus.InfoLevel, logrus.GetLevel())
+
+	// the configured node ID can't be changed with $setNodeId
+	pub1.HandleSetNodeIDCommand(selfNode.Address, &types.SetNodeIDMessage{NodeID: "other"})
+	assert.Equal(t, "gateway", pub1.GetNodeByHWID(publisher.PublisherNodeHWID).NodeID)
+
+	// error case - node IDs that overlap with publisher addresses are ignored
+	config.PublisherNodeID = types.MessageTypeControl
+	pub2 := publisher.NewPublisher(&config, messaging.NewDummyMessenger(msgConfig))
+	assert.Equal(t, publisher.PublisherNodeHWID, pub2.GetNodeByHWID(publisher.PublisherNodeHWID).NodeID)
+}
+
 func TestSetLogging(t *testing.T) {
 	var logFile = "/tmp/iotdomain-go.log"
 	// var testMessenger = messaging.NewDummyMessenger(msgConfig)
@@ -263,3 +1320,864 @@ func TestErrors(t *testing.T) {
 	pub1.UpdateOutput(nil)
 	pub1.UpdateOutputForecast("fakeid", []types.OutputValue{})
 }
+
+// TestNodeDefinitions tests exporting node definitions and importing them in another publisher
+func TestNodeDefinitions(t *testing.T) {
+	const node22HWID = "node22"
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	definitionsFile := filepath.Join(tempFolder, "nodes.yaml")
+	config1 := &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder, Domain: "test", PublisherID: "definitions1"}
+	pub1 := publisher.NewPublisher(config1, messaging.NewDummyMessenger(msgConfig))
+	pub1.CreateNode(node22HWID, types.NodeTypeMultisensor)
+	pub1.UpdateNodeAttr(node22HWID, types.NodeAttrMap{types.NodeAttrName: "Kitchen"})
+	pub1.UpdateNodeConfig(node22HWID, types.NodeAttrLocalIP, &types.ConfigAttr{DataType: types.DataTypeString, Description: "IP address"})
+	pub1.CreateInput(node22HWID, types.InputTypeSwitch, types.DefaultInputInstance, nil)
+	output := pub1.CreateOutput(node22HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	output.Unit = types.UnitCelcius
+	pub1.UpdateOutput(output)
+
+	err := pub1.ExportNodeDefinitions(definitionsFile)
+	require.NoError(t, err)
+	definitions := pub1.GetNodeDefinitions()
+	require.Len(t, definitions.Nodes, 1)
+	assert.Equal(t, "Kitchen", definitions.Nodes[0].Attr[types.NodeAttrName])
+	require.Len(t, definitions.Nodes[0].Outputs, 1)
+	assert.Equal(t, types.UnitCelcius, definitions.Nodes[0].Outputs[0].Unit)
+
+	// the imported definitions are identical to the exported ones
+	config2 := &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder, Domain: "test", PublisherID: "definitions2"}
+	pub2 := publisher.NewPublisher(config2, messaging.NewDummyMessenger(msgConfig))
+	err = pub2.ImportNodeDefinitions(definitionsFile)
+	require.NoError(t, err)
+	reexportFile := filepath.Join(tempFolder, "nodes2.yaml")
+	err = pub2.ExportNodeDefinitions(reexportFile)
+	require.NoError(t, err)
+	exported, _ := ioutil.ReadFile(definitionsFile)
+	reexported, _ := ioutil.ReadFile(reexportFile)
+	assert.Equal(t, string(exported), string(reexported))
+	assert.NotNil(t, pub2.GetInputByNodeHWID(node22HWID, types.InputTypeSwitch, types.DefaultInputInstance))
+
+	// applying is idempotent and merges attributes
+	err = pub2.ApplyNodeDefinitions(&publisher.NodeDefinitions{Nodes: []publisher.NodeDefinition{{
+		HWID:   node22HWID,
+		NodeID: "kitchen",
+		Attr:   types.NodeAttrMap{types.NodeAttrLocationName: "downstairs"},
+	}}})
+	require.NoError(t, err)
+	node := pub2.GetNodeByHWID(node22HWID)
+	assert.Equal(t, "kitchen", node.NodeID)
+	assert.Equal(t, "Kitchen", node.Attr[types.NodeAttrName])
+	assert.Equal(t, "downstairs", node.Attr[types.NodeAttrLocationName])
+
+	// invalid definitions are not applied
+	err = pub2.ApplyNodeDefinitions(&publisher.NodeDefinitions{Nodes: []publisher.NodeDefinition{
+		{HWID: "node23"},
+		{HWID: node22HWID, Outputs: []publisher.InOutputDefinition{{Instance: "1"}}},
+	}})
+	assert.Error(t, err)
+	assert.Nil(t, pub2.GetNodeByHWID("node23"))
+	err = pub2.ImportNodeDefinitions(filepath.Join(tempFolder, "missing.yaml"))
+	assert.Error(t, err)
+	ioutil.WriteFile(definitionsFile, []byte("nodes: [ not a node"), 0664)
+	err = pub2.ImportNodeDefinitions(definitionsFile)
+	assert.Error(t, err)
+}
+
+// TestProvisionNodes tests pre-registering nodes from the provisioning file of the configuration
+func TestProvisionNodes(t *testing.T) {
+	const node23HWID = "node23"
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	provisioning := `
+nodes:
+  - hwId: node23
+    nodeId: porch
+    attr:
+      name: Porch light
+      locationName: Front door
+    outputs:
+      - type: switch
+        instance: "0"
+`
+	err := ioutil.WriteFile(filepath.Join(tempFolder, "provisioning.yaml"), []byte(provisioning), 0664)
+	require.NoError(t, err)
+	config := &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder, Domain: "test",
+		PublisherID: "provisioning1", ProvisionFile: "provisioning.yaml"}
+	pub := publisher.NewPublisher(config, messaging.NewDummyMessenger(msgConfig))
+	node := pub.GetNodeByHWID(node23HWID)
+	require.NotNil(t, node)
+	assert.Equal(t, "porch", node.NodeID)
+	assert.Equal(t, types.NodeRunStateProvisioned, node.Status[types.NodeStatusRunState])
+	output := pub.GetOutputByNodeHWID(node23HWID, types.OutputTypeSwitch, types.DefaultOutputInstance)
+	require.NotNil(t, output)
+	assert.Equal(t, "test/provisioning1/porch/switch/0/$output", output.Address)
+
+	// the adapter discovers the hardware
+	pub.CreateNode(node23HWID, types.NodeTypeOnOffSwitch)
+	pub.UpdateNodeAttr(node23HWID, types.NodeAttrMap{types.NodeAttrName: "Switch 1", types.NodeAttrModel: "S1"})
+	node = pub.GetNodeByHWID(node23HWID)
+	assert.Equal(t, types.NodeRunStateReady, node.Status[types.NodeStatusRunState])
+	assert.Equal(t, string(types.NodeTypeOnOffSwitch), node.Attr[types.NodeAttrType])
+	assert.Equal(t, "Porch light", node.Attr[types.NodeAttrName])
+	assert.Equal(t, "Front door", node.Attr[types.NodeAttrLocationName])
+	assert.Equal(t, "S1", node.Attr[types.NodeAttrModel])
+
+	err = pub.LoadProvisioning("missing.yaml")
+	assert.Error(t, err)
+	err = pub.ProvisionNodes(&publisher.NodeDefinitions{Nodes: []publisher.NodeDefinition{{NodeID: "nohwid"}}})
+	assert.Error(t, err)
+}
+
+// TestConditionalPublications tests that history is only published while consumers are interested
+func TestConditionalPublications(t *testing.T) {
+	const node24HWID = "node24"
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder, Domain: "test",
+		PublisherID: "interest1", InterestTypes: []string{types.MessageTypeHistory}}
+	testMessenger := messaging.NewDummyMessenger(msgConfig)
+	pub := publisher.NewPublisher(config, testMessenger)
+	pub.Start()
+	defer pub.Stop()
+	historyAddr := "test/interest1/node24/temperature/0/$history"
+	latestAddr := "test/interest1/node24/temperature/0/$latest"
+
+	pub.CreateNode(node24HWID, types.NodeTypeMultisensor)
+	pub.CreateOutput(node24HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub.UpdateOutputValue(node24HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	pub.PublishUpdates()
+	assert.NotEmpty(t, testMessenger.FindLastPublication(latestAddr))
+	assert.Empty(t, testMessenger.FindLastPublication(historyAddr))
+	assert.False(t, pub.HasConsumerInterest(node24HWID, types.MessageTypeHistory))
+
+	// gaining interest publishes the suppressed history
+	err := pub.PublishInterest("test", "interest1", node24HWID, []string{types.MessageTypeHistory}, 60)
+	require.NoError(t, err)
+	assert.True(t, pub.HasConsumerInterest(node24HWID, types.MessageTypeHistory))
+	payload, _ := messaging.JWSPayload(testMessenger.FindLastPublication(historyAddr))
+	assert.Contains(t, payload, `"value": "20"`)
+	pub.UpdateOutputValue(node24HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "21")
+	pub.PublishUpdates()
+	payload, _ = messaging.JWSPayload(testMessenger.FindLastPublication(historyAddr))
+	assert.Contains(t, payload, `"value": "21"`)
+}
+
+// TestPublishPolicy tests that the node's publish configuration controls the output publications
+func TestPublishPolicy(t *testing.T) {
+	const node26HWID = "node26"
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder, Domain: "test",
+		PublisherID: "policy1"}
+	testMessenger := messaging.NewDummyMessenger(msgConfig)
+	pub := publisher.NewPublisher(config, testMessenger)
+	pub.Start()
+	defer pub.Stop()
+	historyAddr := "test/policy1/node26/temperature/0/$history"
+	latestAddr := "test/policy1/node26/temperature/0/$latest"
+
+	pub.CreateNode(node26HWID, types.NodeTypeMultisensor)
+	pub.CreateOutput(node26HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub.UpdateNodeConfigValues(node26HWID, types.NodeAttrMap{types.NodeAttrPublishHistory: "false"})
+	pubHistory, found, err := pub.ReadNodeConfigBool(node26HWID, types.NodeAttrPublishHistory)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.False(t, pubHistory)
+
+	pub.UpdateOutputValue(node26HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	pub.PublishUpdates()
+	assert.NotEmpty(t, testMessenger.FindLastPublication(latestAddr))
+	assert.Empty(t, testMessenger.FindLastPublication(historyAddr))
+
+	// the cached policy is replaced when the configuration changes
+	pub.UpdateNodeConfigValues(node26HWID, types.NodeAttrMap{
+		types.NodeAttrPublishHistory: "true", types.NodeAttrPublishLatest: "false"})
+	pub.UpdateOutputValue(node26HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "21")
+	pub.PublishUpdates()
+	payload, _ := messaging.JWSPayload(testMessenger.FindLastPublication(historyAddr))
+	assert.Contains(t, payload, `"value": "21"`)
+	payload, _ = messaging.JWSPayload(testMessenger.FindLastPublication(latestAddr))
+	assert.Contains(t, payload, `"value": "20"`)
+}
+
+// TestPublisherNodePublishPolicy tests that the publish policy of the publisher node applies to nodes
+// that don't configure it themselves
+func TestPublisherNodePublishPolicy(t *testing.T) {
+	const node27HWID = "node27"
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := *test1Config
+	config.ConfigFolder = tempFolder
+	config.SelfConfigure = true
+	testMessenger := messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(&config, testMessenger)
+	selfNode := pub1.GetNodeByHWID(publisher.PublisherNodeHWID)
+	require.NotNil(t, selfNode, "Missing publisher node")
+	pub1.Start()
+	defer pub1.Stop()
+	historyAddr := "test/publisher1/node27/temperature/0/$history"
+	latestAddr := "test/publisher1/node27/temperature/0/$latest"
+	pub1.CreateNode(node27HWID, types.NodeTypeMultisensor)
+	pub1.CreateOutput(node27HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+
+	// invalid policies are rejected
+	sent := pub1.PublishNodeConfigure(selfNode.Address, types.NodeAttrMap{
+		types.NodeAttrPublishHistory: "false",
+		types.NodeAttrPublishLatest:  "maybe",
+	})
+	require.True(t, sent)
+	latest, _, _ := pub1.ReadNodeConfigBool(publisher.PublisherNodeHWID, types.NodeAttrPublishLatest)
+	assert.True(t, latest, "Invalid values should be rejected")
+	pub1.UpdateOutputValue(node27HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	pub1.PublishUpdates()
+	assert.NotEmpty(t, testMessenger.FindLastPublication(latestAddr))
+	assert.Empty(t, testMessenger.FindLastPublication(historyAddr))
+
+	// the node's own policy takes precedence
+	pub1.UpdateNodeConfigValues(node27HWID, types.NodeAttrMap{types.NodeAttrPublishHistory: "true"})
+	pub1.UpdateOutputValue(node27HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "21")
+	pub1.PublishUpdates()
+	assert.NotEmpty(t, testMessenger.FindLastPublication(historyAddr))
+}
+
+// TestOutputRate tests the rate of change companion output and resampling of the output history
+func TestOutputRate(t *testing.T) {
+	const node25HWID = "node25"
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder, Domain: "test", PublisherID: "rate1"}
+	pub := publisher.NewPublisher(config, messaging.NewDummyMessenger(msgConfig))
+	pub.CreateNode(node25HWID, types.NodeTypeMultisensor)
+	pub.CreateOutput(node25HWID, types.OutputTypeElectricEnergy, types.DefaultOutputInstance)
+	pub.EnableOutputRate(node25HWID, types.OutputTypeElectricEnergy, types.DefaultOutputInstance, outputs.RateUnitSecond)
+	rateInstance := types.DefaultOutputInstance + outputs.RateSuffix
+	require.NotNil(t, pub.GetOutputByNodeHWID(node25HWID, types.OutputTypeElectricEnergy, rateInstance))
+
+	pub.UpdateOutputValue(node25HWID, types.OutputTypeElectricEnergy, types.DefaultOutputInstance, "100")
+	assert.Nil(t, pub.GetOutputValueByNodeHWID(node25HWID, types.OutputTypeElectricEnergy, rateInstance))
+	time.Sleep(50 * time.Millisecond)
+	pub.UpdateOutputValue(node25HWID, types.OutputTypeElectricEnergy, types.DefaultOutputInstance, "101")
+	rate := pub.GetOutputValueByNodeHWID(node25HWID, types.OutputTypeElectricEnergy, rateInstance)
+	require.NotNil(t, rate)
+	rateValue, err := strconv.ParseFloat(rate.Value, 64)
+	require.NoError(t, err)
+	assert.True(t, rateValue > 5 && rateValue <= 20, "unexpected rate %s", rate.Value)
+
+	// a repeated reading has no rate of change
+	time.Sleep(50 * time.Millisecond)
+	pub.UpdateOutputValue(node25HWID, types.OutputTypeElectricEnergy, types.DefaultOutputInstance, "101")
+	rate = pub.GetOutputValueByNodeHWID(node25HWID, types.OutputTypeElectricEnergy, rateInstance)
+	require.NotNil(t, rate)
+	assert.Equal(t, "0", rate.Value)
+
+	now := time.Now()
+	resampled, err := pub.GetResampledHistory(node25HWID, types.OutputTypeElectricEnergy, types.DefaultOutputInstance,
+		now.Add(-time.Second), now, 100*time.Millisecond, outputs.ResampleLOCF)
+	require.NoError(t, err)
+	require.NotEmpty(t, resampled)
+	assert.Equal(t, "101", resampled[0].Value)
+	_, err = pub.GetResampledHistory(node25HWID, types.OutputTypeElectricEnergy, "unknown",
+		now.Add(-time.Second), now, 100*time.Millisecond, outputs.ResampleLOCF)
+	assert.Error(t, err)
+}
+
+func TestForecastAccuracy(t *testing.T) {
+	const node26HWID = "node26"
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder, Domain: "test",
+		PublisherID: "forecast1", SaveForecasts: true}
+	pub := publisher.NewPublisher(config, messaging.NewDummyMessenger(msgConfig))
+	pub.CreateNode(node26HWID, types.NodeTypeMultisensor)
+	pub.CreateOutput(node26HWID, types.OutputTypeElectricPower, types.DefaultOutputInstance)
+	pub.EnableForecastAccuracy(node26HWID, types.OutputTypeElectricPower, types.DefaultOutputInstance)
+	maeInstance := types.DefaultOutputInstance + outputs.ForecastMAESuffix
+	biasInstance := types.DefaultOutputInstance + outputs.ForecastBiasSuffix
+	require.NotNil(t, pub.GetOutputByNodeHWID(node26HWID, types.OutputTypeElectricPower, maeInstance))
+	require.NotNil(t, pub.GetOutputByNodeHWID(node26HWID, types.OutputTypeElectricPower, biasInstance))
+
+	// forecast 12 at the time of the first value and realize it with the next value
+	pub.UpdateOutputValue(node26HWID, types.OutputTypeElectricPower, types.DefaultOutputInstance, "10")
+	latest := pub.GetOutputValueByNodeHWID(node26HWID, types.OutputTypeElectricPower, types.DefaultOutputInstance)
+	require.NotNil(t, latest)
+	outputID := outputs.MakeOutputID(node26HWID, types.OutputTypeElectricPower, types.DefaultOutputInstance)
+	pub.UpdateOutputForecast(outputID, outputs.OutputForecast{
+		{EpochTime: latest.EpochTime, Timestamp: latest.Timestamp, Value: "12"},
+	})
+	assert.Nil(t, pub.GetForecastAccuracy(node26HWID, types.OutputTypeElectricPower, types.DefaultOutputInstance))
+	time.Sleep(10 * time.Millisecond)
+	pub.UpdateOutputValue(node26HWID, types.OutputTypeElectricPower, types.DefaultOutputInstance, "11")
+
+	accuracy := pub.GetForecastAccuracy(node26HWID, types.OutputTypeElectricPower, types.DefaultOutputInstance)
+	require.NotNil(t, accuracy)
+	assert.Equal(t, 1, accuracy.Count)
+	mae := pub.GetOutputValueByNodeHWID(node26HWID, types.OutputTypeElectricPower, maeInstance)
+	require.NotNil(t, mae)
+	assert.Equal(t, "2", mae.Value)
+	bias := pub.GetOutputValueByNodeHWID(node26HWID, types.OutputTypeElectricPower, biasInstance)
+	require.NotNil(t, bias)
+	assert.Equal(t, "2", bias.Value)
+
+	// a reading that repeats the previous value realizes the forecast as well
+	forecastTime := time.Now().Add(5 * time.Millisecond)
+	pub.UpdateOutputForecast(outputID, outputs.OutputForecast{
+		{EpochTime: forecastTime.Unix(), Timestamp: forecastTime.Format(types.TimeFormat), Value: "11"},
+	})
+	time.Sleep(10 * time.Millisecond)
+	pub.UpdateOutputValue(node26HWID, types.OutputTypeElectricPower, types.DefaultOutputInstance, "11")
+	accuracy = pub.GetForecastAccuracy(node26HWID, types.OutputTypeElectricPower, types.DefaultOutputInstance)
+	require.NotNil(t, accuracy)
+	assert.Equal(t, 2, accuracy.Count)
+
+	// the past forecasts are retained in the cache
+	err := pub.SaveForecasts()
+	assert.NoError(t, err)
+	pub2 := publisher.NewPublisher(config, messaging.NewDummyMessenger(msgConfig))
+	err = pub2.LoadForecasts()
+	assert.NoError(t, err)
+	assert.Equal(t, accuracy, pub2.GetForecastAccuracy(node26HWID, types.OutputTypeElectricPower, types.DefaultOutputInstance))
+}
+
+func TestRawSignatures(t *testing.T) {
+	const node1HWID = "node1"
+	const rawAddr = "test/rawsig1/node1/temperature/0/$raw"
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder, Domain: "test",
+		PublisherID: "rawsig1", RawSignatures: true}
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(config, testMessenger)
+	pub1.CreateNode(node1HWID, types.NodeTypeUnknown)
+	pub1.CreateOutput(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	pub1.PublishUpdates()
+
+	// the raw value is published as is with its detached signature
+	assert.Equal(t, "20", testMessenger.FindLastPublication(rawAddr))
+	rawSig := testMessenger.FindLastPublication(outputs.MakeRawSignatureAddress(rawAddr))
+	require.NotEmpty(t, rawSig)
+	publicKey := messaging.PublicKeyFromPem(pub1.GetIdentity().PublicKey)
+	getPublicKey := func(address string) crypto.PublicKey {
+		return publicKey
+	}
+	err := outputs.VerifyRawSignature(rawAddr, "20", rawSig, getPublicKey)
+	assert.NoError(t, err)
+	err = outputs.VerifyRawSignature(rawAddr, "21", rawSig, getPublicKey)
+	assert.Error(t, err)
+	err = outputs.VerifyRawSignature("test/rawsig1/node1/temperature/1/$raw", "20", rawSig, getPublicKey)
+	assert.Error(t, err)
+	err = outputs.VerifyRawSignature(rawAddr, "20", "", getPublicKey)
+	assert.Error(t, err)
+	err = outputs.VerifyRawSignature(rawAddr, "20", rawSig, func(address string) crypto.PublicKey { return nil })
+	assert.Error(t, err)
+	// a signature moved to another output's $rawsig doesn't verify
+	var sigMessage types.OutputRawSignatureMessage
+	payload, _ := messaging.JWSPayload(rawSig)
+	json.Unmarshal([]byte(payload), &sigMessage)
+	sigMessage.Address = "test/rawsig1/node1/temperature/1/$raw"
+	movedSig, _ := json.Marshal(&sigMessage)
+	err = outputs.VerifyRawSignature(sigMessage.Address, "20", string(movedSig), getPublicKey)
+	assert.Error(t, err)
+	assert.Contains(t, pub1.GetIdentity().UnsignedTypes, types.MessageType(types.MessageTypeRawSignature))
+}
+
+func TestNodeIDMappings(t *testing.T) {
+	const node27HWID = "node27"
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder, Domain: "test", PublisherID: "mapping1"}
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub := publisher.NewPublisher(config, testMessenger)
+	node := pub.CreateNode(node27HWID, types.NodeTypeMultisensor)
+	pub.HandleSetNodeIDCommand(node.Address, &types.SetNodeIDMessage{NodeID: "porch"})
+	assert.Equal(t, "porch", pub.GetNodeID(node27HWID))
+	assert.Equal(t, node27HWID, pub.GetNodeHWID("porch"))
+
+	// the change is published with all mappings
+	pub.PublishUpdates()
+	payload, _ := messaging.JWSPayload(testMessenger.FindLastPublication(nodes.MakeNodeIDMappingAddress("test", "mapping1")))
+	var mappingMessage types.NodeIDMappingMessage
+	err := json.Unmarshal([]byte(payload), &mappingMessage)
+	require.NoError(t, err)
+	assert.Equal(t, node27HWID, mappingMessage.HWID)
+	assert.Equal(t, "porch", mappingMessage.NodeID)
+	assert.Equal(t, node27HWID, mappingMessage.PreviousNodeID)
+	assert.Equal(t, map[string]string{node27HWID: "porch"}, mappingMessage.Mappings)
+
+	// the mappings are persisted and applied to rediscovered nodes
+	os.Remove(filepath.Join(tempFolder, "mapping1"+publisher.RegisteredNodesFileSuffix))
+	pub2 := publisher.NewPublisher(config, messaging.NewDummyMessenger(msgConfig))
+	assert.Equal(t, "porch", pub2.GetNodeID(node27HWID))
+	node = pub2.CreateNode(node27HWID, types.NodeTypeMultisensor)
+	assert.Equal(t, "porch", node.NodeID)
+}
+
+func TestDomainLog(t *testing.T) {
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder,
+		Domain: "test", PublisherID: "log1", DomainLog: true}
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	events := make([]types.DomainLogMessage, 0)
+	testMessenger.Subscribe("+/+/"+types.MessageTypeDomainLog, func(address string, message string) error {
+		var event types.DomainLogMessage
+		payload, _ := messaging.JWSPayload(message)
+		err := json.Unmarshal([]byte(payload), &event)
+		assert.NoError(t, err)
+		assert.Equal(t, publisher.MakeDomainLogAddress("test", "log1"), address)
+		events = append(events, event)
+		return nil
+	})
+	pub := publisher.NewPublisher(config, testMessenger)
+	pub.Start()
+	defer pub.Stop()
+
+	// node ID changes are logged
+	node := pub.CreateNode(node1ID, types.NodeTypeMultisensor)
+	pub.HandleSetNodeIDCommand(node.Address, &types.SetNodeIDMessage{NodeID: "porch"})
+	pub.PublishUpdates()
+	require.Len(t, events, 1)
+	assert.Equal(t, types.DomainLogEventAliasChanged, events[0].Event)
+	assert.Equal(t, "test/log1/porch/$node", events[0].Subject)
+	assert.Equal(t, "test/log1/$identity", events[0].Sender)
+
+	// a set command that claims to be sent by this publisher but is signed by another key is logged
+	pub.CreateInput(node1ID, types.InputTypeSwitch, types.DefaultInputInstance, nil)
+	myIdentityAddr := pub.GetIdentity().Address
+	spoofSigner := messaging.NewMessageSigner(testMessenger, messaging.CreateAsymKeys(), nil)
+	setAddr := inputs.MakeSetInputAddress("test", "log1", node1ID, types.InputTypeSwitch, types.DefaultInputInstance)
+	spoofSigner.PublishObject(setAddr, false, &types.SetInputMessage{
+		Address: setAddr, Sender: myIdentityAddr, Value: "on",
+	}, &pub.GetIdentityKeys().PublicKey)
+	require.Len(t, events, 2)
+	assert.Equal(t, types.DomainLogEventRejectedSignature, events[1].Event)
+	assert.Equal(t, types.DomainLogSeverityCritical, events[1].Severity)
+	assert.Equal(t, myIdentityAddr, events[1].Subject)
+	// repeated rejections of the same sender are aggregated until the end of the log interval
+	for i := 0; i < 3; i++ {
+		spoofSigner.PublishObject(setAddr, false, &types.SetInputMessage{
+			Address: setAddr, Sender: myIdentityAddr, Value: "on",
+		}, &pub.GetIdentityKeys().PublicKey)
+	}
+	require.Len(t, events, 2)
+
+	// applications can log their own events
+	err := pub.LogDomainEvent(types.DomainLogEventRevoked, types.DomainLogSeverityWarning, "test/other1", "revoked")
+	assert.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, types.DomainLogEventRevoked, events[2].Event)
+}
+
+func TestReloadIdentity(t *testing.T) {
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder,
+		Domain: "test", PublisherID: "reload1", IdentityPriority: 1, RawSignatures: true}
+	identityFile := filepath.Join(tempFolder, "reload1"+publisher.RegisteredIdentityFileSuffix)
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub := publisher.NewPublisher(config, testMessenger)
+	pub.Start()
+	defer pub.Stop()
+	oldKey := pub.GetIdentity().PublicKey
+
+	// a corrupt identity file is refused and the current identity remains in use
+	os.Chmod(identityFile, 0600)
+	ioutil.WriteFile(identityFile, []byte("not an identity"), 0600)
+	err := pub.HandleControlCommand(types.PublisherControlReloadIdentity, "", "test/admin")
+	assert.Error(t, err)
+	assert.Equal(t, oldKey, pub.GetIdentity().PublicKey)
+
+	// the new identity is used for signing and published
+	newIdent := identities.NewRegisteredIdentity("test", "reload1", identityFile)
+	err = newIdent.SaveIdentity()
+	require.NoError(t, err)
+	err = pub.HandleControlCommand(types.PublisherControlReloadIdentity, "", "test/admin")
+	require.NoError(t, err)
+	newKey := newIdent.GetPrivateKey()
+	assert.NotEqual(t, oldKey, pub.GetIdentity().PublicKey)
+	assert.Equal(t, newKey, pub.GetIdentityKeys())
+
+	// the configured priority and unsigned types are applied to the reloaded identity
+	assert.Equal(t, 1, pub.GetIdentity().Priority)
+	assert.Contains(t, pub.GetIdentity().UnsignedTypes, types.MessageType(types.MessageTypeRaw))
+	err = messaging.VerifyIdentitySignature(pub.GetIdentity(), &newKey.PublicKey)
+	assert.NoError(t, err)
+
+	var published types.PublisherIdentityMessage
+	payload, err := messaging.VerifyJWSMessage(testMessenger.FindLastPublication(pub.Address()), &newKey.PublicKey)
+	require.NoError(t, err, "identity is not signed with the new key")
+	json.Unmarshal([]byte(payload), &published)
+	assert.Equal(t, pub.GetIdentity().PublicKey, published.PublicKey)
+}
+
+func TestProfiling(t *testing.T) {
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder,
+		Domain: "test", PublisherID: "profile1", PprofListen: "127.0.0.1:0"}
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub := publisher.NewPublisher(config, testMessenger)
+	assert.Empty(t, pub.PprofAddress())
+	pub.Start()
+
+	// the pprof endpoint runs while the publisher is started
+	pprofAddress := pub.PprofAddress()
+	require.NotEmpty(t, pprofAddress)
+	resp, err := http.Get("http://" + pprofAddress + "/debug/pprof/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// profiles are captured with control commands
+	err = pub.HandleControlCommand(types.PublisherControlProfileHeap, "", "test/admin")
+	require.NoError(t, err)
+	heapFiles, _ := filepath.Glob(filepath.Join(tempFolder, "profile1-heap-*.pprof"))
+	assert.Len(t, heapFiles, 1)
+	err = pub.HandleControlCommand(types.PublisherControlProfileCPU, "soon", "test/admin")
+	assert.Error(t, err)
+
+	cpuFile, err := pub.StartCPUProfile()
+	require.NoError(t, err)
+	_, err = pub.StartCPUProfile()
+	assert.Error(t, err, "only one CPU profile at a time")
+	assert.Equal(t, cpuFile, pub.StopCPUProfile())
+	assert.Empty(t, pub.StopCPUProfile())
+	_, err = os.Stat(cpuFile)
+	assert.NoError(t, err)
+
+	// the timer of a stopped profileCPU capture doesn't end the next capture
+	err = pub.HandleControlCommand(types.PublisherControlProfileCPU, "1", "test/admin")
+	require.NoError(t, err)
+	assert.NotEmpty(t, pub.StopCPUProfile())
+	cpuFile, err = pub.StartCPUProfile()
+	require.NoError(t, err)
+	time.Sleep(1200 * time.Millisecond)
+	assert.Equal(t, cpuFile, pub.StopCPUProfile())
+
+	pub.Stop()
+	assert.Empty(t, pub.PprofAddress())
+
+	// the unauthenticated endpoint doesn't listen on the network unless allowed
+	config.PprofListen = "0.0.0.0:0"
+	assert.Error(t, config.Validate())
+	pub2 := publisher.NewPublisher(config, testMessenger)
+	pub2.Start()
+	assert.Empty(t, pub2.PprofAddress())
+	pub2.Stop()
+}
+
+func TestQuotas(t *testing.T) {
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder,
+		Domain: "test", PublisherID: "quota1", SelfConfigure: true,
+		MaxNodes: 2, MaxNodeOutputs: 1, MaxHistoryKB: 1, MaxPublishRate: 1000}
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub := publisher.NewPublisher(config, testMessenger)
+
+	// the publisher node counts as a node
+	assert.NotNil(t, pub.CreateNode(node1ID, types.NodeTypeMultisensor))
+	assert.Nil(t, pub.CreateNode("node2", types.NodeTypeMultisensor))
+	output := pub.CreateOutput(node1ID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub.CreateOutput(node1ID, types.OutputTypeHumidity, types.DefaultOutputInstance)
+	assert.Len(t, pub.GetOutputs(), 1)
+	// the outputs and inputs of rejected nodes are rejected as well
+	pub.CreateOutput("node2", types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub.CreateInput("node2", types.InputTypeSwitch, types.DefaultInputInstance, nil)
+	assert.Len(t, pub.GetOutputs(), 1)
+	assert.Nil(t, pub.GetInputByNodeHWID("node2", types.InputTypeSwitch, types.DefaultInputInstance))
+	for i := 0; i < 100; i++ {
+		pub.UpdateOutputValue(node1ID, output.OutputType, output.Instance, strconv.Itoa(i))
+	}
+
+	status := pub.GetQuotaStatus()
+	assert.Equal(t, 2, status.NodeCount)
+	assert.Equal(t, 1, status.RejectedNodes)
+	assert.Equal(t, 1, status.RejectedOutputs)
+	assert.LessOrEqual(t, status.HistoryMemory, 1024)
+	assert.Equal(t, 1000, status.MaxPublishRate)
+	assert.Equal(t, []string{publisher.QuotaNodes, publisher.QuotaNodeOutputs}, status.ExceededQuotas())
+
+	// exceeded quotas are reported in the publisher node status
+	pub.Start()
+	time.Sleep(1100 * time.Millisecond)
+	pub.Stop()
+	value, _ := pub.GetNodeStatus(publisher.PublisherNodeHWID, publisher.PublisherNodeStatusQuotaExceeded)
+	assert.Equal(t, "maxNodes,maxNodeOutputs", value)
+}
+
+func TestTopicAuthorization(t *testing.T) {
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder,
+		Domain: "test", PublisherID: "acl1", PreflightTopics: true}
+	var testMessenger = messaging.NewDummyMessenger(msgConfig)
+	testMessenger.DenyTopic(messaging.TopicOperationPublish, "test/acl1/+/$node")
+	testMessenger.DenyTopic(messaging.TopicOperationSubscribe, "test/acl1/+/$setNodeId")
+	pub := publisher.NewPublisher(config, testMessenger)
+	deniedCount := 0
+	assert.True(t, pub.SetOnTopicDenied(func(denial messaging.TopicDenial) {
+		deniedCount++
+	}))
+	pub.Start()
+	defer pub.Stop()
+
+	// the preflight at startup detects the denied publication and subscription
+	assert.Eventually(t, func() bool {
+		return len(pub.GetTopicDenials()) == 2
+	}, time.Second, 10*time.Millisecond)
+	denials := pub.GetTopicDenials()
+	require.Len(t, denials, 2)
+	assert.Equal(t, "test/acl1/+/$node", denials[0].Address)
+	assert.Equal(t, messaging.TopicOperationPublish, denials[0].Operation)
+	assert.Equal(t, "test/acl1/+/$setNodeId", denials[1].Address)
+	assert.Equal(t, messaging.TopicOperationSubscribe, denials[1].Operation)
+	assert.Equal(t, 2, deniedCount)
+
+	// denied publications are dropped and counted
+	pub.CreateNode(node1ID, types.NodeTypeMultisensor)
+	pub.PublishUpdates()
+	assert.Empty(t, testMessenger.FindLastPublication("test/acl1/"+node1ID+"/$node"))
+	assert.Len(t, pub.GetTopicDenials(), 3)
+
+	denials, err := pub.CheckTopicAccess()
+	require.NoError(t, err)
+	assert.Len(t, denials, 2)
+
+	// messengers that can't detect denials
+	pub2 := publisher.NewPublisher(config, messaging.NewMultiMessenger())
+	assert.False(t, pub2.SetOnTopicDenied(nil))
+	_, err = pub2.CheckTopicAccess()
+	assert.Error(t, err)
+	assert.Empty(t, pub2.GetTopicDenials())
+}
+
+func TestSaveSequences(t *testing.T) {
+	const node1HWID = "node1"
+	const latestAddr = "test/seq1/node1/temperature/0/" + types.MessageTypeLatest
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{ConfigFolder: tempFolder, CacheFolder: tempFolder,
+		Domain: "test", PublisherID: "seq1", SaveSequences: true}
+	getSequence := func(messenger *messaging.DummyMessenger) uint64 {
+		payload, _ := messaging.JWSPayload(messenger.FindLastPublication(latestAddr))
+		var latest types.OutputLatestMessage
+		json.Unmarshal([]byte(payload), &latest)
+		return latest.Sequence
+	}
+
+	testMessenger := messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(config, testMessenger)
+	pub1.Start()
+	pub1.CreateNode(node1HWID, types.NodeTypeMultisensor)
+	pub1.CreateOutput(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	pub1.PublishUpdates()
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "21")
+	pub1.PublishUpdates()
+	assert.Equal(t, uint64(2), getSequence(testMessenger))
+	pub1.Stop()
+	assert.FileExists(t, filepath.Join(tempFolder, "seq1"+publisher.SequencesFileSuffix))
+
+	// the sequence nrs continue after a restart
+	testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 = publisher.NewPublisher(config, testMessenger)
+	pub1.Start()
+	pub1.CreateNode(node1HWID, types.NodeTypeMultisensor)
+	pub1.CreateOutput(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "22")
+	pub1.PublishUpdates()
+	assert.Equal(t, uint64(3), getSequence(testMessenger))
+	pub1.Stop()
+}
+
+func TestReplayOutputValues(t *testing.T) {
+	const node1HWID = "node1"
+	const latestAddr = "test/replay1/node1/temperature/0/" + types.MessageTypeLatest
+	config := &publisher.PublisherConfig{Domain: "test", PublisherID: "replay1", ReplayBudget: 10}
+	testMessenger := messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(config, testMessenger)
+	var result *types.OutputReplayResultMessage
+	pub1.SetOnDomainOutputReplay(func(replayed *types.OutputReplayResultMessage) {
+		result = replayed
+	})
+	pub1.Start()
+	pub1.Subscribe("test", "replay1")
+	pub1.CreateNode(node1HWID, types.NodeTypeMultisensor)
+	pub1.CreateOutput(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	for _, value := range []string{"20", "21", "22"} {
+		pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, value)
+		pub1.PublishUpdates()
+	}
+
+	// the history values have the sequence nr of their $latest publication
+	err := pub1.RequestDomainOutputReplay(latestAddr, 1, time.Time{})
+	assert.NoError(t, err)
+	require.NotNil(t, result)
+	assert.True(t, result.Complete)
+	require.Len(t, result.Replay, 2)
+	assert.Equal(t, "21", result.Replay[0].Value)
+	assert.Equal(t, uint64(3), result.Replay[1].Sequence)
+	pub1.Stop()
+}
+
+func TestRawValueCodecs(t *testing.T) {
+	const node1HWID = "node1"
+	const rawAddr = "test/codec1/node1/temperature/0/" + types.MessageTypeRaw
+	config := &publisher.PublisherConfig{Domain: "test", PublisherID: "codec1"}
+	testMessenger := messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(config, testMessenger)
+	pub1.CreateNode(node1HWID, types.NodeTypeMultisensor)
+	pub1.CreateOutput(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	getRaw := func() string {
+		payload, _ := messaging.JWSPayload(testMessenger.FindLastPublication(rawAddr))
+		return payload
+	}
+
+	// without codec configuration the plain value is published
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "20")
+	pub1.PublishUpdates()
+	assert.Equal(t, "20", getRaw())
+
+	// a custom codec is configured with $configure
+	err := pub1.RegisterValueCodec("hex", func(output *types.OutputDiscoveryMessage, value *types.OutputValue) (string, error) {
+		return fmt.Sprintf("%x", value.Value), nil
+	})
+	assert.NoError(t, err)
+	assert.Error(t, pub1.RegisterValueCodec(outputs.ValueCodecJSON, outputs.EncodeValuePlain))
+	pub1.EnableOutputCodec(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, outputs.ValueCodecCSV)
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "21")
+	pub1.PublishUpdates()
+	assert.Contains(t, getRaw(), ",21,")
+
+	codecAttr := outputs.MakeCalibrationAttr(types.OutputTypeTemperature, types.DefaultOutputInstance, types.NodeAttrRawCodec)
+	pub1.UpdateNodeConfigValues(node1HWID, types.NodeAttrMap{codecAttr: "hex"})
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance, "22")
+	pub1.PublishUpdates()
+	assert.Equal(t, "3232", getRaw())
+
+	// $latest remains canonical
+	latest := pub1.GetOutputValueByNodeHWID(node1HWID, types.OutputTypeTemperature, types.DefaultOutputInstance)
+	require.NotNil(t, latest)
+	assert.Equal(t, "22", latest.Value)
+}
+
+func TestOutputGroups(t *testing.T) {
+	const node1HWID = "meter1"
+	const groupAddr = "test/group1/phases/" + types.MessageTypeGroup
+	config := &publisher.PublisherConfig{Domain: "test", PublisherID: "group1"}
+	testMessenger := messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(config, testMessenger)
+	pub1.CreateNode(node1HWID, types.NodeTypeMultisensor)
+	outputIDs := make([]string, 0)
+	for _, phase := range []string{"l1", "l2", "l3"} {
+		output := pub1.CreateOutput(node1HWID, types.OutputTypeVoltage, phase)
+		outputIDs = append(outputIDs, output.OutputID)
+	}
+	assert.Error(t, pub1.SetOutputGroup("phases/1", outputIDs...))
+	assert.Error(t, pub1.SetOutputGroup("phases", "unknown"))
+	assert.Error(t, pub1.SetOutputGroup(node1HWID, outputIDs...), "group ID can't be a node ID")
+	assert.Error(t, pub1.PublishOutputGroup("phases"))
+	err := pub1.SetOutputGroup("phases", outputIDs...)
+	require.NoError(t, err)
+
+	// the group is published once with the values of all phases
+	for index, phase := range []string{"l1", "l2", "l3"} {
+		pub1.UpdateOutputValue(node1HWID, types.OutputTypeVoltage, phase, strconv.Itoa(230+index))
+	}
+	pub1.PublishUpdates()
+	payload, isSigned := messaging.JWSPayload(testMessenger.FindLastPublication(groupAddr))
+	assert.True(t, isSigned)
+	var group types.OutputGroupMessage
+	err = json.Unmarshal([]byte(payload), &group)
+	require.NoError(t, err)
+	require.Len(t, group.Outputs, 3)
+	assert.Equal(t, "230", group.Outputs[0].Value)
+	assert.Equal(t, "232", group.Outputs[2].Value)
+	assert.Equal(t, uint64(1), group.Sequence)
+
+	// updating a single phase publishes a new snapshot of the group
+	pub1.UpdateOutputValue(node1HWID, types.OutputTypeVoltage, "l2", "240")
+	pub1.PublishUpdates()
+	payload, _ = messaging.JWSPayload(testMessenger.FindLastPublication(groupAddr))
+	json.Unmarshal([]byte(payload), &group)
+	assert.Equal(t, "240", group.Outputs[1].Value)
+	assert.Equal(t, "230", group.Outputs[0].Value)
+	assert.Equal(t, uint64(2), group.Sequence)
+	pub1.RemoveOutputGroup("phases")
+	assert.Error(t, pub1.PublishOutputGroup("phases"))
+}
+
+func TestNodeTwins(t *testing.T) {
+	const node1HWID = "thermostat1"
+	tempFolder, _ := ioutil.TempDir("", "iotdomain")
+	defer os.RemoveAll(tempFolder)
+	config := &publisher.PublisherConfig{CacheFolder: tempFolder, Domain: "test", PublisherID: "twin1", SaveTwins: true}
+	testMessenger := messaging.NewDummyMessenger(msgConfig)
+	pub1 := publisher.NewPublisher(config, testMessenger)
+	desired := make(map[string]string)
+	pub1.SetOnDesiredProperties(func(hwID string, changed map[string]string) {
+		for name, value := range changed {
+			desired[name] = value
+		}
+	})
+	convergedVersion := uint64(0)
+	pub1.SetOnTwinConverged(func(hwID string, twin *types.NodeTwinMessage) {
+		convergedVersion = twin.DesiredVersion
+	})
+	pub1.Start()
+	node := pub1.CreateNode(node1HWID, types.NodeTypeThermostat)
+	_, err := pub1.SetDesiredProperties("unknown", map[string]string{"setpoint": "20"})
+	assert.Error(t, err)
+
+	// the desired properties are set remotely
+	err = pub1.PublishSetDesired(node.Address, map[string]string{"setpoint": "21", "mode": "heat"})
+	require.NoError(t, err)
+	assert.Equal(t, "21", desired["setpoint"])
+	twin := pub1.GetNodeTwin(node1HWID)
+	require.NotNil(t, twin)
+	assert.False(t, twin.Converged)
+	assert.Equal(t, []string{"mode", "setpoint"}, twin.Pending)
+
+	// the device reports the desired properties
+	pub1.SetReportedProperties(node1HWID, map[string]string{"setpoint": "21"})
+	assert.Len(t, pub1.GetPendingProperties(node1HWID), 1)
+	assert.Equal(t, uint64(0), convergedVersion)
+	pub1.SetReportedProperties(node1HWID, map[string]string{"mode": "heat", "humidity": "40"})
+	assert.Equal(t, uint64(1), convergedVersion)
+	pub1.PublishUpdates()
+	payload, _ := messaging.JWSPayload(testMessenger.FindLastPublication("test/twin1/thermostat1/" + types.MessageTypeTwin))
+	var published types.NodeTwinMessage
+	err = json.Unmarshal([]byte(payload), &published)
+	require.NoError(t, err)
+	assert.True(t, published.Converged)
+	assert.Equal(t, "40", published.Reported["humidity"])
+	pub1.Stop()
+
+	// the desired properties are restored after a restart and applied when the node is created
+	testMessenger = messaging.NewDummyMessenger(msgConfig)
+	pub1 = publisher.NewPublisher(config, testMessenger)
+	pendingChannel := make(chan map[string]string, 1)
+	pub1.SetOnDesiredProperties(func(hwID string, changed map[string]string) {
+		pendingChannel <- changed
+	})
+	pub1.Start()
+	pub1.PublishUpdates()
+	assert.Empty(t, testMessenger.FindLastPublication("test/twin1/thermostat1/"+types.MessageTypeTwin))
+	pub1.CreateNode(node1HWID, types.NodeTypeThermostat)
+	select {
+	case pending := <-pendingChannel:
+		assert.Equal(t, map[string]string{"mode": "heat", "setpoint": "21"}, pending)
+	case <-time.After(time.Second):
+		assert.Fail(t, "desired handler not notified of the loaded twin")
+	}
+	twin = pub1.GetNodeTwin(node1HWID)
+	require.NotNil(t, twin)
+	assert.Equal(t, "heat", twin.Desired["mode"])
+	assert.Empty(t, twin.Reported)
+	assert.False(t, twin.Converged)
+	pub1.PublishUpdates()
+	assert.NotEmpty(t, testMessenger.FindLastPublication("test/twin1/thermostat1/"+types.MessageTypeTwin))
+	pub1.Stop()
+}
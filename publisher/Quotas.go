@@ -0,0 +1,103 @@
+// Package publisher with resource quotas that protect shared gateways from runaway adapters
+package publisher
+
+import (
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// PublisherNodeStatusQuotaExceeded is the status attribute of the publisher node with the comma
+// separated names of the quotas that rejected nodes, outputs or publications. Empty if none.
+const PublisherNodeStatusQuotaExceeded types.NodeStatus = "quotaExceeded"
+
+// Names of quotas in the quotaExceeded status
+const (
+	QuotaNodeOutputs = "maxNodeOutputs"
+	QuotaNodes       = "maxNodes"
+	QuotaPublishRate = "maxPublishRate"
+)
+
+// QuotaStatus with the usage of the resource quotas of the publisher
+// A max of 0 is unlimited.
+type QuotaStatus struct {
+	HistoryMemory        int // estimated memory in bytes used by the history of output values
+	MaxHistoryMemory     int // max memory in bytes of the history of output values
+	MaxNodeOutputs       int // max nr of outputs per node
+	MaxNodes             int // max nr of nodes
+	MaxPublishRate       int // max nr of publications per second
+	NodeCount            int // nr of registered nodes
+	OutputCount          int // nr of registered outputs
+	PublishRate          int // nr of publications in the previous second
+	RejectedNodes        int // nr of new nodes rejected because the max nr of nodes is reached
+	RejectedOutputs      int // nr of new outputs rejected because their node has the max nr of outputs
+	RejectedPublications int // nr of publications rejected because the max publish rate is reached
+}
+
+// ExceededQuotas returns the names of the quotas that rejected nodes, outputs or publications
+func (status *QuotaStatus) ExceededQuotas() []string {
+	exceeded := make([]string, 0)
+	if status.RejectedNodes > 0 {
+		exceeded = append(exceeded, QuotaNodes)
+	}
+	if status.RejectedOutputs > 0 {
+		exceeded = append(exceeded, QuotaNodeOutputs)
+	}
+	if status.RejectedPublications > 0 {
+		exceeded = append(exceeded, QuotaPublishRate)
+	}
+	return exceeded
+}
+
+// GetQuotaStatus returns the usage of the resource quotas of this publisher
+// The quotas are set with the maxNodes, maxNodeOutputs, maxHistoryKB and maxPublishRate configuration.
+func (pub *Publisher) GetQuotaStatus() QuotaStatus {
+	status := QuotaStatus{}
+	status.NodeCount, status.MaxNodes, status.RejectedNodes = pub.registeredNodes.GetNodeQuota()
+	status.OutputCount, status.MaxNodeOutputs, status.RejectedOutputs = pub.registeredOutputs.GetOutputQuota()
+	status.HistoryMemory, status.MaxHistoryMemory = pub.registeredOutputValues.GetHistoryMemory()
+	publishLimiter := pub.messageSigner.PublishLimiter()
+	status.PublishRate, status.RejectedPublications = publishLimiter.GetRate()
+	status.MaxPublishRate = publishLimiter.MaxRate()
+	return status
+}
+
+// applyQuotas sets the configured resource quotas
+// Nodes and outputs that are registered before the quotas are applied are not removed.
+// With a max nr of nodes, the inputs and outputs of nodes that aren't registered are rejected.
+func (pub *Publisher) applyQuotas() {
+	pub.registeredNodes.SetMaxNodes(pub.config.MaxNodes)
+	var isNodeRegistered func(nodeHWID string) bool
+	if pub.config.MaxNodes > 0 {
+		isNodeRegistered = func(nodeHWID string) bool {
+			return pub.registeredNodes.GetNodeByHWID(nodeHWID) != nil
+		}
+	}
+	pub.registeredInputs.SetNodeFilter(isNodeRegistered)
+	pub.registeredOutputs.SetNodeFilter(isNodeRegistered)
+	pub.registeredOutputs.SetMaxNodeOutputs(pub.config.MaxNodeOutputs)
+	pub.registeredOutputValues.SetMaxHistoryMemory(pub.config.MaxHistoryKB * 1024)
+	pub.messageSigner.PublishLimiter().SetMaxRate(pub.config.MaxPublishRate)
+}
+
+// updateQuotaStatus logs publications that are rejected since the last update and reports the
+// exceeded quotas in the status of the publisher node
+func (pub *Publisher) updateQuotaStatus() {
+	status := pub.GetQuotaStatus()
+	pub.updateMutex.Lock()
+	newRejections := status.RejectedPublications - pub.rejectedPublications
+	pub.rejectedPublications = status.RejectedPublications
+	pub.updateMutex.Unlock()
+
+	if newRejections > 0 {
+		logrus.Errorf("Publisher.updateQuotaStatus: %d publications are rejected. The max publish rate of %d/sec is reached",
+			newRejections, status.MaxPublishRate)
+	}
+	if pub.registeredNodes.GetNodeByHWID(PublisherNodeHWID) == nil {
+		return
+	}
+	pub.registeredNodes.UpdateNodeStatus(PublisherNodeHWID, map[types.NodeStatus]string{
+		PublisherNodeStatusQuotaExceeded: strings.Join(status.ExceededQuotas(), ","),
+	})
+}
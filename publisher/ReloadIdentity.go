@@ -0,0 +1,50 @@
+// Package publisher with reloading of the publisher identity without a restart
+package publisher
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// ReloadIdentity reloads the publisher identity from the identity file, eg after an operator installed
+// a key that is issued out of band, without restarting the publisher.
+// The identity and signing key are replaced together and the new identity is published, so
+// publications are signed with either the previous or the new key. The configured priority and unsigned
+// types are applied to a self-signed identity. The new key is pinned in the trust store to avoid a
+// false key change alarm.
+// If the file is invalid then an error is returned and the current identity remains in use.
+// Invoked on the SIGHUP signal in WaitForSignal and with the reloadIdentity control command.
+func (pub *Publisher) ReloadIdentity() error {
+	changed, err := pub.registeredIdentity.ReloadIdentity(
+		func(fullIdentity *types.PublisherFullIdentity, privKey *ecdsa.PrivateKey) {
+			pub.messageSigner.SetPrivateKey(privKey)
+			pub.messageSigner.SetUnsignedMessageTypes(fullIdentity.UnsignedTypes)
+		})
+	if err != nil {
+		logrus.Errorf("Publisher.ReloadIdentity: %s. Identity not reloaded.", err)
+		return err
+	} else if !changed {
+		logrus.Infof("Publisher.ReloadIdentity: Identity of %s is unchanged", pub.PublisherID())
+		return nil
+	}
+	myIdent, _ := pub.registeredIdentity.GetFullIdentity()
+	pub.domainIdentities.AddIdentity(&myIdent.PublisherIdentityMessage)
+	if pub.trustStore != nil {
+		pub.trustStore.Pin(pub.Domain()+"/"+pub.PublisherID(), myIdent.PublicKey)
+	}
+
+	pub.updateMutex.Lock()
+	isRunning := pub.isRunning
+	pub.updateMutex.Unlock()
+	if isRunning {
+		identities.PublishIdentity(&myIdent.PublisherIdentityMessage, pub.messageSigner)
+	}
+	logrus.Warningf("Publisher.ReloadIdentity: Reloaded identity %s issued by %s", myIdent.Address, myIdent.IssuerID)
+	pub.LogDomainEvent(types.DomainLogEventIdentityChanged, types.DomainLogSeverityInfo, myIdent.Address,
+		fmt.Sprintf("Identity is reloaded from file. Issued by %s", myIdent.IssuerID))
+	return nil
+}
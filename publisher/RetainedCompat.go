@@ -0,0 +1,33 @@
+// Package publisher with republication of discovery on message busses without retained messages
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/sirupsen/logrus"
+)
+
+// IsRetainedSupported returns true if the message bus of the messenger passes retained messages
+// to new subscribers. If not, then the discovery is republished every republishInterval seconds so
+// subscribers reconstruct the domain from the periodic publications.
+func (pub *Publisher) IsRetainedSupported() bool {
+	return messaging.GetCapabilities(pub.messenger).Retained
+}
+
+// updateRepublish counts down the republish interval each heartbeat and republishes the discovery
+// when it has passed, if the message bus doesn't support retained messages
+func (pub *Publisher) updateRepublish() {
+	if pub.IsRetainedSupported() {
+		return
+	}
+	pub.updateMutex.Lock()
+	pub.republishCountdown--
+	republishNow := pub.republishCountdown <= 0
+	if republishNow {
+		pub.republishCountdown = pub.config.RepublishInterval
+	}
+	pub.updateMutex.Unlock()
+	if republishNow {
+		logrus.Infof("Publisher.updateRepublish: Message bus doesn't retain messages. Republishing discovery.")
+		pub.republishDiscovery()
+	}
+}
@@ -0,0 +1,226 @@
+// Package publisher with configuration of the publisher itself through its own node
+package publisher
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// PublisherNodeHWID is the hardware ID of the node that represents the publisher itself
+const PublisherNodeHWID = "publisher"
+
+// Configuration attributes of the publisher node in addition to types.NodeAttrPollInterval and the
+// publish policies in publisherPolicyAttrs
+const (
+	PublisherNodeAttrLogLevel      types.NodeAttr = "logLevel"
+	PublisherNodeAttrMissingGrace  types.NodeAttr = "missingGrace"
+	PublisherNodeAttrPublishBudget types.NodeAttr = "publishBudget"
+)
+
+// Connection status attributes of the publisher node in addition to types.NodeStatusLastError and
+// types.NodeStatusLatencyMSec, the round trip time to the broker
+const (
+	PublisherNodeStatusConnection types.NodeStatus = "connection"
+	PublisherNodeStatusReconnects types.NodeStatus = "reconnects"
+)
+
+// PublisherNodeLatencyChange is the min change of the broker round trip time that updates the latency
+// status of the publisher node. Smaller variations would republish the node each heartbeat.
+const PublisherNodeLatencyChange = 50 * time.Millisecond
+
+// publisherLogLevels are the log levels that can be configured on the publisher node
+var publisherLogLevels = []string{"error", "warning", "info", "debug"}
+
+// publisherPolicyAttrs are the publish policies of the publisher node with their description. They are
+// the default of nodes that don't configure the policy themselves.
+var publisherPolicyAttrs = []struct {
+	attrName     types.NodeAttr
+	defaultValue bool
+	description  string
+}{
+	{types.NodeAttrPublishEvent, false, "Publish the outputs of nodes as event"},
+	{types.NodeAttrPublishHistory, true, "Publish the output history of nodes"},
+	{types.NodeAttrPublishLatest, true, "Publish the latest output value of nodes"},
+	{types.NodeAttrPublishRaw, true, "Publish the raw output value of nodes"},
+}
+
+// applyPublisherNodeConfig applies configuration values of the publisher node to the publisher
+// Invalid values are logged and ignored.
+func (pub *Publisher) applyPublisherNodeConfig(params types.NodeAttrMap) {
+	pub.updateMutex.Lock()
+	defer pub.updateMutex.Unlock()
+	for attrName, value := range params {
+		switch attrName {
+		case PublisherNodeAttrLogLevel:
+			level, err := logrus.ParseLevel(value)
+			if err != nil {
+				logrus.Errorf("Publisher.applyPublisherNodeConfig: Invalid log level '%s'", value)
+				continue
+			}
+			pub.config.Loglevel = value
+			logrus.SetLevel(level)
+		case PublisherNodeAttrMissingGrace, PublisherNodeAttrPublishBudget, types.NodeAttrPollInterval:
+			intValue, err := strconv.Atoi(value)
+			if err != nil || intValue < 0 {
+				logrus.Errorf("Publisher.applyPublisherNodeConfig: Invalid value '%s' for %s", value, attrName)
+				continue
+			}
+			if attrName == PublisherNodeAttrMissingGrace {
+				pub.config.MissingNodeGrace = intValue
+			} else if attrName == PublisherNodeAttrPublishBudget {
+				pub.config.PublishBudget = intValue
+			} else if intValue > 0 {
+				pub.pollInterval = intValue
+				// poll with the new interval
+				if pub.pollCountdown > intValue {
+					pub.pollCountdown = intValue
+				}
+			}
+		case types.NodeAttrPublishEvent, types.NodeAttrPublishHistory, types.NodeAttrPublishLatest, types.NodeAttrPublishRaw:
+			if _, err := strconv.ParseBool(value); err != nil {
+				logrus.Errorf("Publisher.applyPublisherNodeConfig: Invalid value '%s' for %s", value, attrName)
+				continue
+			}
+			// resolve the policies of nodes again as they can use the policy of the publisher node
+			pub.publishPolicies = make(map[string]publishPolicy)
+		default:
+			continue
+		}
+		logrus.Warningf("Publisher.applyPublisherNodeConfig: Publisher %s configuration %s set to '%s'",
+			pub.PublisherID(), attrName, value)
+	}
+}
+
+// createPublisherNode creates the node that represents this publisher with its configuration
+// Configuration values are applied when the publisher starts. The node ID is set if it is configured.
+func (pub *Publisher) createPublisherNode() {
+	regNodes := pub.registeredNodes
+	regNodes.CreateNode(PublisherNodeHWID, types.NodeTypeAdapter)
+	if pub.config.PublisherNodeID != "" {
+		pub.setPublisherNodeID(pub.config.PublisherNodeID)
+	}
+	regNodes.UpdateNodeConfig(PublisherNodeHWID, PublisherNodeAttrLogLevel, &types.ConfigAttr{
+		DataType:    types.DataTypeEnum,
+		Default:     pub.config.Loglevel,
+		Description: "Logging level of the publisher",
+		Enum:        publisherLogLevels,
+	})
+	regNodes.UpdateNodeConfig(PublisherNodeHWID, PublisherNodeAttrMissingGrace, &types.ConfigAttr{
+		DataType:    types.DataTypeInt,
+		Default:     strconv.Itoa(pub.config.MissingNodeGrace),
+		Description: "Seconds after the first discovery before cached nodes are missing. 0 for default",
+	})
+	regNodes.UpdateNodeConfig(PublisherNodeHWID, types.NodeAttrPollInterval, &types.ConfigAttr{
+		DataType:    types.DataTypeInt,
+		Default:     strconv.Itoa(DefaultPollInterval),
+		Description: "Interval in seconds of discovery and polling of values",
+		Max:         24 * 3600,
+		Min:         1,
+	})
+	regNodes.UpdateNodeConfig(PublisherNodeHWID, PublisherNodeAttrPublishBudget, &types.ConfigAttr{
+		DataType:    types.DataTypeInt,
+		Default:     strconv.Itoa(pub.config.PublishBudget),
+		Description: "Max nr of updates published per heartbeat. 0 is unlimited",
+	})
+	for _, policy := range publisherPolicyAttrs {
+		regNodes.UpdateNodeConfig(PublisherNodeHWID, policy.attrName, &types.ConfigAttr{
+			DataType:    types.DataTypeBool,
+			Default:     strconv.FormatBool(policy.defaultValue),
+			Description: policy.description + " that don't configure it themselves",
+		})
+	}
+}
+
+// handleNodeConfigure applies configuration commands of the publisher node and passes configuration
+// commands of other nodes to the application handler, or applies them if no handler is set.
+func (pub *Publisher) handleNodeConfigure(nodeHWID string, params types.NodeAttrMap) {
+	pub.updateMutex.Lock()
+	onNodeConfigHandler := pub.onNodeConfigHandler
+	pub.updateMutex.Unlock()
+
+	if nodeHWID == PublisherNodeHWID {
+		changes, errs := pub.registeredNodes.ValidateNodeConfigValues(nodeHWID, params)
+		for attrName, reason := range errs {
+			logrus.Warningf("Publisher.handleNodeConfigure: Publisher configuration %s rejected: %s",
+				attrName, reason)
+		}
+		pub.registeredNodes.UpdateNodeConfigValues(nodeHWID, changes)
+		pub.applyPublisherNodeConfig(changes)
+	} else if onNodeConfigHandler != nil {
+		onNodeConfigHandler(nodeHWID, params)
+	} else {
+		pub.registeredNodes.UpdateNodeConfigValues(nodeHWID, params)
+	}
+}
+
+// setPublisherNodeID sets the node ID of the publisher node, its inputs and its outputs
+// The node ID must not start with '$' or contain address separators or wildcards, so the addresses of the
+// node don't overlap with the identity, status and $control addresses of the publisher. An invalid
+// node ID or one that is used by another node is logged and ignored.
+func (pub *Publisher) setPublisherNodeID(nodeID string) {
+	if nodeID == "" || strings.HasPrefix(nodeID, "$") || strings.ContainsAny(nodeID, "/+#") {
+		logrus.Errorf("Publisher.setPublisherNodeID: Invalid publisher node ID '%s'. Ignored.", nodeID)
+		return
+	}
+	node := pub.registeredNodes.GetNodeByHWID(PublisherNodeHWID)
+	if node == nil || node.NodeID == nodeID {
+		return
+	}
+	if !pub.registeredNodes.SetNodeID(node, nodeID) {
+		logrus.Errorf("Publisher.setPublisherNodeID: Node ID '%s' is used by another node. Ignored.", nodeID)
+		return
+	}
+	pub.registeredInputs.SetNodeID(PublisherNodeHWID, nodeID)
+	pub.registeredOutputs.SetNodeID(PublisherNodeHWID, nodeID)
+	logrus.Infof("Publisher.setPublisherNodeID: Publisher node ID is '%s'", nodeID)
+}
+
+// startPublisherNode applies the configured values of the publisher node
+// Values that haven't been configured keep the publisher settings.
+func (pub *Publisher) startPublisherNode() {
+	node := pub.registeredNodes.GetNodeByHWID(PublisherNodeHWID)
+	if node == nil {
+		return
+	}
+	params := make(types.NodeAttrMap)
+	for attrName := range node.Config {
+		if value, configured := node.Attr[attrName]; configured {
+			params[attrName] = value
+		}
+	}
+	pub.applyPublisherNodeConfig(params)
+}
+
+// updatePublisherNodeStatus updates the status of the publisher node with the connection status of
+// the messenger, so a lack of publications can be diagnosed remotely
+func (pub *Publisher) updatePublisherNodeStatus() {
+	node := pub.registeredNodes.GetNodeByHWID(PublisherNodeHWID)
+	if node == nil {
+		return
+	}
+	status := pub.GetConnectionStatus()
+	pub.registeredNodes.UpdateNodeStatus(PublisherNodeHWID, map[types.NodeStatus]string{
+		PublisherNodeStatusConnection:  string(status.State),
+		PublisherNodeStatusReconnects:  strconv.Itoa(status.Reconnects),
+		types.NodeStatusLastError:      status.LastError,
+		types.NodeStatusLatencyMSec:    makeLatencyStatus(node.Status[types.NodeStatusLatencyMSec], status.RTT),
+		PublisherNodeStatusTopicDenied: makeTopicDeniedStatus(pub.GetTopicDenials()),
+	})
+}
+
+// makeLatencyStatus returns the latency status for the round trip time in msec. The previous status is
+// kept while the round trip time differs less than PublisherNodeLatencyChange from it.
+func makeLatencyStatus(previous string, rtt time.Duration) string {
+	prevMSec, err := strconv.ParseInt(previous, 10, 64)
+	if err == nil {
+		change := rtt - time.Duration(prevMSec)*time.Millisecond
+		if change < PublisherNodeLatencyChange && change > -PublisherNodeLatencyChange {
+			return previous
+		}
+	}
+	return strconv.FormatInt(int64(rtt/time.Millisecond), 10)
+}
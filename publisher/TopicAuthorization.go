@@ -0,0 +1,91 @@
+// Package publisher with detection of publications and subscriptions that are denied by the broker ACL
+package publisher
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/identities"
+	"github.com/iotdomain/iotdomain-go/inputs"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/nodes"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/sirupsen/logrus"
+)
+
+// PublisherNodeStatusTopicDenied is the status attribute of the publisher node with the comma separated
+// operations that are denied by the broker, eg "publish test/pub1/+/$node". Empty if none.
+const PublisherNodeStatusTopicDenied types.NodeStatus = "topicDenied"
+
+// CheckTopicAccess verifies that the broker authorizes the publications and subscriptions of this publisher.
+// Intended to detect a misconfigured broker ACL at startup, as brokers silently drop denied publications.
+// This runs in the background at startup when preflightTopics is configured. The publications of nodes,
+// inputs and outputs are checked with the PreflightSegment instead of their IDs. The probes are published
+// on the PreflightSegment subtopic of each address so consumers of the publications don't receive them.
+// This returns the denied operations or an error if the messenger can't detect denials.
+func (pub *Publisher) CheckTopicAccess() ([]messaging.TopicDenial, error) {
+	denials, err := messaging.CheckTopicAccess(pub.messenger, pub.getPublicationAddresses(), nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, denial := range denials {
+		logrus.Errorf("Publisher.CheckTopicAccess: The broker denies to %s on %s: %s",
+			denial.Operation, denial.Address, denial.Reason)
+	}
+	return denials, nil
+}
+
+// GetTopicDenials returns the publications and subscriptions that are denied by the broker, with the nr
+// of times they are denied. Denied subscriptions are detected when the broker acknowledges them. Denied
+// publications are only detected with CheckTopicAccess.
+func (pub *Publisher) GetTopicDenials() []messaging.TopicDenial {
+	return messaging.GetTopicDenials(pub.messenger)
+}
+
+// SetOnTopicDenied sets the handler that is invoked when the broker denies a publication or subscription
+// Use nil to remove the handler. This returns false if the messenger can't detect denials.
+func (pub *Publisher) SetOnTopicDenied(handler messaging.TopicDenialHandler) bool {
+	return messaging.SetOnTopicDenied(pub.messenger, handler)
+}
+
+// getPublicationAddresses returns the addresses this publisher publishes on, with wildcards for the IDs
+// of nodes, inputs and outputs
+func (pub *Publisher) getPublicationAddresses() []string {
+	domain := pub.Domain()
+	publisherID := pub.PublisherID()
+	return []string{
+		identities.MakePublisherIdentityAddress(domain, publisherID),
+		identities.MakePublisherStatusAddress(domain, publisherID),
+		nodes.MakeNodeDiscoveryAddress(domain, publisherID, "+"),
+		inputs.MakeInputDiscoveryAddress(domain, publisherID, "+", "+", "+"),
+		outputs.MakeOutputDiscoveryAddress(domain, publisherID, "+", "+", "+"),
+		outputs.MakeOutputValueAddress(domain, publisherID, types.MessageTypeLatest),
+		outputs.MakeOutputValueAddress(domain, publisherID, types.MessageTypeEvent),
+		outputs.MakeOutputValueAddress(domain, publisherID, types.MessageTypeHistory),
+		outputs.MakeGroupAddress(domain, publisherID, "+"),
+	}
+}
+
+// makeTopicDeniedStatus returns the value of the topicDenied status of the publisher node
+func makeTopicDeniedStatus(denials []messaging.TopicDenial) string {
+	denied := make([]string, 0, len(denials))
+	for _, denial := range denials {
+		denied = append(denied, string(denial.Operation)+" "+denial.Address)
+	}
+	sort.Strings(denied)
+	return strings.Join(denied, ",")
+}
+
+// preflightTopics checks the topics of the publisher at startup and logs a summary of the denials
+func (pub *Publisher) preflightTopics() {
+	denials, err := pub.CheckTopicAccess()
+	if err != nil {
+		logrus.Warningf("Publisher.preflightTopics: %s", err)
+	} else if len(denials) > 0 {
+		logrus.Errorf("Publisher.preflightTopics: The broker denies %d topics of publisher %s. Check the broker ACL.",
+			len(denials), pub.PublisherID())
+	} else {
+		logrus.Infof("Publisher.preflightTopics: All topics of publisher %s are authorized", pub.PublisherID())
+	}
+}
@@ -0,0 +1,143 @@
+// Package publisher with validation of the publisher configuration
+package publisher
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/messaging"
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// validLogLevels are the log levels accepted by lib.SetLogging
+var validLogLevels = []string{"error", "warn", "warning", "info", "debug"}
+
+// Validate checks the publisher configuration before the publisher is created, and returns a
+// lib.ConfigError with all problems that are found or nil if the configuration is valid.
+// Problems are reported using their yaml key. This checks that:
+//  - the publisherId and domain are valid address segments
+//  - the loglevel is one of error, warning, info or debug
+//  - intervals, deadlines and quotas are not negative. 0 is the default
+//  - the config and profile folders, and the cache folder if caching is enabled, exist and are writable
+//  - the address template, control roles, unsigned types and provisioning file are valid
+func (config *PublisherConfig) Validate() error {
+	configErr := &lib.ConfigError{}
+	if config.PublisherID == "" {
+		configErr.Add("publisherId is missing")
+	} else if !isValidAddressSegment(config.PublisherID) {
+		configErr.Add("publisherId '%s' can't contain '/', '+', '#' or spaces", config.PublisherID)
+	}
+	if config.Domain != "" && !isValidAddressSegment(config.Domain) {
+		configErr.Add("domain '%s' can't contain '/', '+', '#' or spaces", config.Domain)
+	}
+	if config.Loglevel != "" && !isValidLogLevel(config.Loglevel) {
+		configErr.Add("loglevel '%s' is invalid. Expected error, warning, info or debug", config.Loglevel)
+	}
+
+	positiveSettings := []struct {
+		key   string
+		value int
+	}{
+		{"shutdownDeadline", config.ShutdownDeadline},
+		{"publishBudget", config.PublishBudget},
+		{"missingGrace", config.MissingNodeGrace},
+		{"removeMissing", config.RemoveMissingDays},
+		{"republishOnJoin", config.RepublishOnJoin},
+		{"republishInterval", config.RepublishInterval},
+		{"statusInterval", config.StatusInterval},
+		{"maxNodes", config.MaxNodes},
+		{"maxNodeOutputs", config.MaxNodeOutputs},
+		{"maxHistoryKB", config.MaxHistoryKB},
+		{"maxPublishRate", config.MaxPublishRate},
+		{"flapTransitions", config.FlapTransitions},
+		{"flapMinutes", config.FlapMinutes},
+		{"replayBudget", config.ReplayBudget},
+	}
+	for _, setting := range positiveSettings {
+		if setting.value < 0 {
+			configErr.Add("%s is %d. It can't be negative, use 0 for the default", setting.key, setting.value)
+		}
+	}
+
+	if config.ConfigFolder != "" {
+		if err := lib.CheckFolderWritable(config.ConfigFolder); err != nil {
+			configErr.Add("configFolder: %s", err)
+		}
+	}
+	isCaching := config.SaveDiscoveredPublishers || config.SaveDiscoveredNodes || config.SaveInputValues ||
+		config.SaveCounters || config.SaveForecasts || config.SaveSequences || config.SaveTwins
+	if isCaching && config.CacheFolder != "" {
+		if err := lib.CheckFolderWritable(config.CacheFolder); err != nil {
+			configErr.Add("cacheFolder: %s", err)
+		}
+	}
+	if config.ProfileFolder != "" {
+		if err := lib.CheckFolderWritable(config.ProfileFolder); err != nil {
+			configErr.Add("profileFolder: %s", err)
+		}
+	}
+	if config.PprofListen != "" {
+		if _, _, err := net.SplitHostPort(config.PprofListen); err != nil {
+			configErr.Add("pprofListen '%s' is invalid. Expected host:port, eg localhost:6060", config.PprofListen)
+		} else if !config.PprofAllowRemote && !isLoopbackListenAddress(config.PprofListen) {
+			configErr.Add("pprofListen '%s' isn't a loopback address. Set pprofAllowRemote to allow access from the network",
+				config.PprofListen)
+		}
+	}
+	if config.Logfile != "" {
+		if err := lib.CheckFolderWritable(filepath.Dir(config.Logfile)); err != nil {
+			configErr.Add("logfile: %s", err)
+		}
+	}
+	if config.ProvisionFile != "" {
+		provisionFile := config.ProvisionFile
+		if !filepath.IsAbs(provisionFile) {
+			provisionFile = filepath.Join(config.ConfigFolder, provisionFile)
+		}
+		if _, err := os.Stat(provisionFile); err != nil {
+			configErr.Add("provisionFile %s does not exist", provisionFile)
+		}
+	}
+	if config.AddressTemplate != "" {
+		if err := outputs.ValidateAddressTemplate(config.AddressTemplate, config.Domain, config.PublisherID); err != nil {
+			configErr.Add("addressTemplate: %s", err)
+		}
+	}
+	senders := make([]string, 0, len(config.ControlRoles))
+	for sender := range config.ControlRoles {
+		senders = append(senders, sender)
+	}
+	sort.Strings(senders)
+	for _, sender := range senders {
+		role := config.ControlRoles[sender]
+		if role != types.RoleViewer && role != types.RoleOperator && role != types.RoleAdmin {
+			configErr.Add("controlRoles: role '%s' of %s is invalid. Expected viewer, operator or admin", role, sender)
+		}
+	}
+	for _, messageType := range config.UnsignedTypes {
+		if messaging.IsSigningRequired(types.MessageType(messageType)) {
+			configErr.Add("unsignedTypes: messages of type %s must be signed", messageType)
+		}
+	}
+	return configErr.ErrorOrNil()
+}
+
+// isValidAddressSegment returns true if the value can be used as a segment of a publication address
+func isValidAddressSegment(value string) bool {
+	return !strings.ContainsAny(value, "/+# ")
+}
+
+// isValidLogLevel returns true if the level is accepted by lib.SetLogging
+func isValidLogLevel(level string) bool {
+	for _, validLevel := range validLogLevels {
+		if strings.ToLower(level) == validLevel {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+// Package publisher with configurable encodings of $raw output values for legacy consumers
+package publisher
+
+import (
+	"github.com/iotdomain/iotdomain-go/outputs"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// EnableOutputCodec adds the $raw codec configuration of an output to its node. The codec can be
+// changed with $configure and is one of the built-in or registered codecs. The $latest, $history and
+// $event publications are not affected. The node must exist.
+//  codecName is the default codec, eg outputs.ValueCodecCSV
+func (pub *Publisher) EnableOutputCodec(nodeHWID string, outputType types.OutputType, instance string,
+	codecName string) {
+
+	codecAttr := outputs.MakeCalibrationAttr(outputType, instance, types.NodeAttrRawCodec)
+	pub.registeredNodes.UpdateNodeConfig(nodeHWID, codecAttr, &types.ConfigAttr{
+		DataType:    types.DataTypeEnum,
+		Description: "Encoding of the $raw value of output " + string(outputType) + "/" + instance,
+		Default:     codecName,
+		Enum:        pub.valueCodecs.Names(),
+	})
+}
+
+// RegisterValueCodec registers a custom codec of $raw output values, eg a protobuf encoding
+// Register custom codecs before enabling them with EnableOutputCodec. Built-in codecs can't be replaced.
+func (pub *Publisher) RegisterValueCodec(codecName string, codec outputs.ValueCodec) error {
+	return pub.valueCodecs.Register(codecName, codec)
+}
+
+// encodeRawValue encodes an output value with the $raw codec that is configured for the output
+// Outputs without codec configuration publish the plain value.
+func (pub *Publisher) encodeRawValue(output *types.OutputDiscoveryMessage, value *types.OutputValue) (string, error) {
+	codecAttr := outputs.MakeCalibrationAttr(output.OutputType, output.Instance, types.NodeAttrRawCodec)
+	codecName, _ := pub.registeredNodes.GetNodeConfigString(output.NodeHWID, codecAttr, outputs.ValueCodecPlain)
+	return pub.valueCodecs.Encode(codecName, output, value)
+}
@@ -5,7 +5,9 @@ package publisher
 
 import (
 	"crypto/ecdsa"
+	"time"
 
+	"github.com/iotdomain/iotdomain-go/identities"
 	"github.com/iotdomain/iotdomain-go/inputs"
 	"github.com/iotdomain/iotdomain-go/lib"
 	"github.com/iotdomain/iotdomain-go/nodes"
@@ -21,6 +23,32 @@ func (pub *Publisher) Address() string {
 	return pub.registeredIdentity.GetAddress()
 }
 
+// Compact removes stale address lookups of registered inputs and outputs and reclaims their memory
+// Intended as a periodic maintenance call for long running publishers with frequently renamed nodes.
+func (pub *Publisher) Compact() {
+	removed := pub.registeredInputs.Compact() + pub.registeredOutputs.Compact()
+	if removed > 0 {
+		logrus.Infof("Publisher.Compact: Removed %d stale address lookups", removed)
+	}
+}
+
+// CreateEnumInput creates a new node input that handles set commands with one of the given enum values
+// Set commands with other values are ignored. Use types.EnumValues to define an enum once and reuse it.
+func (pub *Publisher) CreateEnumInput(nodeHWID string, inputType types.InputType, instance string,
+	enum types.EnumValues,
+	setCommandHandler func(input *types.InputDiscoveryMessage, sender string, value string)) *types.InputDiscoveryMessage {
+	input := pub.inputFromSetCommands.CreateEnumInput(nodeHWID, inputType, instance, enum, setCommandHandler)
+	return input
+}
+
+// CreateEnumOutput creates a new node output whose values are one of the given enum values
+// Values are validated against the enum when the output value is updated.
+func (pub *Publisher) CreateEnumOutput(nodeHWID string, outputType types.OutputType,
+	instance string, enum types.EnumValues) *types.OutputDiscoveryMessage {
+	output := pub.registeredOutputs.CreateEnumOutput(nodeHWID, outputType, instance, enum)
+	return output
+}
+
 // CreateInput creates a new node input that handle set commands and add it to the registered inputs
 //  If an input of the given nodeHWID, type and instance already exist it will be replaced. This returns the new input
 func (pub *Publisher) CreateInput(nodeHWID string, inputType types.InputType, instance string,
@@ -60,12 +88,20 @@ func (pub *Publisher) CreateInputFromOutput(
 }
 
 // CreateNode creates a new node and add it to this publisher's registered nodes
-// returns the new node instance
+// returns the new node instance, or nil if the maxNodes quota is reached
 func (pub *Publisher) CreateNode(nodeHWID string, nodeType types.NodeType) *types.NodeDiscoveryMessage {
 	node := pub.registeredNodes.CreateNode(nodeHWID, nodeType)
 	return node
 }
 
+// CreateOrUpdateNode creates a new node or refreshes the type and attributes of an existing node
+// The node is only republished if something changed. See RegisteredNodes.CreateOrUpdateNode for details.
+// returns the node instance, or nil if the maxNodes quota is reached
+func (pub *Publisher) CreateOrUpdateNode(
+	nodeHWID string, nodeType types.NodeType, attr types.NodeAttrMap) *types.NodeDiscoveryMessage {
+	return pub.registeredNodes.CreateOrUpdateNode(nodeHWID, nodeType, attr)
+}
+
 // CreateOutput creates a new node output adds it to this publisher outputs list
 // returns the output object to allow for easy updates
 func (pub *Publisher) CreateOutput(nodeHWID string, outputType types.OutputType,
@@ -74,9 +110,29 @@ func (pub *Publisher) CreateOutput(nodeHWID string, outputType types.OutputType,
 	return output
 }
 
-// DeleteNode deletes a node from the collection of registered nodes
+// DeleteNode deletes a node and its inputs and outputs from this publisher, and clears their
+// retained publications so consumers no longer see the node.
 func (pub *Publisher) DeleteNode(hwAddress string) {
+	node := pub.registeredNodes.GetNodeByHWID(hwAddress)
+	if node == nil {
+		return
+	}
+	addresses := []string{node.Address, outputs.ReplaceMessageType(node.Address, types.MessageTypeEvent)}
+	for _, input := range pub.registeredInputs.GetInputsByNodeHWID(hwAddress) {
+		addresses = append(addresses, input.Address)
+		pub.inputFromSetCommands.DeleteInput(input.InputID)
+	}
+	for _, output := range pub.registeredOutputs.GetOutputsByNodeHWID(hwAddress) {
+		addresses = append(addresses, output.Address)
+		for _, messageType := range retainedOutputMessageTypes {
+			addresses = append(addresses, outputs.ReplaceMessageType(output.Address, messageType))
+		}
+		pub.registeredOutputs.DeleteOutput(output.OutputID)
+	}
 	pub.registeredNodes.DeleteNode(hwAddress)
+	for _, address := range addresses {
+		pub.messageSigner.ClearRetained(address)
+	}
 }
 
 // Domain returns the publication domain
@@ -111,11 +167,44 @@ func (pub *Publisher) GetDomainNodes() []*types.NodeDiscoveryMessage {
 	return pub.domainNodes.GetAllNodes()
 }
 
+// GetDomainNodesByGroup returns the discovered domain nodes in the group or one of its sub-groups
+func (pub *Publisher) GetDomainNodesByGroup(group string) []*types.NodeDiscoveryMessage {
+	return pub.domainNodes.GetNodesByGroup(group)
+}
+
 // GetDomainOutput returns a discovered domain output by its address
 func (pub *Publisher) GetDomainOutput(address string) *types.OutputDiscoveryMessage {
 	return pub.domainOutputs.GetOutputByAddress(address)
 }
 
+// GetDomainOutputForecast returns the merged forecast of a discovered domain output within a time range
+// Later forecasts supersede the values of earlier forecasts within their horizon. Use Subscribe to
+// select the domain publishers.
+//  outputAddress is the address of the output, with or without message type
+//  from and to are the time range, inclusive. Use a zero time for an open range.
+// Returns the values ordered by time, or an empty list if no forecast was received for the output.
+func (pub *Publisher) GetDomainOutputForecast(outputAddress string, from time.Time, to time.Time) []types.OutputValue {
+	return pub.domainForecasts.GetForecast(outputAddress, from, to)
+}
+
+// GetDomainOutputHistory returns the history value of a discovered domain output
+//  outputAddress is the address of the output, with or without message type
+// Returns nil if no history was received for the output.
+func (pub *Publisher) GetDomainOutputHistory(outputAddress string) *types.OutputHistoryMessage {
+	historyAddr := lib.MakeBaseAddress(outputAddress) + "/" + types.MessageTypeHistory
+	history, _ := pub.domainOutputValues.GetHistory(historyAddr)
+	return history
+}
+
+// GetDomainOutputLatest returns the latest value of a discovered domain output
+//  outputAddress is the address of the output, with or without message type
+// Returns nil if no latest value was received for the output.
+func (pub *Publisher) GetDomainOutputLatest(outputAddress string) *types.OutputLatestMessage {
+	latestAddr := lib.MakeBaseAddress(outputAddress) + "/" + types.MessageTypeLatest
+	latest, _ := pub.domainOutputValues.GetLatest(latestAddr)
+	return latest
+}
+
 // GetDomainOutputs returns all discovered domain outputs
 func (pub *Publisher) GetDomainOutputs() []*types.OutputDiscoveryMessage {
 	return pub.domainOutputs.GetAllOutputs()
@@ -126,6 +215,13 @@ func (pub *Publisher) GetDomainPublishers() []*types.PublisherIdentityMessage {
 	return pub.domainIdentities.GetAllPublishers()
 }
 
+// GetDomainPublisherStatus returns the last received status of a discovered domain publisher
+//  address is the publisher address domain/publisherID, with or without message type
+// Returns nil if no status was received for the publisher.
+func (pub *Publisher) GetDomainPublisherStatus(address string) *types.PublisherStatusMessage {
+	return pub.domainStatus.GetStatus(address)
+}
+
 // GetInputByNodeHWID Get a registered input by its node HWID
 func (pub *Publisher) GetInputByNodeHWID(nodeHWID string, inputType types.InputType, instance string) *types.InputDiscoveryMessage {
 	return pub.registeredInputs.GetInputByNodeHWID(nodeHWID, inputType, instance)
@@ -141,7 +237,13 @@ func (pub *Publisher) GetInputByID(inputID string) *types.InputDiscoveryMessage
 	return pub.registeredInputs.GetInputByID(inputID)
 }
 
-// GetInputs returns a list of all registered inputs
+// GetInputQueueStats returns the nr of handled and dropped set commands of an input with a queue
+func (pub *Publisher) GetInputQueueStats(inputID string) inputs.InputQueueStats {
+	return pub.registeredInputs.GetInputQueueStats(inputID)
+}
+
+// GetInputs returns copies of all registered inputs
+// Use ModifyInput to change a registered input.
 func (pub *Publisher) GetInputs() []*types.InputDiscoveryMessage {
 	return pub.registeredInputs.GetAllInputs()
 }
@@ -208,11 +310,29 @@ func (pub *Publisher) GetNodeConfigString(
 	return pub.registeredNodes.GetNodeConfigString(nodeHWID, attrName, defaultValue)
 }
 
+// GetNodeHWID returns the hardware ID of the node that is published with a node ID, including nodes that
+// are not registered. This returns "" if the node ID belongs to a hardware ID that is mapped to another node ID.
+func (pub *Publisher) GetNodeHWID(nodeID string) string {
+	return pub.registeredNodes.NodeIDMappings().GetHWID(nodeID)
+}
+
+// GetNodeID returns the node ID that a hardware ID is published with, including nodes that are not registered
+// This returns the hardware ID if it isn't mapped to another node ID.
+func (pub *Publisher) GetNodeID(nodeHWID string) string {
+	return pub.registeredNodes.NodeIDMappings().GetNodeID(nodeHWID)
+}
+
 // GetNodes returns a list of all registered nodes
 func (pub *Publisher) GetNodes() []*types.NodeDiscoveryMessage {
 	return pub.registeredNodes.GetAllNodes()
 }
 
+// GetNodesByGroup returns the registered nodes in the group or one of its sub-groups
+//  group is the group path, eg building1/floor2
+func (pub *Publisher) GetNodesByGroup(group string) []*types.NodeDiscoveryMessage {
+	return pub.registeredNodes.GetNodesByGroup(group)
+}
+
 // GetNodeStatus returns a status attribute of a registered node
 func (pub *Publisher) GetNodeStatus(nodeHWID string, attrName types.NodeStatus) (value string, exists bool) {
 	node := pub.registeredNodes.GetNodeByHWID(nodeHWID)
@@ -233,7 +353,8 @@ func (pub *Publisher) GetOutputByID(outputID string) *types.OutputDiscoveryMessa
 	return pub.registeredOutputs.GetOutputByID(outputID)
 }
 
-// GetOutputs returns a list of all registered outputs
+// GetOutputs returns copies of all registered outputs
+// Use ModifyOutput or UpdateOutput to change a registered output.
 func (pub *Publisher) GetOutputs() []*types.OutputDiscoveryMessage {
 	return pub.registeredOutputs.GetAllOutputs()
 }
@@ -248,18 +369,50 @@ func (pub *Publisher) GetOutputValueByID(outputID string) *types.OutputValue {
 	return pub.registeredOutputValues.GetOutputValueByID(outputID)
 }
 
+// GetOutputValuesSnapshot returns a consistent copy of the latest values of all registered outputs by output ID
+func (pub *Publisher) GetOutputValuesSnapshot() map[string]types.OutputValue {
+	return pub.registeredOutputValues.Snapshot()
+}
+
 // GetPublisherKey returns the public key of the publisher contained in the given address
 // The address must at least contain a domain and publisherId
 func (pub *Publisher) GetPublisherKey(address string) *ecdsa.PublicKey {
 	return pub.domainIdentities.GetPublisherKey(address)
 }
 
+// GetUpdatedNodesSince returns the nodes of this publisher that are updated after the given cursor
+// Unlike the publisher heartbeat this does not clear the updates. Use 0 for the first call.
+func (pub *Publisher) GetUpdatedNodesSince(cursor uint64) (
+	updateList []*types.NodeDiscoveryMessage, newCursor uint64) {
+	return pub.registeredNodes.GetUpdatedNodesSince(cursor)
+}
+
+// GetUpdatedOutputValuesSince returns the IDs of outputs whose values are updated after the given cursor
+// Unlike the publisher heartbeat this does not clear the updates. Use 0 for the first call.
+func (pub *Publisher) GetUpdatedOutputValuesSince(cursor uint64) (idList []string, newCursor uint64) {
+	return pub.registeredOutputValues.GetUpdatedOutputValuesSince(cursor)
+}
+
 // MakeNodeDiscoveryAddress makes the node discovery address using the publisher domain and publisherID
 func (pub *Publisher) MakeNodeDiscoveryAddress(nodeID string) string {
 	addr := nodes.MakeNodeDiscoveryAddress(pub.Domain(), pub.PublisherID(), nodeID)
 	return addr
 }
 
+// ModifyInput applies a modification to a copy of a registered input and replaces the input with it
+// The modification must not use the publisher. Returns nil if the input doesn't exist.
+func (pub *Publisher) ModifyInput(
+	inputID string, modify func(input *types.InputDiscoveryMessage)) *types.InputDiscoveryMessage {
+	return pub.registeredInputs.ModifyInput(inputID, modify)
+}
+
+// ModifyOutput applies a modification to a copy of a registered output and replaces the output with it
+// The modification must not use the publisher. Returns nil if the output doesn't exist.
+func (pub *Publisher) ModifyOutput(
+	outputID string, modify func(output *types.OutputDiscoveryMessage)) *types.OutputDiscoveryMessage {
+	return pub.registeredOutputs.ModifyOutput(outputID, modify)
+}
+
 // PublisherID returns the publisher's ID
 func (pub *Publisher) PublisherID() string {
 	ident, _ := pub.registeredIdentity.GetFullIdentity()
@@ -279,6 +432,20 @@ func (pub *Publisher) PublishNodeConfigure(domainNodeAddr string, attr types.Nod
 	return true
 }
 
+// PublishNodeConfigureValidate publishes a validate-only $configure command to a domain node
+// The node's publisher responds on the node's $configureResult address with the changes that would be made.
+// Returns true if successful, false if the domain node publisher cannot be found or has no public key
+// and the message is not sent.
+func (pub *Publisher) PublishNodeConfigureValidate(domainNodeAddr string, attr types.NodeAttrMap) bool {
+	destPubKey := pub.GetPublisherKey(domainNodeAddr)
+	if destPubKey == nil {
+		logrus.Warnf("PublishNodeConfigureValidate: no public key found to encrypt command for node %s. Message not sent.", domainNodeAddr)
+		return false
+	}
+	nodes.PublishNodeConfigureValidate(domainNodeAddr, attr, pub.Address(), pub.messageSigner, destPubKey)
+	return true
+}
+
 // // PublishNodeAlias publishes a command to set a node's alias
 // // The node's publisher must have been discovered
 // func (pub *Publisher) PublishNodeAlias(nodeAddr string, alias string) {
@@ -289,12 +456,25 @@ func (pub *Publisher) PublishNodeConfigure(domainNodeAddr string, attr types.Nod
 // PublishRaw immediately publishes the given value of a node, output type and instance on the
 // $raw output address. The content can be signed but is not encrypted.
 // This is intended for publishing large values that should not be stored, for example images
+// If $raw is one of the interestTypes then the value is only published while consumers are interested.
+// With the rawSignatures configuration the detached signature of the value is published on $rawsig.
 func (pub *Publisher) PublishRaw(output *types.OutputDiscoveryMessage, sign bool, value string) {
+	node := pub.registeredNodes.GetNodeByHWID(output.NodeHWID)
+	if node != nil && !pub.isPublicationWanted(node.NodeID, types.MessageTypeRaw) {
+		return
+	}
 	outputs.PublishOutputRaw(output, value, pub.messageSigner)
+	if pub.config.RawSignatures {
+		outputs.PublishOutputRawSignature(output, value, pub.messageSigner)
+	}
 }
 
 // PublishOutputEvent publishes all outputs of the node in a single event
+// If $event is one of the interestTypes then the event is only published while consumers are interested.
 func (pub *Publisher) PublishOutputEvent(node *types.NodeDiscoveryMessage) error {
+	if !pub.isPublicationWanted(node.NodeID, types.MessageTypeEvent) {
+		return nil
+	}
 	return PublishOutputEvent(node, pub.registeredOutputs, pub.registeredOutputValues, pub.messageSigner)
 }
 
@@ -325,6 +505,103 @@ func (pub *Publisher) PublishSetNodeID(nodeAddr string, newNodeID string) error
 	return err
 }
 
+// ReadNodeConfigBool reads a node configuration value as a boolean
+// This returns found false if no value is set and no configuration default exists. See also nodes.ErrNodeNotFound,
+// nodes.ErrConfigNotFound and nodes.ErrInvalidConfigValue.
+func (pub *Publisher) ReadNodeConfigBool(
+	nodeHWID string, attrName types.NodeAttr) (value bool, found bool, err error) {
+	return pub.registeredNodes.ReadNodeConfigBool(nodeHWID, attrName)
+}
+
+// ReadNodeConfigFloat reads a node configuration value as a float number
+// This returns found false if no value is set and no configuration default exists.
+func (pub *Publisher) ReadNodeConfigFloat(
+	nodeHWID string, attrName types.NodeAttr) (value float32, found bool, err error) {
+	return pub.registeredNodes.ReadNodeConfigFloat(nodeHWID, attrName)
+}
+
+// ReadNodeConfigInt reads a node configuration value as an integer
+// This returns found false if no value is set and no configuration default exists.
+func (pub *Publisher) ReadNodeConfigInt(
+	nodeHWID string, attrName types.NodeAttr) (value int, found bool, err error) {
+	return pub.registeredNodes.ReadNodeConfigInt(nodeHWID, attrName)
+}
+
+// ReadNodeConfigString reads a node configuration value as a string
+// This returns found false if no value is set and no configuration default exists.
+func (pub *Publisher) ReadNodeConfigString(
+	nodeHWID string, attrName types.NodeAttr) (value string, found bool, err error) {
+	return pub.registeredNodes.ReadNodeConfigString(nodeHWID, attrName)
+}
+
+// RegisterCustomInputType registers a vendor specific input type under the given namespace, eg: x-acme:valve-position
+// The optional info is published with the discovery of inputs of this type. Returns the input type to create inputs with.
+func (pub *Publisher) RegisterCustomInputType(
+	namespace string, name string, info *types.CustomTypeInfo) (types.InputType, error) {
+	return pub.registeredInputs.RegisterCustomType(namespace, name, info)
+}
+
+// RegisterCustomOutputType registers a vendor specific output type under the given namespace, eg: x-acme:valve-position
+// The optional info is published with the discovery of outputs of this type. Returns the output type to create outputs with.
+func (pub *Publisher) RegisterCustomOutputType(
+	namespace string, name string, info *types.CustomTypeInfo) (types.OutputType, error) {
+	return pub.registeredOutputs.RegisterCustomType(namespace, name, info)
+}
+
+// RequestDomainOutputReplay requests the publisher of a domain output to replay the values that were
+// missed, eg after a gap is detected. The publisher must have a replay budget. The replayed values are
+// published to this publisher and received with the handler of SetOnDomainOutputReplay while running.
+//  outputAddress is the address of the output, eg domain/publisherId/nodeId/type/instance/$latest
+//  sinceSequence replays the values with a higher sequence nr. Use 0 to ignore.
+//  since replays the values after this time. Use the zero time to ignore.
+func (pub *Publisher) RequestDomainOutputReplay(outputAddress string, sinceSequence uint64, since time.Time) error {
+	return outputs.PublishReplayRequest(outputAddress, sinceSequence, since, pub.Address(), pub.messageSigner)
+}
+
+// SetInputQueue sets how set commands are queued while the handler of an input is busy
+//  mode is latest-wins, FIFO or reject-while-busy. Use inputs.InputQueueModeDirect to invoke the handler on receipt.
+//  maxDepth is the max nr of pending commands in FIFO mode. Use 0 for the default.
+func (pub *Publisher) SetInputQueue(inputID string, mode inputs.InputQueueMode, maxDepth int) {
+	pub.registeredInputs.SetInputQueue(inputID, mode, maxDepth)
+}
+
+// SetNodeGroup sets the group path and tags of a registered node, eg building1/floor2/room3
+// The group and tags are included in the node discovery.
+func (pub *Publisher) SetNodeGroup(nodeHWID string, group string, tags ...string) (changed bool) {
+	return pub.registeredNodes.SetNodeGroup(nodeHWID, group, tags...)
+}
+
+// SetOnDomainOutputValue sets the handler that is invoked when the latest value of a subscribed domain
+// output is received. Use Subscribe to select the domain publishers. Use nil to remove the handler.
+func (pub *Publisher) SetOnDomainOutputValue(handler outputs.OutputValueHandler) {
+	pub.domainOutputValues.SetOnLatest(handler)
+}
+
+// SetOnDomainOutputGap sets the handler that is invoked when values of a subscribed domain output are
+// missed, detected with their sequence nr. Use GetDomainOutputHistory or RequestDomainOutputReplay to fill the gap.
+// Use nil to remove the handler.
+func (pub *Publisher) SetOnDomainOutputGap(handler outputs.OutputGapHandler) {
+	pub.domainOutputValues.SetOnGap(handler)
+}
+
+// SetOnDomainOutputReplay sets the handler that is invoked when the values of a domain output are
+// replayed, after requesting them with RequestDomainOutputReplay. Use nil to remove the handler.
+func (pub *Publisher) SetOnDomainOutputReplay(handler outputs.OutputReplayHandler) {
+	pub.domainOutputValues.SetOnReplay(handler)
+}
+
+// SetOnDomainOutputForecast sets the handler that is invoked when a forecast of a subscribed domain
+// output is received, with the merged forecast of the output. Use nil to remove the handler.
+func (pub *Publisher) SetOnDomainOutputForecast(handler outputs.ForecastHandler) {
+	pub.domainForecasts.SetOnForecast(handler)
+}
+
+// SetOnDomainPublisherStatus sets the handler that is invoked when the status of a subscribed domain
+// publisher is received, including the 'lost' status when it unexpectedly disconnects. Use nil to remove the handler.
+func (pub *Publisher) SetOnDomainPublisherStatus(handler identities.PublisherStatusHandler) {
+	pub.domainStatus.SetOnStatus(handler)
+}
+
 // SetSigningOnOff turns signing of publications on or off.
 //  The default is on (true)
 func (pub *Publisher) SetSigningOnOff(onOff bool) {
@@ -344,6 +621,9 @@ func (pub *Publisher) Subscribe(domain string, publisherID string) {
 	pub.domainNodes.Subscribe(domain, publisherID)
 	pub.domainInputs.Subscribe(domain, publisherID)
 	pub.domainOutputs.Subscribe(domain, publisherID)
+	pub.domainOutputValues.Subscribe(domain, publisherID)
+	pub.domainForecasts.Subscribe(domain, publisherID)
+	pub.domainStatus.Subscribe(domain, publisherID)
 }
 
 // Unsubscribe from receiving nodes, inputs and outputs from the selected domain and/or publisher
@@ -359,6 +639,9 @@ func (pub *Publisher) Unsubscribe(domain string, publisherID string) {
 	pub.domainNodes.Unsubscribe(domain, publisherID)
 	pub.domainInputs.Unsubscribe(domain, publisherID)
 	pub.domainOutputs.Unsubscribe(domain, publisherID)
+	pub.domainOutputValues.Unsubscribe(domain, publisherID)
+	pub.domainForecasts.Unsubscribe(domain, publisherID)
+	pub.domainStatus.Unsubscribe(domain, publisherID)
 }
 
 // UpdateNodeErrorStatus sets a registered node RunState to the given status with a lasterror message
@@ -368,6 +651,12 @@ func (pub *Publisher) UpdateNodeErrorStatus(nodeHWID string, status string, last
 	pub.registeredNodes.UpdateErrorStatus(nodeHWID, status, lastError)
 }
 
+// UpdateAttrForAll updates the attributes of all selected registered nodes in a single operation
+// Use nil as selector to update all nodes. This returns the nodes that have changed.
+func (pub *Publisher) UpdateAttrForAll(selector nodes.NodeSelector, attrParams types.NodeAttrMap) []*types.NodeDiscoveryMessage {
+	return pub.registeredNodes.UpdateAttrForAll(selector, attrParams)
+}
+
 // UpdateNodeAttr updates one or more attributes of a registered node
 // This only updates the node if the status or lastError message changes
 func (pub *Publisher) UpdateNodeAttr(nodeHWID string, attrParams types.NodeAttrMap) (changed bool) {
@@ -406,6 +695,13 @@ func (pub *Publisher) UpdateNodeStatus(nodeHWID string, status map[types.NodeSta
 // 	pub.registeredNodes.UpdateNode(node)
 // }
 
+// UpdateStatusForAll updates the status of all selected registered nodes in a single operation
+// Use nil as selector to update all nodes. This returns the nodes that have changed.
+func (pub *Publisher) UpdateStatusForAll(
+	selector nodes.NodeSelector, status map[types.NodeStatus]string) []*types.NodeDiscoveryMessage {
+	return pub.registeredNodes.UpdateStatusForAll(selector, status)
+}
+
 // UpdateOutput replaces a registered output with a new instance. Intended to update an
 // output attribute. If the output does not exist, this is ignored.
 func (pub *Publisher) UpdateOutput(output *types.OutputDiscoveryMessage) {
@@ -419,6 +715,12 @@ func (pub *Publisher) UpdateOutputForecast(outputID string, forecast outputs.Out
 
 // UpdateOutputValue adds the registered node's output value to the front of the value history
 func (pub *Publisher) UpdateOutputValue(nodeHWID string, outputType types.OutputType, instance string, newValue string) bool {
-	outputID := outputs.MakeOutputID(nodeHWID, outputType, instance)
-	return pub.registeredOutputValues.UpdateOutputValue(outputID, newValue)
+	return pub.updateOutputValue(nodeHWID, outputType, instance, newValue, "")
+}
+
+// UpdateOutputValueWithQuality adds the registered node's output value with its quality to the front of the value history
+// Intended for adapters to mark values when the sensor reports an error or values are interpolated.
+func (pub *Publisher) UpdateOutputValueWithQuality(nodeHWID string, outputType types.OutputType, instance string,
+	newValue string, quality types.ValueQuality) bool {
+	return pub.updateOutputValue(nodeHWID, outputType, instance, newValue, quality)
 }
@@ -0,0 +1,30 @@
+// Package types with the canonical representation of composite input and output values
+package types
+
+// ColorRGB is the value of an rgb input or output. Each component is 0-255.
+type ColorRGB struct {
+	R uint8 `json:"r"` // red
+	G uint8 `json:"g"` // green
+	B uint8 `json:"b"` // blue
+}
+
+// ColorHSV is the value of an hsv input or output
+type ColorHSV struct {
+	H float64 `json:"h"` // hue in degrees, 0-360
+	S float64 `json:"s"` // saturation, 0-1
+	V float64 `json:"v"` // value (brightness), 0-1
+}
+
+// Location is the value of a GPS location input or output
+type Location struct {
+	Latitude  float64 `json:"lat"`           // latitude in degrees, -90 - 90
+	Longitude float64 `json:"lon"`           // longitude in degrees, -180 - 180
+	Altitude  float64 `json:"alt,omitempty"` // optional altitude in meters
+}
+
+// Vector is the value of a 3D vector input or output
+type Vector struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
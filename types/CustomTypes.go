@@ -0,0 +1,44 @@
+// Package types with definitions of vendor specific output and input types
+package types
+
+import "strings"
+
+// CustomTypePrefix is the prefix of vendor specific output and input types, eg: x-acme:valve-position
+const CustomTypePrefix = "x-"
+
+// CustomTypeSeparator separates the namespace and the name of a vendor specific type
+const CustomTypeSeparator = ":"
+
+// customTypeReserved contains characters that cannot be used in a namespace or type name
+// as they are used in the publication address or as subscription wildcards
+const customTypeReserved = "/:$#+ "
+
+// CustomTypeInfo with optional metadata describing a vendor specific type. It is included in the
+// discovery message of outputs and inputs of that type, to help consumers interpret the values.
+type CustomTypeInfo struct {
+	Namespace   string   `json:"namespace"`             // vendor namespace, eg: acme
+	Name        string   `json:"name"`                  // type name within the namespace, eg: valve-position
+	Description string   `json:"description,omitempty"` // human readable description of the type
+	DataType    DataType `json:"dataType,omitempty"`    // data type of values of this type
+	Unit        Unit     `json:"unit,omitempty"`        // unit of values of this type
+	URL         string   `json:"url,omitempty"`         // link to the vendor's documentation of the type
+}
+
+// MakeCustomType returns the type name of a vendor specific type: x-{namespace}:{name}
+func MakeCustomType(namespace string, name string) string {
+	return CustomTypePrefix + namespace + CustomTypeSeparator + name
+}
+
+// SplitCustomType returns the namespace and name of a vendor specific type
+// isCustom is false if the type is not a valid vendor specific type
+func SplitCustomType(typeName string) (namespace string, name string, isCustom bool) {
+	if !strings.HasPrefix(typeName, CustomTypePrefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(typeName, CustomTypePrefix), CustomTypeSeparator, 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" ||
+		strings.ContainsAny(parts[0], customTypeReserved) || strings.ContainsAny(parts[1], customTypeReserved) {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
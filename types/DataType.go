@@ -21,8 +21,31 @@ const (
 	// a secret string that is not published
 	DataTypeSecret DataType = "secret"
 	DataTypeString DataType = "string"
-	// 3D vector (x, y, z) or (lat, lon, 0)
+	// 3D vector as json object {"x":x,"y":y,"z":z}, see Vector
 	DataTypeVector DataType = "vector"
 	// value is a json object
 	DataTypeJSON DataType = "json"
+	// RGB color as json object {"r":r,"g":g,"b":b}, see ColorRGB
+	DataTypeColorRGB DataType = "rgb"
+	// HSV color as json object {"h":h,"s":s,"v":v}, see ColorHSV
+	DataTypeColorHSV DataType = "hsv"
+	// GPS location as json object {"lat":lat,"lon":lon[,"alt":alt]}, see Location
+	DataTypeLocation DataType = "location"
 )
+
+// EnumValues with the possible values of an enum input or output. Define an enum once and use it
+// with the inputs and outputs that share the values.
+type EnumValues []string
+
+// Contains returns true if the value is one of the enum values
+func (enum EnumValues) Contains(value string) bool {
+	for _, enumValue := range enum {
+		if enumValue == value {
+			return true
+		}
+	}
+	return false
+}
+
+// EnumThermostatMode with the operating modes of a thermostat
+var EnumThermostatMode = EnumValues{"off", "heat", "cool", "auto"}
@@ -29,7 +29,9 @@ const (
 	InputTypeColorTemperature InputType = "colortemperature" // set light color temperature in kelvin
 	InputTypeCommand          InputType = "command"          // issue input command
 	InputTypeDimmer           InputType = "dimmer"           // control light dimmer 0-100%
+	InputTypeFirmware         InputType = "firmware"         // update firmware, value is a FirmwareDescriptor in JSON
 	InputTypeHumidity         InputType = "humidity"         // humidity setting control 0-100%
+	InputTypeIdentify         InputType = "identify"         // identify/locate the device, eg blink a LED or beep, for nr of seconds
 	InputTypeImage            InputType = "image"            // image input
 	InputTypeLevel            InputType = "level"            // multilevel input control
 	InputTypeLock             InputType = "lock"             // lock "open" or "closed"
@@ -45,18 +47,41 @@ const (
 	InputTypeWaterLevel       InputType = "waterlevel"       // set input control for water level
 )
 
+// InputSourceKind identifies how an input receives its value
+type InputSourceKind string
+
+// Input source kinds
+const (
+	InputSourceExec   InputSourceKind = "exec"   // set commands run a command
+	InputSourceFile   InputSourceKind = "file"   // file changes
+	InputSourceHTTP   InputSourceKind = "http"   // polling of a http service
+	InputSourceOutput InputSourceKind = "output" // values of a subscribed output
+	InputSourceSet    InputSourceKind = "set"    // set commands from the message bus
+)
+
+// InputSourceInfo with the optional description of where an input gets its value and where it is applied
+// Intended for debugging and for user interfaces. Inputs don't act on it.
+type InputSourceInfo struct {
+	Description string          `json:"description,omitempty"` // human readable description of the source
+	Kind        InputSourceKind `json:"kind"`                  // how the input receives its value
+	Source      string          `json:"source,omitempty"`      // URL, file path or topic the value is received from
+	Target      string          `json:"target,omitempty"`      // where the value is applied, eg device register or command
+}
+
 // InputDiscoveryMessage with node input description
 type InputDiscoveryMessage struct {
-	Address    string        `json:"address"`              // Discovery address of the input
-	Attr       NodeAttrMap   `json:"attr"`                 // Attributes describing this input
-	Config     ConfigAttrMap `json:"config,omitempty"`     // Optional configuration of input
-	DataType   DataType      `json:"dataType,omitempty"`   // input value data type
-	EnumValues []string      `json:"enumValues,omitempty"` // enum valid input values for enum datatypes
-	Max        float32       `json:"max,omitempty"`        // optional max value of input for numeric data types
-	Min        float32       `json:"min,omitempty"`        // optional min value of input for numeric data types
-	Source     string        `json:"source,omitempty"`     // the input source URL, empty for set commands
-	Timestamp  string        `json:"timestamp"`            // Time the record is last updated
-	Unit       Unit          `json:"unit,omitempty"`       // unit of value
+	Address    string           `json:"address"`              // Discovery address of the input
+	Attr       NodeAttrMap      `json:"attr"`                 // Attributes describing this input
+	Config     ConfigAttrMap    `json:"config,omitempty"`     // Optional configuration of input
+	DataType   DataType         `json:"dataType,omitempty"`   // input value data type
+	EnumValues EnumValues       `json:"enumValues,omitempty"` // enum valid input values for enum datatypes
+	Max        float32          `json:"max,omitempty"`        // optional max value of input for numeric data types
+	Min        float32          `json:"min,omitempty"`        // optional min value of input for numeric data types
+	Source     string           `json:"source,omitempty"`     // the input source URL, empty for set commands
+	SourceInfo *InputSourceInfo `json:"sourceInfo,omitempty"` // optional description of the source and target
+	Timestamp  string           `json:"timestamp"`            // Time the record is last updated
+	TypeInfo   *CustomTypeInfo  `json:"typeInfo,omitempty"`   // metadata of vendor specific types
+	Unit       Unit             `json:"unit,omitempty"`       // unit of value
 	// For internal use. Filled when registering inputs
 	InputID     string    `json:"-"` // ID of input using NodeHWID
 	NodeHWID    string    `json:"-"` // Hardware address of the node the input belongs to
@@ -73,6 +98,15 @@ type SetInputMessage struct {
 	Value     string `json:"value"`  // this can also be a string containing a list, eg "[ a, b, c ]""
 }
 
+// FirmwareDescriptor describes a firmware image to download and install, set on the firmware input
+// The descriptor is signed as part of the set input command.
+type FirmwareDescriptor struct {
+	Group   string `json:"group,omitempty"` // staged rollout to nodes in this group path. Default is all nodes
+	SHA256  string `json:"sha256"`          // hex encoded SHA-256 hash of the image
+	URL     string `json:"url"`             // http(s) URL to download the image from
+	Version string `json:"version"`         // version of the firmware
+}
+
 // UpgradeFirmwareMessage with node firmware
 type UpgradeFirmwareMessage struct {
 	Address   string `json:"address"`   // message address
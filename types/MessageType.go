@@ -6,23 +6,34 @@ type MessageType string
 
 // Available message types from the standard
 const (
-	MessageTypeConfigure       = "$configure"   // node configuration, payload is NodeConfigureMessage
-	MessageTypeCreate          = "$create"      // create node command
-	MessageTypeDelete          = "$delete"      // delete node command
-	MessageTypeEvent           = "$event"       // node outputs event, payload is EventMessage
-	MessageTypeForecast        = "$forecast"    // output forecast, payload is HistoryMessage
-	MessageTypeHistory         = "$history"     // output history, payload is HistoryMessage
-	MessageTypeIdentity        = "$identity"    // publisher identity
-	MessageTypeInputDiscovery  = "$input"       // input discovery, payload is InOutput object
-	MessageTypeLatest          = "$latest"      // latest output, payload is latest message
-	MessageTypeNodeDiscovery   = "$node"        // node discovery, payload is Node object
-	MessageTypeOutputDiscovery = "$output"      // output discovery, payload output definition
-	MessageTypeStatus          = "$status"      // publisher runtime status, connected, disconnected, lost
-	MessageTypeSetIdentity     = "$setIdentity" // renew publisher identity keys
-	MessageTypeSetInput        = "$setInput"    // command to set input value, payload is input value
-	MessageTypeSetNodeID       = "$setNodeId"   // set node ID, payload is SetNodeIDMessage
-	MessageTypeUpgrade         = "$upgrade"     // perform firmware upgrade, payload is UpgradeMessage
-	MessageTypeRaw             = "$raw"         // raw output value
+	MessageTypeConfigure       = "$configure"       // node configuration, payload is NodeConfigureMessage
+	MessageTypeConfigureResult = "$configureResult" // result of validating a node configuration, payload is NodeConfigureResultMessage
+	MessageTypeControl         = "$control"         // publisher control command, payload is PublisherControlMessage
+	MessageTypeCreate          = "$create"          // create node command
+	MessageTypeDelete          = "$delete"          // delete node command
+	MessageTypeDomainLog       = "$domainlog"       // security relevant domain event, payload is DomainLogMessage
+	MessageTypeEvent           = "$event"           // node outputs event, payload is EventMessage
+	MessageTypeForecast        = "$forecast"        // output forecast, payload is HistoryMessage
+	MessageTypeGroup           = "$group"           // values of a group of outputs that are published together, payload is OutputGroupMessage
+	MessageTypeHistory         = "$history"         // output history, payload is HistoryMessage
+	MessageTypeIdentity        = "$identity"        // publisher identity
+	MessageTypeInterest        = "$interest"        // consumer interest in publications, payload is InterestMessage
+	MessageTypeInputDiscovery  = "$input"           // input discovery, payload is InOutput object
+	MessageTypeLatest          = "$latest"          // latest output, payload is latest message
+	MessageTypeNodeDiscovery   = "$node"            // node discovery, payload is Node object
+	MessageTypeNodeIDMapping   = "$nodeIdMapping"   // change of the node ID of a hardware ID, payload is NodeIDMappingMessage
+	MessageTypeOutputDiscovery = "$output"          // output discovery, payload output definition
+	MessageTypeStatus          = "$status"          // publisher runtime status, connected, disconnected, lost
+	MessageTypeSetIdentity     = "$setIdentity"     // renew publisher identity keys
+	MessageTypeSetInput        = "$setInput"        // command to set input value, payload is input value
+	MessageTypeSetNodeID       = "$setNodeId"       // set node ID, payload is SetNodeIDMessage
+	MessageTypeSetDesired      = "$setDesired"      // set desired properties of a node twin, payload is SetDesiredMessage
+	MessageTypeTwin            = "$twin"            // desired and reported properties of a node, payload is NodeTwinMessage
+	MessageTypeUpgrade         = "$upgrade"         // perform firmware upgrade, payload is UpgradeMessage
+	MessageTypeRaw             = "$raw"             // raw output value
+	MessageTypeReplay          = "$replay"          // request to republish missed output values, payload is ReplayMessage
+	MessageTypeReplayResult    = "$replayResult"    // republished output values, payload is OutputReplayResultMessage
+	MessageTypeRawSignature    = "$rawsig"          // detached signature of the raw output value, payload is OutputRawSignatureMessage
 	// LocaldomainID for local-only domains (eg, no sharing outside this domain)
 	LocalDomainID = "local" // local area domain
 	TestDomainID  = "test"  // Domain to use in testing
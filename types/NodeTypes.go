@@ -21,8 +21,11 @@ const (
 	NodeAttrDisabled        NodeAttr = "disabled"        // device or sensor is disabled
 	NodeAttrEvent           NodeAttr = "event"           // Enable/disable event publishing
 	NodeAttrFilename        NodeAttr = "filename"        // filename to write images or other values to
+	NodeAttrGain            NodeAttr = "gain"            // output calibration multiplier, see also MakeCalibrationAttr
 	NodeAttrGatewayAddress  NodeAttr = "gatewayAddress"  // the node gateway address
+	NodeAttrGroup           NodeAttr = "group"           // location or group path, eg building/floor/room
 	NodeAttrHostname        NodeAttr = "hostname"        // network device hostname
+	NodeAttrIdentify        NodeAttr = "identify"        // bool, node can be located with the identify input, eg to show an identify button
 	NodeAttrIotcVersion     NodeAttr = "iotcVersion"     // IoTDomain version
 	NodeAttrLatLon          NodeAttr = "latlon"          // latitude, longitude of the device for display on a map r/w
 	NodeAttrLocalIP         NodeAttr = "localIP"         // for IP nodes
@@ -35,6 +38,7 @@ const (
 	NodeAttrModel           NodeAttr = "model"           // device model
 	NodeAttrName            NodeAttr = "name"            // Name of device or service
 	NodeAttrNetmask         NodeAttr = "netmask"         // IP network mask
+	NodeAttrOffset          NodeAttr = "offset"          // output calibration offset, added after applying the gain
 	NodeAttrPassword        NodeAttr = "password"        // password to connect. Value is not published.
 	NodeAttrPublishBatch    NodeAttr = "publishBatch"    // int with nr of events per batch, 0 to disable
 	NodeAttrPublishEvent    NodeAttr = "publishEvent"    // enable publishing as event
@@ -46,8 +50,12 @@ const (
 	NodeAttrPowerSource     NodeAttr = "powerSource"     // battery, usb, mains
 	NodeAttrProduct         NodeAttr = "product"         // device product or model name
 	NodeAttrPublicKey       NodeAttr = "publicKey"       // public key for encrypting sensitive configuration settings
+	NodeAttrRate            NodeAttr = "rate"            // output rate of change unit: off, second, minute, hour. See MakeCalibrationAttr
+	NodeAttrRawCodec        NodeAttr = "rawCodec"        // output $raw value encoding: plain, json, csv or a registered codec. See MakeCalibrationAttr
 	NodeAttrSoftwareVersion NodeAttr = "softwareVersion" // version of the software running the node
+	NodeAttrStatistics      NodeAttr = "statistics"      // output statistics period: off, hourly, daily. See MakeCalibrationAttr
 	NodeAttrSubnet          NodeAttr = "subnet"          // IP subnets configuration
+	NodeAttrTags            NodeAttr = "tags"            // comma separated free-form tags, eg lighting,outdoor
 	NodeAttrType            NodeAttr = "type"            // Node type
 	NodeAttrURL             NodeAttr = "url"             // node URL
 )
@@ -62,12 +70,16 @@ type NodeStatusMap map[NodeStatus]string
 // These indicate how the node is performing and are updated with each publication, typically once a day
 const (
 	NodeStatusErrorCount    NodeStatus = "errorCount"    // nr of errors reported on this device
+	NodeStatusFirmware      NodeStatus = "firmware"      // state of the firmware update as per below
+	NodeStatusFirmwarePct   NodeStatus = "firmwarePct"   // progress of the firmware update 0-100%
+	NodeStatusFlapping      NodeStatus = "flapping"      // "true" while the node repeatedly appears and disappears. Its runState publication is dampened
 	NodeStatusHealth        NodeStatus = "health"        // health status of the device 0-100%
 	NodeStatusLastError     NodeStatus = "lastError"     // most recent error message, or "" if no error
 	NodeStatusLastSeen      NodeStatus = "lastSeen"      // ISO time the device was last seen
 	NodeStatusLatencyMSec   NodeStatus = "latencymsec"   // duration connect to sensor in milliseconds
 	NodeStatusNeighborCount NodeStatus = "neighborCount" // mesh network nr of neighbors
 	NodeStatusNeighborIDs   NodeStatus = "neighborIDs"   // mesh network device neighbors ID list [id,id,...]
+	NodeStatusOverridden    NodeStatus = "overridden"    // comma separated IDs of the overridden inputs and outputs of the node
 	NodeStatusRxCount       NodeStatus = "rxCount"       // Nr of messages received from device
 	NodeStatusTxCount       NodeStatus = "txCount"       // Nr of messages send to device
 	NodeStatusRunState      NodeStatus = "runState"      // Node run-state as per below
@@ -80,6 +92,17 @@ const (
 	NodeRunStateReady    string = "ready"    // Node is ready for use
 	NodeRunStateSleeping string = "sleeping" // Node has gone into sleep mode, often a battery powered devie
 	NodeRunStateLost     string = "lost"     // Node is is no longer reachable
+	NodeRunStateMissing  string = "missing"  // Node was loaded from cache but not rediscovered
+
+	NodeRunStateProvisioned string = "provisioned" // Node is pre-registered and hasn't been discovered yet
+)
+
+// Values for the state of a firmware update
+const (
+	FirmwareStateDownloading string = "downloading" // the image is being downloaded and verified
+	FirmwareStateInstalling  string = "installing"  // the adapter is installing the image
+	FirmwareStateDone        string = "done"        // the image is installed
+	FirmwareStateFailed      string = "failed"      // the update failed, see lastError
 )
 
 // NodeType identifying  the purpose of the node
@@ -137,10 +160,20 @@ type ConfigAttr struct {
 
 // NodeConfigureMessage with values to update a node configuration
 type NodeConfigureMessage struct {
-	Address   string      `json:"address"` // zone/publisher/node/$configure
-	Attr      NodeAttrMap `json:"attr"`    // attributes to configure
-	Sender    string      `json:"sender"`  // sending node: zone/publisher/node
-	Timestamp string      `json:"timestamp"`
+	Address      string      `json:"address"`                // zone/publisher/node/$configure
+	Attr         NodeAttrMap `json:"attr"`                   // attributes to configure
+	Sender       string      `json:"sender"`                 // sending node: zone/publisher/node
+	Timestamp    string      `json:"timestamp"`              // time the command is sent
+	ValidateOnly bool        `json:"validateOnly,omitempty"` // only validate, respond with $configureResult without applying
+}
+
+// NodeConfigureResultMessage with the result of validating a node configuration update
+type NodeConfigureResultMessage struct {
+	Address   string              `json:"address"`          // zone/publisher/node/$configureResult
+	Changes   NodeAttrMap         `json:"changes"`          // configuration values that would change
+	Errors    map[NodeAttr]string `json:"errors,omitempty"` // rejected configuration values with the reason
+	Sender    string              `json:"sender"`           // sender of the configure command this is a result of
+	Timestamp string              `json:"timestamp"`        // time the result is created
 }
 
 // NodeDiscoveryMessage definition published in node discovery
@@ -156,6 +189,40 @@ type NodeDiscoveryMessage struct {
 	PublisherID string `json:"-"`
 }
 
+// NodeIDMappingMessage announces a change of the node ID that the hardware ID of a node is published with
+// Intended for consumers to update references that use the previous node ID.
+type NodeIDMappingMessage struct {
+	Address        string            `json:"address"`        // zone/publisher/$nodeIdMapping
+	HWID           string            `json:"hwId"`           // hardware ID of the node whose node ID changed
+	Mappings       map[string]string `json:"mappings"`       // node IDs by hardware ID of all mapped nodes of the publisher
+	NodeID         string            `json:"nodeId"`         // new node ID, the hardware ID if the mapping is removed
+	PreviousNodeID string            `json:"previousNodeId"` // node ID before the change
+	Timestamp      string            `json:"timestamp"`
+}
+
+// NodeTwinMessage with the desired and reported properties of a node, similar to the device twins of
+// cloud IoT platforms. Desired properties are set remotely with $setDesired, reported properties are
+// reported by the device. The twin converges when the reported properties match the desired properties.
+type NodeTwinMessage struct {
+	Address         string            `json:"address"`           // zone/publisher/node/$twin
+	Converged       bool              `json:"converged"`         // the reported properties match all desired properties
+	Desired         map[string]string `json:"desired"`           // desired properties
+	DesiredVersion  uint64            `json:"desiredVersion"`    // incremented on each change of the desired properties
+	Pending         []string          `json:"pending,omitempty"` // sorted names of desired properties that aren't reported yet
+	Reported        map[string]string `json:"reported"`          // properties reported by the device
+	ReportedVersion uint64            `json:"reportedVersion"`   // incremented on each change of the reported properties
+	Timestamp       string            `json:"timestamp"`         // time the twin is published
+}
+
+// SetDesiredMessage to change the desired properties of a node twin
+// Properties that are not included remain unchanged. An empty value removes the desired property.
+type SetDesiredMessage struct {
+	Address   string            `json:"address"`   // zone/publisher/node/$setDesired
+	Desired   map[string]string `json:"desired"`   // desired properties to change
+	Sender    string            `json:"sender"`    // sending node: zone/publisher/node
+	Timestamp string            `json:"timestamp"` // time the command is created
+}
+
 // SetNodeIDMessage to change a node's ID
 type SetNodeIDMessage struct {
 	Address   string `json:"address"` // zone/publisher/node/$alias - existing address
@@ -0,0 +1,91 @@
+// Package types with the registry of standard output types and their units
+package types
+
+// OutputTypeInfo describes the data type and units that are standard for an output type
+type OutputTypeInfo struct {
+	DataType    DataType // data type of the output value
+	DefaultUnit Unit     // unit to assume if none is provided
+	Units       []Unit   // valid units, nil if any unit can be used
+}
+
+// OutputTypeMap with the standard data type and units of known output types.
+// Unitless outputs only accept UnitNone.
+var OutputTypeMap = map[OutputType]OutputTypeInfo{
+	OutputTypeAcceleration:           {DataType: DataTypeNumber, DefaultUnit: "m/s2", Units: []Unit{"m/s2"}},
+	OutputTypeAirQuality:             {DataType: DataTypeNumber},
+	OutputTypeAlarm:                  {DataType: DataTypeString, Units: []Unit{UnitNone}},
+	OutputTypeAtmosphericPressure:    {DataType: DataTypeNumber, DefaultUnit: UnitMillibar, Units: []Unit{UnitMillibar, UnitMercury, UnitPSI, UnitPascal}},
+	OutputTypeBattery:                {DataType: DataTypeNumber, DefaultUnit: UnitPercent, Units: []Unit{UnitPercent, UnitVolt}},
+	OutputTypeCarbonDioxideLevel:     {DataType: DataTypeNumber, DefaultUnit: UnitPartsPerMillion, Units: []Unit{UnitPartsPerMillion}},
+	OutputTypeCarbonMonoxideDetector: {DataType: DataTypeBool, Units: []Unit{UnitNone}},
+	OutputTypeCarbonMonoxideLevel:    {DataType: DataTypeNumber, DefaultUnit: UnitPartsPerMillion, Units: []Unit{UnitPartsPerMillion}},
+	OutputTypeChannel:                {DataType: DataTypeNumber, Units: []Unit{UnitNone}},
+	OutputTypeColor:                  {DataType: DataTypeColorRGB, Units: []Unit{UnitNone}},
+	OutputTypeColorTemperature:       {DataType: DataTypeNumber, DefaultUnit: UnitKelvin, Units: []Unit{UnitKelvin}},
+	OutputTypeConnections:            {DataType: DataTypeNumber, Units: []Unit{UnitNone, UnitCount}},
+	OutputTypeCPULevel:               {DataType: DataTypeNumber, DefaultUnit: UnitPercent, Units: []Unit{UnitPercent}},
+	OutputTypeDewpoint:               {DataType: DataTypeNumber, DefaultUnit: UnitCelcius, Units: []Unit{UnitCelcius, UnitFahrenheit}},
+	OutputTypeDimmer:                 {DataType: DataTypeNumber, DefaultUnit: UnitPercent, Units: []Unit{UnitPercent}},
+	OutputTypeDoorWindowSensor:       {DataType: DataTypeBool, Units: []Unit{UnitNone}},
+	OutputTypeElectricCurrent:        {DataType: DataTypeNumber, DefaultUnit: UnitAmp, Units: []Unit{UnitAmp}},
+	OutputTypeElectricEnergy:         {DataType: DataTypeNumber, DefaultUnit: UnitKWH, Units: []Unit{UnitKWH}},
+	OutputTypeElectricPower:          {DataType: DataTypeNumber, DefaultUnit: UnitWatt, Units: []Unit{UnitWatt}},
+	OutputTypeErrors:                 {DataType: DataTypeNumber, Units: []Unit{UnitNone, UnitCount}},
+	OutputTypeHeatIndex:              {DataType: DataTypeNumber, DefaultUnit: UnitCelcius, Units: []Unit{UnitCelcius, UnitFahrenheit}},
+	OutputTypeHue:                    {DataType: DataTypeString, Units: []Unit{UnitNone}},
+	OutputTypeHumidex:                {DataType: DataTypeNumber, DefaultUnit: UnitCelcius, Units: []Unit{UnitCelcius, UnitFahrenheit}},
+	OutputTypeHumidity:               {DataType: DataTypeNumber, DefaultUnit: UnitPercent, Units: []Unit{UnitPercent}},
+	OutputTypeImage:                  {DataType: DataTypeBytes, DefaultUnit: UnitJpeg, Units: []Unit{UnitJpeg, UnitPng}},
+	OutputTypeLatency:                {DataType: DataTypeNumber, DefaultUnit: UnitSecond, Units: []Unit{UnitSecond}},
+	OutputTypeLevel:                  {DataType: DataTypeNumber, DefaultUnit: UnitPercent},
+	OutputTypeLocation:               {DataType: DataTypeLocation, Units: []Unit{UnitNone}},
+	OutputTypeLock:                   {DataType: DataTypeString, Units: []Unit{UnitNone}},
+	OutputTypeLuminance:              {DataType: DataTypeNumber, DefaultUnit: UnitLux, Units: []Unit{UnitLux, UnitCandela}},
+	OutputTypeMotion:                 {DataType: DataTypeBool, Units: []Unit{UnitNone}},
+	OutputTypeMute:                   {DataType: DataTypeBool, Units: []Unit{UnitNone}},
+	OutputTypeParticulateMatter10:    {DataType: DataTypeNumber, DefaultUnit: UnitMicrogramPerM3, Units: []Unit{UnitMicrogramPerM3}},
+	OutputTypeParticulateMatter25:    {DataType: DataTypeNumber, DefaultUnit: UnitMicrogramPerM3, Units: []Unit{UnitMicrogramPerM3}},
+	OutputTypePlay:                   {DataType: DataTypeBool, Units: []Unit{UnitNone}},
+	OutputTypePowerFactor:            {DataType: DataTypeNumber, Units: []Unit{UnitNone}},
+	OutputTypePushButton:             {DataType: DataTypeNumber, Units: []Unit{UnitNone, UnitCount}},
+	OutputTypeRain:                   {DataType: DataTypeNumber, DefaultUnit: UnitMeter, Units: []Unit{UnitMeter, UnitFeet}},
+	OutputTypeRelay:                  {DataType: DataTypeBool, Units: []Unit{UnitNone}},
+	OutputTypeSaturation:             {DataType: DataTypeString, Units: []Unit{UnitNone}},
+	OutputTypeScale:                  {DataType: DataTypeNumber, DefaultUnit: UnitKG, Units: []Unit{UnitKG, UnitPounds}},
+	OutputTypeSignalStrength:         {DataType: DataTypeNumber, DefaultUnit: "dBm", Units: []Unit{"dBm"}},
+	OutputTypeSmokeDetector:          {DataType: DataTypeBool, Units: []Unit{UnitNone}},
+	OutputTypeSnow:                   {DataType: DataTypeNumber, DefaultUnit: UnitMeter, Units: []Unit{UnitMeter, UnitFeet}},
+	OutputTypeSoundDetector:          {DataType: DataTypeBool, Units: []Unit{UnitNone}},
+	OutputTypeSwitch:                 {DataType: DataTypeBool, Units: []Unit{UnitNone}},
+	OutputTypeTemperature:            {DataType: DataTypeNumber, DefaultUnit: UnitCelcius, Units: []Unit{UnitCelcius, UnitFahrenheit, UnitKelvin}},
+	OutputTypeUltraviolet:            {DataType: DataTypeNumber},
+	OutputTypeUVIndex:                {DataType: DataTypeNumber, DefaultUnit: UnitUVIndex, Units: []Unit{UnitUVIndex}},
+	OutputTypeValue:                  {DataType: DataTypeNumber},
+	OutputTypeVibrationDetector:      {DataType: DataTypeNumber},
+	OutputTypeVOCLevel:               {DataType: DataTypeNumber, DefaultUnit: UnitPartsPerBillion, Units: []Unit{UnitPartsPerBillion, UnitPartsPerMillion, UnitMicrogramPerM3}},
+	OutputTypeVoltage:                {DataType: DataTypeNumber, DefaultUnit: UnitVolt, Units: []Unit{UnitVolt}},
+	OutputTypeVolume:                 {DataType: DataTypeNumber, DefaultUnit: UnitPercent, Units: []Unit{UnitPercent}},
+	OutputTypeWaterLevel:             {DataType: DataTypeNumber, DefaultUnit: UnitMeter, Units: []Unit{UnitMeter, UnitFeet}},
+	OutputTypeWeather:                {DataType: DataTypeString, Units: []Unit{UnitNone}},
+	OutputTypeWindHeading:            {DataType: DataTypeNumber, DefaultUnit: UnitDegree, Units: []Unit{UnitDegree}},
+	OutputTypeWindSpeed:              {DataType: DataTypeNumber, DefaultUnit: UnitMetersPerSecond, Units: []Unit{UnitMetersPerSecond, UnitKmPerHour, UnitMilesPerHour}},
+}
+
+// IsStandardOutputUnit tests if the output type and unit combination is known in the OutputTypeMap
+// An empty unit is accepted for types that have a default unit.
+// Returns whether the type is known, and if so, whether its unit is valid for the type.
+func IsStandardOutputUnit(outputType OutputType, unit Unit) (knownType bool, knownUnit bool) {
+	info, knownType := OutputTypeMap[outputType]
+	if !knownType {
+		return false, false
+	}
+	if info.Units == nil || (unit == UnitNone && info.DefaultUnit != UnitNone) {
+		return true, true
+	}
+	for _, validUnit := range info.Units {
+		if validUnit == unit {
+			return true, true
+		}
+	}
+	return true, false
+}
@@ -58,7 +58,10 @@ const (
 	OutputTypeMotion                 OutputType = "motion"
 	OutputTypeMute                   OutputType = "avmute"
 	OutputTypeOnOffSwitch            OutputType = "switch"
+	OutputTypeParticulateMatter10    OutputType = "pm10"
+	OutputTypeParticulateMatter25    OutputType = "pm25"
 	OutputTypePlay                   OutputType = "avplay"
+	OutputTypePowerFactor            OutputType = "powerfactor"
 	OutputTypePushButton             OutputType = "pushbutton" // with nr of pushes
 	OutputTypeRain                   OutputType = "rain"
 	OutputTypeRelay                  OutputType = "relay"
@@ -71,8 +74,10 @@ const (
 	OutputTypeSwitch                 OutputType = "switch" // on/off switch: "on" "off"
 	OutputTypeTemperature            OutputType = "temperature"
 	OutputTypeUltraviolet            OutputType = "ultraviolet"
+	OutputTypeUVIndex                OutputType = "uvindex"
 	OutputTypeVibrationDetector      OutputType = "vibrationdetector"
-	OutputTypeValue                  OutputType = "value" // generic value
+	OutputTypeValue                  OutputType = "value"    // generic value
+	OutputTypeVOCLevel               OutputType = "voclevel" // volatile organic compounds
 	OutputTypeVoltage                OutputType = "voltage"
 	OutputTypeVolume                 OutputType = "volume"
 	OutputTypeWaterLevel             OutputType = "waterlevel"
@@ -81,68 +86,6 @@ const (
 	OutputTypeWindSpeed              OutputType = "windspeed"
 )
 
-// OutputTypeMap defines data type and unit for an IOType
-// Todo: option to download from file
-// var OutputTypeMap = map[OutputType]struct {
-// 	DataType    DataType
-// 	DefaultUnit Unit
-// 	UnitValues  string
-// }{
-// 	OutputTypeUnknown:                {},
-// 	OutputTypeAcceleration:           {DataType: DataTypeNumber, DefaultUnit: "m/s2"},
-// 	OutputTypeAirQuality:             {DataType: DataTypeNumber, DefaultUnit: ""},
-// 	OutputTypeAlarm:                  {DataType: DataTypeString},
-// 	OutputTypeAtmosphericPressure:    {DataType: DataTypeNumber, DefaultUnit: UnitMillibar, UnitValues: UnitValuesAtmosphericPressure},
-// 	OutputTypeBattery:                {DataType: DataTypeNumber, DefaultUnit: UnitPercent},
-// 	OutputTypeCarbonDioxideLevel:     {DataType: DataTypeNumber, DefaultUnit: UnitPartsPerMillion},
-// 	OutputTypeCarbonMonoxideDetector: {DataType: DataTypeBool},
-// 	OutputTypeCarbonMonoxideLevel:    {DataType: DataTypeNumber, DefaultUnit: UnitPartsPerMillion},
-// 	OutputTypeChannel:                {DataType: DataTypeNumber},
-// 	OutputTypeColor:                  {DataType: DataTypeString},
-// 	OutputTypeColorTemperature:       {DataType: DataTypeNumber, DefaultUnit: UnitKelvin},
-// 	OutputTypeConnections:            {DataType: DataTypeNumber},
-// 	OutputTypeContact:                {DataType: DataTypeBool},
-// 	OutputTypeCPULevel:               {DataType: DataTypeNumber, DefaultUnit: UnitPercent},
-// 	OutputTypeDewpoint:               {DataType: DataTypeNumber, DefaultUnit: UnitCelcius, UnitValues: UnitValuesTemperature},
-// 	OutputTypeDimmer:                 {DataType: DataTypeNumber, DefaultUnit: UnitPercent},
-// 	OutputTypeDoorWindowSensor:       {DataType: DataTypeBool},
-// 	OutputTypeElectricCurrent:        {DataType: DataTypeBool, DefaultUnit: UnitAmp},
-// 	OutputTypeElectricEnergy:         {DataType: DataTypeNumber, DefaultUnit: UnitKWH},
-// 	OutputTypeElectricPower:          {DataType: DataTypeNumber, DefaultUnit: UnitWatt},
-// 	OutputTypeErrors:                 {DataType: DataTypeNumber},
-// 	OutputTypeHeatIndex:              {DataType: DataTypeNumber, DefaultUnit: UnitCelcius, UnitValues: "C, F"},
-// 	OutputTypeHue:                    {DataType: DataTypeString},
-// 	OutputTypeHumidex:                {DataType: DataTypeNumber, DefaultUnit: UnitCelcius, UnitValues: "C, F"},
-// 	OutputTypeHumidity:               {DataType: DataTypeNumber, DefaultUnit: UnitPercent},
-// 	OutputTypeImage:                  {DataType: DataTypeBytes, DefaultUnit: "", UnitValues: UnitValuesImage},
-// 	OutputTypeLatency:                {DataType: DataTypeNumber, DefaultUnit: UnitSecond},
-// 	OutputTypeLevel:                  {DataType: DataTypeNumber, DefaultUnit: UnitPercent},
-// 	OutputTypeLocation:               {DataType: DataTypeString},
-// 	OutputTypeLock:                   {DataType: DataTypeString},
-// 	OutputTypeLuminance:              {DataType: DataTypeNumber, DefaultUnit: UnitLux},
-// 	OutputTypeMotion:                 {DataType: DataTypeBool},
-// 	OutputTypeMute:                   {DataType: DataTypeBool},
-// 	OutputTypeOnOffSwitch:            {DataType: DataTypeBool},
-// 	OutputTypePlay:                   {DataType: DataTypeBool},
-// 	OutputTypePushButton:             {DataType: DataTypeNumber},
-// 	OutputTypeRain:                   {DataType: DataTypeNumber, DefaultUnit: UnitMeter, UnitValues: "m"},
-// 	OutputTypeSaturation:             {DataType: DataTypeString},
-// 	OutputTypeScale:                  {DataType: DataTypeNumber, DefaultUnit: UnitKG, UnitValues: UnitValuesWeight},
-// 	OutputTypeSnow:                   {DataType: DataTypeNumber, DefaultUnit: UnitMeter, UnitValues: "m"},
-// 	OutputTypeSignalStrength:         {DataType: DataTypeNumber, DefaultUnit: "dBm"},
-// 	OutputTypeSmokeDetector:          {DataType: DataTypeBool},
-// 	OutputTypeSoundDetector:          {DataType: DataTypeBool},
-// 	OutputTypeTemperature:            {DataType: DataTypeNumber},
-// 	OutputTypeUltraviolet:            {DataType: DataTypeNumber},
-// 	OutputTypeVibrationDetector:      {DataType: DataTypeNumber},
-// 	OutputTypeValue:                  {DataType: DataTypeNumber},
-// 	OutputTypeVoltage:                {DataType: DataTypeNumber, DefaultUnit: UnitVolt},
-// 	OutputTypeVolume:                 {DataType: DataTypeNumber, DefaultUnit: UnitPercent},
-// 	OutputTypeWaterLevel:             {DataType: DataTypeNumber, DefaultUnit: UnitMeter, UnitValues: UnitValuesLength},
-// 	OutputTypeWindHeading:            {DataType: DataTypeNumber, DefaultUnit: UnitDegree},
-// 	OutputTypeWindSpeed:              {DataType: DataTypeNumber, DefaultUnit: UnitSpeed, UnitValues: UnitValuesSpeed},
-// }
-
 // OutputBatchMessage message with multiple output events
 type OutputBatchMessage struct {
 	Address string `json:"address"` // Address of the publication: zone/publisher/node/$output/type/instance
@@ -155,15 +98,16 @@ type OutputBatchMessage struct {
 
 // OutputDiscoveryMessage with node output description
 type OutputDiscoveryMessage struct {
-	Address    string        `json:"address"`              // Address of the publication: zone/publisher/node/$output/type/instance
-	Attr       NodeAttrMap   `json:"attr,omitempty"`       // Attributes describing this output
-	Config     ConfigAttrMap `json:"config,omitempty"`     // Optional configuration of output
-	DataType   DataType      `json:"dataType,omitempty"`   // output value data type, default is string
-	EnumValues []string      `json:"enumValues,omitempty"` // possible enum output values for enum datatype
-	Max        float32       `json:"max,omitempty"`        // optional max value of output for numeric data types
-	Min        float32       `json:"min,omitempty"`        // optional min value of output for numeric data types
-	Timestamp  string        `json:"timestamp"`            // time the record is last updated
-	Unit       Unit          `json:"unit,omitempty"`       // unit of output value
+	Address    string          `json:"address"`              // Address of the publication: zone/publisher/node/$output/type/instance
+	Attr       NodeAttrMap     `json:"attr,omitempty"`       // Attributes describing this output
+	Config     ConfigAttrMap   `json:"config,omitempty"`     // Optional configuration of output
+	DataType   DataType        `json:"dataType,omitempty"`   // output value data type, default is string
+	EnumValues EnumValues      `json:"enumValues,omitempty"` // possible enum output values for enum datatype
+	Max        float32         `json:"max,omitempty"`        // optional max value of output for numeric data types
+	Min        float32         `json:"min,omitempty"`        // optional min value of output for numeric data types
+	Timestamp  string          `json:"timestamp"`            // time the record is last updated
+	TypeInfo   *CustomTypeInfo `json:"typeInfo,omitempty"`   // metadata of vendor specific types
+	Unit       Unit            `json:"unit,omitempty"`       // unit of output value
 	// For convenience, filled when registering or receiving
 	OutputID    string     `json:"-"`
 	NodeHWID    string     `json:"-"`
@@ -176,6 +120,7 @@ type OutputDiscoveryMessage struct {
 type OutputEventMessage struct {
 	Address   string            `json:"address"` // Address of the publication: zone/publisher/node/$output/type/instance
 	Event     map[string]string `json:"event"`
+	Sequence  uint64            `json:"sequence,omitempty"` // sequence nr of the publication on this address, to detect missed events
 	Timestamp string            `json:"timestamp"`
 }
 
@@ -191,6 +136,25 @@ type OutputForecastMessage struct {
 	Unit      Unit          `json:"unit,omitempty"`
 }
 
+// OutputGroupMessage with a snapshot of the latest values of a group of outputs that are published
+// together, eg all phases of a power meter. Intended for consumers that compute derived quantities from
+// coherent values.
+type OutputGroupMessage struct {
+	Address   string             `json:"address"`            // Address of the publication: zone/publisher/groupId/$group
+	Outputs   []OutputGroupValue `json:"outputs"`            // values of the outputs of the group
+	Sequence  uint64             `json:"sequence,omitempty"` // sequence nr of the publication on this address, to detect missed snapshots
+	Timestamp string             `json:"timestamp"`          // time of the snapshot
+}
+
+// OutputGroupValue with the latest value of an output in an OutputGroupMessage
+type OutputGroupValue struct {
+	Address   string       `json:"address"`           // Discovery address of the output: zone/publisher/node/type/instance/$output
+	Quality   ValueQuality `json:"quality,omitempty"` // quality of the value, default is good
+	Timestamp string       `json:"timestamp"`         // timestamp of the value
+	Unit      Unit         `json:"unit,omitempty"`
+	Value     string       `json:"value"` // empty if the output has no value yet
+}
+
 // OutputHistoryMessage with historical output value
 type OutputHistoryMessage struct {
 	Address   string        `json:"address"` // Address of the publication: zone/publisher/node/$output/type/instance
@@ -202,15 +166,53 @@ type OutputHistoryMessage struct {
 
 // OutputLatestMessage struct to send/receive the '$latest' command
 type OutputLatestMessage struct {
-	Address   string `json:"address"`   // Address of the publication: zone/publisher/node/$output/type/instance
-	Timestamp string `json:"timestamp"` // timestamp of value
-	Unit      Unit   `json:"unit,omitempty"`
-	Value     string `json:"value"` // this can also be a string containing a list, eg "[ a, b, c ]""
+	Address   string       `json:"address"`            // Address of the publication: zone/publisher/node/$output/type/instance
+	Quality   ValueQuality `json:"quality,omitempty"`  // quality of the value, default is good
+	Sequence  uint64       `json:"sequence,omitempty"` // sequence nr of the publication on this address, to detect missed values
+	Timestamp string       `json:"timestamp"`          // timestamp of value
+	Unit      Unit         `json:"unit,omitempty"`
+	Value     string       `json:"value"` // this can also be a string containing a list, eg "[ a, b, c ]""
+}
+
+// OutputRawSignatureMessage struct to send/receive the '$rawsig' detached signature of a $raw value
+// Intended for consumers of $raw values that can't parse JWS to verify the value separately.
+type OutputRawSignatureMessage struct {
+	Address   string `json:"address"`   // Address of the $raw publication whose value is signed
+	Signature string `json:"signature"` // JWS compact serialization of the signature of address|timestamp|value with detached payload
+	Timestamp string `json:"timestamp"` // timestamp of signing, included in the signed payload
+}
+
+// OutputReplayResultMessage struct to send/receive the '$replayResult' output values that are republished
+// at the request of a consumer with a $replay message. It is published to the requester on
+// zone/requester/$replayResult and isn't retained.
+type OutputReplayResultMessage struct {
+	Address   string        `json:"address"`   // Address of the replayed output: zone/publisher/node/type/instance/$replayResult
+	Complete  bool          `json:"complete"`  // false if requested values are not replayed due to the replay budget
+	Replay    []OutputValue `json:"replay"`    // the replayed values, oldest first
+	Requester string        `json:"requester"` // identity address of the consumer that requested the replay
+	Timestamp string        `json:"timestamp"` // timestamp this message was created
+	Unit      Unit          `json:"unit,omitempty"`
 }
 
 // OutputValue struct for history and forecast
 type OutputValue struct {
-	Timestamp string `json:"timestamp"` // Timestamp of the value is ISO 8601
-	Value     string `json:"value"`     // this can also be a string containing a list, eg "[ a, b, c ]""
-	EpochTime int64  `json:"epoch"`     // seconds since jan 1st, 1970,
+	Timestamp string       `json:"timestamp"`          // Timestamp of the value is ISO 8601
+	Value     string       `json:"value"`              // this can also be a string containing a list, eg "[ a, b, c ]""
+	EpochTime int64        `json:"epoch"`              // seconds since jan 1st, 1970,
+	Quality   ValueQuality `json:"quality,omitempty"`  // quality of the value, default is good
+	RawValue  string       `json:"raw,omitempty"`      // uncalibrated value if the value is calibrated
+	Sequence  uint64       `json:"sequence,omitempty"` // sequence nr of the $latest publication of the value, if published
 }
+
+// ValueQuality indicates how reliable an output value is
+// An empty quality is treated as good.
+type ValueQuality string
+
+// Output value qualities
+const (
+	ValueQualityGood        ValueQuality = "good"        // the value is reliable
+	ValueQualityUncertain   ValueQuality = "uncertain"   // the sensor reported a possible problem with the value
+	ValueQualityBad         ValueQuality = "bad"         // the value is not reliable, eg the sensor reported an error
+	ValueQualitySubstituted ValueQuality = "substituted" // the value is interpolated or provided by other means than the sensor
+	ValueQualityOverridden  ValueQuality = "overridden"  // the value is forced by an operator, eg during commissioning
+)
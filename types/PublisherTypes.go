@@ -5,6 +5,113 @@ package types
 // the DSS is responsible for renewal of keys in a secured domain.
 const DSSPublisherID = "$dss"
 
+// PublisherControlCommand with commands to control a publisher remotely
+type PublisherControlCommand string
+
+// Publisher control commands
+const (
+	PublisherControlClearOverride  PublisherControlCommand = "clearOverride"  // clear the override of an input or output, value is its ID
+	PublisherControlDiscover       PublisherControlCommand = "discover"       // run discovery of nodes, inputs and outputs now
+	PublisherControlFlushCache     PublisherControlCommand = "flushCache"     // remove the cached discovered publishers and input values
+	PublisherControlOverrideInput  PublisherControlCommand = "overrideInput"  // ignore set commands of an input, value is the input ID
+	PublisherControlOverrideOutput PublisherControlCommand = "overrideOutput" // force an output value, value is {outputID}={value}
+	PublisherControlPause          PublisherControlCommand = "pause"          // pause publication of updates, value is the policy: buffer (default) or drop
+	PublisherControlProfileCPU     PublisherControlCommand = "profileCPU"     // capture a CPU profile, value is the nr of seconds. Default is 30
+	PublisherControlProfileHeap    PublisherControlCommand = "profileHeap"    // capture a heap profile
+	PublisherControlReloadIdentity PublisherControlCommand = "reloadIdentity" // reload the publisher identity from its identity file
+	PublisherControlRepublish      PublisherControlCommand = "republish"      // republish all nodes, inputs and outputs
+	PublisherControlRestart        PublisherControlCommand = "restart"        // stop and start the publisher
+	PublisherControlResume         PublisherControlCommand = "resume"         // resume publication of updates
+	PublisherControlSetLogLevel    PublisherControlCommand = "setLogLevel"    // set the logging level: error, warning, info, debug
+)
+
+// PublisherControlRoles contains the role that is required to send a control command
+// Commands that are not listed require the admin role.
+var PublisherControlRoles = map[PublisherControlCommand]Role{
+	PublisherControlClearOverride:  RoleOperator,
+	PublisherControlDiscover:       RoleOperator,
+	PublisherControlFlushCache:     RoleAdmin,
+	PublisherControlOverrideInput:  RoleOperator,
+	PublisherControlOverrideOutput: RoleOperator,
+	PublisherControlPause:          RoleAdmin,
+	PublisherControlProfileCPU:     RoleAdmin,
+	PublisherControlProfileHeap:    RoleAdmin,
+	PublisherControlReloadIdentity: RoleAdmin,
+	PublisherControlRepublish:      RoleOperator,
+	PublisherControlRestart:        RoleAdmin,
+	PublisherControlResume:         RoleAdmin,
+	PublisherControlSetLogLevel:    RoleAdmin,
+}
+
+// PublisherControlMessage with a command to control a publisher
+// This message MUST be encrypted and signed by an authorized sender
+type PublisherControlMessage struct {
+	Address   string                  `json:"address"`         // publication address of this message: domain/publisherId/$control
+	Command   PublisherControlCommand `json:"command"`         // command to execute
+	Sender    string                  `json:"sender"`          // identity address of the sender: domain/publisherId/$identity
+	Timestamp string                  `json:"timestamp"`       // timestamp this message was created
+	Value     string                  `json:"value,omitempty"` // optional command parameter, eg the log level
+}
+
+// DomainLogEvent with the security relevant events that publishers publish on $domainlog
+type DomainLogEvent string
+
+// Domain log events
+const (
+	DomainLogEventAliasChanged      DomainLogEvent = "aliasChanged"      // the node ID of a node has changed, subject is the node address
+	DomainLogEventIdentityChanged   DomainLogEvent = "identityChanged"   // the identity of the logging publisher is renewed or migrated
+	DomainLogEventIdentityConflict  DomainLogEvent = "identityConflict"  // another publisher uses the logging publisher's ID with a different key
+	DomainLogEventKeyChanged        DomainLogEvent = "keyChanged"        // a self-signed identity differs from its pinned key, subject is the publisher
+	DomainLogEventRejectedSignature DomainLogEvent = "rejectedSignature" // a received message is rejected by its signature, subject is the sender
+	DomainLogEventRevoked           DomainLogEvent = "revoked"           // an identity or key is revoked, eg by the DSS, subject is the publisher
+)
+
+// DomainLogSeverity of domain log events
+type DomainLogSeverity string
+
+// Domain log severities
+const (
+	DomainLogSeverityCritical DomainLogSeverity = "critical" // likely attack that needs attention
+	DomainLogSeverityInfo     DomainLogSeverity = "info"     // expected security relevant change
+	DomainLogSeverityWarning  DomainLogSeverity = "warning"  // possible attack or misconfiguration
+)
+
+// DomainLogMessage with a security relevant event published on $domainlog
+// Intended for security monitoring, eg by a SIEM. Domain log messages are signed by the sender.
+type DomainLogMessage struct {
+	Address     string            `json:"address"`               // publication address of this message: domain/publisherId/$domainlog
+	Description string            `json:"description,omitempty"` // human readable description of the event
+	Event       DomainLogEvent    `json:"event"`                 // the event that took place
+	Sender      string            `json:"sender"`                // identity address of the logging publisher: domain/publisherId/$identity
+	Severity    DomainLogSeverity `json:"severity"`              // severity of the event
+	Subject     string            `json:"subject,omitempty"`     // address of the publisher, node or sender the event is about
+	Timestamp   string            `json:"timestamp"`             // time the event took place
+}
+
+// InterestMessage with the announcement of a consumer's interest in publications of a publisher
+// Publishers can suppress expensive publications while no consumer is interested. Consumers renew
+// their interest before its duration expires.
+type InterestMessage struct {
+	Address      string   `json:"address"`          // publication address of this message: domain/publisherId/$interest
+	Duration     int      `json:"duration"`         // seconds the interest lasts unless it is renewed
+	MessageTypes []string `json:"messageTypes"`     // message types of interest, eg $history, $event or $raw
+	NodeID       string   `json:"nodeId,omitempty"` // node of interest. Default is all nodes of the publisher
+	Sender       string   `json:"sender"`           // identity address of the consumer: domain/publisherId/$identity
+	Timestamp    string   `json:"timestamp"`        // timestamp this message was created
+}
+
+// ReplayMessage with the request of a consumer to republish the output values it missed, eg after a gap
+// in the sequence nrs of the latest values. The publisher replays the values from the history of the
+// output on its $replayResult address, limited by its replay budget. Use SinceSequence or SinceTime.
+type ReplayMessage struct {
+	Address       string `json:"address"`                 // publication address of this message: domain/publisherId/$replay
+	OutputAddress string `json:"outputAddress"`           // address of the output: domain/publisherId/nodeId/type/instance[/messageType]
+	Sender        string `json:"sender"`                  // identity address of the consumer: domain/publisherId/$identity
+	SinceSequence uint64 `json:"sinceSequence,omitempty"` // replay values with a higher sequence nr than this
+	SinceTime     string `json:"sinceTime,omitempty"`     // replay values after this ISO8601 time
+	Timestamp     string `json:"timestamp"`               // timestamp this message was created
+}
+
 // PublisherRunState indicates the operating status of the publisher. Used in LWT.
 type PublisherRunState string
 
@@ -19,17 +126,20 @@ const (
 
 // PublisherIdentityMessage contains the public identity of a publisher
 type PublisherIdentityMessage struct {
-	Address           string `json:"address"`               // publication address of this identity, eg domain/publisherId/\$identity
-	Certificate       string `json:"certificate,omitempty"` // optional x509 cert base64 encoded
-	Domain            string `json:"domain"`                // IoT domain name for this publisher
-	IssuerID          string `json:"issuerId"`              // Issuer of the identity, the DSS, publisherId or CA
-	Location          string `json:"location,omitempty"`    // city, province, country
-	Organization      string `json:"organization"`          // publishing organization
-	PublicKey         string `json:"publicKey"`             // public key in PEM format for signature verification and encryption
-	PublisherID       string `json:"publisherId"`           // This publisher's ID for this domain
-	ValidUntil        string `json:"validUntil"`            // timestamp this identity expires
-	IdentitySignature string `json:"signature"`             // base64 encoded signature of this identity
-	Timestamp         string `json:"timestamp"`             // timestamp this message was created
+	Address           string        `json:"address"`                 // publication address of this identity, eg domain/publisherId/\$identity
+	Certificate       string        `json:"certificate,omitempty"`   // optional x509 cert base64 encoded
+	Created           string        `json:"created,omitempty"`       // time the identity keys were created. Unchanged when signed again
+	Domain            string        `json:"domain"`                  // IoT domain name for this publisher
+	IssuerID          string        `json:"issuerId"`                // Issuer of the identity, the DSS, publisherId or CA
+	Location          string        `json:"location,omitempty"`      // city, province, country
+	Organization      string        `json:"organization"`            // publishing organization
+	Priority          int           `json:"priority,omitempty"`      // priority of the claim to the publisher ID when another publisher uses it
+	PublicKey         string        `json:"publicKey"`               // public key in PEM format for signature verification and encryption
+	PublisherID       string        `json:"publisherId"`             // This publisher's ID for this domain
+	UnsignedTypes     []MessageType `json:"unsignedTypes,omitempty"` // message types this publisher publishes without signature
+	ValidUntil        string        `json:"validUntil"`              // timestamp this identity expires
+	IdentitySignature string        `json:"signature"`               // base64 encoded signature of this identity
+	Timestamp         string        `json:"timestamp"`               // timestamp this message was created
 }
 
 // PublisherFullIdentity containing the public identity, DSS signature and private key
@@ -43,6 +153,11 @@ type PublisherFullIdentity struct {
 
 // PublisherStatusMessage containing 'alive' status, used in LWT
 type PublisherStatusMessage struct {
-	Address string            `json:"address"` // publication address of this message
-	Status  PublisherRunState `json:"status"`
+	Address          string            `json:"address"`                    // publication address of this message
+	ClockOutOfSync   bool              `json:"clockOutOfSync,omitempty"`   // the clock skew exceeds the allowed maximum
+	ClockSkewMSec    int64             `json:"clockSkewMsec,omitempty"`    // estimated domain time minus local time in msec
+	IdentityConflict bool              `json:"identityConflict,omitempty"` // security alert, another publisher uses this publisher ID with a different key
+	IdentityExpiry   string            `json:"identityExpiry,omitempty"`   // time the identity expires, when within the expiry warning period
+	Status           PublisherRunState `json:"status"`
+	Timestamp        string            `json:"timestamp,omitempty"` // time the status was published
 }
@@ -0,0 +1,22 @@
+// Package types with definitions of the roles of clients of the control and gateway APIs
+package types
+
+// Role of a client that determines what it is authorized to do
+// Each role includes the permissions of the roles below it.
+type Role string
+
+// Available roles, from least to most privileged
+const (
+	RoleNone     Role = ""         // not authorized
+	RoleViewer   Role = "viewer"   // read domain data
+	RoleOperator Role = "operator" // also set inputs and operate nodes
+	RoleAdmin    Role = "admin"    // also manage the publisher
+)
+
+// RoleRank orders the roles by privilege
+var RoleRank = map[Role]int{
+	RoleNone:     0,
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
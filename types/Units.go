@@ -25,9 +25,11 @@ const (
 	UnitMercury         Unit = "hg"
 	UnitMeter           Unit = "m"
 	UnitMetersPerSecond Unit = "m/s"
+	UnitMicrogramPerM3  Unit = "ug/m3"
 	UnitMilesPerHour    Unit = "mph"
 	UnitMillibar        Unit = "mbar"
 	UnitMole            Unit = "mol"
+	UnitPartsPerBillion Unit = "ppb"
 	UnitPartsPerMillion Unit = "ppm"
 	UnitPng             Unit = "png"
 	UnitKWH             Unit = "KWh"
@@ -39,6 +41,7 @@ const (
 	UnitSpeed           Unit = "m/s"
 	UnitPSI             Unit = "psi"
 	UnitSecond          Unit = "s "
+	UnitUVIndex         Unit = "UVI"
 	UnitVolt            Unit = "V"
 	UnitWatt            Unit = "W"
 )
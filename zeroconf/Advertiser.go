@@ -0,0 +1,173 @@
+// Package zeroconf with the mDNS advertisement of a DNS-SD service instance
+package zeroconf
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultTTL is the time to live in seconds of advertised records
+const DefaultTTL = 120
+
+// Advertiser answers mDNS queries for a service instance and announces it on start and stop
+type Advertiser struct {
+	conn        *net.UDPConn
+	hostname    string            // target host name of the SRV record
+	instance    string            // instance name, eg the publisher ID
+	port        int               // service port, 0 if the service has no port
+	service     string            // service type, eg _iotdomain._tcp
+	text        map[string]string // TXT record key=value pairs
+	updateMutex *sync.Mutex
+}
+
+// Start listens for queries and announces the service instance
+// This returns an error if the mDNS address can't be listened on.
+func (adv *Advertiser) Start() error {
+	adv.updateMutex.Lock()
+	defer adv.updateMutex.Unlock()
+	if adv.conn != nil {
+		return nil
+	}
+	conn, err := listenMDNS()
+	if err != nil {
+		return lib.MakeErrorf("Advertiser.Start: Unable to listen on '%s': %s", MDNSAddress, err)
+	}
+	adv.conn = conn
+	go adv.receiveLoop(conn)
+	adv.announce(DefaultTTL)
+	logrus.Infof("Advertiser.Start: Advertising '%s' as service '%s'", adv.instance, adv.service)
+	return nil
+}
+
+// Stop announces the service instance is going away and stops answering queries
+func (adv *Advertiser) Stop() {
+	adv.updateMutex.Lock()
+	defer adv.updateMutex.Unlock()
+	if adv.conn == nil {
+		return
+	}
+	adv.announce(0)
+	adv.conn.Close()
+	adv.conn = nil
+}
+
+// announce multicasts the records of the service instance. A TTL of 0 is a goodbye.
+func (adv *Advertiser) announce(ttl uint32) {
+	groupAddr, err := net.ResolveUDPAddr("udp4", MDNSAddress)
+	if err != nil {
+		return
+	}
+	response := adv.makeResponse(0, ttl)
+	adv.conn.WriteToUDP(response.marshal(), groupAddr)
+}
+
+// instanceName returns the full name of the service instance
+func (adv *Advertiser) instanceName() string {
+	return adv.instance + "." + adv.serviceName()
+}
+
+// makeResponse returns the response with the PTR, SRV, TXT and A records of the service instance
+func (adv *Advertiser) makeResponse(id uint16, ttl uint32) *dnsMessage {
+	response := &dnsMessage{id: id, isResponse: true}
+	response.records = append(response.records,
+		dnsRecord{class: dnsClassIN, name: adv.serviceName(), rrType: dnsTypePTR, target: adv.instanceName(), ttl: ttl},
+		dnsRecord{class: dnsClassIN | dnsCacheFlush, name: adv.instanceName(), port: uint16(adv.port),
+			rrType: dnsTypeSRV, target: adv.hostname, ttl: ttl},
+		dnsRecord{class: dnsClassIN | dnsCacheFlush, name: adv.instanceName(), rrType: dnsTypeTXT,
+			text: adv.text, ttl: ttl},
+	)
+	for _, ip := range getLocalIPs() {
+		response.records = append(response.records,
+			dnsRecord{class: dnsClassIN | dnsCacheFlush, ip: ip, name: adv.hostname, rrType: dnsTypeA, ttl: ttl})
+	}
+	return response
+}
+
+// receiveLoop answers queries for the service type and instance until the connection is closed.
+// Queries from a port other than the mDNS port are legacy unicast queries and receive a unicast response.
+func (adv *Advertiser) receiveLoop(conn *net.UDPConn) {
+	buffer := make([]byte, 9000)
+	for {
+		size, sender, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		query, err := parseDNSMessage(buffer[:size])
+		if err != nil || query.isResponse {
+			continue
+		}
+		matches := false
+		for _, question := range query.questions {
+			name := normalizeName(question.name)
+			if (name == normalizeName(adv.serviceName()) && (question.qType == dnsTypePTR || question.qType == dnsTypeANY)) ||
+				name == normalizeName(adv.instanceName()) {
+				matches = true
+			}
+		}
+		if !matches {
+			continue
+		}
+		destination := sender
+		response := adv.makeResponse(0, DefaultTTL)
+		if sender.Port == mdnsPort {
+			destination, err = net.ResolveUDPAddr("udp4", MDNSAddress)
+			if err != nil {
+				continue
+			}
+		} else {
+			response.id = query.id
+			response.questions = query.questions
+		}
+		conn.WriteToUDP(response.marshal(), destination)
+	}
+}
+
+// serviceName returns the full name of the service type
+func (adv *Advertiser) serviceName() string {
+	return adv.service + "." + localDomain
+}
+
+// getLocalIPs returns the IPv4 addresses of this host, or the loopback address if it has no others
+func getLocalIPs() []net.IP {
+	ips := make([]net.IP, 0)
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil && !ipNet.IP.IsLoopback() {
+			ips = append(ips, ipNet.IP.To4())
+		}
+	}
+	if len(ips) == 0 {
+		ips = append(ips, net.IPv4(127, 0, 0, 1).To4())
+	}
+	return ips
+}
+
+// NewAdvertiser creates an advertiser of a service instance
+//  instance is the name of the instance, eg the publisher ID. Dots are replaced by dashes.
+//  service is the service type, eg _iotdomain._tcp
+//  port is the port the service listens on, 0 if the service has no port
+//  text contains the TXT record key=value pairs
+func NewAdvertiser(instance string, service string, port int, text map[string]string) *Advertiser {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "localhost"
+	}
+	hostname = strings.Split(hostname, ".")[0] + "." + localDomain
+	if text == nil {
+		text = make(map[string]string)
+	}
+	adv := &Advertiser{
+		hostname:    hostname,
+		instance:    strings.Replace(instance, ".", "-", -1),
+		port:        port,
+		service:     service,
+		text:        text,
+		updateMutex: &sync.Mutex{},
+	}
+	return adv
+}
@@ -0,0 +1,200 @@
+// Package zeroconf with DNS-SD/mDNS browsing for message bus brokers and the domain security service
+package zeroconf
+
+import (
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+	"github.com/iotdomain/iotdomain-go/types"
+)
+
+// DNS-SD service types
+const (
+	BrokerServiceType       = "_mqtt._tcp"        // MQTT broker
+	PublisherServiceType    = "_iotdomain._tcp"   // publishers of the domain
+	SecureBrokerServiceType = "_secure-mqtt._tcp" // MQTT broker with TLS
+)
+
+// TXT record keys of publisher service instances
+const (
+	TextDomain    = "domain"
+	TextPublisher = "publisher"
+)
+
+// DefaultBrowseTimeout is the time to wait for responses when browsing
+const DefaultBrowseTimeout = 2 * time.Second
+
+// MDNSAddress is the address to send queries and announcements to. Tests can use a unicast address.
+var MDNSAddress = "224.0.0.251:5353"
+
+const localDomain = "local"
+const mdnsPort = 5353
+
+// ServiceEntry is a service instance found by browsing
+type ServiceEntry struct {
+	Host     string            // target host name of the SRV record
+	IPs      []net.IP          // addresses of the host
+	Instance string            // instance name without the service type
+	Port     int               // service port
+	Service  string            // service type of the instance
+	Text     map[string]string // TXT record key=value pairs
+}
+
+// Address returns the first IP address of the instance, or its host name if no address is known
+func (entry *ServiceEntry) Address() string {
+	if len(entry.IPs) > 0 {
+		return entry.IPs[0].String()
+	}
+	return strings.TrimSuffix(entry.Host, ".")
+}
+
+// Browse queries the local network for instances of a service type
+// This waits for responses until the timeout and returns the instances sorted by name.
+//  service is the service type, eg _iotdomain._tcp
+func Browse(service string, timeout time.Duration) ([]*ServiceEntry, error) {
+	return browse([]string{service}, timeout)
+}
+
+// LocateBroker browses for an MQTT broker, preferring brokers that use TLS
+// This returns nil if no broker was found within the timeout.
+func LocateBroker(timeout time.Duration) (*ServiceEntry, error) {
+	entries, err := browse([]string{SecureBrokerServiceType, BrokerServiceType}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range []string{SecureBrokerServiceType, BrokerServiceType} {
+		for _, entry := range entries {
+			if entry.Service == service {
+				return entry, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// LocateDSS browses for the publisher instance of the domain security service
+// This returns nil if the DSS was not found within the timeout.
+func LocateDSS(timeout time.Duration) (*ServiceEntry, error) {
+	entries, err := browse([]string{PublisherServiceType}, timeout)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Text[TextPublisher] == types.DSSPublisherID {
+			return entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// browse sends a PTR query for the service types and collects the instances from the responses
+func browse(services []string, timeout time.Duration) ([]*ServiceEntry, error) {
+	groupAddr, err := net.ResolveUDPAddr("udp4", MDNSAddress)
+	if err != nil {
+		return nil, lib.MakeErrorf("browse: Invalid mDNS address '%s': %s", MDNSAddress, err)
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, lib.MakeErrorf("browse: Unable to listen for responses: %s", err)
+	}
+	defer conn.Close()
+
+	query := &dnsMessage{id: uint16(rand.Intn(0x10000))}
+	for _, service := range services {
+		query.questions = append(query.questions, dnsQuestion{
+			class: dnsClassIN | dnsUnicastResponse,
+			name:  service + "." + localDomain,
+			qType: dnsTypePTR,
+		})
+	}
+	_, err = conn.WriteToUDP(query.marshal(), groupAddr)
+	if err != nil {
+		return nil, lib.MakeErrorf("browse: Unable to send query to '%s': %s", MDNSAddress, err)
+	}
+
+	instances := make(map[string]string) // service type by normalized instance name
+	names := make(map[string]string)     // instance name by normalized instance name
+	records := make([]dnsRecord, 0)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buffer := make([]byte, 9000)
+	for {
+		size, _, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			break
+		}
+		response, err := parseDNSMessage(buffer[:size])
+		if err != nil || !response.isResponse {
+			continue
+		}
+		for _, record := range response.records {
+			if record.rrType != dnsTypePTR || record.ttl == 0 {
+				continue
+			}
+			for _, service := range services {
+				target := strings.TrimSuffix(record.target, ".")
+				serviceName := normalizeName(service + "." + localDomain)
+				if normalizeName(record.name) == serviceName && strings.HasSuffix(normalizeName(target), "."+serviceName) {
+					instances[normalizeName(target)] = service
+					names[normalizeName(target)] = target[:len(target)-len(serviceName)-1]
+				}
+			}
+		}
+		records = append(records, response.records...)
+	}
+	entries := make([]*ServiceEntry, 0)
+	for instanceName, service := range instances {
+		entry := &ServiceEntry{
+			Instance: names[instanceName],
+			IPs:      make([]net.IP, 0),
+			Service:  service,
+			Text:     make(map[string]string),
+		}
+		for _, record := range records {
+			if normalizeName(record.name) != instanceName {
+				continue
+			} else if record.rrType == dnsTypeSRV {
+				entry.Host = record.target
+				entry.Port = int(record.port)
+			} else if record.rrType == dnsTypeTXT {
+				entry.Text = record.text
+			}
+		}
+		for _, record := range records {
+			if entry.Host != "" && normalizeName(record.name) == normalizeName(entry.Host) &&
+				(record.rrType == dnsTypeA || record.rrType == dnsTypeAAAA) && !containsIP(entry.IPs, record.ip) {
+				entry.IPs = append(entry.IPs, record.ip)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Instance < entries[j].Instance
+	})
+	return entries, nil
+}
+
+// containsIP returns true if the list contains the address
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, item := range ips {
+		if item.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// listenMDNS listens on the mDNS address, joining the group if the address is multicast
+func listenMDNS() (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp4", MDNSAddress)
+	if err != nil {
+		return nil, err
+	}
+	if addr.IP.IsMulticast() {
+		return net.ListenMulticastUDP("udp4", nil, addr)
+	}
+	return net.ListenUDP("udp4", addr)
+}
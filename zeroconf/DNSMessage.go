@@ -0,0 +1,254 @@
+// Package zeroconf with encoding and decoding of the DNS messages used by mDNS (RFC 6762)
+package zeroconf
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/iotdomain/iotdomain-go/lib"
+)
+
+// DNS record types used by DNS-SD
+const (
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeTXT  = 16
+	dnsTypeAAAA = 28
+	dnsTypeSRV  = 33
+	dnsTypeANY  = 255
+)
+
+// DNS classes and the mDNS flags in the class field
+const (
+	dnsClassIN         = 1
+	dnsCacheFlush      = 0x8000 // record replaces cached records of the same name and type
+	dnsUnicastResponse = 0x8000 // question asks for a unicast response
+)
+
+// dnsFlagResponse are the header flags of an authoritative response
+const dnsFlagResponse = 0x8400
+
+// dnsQuestion is a question of a DNS query
+type dnsQuestion struct {
+	class uint16
+	name  string
+	qType uint16
+}
+
+// dnsRecord is a resource record of a DNS response with its decoded data
+type dnsRecord struct {
+	class  uint16
+	ip     net.IP            // address of A and AAAA records
+	name   string            // owner name
+	port   uint16            // port of SRV records
+	rrType uint16            // record type
+	target string            // target name of PTR and SRV records
+	text   map[string]string // key=value pairs of TXT records
+	ttl    uint32
+}
+
+// dnsMessage is a DNS query or response. Parsed responses hold the answer and additional records.
+type dnsMessage struct {
+	id         uint16
+	isResponse bool
+	questions  []dnsQuestion
+	records    []dnsRecord
+}
+
+// marshal encodes the message. Records are encoded as answers without name compression.
+func (message *dnsMessage) marshal() []byte {
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint16(data[0:], message.id)
+	if message.isResponse {
+		binary.BigEndian.PutUint16(data[2:], dnsFlagResponse)
+	}
+	binary.BigEndian.PutUint16(data[4:], uint16(len(message.questions)))
+	binary.BigEndian.PutUint16(data[6:], uint16(len(message.records)))
+
+	for _, question := range message.questions {
+		data = appendName(data, question.name)
+		data = appendUint16(data, question.qType)
+		data = appendUint16(data, question.class)
+	}
+	for _, record := range message.records {
+		rdata := make([]byte, 0)
+		switch record.rrType {
+		case dnsTypeA:
+			rdata = append(rdata, record.ip.To4()...)
+		case dnsTypeAAAA:
+			rdata = append(rdata, record.ip.To16()...)
+		case dnsTypePTR:
+			rdata = appendName(rdata, record.target)
+		case dnsTypeSRV:
+			rdata = appendUint16(rdata, 0) // priority
+			rdata = appendUint16(rdata, 0) // weight
+			rdata = appendUint16(rdata, record.port)
+			rdata = appendName(rdata, record.target)
+		case dnsTypeTXT:
+			keys := make([]string, 0, len(record.text))
+			for key := range record.text {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				pair := key + "=" + record.text[key]
+				if len(pair) > 255 {
+					pair = pair[:255]
+				}
+				rdata = append(rdata, byte(len(pair)))
+				rdata = append(rdata, pair...)
+			}
+			if len(rdata) == 0 {
+				rdata = append(rdata, 0)
+			}
+		}
+		data = appendName(data, record.name)
+		data = appendUint16(data, record.rrType)
+		data = appendUint16(data, record.class)
+		data = append(data, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(data[len(data)-4:], record.ttl)
+		data = appendUint16(data, uint16(len(rdata)))
+		data = append(data, rdata...)
+	}
+	return data
+}
+
+// appendName appends a name encoded as labels
+func appendName(data []byte, name string) []byte {
+	for _, label := range strings.Split(strings.Trim(name, "."), ".") {
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		if label != "" {
+			data = append(data, byte(len(label)))
+			data = append(data, label...)
+		}
+	}
+	return append(data, 0)
+}
+
+// appendUint16 appends a big endian 16 bit value
+func appendUint16(data []byte, value uint16) []byte {
+	return append(data, byte(value>>8), byte(value))
+}
+
+// normalizeName returns a name in lower case without trailing dot for comparison
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// parseDNSMessage decodes a DNS message
+// This returns an error if the message is truncated or malformed.
+func parseDNSMessage(data []byte) (*dnsMessage, error) {
+	if len(data) < 12 {
+		return nil, lib.MakeErrorf("parseDNSMessage: Message too short")
+	}
+	message := &dnsMessage{
+		id:         binary.BigEndian.Uint16(data[0:]),
+		isResponse: data[2]&0x80 != 0,
+		questions:  make([]dnsQuestion, 0),
+		records:    make([]dnsRecord, 0),
+	}
+	questionCount := int(binary.BigEndian.Uint16(data[4:]))
+	recordCount := int(binary.BigEndian.Uint16(data[6:])) + int(binary.BigEndian.Uint16(data[8:])) +
+		int(binary.BigEndian.Uint16(data[10:]))
+	offset := 12
+	for i := 0; i < questionCount; i++ {
+		name, next, err := readName(data, offset)
+		if err != nil || next+4 > len(data) {
+			return nil, lib.MakeErrorf("parseDNSMessage: Invalid question")
+		}
+		message.questions = append(message.questions, dnsQuestion{
+			class: binary.BigEndian.Uint16(data[next+2:]),
+			name:  name,
+			qType: binary.BigEndian.Uint16(data[next:]),
+		})
+		offset = next + 4
+	}
+	for i := 0; i < recordCount; i++ {
+		name, next, err := readName(data, offset)
+		if err != nil || next+10 > len(data) {
+			return nil, lib.MakeErrorf("parseDNSMessage: Invalid record")
+		}
+		record := dnsRecord{
+			class:  binary.BigEndian.Uint16(data[next+2:]),
+			name:   name,
+			rrType: binary.BigEndian.Uint16(data[next:]),
+			ttl:    binary.BigEndian.Uint32(data[next+4:]),
+		}
+		length := int(binary.BigEndian.Uint16(data[next+8:]))
+		start := next + 10
+		if start+length > len(data) {
+			return nil, lib.MakeErrorf("parseDNSMessage: Record data of '%s' is truncated", name)
+		}
+		rdata := data[start : start+length]
+		switch record.rrType {
+		case dnsTypeA, dnsTypeAAAA:
+			record.ip = net.IP(append([]byte{}, rdata...))
+		case dnsTypePTR:
+			record.target, _, err = readName(data, start)
+		case dnsTypeSRV:
+			if length < 7 {
+				return nil, lib.MakeErrorf("parseDNSMessage: Invalid SRV record of '%s'", name)
+			}
+			record.port = binary.BigEndian.Uint16(rdata[4:])
+			record.target, _, err = readName(data, start+6)
+		case dnsTypeTXT:
+			record.text = make(map[string]string)
+			for position := 0; position < len(rdata); position += int(rdata[position]) + 1 {
+				end := position + 1 + int(rdata[position])
+				if end > len(rdata) {
+					break
+				}
+				pair := strings.SplitN(string(rdata[position+1:end]), "=", 2)
+				if pair[0] != "" && len(pair) == 2 {
+					record.text[pair[0]] = pair[1]
+				} else if pair[0] != "" {
+					record.text[pair[0]] = ""
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		message.records = append(message.records, record)
+		offset = start + length
+	}
+	return message, nil
+}
+
+// readName reads a name at an offset, following compression pointers
+// This returns the name and the offset after the name.
+func readName(data []byte, offset int) (string, int, error) {
+	labels := make([]string, 0)
+	next := -1
+	for jumps := 0; jumps < 32; {
+		if offset >= len(data) {
+			return "", 0, lib.MakeErrorf("readName: Name is truncated")
+		}
+		length := int(data[offset])
+		if length == 0 {
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		} else if length&0xc0 == 0xc0 {
+			if offset+1 >= len(data) {
+				return "", 0, lib.MakeErrorf("readName: Name pointer is truncated")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(data[offset:]) & 0x3fff)
+			jumps++
+			continue
+		} else if offset+1+length > len(data) {
+			return "", 0, lib.MakeErrorf("readName: Label is truncated")
+		}
+		labels = append(labels, string(data[offset+1:offset+1+length]))
+		offset += 1 + length
+	}
+	return "", 0, lib.MakeErrorf("readName: Too many name pointers")
+}
@@ -0,0 +1,99 @@
+package zeroconf_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/iotdomain/iotdomain-go/types"
+	"github.com/iotdomain/iotdomain-go/zeroconf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const browseTimeout = 300 * time.Millisecond
+
+// useTestAddress replaces the mDNS group address with a free unicast address on the loopback interface
+func useTestAddress(t *testing.T) {
+	freeConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	zeroconf.MDNSAddress = freeConn.LocalAddr().String()
+	freeConn.Close()
+}
+
+func TestAdvertiseAndBrowse(t *testing.T) {
+	useTestAddress(t)
+	adv := zeroconf.NewAdvertiser("publisher.1", zeroconf.PublisherServiceType, 0, map[string]string{
+		zeroconf.TextDomain:    "test",
+		zeroconf.TextPublisher: "publisher.1",
+	})
+	err := adv.Start()
+	require.NoError(t, err)
+	// starting twice is ignored
+	assert.NoError(t, adv.Start())
+
+	entries, err := zeroconf.Browse(zeroconf.PublisherServiceType, browseTimeout)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "publisher-1", entries[0].Instance)
+	assert.Equal(t, zeroconf.PublisherServiceType, entries[0].Service)
+	assert.Equal(t, "test", entries[0].Text[zeroconf.TextDomain])
+	assert.Equal(t, "publisher.1", entries[0].Text[zeroconf.TextPublisher])
+	assert.NotEmpty(t, entries[0].IPs)
+	assert.NotEmpty(t, entries[0].Address())
+
+	// other service types are not answered
+	entries, err = zeroconf.Browse(zeroconf.BrokerServiceType, browseTimeout)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	broker, err := zeroconf.LocateBroker(browseTimeout)
+	require.NoError(t, err)
+	assert.Nil(t, broker)
+	dss, err := zeroconf.LocateDSS(browseTimeout)
+	require.NoError(t, err)
+	assert.Nil(t, dss)
+
+	// invalid messages are ignored
+	conn, err := net.Dial("udp4", zeroconf.MDNSAddress)
+	require.NoError(t, err)
+	conn.Write([]byte{1, 2, 3})
+	conn.Write([]byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xc0, 12})
+	conn.Close()
+
+	// stopped advertisers no longer answer
+	adv.Stop()
+	adv.Stop()
+	entries, err = zeroconf.Browse(zeroconf.PublisherServiceType, browseTimeout)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestLocateBrokerAndDSS(t *testing.T) {
+	useTestAddress(t)
+	adv := zeroconf.NewAdvertiser("mosquitto", zeroconf.SecureBrokerServiceType, 8883, nil)
+	require.NoError(t, adv.Start())
+	broker, err := zeroconf.LocateBroker(browseTimeout)
+	adv.Stop()
+	require.NoError(t, err)
+	require.NotNil(t, broker)
+	assert.Equal(t, "mosquitto", broker.Instance)
+	assert.Equal(t, 8883, broker.Port)
+	assert.NotEmpty(t, broker.Host)
+
+	adv = zeroconf.NewAdvertiser(types.DSSPublisherID, zeroconf.PublisherServiceType, 0, map[string]string{
+		zeroconf.TextDomain:    "test",
+		zeroconf.TextPublisher: types.DSSPublisherID,
+	})
+	require.NoError(t, adv.Start())
+	defer adv.Stop()
+	dss, err := zeroconf.LocateDSS(browseTimeout)
+	require.NoError(t, err)
+	require.NotNil(t, dss)
+	assert.Equal(t, "test", dss.Text[zeroconf.TextDomain])
+
+	// error case - invalid address
+	zeroconf.MDNSAddress = "not an address"
+	_, err = zeroconf.Browse(zeroconf.PublisherServiceType, browseTimeout)
+	assert.Error(t, err)
+	assert.Error(t, zeroconf.NewAdvertiser("x", zeroconf.PublisherServiceType, 0, nil).Start())
+}